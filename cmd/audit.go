@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+)
+
+var (
+	auditLogFile  string
+	auditSyslog   bool
+	auditRequired bool
+
+	// auditLog records every push, if --audit-log or --audit-syslog is set
+	auditLog *audit.Log
+)
+
+// openAudit opens the audit log selected by the audit flags
+func openAudit() error {
+	if auditLogFile == "" && !auditSyslog {
+		if auditRequired {
+			return fmt.Errorf("--audit-required needs --audit-log or --audit-syslog")
+		}
+		return nil
+	}
+	var err error
+	auditLog, err = audit.Open(audit.Options{
+		Path:     auditLogFile,
+		Syslog:   auditSyslog,
+		Required: auditRequired,
+		Config:   configFile,
+	})
+	return err
+}
+
+// closeAudit closes the audit log
+func closeAudit() {
+	if auditLog == nil {
+		return
+	}
+	if err := auditLog.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close audit log: %v\n", err)
+	}
+	auditLog = nil
+}
+
+// recordPush records a push made outside the docker client in the audit
+// log. It only returns an error when the audit log is required.
+func recordPush(entry audit.Entry) error {
+	if auditLog == nil {
+		return nil
+	}
+	err := auditLog.Record(entry)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("failed to record the push of %s in the audit log: %w", entry.Target, err)
+	if auditLog.Required() {
+		return err
+	}
+	warnf("%v", err)
+	return nil
+}
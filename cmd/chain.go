@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+)
+
+// chain follows the tasks taking their source from the target of another
+// task with source_from: it records the digest every task of a chain left
+// its target at, for the next hop to copy, and the lineage of the last
+// hop in the report
+type chain struct {
+	// hops holds the hop each task of a chain made, by task name
+	hops map[string]report.Hop
+	// sourceFrom holds the task each chained task takes its source from,
+	// and feeds the tasks others take their source from
+	sourceFrom map[string]string
+	feeds      map[string]bool
+}
+
+// newChain returns the chains of tasks
+func newChain(tasks []config.ImageTask) *chain {
+	c := &chain{hops: make(map[string]report.Hop), sourceFrom: make(map[string]string), feeds: make(map[string]bool)}
+	for _, task := range tasks {
+		if task.SourceFrom != "" {
+			c.sourceFrom[task.Name] = task.SourceFrom
+			c.feeds[task.SourceFrom] = true
+		}
+	}
+	return c
+}
+
+// source returns the digest a chained task copies, the one the task it
+// takes its source from left its target at
+func (c *chain) source(task config.ImageTask) (string, error) {
+	hop, ok := c.hops[task.SourceFrom]
+	if !ok || hop.TargetDigest == "" {
+		return "", fmt.Errorf("task %s left no known digest at %s to take the source from", task.SourceFrom, task.Source)
+	}
+	return hop.TargetDigest, nil
+}
+
+// record records the hop of a completed task of a chain, which copied
+// digest. Its target digest is the one the task pushed, or the one the
+// target resolves to when it pushed nothing, such as when it was up to
+// date. The lineage of the last task of a chain is added to rep.
+func (c *chain) record(client *docker.Client, rep *report.Report, task config.ImageTask, digest string, result *docker.TaskResult) {
+	if task.Name == "" || (!c.feeds[task.Name] && c.sourceFrom[task.Name] == "") {
+		return
+	}
+	hop := report.Hop{Task: task.Name, Source: task.Source, SourceDigest: digest, Target: task.Target}
+	if result != nil {
+		hop.TargetDigest = result.PushedDigest(task.Target)
+	}
+	if hop.TargetDigest == "" {
+		resolved, err := client.ResolveDigest(task.Target)
+		if err != nil {
+			warnf("Failed to resolve the digest task %s left at %s: %v", task.Name, task.Target, err)
+		}
+		hop.TargetDigest = resolved
+	}
+	c.hops[task.Name] = hop
+
+	if c.feeds[task.Name] {
+		return
+	}
+	lineage := report.Lineage{Task: task.Name, Hops: []report.Hop{hop}}
+	for name := c.sourceFrom[task.Name]; name != ""; name = c.sourceFrom[name] {
+		lineage.Hops = append([]report.Hop{c.hops[name]}, lineage.Hops...)
+	}
+	rep.AddLineage(lineage)
+}
@@ -0,0 +1,510 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/preflight"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+var checkOutput string
+
+// checkCmd validates the environment, credentials and sources of a config
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that a configuration can run before starting it",
+	Long: `Run a fast preflight pass over a configuration file:
+
+  - the docker daemon is reachable and its API is recent enough
+  - docker manifest (required) and buildx (optional) are available
+  - target registries are reachable and accept the configured credentials
+  - the projects of the targets exist on a registry with a type, such as Harbor
+  - every source manifest resolves with the credentials a pull would use
+  - output directories are writable and have room for the estimated image size
+
+The command exits non-zero when a blocking check fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if checkOutput != "text" && checkOutput != "json" {
+			return fmt.Errorf("unsupported output format %q, use text or json", checkOutput)
+		}
+		if configFile == "" {
+			return fmt.Errorf("config file path is required")
+		}
+
+		list := runChecks()
+
+		out := cmd.OutOrStdout()
+		if checkOutput == "json" {
+			data, err := json.MarshalIndent(struct {
+				OK bool `json:"ok"`
+				*preflight.Checklist
+			}{list.OK(), list}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, string(data))
+		} else {
+			list.Print(out)
+		}
+
+		return checkStatus(list)
+	},
+}
+
+// runChecks runs every preflight check for the configuration file
+func runChecks() *preflight.Checklist {
+	list := &preflight.Checklist{}
+
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		list.Fail("configuration", err)
+		return list
+	}
+	useConfigRegistries(cfg)
+	list.Pass("configuration", fmt.Sprintf("%d tasks in %s", len(cfg.ImageTask), configFile))
+
+	validErr := cfg.Validate()
+	if validErr != nil {
+		list.Fail("validation", validErr)
+	} else if unused := cfg.UnusedAliases(); len(unused) > 0 {
+		list.Warn("aliases", fmt.Errorf("not used by any task: %s", strings.Join(unused, ", ")))
+	}
+	if cfg.OutputRoot != "" || outputRoot != "" {
+		if err := applyOutputRoot(cfg); err != nil {
+			list.Fail("output root", err)
+		} else {
+			list.Pass("output root", fmt.Sprintf("task directories below %s", cfg.OutputRoot))
+		}
+	}
+	// Tasks are only identical, push the same references or are judged by
+	// the policies once the output root is applied and the targets are
+	// normalized
+	if validErr == nil {
+		if err := normalizeTargets(cfg.ImageTask); err != nil {
+			list.Fail("targets", err)
+		} else {
+			var merged []string
+			cfg.ImageTask, merged = config.MergeDuplicates(cfg.ImageTask)
+			if len(merged) > 0 {
+				list.Warn("duplicate tasks", errors.New(strings.Join(merged, "; ")))
+			}
+			if collisions := config.TargetCollisions(cfg.ImageTask); len(collisions) > 0 {
+				list.Fail("duplicate targets", errors.New(strings.Join(collisions, "; ")))
+			} else {
+				list.Pass("duplicate targets", "")
+			}
+			if err := checkPolicy(cfg, cfg.ImageTask); err != nil {
+				list.Fail("registry policy", err)
+			} else {
+				list.Pass("registry policy", "")
+			}
+			if tasks, order, err := executionOrder(cfg); err == nil && cfg.Ordered() {
+				list.Pass("execution order", strings.Join(executionPlan(tasks, order), ", "))
+			}
+		}
+	}
+
+	client, err := docker.NewClient(append(dockerOptions(), docker.WithLogger(logger), docker.WithRegistryTLS(registryTLS),
+		docker.WithRegistryProxy(registryProxy), docker.WithSourceAuth(sourceAuth(cfg)))...)
+	if err != nil {
+		list.Fail("docker CLI", err)
+		return list
+	}
+
+	info, err := client.CheckDaemon()
+	if err != nil {
+		list.Fail("docker daemon", err)
+		return list
+	}
+	list.Pass("docker daemon", fmt.Sprintf("daemon %s, API %s, CLI %s", info.ServerVersion, info.APIVersion, info.CLIVersion))
+
+	if err := client.CheckCommand("manifest", "inspect"); err != nil {
+		list.Fail("docker manifest", err)
+	} else {
+		list.Pass("docker manifest", "")
+	}
+	if err := client.CheckCommand("buildx", "version"); err != nil {
+		list.Warn("docker buildx", err)
+	} else {
+		list.Pass("docker buildx", "")
+	}
+	if disk, err := cfg.DaemonDisk(); err == nil && disk.Max > 0 {
+		checkDaemonDisk(list, client, disk.Max)
+	}
+
+	auth, err := configAuth(cfg.Registry)
+	if err != nil {
+		list.Fail("registry credentials", err)
+		return list
+	}
+
+	checkRegistries(list, client, cfg, auth)
+	checkProjects(list, cfg)
+	checkProxies(list, client, cfg)
+	resolved := checkSources(list, client, cfg, auth)
+	checkOutputDirs(list, client, cfg, resolved)
+
+	return list
+}
+
+// checkDaemonDisk reports the disk usage of the daemon against the
+// max_daemon_disk limit, above which the run waits before pulling
+func checkDaemonDisk(list *preflight.Checklist, client *docker.Client, max int64) {
+	usage, err := client.DaemonDiskUsage(context.Background())
+	switch {
+	case err != nil:
+		list.Warn("daemon disk", err)
+	case usage > max:
+		list.Warn("daemon disk", fmt.Errorf("%s used, above max_daemon_disk %s: pulls wait until it drops",
+			progress.FormatBytes(usage), progress.FormatBytes(max)))
+	default:
+		list.Pass("daemon disk", fmt.Sprintf("%s used of max_daemon_disk %s", progress.FormatBytes(usage), progress.FormatBytes(max)))
+	}
+}
+
+// checkProxies reports the proxy the tool's registry API calls use for
+// every registry the configuration refers to, and the proxy of the daemon,
+// which handles pulls and pushes
+func checkProxies(list *preflight.Checklist, client *docker.Client, cfg *config.Config) {
+	var hosts []string
+	seen := make(map[string]bool)
+	addImage := func(image string) {
+		if named, err := reference.ParseNormalizedNamed(image); err == nil && !seen[reference.Domain(named)] {
+			seen[reference.Domain(named)] = true
+			hosts = append(hosts, reference.Domain(named))
+		}
+	}
+	if cfg.Registry != nil && cfg.Registry.URL != "" {
+		host := registry.NormalizeHost(cfg.Registry.URL)
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	for _, task := range cfg.ImageTask {
+		if len(task.Archives) == 0 && !task.ReadsLayout() {
+			addImage(task.Source)
+		}
+		if task.Target != "" {
+			addImage(task.Target)
+		}
+	}
+	for _, host := range slices.Sorted(maps.Keys(cfg.Mirrors)) {
+		for _, mirror := range cfg.Mirrors[host] {
+			if !seen[mirror] {
+				seen[mirror] = true
+				hosts = append(hosts, mirror)
+			}
+		}
+	}
+
+	for _, host := range hosts {
+		name := "proxy " + host
+		setting := registryProxy(host)
+		proxy, err := registry.ProxyFor(host, setting)
+		switch {
+		case err != nil:
+			list.Fail(name, err)
+		case proxy != nil && setting != "":
+			list.Pass(name, "via "+proxy.Redacted()+" (configuration)")
+		case proxy != nil:
+			list.Pass(name, "via "+proxy.Redacted()+" (environment)")
+		case setting == registry.ProxyDirect:
+			list.Pass(name, "direct (configuration)")
+		default:
+			list.Pass(name, "direct")
+		}
+	}
+
+	daemon, err := client.DaemonProxy()
+	if err != nil {
+		list.Warn("daemon proxy", err)
+		return
+	}
+	if daemon == (docker.DaemonProxy{}) {
+		list.Pass("daemon proxy", "none, pulls and pushes connect directly")
+		return
+	}
+	detail := fmt.Sprintf("HTTPS %s, HTTP %s", orNone(daemon.HTTPS), orNone(daemon.HTTP))
+	if daemon.NoProxy != "" {
+		detail += ", no proxy for " + daemon.NoProxy
+	}
+	list.Pass("daemon proxy", detail)
+}
+
+// orNone returns value, or "none" when it is empty
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// checkRegistries checks every target registry once
+func checkRegistries(list *preflight.Checklist, client *docker.Client, cfg *config.Config, auth docker.RegistryAuth) {
+	var hosts []string
+	seen := make(map[string]bool)
+	addHost := func(host string) {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	if cfg.Registry != nil && cfg.Registry.URL != "" {
+		addHost(registry.NormalizeHost(cfg.Registry.URL))
+	}
+	for _, task := range cfg.ImageTask {
+		if task.Target == "" {
+			continue
+		}
+		if named, err := reference.ParseNormalizedNamed(task.Target); err == nil {
+			addHost(reference.Domain(named))
+		}
+	}
+
+	for _, host := range hosts {
+		name := "registry " + host
+		authenticated, err := client.CheckRegistry(host, auth)
+		switch {
+		case err != nil:
+			list.Fail(name, err)
+		case authenticated:
+			list.Pass(name, "reachable, credentials accepted")
+		default:
+			list.Pass(name, "reachable, no credentials configured")
+		}
+	}
+}
+
+// checkProjects checks that the projects of the targets exist on a registry
+// with a type. Missing projects pass when ensure_project creates them.
+func checkProjects(list *preflight.Checklist, cfg *config.Config) {
+	provider, err := registryProvider(cfg.Registry)
+	if err != nil {
+		list.Fail("registry "+cfg.Registry.URL+" API", err)
+		return
+	}
+	if provider == nil {
+		return
+	}
+	for _, project := range targetProjects(cfg) {
+		name := "project " + project
+		exists, err := provider.ProjectExists(context.Background(), project)
+		switch {
+		case err != nil:
+			list.Fail(name, err)
+		case exists:
+			list.Pass(name, "exists")
+		case cfg.Registry.EnsureProject:
+			list.Pass(name, "missing, created when the run starts")
+		default:
+			list.Fail(name, &errdefs.NotFoundError{Err: fmt.Errorf("project %s doesn't exist on %s and ensure_project is not set", project, cfg.Registry.URL)})
+		}
+	}
+}
+
+// checkSources resolves the manifest of every source image once and returns
+// the sources that resolved
+func checkSources(list *preflight.Checklist, client *docker.Client, cfg *config.Config, auth docker.RegistryAuth) map[string]bool {
+	resolved := make(map[string]bool)
+	checked := make(map[string]bool)
+	for _, task := range cfg.ImageTask {
+		if len(task.Archives) > 0 {
+			checkArchives(list, task)
+			continue
+		}
+		if task.ReadsLayout() {
+			checkLayout(list, task)
+			continue
+		}
+		source := imageref.Key(task.Source)
+		if checked[source] {
+			continue
+		}
+		checked[source] = true
+
+		name := "source " + task.Source
+		if task.LocalOnly {
+			platforms, err := client.LocalPlatforms(task.Source)
+			if err != nil {
+				list.Fail(name, err)
+				continue
+			}
+			list.Pass(name, "local: "+docker.FormatPlatforms(platforms))
+			continue
+		}
+		digest, err := client.CheckSource(task.Source, auth)
+		if err != nil {
+			list.Fail(name, err)
+			continue
+		}
+		resolved[source] = true
+		list.Pass(name, digest)
+	}
+	return resolved
+}
+
+// checkArchives checks that the archives of a task can be read and lists
+// the platforms they hold
+func checkArchives(list *preflight.Checklist, task config.ImageTask) {
+	name := "archives of " + task.Source
+	var platforms []string
+	for _, path := range task.Archives {
+		description, err := archive.Describe(path)
+		if err != nil {
+			list.Fail(name, fmt.Errorf("%s: %v", path, err))
+			return
+		}
+		for _, platform := range description.Platforms {
+			platforms = append(platforms, platform.String())
+		}
+	}
+	list.Pass(name, fmt.Sprintf("%d archives: %s", len(task.Archives), strings.Join(platforms, ", ")))
+}
+
+// checkLayout checks that the dir: or oci: layout a task reads is complete
+func checkLayout(list *preflight.Checklist, task config.ImageTask) {
+	name := "source " + task.Source
+	source, err := archive.ParseSource(task.Source)
+	if err == nil {
+		err = source.Check()
+	}
+	if err != nil {
+		list.Fail(name, err)
+		return
+	}
+	list.Pass(name, "complete")
+}
+
+// checkOutputDirs checks that the output directory of every task that saves
+// images is writable and has room for the estimated size of its images
+func checkOutputDirs(list *preflight.Checklist, client *docker.Client, cfg *config.Config, resolved map[string]bool) {
+	var dirs []string
+	needed := make(map[string]int64)
+	estimated := make(map[string]bool)
+	for _, task := range cfg.ImageTask {
+		// Tasks with a target are only pushed, see processTask
+		if task.Target != "" || !task.Save {
+			continue
+		}
+
+		dir := task.OutputDir
+		if dir == "" {
+			dir = "."
+		}
+		if _, ok := needed[dir]; !ok {
+			dirs = append(dirs, dir)
+			needed[dir] = 0
+			estimated[dir] = true
+		}
+
+		if !resolved[imageref.Key(task.Source)] {
+			estimated[dir] = false
+			continue
+		}
+
+		var archs []string
+		if !task.AllArchitecture {
+			archs = task.Architectures
+		}
+		operatingSystems := task.OperatingSystems
+		if len(operatingSystems) == 0 {
+			operatingSystems = []string{"linux"}
+		}
+
+		size, err := client.EstimateSize(task.Source, operatingSystems, archs)
+		if err != nil {
+			estimated[dir] = false
+			continue
+		}
+		needed[dir] += size
+	}
+
+	for _, dir := range dirs {
+		name, local := "output directory "+dir, dir
+		// Archives for a URL are staged below --tmpdir before the upload
+		if httpfile.IsURL(dir) {
+			name, local = "output directory "+httpfile.Redact(dir), valueOr(tmpDir, os.TempDir())
+		}
+		if err := checkWritable(local); err != nil {
+			list.Fail(name, err)
+			continue
+		}
+
+		free, err := preflight.FreeSpace(local)
+		if err != nil {
+			list.Warn(name, fmt.Errorf("writable, but free space is unknown: %v", err))
+			continue
+		}
+
+		detail := fmt.Sprintf("writable, %s free", report.FormatBytes(int64(free)))
+		if !estimated[dir] {
+			list.Warn(name, fmt.Errorf("%s, size of some images could not be estimated", detail))
+			continue
+		}
+		if uint64(needed[dir]) > free {
+			list.Fail(name, fmt.Errorf("%s, at least %s needed", detail, report.FormatBytes(needed[dir])))
+			continue
+		}
+		list.Pass(name, fmt.Sprintf("%s, at least %s needed", detail, report.FormatBytes(needed[dir])))
+	}
+}
+
+// checkWritable creates dir if needed and verifies a file can be created in it
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".imgmigrate-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %v", err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkStatus returns an exitError when a blocking check failed. Credential
+// and missing image failures get their own exit codes, anything else is
+// treated as a configuration problem.
+func checkStatus(list *preflight.Checklist) error {
+	errs := list.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+
+	code := ExitUsage
+	switch {
+	case anyIs(errs, errdefs.ErrAuthentication), anyIs(errs, errdefs.ErrAuthorization):
+		code = ExitAuth
+	case anyIs(errs, errdefs.ErrNotFound):
+		code = ExitNotFound
+	}
+
+	return &exitError{code: code, err: fmt.Errorf("%d blocking checks failed", len(errs))}
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().StringVarP(&configFile, "file", "f", "", "Path or http(s) URL of the YAML or JSON configuration file")
+	checkCmd.Flags().StringVar(&policyFile, "policy-file", "", "Path to a central registry policy file")
+	addOutputRootFlags(checkCmd)
+	checkCmd.Flags().StringVarP(&checkOutput, "output", "o", "text", "Output format: text or json")
+}
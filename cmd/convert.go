@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/spf13/cobra"
+)
+
+var (
+	convertInput  string
+	convertOutput string
+	convertFormat string
+)
+
+// convertCmd converts saved archives between formats
+var convertCmd = &cobra.Command{
+	Use:   "convert --input <archive> --output <archive>",
+	Short: "Convert a saved image archive to another format",
+	Long: `Convert an image archive between docker save tarballs (tar, tar.gz, tar.zst),
+OCI image layout directories and OCI archives, without a docker daemon. The
+input may also be a dir:/path or oci:/path[:ref] layout skopeo copy wrote.
+
+The input format is detected from its content. The output format is taken
+from --format, or from the output name: .tar, .tar.gz, .tgz and .tar.zst
+are docker archives, .oci.tar, .oci.tar.gz and .oci.tar.zst OCI archives,
+and directories OCI image layouts. Image names are kept: the RepoTags of a
+docker archive become the name annotations of an OCI index and back.
+
+Changing only the compression streams the archive; changing the layout
+unpacks it to a temporary directory first. A docker archive holds one
+platform per image name, so converting a multi-platform OCI index to one
+fails. zstd needs the zstd command.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if convertInput == "" || convertOutput == "" {
+			return fmt.Errorf("both --input and --output are required")
+		}
+
+		var format archive.Format
+		var err error
+		if convertFormat != "" {
+			format, err = archive.ParseFormat(convertFormat)
+		} else {
+			format, err = archive.FormatForPath(convertOutput)
+		}
+		if err != nil {
+			return err
+		}
+
+		from, err := archive.Convert(convertInput, convertOutput, format)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %w", convertInput, err)
+		}
+		infof("Converted %s (%s) to %s (%s)", convertInput, from, convertOutput, format)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+
+	convertCmd.Flags().StringVarP(&convertInput, "input", "i", "", "Archive, OCI layout directory, or dir: or oci: layout to convert")
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "Path of the converted archive or OCI layout directory")
+	convertCmd.Flags().StringVar(&convertFormat, "format", "", "Output format: "+archive.FormatNames+" (default: from the output name)")
+}
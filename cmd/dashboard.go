@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/tui"
+)
+
+var (
+	useTUI bool
+
+	// dashboard shows the run full screen with --tui; nil otherwise
+	dashboard *tui.Dashboard
+)
+
+// startDashboard shows the dashboard in place of the console output when
+// stdin and stdout are terminals that can draw it, and reports whether it
+// did
+func startDashboard() bool {
+	if !progress.SupportsANSI(os.Stdout) || !progress.IsTerminal(os.Stdin) {
+		return false
+	}
+	d, err := tui.Start(os.Stdin, os.Stdout, runID)
+	if err != nil {
+		return false
+	}
+	d.SetEstimator(estimator)
+	dashboard = d
+	stdout = d
+	return true
+}
+
+// stopDashboard restores the terminal, printing the log of the run
+func stopDashboard() {
+	if dashboard == nil {
+		return
+	}
+	dashboard.Stop()
+	dashboard = nil
+	stdout = os.Stdout
+}
+
+// attachDashboard lists the tasks of a run on the dashboard and returns a
+// context of ctx that aborting from the dashboard cancels
+func attachDashboard(ctx context.Context, tasks []config.ImageTask) (context.Context, context.CancelFunc) {
+	if dashboard == nil {
+		return ctx, func() {}
+	}
+	labels := make([]string, len(tasks))
+	for i, task := range tasks {
+		labels[i] = task.Label(i)
+	}
+	dashboard.SetTasks(labels)
+	return dashboard.Attach(ctx)
+}
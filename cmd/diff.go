@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/imagediff"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd compares two images through the registry API
+var diffCmd = &cobra.Command{
+	Use:   "diff <image> <image>",
+	Short: "Compare two images, such as a source and its mirrored copy",
+	Long: `Compare two image references through the registry API: the platforms they
+cover and, for every platform both cover, the manifest digest, the layer
+digests and the image config (environment, labels, entrypoint, command,
+working directory and user). A single image manifest is compared with the
+entry of the same platform of a manifest list. Attestation manifests are
+ignored.
+
+The first image is read with --source-username and --source-password, the
+second with --username and --password. Without them, the source_auth or
+registry section of the --file configuration or docker login is used.
+
+Exits with 0 when the images match and 6 when they differ.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := registryConfig()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		left, err := loadImage(ctx, cfg, args[0], docker.RegistryAuth{Username: sourceUsername, Password: redact.Secret(sourcePassword), Insecure: sourceInsecure})
+		if err != nil {
+			return err
+		}
+		right, err := loadImage(ctx, cfg, args[1], docker.RegistryAuth{Username: username, Password: redact.Secret(password), Insecure: insecure})
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "--- %s (%s)\n", args[0], describeImage(left))
+		fmt.Fprintf(out, "+++ %s (%s)\n", args[1], describeImage(right))
+
+		diffs := imagediff.Compare(left, right)
+		if len(diffs) == 0 {
+			fmt.Fprintln(out, "Images are identical")
+			return nil
+		}
+		printDifferences(out, diffs)
+		return &exitError{code: ExitDifferent, err: fmt.Errorf("images differ in %d places", len(diffs))}
+	},
+}
+
+// loadImage fetches an image and its image configs with the credentials
+// imageAuth picks for its registry
+func loadImage(ctx context.Context, cfg *config.Config, image string, flags docker.RegistryAuth) (*imagediff.Image, error) {
+	host, repo, ref, err := splitReference(image)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := imageAuth(cfg, host, flags)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newRegistryClient(host, auth)
+	if err != nil {
+		return nil, err
+	}
+	return imagediff.Load(ctx, client, repo, ref)
+}
+
+// describeImage summarizes the kind and digest of an image
+func describeImage(image *imagediff.Image) string {
+	if image.IsList() {
+		return fmt.Sprintf("manifest list of %d platforms, %s", len(image.Configs), image.Digest)
+	}
+	return fmt.Sprintf("image manifest, %s", image.Digest)
+}
+
+// printDifferences prints each difference with the values only in the
+// first image prefixed with - and those only in the second with +
+func printDifferences(out io.Writer, diffs []imagediff.Difference) {
+	for _, diff := range diffs {
+		fmt.Fprintln(out)
+		if diff.Platform == "" {
+			fmt.Fprintf(out, "%s:\n", diff.Field)
+		} else {
+			fmt.Fprintf(out, "%s %s:\n", diff.Platform, diff.Field)
+		}
+		for _, value := range diff.Left {
+			fmt.Fprintf(out, "  - %s\n", valueOr(value, "(empty)"))
+		}
+		for _, value := range diff.Right {
+			fmt.Fprintf(out, "  + %s\n", valueOr(value, "(empty)"))
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	addSourceAuthFlags(diffCmd, "reading the first image from")
+	diffCmd.Flags().StringVarP(&username, "username", "u", "", "Username for reading the second image from its registry")
+	diffCmd.Flags().StringVarP(&password, "password", "p", "", "Password for reading the second image from its registry")
+	diffCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure connections to the registry of the second image")
+	diffCmd.Flags().StringVarP(&configFile, "file", "f", "", "Configuration file providing registry credentials")
+}
@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/dockerfile"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	dfFiles         []string
+	dfBuildArgs     []string
+	dfMappingFile   string
+	dfMappingFormat string
+	dfDryRun        bool
+	dfGenerate      string
+)
+
+// Formats of the --mapping-file
+const (
+	mappingTSV = "tsv"
+	mappingSed = "sed"
+)
+
+// dockerfileImage is a base image of the Dockerfiles to mirror
+type dockerfileImage struct {
+	source string
+	// references are the spellings of the image in the Dockerfiles, with
+	// build arguments substituted, which all map to its mirror
+	references []string
+	// digest is the digest the Dockerfiles pin the image to, if any
+	digest string
+	// platforms are those FROM instructions select with --platform
+	platforms []string
+	// uses are the file:line of the FROM instructions naming the image
+	uses []string
+}
+
+// fromDockerfileCmd mirrors the base images of Dockerfiles
+var fromDockerfileCmd = &cobra.Command{
+	Use:   "from-dockerfile",
+	Short: "Mirror the base images the FROM instructions of Dockerfiles name",
+	Long: `Read the FROM instructions of the Dockerfiles --file matches, with ** matching
+any number of directories, and mirror every external base image once below
+--target-prefix. Stages of the same Dockerfile and scratch are left out, build
+arguments are substituted from the ARG instructions before the first FROM and
+--build-arg, and the platform a FROM selects with --platform is mirrored in
+addition to --arch and --os.
+
+The repository path and tag of an image are kept below the prefix, so with
+--target-prefix harbor.internal/mirror the image golang:1.22 is pushed to
+harbor.internal/mirror/library/golang:1.22. --mapping-file writes each
+reference as the Dockerfiles write it with its mirror, as tab-separated lines
+or as a script for sed -E -i -f to rewrite the Dockerfiles with.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(dfFiles) == 0 {
+			return fmt.Errorf("at least one Dockerfile is required")
+		}
+		if targetPrefix == "" {
+			return fmt.Errorf("--target-prefix is required")
+		}
+		if err := loadAliases(); err != nil {
+			return err
+		}
+		if !allArch && len(architectures) == 0 {
+			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+		}
+		if err := checkSourceOrder(nil); err != nil {
+			return err
+		}
+		switch dfMappingFormat {
+		case mappingTSV, mappingSed:
+		default:
+			return fmt.Errorf("unknown --mapping-format %q, supported: %s, %s", dfMappingFormat, mappingTSV, mappingSed)
+		}
+		for _, pattern := range genExclude {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid --exclude pattern %q: %v", pattern, err)
+			}
+		}
+		buildArgs, err := parseBuildArgs(dfBuildArgs)
+		if err != nil {
+			return err
+		}
+
+		files, err := dockerfiles(dfFiles)
+		if err != nil {
+			return err
+		}
+		images, err := dockerfileImages(files, buildArgs)
+		if err != nil {
+			return err
+		}
+		tasks, err := dockerfileTasks(images)
+		if err != nil {
+			return err
+		}
+		// One task per source, so none is merged and the indexes still match
+		if tasks, err = checkTargets(tasks); err != nil {
+			return err
+		}
+		for i, image := range images {
+			infof("  %s -> %s (%s)", image.source, tasks[i].Target, strings.Join(image.uses, ", "))
+		}
+		infof("%d base images found in %d Dockerfiles for mirroring to %s", len(images), len(files), targetPrefix)
+
+		if dfMappingFile != "" {
+			if err := writeMapping(dfMappingFile, images, tasks); err != nil {
+				return err
+			}
+			infof("Mapping of %d images written to %s", len(images), dfMappingFile)
+		}
+		if dfGenerate != "" {
+			data, err := yaml.Marshal(config.Config{ImageTask: tasks})
+			if err != nil {
+				return fmt.Errorf("error marshaling config: %v", err)
+			}
+			if err := os.WriteFile(dfGenerate, data, 0644); err != nil {
+				return fmt.Errorf("error writing config file: %v", err)
+			}
+			infof("Configuration with %d tasks written to %s", len(tasks), dfGenerate)
+			return nil
+		}
+		if dfDryRun || len(tasks) == 0 {
+			return nil
+		}
+
+		if err := checkPolicy(nil, tasks); err != nil {
+			return err
+		}
+
+		rep := report.New()
+		limit, err := daemonDiskLimit(nil)
+		if err != nil {
+			return err
+		}
+		client, err := newClient(rep, docker.WithDiskLimit(limit))
+		if err != nil {
+			return err
+		}
+		defer removeIntermediate(client)
+		defer logoutRegistries(client)
+
+		auth := docker.RegistryAuth{
+			Username: username,
+			Password: redact.Secret(password),
+			Token:    redact.Secret(registryToken),
+			URL:      registryURL,
+			Insecure: insecure,
+		}
+
+		ctx, stop := interruptible()
+		defer stop()
+		runTasks(ctx, client, &config.Config{ImageTask: tasks}, auth, nil, rep)
+		return runStatus(rep, finishReport(rep, nil))
+	},
+}
+
+// parseBuildArgs parses --build-arg values, NAME=value or NAME to take the
+// value from the environment as docker build does
+func parseBuildArgs(values []string) (map[string]string, error) {
+	args := make(map[string]string, len(values))
+	for _, value := range values {
+		name, v, ok := strings.Cut(value, "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid --build-arg %q, use NAME=value", value)
+		}
+		if !ok {
+			if v, ok = os.LookupEnv(name); !ok {
+				continue
+			}
+		}
+		args[name] = v
+	}
+	return args, nil
+}
+
+// dockerfiles returns the files the patterns match, each once
+func dockerfiles(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := dockerfile.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Dockerfile pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no Dockerfile matches %s", pattern)
+		}
+		for _, match := range matches {
+			if !slices.Contains(files, match) {
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// dockerfileImages returns the base images of the Dockerfiles to mirror,
+// one per source after dropping digests, without excluded images and those
+// already below --target-prefix. The errors of all Dockerfiles are reported
+// together.
+func dockerfileImages(files []string, buildArgs map[string]string) ([]*dockerfileImage, error) {
+	var images []*dockerfileImage
+	bySource := make(map[string]*dockerfileImage)
+	var errs []string
+	for _, file := range files {
+		bases, err := dockerfile.ParseFile(file, buildArgs)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, base := range bases {
+			use := fmt.Sprintf("%s:%d", file, base.Line)
+			ref := resolveAlias(base.Reference)
+			named, err := imageref.Parse(ref)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid image %s: %v", use, base.Reference, err))
+				continue
+			}
+			if excludedImage(named) {
+				infof("Excluding %s used in %s", base.Reference, use)
+				continue
+			}
+
+			// Tasks pull by tag, so a pinned digest is only recorded
+			source, digest, _ := strings.Cut(ref, "@")
+			if _, tagged := named.(reference.Tagged); !tagged && digest != "" {
+				warnf("Skipping %s used in %s: it has no tag to mirror", base.Reference, use)
+				continue
+			}
+
+			key := imageref.Key(source)
+			image, ok := bySource[key]
+			if !ok {
+				image = &dockerfileImage{source: source, digest: digest}
+				bySource[key] = image
+				images = append(images, image)
+			} else if digest != "" && image.digest != "" && digest != image.digest {
+				warnf("%s is pinned to %s in %s and to %s before, mirroring the tag", source, digest, use, image.digest)
+			}
+			if !slices.Contains(image.references, base.Reference) {
+				image.references = append(image.references, base.Reference)
+			}
+			if base.Platform != "" && !slices.Contains(image.platforms, base.Platform) {
+				image.platforms = append(image.platforms, base.Platform)
+			}
+			image.uses = append(image.uses, use)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to read the Dockerfiles:\n%s", strings.Join(errs, "\n"))
+	}
+	return images, nil
+}
+
+// dockerfileTasks returns a task mirroring every image below
+// --target-prefix, for the platforms of the flags and those its FROM
+// instructions select
+func dockerfileTasks(images []*dockerfileImage) ([]config.ImageTask, error) {
+	tasks := make([]config.ImageTask, 0, len(images))
+	for _, image := range images {
+		target, err := prefixedTarget(targetPrefix, image.source)
+		if err != nil {
+			return nil, err
+		}
+		task := config.ImageTask{
+			Source:           image.source,
+			Target:           target,
+			OperatingSystems: slices.Clone(operatingSystems),
+			CreateMultiArch:  createMultiArch,
+			Verbatim:         verbatim,
+		}
+		if allArch {
+			task.AllArchitecture = true
+		} else {
+			task.Architectures = slices.Clone(architectures)
+		}
+		for _, platform := range image.platforms {
+			platformOS, arch, ok := strings.Cut(platform, "/")
+			if !ok {
+				warnf("Ignoring the platform %s of %s, which is not os/arch[/variant]", platform, image.source)
+				continue
+			}
+			if len(task.OperatingSystems) > 0 && !slices.Contains(task.OperatingSystems, platformOS) {
+				task.OperatingSystems = append(task.OperatingSystems, platformOS)
+			}
+			if !allArch && !slices.Contains(task.Architectures, arch) {
+				task.Architectures = append(task.Architectures, arch)
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// writeMapping writes every reference of the images with the target of its
+// task, keeping pinned digests when --verbatim copies keep them
+func writeMapping(file string, images []*dockerfileImage, tasks []config.ImageTask) error {
+	var b strings.Builder
+	for i, image := range images {
+		mirror := tasks[i].Target
+		if image.digest != "" && verbatim {
+			mirror += "@" + image.digest
+		} else if image.digest != "" {
+			warnf("The mirror of %s gets a digest of its own, so its mapping drops the pinned digest; use --all-arch --verbatim to keep it", image.source)
+		}
+		for _, ref := range image.references {
+			switch dfMappingFormat {
+			case mappingSed:
+				// Only whole references of FROM instructions are replaced,
+				// not those a longer reference starts with
+				fmt.Fprintf(&b, "/^[[:space:]]*[Ff][Rr][Oo][Mm][[:space:]]/s#([[:space:]])%s([[:space:]]|$)#\\1%s\\2#\n", regexp.QuoteMeta(ref), mirror)
+			default:
+				fmt.Fprintf(&b, "%s\t%s\n", ref, mirror)
+			}
+		}
+	}
+	if err := os.WriteFile(file, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("error writing mapping file: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(fromDockerfileCmd)
+
+	fromDockerfileCmd.Flags().StringArrayVarP(&dfFiles, "file", "f", nil, "Dockerfile to read, or a glob where ** matches any number of directories (repeatable)")
+	fromDockerfileCmd.Flags().StringArrayVar(&dfBuildArgs, "build-arg", nil, "Build argument NAME=value substituted in FROM instructions, or NAME to take it from the environment (repeatable)")
+	fromDockerfileCmd.Flags().StringVar(&targetPrefix, "target-prefix", "", "Registry and namespace to push every image below, keeping its repository path and tag (required)")
+	fromDockerfileCmd.Flags().StringArrayVar(&genExclude, "exclude", nil, "Skip images whose repository, or a path above it, matches this glob, e.g. harbor.internal or quay.io/org/* (repeatable)")
+	fromDockerfileCmd.Flags().StringVar(&dfMappingFile, "mapping-file", "", "Write every reference the Dockerfiles use with the name of its mirror to this file")
+	fromDockerfileCmd.Flags().StringVar(&dfMappingFormat, "mapping-format", mappingTSV, "Format of the --mapping-file: tsv lines of reference and mirror, or sed for a script to run with sed -E -i -f")
+	fromDockerfileCmd.Flags().BoolVar(&dfDryRun, "dry-run", false, "Only print the base images that would be mirrored, and write the --mapping-file")
+	addAliasesFlag(fromDockerfileCmd)
+	fromDockerfileCmd.Flags().StringVar(&dfGenerate, "generate", "", "Write the generated tasks to this configuration file instead of running them")
+	fromDockerfileCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop at the first image that fails instead of continuing with the others")
+	addTargetAuthFlags(fromDockerfileCmd)
+	addPlatformFlags(fromDockerfileCmd, "mirror")
+	fromDockerfileCmd.Flags().BoolVar(&verbatim, "verbatim", false, "With --all-arch, copy the source index and its images byte for byte through the registry API, keeping its digest and pinned digests in the mapping")
+	addNormalizeNamesFlag(fromDockerfileCmd)
+	addAllowDuplicateTargetsFlag(fromDockerfileCmd)
+	addTimeoutFlags(fromDockerfileCmd)
+	addDaemonDiskFlags(fromDockerfileCmd)
+	addRunFlags(fromDockerfileCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+)
+
+// Process exit codes
+const (
+	ExitOK             = 0
+	ExitUsage          = 1 // invalid flags, configuration or policy
+	ExitAuth           = 2 // a registry rejected the credentials or denied access
+	ExitNotFound       = 3 // a source image or manifest doesn't exist
+	ExitPartialFailure = 4 // some tasks or platforms failed
+	ExitFailure        = 5 // every task failed
+	ExitDifferent      = 6 // diff found differences between the images
+	ExitDeferred       = 7 // nothing failed, but work was deferred by --max-transfer
+	ExitDrift          = 8 // status found targets that drifted from their sources
+)
+
+// exitError carries the exit code for a failed run
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the process exit code for an error returned by a command.
+// Errors that don't come from a run are usage or configuration errors.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return ExitUsage
+}
+
+// runStatus classifies the outcome of a finished run. When a task failed it
+// returns an exitError wrapping runErr, or a summary of the failures when
+// runErr is nil. Authentication failures take precedence over missing
+// images, which take precedence over the share of tasks that failed. A run
+// where nothing failed but tasks were deferred exits with ExitDeferred.
+func runStatus(rep *report.Report, runErr error) error {
+	if rep.Failed() == 0 && len(rep.Errors()) == 0 {
+		// Nothing failed in the run itself, so runErr can only be a problem
+		// writing its output
+		if deferred := rep.Deferred(); deferred > 0 && runErr == nil {
+			return &exitError{code: ExitDeferred, err: fmt.Errorf("%d of %d tasks were deferred by the transfer budget", deferred, len(rep.Tasks))}
+		}
+		return runErr
+	}
+
+	errs := rep.Errors()
+	if runErr != nil {
+		errs = append(errs, runErr)
+	}
+
+	err := runErr
+	if err == nil {
+		err = fmt.Errorf("%d of %d tasks failed", rep.Failed(), len(rep.Tasks))
+	}
+
+	code := ExitPartialFailure
+	switch {
+	case anyIs(errs, errdefs.ErrAuthentication), anyIs(errs, errdefs.ErrAuthorization):
+		code = ExitAuth
+	case anyIs(errs, errdefs.ErrNotFound):
+		code = ExitNotFound
+	case rep.Failed() == len(rep.Tasks):
+		code = ExitFailure
+	}
+
+	return &exitError{code: code, err: err}
+}
+
+// anyIs reports whether any of errs matches target
+func anyIs(errs []error, target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadError classifies a failure to load a configuration or policy file.
+// Failing to fetch one from a URL exits like the same failure in a run,
+// while content that doesn't parse or validate is a usage error.
+func loadError(err error) error {
+	switch {
+	case errors.Is(err, errdefs.ErrAuthentication), errors.Is(err, errdefs.ErrAuthorization):
+		return &exitError{code: ExitAuth, err: err}
+	case errors.Is(err, errdefs.ErrNotFound):
+		return &exitError{code: ExitNotFound, err: err}
+	case errdefs.CategoryOf(err) != "":
+		return &exitError{code: ExitFailure, err: err}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/spf13/cobra"
+)
+
+var (
+	extractInput    string
+	extractOutput   string
+	extractPlatform string
+	extractTag      string
+)
+
+// extractCmd writes one platform of a multi-platform archive to a docker archive
+var extractCmd = &cobra.Command{
+	Use:   "extract --input <archive> --platform <os/arch> --output <archive>",
+	Short: "Extract one platform of a multi-platform archive",
+	Long: `Extract the image of one platform from a multi-platform OCI archive or
+layout, from a dir: or oci: layout skopeo copy --all wrote, or from a docker
+archive, and write it as a docker archive that old docker versions can
+load, without a docker daemon.
+
+The input may be compressed with gzip or zstd. The output is compressed
+according to its name: .tar, .tar.gz, .tgz or .tar.zst. The image keeps the
+name it has in the input as its RepoTag, unless --tag gives another one.
+Without a variant, --platform matches any variant of the architecture as
+long as only one is present. --compat docker-legacy writes the layers
+uncompressed as <diff ID>/layer.tar for Docker 17.x, and allows .tar and
+.tar.gz outputs only.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if extractInput == "" || extractOutput == "" || extractPlatform == "" {
+			return fmt.Errorf("--input, --output and --platform are required")
+		}
+
+		profile, err := archive.ParseCompat(compat)
+		if err != nil {
+			return fmt.Errorf("invalid --compat: %v", err)
+		}
+		extraction, err := archive.Extract(extractInput, extractOutput, extractPlatform, archive.ExtractOptions{RepoTags: []string{extractTag}, Compat: profile})
+		if err != nil {
+			return fmt.Errorf("failed to extract %s from %s: %w", extractPlatform, extractInput, err)
+		}
+		infof("Extracted %s (%s) of %s to %s as %s", extraction.Platform, extraction.Digest, extractInput,
+			extractOutput, strings.Join(extraction.RepoTags, ", "))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+
+	extractCmd.Flags().StringVarP(&extractInput, "input", "i", "", "Multi-platform archive, OCI layout directory, or dir: or oci: layout")
+	extractCmd.Flags().StringVarP(&extractOutput, "output", "o", "", "Path of the docker archive to write")
+	extractCmd.Flags().StringVar(&extractPlatform, "platform", "", "Platform to extract (os/arch[/variant])")
+	extractCmd.Flags().StringVar(&extractTag, "tag", "", "RepoTag of the extracted image (default: its name in the input)")
+	addCompatFlag(extractCmd, "the written archive")
+}
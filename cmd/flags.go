@@ -0,0 +1,362 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/spf13/cobra"
+)
+
+// Flags shared by several commands are registered by the functions below,
+// so every command binds them to the same variables with the same defaults.
+
+var (
+	sourceUsername string
+	sourcePassword string
+	sourceInsecure bool
+	noPull         bool
+	repoTags       string
+	annotations    []string
+	preserveIndex  bool
+	sourceOrder    bool
+	verbatim       bool
+	squash         bool
+	recompress     string
+	smokeTest      string
+	smokeRemove    bool
+	blobStore      string
+	compat         string
+	normalizeNames bool
+	allowDupTarget bool
+	outputRoot     string
+	splitPlatforms bool
+	onSourceChange string
+	aliasesFile    string
+	aliases        config.Aliases
+
+	runTimeout       time.Duration
+	taskTimeout      time.Duration
+	operationTimeout time.Duration
+
+	maxDaemonDisk     string
+	pruneDaemonDisk   bool
+	daemonDiskTimeout time.Duration
+)
+
+// addPlatformFlags registers the architecture and operating system filters.
+// verb describes what happens to the selected platforms, e.g. "pull".
+func addPlatformFlags(cmd *cobra.Command, verb string) {
+	cmd.Flags().StringSliceVarP(&architectures, "arch", "a", []string{"amd64", "arm64"}, "Architectures to "+verb+" (e.g., amd64,arm64)")
+	cmd.Flags().StringSliceVarP(&operatingSystems, "os", "", []string{"linux"}, "Operating systems to "+verb+" (e.g., linux,windows)")
+	cmd.Flags().BoolVar(&allArch, "all-arch", false, "Pull all available architectures")
+	cmd.Flags().BoolVar(&createMultiArch, "create-multi-arch", true, "Create a multi-architecture image with -allarch tag")
+}
+
+// addNoPullFlag registers the option to use only images already in the
+// local docker daemon
+func addNoPullFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&noPull, "no-pull", false, "Use the source images in the local docker daemon, without resolving or pulling them")
+}
+
+// addSourceChangeFlag registers what to do when a source tag moves while
+// its task runs
+func addSourceChangeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&onSourceChange, "on-source-change", "", "When the source tag moves to another digest during a task: warn (default), fail, or retry the task pinned to the new digest")
+}
+
+// sourceChangeFlag returns the --on-source-change action after checking it
+func sourceChangeFlag() (string, error) {
+	if err := config.ValidateSourceChange(onSourceChange); err != nil {
+		return "", fmt.Errorf("invalid --on-source-change: %v", err)
+	}
+	if onSourceChange != "" && noPull {
+		return "", fmt.Errorf("--on-source-change watches the source tag in its registry and can't be combined with --no-pull")
+	}
+	return onSourceChange, nil
+}
+
+// addAliasesFlag registers the configuration whose aliases resolve the
+// images a command finds
+func addAliasesFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&aliasesFile, "aliases", "", "Configuration file whose aliases section resolves the short names of the images found")
+}
+
+// loadAliases reads and checks the aliases of the --aliases configuration
+func loadAliases() error {
+	if aliasesFile == "" {
+		return nil
+	}
+	cfg, err := config.LoadConfig(aliasesFile)
+	if err != nil {
+		return loadError(fmt.Errorf("failed to load aliases: %w", err))
+	}
+	if err := cfg.Aliases.Validate(); err != nil {
+		return fmt.Errorf("aliases of %s: %v", aliasesFile, err)
+	}
+	aliases = cfg.Aliases
+	return nil
+}
+
+// resolveAlias returns an image reference with its --aliases alias resolved
+func resolveAlias(ref string) string {
+	resolved, _ := aliases.Resolve(ref)
+	return resolved
+}
+
+// addAnnotationFlag registers the annotations added to the pushed image.
+// what names what they are added to, e.g. "the pushed manifest list".
+func addAnnotationFlag(cmd *cobra.Command, what string) {
+	cmd.Flags().StringArrayVar(&annotations, "annotation", nil, "Annotation key=value to add to "+what+"; the value may use {{.Source}}, {{.Target}}, {{.SourceDigest}} and {{.Timestamp}}")
+}
+
+// addPreserveIndexFlag registers the option to carry the metadata of the
+// source index over to the pushed manifest list
+func addPreserveIndexFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&preserveIndex, "preserve-index", false, "Carry the media type and annotations of the source index, including those of its platforms, over to the pushed manifest list; --annotation adds to or replaces them")
+}
+
+// addSourceOrderFlags registers the options that keep the pushed manifest
+// list identical to the source index in its order or byte for byte
+func addSourceOrderFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&sourceOrder, "source-order", false, "List the platforms of the pushed manifest list in the order of the source index, pushing it only when every selected platform was pushed")
+	cmd.Flags().BoolVar(&verbatim, "verbatim", false, "With --all-arch, copy the source index and its images byte for byte through the registry API, keeping its digest")
+}
+
+// checkSourceOrder rejects --source-order and --verbatim where they can't
+// apply
+func checkSourceOrder(annotations map[string]string) error {
+	switch {
+	case sourceOrder && !createMultiArch:
+		return fmt.Errorf("--source-order applies to the manifest list and can't be combined with --create-multi-arch=false")
+	case verbatim && !allArch:
+		return fmt.Errorf("--verbatim copies the whole source index and requires --all-arch")
+	case verbatim && noPull:
+		return fmt.Errorf("--verbatim copies from the source registry and can't be combined with --no-pull")
+	case verbatim && len(annotations) > 0:
+		return fmt.Errorf("--verbatim keeps the digest of the source index and can't be combined with --annotation")
+	}
+	return nil
+}
+
+// addTransformFlags registers the layer transforms applied before a push
+func addTransformFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&squash, "squash", false, "Flatten the layers of each pushed image into one, which changes its digest")
+	cmd.Flags().StringVar(&recompress, "recompress", "", "Recompress the layers of each pushed image with gzip or zstd, which changes its digest")
+}
+
+// transformFlags returns the layer transform given with --squash and
+// --recompress
+func transformFlags() (archive.Transform, error) {
+	compression, err := archive.ParseRecompression(recompress)
+	if err != nil {
+		return archive.Transform{}, fmt.Errorf("invalid --recompress: %v", err)
+	}
+	transform := archive.Transform{Squash: squash, Recompress: compression}
+	if verbatim && !transform.IsZero() {
+		return archive.Transform{}, fmt.Errorf("--verbatim keeps the digest of the source index and can't be combined with --squash or --recompress")
+	}
+	return transform, nil
+}
+
+// addSmokeTestFlags registers the check that the target serves the pushed
+// images
+func addSmokeTestFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&smokeTest, "smoke-test", "", "After the push, check that the target serves every pushed platform with its digest: pull pulls it into the daemon, api checks its manifest and blobs through the registry API")
+	cmd.Flags().BoolVar(&smokeRemove, "smoke-test-remove", false, "Remove the images --smoke-test pull pulled")
+}
+
+// smokeTestFlags returns the smoke test given with --smoke-test, or nil
+func smokeTestFlags() (*config.SmokeTestConfig, error) {
+	if smokeTest == "" {
+		if smokeRemove {
+			return nil, fmt.Errorf("--smoke-test-remove requires --smoke-test pull")
+		}
+		return nil, nil
+	}
+	if err := config.ValidateSmokeTest(smokeTest); err != nil {
+		return nil, fmt.Errorf("invalid --smoke-test: %v", err)
+	}
+	switch {
+	case verbatim:
+		return nil, fmt.Errorf("--smoke-test can't be combined with --verbatim, which pushes through the registry API")
+	case smokeRemove && smokeTest != config.SmokeTestPull:
+		return nil, fmt.Errorf("--smoke-test-remove applies to --smoke-test pull, the api mode pulls nothing")
+	}
+	return &config.SmokeTestConfig{Mode: smokeTest, Remove: smokeRemove}, nil
+}
+
+// checkManifestListAnnotations rejects annotations and --preserve-index
+// when no manifest list is created to carry them
+func checkManifestListAnnotations(annotations map[string]string) error {
+	if len(annotations) > 0 && !createMultiArch {
+		return fmt.Errorf("--annotation is added to the manifest list and can't be combined with --create-multi-arch=false")
+	}
+	if preserveIndex && !createMultiArch {
+		return fmt.Errorf("--preserve-index applies to the manifest list and can't be combined with --create-multi-arch=false")
+	}
+	if preserveIndex && noPull {
+		return fmt.Errorf("--preserve-index reads the source index from its registry and can't be combined with --no-pull")
+	}
+	return nil
+}
+
+// annotationFlags returns the annotations given with --annotation
+func annotationFlags() (map[string]string, error) {
+	if len(annotations) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]string, len(annotations))
+	for _, annotation := range annotations {
+		key, value, ok := strings.Cut(annotation, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --annotation %q, use key=value", annotation)
+		}
+		parsed[key] = value
+	}
+	if err := config.ValidateAnnotations(parsed); err != nil {
+		return nil, fmt.Errorf("invalid --annotation: %v", err)
+	}
+	return parsed, nil
+}
+
+// addSaveFlags registers the options for saving images to archives
+func addSaveFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Output directory for saving images")
+	cmd.Flags().BoolVarP(&useCompression, "compress", "z", false, "Use gzip compression for saved images (.tar.gz)")
+	cmd.Flags().StringVar(&compressionMode, "compression", "", "Compression of saved images: gzip, zstd, or auto to pick one for every archive from a sample of it")
+	cmd.Flags().StringVar(&compressionPreference, "compression-preference", "", "What --compression auto prefers: ratio, speed or balanced (default balanced)")
+	cmd.Flags().StringVar(&compressionSample, "compression-sample", "", "How much of every archive --compression auto compresses with each candidate (default 16MiB)")
+	cmd.Flags().StringVar(&repoTags, "repo-tags", config.RepoTagsSuffixed, "Names embedded in saved archives: suffixed (e.g., nginx:1.25-linux-amd64), original (nginx:1.25) or both")
+	cmd.Flags().StringVar(&blobStore, "blob-store", "", "Add saved images to this shared OCI layout directory instead of an archive each, storing the layers they share once")
+	addCompatFlag(cmd, "saved archives")
+}
+
+// addCompatFlag registers the compatibility profile of the docker archives
+// a command writes, described by what
+func addCompatFlag(cmd *cobra.Command, what string) {
+	cmd.Flags().StringVar(&compat, "compat", "", "Compatibility profile of "+what+": docker-legacy for the classic docker save layout, gzip only and a single RepoTag, which Docker 17.x loads")
+}
+
+// checkSaveFlags checks the compression and compatibility options and
+// rejects --blob-store with a compression: the store keeps layers as docker
+// saves them
+func checkSaveFlags() error {
+	if blobStore != "" && (useCompression || compressionMode != "") {
+		return fmt.Errorf("--blob-store keeps layers as docker saves them and can't be combined with --compress or --compression")
+	}
+	if err := config.ValidateCompression(useCompression, compressionMode, compressionPreference, compressionSample); err != nil {
+		return err
+	}
+	if err := config.ValidateCompat(compat, compressionMode, repoTags, blobStore); err != nil {
+		return fmt.Errorf("invalid --compat: %v", err)
+	}
+	return nil
+}
+
+// addNormalizeNamesFlag registers the option to fix the case and separators
+// of invalid targets instead of rejecting them
+func addNormalizeNamesFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&normalizeNames, "normalize-names", false, "Lower-case target repositories and squash repeated slashes instead of rejecting such targets, listing each change")
+}
+
+// addAllowDuplicateTargetsFlag registers the option to only warn about
+// tasks pushing the same references
+func addAllowDuplicateTargetsFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&allowDupTarget, "allow-duplicate-targets", false, "Warn about tasks pushing to the same target instead of rejecting them")
+}
+
+// addOutputRootFlags registers the root directory of the per-task output
+// directories of a configuration, and the per-platform split below them
+func addOutputRootFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&outputRoot, "output-root", "", "Save each task without an output_dir to <root>/<task name or source repository> (overrides output_root)")
+	cmd.Flags().BoolVar(&splitPlatforms, "split-platforms", false, "Save the archives of each platform in a directory of its own, such as linux-amd64")
+}
+
+// applyOutputRoot applies the output root flags to cfg and derives the
+// output directories of its tasks from the root
+func applyOutputRoot(cfg *config.Config) error {
+	if outputRoot != "" {
+		cfg.OutputRoot = outputRoot
+	}
+	cfg.SplitPlatforms = cfg.SplitPlatforms || splitPlatforms
+	return cfg.ApplyOutputRoot()
+}
+
+// addTimeoutFlags registers the run, task and operation timeouts, which
+// override those of the configuration's timeouts section
+func addTimeoutFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Stop the run after this long, failing the running task and skipping the rest (0 for no limit; overrides timeouts.run)")
+	cmd.Flags().DurationVar(&taskTimeout, "task-timeout", 0, "Fail a task that takes longer than this, unless the task sets its own (0 for no limit; overrides timeouts.task)")
+	cmd.Flags().DurationVar(&operationTimeout, "operation-timeout", 0, "Fail a pull, save or push of a platform that takes longer than this, unless the task sets its own (0 for no limit; overrides timeouts.operation)")
+}
+
+// timeoutFlags returns the timeouts given as flags
+func timeoutFlags() config.Durations {
+	return config.Durations{Run: runTimeout, Task: taskTimeout, Operation: operationTimeout}
+}
+
+// addDaemonDiskFlags registers the limit of the disk usage of the docker
+// daemon, which overrides that of the configuration
+func addDaemonDiskFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&maxDaemonDisk, "max-daemon-disk", "", "Pause before pulling a platform while the docker daemon uses more disk than this (e.g., 200GB)")
+	cmd.Flags().BoolVar(&pruneDaemonDisk, "prune-daemon-disk", false, "Remove the local tags the run created for earlier tasks when --max-daemon-disk is exceeded")
+	cmd.Flags().DurationVar(&daemonDiskTimeout, "daemon-disk-timeout", 0, "Fail a platform that waited this long for --max-daemon-disk (default 1h)")
+}
+
+// daemonDiskLimit returns the limit of the daemon disk usage the flags
+// set, taking what they leave unset from cfg when it is given
+func daemonDiskLimit(cfg *config.Config) (docker.DiskLimit, error) {
+	var configured config.DaemonDisk
+	if cfg != nil {
+		var err error
+		if configured, err = cfg.DaemonDisk(); err != nil {
+			return docker.DiskLimit{}, err
+		}
+	}
+	limit := docker.DiskLimit{Max: configured.Max, Prune: pruneDaemonDisk || configured.Prune, Timeout: daemonDiskTimeout}
+	if maxDaemonDisk != "" {
+		max, err := bandwidth.ParseSize(maxDaemonDisk)
+		if err != nil || max <= 0 {
+			return limit, fmt.Errorf("invalid --max-daemon-disk %q", maxDaemonDisk)
+		}
+		limit.Max = max
+	}
+	if limit.Timeout == 0 {
+		limit.Timeout = configured.Timeout
+	}
+	if limit.Max == 0 && (pruneDaemonDisk || daemonDiskTimeout != 0) {
+		return limit, fmt.Errorf("--prune-daemon-disk and --daemon-disk-timeout require --max-daemon-disk")
+	}
+	return limit, nil
+}
+
+// addTargetAuthFlags registers the target registry and its credentials
+func addTargetAuthFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&registryURL, "registry", "r", "", "URL of the private registry")
+	cmd.Flags().StringVarP(&username, "username", "u", "", "Username for registry authentication")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "Password for registry authentication")
+	cmd.Flags().StringVar(&registryToken, "token", "", "Registry bearer token used instead of a username and password (e.g., a CI job token)")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure registry connections")
+}
+
+// addSourceAuthFlags registers the source registry credentials. purpose
+// describes what they are used for, e.g. "pulling from".
+func addSourceAuthFlags(cmd *cobra.Command, purpose string) {
+	cmd.Flags().StringVar(&sourceUsername, "source-username", "", "Username for "+purpose+" the source registry (defaults to docker login credentials)")
+	cmd.Flags().StringVar(&sourcePassword, "source-password", "", "Password for "+purpose+" the source registry")
+	cmd.Flags().BoolVar(&sourceInsecure, "source-insecure", false, "Allow insecure connections to the source registry")
+}
+
+// addRunFlags registers the report and policy options of commands that
+// process images
+func addRunFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&reportFile, "report", "", "Write a JSON report with per-stage timings to this file")
+	cmd.Flags().StringVar(&reportHistory, "report-history", "", "Also keep the JSON report in this directory, named after the time of the run")
+	cmd.Flags().IntVar(&reportHistoryKeep, "report-history-keep", 30, "Number of reports the history keeps, removing the oldest (0 keeps all)")
+	cmd.Flags().StringVar(&policyFile, "policy-file", "", "Path to a central registry policy file")
+}
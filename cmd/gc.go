@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/runs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcOlderThan string
+	gcOutputDir string
+	gcYes       bool
+)
+
+// gcCmd removes what runs created on this host longer ago than a retention
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove archives and local tags of runs older than a retention",
+	Long: `Find what runs that saved below --output-dir created longer ago than
+--older-than: the archives they wrote, their SHA256SUMS entries, the local
+tags they kept with --keep-intermediate and temporary files of interrupted
+runs, and print it. --yes removes them and compacts the run index and
+SHA256SUMS of every directory.
+
+Only what the run index imgmigrate-runs.json of a directory attributes to
+the tool is considered: an archive is kept when a newer run wrote it again,
+SHA256SUMS doesn't list it or it was modified after the cutoff, and files
+and tags the index doesn't list are never touched.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		retention, err := config.ParseDuration(gcOlderThan)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %v", err)
+		}
+		cutoff := time.Now().Add(-retention)
+
+		dirs, err := runDirs(gcOutputDir)
+		if err != nil {
+			return err
+		}
+		if len(dirs) == 0 {
+			infof("No runs recorded below %s", gcOutputDir)
+			return nil
+		}
+		release, err := acquireLocks("", dirs)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		var collected []*runs.Garbage
+		var tags []string
+		var archives, temp int
+		var size int64
+		for _, dir := range dirs {
+			g, err := runs.Collect(dir, cutoff)
+			if err != nil {
+				return err
+			}
+			for _, skipped := range g.Skipped {
+				warnf("Keeping an archive in %s: %s", dir, skipped)
+			}
+			for _, name := range g.Archives {
+				infof("%s %s", gcVerb("archive"), filepath.Join(dir, name))
+			}
+			for _, name := range g.Temp {
+				infof("%s %s", gcVerb("temporary file"), filepath.Join(dir, name))
+			}
+			for _, tag := range g.Tags {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+			archives += len(g.Archives)
+			temp += len(g.Temp)
+			size += g.Size
+			collected = append(collected, g)
+		}
+
+		// Tags that no longer exist were removed by hand and are only
+		// dropped from the index
+		var client *docker.Client
+		var local, kept []string
+		if len(tags) > 0 {
+			if client, err = docker.NewClient(dockerOptions()...); err != nil {
+				warnf("Keeping %d recorded local tags: %v", len(tags), err)
+				kept = tags
+			} else {
+				local = client.LocalTags(tags)
+			}
+		}
+		for _, tag := range local {
+			infof("%s %s", gcVerb("local tag"), tag)
+		}
+
+		summary := fmt.Sprintf("%d archives and %d temporary files (%s), and %d local tags older than %s",
+			archives, temp, progress.FormatBytes(size), len(local), gcOlderThan)
+		if !gcYes {
+			infof("Would remove %s; use --yes to remove them", summary)
+			return nil
+		}
+
+		if len(local) > 0 {
+			failed, err := client.RemoveTags(local)
+			if err != nil {
+				warnf("%v", err)
+			}
+			kept = append(kept, failed...)
+		}
+		for _, g := range collected {
+			if err := g.Remove(kept); err != nil {
+				return fmt.Errorf("error removing from %s: %v", g.Dir, err)
+			}
+		}
+		infof("Removed %s", summary)
+		return nil
+	},
+}
+
+// gcVerb starts the line listing something gc removes
+func gcVerb(what string) string {
+	if gcYes {
+		return "Removing " + what
+	}
+	return "Would remove " + what
+}
+
+// runDirs returns the directories below root with a run index
+func runDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == runs.IndexFile {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().StringVar(&gcOlderThan, "older-than", "720h", "Remove what runs created longer ago than this, e.g. 720h or 30d")
+	gcCmd.Flags().StringVarP(&gcOutputDir, "output-dir", "o", ".", "Directory the runs saved to, searched for run indexes at any depth")
+	gcCmd.Flags().BoolVarP(&gcYes, "yes", "y", false, "Remove what was found instead of only printing it")
+	gcCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this long for a run to release a directory instead of failing")
+}
@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/cluster"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	genFromCluster    bool
+	genKubeconfig     string
+	genKubeContext    string
+	genKubectl        string
+	genNamespaces     []string
+	genExclude        []string
+	genResolveDigests bool
+	genOutput         string
+	genFromArchives   string
+)
+
+// clusterImage is an image found in the cluster, as the source of a task
+type clusterImage struct {
+	source     string
+	namespaces []string
+	// digest is the digest pods pin the image to, or the resolved one
+	digest string
+}
+
+// generatedTask is a task of a generated configuration with the comment
+// written above it
+type generatedTask struct {
+	task    config.ImageTask
+	comment string
+}
+
+// generateConfigCmd writes a configuration for the images of a cluster or
+// a directory of archives
+var generateConfigCmd = &cobra.Command{
+	Use:   "generate-config",
+	Short: "Generate a configuration from the images running in a Kubernetes cluster or saved in a directory",
+	Long: `With --from-cluster, list the pods of a Kubernetes cluster through kubectl and
+write a configuration with one task per distinct image of their containers,
+init containers and ephemeral containers, pushing each below --target-prefix.
+
+With --from-archives, read the archives saved below a directory and write a
+configuration with one task per image pushing its archives below
+--target-prefix, the archives of the platforms of one image merged into a
+multi-platform image.
+
+The repository path and tag of an image are kept below the prefix, so with
+--target-prefix harbor.internal/mirror the image ghcr.io/org/app:1.0 is pushed
+to harbor.internal/mirror/org/app:1.0.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if genFromCluster == (genFromArchives != "") {
+			return fmt.Errorf("exactly one of --from-cluster and --from-archives is required")
+		}
+		if err := loadAliases(); err != nil {
+			return err
+		}
+		if targetPrefix == "" {
+			return fmt.Errorf("--target-prefix is required")
+		}
+		for _, pattern := range genExclude {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid --exclude pattern %q: %v", pattern, err)
+			}
+		}
+
+		var generated []generatedTask
+		var header string
+		var err error
+		if genFromCluster {
+			if !allArch && len(architectures) == 0 {
+				return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+			}
+			generated, err = clusterTasks(context.Background())
+			scope := "all namespaces"
+			if len(genNamespaces) > 0 {
+				scope = "namespaces " + strings.Join(genNamespaces, ", ")
+			}
+			header = "Generated by imgMigrate generate-config from the pods in " + scope
+		} else {
+			generated, err = archiveTasks(genFromArchives)
+			header = "Generated by imgMigrate generate-config from the archives in " + genFromArchives
+		}
+		if err != nil {
+			return err
+		}
+
+		tasks := make([]config.ImageTask, len(generated))
+		for i := range generated {
+			tasks[i] = generated[i].task
+		}
+		// One task per source, so none is merged and the indexes still match
+		if tasks, err = checkTargets(tasks); err != nil {
+			return err
+		}
+		for i := range generated {
+			generated[i].task = tasks[i]
+		}
+
+		data, err := generatedConfig(header, generated)
+		if err != nil {
+			return fmt.Errorf("error marshaling config: %v", err)
+		}
+		if genOutput == "" || genOutput == "-" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(genOutput, data, 0644); err != nil {
+			return fmt.Errorf("error writing config file: %v", err)
+		}
+		infof("Configuration with %d tasks written to %s", len(tasks), genOutput)
+		return nil
+	},
+}
+
+// clusterTasks returns a task for every image the pods of the cluster run
+func clusterTasks(ctx context.Context) ([]generatedTask, error) {
+	found, err := cluster.Images(ctx, cluster.Options{
+		Kubectl:    genKubectl,
+		Kubeconfig: genKubeconfig,
+		Context:    genKubeContext,
+		Namespaces: genNamespaces,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	images := clusterImages(found)
+	if genResolveDigests {
+		resolveClusterDigests(ctx, images)
+	}
+
+	generated := make([]generatedTask, 0, len(images))
+	for _, image := range images {
+		target, err := prefixedTarget(targetPrefix, image.source)
+		if err != nil {
+			return nil, err
+		}
+		task := config.ImageTask{
+			Source:           image.source,
+			Target:           target,
+			OperatingSystems: operatingSystems,
+			CreateMultiArch:  createMultiArch,
+		}
+		if allArch {
+			task.AllArchitecture = true
+		} else {
+			task.Architectures = architectures
+		}
+		comment := "used in " + strings.Join(image.namespaces, ", ")
+		if image.digest != "" {
+			comment += "\ndigest " + image.digest
+		}
+		generated = append(generated, generatedTask{task: task, comment: comment})
+	}
+	return generated, nil
+}
+
+// clusterImages returns the images of the cluster to mirror, one per
+// source after dropping digests, without excluded images and those already
+// below --target-prefix
+func clusterImages(found []cluster.Image) []*clusterImage {
+	var images []*clusterImage
+	bySource := make(map[string]*clusterImage)
+	for _, image := range found {
+		ref := resolveAlias(image.Reference)
+		named, err := imageref.Parse(ref)
+		if err != nil {
+			warnf("Skipping %s used in %s: %v", image.Reference, strings.Join(image.Namespaces, ", "), err)
+			continue
+		}
+		if excludedImage(named) {
+			infof("Excluding %s", image.Reference)
+			continue
+		}
+
+		// Tasks pull by tag, so a pinned digest is only recorded
+		source, digest, _ := strings.Cut(ref, "@")
+		if _, tagged := named.(reference.Tagged); !tagged && digest != "" {
+			warnf("Skipping %s used in %s: it has no tag to mirror", image.Reference, strings.Join(image.Namespaces, ", "))
+			continue
+		}
+
+		key := imageref.Key(source)
+		existing, ok := bySource[key]
+		if !ok {
+			existing = &clusterImage{source: source, digest: digest}
+			bySource[key] = existing
+			images = append(images, existing)
+		}
+		for _, namespace := range image.Namespaces {
+			if !slices.Contains(existing.namespaces, namespace) {
+				existing.namespaces = append(existing.namespaces, namespace)
+			}
+		}
+	}
+	infof("Found %d images in %d references in the cluster", len(images), len(found))
+	return images
+}
+
+// excludedImage reports whether an image matches an --exclude pattern or
+// is already below --target-prefix. A pattern matches the repository, fully
+// qualified or short as docker shows it, or any path above it, so
+// harbor.internal excludes every image of that registry.
+func excludedImage(named reference.Named) bool {
+	canonical := named.Name()
+	patterns := append([]string{strings.TrimSuffix(targetPrefix, "/")}, genExclude...)
+	for _, name := range []string{canonical, reference.FamiliarName(named)} {
+		parts := strings.Split(name, "/")
+		for i := range parts {
+			prefix := strings.Join(parts[:i+1], "/")
+			for _, pattern := range patterns {
+				if matched, _ := path.Match(pattern, prefix); matched {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// resolveClusterDigests looks up the current digest of every image pods
+// don't pin, warning about those that can't be resolved
+func resolveClusterDigests(ctx context.Context, images []*clusterImage) {
+	clients := make(map[string]*registry.Client)
+	for _, image := range images {
+		if image.digest != "" {
+			continue
+		}
+		host, repo, ref, err := splitReference(image.source)
+		if err != nil {
+			warnf("Not resolving %s: %v", image.source, err)
+			continue
+		}
+		client, ok := clients[host]
+		if !ok {
+			if client, err = newRegistryClient(host, docker.RegistryAuth{}); err != nil {
+				warnf("Not resolving %s: %v", image.source, err)
+				continue
+			}
+			clients[host] = client
+		}
+		if image.digest, err = client.Digest(ctx, repo, ref); err != nil {
+			warnf("Not resolving %s: %v", image.source, err)
+		}
+	}
+}
+
+// generatedConfig marshals the tasks with their comments
+func generatedConfig(header string, generated []generatedTask) ([]byte, error) {
+	tasks := make([]config.ImageTask, len(generated))
+	for i := range generated {
+		tasks[i] = generated[i].task
+	}
+	var doc yaml.Node
+	if err := doc.Encode(config.Config{ImageTask: tasks}); err != nil {
+		return nil, err
+	}
+	doc.HeadComment = header
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "images" {
+			continue
+		}
+		for j, item := range doc.Content[i+1].Content {
+			item.HeadComment = generated[j].comment
+		}
+	}
+	return yaml.Marshal(&doc)
+}
+
+func init() {
+	rootCmd.AddCommand(generateConfigCmd)
+
+	generateConfigCmd.Flags().BoolVar(&genFromCluster, "from-cluster", false, "Collect the images of the pods in a Kubernetes cluster")
+	generateConfigCmd.Flags().StringVar(&genFromArchives, "from-archives", "", "Collect the images of the archives saved below this directory")
+	generateConfigCmd.Flags().StringVar(&genKubeconfig, "kubeconfig", "", "Path to the kubeconfig file (default: kubectl's own)")
+	generateConfigCmd.Flags().StringVar(&genKubeContext, "kube-context", "", "Kubeconfig context to use (default: the current context)")
+	generateConfigCmd.Flags().StringVar(&genKubectl, "kubectl", "kubectl", "kubectl binary to list pods with, by name or path")
+	generateConfigCmd.Flags().StringSliceVar(&genNamespaces, "namespaces", nil, "Namespaces to list pods in (default: all namespaces)")
+	generateConfigCmd.Flags().StringVar(&targetPrefix, "target-prefix", "", "Registry and namespace to push every image below, keeping its repository path and tag (required)")
+	generateConfigCmd.Flags().StringArrayVar(&genExclude, "exclude", nil, "Skip images whose repository, or a path above it, matches this glob, e.g. harbor.internal or quay.io/org/* (repeatable)")
+	generateConfigCmd.Flags().BoolVar(&genResolveDigests, "resolve-digests", false, "Look up the current digest of each image and record it in the configuration")
+	generateConfigCmd.Flags().StringVarP(&genOutput, "output", "o", "", "Configuration file to write (default: stdout)")
+	addAliasesFlag(generateConfigCmd)
+	addPlatformFlags(generateConfigCmd, "mirror")
+	addNormalizeNamesFlag(generateConfigCmd)
+	addAllowDuplicateTargetsFlag(generateConfigCmd)
+}
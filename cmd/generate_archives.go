@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+)
+
+// savedArchive is an archive found below --from-archives
+type savedArchive struct {
+	path        string
+	modified    time.Time
+	description *archive.Description
+}
+
+// archiveTasks returns a task for every image saved below dir, pushing the
+// archives of its platforms. Archives that can't be read are skipped with a
+// warning.
+func archiveTasks(dir string) ([]generatedTask, error) {
+	paths, err := archivesBelow(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string][]savedArchive)
+	skipped := 0
+	for _, path := range paths {
+		description, err := archive.Describe(path)
+		if err != nil {
+			warnf("Skipping %s: %v", path, err)
+			skipped++
+			continue
+		}
+		if description.Name == "" {
+			warnf("Skipping %s: it doesn't name its image", path)
+			skipped++
+			continue
+		}
+		name := resolveAlias(description.Name)
+		named, err := imageref.Parse(name)
+		if err != nil {
+			warnf("Skipping %s: %v", path, err)
+			skipped++
+			continue
+		}
+		if excludedImage(named) {
+			infof("Excluding %s (%s)", path, description.Name)
+			continue
+		}
+		saved := savedArchive{path: path, description: description}
+		if info, err := os.Stat(path); err == nil {
+			saved.modified = info.ModTime()
+		}
+		images[name] = append(images[name], saved)
+	}
+
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	generated := make([]generatedTask, 0, len(names))
+	for _, name := range names {
+		target, err := prefixedTarget(targetPrefix, name)
+		if err != nil {
+			return nil, err
+		}
+		task := config.ImageTask{Source: name, Target: target}
+		var platforms []string
+		for _, saved := range pickArchives(name, images[name]) {
+			task.Archives = append(task.Archives, saved.path)
+			for _, platform := range saved.description.Platforms {
+				platforms = append(platforms, platform.String())
+			}
+		}
+		generated = append(generated, generatedTask{task: task, comment: strings.Join(platforms, ", ")})
+	}
+	infof("Found %d images in %d archives below %s, skipped %d", len(generated), len(paths), dir, skipped)
+	return generated, nil
+}
+
+// pickArchives selects the archives to push for an image: the newest
+// archive holding several platforms on its own, else the newest archive of
+// every platform, as an image saved twice leaves two
+func pickArchives(name string, saved []savedArchive) []savedArchive {
+	sort.SliceStable(saved, func(i, j int) bool { return saved[i].modified.After(saved[j].modified) })
+
+	for _, candidate := range saved {
+		if len(candidate.description.Platforms) > 1 {
+			if len(saved) > 1 {
+				warnf("Using %s for %s, which holds all its platforms, instead of the other %d archives", candidate.path, name, len(saved)-1)
+			}
+			return []savedArchive{candidate}
+		}
+	}
+
+	var picked []savedArchive
+	seen := make(map[string]string)
+	for _, candidate := range saved {
+		platform := candidate.description.Platforms[0].String()
+		if newer, ok := seen[platform]; ok {
+			warnf("Skipping %s: %s is a newer archive of %s for %s", candidate.path, newer, name, platform)
+			continue
+		}
+		seen[platform] = candidate.path
+		picked = append(picked, candidate)
+	}
+	sort.Slice(picked, func(i, j int) bool {
+		return picked[i].description.Platforms[0].String() < picked[j].description.Platforms[0].String()
+	})
+	return picked
+}
+
+// archivesBelow returns the archives and OCI layout directories below dir,
+// leaving out the temporary files of interrupted saves
+func archivesBelow(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			warnf("Skipping %s: %v", path, err)
+			return nil
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if _, err := os.Stat(filepath.Join(path, "index.json")); err == nil {
+				paths = append(paths, path)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, err := archive.FormatForPath(path); err == nil {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/runs"
+)
+
+// httpOptions holds the credentials for output directories and archives
+// given as http(s) URLs
+var httpOptions httpfile.Options
+
+// httpClient returns the client transferring archives to and from URLs
+func httpClient() *httpfile.Client {
+	opts := httpOptions
+	opts.Limiter = limiter
+	return httpfile.New(opts)
+}
+
+// stagedOutput is an output directory given as a URL. Archives are saved
+// to dir and uploaded below url after every task.
+type stagedOutput struct {
+	dir string
+	url string
+	// uploaded records the modification time of every file as uploaded,
+	// by its path relative to dir
+	uploaded map[string]time.Time
+}
+
+// stagedOutputs lists the output directories of the run given as URLs
+var stagedOutputs []*stagedOutput
+
+// stageOutput returns the local directory archives for the output
+// directory dir are saved to: dir itself, or when it is an http(s) URL a
+// temporary directory below --tmpdir that is uploaded after every task
+func stageOutput(dir string) (string, error) {
+	if !httpfile.IsURL(dir) {
+		return dir, nil
+	}
+	for _, staged := range stagedOutputs {
+		if staged.url == dir {
+			return staged.dir, nil
+		}
+	}
+	if _, err := url.Parse(dir); err != nil {
+		return "", fmt.Errorf("invalid output URL %s: %v", httpfile.Redact(dir), err)
+	}
+	local, err := os.MkdirTemp(tmpDir, ".imgmigrate-upload-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the staging directory of %s: %v", httpfile.Redact(dir), err)
+	}
+	stagedOutputs = append(stagedOutputs, &stagedOutput{dir: local, url: dir, uploaded: make(map[string]time.Time)})
+	infof("Archives for %s are staged in %s", httpfile.Redact(dir), local)
+	return local, nil
+}
+
+// uploadOutputs uploads the files written to the staged output directories
+// since the last upload. Archives go first and the checksum list and run
+// index last, so they never list an archive the server doesn't have yet.
+func uploadOutputs(ctx context.Context) error {
+	client := httpClient()
+	for _, staged := range stagedOutputs {
+		var changed []string
+		err := filepath.WalkDir(staged.dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".imgmigrate") {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(staged.dir, p)
+			if err != nil {
+				return err
+			}
+			if uploaded, ok := staged.uploaded[rel]; !ok || !info.ModTime().Equal(uploaded) {
+				changed = append(changed, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		isIndex := func(rel string) bool {
+			name := filepath.Base(rel)
+			return name == archive.ChecksumFile || name == runs.IndexFile
+		}
+		slices.SortStableFunc(changed, func(a, b string) int {
+			switch {
+			case isIndex(a) == isIndex(b):
+				return strings.Compare(a, b)
+			case isIndex(a):
+				return 1
+			}
+			return -1
+		})
+
+		for _, rel := range changed {
+			local := filepath.Join(staged.dir, rel)
+			info, err := os.Stat(local)
+			if err != nil {
+				return err
+			}
+			target, err := httpfile.Join(staged.url, filepath.ToSlash(rel))
+			if err != nil {
+				return err
+			}
+			started := time.Now()
+			if err := client.Upload(ctx, local, target); err != nil {
+				return fmt.Errorf("failed to upload %s: %w", rel, err)
+			}
+			staged.uploaded[rel] = info.ModTime()
+			infof("Uploaded %s (%s) to %s in %s", rel, progress.FormatBytes(info.Size()), httpfile.Redact(target), time.Since(started).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// removeStagedOutputs removes the staging directories of the run
+func removeStagedOutputs() {
+	for _, staged := range stagedOutputs {
+		if err := os.RemoveAll(staged.dir); err != nil {
+			warnf("Failed to remove the staging directory %s: %v", staged.dir, err)
+		}
+	}
+	stagedOutputs = nil
+}
+
+// fetchArchive downloads the archive at rawURL, with the SHA256SUMS and run
+// index next to it when the server has them, to a temporary directory,
+// streaming it to disk. It returns the path of the archive and a function
+// removing the directory. With verify the archive must be listed in
+// SHA256SUMS with the checksum it was downloaded with.
+func fetchArchive(ctx context.Context, rawURL string, verify bool) (string, func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid archive URL %s: %v", httpfile.Redact(rawURL), err)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", nil, fmt.Errorf("archive URL %s doesn't name a file", httpfile.Redact(rawURL))
+	}
+	dir, err := os.MkdirTemp(tmpDir, ".imgmigrate-download-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	client := httpClient()
+	for _, index := range []string{archive.ChecksumFile, runs.IndexFile} {
+		indexURL := *u
+		indexURL.Path = path.Join(path.Dir(u.Path), index)
+		indexURL.RawQuery = ""
+		if _, err := client.Download(ctx, indexURL.String(), filepath.Join(dir, index)); err != nil && !errors.Is(err, errdefs.ErrNotFound) {
+			cleanup()
+			return "", nil, err
+		}
+	}
+
+	local := filepath.Join(dir, name)
+	infof("Downloading %s...", httpfile.Redact(rawURL))
+	started := time.Now()
+	sum, err := client.Download(ctx, rawURL, local)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	info, _ := os.Stat(local)
+	infof("Downloaded %s (%s) in %s", name, progress.FormatBytes(info.Size()), time.Since(started).Round(time.Second))
+	if !verify {
+		return local, cleanup, nil
+	}
+
+	expected, err := listedChecksum(filepath.Join(dir, archive.ChecksumFile), name)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if expected == "" {
+		cleanup()
+		return "", nil, fmt.Errorf("%s isn't listed in the %s next to it, so its download can't be verified", httpfile.Redact(rawURL), archive.ChecksumFile)
+	}
+	if sum != expected {
+		cleanup()
+		return "", nil, fmt.Errorf("the download of %s has the checksum %s, but %s lists %s", httpfile.Redact(rawURL), sum, archive.ChecksumFile, expected)
+	}
+	infof("Verified the checksum of %s against %s", name, archive.ChecksumFile)
+	return local, cleanup, nil
+}
+
+// listedChecksum returns the checksum the sha256sum file at path lists for
+// name, or "" when it doesn't list it or doesn't exist
+func listedChecksum(path, name string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, listed, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if ok && strings.TrimPrefix(strings.TrimSpace(listed), "*") == name {
+			return sum, nil
+		}
+	}
+	return "", scanner.Err()
+}
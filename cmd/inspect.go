@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectPlatform string
+	inspectOutput   string
+)
+
+// inspectCmd shows what an image reference resolves to in its registry
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <image>",
+	Short: "Show the manifests an image resolves to",
+	Long: `Resolve an image through the registry API and print its digest and, for a
+manifest list, every entry with its platform, digest, compressed size and
+os.version, marking attestation manifests. The total download size counts
+each distinct blob of the images once and leaves attestations out.
+
+With --platform, the image config of one entry is shown instead: its
+environment, entrypoint, command and layers.
+
+Credentials come from --username and --password, the source_auth or
+registry section of the --file configuration, or docker login.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if inspectOutput != "text" && inspectOutput != "json" {
+			return fmt.Errorf("unsupported output format %q, use text or json", inspectOutput)
+		}
+
+		host, repo, ref, err := splitReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		cfg, err := registryConfig()
+		if err != nil {
+			return err
+		}
+		auth, err := imageAuth(cfg, host, docker.RegistryAuth{Username: username, Password: redact.Secret(password), Insecure: insecure})
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		client, err := newRegistryClient(host, auth)
+		if err != nil {
+			return err
+		}
+		image, err := client.Inspect(ctx, repo, ref)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s/%s", host, repo)
+		out := cmd.OutOrStdout()
+		if inspectPlatform == "" {
+			return printImage(out, name+":"+ref, image)
+		}
+
+		entry, err := image.Entry(inspectPlatform)
+		if err != nil {
+			return fmt.Errorf("%s:%s: %v", name, ref, err)
+		}
+		manifest := &registry.Manifest{MediaType: entry.MediaType, Config: entry.Config, Layers: entry.Layers, Digest: entry.Digest}
+		imageConfig, err := client.ImageConfig(ctx, repo, entry.Digest, manifest)
+		if err != nil {
+			return err
+		}
+		return printImageEntry(out, name+"@"+entry.Digest, entry, imageConfig)
+	},
+}
+
+// printImage prints the entries of an image in the --output format
+func printImage(out io.Writer, name string, image *registry.Image) error {
+	if inspectOutput == "json" {
+		return printJSON(out, struct {
+			Name string `json:"name"`
+			*registry.Image
+		}{name, image})
+	}
+
+	fmt.Fprintf(out, "Name:        %s\n", name)
+	fmt.Fprintf(out, "Digest:      %s\n", image.Digest)
+	fmt.Fprintf(out, "Media type:  %s\n", image.MediaType)
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tDIGEST\tSIZE\tOS VERSION\tATTESTATION")
+	for _, entry := range image.Entries {
+		attestation := "no"
+		if entry.Attestation {
+			attestation = "yes"
+		}
+		platform := entry.Platform.String()
+		if entry.Nested {
+			platform += " (nested)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", platform, entry.Digest, progress.FormatBytes(entry.Size),
+			valueOr(entry.Platform.OSVersion, "-"), attestation)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Total download size: %s\n", progress.FormatBytes(image.DownloadSize))
+	return nil
+}
+
+// printImageEntry prints one entry of an image with its image config in
+// the --output format
+func printImageEntry(out io.Writer, name string, entry registry.ImageEntry, imageConfig *registry.ImageConfig) error {
+	if inspectOutput == "json" {
+		return printJSON(out, struct {
+			Name string `json:"name"`
+			registry.ImageEntry
+			ImageConfig *registry.ImageConfig `json:"image_config"`
+		}{name, entry, imageConfig})
+	}
+
+	run := imageConfig.Config
+	fmt.Fprintf(out, "Name:        %s\n", name)
+	fmt.Fprintf(out, "Platform:    %s\n", entry.Platform)
+	if entry.Platform.OSVersion != "" {
+		fmt.Fprintf(out, "OS version:  %s\n", entry.Platform.OSVersion)
+	}
+	if !imageConfig.Created.IsZero() {
+		fmt.Fprintf(out, "Created:     %s\n", imageConfig.Created.Format("2006-01-02 15:04:05 MST"))
+	}
+	fmt.Fprintf(out, "Entrypoint:  %s\n", formatArgs(run.Entrypoint))
+	fmt.Fprintf(out, "Cmd:         %s\n", formatArgs(run.Cmd))
+	fmt.Fprintf(out, "Working dir: %s\n", valueOr(run.WorkingDir, "-"))
+	fmt.Fprintf(out, "User:        %s\n", valueOr(run.User, "-"))
+	fmt.Fprintln(out, "Env:")
+	for _, env := range run.Env {
+		fmt.Fprintf(out, "  %s\n", env)
+	}
+	fmt.Fprintf(out, "Layers:      %d (%s)\n", len(entry.Layers), progress.FormatBytes(entry.Size))
+	for _, layer := range entry.Layers {
+		fmt.Fprintf(out, "  %s  %s\n", layer.Digest, progress.FormatBytes(layer.Size))
+	}
+	return nil
+}
+
+// formatArgs formats an entrypoint or command, or - when it is empty
+func formatArgs(args []string) string {
+	if len(args) == 0 {
+		return "-"
+	}
+	return registry.FormatArgs(args)
+}
+
+// printJSON writes value as indented JSON
+func printJSON(out io.Writer, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(data))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().StringVar(&inspectPlatform, "platform", "", "Show the image config of one platform (os/arch[/variant])")
+	inspectCmd.Flags().StringVarP(&inspectOutput, "output", "o", "text", "Output format: text or json")
+	inspectCmd.Flags().StringVarP(&username, "username", "u", "", "Username for registry authentication")
+	inspectCmd.Flags().StringVarP(&password, "password", "p", "", "Password for registry authentication")
+	inspectCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure registry connections")
+	inspectCmd.Flags().StringVarP(&configFile, "file", "f", "", "Configuration file providing registry credentials")
+}
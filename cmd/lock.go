@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/lock"
+)
+
+// waitLock is how long to wait for another run to release its locks
+var waitLock time.Duration
+
+// outputLockName is the lock file taken in every output directory
+const outputLockName = ".imgmigrate.lock"
+
+// outputDirs returns the output directories the tasks of a config save to
+func outputDirs(cfg *config.Config) []string {
+	var dirs []string
+	for _, task := range cfg.ImageTask {
+		// Tasks with a target are only pushed, see processTask
+		if task.Target != "" || !task.Save {
+			continue
+		}
+		dir := task.OutputDir
+		if task.BlobStore != "" {
+			dir = task.BlobStore
+		}
+		if dir == "" {
+			dir = "."
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// acquireLocks takes the lock next to the config file, when there is one,
+// and the lock in every output directory, waiting up to --wait-lock for
+// each. Locks are taken in a fixed order so two waiting runs can't deadlock.
+// The returned function releases them.
+func acquireLocks(configPath string, dirs []string) (func(), error) {
+	var paths []string
+	if configPath != "" {
+		paths = append(paths, configPath+".lock")
+	}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory %s: %v", dir, err)
+		}
+		paths = append(paths, filepath.Join(dir, outputLockName))
+	}
+	sort.Strings(paths)
+
+	var held []*lock.Lock
+	release := func() {
+		for _, l := range held {
+			l.Release()
+		}
+	}
+	for _, path := range paths {
+		l, err := lock.AcquireWait(path, waitLock)
+		if err != nil {
+			release()
+			var heldErr *lock.HeldError
+			if errors.As(err, &heldErr) {
+				return nil, fmt.Errorf("another imgMigrate instance (%s) holds %s; retry later or use --wait-lock", heldErr.Owner, path)
+			}
+			return nil, err
+		}
+		held = append(held, l)
+	}
+	return release, nil
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mergeInputs []string
+	mergeOutput string
+	mergeFormat string
+	mergeTag    string
+	mergeForce  bool
+)
+
+// mergeCmd combines per-platform archives into one multi-platform archive
+var mergeCmd = &cobra.Command{
+	Use:   "merge --inputs <pattern>... --output <archive>",
+	Short: "Merge per-platform archives into one multi-platform archive",
+	Long: `Merge single-platform image archives, such as those save writes for each
+architecture, into one multi-platform OCI archive or layout, without a docker
+daemon. The platform of every input is read from its image config, and the
+merged OCI index references all of them; layers the inputs share are stored
+once.
+
+--inputs takes archive paths or glob patterns. The merged image is named by
+--tag, or after the inputs with their platform suffix removed:
+nginx:1.25-linux-amd64 and nginx:1.25-linux-arm64 become nginx:1.25. Inputs of
+different repositories are an error unless --force is set. The output format
+is taken from --format or the output name: .oci.tar, .oci.tar.gz and
+.oci.tar.zst are OCI archives and directories OCI image layouts.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(mergeInputs) == 0 || mergeOutput == "" {
+			return fmt.Errorf("both --inputs and --output are required")
+		}
+
+		var inputs []string
+		for _, pattern := range mergeInputs {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid input pattern %q: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return fmt.Errorf("no archives match %s", pattern)
+			}
+			for _, match := range matches {
+				if !slices.Contains(inputs, match) {
+					inputs = append(inputs, match)
+				}
+			}
+		}
+
+		var format archive.Format
+		var err error
+		if mergeFormat != "" {
+			format, err = archive.ParseFormat(mergeFormat)
+		} else {
+			format, err = archive.FormatForPath(mergeOutput)
+		}
+		if err != nil {
+			return err
+		}
+
+		merged, err := archive.Merge(inputs, mergeOutput, format, mergeTag, mergeForce)
+		if err != nil {
+			return fmt.Errorf("failed to merge archives: %w", err)
+		}
+		platforms := make([]string, 0, len(merged.Platforms))
+		for _, platform := range merged.Platforms {
+			platforms = append(platforms, platform.String())
+		}
+		infof("Merged %d archives into %s (%s) as %s for %s", len(inputs), mergeOutput, format, merged.Name,
+			strings.Join(platforms, ", "))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringSliceVarP(&mergeInputs, "inputs", "i", nil, "Archives or glob patterns of the per-platform archives to merge")
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "Path of the merged OCI archive or layout directory")
+	mergeCmd.Flags().StringVar(&mergeFormat, "format", "", "Output format: oci-layout, oci-archive, oci-archive.gz or oci-archive.zst (default: from the output name)")
+	mergeCmd.Flags().StringVar(&mergeTag, "tag", "", "Name of the merged image (default: derived from the inputs)")
+	mergeCmd.Flags().BoolVar(&mergeForce, "force", false, "Merge inputs of different repositories")
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/spf13/cobra"
+)
+
+// saveArchives makes migrate save archives in addition to pushing
+var saveArchives bool
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Pull an image, save it to archives and push it to a registry in one run",
+	Long: `Pull the selected platforms of an image once, save them to archives with --save
+and push them to --target. The report records the archive and push results as one task.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if sourceImage == "" {
+			return fmt.Errorf("source image is required")
+		}
+		if targetImage == "" && !saveArchives {
+			return fmt.Errorf("either --target or --save is required")
+		}
+		if !allArch && len(architectures) == 0 {
+			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+		}
+		if err := config.ValidateRepoTags(repoTags); err != nil {
+			return err
+		}
+		if err := checkSaveFlags(); err != nil {
+			return err
+		}
+		if blobStore != "" && !saveArchives {
+			return fmt.Errorf("--blob-store holds the saved images and requires --save")
+		}
+		templates, err := annotationFlags()
+		if err != nil {
+			return err
+		}
+		if err := checkManifestListAnnotations(templates); err != nil {
+			return err
+		}
+		if len(templates) > 0 && targetImage == "" {
+			return fmt.Errorf("--annotation is added to the pushed manifest list and requires --target")
+		}
+		if preserveIndex && targetImage == "" {
+			return fmt.Errorf("--preserve-index applies to the pushed manifest list and requires --target")
+		}
+		if err := checkSourceOrder(templates); err != nil {
+			return err
+		}
+		if (sourceOrder || verbatim) && targetImage == "" {
+			return fmt.Errorf("--source-order and --verbatim apply to the pushed image and require --target")
+		}
+		transform, err := transformFlags()
+		if err != nil {
+			return err
+		}
+		if !transform.IsZero() && targetImage == "" {
+			return fmt.Errorf("--squash and --recompress change the pushed images and require --target")
+		}
+		smoke, err := smokeTestFlags()
+		if err != nil {
+			return err
+		}
+		if smoke != nil && targetImage == "" {
+			return fmt.Errorf("--smoke-test checks the pushed images and requires --target")
+		}
+		sourceChange, err := sourceChangeFlag()
+		if err != nil {
+			return err
+		}
+
+		if targetImage != "" {
+			if targetImage, err = checkTarget("target", targetImage); err != nil {
+				return err
+			}
+		}
+		if err := checkPolicy(nil, []config.ImageTask{{Source: sourceImage, Target: targetImage}}); err != nil {
+			return err
+		}
+
+		if httpfile.IsURL(outputDir) {
+			return fmt.Errorf("--output URLs are only supported by pull and from-config")
+		}
+		var lockDirs []string
+		if saveArchives && blobStore != "" {
+			lockDirs = append(lockDirs, blobStore)
+		} else if saveArchives {
+			lockDirs = append(lockDirs, outputDir)
+		}
+		release, err := acquireLocks("", lockDirs)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		limit, err := daemonDiskLimit(nil)
+		if err != nil {
+			return err
+		}
+		extra := []docker.ClientOption{docker.WithDiskLimit(limit)}
+		if sourceUsername != "" {
+			host, _, _, err := splitReference(sourceImage)
+			if err != nil {
+				return err
+			}
+			extra = append(extra, docker.WithSourceAuth(map[string]docker.RegistryAuth{host: {
+				Username: sourceUsername,
+				Password: redact.Secret(sourcePassword),
+				URL:      host,
+				Insecure: sourceInsecure,
+			}}))
+		}
+
+		// The pull and push stages complete as separate tasks; the report
+		// records their combined result instead
+		rep := report.New()
+		extra = append(extra, docker.WithEventHandler(docker.EventHandlerFunc(func(e docker.Event) {
+			if _, ok := e.(docker.TaskCompleted); !ok {
+				rep.HandleEvent(e)
+			}
+		})))
+		client, err := newClient(nil, extra...)
+		if err != nil {
+			return err
+		}
+		defer removeIntermediate(client)
+		defer logoutRegistries(client)
+
+		auth := docker.RegistryAuth{
+			Username: username,
+			Password: redact.Secret(password),
+			Token:    redact.Secret(registryToken),
+			URL:      registryURL,
+			Insecure: insecure,
+		}
+
+		options := docker.SaveOptions{
+			UseCompression:   useCompression,
+			OutputDir:        outputDir,
+			OperatingSystems: operatingSystems,
+			CreateMultiArch:  createMultiArch,
+			RepoTags:         repoTags,
+			BlobStore:        blobStore,
+			Compat:           archive.Compat(compat),
+			LocalOnly:        noPull,
+			PreserveIndex:    preserveIndex,
+			SourceOrder:      sourceOrder,
+			Verbatim:         verbatim,
+			OnSourceChange:   sourceChange,
+			Transform:        transform,
+		}
+		if smoke != nil {
+			options.SmokeTest, options.SmokeTestRemove = smoke.Mode, smoke.Remove
+		}
+		if err := setCompression(&options, saveOptions(true), nil); err != nil {
+			return err
+		}
+
+		// Resolving the digest first lets the push reuse the platforms
+		// pulled for the archives
+		if saveArchives && targetImage != "" && !noPull {
+			if options.SourceDigest, err = client.ResolveDigest(sourceImage); err != nil {
+				warnf("%v, pulling again for the push", err)
+			}
+		}
+		options.Annotations, err = config.RenderAnnotations(templates, config.AnnotationData{
+			Source:       sourceImage,
+			Target:       targetImage,
+			SourceDigest: options.SourceDigest,
+			Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+
+		// The run is a single task, so both timeouts limit it
+		ctx, stop := interruptible()
+		defer stop()
+		for _, limit := range []time.Duration{runTimeout, taskTimeout} {
+			if limit > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, limit)
+				defer cancel()
+			}
+		}
+		options.OperationTimeout = operationTimeout
+
+		result, err := client.ProcessImageTask(ctx, docker.TaskSpec{
+			Source:           sourceImage,
+			Target:           targetImage,
+			Architectures:    architectures,
+			AllArchitectures: allArch,
+			Save:             saveArchives,
+			Auth:             auth,
+			Options:          options,
+		})
+
+		name := sourceImage
+		if targetImage != "" {
+			name = fmt.Sprintf("%s -> %s", sourceImage, targetImage)
+		}
+		rep.Add(name, result, err)
+
+		return runStatus(rep, finishReport(rep, err))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVarP(&sourceImage, "source", "s", "", "Source image to pull (required)")
+	migrateCmd.Flags().StringVarP(&targetImage, "target", "t", "", "Target image name with tag")
+	migrateCmd.Flags().BoolVar(&saveArchives, "save", false, "Also save the pulled images to archives in --output")
+	addSaveFlags(migrateCmd)
+	addPlatformFlags(migrateCmd, "migrate")
+	addNoPullFlag(migrateCmd)
+	addAnnotationFlag(migrateCmd, "the pushed manifest list")
+	addPreserveIndexFlag(migrateCmd)
+	addSourceOrderFlags(migrateCmd)
+	addTransformFlags(migrateCmd)
+	addSmokeTestFlags(migrateCmd)
+	addSourceChangeFlag(migrateCmd)
+	addNormalizeNamesFlag(migrateCmd)
+	addTimeoutFlags(migrateCmd)
+	addDaemonDiskFlags(migrateCmd)
+	addTargetAuthFlags(migrateCmd)
+	addSourceAuthFlags(migrateCmd, "pulling from")
+	addRunFlags(migrateCmd)
+	migrateCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this long for another run to release the output directory instead of failing")
+
+	migrateCmd.MarkFlagRequired("source")
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	nsSource    string
+	nsTarget    string
+	nsPrefix    string
+	nsRepoRegex string
+	nsTagRegex  string
+	nsMaxRepos  int
+	nsListRate  float64
+	nsDryRun    bool
+	nsGenerate  string
+)
+
+// namespaceRepo is a source repository selected for mirroring and its tags
+type namespaceRepo struct {
+	name string
+	tags []string
+}
+
+// mirrorNamespaceCmd mirrors every repository below a source namespace
+var mirrorNamespaceCmd = &cobra.Command{
+	Use:   "mirror-namespace",
+	Short: "Mirror every repository below a source registry namespace",
+	Long: `List the repositories below a source namespace through the registry _catalog
+endpoint, or the Harbor and Quay project APIs where the catalog is restricted,
+enumerate their tags and mirror each tag to the target namespace.
+
+The path of a repository below --source is kept below --target, so with
+--source harbor.old.example.com/project and --target registry.example.com/mirror
+the tag project/team/app:1.0 is pushed to registry.example.com/mirror/team/app:1.0.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if nsSource == "" || nsTarget == "" {
+			return fmt.Errorf("source and target namespaces are required")
+		}
+		if !allArch && len(architectures) == 0 {
+			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+		}
+
+		var repoFilter, tagFilter *regexp.Regexp
+		var err error
+		if nsRepoRegex != "" {
+			if repoFilter, err = regexp.Compile(nsRepoRegex); err != nil {
+				return fmt.Errorf("invalid --repo-regex: %v", err)
+			}
+		}
+		if nsTagRegex != "" {
+			if tagFilter, err = regexp.Compile(nsTagRegex); err != nil {
+				return fmt.Errorf("invalid --tag-regex: %v", err)
+			}
+		}
+
+		host, namespace, _ := strings.Cut(strings.TrimSuffix(nsSource, "/"), "/")
+		host = registry.NormalizeHost(host)
+
+		creds := registry.Credentials{Username: sourceUsername, Password: sourcePassword}
+		if creds.Empty() {
+			creds, _ = registry.StoredCredentials(host)
+		}
+		var interval time.Duration
+		if nsListRate > 0 {
+			interval = time.Duration(float64(time.Second) / nsListRate)
+		}
+		lister, err := registry.NewClient(host, registry.Options{
+			Credentials:     creds,
+			Insecure:        sourceInsecure,
+			TLS:             registryTLS(host),
+			Proxy:           registryProxy(host),
+			RequestInterval: interval,
+		})
+		if err != nil {
+			return err
+		}
+
+		repos, err := listNamespace(context.Background(), lister, namespace, repoFilter, tagFilter)
+		if err != nil {
+			return err
+		}
+
+		tasks, err := checkTargets(namespaceTasks(host, namespace, repos))
+		if err != nil {
+			return err
+		}
+		printNamespacePlan(host, namespace, repos, tasks)
+
+		if nsGenerate != "" {
+			data, err := yaml.Marshal(config.Config{ImageTask: tasks})
+			if err != nil {
+				return fmt.Errorf("error marshaling config: %v", err)
+			}
+			if err := os.WriteFile(nsGenerate, data, 0644); err != nil {
+				return fmt.Errorf("error writing config file: %v", err)
+			}
+			infof("Configuration with %d tasks written to %s", len(tasks), nsGenerate)
+			return nil
+		}
+		if nsDryRun || len(tasks) == 0 {
+			return nil
+		}
+
+		if err := checkPolicy(nil, tasks); err != nil {
+			return err
+		}
+
+		rep := report.New()
+		limit, err := daemonDiskLimit(nil)
+		if err != nil {
+			return err
+		}
+		client, err := newClient(rep, docker.WithDiskLimit(limit))
+		if err != nil {
+			return err
+		}
+		defer removeIntermediate(client)
+		defer logoutRegistries(client)
+
+		auth := docker.RegistryAuth{
+			Username: username,
+			Password: redact.Secret(password),
+			URL:      registry.NormalizeHost(nsTarget),
+			Insecure: insecure,
+		}
+
+		ctx, stop := interruptible()
+		defer stop()
+		runTasks(ctx, client, &config.Config{ImageTask: tasks}, auth, nil, rep)
+		return runStatus(rep, finishReport(rep, nil))
+	},
+}
+
+// listNamespace returns the repositories below namespace that pass the
+// filters, with their matching tags, stopping after --max-repos repositories
+func listNamespace(ctx context.Context, lister *registry.Client, namespace string, repoFilter, tagFilter *regexp.Regexp) ([]namespaceRepo, error) {
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + "/"
+	}
+
+	var names []string
+	err := lister.Repositories(ctx, namespace, func(repo string) bool {
+		relative := strings.TrimPrefix(repo, prefix)
+		if !strings.HasPrefix(relative, nsPrefix) {
+			return true
+		}
+		if repoFilter != nil && !repoFilter.MatchString(relative) {
+			return true
+		}
+		names = append(names, repo)
+		return nsMaxRepos <= 0 || len(names) < nsMaxRepos
+	})
+	if err != nil {
+		return nil, err
+	}
+	if nsMaxRepos > 0 && len(names) == nsMaxRepos {
+		warnf("Stopped listing after --max-repos=%d repositories", nsMaxRepos)
+	}
+
+	var repos []namespaceRepo
+	for _, name := range names {
+		tags, err := lister.Tags(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		repo := namespaceRepo{name: name}
+		for _, tag := range tags {
+			if tagFilter == nil || tagFilter.MatchString(tag) {
+				repo.tags = append(repo.tags, tag)
+			}
+		}
+		repos = append(repos, repo)
+	}
+
+	return repos, nil
+}
+
+// namespaceTasks maps every tag of the listed repositories to a task that
+// mirrors it below the target namespace
+func namespaceTasks(host, namespace string, repos []namespaceRepo) []config.ImageTask {
+	var tasks []config.ImageTask
+	for _, repo := range repos {
+		relative := repo.name
+		if namespace != "" {
+			relative = strings.TrimPrefix(repo.name, namespace+"/")
+		}
+
+		for _, tag := range repo.tags {
+			task := config.ImageTask{
+				Source:           fmt.Sprintf("%s/%s:%s", host, repo.name, tag),
+				Target:           fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(nsTarget, "/"), relative, tag),
+				OperatingSystems: operatingSystems,
+				CreateMultiArch:  createMultiArch,
+			}
+			if allArch {
+				task.AllArchitecture = true
+			} else {
+				task.Architectures = architectures
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// printNamespacePlan prints the repositories and tag counts selected for mirroring
+func printNamespacePlan(host, namespace string, repos []namespaceRepo, tasks []config.ImageTask) {
+	source := host
+	if namespace != "" {
+		source += "/" + namespace
+	}
+
+	for _, repo := range repos {
+		infof("  %s (%d tags)", repo.name, len(repo.tags))
+	}
+	infof("%d repositories and %d tags selected in %s for mirroring to %s", len(repos), len(tasks), source, nsTarget)
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorNamespaceCmd)
+
+	mirrorNamespaceCmd.Flags().StringVar(&nsSource, "source", "", "Source registry and namespace, e.g. harbor.example.com/project (required)")
+	mirrorNamespaceCmd.Flags().StringVar(&nsTarget, "target", "", "Target registry and namespace, e.g. registry.example.com/mirror (required)")
+	mirrorNamespaceCmd.Flags().StringVar(&nsPrefix, "prefix", "", "Only mirror repositories whose path below the namespace starts with this prefix")
+	mirrorNamespaceCmd.Flags().StringVar(&nsRepoRegex, "repo-regex", "", "Only mirror repositories whose path below the namespace matches this regular expression")
+	mirrorNamespaceCmd.Flags().StringVar(&nsTagRegex, "tag-regex", "", "Only mirror tags matching this regular expression")
+	mirrorNamespaceCmd.Flags().IntVar(&nsMaxRepos, "max-repos", 0, "Stop after this many repositories (0 for no limit)")
+	mirrorNamespaceCmd.Flags().Float64Var(&nsListRate, "list-rate", 5, "Maximum listing requests per second against the source registry (0 for no limit)")
+	mirrorNamespaceCmd.Flags().BoolVar(&nsDryRun, "dry-run", false, "Only print the repositories and tag counts that would be mirrored")
+	mirrorNamespaceCmd.Flags().StringVar(&nsGenerate, "generate", "", "Write the generated tasks to this configuration file instead of running them")
+	addSourceAuthFlags(mirrorNamespaceCmd, "listing")
+	mirrorNamespaceCmd.Flags().StringVarP(&username, "username", "u", "", "Username for target registry authentication")
+	mirrorNamespaceCmd.Flags().StringVarP(&password, "password", "p", "", "Password for target registry authentication")
+	mirrorNamespaceCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure target registry connections")
+	addPlatformFlags(mirrorNamespaceCmd, "mirror")
+	addNormalizeNamesFlag(mirrorNamespaceCmd)
+	addAllowDuplicateTargetsFlag(mirrorNamespaceCmd)
+	addTimeoutFlags(mirrorNamespaceCmd)
+	addDaemonDiskFlags(mirrorNamespaceCmd)
+	addRunFlags(mirrorNamespaceCmd)
+}
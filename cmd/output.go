@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/logging"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+)
+
+var (
+	quiet         bool
+	noColor       bool
+	logFile       string
+	logMaxSize    int64
+	logMaxBackups int
+
+	eventsFormat string
+	eventsFile   string
+
+	// logOutput is the open --log-file, if any
+	logOutput *logging.RotatingFile
+	// events writes the --events-format stream, if any, to eventsOutput
+	events       *report.EventStream
+	eventsOutput *os.File
+
+	// logger writes console messages to stdout
+	logger = slog.New(logging.Redacting(logging.NewConsoleHandler(os.Stdout, slog.LevelInfo, false)))
+)
+
+// setupOutput configures progress bars and logging from the output flags.
+// With --tui the console output goes to the dashboard. In quiet mode only
+// warnings, errors and the final summary are shown on the console. Color is
+// used only on a terminal that supports it and when NO_COLOR is unset. The
+// --log-file receives the full debug log regardless of console verbosity.
+// Registered secrets are masked in both.
+func setupOutput() error {
+	if err := setupEvents(); err != nil {
+		return err
+	}
+
+	console, consoleFile := stdout, os.Stdout
+	if dataOnStdout() {
+		console, consoleFile = os.Stderr, os.Stderr
+		stdout = os.Stderr
+	} else if useTUI && startDashboard() {
+		console = stdout
+	} else if !quiet {
+		startProgress()
+		console = stdout
+	}
+
+	level := slog.LevelInfo
+	if quiet {
+		level = slog.LevelWarn
+	}
+	color := !noColor && os.Getenv("NO_COLOR") == "" && dashboard == nil && progress.SupportsANSI(consoleFile)
+	var handler slog.Handler = logging.NewConsoleHandler(console, level, color)
+
+	if logFile != "" {
+		var err error
+		logOutput, err = logging.OpenFile(logFile, logMaxSize*1024*1024, logMaxBackups)
+		if err != nil {
+			return err
+		}
+		file := slog.NewJSONHandler(logOutput, &slog.HandlerOptions{Level: slog.LevelDebug}).WithAttrs([]slog.Attr{slog.String("run_id", runID)})
+		handler = logging.Fanout(handler, file)
+	}
+
+	logger = slog.New(logging.Redacting(handler))
+	if useTUI && dashboard == nil {
+		warnf("--tui needs stdin and stdout on a terminal that can draw it, showing plain output")
+	}
+	return nil
+}
+
+// dataOnStdout reports whether the command writes its result or the event
+// stream to stdout, in which case messages and the summary go to stderr
+func dataOnStdout() bool {
+	if eventsFormat != "" && (eventsFile == "" || eventsFile == "-") {
+		return true
+	}
+	return printConfig || ((genFromCluster || genFromArchives != "") && (genOutput == "" || genOutput == "-"))
+}
+
+// setupEvents opens the event stream selected by --events-format and
+// --events-file
+func setupEvents() error {
+	switch eventsFormat {
+	case "":
+		if eventsFile != "" {
+			return fmt.Errorf("--events-file requires --events-format")
+		}
+		return nil
+	case "ndjson":
+	default:
+		return fmt.Errorf("unknown --events-format %q, the only format is ndjson", eventsFormat)
+	}
+
+	if eventsFile == "" || eventsFile == "-" {
+		events = report.NewEventStream(os.Stdout, runID)
+		return nil
+	}
+	file, err := os.OpenFile(eventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the events file: %w", err)
+	}
+	eventsOutput = file
+	events = report.NewEventStream(file, runID)
+	return nil
+}
+
+// closeEvents closes the --events-file
+func closeEvents() {
+	if eventsOutput == nil {
+		return
+	}
+	if err := eventsOutput.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close events file: %v\n", err)
+	}
+	eventsOutput = nil
+}
+
+// newRunID returns a short random ID for the run
+func newRunID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// closeLog syncs and closes the --log-file
+func closeLog() {
+	if logOutput == nil {
+		return
+	}
+	if err := logOutput.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close log file: %v\n", err)
+	}
+	logOutput = nil
+}
+
+// infof logs a progress message
+func infof(format string, args ...interface{}) {
+	logger.Info(fmt.Sprintf(format, args...))
+}
+
+// warnf logs a problem that doesn't stop processing
+func warnf(format string, args ...interface{}) {
+	logger.Warn(fmt.Sprintf(format, args...))
+}
+
+// errorf logs a failure
+func errorf(format string, args ...interface{}) {
+	logger.Error(fmt.Sprintf(format, args...))
+}
+
+// consoleHandler prints pipeline events to the console
+type consoleHandler struct{}
+
+// HandleEvent prints a line for each notable event
+func (consoleHandler) HandleEvent(e docker.Event) {
+	switch e := e.(type) {
+	case docker.PlatformSaved:
+		infof("Successfully saved image %s to %s", e.Tag, e.Path)
+	case docker.PlatformPushed:
+		if e.Digest != "" {
+			infof("Successfully pushed image %s (%s)", e.Tag, e.Digest)
+		} else {
+			infof("Successfully pushed image %s", e.Tag)
+		}
+	case docker.PlatformFailed:
+		errorf("Failed to %s image for architecture %s: %v", e.Stage, e.Platform, e.Err)
+	case docker.ManifestCreated:
+		infof("Successfully created multi-arch manifest %s", e.ManifestList)
+	case docker.TaskCompleted:
+		if !quiet {
+			report.PrintTaskTotals(stdout, e.Result)
+		}
+	case docker.OperationHeartbeat:
+		heartbeatf("%s", e)
+	}
+}
+
+// heartbeatf logs that an operation is still running. Quiet mode prints it
+// on the console too unless --quiet-heartbeat is given, since quiet CI jobs
+// are the ones killed for being silent.
+func heartbeatf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logger.Info(msg)
+	if quiet && !quietHeartbeat {
+		fmt.Fprintln(stdout, redact.String(msg))
+	}
+}
+
+// dockerOptions returns the client options selecting how the docker CLI is
+// run, from --docker-bin and --sudo
+func dockerOptions() []docker.ClientOption {
+	opts := []docker.ClientOption{docker.WithDockerBinary(dockerBin), docker.WithBandwidthLimiter(limiter), docker.WithTempDir(tmpDir), docker.WithRunID(runID), docker.WithHeartbeat(heartbeat), docker.WithMaxRetryAfter(maxRetryAfter)}
+	if useSudo {
+		opts = append(opts, docker.WithSudo())
+	}
+	return opts
+}
+
+// newClient creates a docker client that prints events to the console and
+// records completed tasks in rep
+func newClient(rep *report.Report, extra ...docker.ClientOption) (*docker.Client, error) {
+	opts := append(dockerOptions(),
+		docker.WithLogger(logger),
+		docker.WithEventHandler(consoleHandler{}),
+		docker.WithRegistryTLS(registryTLS),
+		docker.WithRegistryProxy(registryProxy),
+	)
+	if noCache {
+		opts = append(opts, docker.WithoutManifestCache())
+	} else if dir := docker.DefaultCacheDir(); dir != "" {
+		opts = append(opts, docker.WithManifestCache(dir, cacheTTL))
+	}
+	if renderer != nil {
+		opts = append(opts, docker.WithEventHandler(&progressHandler{bars: make(map[string]*progress.Bar)}))
+	}
+	if dashboard != nil {
+		opts = append(opts, docker.WithEventHandler(dashboard))
+	}
+	opts = append(opts, docker.WithEventHandler(estimator))
+	if transferBudget != nil {
+		opts = append(opts, docker.WithEventHandler(transferBudget), docker.WithPlatformBudget(transferBudget.Allows))
+	}
+	if transferWindow != nil {
+		// Tasks pause between their platforms when the --window closes
+		opts = append(opts, docker.WithPlatformGate(func(ctx context.Context, source, platform string) time.Duration {
+			return waitForWindow(ctx, rep, source+" "+platform)
+		}))
+	}
+	if rep != nil {
+		opts = append(opts, docker.WithEventHandler(rep))
+	}
+	if auditLog != nil {
+		opts = append(opts, docker.WithAuditLog(auditLog))
+	}
+	if events != nil {
+		opts = append(opts, docker.WithEventHandler(events))
+	}
+
+	client, err := docker.NewClient(append(opts, extra...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %v", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/eta"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+)
+
+var (
+	noProgress bool
+
+	// stdout receives console output. It is the progress renderer while
+	// progress bars are shown, so log lines are printed above the bars.
+	stdout io.Writer = os.Stdout
+
+	// renderer draws progress bars; nil when progress output is disabled
+	renderer *progress.Renderer
+
+	// estimator estimates the time the tasks of a run have left
+	estimator = eta.New()
+)
+
+// startProgress enables progress bars when stdout is a terminal that can
+// draw them and --no-progress wasn't given
+func startProgress() {
+	if noProgress || !progress.SupportsANSI(os.Stdout) {
+		return
+	}
+	renderer = progress.New(os.Stdout)
+	stdout = renderer
+}
+
+// stopProgress removes any bars or dashboard still on screen and restores
+// plain output
+func stopProgress() {
+	stopDashboard()
+	if renderer == nil {
+		return
+	}
+	renderer.Stop()
+	renderer = nil
+	stdout = os.Stdout
+}
+
+// progressHandler shows a bar for every platform that is being pulled, saved
+// or pushed
+type progressHandler struct {
+	bars map[string]*progress.Bar
+}
+
+// HandleEvent updates the bar of the platform an event belongs to and
+// removes it once the platform is done
+func (h *progressHandler) HandleEvent(e docker.Event) {
+	switch e := e.(type) {
+	case docker.PlatformPullProgress:
+		h.bar(e.Source, e.Platform).Set("pull", e.Bytes, e.Total)
+	case docker.PlatformSaveProgress:
+		h.bar(e.Source, e.Platform).Set("save", e.Bytes, e.Total)
+	case docker.PlatformPushProgress:
+		h.bar(e.Source, e.Platform).Set("push", e.Bytes, e.Total)
+	case docker.PlatformSaved:
+		h.remove(e.Source, e.Platform)
+	case docker.PlatformPushed:
+		h.remove(e.Source, e.Platform)
+	case docker.PlatformFailed:
+		h.remove(e.Source, e.Platform)
+	}
+}
+
+func (h *progressHandler) bar(source, platform string) *progress.Bar {
+	key := source + " " + platform
+	bar, ok := h.bars[key]
+	if !ok {
+		bar = renderer.AddBar(key)
+		h.bars[key] = bar
+	}
+	return bar
+}
+
+func (h *progressHandler) remove(source, platform string) {
+	key := source + " " + platform
+	if bar, ok := h.bars[key]; ok {
+		bar.Remove()
+		delete(h.bars, key)
+	}
+}
+
+// showRemaining keeps the label of the overall bar of a run up to date with
+// the estimated time left, until the returned function is called
+func showRemaining(overall *progress.Bar) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if estimate := estimator.Estimate(); estimate.Known {
+				overall.SetLabel("tasks, " + eta.Format(estimate.RunRemaining) + " remaining")
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+)
+
+var pruneDryRun bool
+
+// pruneTask deletes stale tags from the target repository of a task. A tag
+// is only considered when its base tag, the target tag its arch_tag or
+// -allarch manifest list tag was named after, matches one of the configured tag patterns or was recorded as a
+// target in the state file. It is stale when the
+// base tag no longer exists in the source repository or its image is older
+// than the retention. The task's own target tags are never pruned, and
+// neither is a digest that a tag not attributable to the tool points to.
+func pruneTask(ctx context.Context, client *docker.Client, task config.ImageTask, auth docker.RegistryAuth, mirrored *state.File, rep *report.Report) error {
+	retention, err := task.Prune.RetentionPeriod()
+	if err != nil {
+		return err
+	}
+	archTag, err := config.ParseArchTag(task.ArchTag)
+	if err != nil {
+		return err
+	}
+
+	targetHost, targetRepo, currentTag, err := splitReference(task.Target)
+	if err != nil {
+		return err
+	}
+
+	// Tags recorded as mirror targets of the same repository
+	recorded := make(map[string]string)
+	if mirrored != nil {
+		for _, entry := range mirrored.Entries() {
+			// A task deferred before it was ever mirrored wrote no tag
+			if entry.MirroredAt.IsZero() {
+				continue
+			}
+			host, repo, tag, err := splitReference(entry.Target)
+			if err == nil && host == targetHost && repo == targetRepo {
+				recorded[tag] = entry.Target
+			}
+		}
+	}
+	if len(task.Prune.TagPatterns) == 0 && len(recorded) == 0 {
+		warnf("No tag_patterns configured or tags recorded for pruning %s, no tags are attributable to imgMigrate", task.Target)
+		return nil
+	}
+	sourceHost, sourceRepo, _, err := splitReference(task.Source)
+	if err != nil {
+		return err
+	}
+
+	target, err := newRegistryClient(targetHost, targetAuth(auth, targetHost))
+	if err != nil {
+		return err
+	}
+	source, err := newRegistryClient(sourceHost, client.SourceAuth(sourceHost))
+	if err != nil {
+		return err
+	}
+
+	upstreamTags, err := source.Tags(ctx, sourceRepo)
+	if err != nil {
+		return err
+	}
+	upstream := make(map[string]bool)
+	for _, tag := range upstreamTags {
+		upstream[tag] = true
+	}
+
+	tags, err := target.Tags(ctx, targetRepo)
+	if err != nil {
+		return err
+	}
+
+	// Select the stale tags the tool created
+	reasons := make(map[string]string)
+	for _, tag := range tags {
+		base, ok := strings.CutSuffix(tag, "-allarch")
+		if !ok {
+			if base, ok = archTag.Base(tag); !ok {
+				base = tag
+			}
+		}
+		if _, ok := recorded[base]; base == currentTag || (!ok && !matchTag(task.Prune.TagPatterns, base)) {
+			continue
+		}
+
+		if !upstream[base] {
+			reasons[tag] = fmt.Sprintf("%s no longer exists in %s/%s", base, sourceHost, sourceRepo)
+			continue
+		}
+		if retention > 0 {
+			created, err := target.Created(ctx, targetRepo, tag)
+			if err != nil {
+				warnf("Skipping retention check of %s:%s: %v", targetRepo, tag, err)
+				continue
+			}
+			if age := time.Since(created); age > retention {
+				reasons[tag] = fmt.Sprintf("created %s ago, retention is %s", age.Round(time.Hour), task.Prune.Retention)
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		infof("No stale tags to prune in %s/%s", targetHost, targetRepo)
+		return nil
+	}
+
+	// Deleting a manifest removes every tag pointing to it, so group the
+	// tags by digest and keep digests shared with any other tag
+	byDigest := make(map[string][]string)
+	for _, tag := range tags {
+		digest, err := target.Digest(ctx, targetRepo, tag)
+		if err != nil {
+			return err
+		}
+		byDigest[digest] = append(byDigest[digest], tag)
+	}
+
+	digests := make([]string, 0, len(byDigest))
+	for digest := range byDigest {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+
+	for _, digest := range digests {
+		shared := byDigest[digest]
+		var stale, kept []string
+		for _, tag := range shared {
+			if _, ok := reasons[tag]; ok {
+				stale = append(stale, tag)
+			} else {
+				kept = append(kept, tag)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		if len(kept) > 0 {
+			warnf("Not pruning %s from %s/%s: digest %s is also tagged %s",
+				strings.Join(stale, ", "), targetHost, targetRepo, digest, strings.Join(kept, ", "))
+			continue
+		}
+
+		if pruneDryRun {
+			infof("Would prune %s from %s/%s (%s)", strings.Join(stale, ", "), targetHost, targetRepo, digest)
+		} else {
+			if err := target.DeleteManifest(ctx, targetRepo, digest); err != nil {
+				return err
+			}
+			infof("Pruned %s from %s/%s (%s)", strings.Join(stale, ", "), targetHost, targetRepo, digest)
+		}
+
+		for _, tag := range stale {
+			if target, ok := recorded[tag]; ok && mirrored != nil && !pruneDryRun {
+				if err := mirrored.Forget(target); err != nil {
+					warnf("Failed to remove %s from the state file: %v", target, err)
+				}
+			}
+			rep.AddPruned(report.PrunedTag{
+				Image:  fmt.Sprintf("%s/%s:%s", targetHost, targetRepo, tag),
+				Digest: digest,
+				Reason: reasons[tag],
+				DryRun: pruneDryRun,
+			})
+		}
+	}
+
+	return nil
+}
+
+// matchTag reports whether tag matches one of the glob patterns
+func matchTag(patterns []string, tag string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
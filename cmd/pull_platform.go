@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pullPlatform string
+	noRetag      bool
+)
+
+// pullWithoutRetag pulls one platform of each source under its own name, as
+// pull --no-retag does. The images are saved only when --output is given.
+func pullWithoutRetag(cmd *cobra.Command, sources []string) error {
+	switch {
+	case pullPlatform == "":
+		return fmt.Errorf("--no-retag needs --platform to name the platform to pull")
+	case allArch:
+		return fmt.Errorf("--no-retag pulls the single platform of --platform and can't be combined with --all-arch")
+	case noPull:
+		return fmt.Errorf("--no-retag and --no-pull can't be combined")
+	}
+	platform, err := registry.ParsePlatform(pullPlatform)
+	if err != nil {
+		return err
+	}
+
+	tasks, err := imageTasks(sources, nil)
+	if err != nil {
+		return err
+	}
+	if err := checkPolicy(nil, tasks); err != nil {
+		return err
+	}
+
+	var dir string
+	if cmd.Flags().Changed("output") {
+		dir = outputDir
+		release, err := acquireLocks("", []string{dir})
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	client, err := newClient(nil)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, source := range sources {
+		pulled, err := client.PullPlatform(source, platform.String(), dir, useCompression)
+		if err != nil {
+			errorf("Failed to pull %s for %s: %v", source, platform, err)
+			failed++
+			if failFast {
+				break
+			}
+			continue
+		}
+		infof("Pulled %s for %s", pulled.Reference, pulled.Platform)
+		infof("  ID:     %s", pulled.ID)
+		if pulled.Digest != "" {
+			infof("  Digest: %s", pulled.Digest)
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	code := ExitPartialFailure
+	if failed == len(sources) {
+		code = ExitFailure
+	}
+	return &exitError{code: code, err: fmt.Errorf("%d of %d images failed to pull", failed, len(sources))}
+}
@@ -0,0 +1,446 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/runs"
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pushArchiveInput      string
+	pushArchiveImage      string
+	pushArchiveAll        bool
+	pushArchiveMaxAge     string
+	pushArchiveAllowStale bool
+	pushArchiveForce      bool
+)
+
+// pushArchiveCmd pushes a saved archive to a registry through its API
+var pushArchiveCmd = &cobra.Command{
+	Use:   "push-archive --input <archive> --target <image>",
+	Short: "Push a saved archive to a registry without a docker daemon",
+	Long: `Push the image of a docker archive, OCI archive or OCI layout directory
+straight to a registry through its HTTP API, without loading it into a
+docker daemon. Compressed archives are read as they are. A dir:/path or
+oci:/path[:ref] input reads a layout skopeo copy wrote in place, after
+checking that it holds every blob it references.
+
+Blobs the target repository has already are skipped. A multi-platform
+archive is pushed as a whole: its index and the images of every platform.
+Platforms whose blobs the archive doesn't hold, as docker save leaves out
+for platforms that weren't pulled, are left out of the pushed index with a
+warning. Layers of docker archives written by docker 24 and earlier are
+pushed uncompressed, as they are stored. --squash flattens the layers of
+every image into one and --recompress converts them to gzip or zstd,
+which changes the digests of the pushed images.
+
+An input holding several images, such as a --blob-store directory, needs
+--image to select one by name, or --all with --target-prefix to push every
+image below the prefix under its own name.
+
+Before pushing, the source and digest the archive was saved from and when
+are printed, as the run index imgmigrate-runs.json next to it records them.
+An archive saved longer ago than --max-age (or max_archive_age of the --file
+configuration), or one the index doesn't record while a maximum age is set,
+is refused; --allow-stale pushes it with a warning. With --state-file, such
+as the one carried over with the archives, pushing an archive older than
+what the state records for the target under another digest requires
+--force, and every push is recorded in it.
+
+Credentials come from --username and --password or --token, the
+source_auth or registry section of the --file configuration, or docker
+login. Every target is checked against the policy of the --file
+configuration and --policy-file before anything is pushed.
+
+An --input given as an http(s) URL is downloaded to --tmpdir with the
+SHA256SUMS and imgmigrate-runs.json next to it, streaming it to disk, and
+only pushed once its checksum matches the one SHA256SUMS lists.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pushArchiveInput == "" {
+			return fmt.Errorf("--input is required")
+		}
+		if httpfile.IsURL(pushArchiveInput) {
+			local, cleanup, err := fetchArchive(context.Background(), pushArchiveInput, true)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			pushArchiveInput = local
+		}
+		var names, targets []string
+		switch {
+		case pushArchiveAll && (targetImage != "" || pushArchiveImage != ""):
+			return fmt.Errorf("--all pushes every image below --target-prefix and can't be combined with --target or --image")
+		case pushArchiveAll && targetPrefix == "":
+			return fmt.Errorf("--all requires --target-prefix")
+		case pushArchiveAll:
+			var err error
+			if names, err = archive.ImageNames(pushArchiveInput); err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				return fmt.Errorf("%s holds no named images to push", pushArchiveInput)
+			}
+			for _, name := range names {
+				target, err := prefixedTarget(targetPrefix, name)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, target)
+			}
+		case targetImage == "" || targetPrefix != "":
+			return fmt.Errorf("--target is required, or --all with --target-prefix")
+		default:
+			names, targets = []string{pushArchiveImage}, []string{targetImage}
+		}
+		var invalid []string
+		for i, target := range targets {
+			label := "target"
+			if pushArchiveAll {
+				label = names[i]
+			}
+			target, err := checkTarget(label, target)
+			if err != nil {
+				invalid = append(invalid, err.Error())
+			}
+			targets[i] = target
+		}
+		if len(invalid) > 0 {
+			return fmt.Errorf("invalid targets:\n%s", strings.Join(invalid, "\n"))
+		}
+
+		host, _, _, err := splitReference(targets[0])
+		if err != nil {
+			return err
+		}
+		cfg, err := registryConfig()
+		if err != nil {
+			return err
+		}
+		if err := checkTargetPolicy(cfg, targets); err != nil {
+			return err
+		}
+		auth, err := imageAuth(cfg, host, docker.RegistryAuth{
+			Username: username,
+			Password: redact.Secret(password),
+			Token:    redact.Secret(registryToken),
+			Insecure: insecure,
+		})
+		if err != nil {
+			return err
+		}
+		client, err := newRegistryClient(host, auth)
+		if err != nil {
+			return err
+		}
+
+		templates, err := annotationFlags()
+		if err != nil {
+			return err
+		}
+		transform, err := transformFlags()
+		if err != nil {
+			return err
+		}
+
+		saved, err := archiveRecord(cfg)
+		if err != nil {
+			return err
+		}
+		var mirrored *state.File
+		if stateFile != "" {
+			if mirrored, err = state.Open(stateFile); err != nil {
+				return err
+			}
+		}
+
+		failed := 0
+		for i, name := range names {
+			if err := pushArchive(client, name, targets[i], templates, transform, saved, mirrored); err != nil {
+				if len(names) == 1 {
+					return err
+				}
+				errorf("%v", err)
+				failed++
+				if failFast {
+					break
+				}
+			}
+		}
+		if failed == 0 {
+			return nil
+		}
+		code := ExitPartialFailure
+		if failed == len(names) {
+			code = ExitFailure
+		}
+		return &exitError{code: code, err: fmt.Errorf("%d of %d images failed to push", failed, len(names))}
+	},
+}
+
+// checkTargetPolicy checks the targets against the policy of cfg, which
+// may be nil, and the one given with --policy-file before anything is
+// pushed
+func checkTargetPolicy(cfg *config.Config, targets []string) error {
+	policies, err := loadPolicies(cfg)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, target := range targets {
+		for _, policy := range policies {
+			if err := policy.CheckTarget(target); err != nil {
+				errs = append(errs, err.Error())
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("policy violation:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// archiveRecord prints where --input was saved from and when, as the run
+// index next to it records, and returns the record, or nil when there is
+// none. It fails when the archive is older than --max-age or
+// max_archive_age of cfg, which may be nil, unless --allow-stale is set.
+func archiveRecord(cfg *config.Config) (*runs.Saved, error) {
+	var maxAge time.Duration
+	if pushArchiveMaxAge != "" {
+		var err error
+		if maxAge, err = config.ParseDuration(pushArchiveMaxAge); err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("invalid --max-age %q", pushArchiveMaxAge)
+		}
+	} else if cfg != nil {
+		var err error
+		if maxAge, err = cfg.ArchiveMaxAge(); err != nil {
+			return nil, err
+		}
+	}
+
+	saved, err := runs.Find(pushArchiveInput)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		if maxAge > 0 {
+			err := fmt.Errorf("%s isn't recorded in the %s next to it, so its age can't be checked against --max-age %s", pushArchiveInput, runs.IndexFile, formatAge(maxAge))
+			if !pushArchiveAllowStale {
+				return nil, fmt.Errorf("%v; --allow-stale pushes it anyway", err)
+			}
+			warnf("%v", err)
+		}
+		return nil, nil
+	}
+
+	age := time.Since(saved.SavedAt)
+	fmt.Fprintf(stdout, "Archive: %s\n", pushArchiveInput)
+	fmt.Fprintf(stdout, "  Source: %s\n", valueOr(saved.Source, "(not recorded)"))
+	if saved.SourceDigest != "" {
+		fmt.Fprintf(stdout, "  Digest: %s\n", saved.SourceDigest)
+	}
+	fmt.Fprintf(stdout, "  Saved:  %s (%s ago)\n", saved.SavedAt.Local().Format(time.RFC1123), formatAge(age))
+	if maxAge > 0 && age > maxAge {
+		err := fmt.Errorf("%s was saved %s ago, longer ago than --max-age %s", pushArchiveInput, formatAge(age), formatAge(maxAge))
+		if !pushArchiveAllowStale {
+			return nil, fmt.Errorf("%v; --allow-stale pushes it anyway", err)
+		}
+		warnf("%v", err)
+	}
+	return saved, nil
+}
+
+// formatAge formats the age of an archive in days once it is older than two
+func formatAge(age time.Duration) string {
+	if age >= 48*time.Hour {
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	}
+	return age.Round(time.Minute).String()
+}
+
+// checkDowngrade fails when mirrored records content of target that was
+// read from its source after the archive was saved, under another digest,
+// unless --force is set
+func checkDowngrade(mirrored *state.File, saved *runs.Saved, target string) error {
+	if mirrored == nil || saved == nil {
+		return nil
+	}
+	for _, entry := range mirrored.Entries() {
+		if imageref.Key(entry.Target) != imageref.Key(target) || entry.Digest == saved.SourceDigest || !entry.ContentTime().After(saved.SavedAt) {
+			continue
+		}
+		err := fmt.Errorf("%s already holds %s@%s from %s, newer than %s saved %s",
+			target, entry.Source, entry.Digest, entry.ContentTime().Local().Format(time.RFC1123),
+			pushArchiveInput, saved.SavedAt.Local().Format(time.RFC1123))
+		if !pushArchiveForce {
+			return fmt.Errorf("%v; --force downgrades it", err)
+		}
+		warnf("Downgrading: %v", err)
+	}
+	return nil
+}
+
+// pushArchive pushes the image of --input named name, or its only image
+// when name is empty, to target. saved is the record of the archive, if
+// any, and mirrored the --state-file, if set, which the push is checked
+// against and recorded in.
+func pushArchive(client *registry.Client, name, target string, templates map[string]string, transform archive.Transform, saved *runs.Saved, mirrored *state.File) error {
+	_, repo, tag, err := splitReference(target)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(tag, ":") {
+		return fmt.Errorf("--target %s must name a tag, not a digest", target)
+	}
+	if err := checkDowngrade(mirrored, saved, target); err != nil {
+		return err
+	}
+	pushAnnotations, err := config.RenderAnnotations(templates, config.AnnotationData{
+		Source:    pushArchiveInput,
+		Target:    target,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	input := pushArchiveInput
+	if name != "" {
+		input = fmt.Sprintf("%s (%s)", pushArchiveInput, name)
+	}
+	infof("Pushing %s to %s...", input, target)
+	pushed, err := archive.Push(context.Background(), pushArchiveInput, client, repo, tag, archive.PushOptions{
+		Image:       name,
+		Annotations: pushAnnotations,
+		Transform:   transform,
+		Progress:    pushArchiveProgress(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push %s to %s: %w", input, target, err)
+	}
+
+	kind := audit.KindImage
+	if len(pushed.Platforms) > 1 {
+		kind = audit.KindManifest
+	}
+	err = recordPush(audit.Entry{
+		Kind:         kind,
+		Source:       valueOr(name, pushArchiveInput),
+		SourceDigest: pushed.ArchiveDigest,
+		Archives:     []string{pushArchiveInput},
+		Target:       target,
+		TargetDigest: pushed.Digest,
+	})
+	if err != nil {
+		return err
+	}
+	if mirrored != nil {
+		entry := state.Entry{
+			Source:     valueOr(name, pushArchiveInput),
+			Target:     target,
+			Digest:     pushed.ArchiveDigest,
+			MirroredAt: time.Now().UTC(),
+		}
+		if saved != nil {
+			entry.Source = valueOr(saved.Source, entry.Source)
+			entry.Digest = valueOr(saved.SourceDigest, entry.Digest)
+			entry.SavedAt = saved.SavedAt
+		}
+		if err := mirrored.Record(entry); err != nil {
+			warnf("Failed to record %s in the state file: %v", target, err)
+		}
+	}
+
+	if len(pushed.Missing) > 0 {
+		warnf("Left out platforms %s, whose images %s doesn't hold", strings.Join(pushed.Missing, ", "), input)
+	}
+	platforms := make([]string, 0, len(pushed.Platforms))
+	for _, platform := range pushed.Platforms {
+		platforms = append(platforms, platform.String())
+	}
+	for _, transformed := range pushed.Transformed {
+		infof("Transformed the layers of %s from %d layers (%s) to %d layers (%s)", transformed.Platform,
+			transformed.LayersBefore, progress.FormatBytes(transformed.SizeBefore),
+			transformed.LayersAfter, progress.FormatBytes(transformed.SizeAfter))
+	}
+	infof("Uploaded %d blobs (%s), skipped %d (%s) the registry already had", pushed.Uploaded,
+		progress.FormatBytes(pushed.Bytes), pushed.Skipped, progress.FormatBytes(pushed.SkippedBytes))
+	infof("Pushed %s (%s) to %s@%s", input, strings.Join(platforms, ", "), target, pushed.Digest)
+	if (len(pushAnnotations) > 0 || !transform.IsZero()) && pushed.Digest != pushed.ArchiveDigest {
+		infof("Adding annotations or transforming layers changed the digest of the image from %s in the archive", pushed.ArchiveDigest)
+	}
+	return nil
+}
+
+// pushArchiveProgress returns the progress callback of push-archive: a
+// bar per blob on a terminal, a line per blob otherwise
+func pushArchiveProgress() func(archive.PushProgress) {
+	bars := make(map[string]*progress.Bar)
+	return func(p archive.PushProgress) {
+		short := strings.TrimPrefix(p.Blob.Digest, "sha256:")
+		if len(short) > 12 {
+			short = short[:12]
+		}
+		if renderer == nil {
+			switch {
+			case p.Exists:
+				infof("Blob %s already exists", short)
+			case p.Done:
+				infof("Uploaded blob %s (%s)", short, progress.FormatBytes(p.Blob.Size))
+			}
+			return
+		}
+
+		bar, ok := bars[p.Blob.Digest]
+		if !ok {
+			bar = renderer.AddBar(short)
+			bars[p.Blob.Digest] = bar
+		}
+		if p.Done {
+			bar.Remove()
+			delete(bars, p.Blob.Digest)
+			return
+		}
+		bar.Set("push", p.Bytes, p.Blob.Size)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(pushArchiveCmd)
+
+	pushArchiveCmd.Flags().StringVarP(&pushArchiveInput, "input", "i", "", "Docker archive, OCI archive or OCI layout directory to push, a dir: or oci: layout skopeo wrote, or the http(s) URL of an archive")
+	pushArchiveCmd.Flags().StringVarP(&targetImage, "target", "t", "", "Target image name with tag")
+	pushArchiveCmd.Flags().StringVar(&pushArchiveImage, "image", "", "Name of the image to push from an input holding several, such as a blob store")
+	pushArchiveCmd.Flags().BoolVar(&pushArchiveAll, "all", false, "Push every named image of the input below --target-prefix")
+	pushArchiveCmd.Flags().StringVar(&targetPrefix, "target-prefix", "", "Registry and namespace to push every image below with --all, keeping its repository path and tag")
+	pushArchiveCmd.Flags().BoolVar(&failFast, "fail-fast", false, "With --all, stop at the first image that fails to push")
+	pushArchiveCmd.Flags().StringVarP(&username, "username", "u", "", "Username for registry authentication")
+	pushArchiveCmd.Flags().StringVarP(&password, "password", "p", "", "Password for registry authentication")
+	pushArchiveCmd.Flags().StringVar(&registryToken, "token", "", "Registry bearer token used instead of a username and password (e.g., a CI job token)")
+	pushArchiveCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure registry connections")
+	pushArchiveCmd.Flags().StringVarP(&configFile, "file", "f", "", "Configuration file providing registry credentials and policy")
+	pushArchiveCmd.Flags().StringVar(&policyFile, "policy-file", "", "Path to a central registry policy file")
+	pushArchiveCmd.Flags().StringVar(&pushArchiveMaxAge, "max-age", "", "Refuse archives saved longer ago than this, e.g. 720h or 30d (overrides max_archive_age)")
+	pushArchiveCmd.Flags().BoolVar(&pushArchiveAllowStale, "allow-stale", false, "Push archives older than --max-age, or whose age is unknown, with a warning")
+	pushArchiveCmd.Flags().StringVar(&stateFile, "state-file", "", "State file recording what the targets hold, checked to refuse downgrades and updated after every push")
+	pushArchiveCmd.Flags().BoolVar(&pushArchiveForce, "force", false, "Push an archive older than what --state-file records for the target")
+	addAnnotationFlag(pushArchiveCmd, "the pushed index and manifests")
+	addTransformFlags(pushArchiveCmd)
+	addNormalizeNamesFlag(pushArchiveCmd)
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/ecr"
+	"github.com/Fr000g/ImgMigrate/pkg/provision"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+var (
+	registryCA   string
+	registryCert string
+	registryKey  string
+
+	// logout makes commands log out of the registries they logged in to
+	logout bool
+	// keepIntermediate keeps the local tags of saved platforms after a run
+	keepIntermediate bool
+
+	// configTLS holds the TLS settings of the loaded configuration by
+	// registry host
+	configTLS map[string]config.TLSConfig
+	// configProxies holds the proxy overrides of the loaded configuration
+	// by registry host
+	configProxies map[string]string
+)
+
+// useConfigRegistries applies the per-registry settings of a configuration.
+// Its TLS settings take precedence over the --registry-ca, --registry-cert
+// and --registry-key flags for the registries it lists, and its proxies
+// over the proxy environment variables.
+func useConfigRegistries(cfg *config.Config) {
+	configTLS = make(map[string]config.TLSConfig)
+	for host, settings := range cfg.TLS {
+		configTLS[registry.NormalizeHost(host)] = settings
+	}
+	if cfg.Registry != nil && cfg.Registry.URL != "" && cfg.Registry.TLSConfig != (config.TLSConfig{}) {
+		configTLS[registry.NormalizeHost(cfg.Registry.URL)] = cfg.Registry.TLSConfig
+	}
+
+	configProxies = make(map[string]string)
+	for host, proxy := range cfg.Proxies {
+		configProxies[registry.NormalizeHost(host)] = proxy
+	}
+	if cfg.Registry != nil && cfg.Registry.URL != "" && cfg.Registry.Proxy != "" {
+		configProxies[registry.NormalizeHost(cfg.Registry.URL)] = cfg.Registry.Proxy
+	}
+}
+
+// registryProxy returns the proxy setting for a registry host: the
+// configuration's override, or empty to use the proxy environment variables
+func registryProxy(host string) string {
+	return configProxies[host]
+}
+
+// registryTLS returns the TLS options for a registry host, from the
+// configuration when it lists the host and from the flags otherwise
+func registryTLS(host string) registry.TLSOptions {
+	if settings, ok := configTLS[host]; ok {
+		return registry.TLSOptions{
+			CAFile:     settings.CAFile,
+			CertFile:   settings.CertFile,
+			KeyFile:    settings.KeyFile,
+			SkipVerify: settings.SkipVerify,
+		}
+	}
+	return registry.TLSOptions{CAFile: registryCA, CertFile: registryCert, KeyFile: registryKey}
+}
+
+// configAuth returns the registry auth of a configuration's registry
+// section, which may be nil
+func configAuth(cfg *config.RegistryConfig) (docker.RegistryAuth, error) {
+	if cfg == nil {
+		return docker.RegistryAuth{}, nil
+	}
+	auth := credentialAuth(registry.NormalizeHost(cfg.URL), cfg.CredentialConfig)
+	auth.URL = cfg.URL
+	auth.Insecure = cfg.Insecure
+
+	if cfg.AuthProvider == config.AuthProviderECR {
+		source, err := ecr.NewTokenSource(registry.NormalizeHost(cfg.URL), cfg.Region)
+		if err != nil {
+			return auth, err
+		}
+		auth.Provider = source
+	}
+	return auth, nil
+}
+
+// registryProvider returns the provider of the registry section's type, or
+// nil when the section has none. It uses the api_auth credentials, or those
+// of the registry.
+func registryProvider(cfg *config.RegistryConfig) (provision.Provider, error) {
+	if cfg == nil || cfg.Type == "" {
+		return nil, nil
+	}
+	host := registry.NormalizeHost(cfg.URL)
+	creds := cfg.CredentialConfig
+	if cfg.APIAuth != nil {
+		creds = *cfg.APIAuth
+	}
+	auth := credentialAuth(host, creds)
+	opts := provision.Options{
+		APIURL:      cfg.APIURL,
+		Credentials: registry.Credentials{Username: auth.Username, Password: auth.Password.Reveal()},
+		Insecure:    cfg.Insecure,
+		TLS:         registryTLS(host),
+		Proxy:       registryProxy(host),
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = "https://" + host
+	}
+	if auth.Provider != nil {
+		resolved, err := auth.Provider.Credentials(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		opts.Credentials = resolved
+	}
+	return provision.New(cfg.Type, opts)
+}
+
+// targetProjects returns the projects of the targets on the registry of the
+// registry section, in task order
+func targetProjects(cfg *config.Config) []string {
+	host := registry.NormalizeHost(cfg.Registry.URL)
+	var projects []string
+	for _, task := range cfg.ImageTask {
+		named, err := reference.ParseNormalizedNamed(task.Target)
+		if err != nil || reference.Domain(named) != host {
+			continue
+		}
+		if project := provision.Project(reference.Path(named)); !slices.Contains(projects, project) {
+			projects = append(projects, project)
+		}
+	}
+	return projects
+}
+
+// ensureProjects checks that the projects of the targets on the configured
+// registry exist before a run, creating them when ensure_project is set
+func ensureProjects(cfg *config.Config) error {
+	provider, err := registryProvider(cfg.Registry)
+	if err != nil || provider == nil {
+		return err
+	}
+	created, err := provision.Ensure(context.Background(), provider, targetProjects(cfg), cfg.Registry.EnsureProject, cfg.Registry.PublicProjects)
+	for _, project := range created {
+		infof("Created the project %s on %s", project, cfg.Registry.URL)
+	}
+	if err != nil {
+		return fmt.Errorf("registry %s: %w", cfg.Registry.URL, err)
+	}
+	return nil
+}
+
+// sourceAuth returns the configured credentials of source registries by host
+func sourceAuth(cfg *config.Config) map[string]docker.RegistryAuth {
+	auth := make(map[string]docker.RegistryAuth, len(cfg.SourceAuth))
+	for host, creds := range cfg.SourceAuth {
+		host = registry.NormalizeHost(host)
+		auth[host] = credentialAuth(host, creds)
+	}
+	return auth
+}
+
+// registryConfig loads the --file configuration of commands that only read
+// credentials and registry settings from it. It returns nil without --file.
+func registryConfig() (*config.Config, error) {
+	if configFile == "" {
+		return nil, nil
+	}
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, loadError(fmt.Errorf("failed to load config: %w", err))
+	}
+	useConfigRegistries(cfg)
+	return cfg, nil
+}
+
+// imageAuth returns the auth for accessing images on host: the credentials
+// of flags when it has a username or token, otherwise the source_auth
+// entry or the registry section for host of cfg, which may be nil. Without
+// any of them newRegistryClient falls back to the credentials stored by
+// docker login.
+func imageAuth(cfg *config.Config, host string, flags docker.RegistryAuth) (docker.RegistryAuth, error) {
+	flags.URL = host
+	if cfg == nil || flags.Username != "" || flags.Token != "" {
+		return flags, nil
+	}
+
+	for configured, creds := range cfg.SourceAuth {
+		if registry.NormalizeHost(configured) == host {
+			auth := credentialAuth(host, creds)
+			auth.URL = host
+			auth.Insecure = flags.Insecure
+			return auth, nil
+		}
+	}
+	if cfg.Registry != nil && registry.NormalizeHost(cfg.Registry.URL) == host {
+		auth, err := configAuth(cfg.Registry)
+		if err != nil {
+			return flags, err
+		}
+		auth.Insecure = auth.Insecure || flags.Insecure
+		return auth, nil
+	}
+	return flags, nil
+}
+
+// credentialAuth converts configured credentials to a registry auth,
+// running password_cmd or auth_cmd when the credentials are first needed
+func credentialAuth(host string, creds config.CredentialConfig) docker.RegistryAuth {
+	redact.Register(creds.Password, creds.Token, creds.IdentityToken)
+	auth := docker.RegistryAuth{
+		Username:      creds.Username,
+		Password:      redact.Secret(creds.Password),
+		Token:         redact.Secret(creds.Token),
+		IdentityToken: redact.Secret(creds.IdentityToken),
+	}
+	if creds.PasswordCmd != "" {
+		auth.Provider = registry.NewPasswordCommand(host, creds.Username, creds.PasswordCmd)
+	} else if creds.AuthCmd != "" {
+		auth.Provider = registry.NewAuthCommand(host, creds.AuthCmd)
+	}
+	return auth
+}
+
+// logoutRegistries logs out of the registries client logged in to when
+// --logout is given
+func logoutRegistries(client *docker.Client) {
+	if !logout {
+		return
+	}
+	if err := client.Logout(); err != nil {
+		warnf("%v", err)
+	}
+}
+
+// removeIntermediate removes the local tags the run created for saved
+// platforms, unless --keep-intermediate is given, which records them for gc
+func removeIntermediate(client *docker.Client) {
+	if keepIntermediate {
+		if err := client.KeepIntermediate(); err != nil {
+			warnf("%v", err)
+		}
+		return
+	}
+	if err := client.RemoveIntermediate(); err != nil {
+		warnf("%v", err)
+	}
+}
+
+// newRegistryClient creates a registry API client for host. The explicit
+// credentials are used when they belong to host, otherwise those stored by
+// docker login, which are read again if the registry rejects them.
+func newRegistryClient(host string, auth docker.RegistryAuth) (*registry.Client, error) {
+	ownsHost := auth.URL != "" && registry.NormalizeHost(auth.URL) == host
+
+	opts := registry.Options{Insecure: ownsHost && auth.Insecure, TLS: registryTLS(host), Proxy: registryProxy(host), Limiter: limiter}
+	explicit := auth.Username != "" || auth.Token != "" || auth.IdentityToken != ""
+	if auth.Provider != nil && ownsHost {
+		creds, err := auth.Provider.Credentials(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		opts.Credentials = creds
+		opts.Resolve = func() (registry.Credentials, error) {
+			auth.Provider.Invalidate()
+			return auth.Provider.Credentials(context.Background())
+		}
+	} else if explicit && ownsHost {
+		opts.Credentials = registry.Credentials{
+			Username:      auth.Username,
+			Password:      auth.Password.Reveal(),
+			IdentityToken: auth.IdentityToken.Reveal(),
+			RegistryToken: auth.Token.Reveal(),
+		}
+	} else {
+		opts.Credentials, _ = registry.StoredCredentials(host)
+		opts.Resolve = func() (registry.Credentials, error) {
+			creds, _ := registry.StoredCredentials(host)
+			return creds, nil
+		}
+	}
+	return registry.NewClient(host, opts)
+}
+
+// targetAuth returns auth for the target registry host, which the
+// credentials of flags belong to when --registry doesn't name another
+func targetAuth(auth docker.RegistryAuth, host string) docker.RegistryAuth {
+	if auth.URL == "" {
+		auth.URL = host
+	}
+	return auth
+}
+
+// splitReference splits an image reference into its registry host,
+// repository path and digest or tag, defaulting the tag to latest
+func splitReference(image string) (host, repo, ref string, err error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid image reference %q: %v", image, err)
+	}
+
+	ref = "latest"
+	if digested, ok := named.(reference.Digested); ok {
+		ref = digested.Digest().String()
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		ref = tagged.Tag()
+	}
+	return reference.Domain(named), reference.Path(named), ref, nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportDiffHistory string
+	reportDiffOutput  string
+)
+
+// reportCmd groups the commands reading run reports
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Work with the JSON reports of runs",
+}
+
+// reportDiffCmd compares two run reports
+var reportDiffCmd = &cobra.Command{
+	Use:   "diff [<old> <new>]",
+	Short: "Compare the reports of two runs",
+	Long: `Compare the JSON reports of two runs, as written by --report or kept by
+--report-history: the images added and removed, the images whose pushed or
+platform digests changed, and the tasks that newly fail or were fixed.
+Tasks are matched by the image they push, or save without a target.
+
+Without arguments, the two newest reports of the --history directory are
+compared. Only the reports are read, no registry is contacted.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 && len(args) != 2 {
+			return fmt.Errorf("accepts 0 or 2 reports, received %d", len(args))
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reportDiffOutput != "text" && reportDiffOutput != "json" {
+			return fmt.Errorf("unsupported output format %q, use text or json", reportDiffOutput)
+		}
+		if len(args) == 0 {
+			if reportDiffHistory == "" {
+				return fmt.Errorf("give two reports or a --history directory")
+			}
+			reports, err := report.History(reportDiffHistory)
+			if err != nil {
+				return err
+			}
+			if len(reports) < 2 {
+				return fmt.Errorf("%s holds %d reports, two are needed", reportDiffHistory, len(reports))
+			}
+			args = reports[len(reports)-2:]
+		}
+
+		old, err := report.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		new, err := report.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+
+		comparison := report.Compare(args[0], old, args[1], new)
+		out := cmd.OutOrStdout()
+		if reportDiffOutput == "json" {
+			return printJSON(out, comparison)
+		}
+		comparison.Print(out)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportDiffCmd)
+
+	reportDiffCmd.Flags().StringVar(&reportDiffHistory, "history", "", "Report history directory whose two newest reports are compared without arguments")
+	reportDiffCmd.Flags().StringVarP(&reportDiffOutput, "output", "o", "text", "Output format: text or json")
+}
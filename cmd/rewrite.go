@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/Fr000g/ImgMigrate/pkg/rewrite"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	rwFiles       []string
+	rwReport      string
+	rwMappingFile string
+	rwOutputDir   string
+	rwKustomize   string
+)
+
+// manifestFile is a manifest to rewrite and the path of its copy below
+// --output-dir
+type manifestFile struct {
+	path, copy string
+}
+
+// mirrorMap maps images to their mirrors pinned to their digests
+type mirrorMap struct {
+	// mirrors maps the canonical form of an image to its mirror
+	mirrors map[string]string
+	// digests caches the digests looked up for mirrors, empty for those
+	// that failed
+	digests map[string]string
+	clients map[string]*registry.Client
+	auth    docker.RegistryAuth
+}
+
+// rewriteManifestsCmd points the images of Kubernetes manifests at their mirrors
+var rewriteManifestsCmd = &cobra.Command{
+	Use:   "rewrite-manifests",
+	Short: "Point the images of Kubernetes manifests at their mirrors",
+	Long: `Replace the image of every container, init container and ephemeral container
+in the Kubernetes manifests --file names, or finds below the directories it
+names, with its mirror pinned to the mirrored digest. Mirrors are taken from
+the JSON report of a run given with --from-report, a --mapping-file as
+from-dockerfile writes it, and the --target-prefix rule, in that order;
+mirrors without a known digest are resolved in their registry.
+
+--output-dir writes rewritten copies of the manifests, replacing only the image
+values so formatting and comments are kept, and --kustomize writes the images
+entries of a kustomization instead. Images without a mirror are left as they
+are and listed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(rwFiles) == 0 {
+			return fmt.Errorf("at least one manifest file or directory is required")
+		}
+		if err := loadAliases(); err != nil {
+			return err
+		}
+		if rwReport == "" && rwMappingFile == "" && targetPrefix == "" {
+			return fmt.Errorf("at least one of --from-report, --mapping-file and --target-prefix is required")
+		}
+		if rwOutputDir == "" && rwKustomize == "" {
+			return fmt.Errorf("--output-dir or --kustomize is required")
+		}
+
+		mirrors := &mirrorMap{
+			mirrors: make(map[string]string),
+			digests: make(map[string]string),
+			clients: make(map[string]*registry.Client),
+			auth: docker.RegistryAuth{
+				Username: username,
+				Password: redact.Secret(password),
+				Token:    redact.Secret(registryToken),
+				URL:      registryURL,
+				Insecure: insecure,
+			},
+		}
+		if rwReport != "" {
+			if err := mirrors.loadReport(rwReport); err != nil {
+				return err
+			}
+		}
+		if rwMappingFile != "" {
+			if err := mirrors.loadMapping(rwMappingFile); err != nil {
+				return err
+			}
+		}
+
+		files, err := manifestFiles(rwFiles)
+		if err != nil {
+			return err
+		}
+
+		mapped := make(map[string]string)
+		unmapped := make(map[string][]string)
+		var order []string
+		rewritten := 0
+		for _, file := range files {
+			data, err := os.ReadFile(file.path)
+			if err != nil {
+				return err
+			}
+			out, left, err := rewrite.Rewrite(data, func(image string) (string, bool) {
+				mirror, ok := mirrors.mirror(image)
+				if ok {
+					mapped[image] = mirror
+				}
+				return mirror, ok
+			})
+			if err != nil {
+				warnf("Copying %s unchanged: %v", file.path, err)
+				out, left = data, nil
+			}
+			for _, image := range left {
+				if _, ok := unmapped[image.Reference]; !ok {
+					order = append(order, image.Reference)
+				}
+				unmapped[image.Reference] = append(unmapped[image.Reference], fmt.Sprintf("%s:%d", file.path, image.Line))
+			}
+			if rwOutputDir == "" {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(file.copy), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(file.copy, out, 0644); err != nil {
+				return fmt.Errorf("error writing manifest: %v", err)
+			}
+			if !slices.Equal(out, data) {
+				rewritten++
+			}
+		}
+
+		if len(order) > 0 {
+			warnf("Left %d images without a mirror unchanged:", len(order))
+			for _, image := range order {
+				warnf("  %s (%s)", image, strings.Join(unmapped[image], ", "))
+			}
+		}
+		if rwOutputDir != "" {
+			infof("Rewrote %d images in %d of %d manifests, written to %s", len(mapped), rewritten, len(files), rwOutputDir)
+		}
+		if rwKustomize != "" {
+			images, warnings := rewrite.Kustomize(mapped)
+			for _, warning := range warnings {
+				warnf("Kustomize: %s", warning)
+			}
+			if err := writeKustomizeImages(rwKustomize, images); err != nil {
+				return err
+			}
+			infof("Kustomize images of %d image names written to %s", len(images), rwKustomize)
+		}
+		return nil
+	},
+}
+
+// manifestFiles returns the files named and the YAML files below the
+// directories named, with the paths of their copies below --output-dir
+func manifestFiles(paths []string) ([]manifestFile, error) {
+	var files []manifestFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, manifestFile{path: path, copy: filepath.Join(rwOutputDir, filepath.Base(path))})
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (filepath.Ext(file) != ".yaml" && filepath.Ext(file) != ".yml") {
+				return nil
+			}
+			rel, err := filepath.Rel(path, file)
+			if err != nil {
+				return err
+			}
+			files = append(files, manifestFile{path: file, copy: filepath.Join(rwOutputDir, rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// loadReport adds the targets of the tasks of a run report that pushed
+// them, with the digests they pushed
+func (m *mirrorMap) loadReport(file string) error {
+	rep, err := report.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for _, task := range rep.Tasks {
+		result := task.Result
+		if result == nil || result.Target == "" || task.Status == report.StatusFailed || task.Status == report.StatusDeferred {
+			continue
+		}
+		mirror := result.Target
+		if digest := result.PushedDigest(result.Target); digest != "" {
+			mirror += "@" + digest
+		}
+		m.mirrors[imageref.Key(result.Source)] = mirror
+	}
+	return nil
+}
+
+// loadMapping adds the mirrors of a mapping file of tab-separated lines of
+// image and mirror, as from-dockerfile writes it; images the report mapped
+// keep their mirror
+func (m *mirrorMap) loadMapping(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("error reading mapping file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		image, mirror, ok := strings.Cut(text, "\t")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected an image and its mirror separated by a tab", file, line)
+		}
+		key := imageref.Key(resolveAlias(strings.TrimSpace(image)))
+		if _, ok := m.mirrors[key]; !ok {
+			m.mirrors[key] = strings.TrimSpace(mirror)
+		}
+	}
+	return scanner.Err()
+}
+
+// mirror returns the mirror of an image pinned to its digest, if it has one.
+// An image pinned by digest also maps by its tag alone, and without other
+// mirrors to the same path below --target-prefix, except for images already
+// below it.
+func (m *mirrorMap) mirror(image string) (string, bool) {
+	image = resolveAlias(image)
+	mirror, ok := m.mirrors[imageref.Key(image)]
+	if !ok {
+		tagged, _, _ := strings.Cut(image, "@")
+		mirror, ok = m.mirrors[imageref.Key(tagged)]
+		if !ok && targetPrefix != "" {
+			named, err := imageref.Parse(tagged)
+			if err != nil || strings.HasPrefix(named.Name(), strings.TrimSuffix(targetPrefix, "/")+"/") {
+				return "", false
+			}
+			if mirror, err = prefixedTarget(targetPrefix, tagged); err != nil {
+				return "", false
+			}
+			ok = true
+		}
+	}
+	if !ok {
+		return "", false
+	}
+	if strings.Contains(mirror, "@") {
+		return mirror, true
+	}
+	if digest := m.digest(mirror); digest != "" {
+		mirror += "@" + digest
+	}
+	return mirror, true
+}
+
+// digest looks up the digest of a mirror in its registry once, warning
+// when it fails
+func (m *mirrorMap) digest(mirror string) string {
+	if digest, ok := m.digests[mirror]; ok {
+		return digest
+	}
+	m.digests[mirror] = ""
+	host, repo, ref, err := splitReference(mirror)
+	if err != nil {
+		warnf("Not pinning %s: %v", mirror, err)
+		return ""
+	}
+	client, ok := m.clients[host]
+	if !ok {
+		if client, err = newRegistryClient(host, m.auth); err != nil {
+			warnf("Not pinning %s: %v", mirror, err)
+			return ""
+		}
+		m.clients[host] = client
+	}
+	digest, err := client.Digest(context.Background(), repo, ref)
+	if err != nil {
+		warnf("Not pinning %s: %v", mirror, err)
+		return ""
+	}
+	m.digests[mirror] = digest
+	return digest
+}
+
+// writeKustomizeImages writes the images entries of a kustomization
+func writeKustomizeImages(file string, images []rewrite.KustomizeImage) error {
+	data, err := yaml.Marshal(struct {
+		Images []rewrite.KustomizeImage `yaml:"images"`
+	}{images})
+	if err != nil {
+		return err
+	}
+	data = append([]byte("# Images of kustomization.yaml, generated by imgMigrate rewrite-manifests\n"), data...)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return fmt.Errorf("error writing kustomize images: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(rewriteManifestsCmd)
+
+	rewriteManifestsCmd.Flags().StringArrayVarP(&rwFiles, "file", "f", nil, "Kubernetes manifest, or directory of .yaml and .yml manifests, to rewrite (repeatable)")
+	rewriteManifestsCmd.Flags().StringVar(&rwReport, "from-report", "", "JSON report of a run whose pushed targets and digests are the mirrors")
+	rewriteManifestsCmd.Flags().StringVar(&rwMappingFile, "mapping-file", "", "Tab-separated lines of image and mirror, as from-dockerfile --mapping-file writes them")
+	rewriteManifestsCmd.Flags().StringVar(&targetPrefix, "target-prefix", "", "Map other images below this registry and namespace, keeping their repository path and tag")
+	rewriteManifestsCmd.Flags().StringVarP(&rwOutputDir, "output-dir", "o", "", "Write the rewritten manifests to this directory, keeping their paths below the directories given")
+	addAliasesFlag(rewriteManifestsCmd)
+	rewriteManifestsCmd.Flags().StringVar(&rwKustomize, "kustomize", "", "Write the images entries of a kustomization pointing at the mirrors to this file")
+	addTargetAuthFlags(rewriteManifestsCmd)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rmYes      bool
+	rmChildren bool
+)
+
+// rmRemoteCmd deletes a tag from a remote registry
+var rmRemoteCmd = &cobra.Command{
+	Use:   "rm-remote <image>",
+	Short: "Delete an image tag or digest from a registry",
+	Long: `Resolve a tag to its manifest digest and delete the manifest through the
+registry API. Registries delete manifests by digest, so every other tag
+pointing to the same digest is removed as well.
+
+For a multi-arch manifest list, --children also deletes the per-platform
+manifests it references. Only use it when no other tag or list shares them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host, repo, ref, err := splitReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		auth := docker.RegistryAuth{Username: username, Password: redact.Secret(password), URL: host, Insecure: insecure}
+		if configFile != "" && username == "" {
+			cfg, err := config.LoadConfig(configFile)
+			if err != nil {
+				return loadError(fmt.Errorf("failed to load config: %w", err))
+			}
+			useConfigRegistries(cfg)
+			if cfg.Registry != nil {
+				if auth, err = configAuth(cfg.Registry); err != nil {
+					return err
+				}
+				auth.Insecure = auth.Insecure || insecure
+			}
+		}
+
+		ctx := context.Background()
+		client, err := newRegistryClient(host, auth)
+		if err != nil {
+			return err
+		}
+
+		manifest, err := client.Manifest(ctx, repo, ref)
+		if err != nil {
+			return err
+		}
+		digest := manifest.Digest
+		if digest == "" {
+			if digest, err = client.Digest(ctx, repo, ref); err != nil {
+				return err
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		name := fmt.Sprintf("%s/%s", host, repo)
+		fmt.Fprintf(out, "%s:%s resolves to %s\n", name, ref, digest)
+
+		var children []string
+		if rmChildren && manifest.IsList() {
+			for _, child := range manifest.Manifests {
+				children = append(children, child.Digest)
+				platform := "unknown platform"
+				if child.Platform != nil {
+					platform = child.Platform.OS + "/" + child.Platform.Architecture
+					if child.Platform.Variant != "" {
+						platform += "/" + child.Platform.Variant
+					}
+				}
+				fmt.Fprintf(out, "  %s (%s)\n", child.Digest, platform)
+			}
+		} else if manifest.IsList() {
+			fmt.Fprintf(out, "It is a manifest list of %d images; use --children to delete them too\n", len(manifest.Manifests))
+		}
+
+		if !rmYes {
+			ok, err := confirm(fmt.Sprintf("Delete %s@%s and every tag pointing to it", name, digest))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(out, "Aborted")
+				return nil
+			}
+		}
+
+		if err := client.DeleteManifest(ctx, repo, digest); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Deleted %s@%s\n", name, digest)
+
+		for _, child := range children {
+			if err := client.DeleteManifest(ctx, repo, child); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Deleted %s@%s\n", name, child)
+		}
+		return nil
+	},
+}
+
+// confirm asks a yes/no question on the terminal. Without a terminal to ask
+// on, it fails so that scripts have to pass --yes explicitly.
+func confirm(question string) (bool, error) {
+	if !progress.IsTerminal(os.Stdin) {
+		return false, fmt.Errorf("refusing to delete without confirmation, stdin is not a terminal; use --yes")
+	}
+
+	fmt.Fprintf(os.Stdout, "%s? [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, nil
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func init() {
+	rootCmd.AddCommand(rmRemoteCmd)
+
+	rmRemoteCmd.Flags().BoolVarP(&rmYes, "yes", "y", false, "Delete without asking for confirmation")
+	rmRemoteCmd.Flags().BoolVar(&rmChildren, "children", false, "Also delete the per-platform manifests of a manifest list")
+	rmRemoteCmd.Flags().StringVarP(&username, "username", "u", "", "Username for registry authentication")
+	rmRemoteCmd.Flags().StringVarP(&password, "password", "p", "", "Password for registry authentication")
+	rmRemoteCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure registry connections")
+	rmRemoteCmd.Flags().StringVarP(&configFile, "file", "f", "", "Configuration file providing registry credentials")
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+)
+
+// rollback undoes the pushes of a failed task
+type rollback struct {
+	auth    docker.RegistryAuth
+	clients map[string]*registry.Client
+	// created holds the tags the task created, by host/repo:tag
+	created map[string]bool
+	// tags holds the tags of each repository by digest, listed once it is
+	// needed, and deleted the digests already deleted, by host/repo@digest
+	tags    map[string]map[string][]string
+	deleted map[string]bool
+}
+
+// rollbackTask undoes the pushes of a failed task, newest first. A tag the
+// task created is deleted by digest, unless a tag it didn't create shares
+// the digest, as deleting a manifest removes every tag pointing to it. A tag
+// the task moved is pointed back to the manifest it pointed to before. Every
+// tag is recorded in rep, with the reason it couldn't be rolled back.
+func rollbackTask(ctx context.Context, result *docker.TaskResult, auth docker.RegistryAuth, rep *report.Report) {
+	if len(result.Pushed) == 0 {
+		return
+	}
+	warnf("Rolling back the %d tags %s pushed before it failed", len(result.Pushed), result.Source)
+
+	r := &rollback{
+		auth:    auth,
+		clients: make(map[string]*registry.Client),
+		created: make(map[string]bool),
+		tags:    make(map[string]map[string][]string),
+		deleted: make(map[string]bool),
+	}
+	for _, pushed := range result.Pushed {
+		if host, repo, tag, err := splitReference(pushed.Reference); err == nil && !pushed.PreviousUnknown && pushed.Previous == "" {
+			r.created[host+"/"+repo+":"+tag] = true
+		}
+	}
+
+	for i := len(result.Pushed) - 1; i >= 0; i-- {
+		pushed := result.Pushed[i]
+		action, err := r.undo(ctx, pushed)
+		if err != nil {
+			action = report.RollbackAction{Image: pushed.Reference, Digest: pushed.Digest, Action: report.RollbackFailed, Error: redact.String(err.Error())}
+			errorf("Failed to roll back %s: %v", pushed.Reference, err)
+		} else {
+			infof("Rolled back %s: %s", pushed.Reference, action.Action)
+		}
+		rep.AddRollback(action)
+	}
+}
+
+// undo rolls back the push of a tag
+func (r *rollback) undo(ctx context.Context, pushed docker.PushedReference) (report.RollbackAction, error) {
+	action := report.RollbackAction{Image: pushed.Reference, Digest: pushed.Digest}
+	if pushed.PreviousUnknown {
+		return action, fmt.Errorf("what it pointed to before the push is unknown")
+	}
+	host, repo, tag, err := splitReference(pushed.Reference)
+	if err != nil {
+		return action, err
+	}
+	client, err := r.client(host)
+	if err != nil {
+		return action, err
+	}
+
+	current, err := client.Digest(ctx, repo, tag)
+	if err != nil && !errors.Is(err, errdefs.ErrNotFound) {
+		return action, err
+	}
+	if pushed.Digest == "" {
+		action.Digest = current
+	}
+	if current != "" && pushed.Digest != "" && current != pushed.Digest && current != pushed.Previous {
+		return action, fmt.Errorf("it was pushed again since, and points to %s", current)
+	}
+
+	if pushed.Previous != "" {
+		action.Previous = pushed.Previous
+		if current == pushed.Previous {
+			action.Action = report.RollbackUnchanged
+			return action, nil
+		}
+		data, mediaType, _, err := client.RawManifest(ctx, repo, pushed.Previous)
+		if err != nil {
+			return action, fmt.Errorf("the manifest it pointed to before is gone: %w", err)
+		}
+		if _, err := client.PushManifest(ctx, repo, tag, mediaType, data); err != nil {
+			return action, err
+		}
+		action.Action = report.RollbackRestored
+		return action, nil
+	}
+
+	// The tag was created by the task
+	if current == "" {
+		if !r.deleted[host+"/"+repo+"@"+action.Digest] {
+			return action, fmt.Errorf("it no longer exists")
+		}
+		action.Action = report.RollbackDeleted
+		return action, nil
+	}
+	byDigest, err := r.repositoryTags(ctx, client, host, repo)
+	if err != nil {
+		return action, err
+	}
+	var others []string
+	for _, other := range byDigest[current] {
+		if !r.created[host+"/"+repo+":"+other] {
+			others = append(others, other)
+		}
+	}
+	if len(others) > 0 {
+		return action, fmt.Errorf("its digest %s is also tagged %s, which deleting it would remove", current, strings.Join(others, ", "))
+	}
+	if err := client.DeleteManifest(ctx, repo, current); err != nil {
+		return action, err
+	}
+	r.deleted[host+"/"+repo+"@"+current] = true
+	delete(byDigest, current)
+	action.Digest = current
+	action.Action = report.RollbackDeleted
+	return action, nil
+}
+
+// client returns the registry client of a host
+func (r *rollback) client(host string) (*registry.Client, error) {
+	if client, ok := r.clients[host]; ok {
+		return client, nil
+	}
+	client, err := newRegistryClient(host, targetAuth(r.auth, host))
+	if err != nil {
+		return nil, err
+	}
+	r.clients[host] = client
+	return client, nil
+}
+
+// repositoryTags returns the tags of a repository by digest
+func (r *rollback) repositoryTags(ctx context.Context, client *registry.Client, host, repo string) (map[string][]string, error) {
+	if byDigest, ok := r.tags[host+"/"+repo]; ok {
+		return byDigest, nil
+	}
+	tags, err := client.Tags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	byDigest := make(map[string][]string)
+	for _, tag := range tags {
+		digest, err := client.Digest(ctx, repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		byDigest[digest] = append(byDigest[digest], tag)
+	}
+	r.tags[host+"/"+repo] = byDigest
+	return byDigest, nil
+}
@@ -1,29 +1,77 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"runtime/debug"
+	"strings"
+	"time"
 
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/budget"
 	"github.com/Fr000g/ImgMigrate/pkg/config"
 	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/Fr000g/ImgMigrate/pkg/metrics"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+	"github.com/Fr000g/ImgMigrate/pkg/window"
 	"github.com/spf13/cobra"
 )
 
 var (
-	sourceImage      string
-	targetImage      string
-	registryURL      string
-	architectures    []string
-	operatingSystems []string
-	outputDir        string
-	allArch          bool
-	username         string
-	password         string
-	insecure         bool
-	useCompression   bool
-	configFile       string
-	generateConfig   string
-	createMultiArch  bool
+	sourceImage           string
+	sourceImages          []string
+	targetImage           string
+	targetPrefix          string
+	failFast              bool
+	registryURL           string
+	architectures         []string
+	operatingSystems      []string
+	outputDir             string
+	allArch               bool
+	username              string
+	password              string
+	registryToken         string
+	insecure              bool
+	useCompression        bool
+	compressionMode       string
+	compressionPreference string
+	compressionSample     string
+	configFile            string
+	generateConfig        string
+	configOverrides       []string
+	printConfig           bool
+	createMultiArch       bool
+	policyFile            string
+	metricsListen         string
+	metricsTextfile       string
+	watch                 bool
+	watchInterval         time.Duration
+	reportFile            string
+	reportHistory         string
+	reportHistoryKeep     int
+	stateFile             string
+	refreshState          bool
+	noCache               bool
+	cacheTTL              time.Duration
+	dockerBin             string
+	useSudo               bool
+	bandwidthLimit        string
+	tmpDir                string
+	noReproducible        bool
+	heartbeat             time.Duration
+	quietHeartbeat        bool
+	maxRetryAfter         time.Duration
+	configRemote          config.RemoteOptions
+	// runID identifies the run in local tags, the log and the report
+	runID string
+	// limiter limits the bandwidth of all transfers, set by --bandwidth-limit
+	limiter *bandwidth.Limiter
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -32,76 +80,229 @@ var rootCmd = &cobra.Command{
 	Short: "A tool for handling multi-architecture Docker images",
 	Long: `A CLI tool that can pull multi-architecture Docker images, 
 tag them differently and save them locally or push to a private registry.`,
+	// Errors are printed by Execute, so they also reach the log file
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags parsed fine, so later errors are not usage problems
+		cmd.SilenceUsage = true
+
+		if dockerBin == "" {
+			dockerBin = os.Getenv("IMG_MIGRATE_DOCKER")
+		}
+
+		runID = newRunID()
+
+		// Mask credentials given as flags in all output
+		redact.Register(password, registryToken, sourcePassword, serveToken, os.Getenv("IMG_MIGRATE_API_TOKEN"))
+
+		// Credentials for --file and --policy-file URLs
+		if configRemote.Username == "" {
+			configRemote.Username = os.Getenv("IMG_MIGRATE_CONFIG_USERNAME")
+		}
+		if configRemote.Password == "" {
+			configRemote.Password = os.Getenv("IMG_MIGRATE_CONFIG_PASSWORD")
+		}
+		if configRemote.Token == "" {
+			configRemote.Token = os.Getenv("IMG_MIGRATE_CONFIG_TOKEN")
+		}
+		redact.Register(configRemote.Password, configRemote.Token)
+		config.SetRemote(configRemote)
+
+		// Credentials for output directories and archives given as URLs
+		if httpOptions.Username == "" {
+			httpOptions.Username = os.Getenv("IMG_MIGRATE_HTTP_USERNAME")
+		}
+		if httpOptions.Password == "" {
+			httpOptions.Password = os.Getenv("IMG_MIGRATE_HTTP_PASSWORD")
+		}
+		if httpOptions.Token == "" {
+			httpOptions.Token = os.Getenv("IMG_MIGRATE_HTTP_TOKEN")
+		}
+		redact.Register(httpOptions.Password, httpOptions.Token)
+
+		if err := setupOutput(); err != nil {
+			return err
+		}
+		if err := openAudit(); err != nil {
+			return err
+		}
+
+		archive.SetReproducible(!noReproducible)
+
+		if bandwidthLimit != "" {
+			rate, err := bandwidth.ParseRate(bandwidthLimit)
+			if err != nil {
+				return err
+			}
+			limiter = bandwidth.NewLimiter(rate)
+		}
+		if windowSpec != "" {
+			w, err := window.Parse(windowSpec)
+			if err != nil {
+				return err
+			}
+			transferWindow = w
+		}
+		if maxTransfer != "" {
+			limit, err := bandwidth.ParseSize(maxTransfer)
+			if err != nil {
+				return fmt.Errorf("invalid --max-transfer: %v", err)
+			}
+			transferBudget = budget.New(limit)
+		}
+
+		if metricsListen != "" {
+			if _, err := metrics.Serve(metricsListen); err != nil {
+				return err
+			}
+			infof("Serving metrics on %s/metrics", metricsListen)
+		}
+		return nil
+	},
 }
 
 // pullCmd represents the pull command
 var pullCmd = &cobra.Command{
-	Use:   "pull",
+	Use:   "pull [image...]",
 	Short: "Pull images from DockerHub and save locally with different tags",
+	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if sourceImage == "" {
+		sources := append(sourceImages, args...)
+		if len(sources) == 0 {
 			return fmt.Errorf("source image is required")
 		}
+		if noRetag {
+			if httpfile.IsURL(outputDir) {
+				return fmt.Errorf("an --output URL can't be combined with --no-retag")
+			}
+			if blobStore != "" {
+				return fmt.Errorf("--blob-store can't be combined with --no-retag")
+			}
+			if compat != "" {
+				return fmt.Errorf("--compat can't be combined with --no-retag")
+			}
+			return pullWithoutRetag(cmd, sources)
+		}
+		if pullPlatform != "" {
+			return fmt.Errorf("--platform is only used with --no-retag; select platforms with --arch and --os")
+		}
+		if !allArch && len(architectures) == 0 {
+			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+		}
+		if err := config.ValidateRepoTags(repoTags); err != nil {
+			return err
+		}
+		if err := checkSaveFlags(); err != nil {
+			return err
+		}
+		var err error
+		if outputDir, err = stageOutput(outputDir); err != nil {
+			return err
+		}
+		defer removeStagedOutputs()
 
-		client, err := docker.NewClient()
+		tasks, err := imageTasks(sources, nil)
 		if err != nil {
-			return fmt.Errorf("failed to create docker client: %v", err)
+			return err
 		}
-
-		options := docker.SaveOptions{
-			UseCompression:   useCompression,
-			OutputDir:        outputDir,
-			OperatingSystems: operatingSystems,
-			CreateMultiArch:  createMultiArch,
+		cfg := &config.Config{ImageTask: tasks}
+		if err := checkPolicy(nil, cfg.ImageTask); err != nil {
+			return err
 		}
 
-		if allArch {
-			return client.PullAllArchitectures(sourceImage, options)
+		lockDir := outputDir
+		if blobStore != "" {
+			lockDir = blobStore
+		}
+		release, err := acquireLocks("", []string{lockDir})
+		if err != nil {
+			return err
 		}
+		defer release()
 
-		if len(architectures) == 0 {
-			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+		rep := report.New()
+		limit, err := daemonDiskLimit(nil)
+		if err != nil {
+			return err
+		}
+		client, err := newClient(rep, docker.WithDiskLimit(limit))
+		if err != nil {
+			return err
 		}
+		defer removeIntermediate(client)
 
-		return client.PullSpecificArchitectures(sourceImage, architectures, options)
+		ctx, stop := interruptible()
+		defer stop()
+		runTasks(ctx, client, cfg, docker.RegistryAuth{}, nil, rep)
+		return runStatus(rep, finishReport(rep, nil))
 	},
 }
 
 // pushCmd represents the push command
 var pushCmd = &cobra.Command{
-	Use:   "push",
+	Use:   "push [image...]",
 	Short: "Pull images from DockerHub, retag and push to private registry",
+	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if sourceImage == "" || targetImage == "" {
-			return fmt.Errorf("source and target images are required")
+		sources := append(sourceImages, args...)
+		if len(sources) == 0 {
+			return fmt.Errorf("source image is required")
 		}
-
-		client, err := docker.NewClient()
-		if err != nil {
-			return fmt.Errorf("failed to create docker client: %v", err)
+		if !allArch && len(architectures) == 0 {
+			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
 		}
 
-		auth := docker.RegistryAuth{
-			Username: username,
-			Password: password,
-			URL:      registryURL,
-			Insecure: insecure,
+		var targetFor func(string) (string, error)
+		switch {
+		case targetImage != "" && targetPrefix != "":
+			return fmt.Errorf("--target and --target-prefix can't be combined")
+		case targetImage != "":
+			if len(sources) > 1 {
+				return fmt.Errorf("--target names a single image; use --target-prefix to push %d sources", len(sources))
+			}
+			targetFor = func(string) (string, error) { return targetImage, nil }
+		case targetPrefix != "":
+			targetFor = func(source string) (string, error) { return prefixedTarget(targetPrefix, source) }
+		default:
+			return fmt.Errorf("either --target or --target-prefix is required")
 		}
 
-		options := docker.SaveOptions{
-			OperatingSystems: operatingSystems,
-			CreateMultiArch:  createMultiArch,
+		tasks, err := imageTasks(sources, targetFor)
+		if err != nil {
+			return err
+		}
+		if tasks, err = checkTargets(tasks); err != nil {
+			return err
+		}
+		cfg := &config.Config{ImageTask: tasks}
+		if err := checkPolicy(nil, cfg.ImageTask); err != nil {
+			return err
 		}
 
-		if allArch {
-			return client.PushAllArchitectures(sourceImage, targetImage, auth, options)
+		rep := report.New()
+		limit, err := daemonDiskLimit(nil)
+		if err != nil {
+			return err
 		}
+		client, err := newClient(rep, docker.WithDiskLimit(limit))
+		if err != nil {
+			return err
+		}
+		defer removeIntermediate(client)
+		defer logoutRegistries(client)
 
-		if len(architectures) == 0 {
-			return fmt.Errorf("at least one architecture must be specified if --all-arch is not used")
+		auth := docker.RegistryAuth{
+			Username: username,
+			Password: redact.Secret(password),
+			Token:    redact.Secret(registryToken),
+			URL:      registryURL,
+			Insecure: insecure,
 		}
 
-		return client.PushSpecificArchitectures(sourceImage, targetImage, architectures, auth, options)
+		ctx, stop := interruptible()
+		defer stop()
+		runTasks(ctx, client, cfg, auth, nil, rep)
+		return runStatus(rep, finishReport(rep, nil))
 	},
 }
 
@@ -123,83 +324,220 @@ var configCmd = &cobra.Command{
 			return fmt.Errorf("config file path is required")
 		}
 
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := resolveConfig()
+		if err != nil {
+			return err
+		}
+		if printConfig {
+			data, err := cfg.MarshalRedacted()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+
+		// Hold the locks for the whole run so overlapping invocations can't
+		// clash; a config fetched from a URL has no file to lock next to
+		lockPath := configFile
+		if config.IsURL(configFile) {
+			lockPath = ""
+		}
+		for i := range cfg.ImageTask {
+			if cfg.ImageTask[i].OutputDir, err = stageOutput(cfg.ImageTask[i].OutputDir); err != nil {
+				return err
+			}
+		}
+		defer removeStagedOutputs()
+		release, err := acquireLocks(lockPath, outputDirs(cfg))
 		if err != nil {
-			return fmt.Errorf("failed to load config: %v", err)
+			return err
 		}
+		defer release()
 
 		// Process each task in the configuration
-		client, err := docker.NewClient()
+		rep := report.New()
+		limit, err := daemonDiskLimit(cfg)
+		if err != nil {
+			return err
+		}
+		client, err := newClient(rep, docker.WithMirrors(cfg.Mirrors), docker.WithSourceAuth(sourceAuth(cfg)), docker.WithDiskLimit(limit))
+		if err != nil {
+			return err
+		}
+		defer removeIntermediate(client)
+		defer logoutRegistries(client)
+
+		// Registry auth is empty unless the config has a registry section
+		auth, err := configAuth(cfg.Registry)
 		if err != nil {
-			return fmt.Errorf("failed to create docker client: %v", err)
+			return err
+		}
+		if err := ensureProjects(cfg); err != nil {
+			return err
 		}
 
-		// Initialize registry auth only if registry config is provided
-		var auth docker.RegistryAuth
-		if cfg.Registry != nil {
-			auth = docker.RegistryAuth{
-				Username: cfg.Registry.Username,
-				Password: cfg.Registry.Password,
-				URL:      cfg.Registry.URL,
-				Insecure: cfg.Registry.Insecure,
+		// Watch mode always tracks what it mirrored, in memory unless a
+		// state file is given
+		var mirrored *state.File
+		if stateFile != "" || watch {
+			if mirrored, err = state.Open(stateFile); err != nil {
+				return err
 			}
 		}
 
-		for i, task := range cfg.ImageTask {
-			fmt.Printf("Processing task %d: %s\n", i+1, task.Source)
+		if watch {
+			return watchTasks(client, cfg, auth, mirrored, rep, watchInterval)
+		}
 
-			options := docker.SaveOptions{
-				UseCompression:   task.Compress,
-				OutputDir:        task.OutputDir,
-				OperatingSystems: task.OperatingSystems,
-				CreateMultiArch:  task.CreateMultiArch,
-			}
+		ctx, stop := interruptible()
+		defer stop()
+		runTasks(ctx, client, cfg, auth, mirrored, rep)
+		return runStatus(rep, finishReport(rep, nil))
+	},
+}
 
-			// Set default OS if not specified
-			if len(options.OperatingSystems) == 0 {
-				options.OperatingSystems = []string{"linux"}
-			}
+// resolveConfig loads --file with the --set overrides and resolves it the
+// way the run uses it: validated, with --output-root applied, targets
+// normalized, tasks checked against its policy and --policy-file, and
+// tasks in execution order
+func resolveConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(configFile, configOverrides...)
+	if err != nil {
+		return nil, loadError(fmt.Errorf("failed to load config: %w", err))
+	}
+	useConfigRegistries(cfg)
 
-			// Determine whether to push or save based on target and save options
-			if task.Target != "" {
-				if task.AllArchitecture {
-					err = client.PushAllArchitectures(task.Source, task.Target, auth, options)
-				} else if len(task.Architectures) > 0 {
-					err = client.PushSpecificArchitectures(task.Source, task.Target, task.Architectures, auth, options)
-				} else {
-					err = fmt.Errorf("task %d: either all_architectures must be true or architectures must be specified", i+1)
-				}
-			} else if task.Save {
-				if task.AllArchitecture {
-					err = client.PullAllArchitectures(task.Source, options)
-				} else if len(task.Architectures) > 0 {
-					err = client.PullSpecificArchitectures(task.Source, task.Architectures, options)
-				} else {
-					err = fmt.Errorf("task %d: either all_architectures must be true or architectures must be specified", i+1)
-				}
-			} else {
-				err = fmt.Errorf("task %d: either target must be specified or save must be true", i+1)
-			}
+	// Validate every task before any pull starts
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if unused := cfg.UnusedAliases(); len(unused) > 0 {
+		warnf("Aliases not used by any task: %s", strings.Join(unused, ", "))
+	}
+	if err := applyOutputRoot(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.ImageTask, err = checkTargets(cfg.ImageTask); err != nil {
+		return nil, err
+	}
+	if err := checkPolicy(cfg, cfg.ImageTask); err != nil {
+		return nil, err
+	}
+	if err := orderTasks(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-			if err != nil {
-				fmt.Printf("Error processing task %d: %v\n", i+1, err)
-				// Continue with other tasks
-				continue
-			}
+// finishReport prints the run summary and writes the JSON report if requested.
+// The run error is passed through unless writing the report fails as well.
+func finishReport(rep *report.Report, runErr error) error {
+	rep.Finish()
+	rep.ETA = estimator.Accuracy()
+	if transferBudget != nil {
+		rep.Budget = &report.TransferBudget{Limit: transferBudget.Limit(), Used: transferBudget.Used()}
+	}
+	rep.LogFile = logFile
+	rep.RunID = runID
+
+	fmt.Fprintln(stdout)
+	rep.PrintSummary(stdout)
 
-			fmt.Printf("Successfully completed task %d\n", i+1)
+	if reportFile != "" {
+		if err := rep.WriteFile(reportFile); err != nil {
+			if runErr != nil {
+				return fmt.Errorf("%v (additionally failed to write report: %v)", runErr, err)
+			}
+			return err
+		}
+		infof("Report written to %s", reportFile)
+	}
+	if reportHistory != "" {
+		path, err := rep.WriteHistory(reportHistory, reportHistoryKeep)
+		if err != nil {
+			if runErr != nil {
+				return fmt.Errorf("%v (additionally failed to write report history: %v)", runErr, err)
+			}
+			return err
 		}
+		infof("Report kept in history as %s", path)
+	}
 
-		return nil
-	},
+	return runErr
+}
+
+// checkPolicy evaluates tasks against the policy of cfg, which may be nil,
+// and the central policy given with --policy-file. The targets are checked
+// as pushed, so they are normalized first.
+func checkPolicy(cfg *config.Config, tasks []config.ImageTask) error {
+	policies, err := loadPolicies(cfg)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, policy := range policies {
+		errs = append(errs, policy.Check(tasks))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("policy violation:\n%v", err)
+	}
+
+	return nil
+}
+
+// loadPolicies returns the policy of cfg, which may be nil, and the
+// central policy given with --policy-file, those that are set
+func loadPolicies(cfg *config.Config) ([]*config.PolicyConfig, error) {
+	var policies []*config.PolicyConfig
+	if cfg != nil && cfg.Policy != nil {
+		policies = append(policies, cfg.Policy)
+	}
+	if policyFile != "" {
+		policy, err := config.LoadPolicy(policyFile)
+		if err != nil {
+			return nil, loadError(fmt.Errorf("failed to load policy: %w", err))
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// Record panics in the log file before it is closed
+	defer func() {
+		if r := recover(); r != nil {
+			stopProgress()
+			logger.Error(fmt.Sprintf("panic: %v", r), "stack", string(debug.Stack()))
+			closeAudit()
+			closeEvents()
+			closeLog()
+			panic(r)
+		}
+	}()
+
+	err := rootCmd.Execute()
+	stopProgress()
+
+	// Write final metric values for one-shot runs, even when the run failed
+	if metricsTextfile != "" {
+		if writeErr := metrics.Default.WriteTextfile(metricsTextfile); writeErr != nil {
+			errorf("Failed to write metrics textfile: %v", writeErr)
+		}
+	}
+
+	if err != nil {
+		errorf("Error: %v", err)
+		closeAudit()
+		closeEvents()
+		closeLog()
+		os.Exit(exitCode(err))
 	}
+	closeAudit()
+	closeEvents()
+	closeLog()
 }
 
 func init() {
@@ -207,33 +545,97 @@ func init() {
 	rootCmd.AddCommand(pushCmd)
 	rootCmd.AddCommand(configCmd)
 
+	// Global flags
+	rootCmd.PersistentFlags().StringVar(&metricsListen, "metrics-listen", "", "Expose Prometheus metrics on this address while running (e.g., :9090)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only print warnings, errors and the final summary")
+	rootCmd.PersistentFlags().DurationVar(&heartbeat, "heartbeat", time.Minute, "Print what is still being waited on at this interval while a pull, save, push or docker command runs, also with --quiet (0 disables)")
+	rootCmd.PersistentFlags().BoolVar(&quietHeartbeat, "quiet-heartbeat", false, "Leave the --heartbeat lines out in quiet mode too")
+	rootCmd.PersistentFlags().DurationVar(&maxRetryAfter, "max-retry-after", registry.DefaultMaxPause, "Longest a registry that answers with a Retry-After is paused for at a time")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output (also disabled when NO_COLOR is set or stdout is not a terminal)")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Also write a debug-level JSON log, including every docker command and its output, to this file")
+	rootCmd.PersistentFlags().StringVar(&eventsFormat, "events-format", "", "Write every pipeline event as a line of JSON for CI systems to follow the run (ndjson)")
+	rootCmd.PersistentFlags().StringVar(&eventsFile, "events-file", "", "File the --events-format stream is appended to (default: stdout, with messages and the summary on stderr)")
+	rootCmd.PersistentFlags().StringVar(&auditLogFile, "audit-log", "", "Append a JSON line recording every image and manifest list pushed, with who pushed it and its digests, to this file")
+	rootCmd.PersistentFlags().BoolVar(&auditSyslog, "audit-syslog", false, "Also send the audit records to the local syslog daemon")
+	rootCmd.PersistentFlags().BoolVar(&auditRequired, "audit-required", false, "Fail a push that can't be recorded in the audit log instead of warning")
+	rootCmd.PersistentFlags().Int64Var(&logMaxSize, "log-max-size", 0, "Rotate the log file once it exceeds this many megabytes (0 disables rotation)")
+	rootCmd.PersistentFlags().IntVar(&logMaxBackups, "log-max-backups", 3, "Number of rotated log files to keep")
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", false, "Disable progress bars and print plain output")
+	rootCmd.PersistentFlags().BoolVar(&useTUI, "tui", false, "Show the run on a full-screen dashboard with keys to pause, skip a task or abort (plain output when not on a terminal)")
+	rootCmd.PersistentFlags().StringVar(&registryCA, "registry-ca", "", "PEM bundle of extra certificate authorities trusted for registry API calls")
+	rootCmd.PersistentFlags().StringVar(&registryCert, "registry-cert", "", "PEM client certificate for registries that require mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&registryKey, "registry-key", "", "PEM key of the --registry-cert client certificate")
+	rootCmd.PersistentFlags().BoolVar(&keepIntermediate, "keep-intermediate", false, "Keep the local tags the run created for saved platforms instead of removing them when it finishes")
+	rootCmd.PersistentFlags().BoolVar(&logout, "logout", false, "Run docker logout for the registries logged in to when the run finishes")
+	rootCmd.PersistentFlags().StringVar(&dockerBin, "docker-bin", "", "Docker CLI to run, by name or path (env IMG_MIGRATE_DOCKER, default docker)")
+	rootCmd.PersistentFlags().BoolVar(&useSudo, "sudo", false, "Run the docker CLI through non-interactive sudo and reach the daemon through it")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Inspect every manifest instead of using cached inspections")
+	rootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", docker.DefaultCacheTTL, "How long manifest inspections are cached on disk (0 keeps them in memory only)")
+	rootCmd.PersistentFlags().StringVar(&bandwidthLimit, "bandwidth-limit", "", "Limit the bandwidth of saves and registry transfers, shared by all of them (e.g., 50MB/s)")
+	rootCmd.PersistentFlags().StringVar(&windowSpec, "window", "", "Only transfer within this daily time window, with an optional time zone (e.g., \"01:00-05:00 Europe/Berlin\"), pausing between tasks and platforms while it is closed")
+	rootCmd.PersistentFlags().StringVar(&maxTransfer, "max-transfer", "", "Stop starting platforms once the run pulled and pushed about this much (e.g., 200GB), deferring the rest to a later run")
+	rootCmd.PersistentFlags().StringVar(&tmpDir, "tmpdir", "", "Write archives to temporary files in this directory before moving them into place (default: next to each archive)")
+	rootCmd.PersistentFlags().BoolVar(&noReproducible, "no-reproducible", false, "Keep file times in written archives and compress zstd with all cores, instead of writing the same bytes for the same content")
+	rootCmd.PersistentFlags().StringVar(&configRemote.Username, "config-username", "", "Username for fetching --file and --policy-file URLs with basic auth (env IMG_MIGRATE_CONFIG_USERNAME)")
+	rootCmd.PersistentFlags().StringVar(&configRemote.Password, "config-password", "", "Password for fetching --file and --policy-file URLs with basic auth (env IMG_MIGRATE_CONFIG_PASSWORD)")
+	rootCmd.PersistentFlags().StringVar(&configRemote.Token, "config-token", "", "Bearer token for fetching --file and --policy-file URLs (env IMG_MIGRATE_CONFIG_TOKEN)")
+	rootCmd.PersistentFlags().DurationVar(&configRemote.Timeout, "config-timeout", config.DefaultRemoteTimeout, "Time limit for fetching --file and --policy-file URLs")
+	rootCmd.PersistentFlags().StringVar(&httpOptions.Username, "http-username", "", "Username for output directories and archives given as http(s) URLs, with basic auth (env IMG_MIGRATE_HTTP_USERNAME)")
+	rootCmd.PersistentFlags().StringVar(&httpOptions.Password, "http-password", "", "Password for output directories and archives given as http(s) URLs (env IMG_MIGRATE_HTTP_PASSWORD)")
+	rootCmd.PersistentFlags().StringVar(&httpOptions.Token, "http-token", "", "Bearer token for output directories and archives given as http(s) URLs (env IMG_MIGRATE_HTTP_TOKEN)")
+	rootCmd.PersistentFlags().StringVar(&configRemote.SHA256, "config-sha256", "", "Refuse a --file whose content doesn't have this SHA256 digest")
+	rootCmd.PersistentFlags().StringVar(&metricsTextfile, "metrics-textfile", "", "Write final metric values to this file in node_exporter textfile format")
+
 	// Common flags for pull command
-	pullCmd.Flags().StringVarP(&sourceImage, "source", "s", "", "Source image to pull (required)")
-	pullCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Output directory for saving images")
-	pullCmd.Flags().StringSliceVarP(&architectures, "arch", "a", []string{"amd64", "arm64"}, "Architectures to pull (e.g., amd64,arm64)")
-	pullCmd.Flags().StringSliceVarP(&operatingSystems, "os", "", []string{"linux"}, "Operating systems to pull (e.g., linux,windows)")
-	pullCmd.Flags().BoolVar(&allArch, "all-arch", false, "Pull all available architectures")
-	pullCmd.Flags().BoolVarP(&useCompression, "compress", "z", false, "Use gzip compression for saved images (.tar.gz)")
-	pullCmd.Flags().BoolVar(&createMultiArch, "create-multi-arch", true, "Create a multi-architecture image with -allarch tag")
+	pullCmd.Flags().StringArrayVarP(&sourceImages, "source", "s", nil, "Source image to pull; repeat for several images, or pass them as arguments")
+	pullCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop at the first image that fails instead of continuing with the others")
+	addSaveFlags(pullCmd)
+	addPlatformFlags(pullCmd, "pull")
+	addNoPullFlag(pullCmd)
+	addSourceChangeFlag(pullCmd)
+	addTimeoutFlags(pullCmd)
+	addDaemonDiskFlags(pullCmd)
+	pullCmd.Flags().StringVar(&pullPlatform, "platform", "", "Platform to pull with --no-retag (os/arch[/variant])")
+	pullCmd.Flags().BoolVar(&noRetag, "no-retag", false, "Pull the --platform image under its own name, without architecture tags, saving it only when --output is given")
+	addRunFlags(pullCmd)
+	pullCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this long for another run to release the output directory instead of failing")
 
 	// Flags for push command
-	pushCmd.Flags().StringVarP(&sourceImage, "source", "s", "", "Source image to pull (required)")
-	pushCmd.Flags().StringVarP(&targetImage, "target", "t", "", "Target image name with tag (required)")
-	pushCmd.Flags().StringVarP(&registryURL, "registry", "r", "", "URL of the private registry")
-	pushCmd.Flags().StringSliceVarP(&architectures, "arch", "a", []string{"amd64", "arm64"}, "Architectures to pull (e.g., amd64,arm64)")
-	pushCmd.Flags().StringSliceVarP(&operatingSystems, "os", "", []string{"linux"}, "Operating systems to pull (e.g., linux,windows)")
-	pushCmd.Flags().BoolVar(&allArch, "all-arch", false, "Pull all available architectures")
-	pushCmd.Flags().StringVarP(&username, "username", "u", "", "Username for registry authentication")
-	pushCmd.Flags().StringVarP(&password, "password", "p", "", "Password for registry authentication")
-	pushCmd.Flags().BoolVar(&insecure, "insecure", false, "Allow insecure registry connections")
-	pushCmd.Flags().BoolVar(&createMultiArch, "create-multi-arch", true, "Create a multi-architecture image with -allarch tag")
+	pushCmd.Flags().StringArrayVarP(&sourceImages, "source", "s", nil, "Source image to pull; repeat for several images, or pass them as arguments")
+	pushCmd.Flags().StringVarP(&targetImage, "target", "t", "", "Target image name with tag, for a single source")
+	pushCmd.Flags().StringVar(&targetPrefix, "target-prefix", "", "Registry and namespace to push every source below, keeping its repository path and tag (e.g., registry.example.com/mirror)")
+	pushCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop at the first image that fails instead of continuing with the others")
+	addTargetAuthFlags(pushCmd)
+	addPlatformFlags(pushCmd, "pull")
+	addNoPullFlag(pushCmd)
+	addAnnotationFlag(pushCmd, "the pushed manifest list")
+	addPreserveIndexFlag(pushCmd)
+	addSourceOrderFlags(pushCmd)
+	addTransformFlags(pushCmd)
+	addSmokeTestFlags(pushCmd)
+	addSourceChangeFlag(pushCmd)
+	addNormalizeNamesFlag(pushCmd)
+	addAllowDuplicateTargetsFlag(pushCmd)
+	addTimeoutFlags(pushCmd)
+	addDaemonDiskFlags(pushCmd)
+	addRunFlags(pushCmd)
 
 	// Flags for config command
-	configCmd.Flags().StringVarP(&configFile, "file", "f", "", "Path to the YAML configuration file")
+	configCmd.Flags().StringVarP(&configFile, "file", "f", "", "Path or http(s) URL of the YAML or JSON configuration file")
 	configCmd.Flags().StringVarP(&generateConfig, "generate", "g", "", "Generate a sample configuration file at the specified path")
+	addRunFlags(configCmd)
+	configCmd.Flags().StringArrayVar(&configOverrides, "set", nil, "Override a config value, e.g. registry.url=host or images[2].target=repo:tag (repeatable)")
+	configCmd.Flags().BoolVar(&printConfig, "print-config", false, "Print the resolved configuration a run would use, with secrets masked, and exit without doing any work")
+	addNormalizeNamesFlag(configCmd)
+	addAllowDuplicateTargetsFlag(configCmd)
+	addTimeoutFlags(configCmd)
+	addDaemonDiskFlags(configCmd)
+	addOutputRootFlags(configCmd)
+	configCmd.Flags().BoolVar(&watch, "watch", false, "Keep running and mirror changed sources every --interval")
+	configCmd.Flags().DurationVar(&watchInterval, "interval", 6*time.Hour, "Time to wait between cycles in watch mode")
+	configCmd.Flags().StringVar(&stateFile, "state-file", "", "Record mirrored source digests in this file and skip unchanged sources on later runs")
+	configCmd.Flags().BoolVar(&refreshState, "refresh-state", false, "Mirror every task even if the state file records its source digest as unchanged")
+	configCmd.Flags().BoolVar(&pruneDryRun, "prune-dry-run", false, "Only report the stale tags tasks with prune enabled would delete")
+	configCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this long for another run to release the config or output directory locks instead of failing")
 
-	// Mark required flags
-	pullCmd.MarkFlagRequired("source")
-	pushCmd.MarkFlagRequired("source")
-	pushCmd.MarkFlagRequired("target")
 }
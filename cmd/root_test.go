@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeDocker writes a docker and a sudo script to a temporary directory
+// put first on PATH, which log their command lines to the returned file
+func fakeDocker(t *testing.T) (bin, log string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake docker CLI is a shell script")
+	}
+	dir := t.TempDir()
+	log = filepath.Join(dir, "commands.log")
+	bin = filepath.Join(dir, "docker")
+	scripts := map[string]string{
+		"docker": "#!/bin/sh\necho \"$0 $*\" >> " + log + "\necho 28.1.1\n",
+		"sudo":   "#!/bin/sh\necho \"sudo $*\" >> " + log + "\nshift 2\nexec \"$@\"\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return bin, log
+}
+
+// runVersion runs the version command, which runs the docker CLI twice:
+// for the check of NewClient and for the CLI version
+func runVersion(t *testing.T, args ...string) {
+	t.Helper()
+	dockerBin, useSudo = "", false
+	t.Cleanup(func() { dockerBin, useSudo = "", false })
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs(append(args, "version", "--output", "json"))
+	t.Cleanup(func() { rootCmd.SetOut(nil); rootCmd.SetArgs(nil) })
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("version: %v", err)
+	}
+	if !strings.Contains(out.String(), `"cli_version": "28.1.1"`) {
+		t.Errorf("version output lacks the CLI version of the fake docker:\n%s", out.String())
+	}
+}
+
+func TestDockerBinFlags(t *testing.T) {
+	bin, log := fakeDocker(t)
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want []string
+		// dial lists the commands the Engine API client runs after want,
+		// once per connection
+		dial []string
+	}{
+		{
+			name: "--docker-bin",
+			args: []string{"--docker-bin", bin},
+			want: []string{
+				bin + " --version",
+				bin + " version --format {{.Client.Version}}",
+			},
+		},
+		{
+			name: "IMG_MIGRATE_DOCKER",
+			env:  bin,
+			want: []string{
+				bin + " --version",
+				bin + " version --format {{.Client.Version}}",
+			},
+		},
+		{
+			name: "--sudo",
+			args: []string{"--docker-bin", bin, "--sudo"},
+			want: []string{
+				"sudo -n -- " + bin + " --version",
+				bin + " --version",
+				"sudo -n -- " + bin + " version --format {{.Client.Version}}",
+				bin + " version --format {{.Client.Version}}",
+			},
+			dial: []string{
+				"sudo -n -- " + bin + " system dial-stdio",
+				bin + " system dial-stdio",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(log)
+			t.Setenv("IMG_MIGRATE_DOCKER", tt.env)
+			runVersion(t, tt.args...)
+
+			data, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.TrimSpace(string(data))
+			want := strings.Join(tt.want, "\n")
+			dialed := strings.TrimPrefix(got, want)
+			for dial := "\n" + strings.Join(tt.dial, "\n"); len(tt.dial) > 0 && strings.HasPrefix(dialed, dial); {
+				dialed = strings.TrimPrefix(dialed, dial)
+			}
+			if !strings.HasPrefix(got, want) || dialed != "" {
+				t.Errorf("commands:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
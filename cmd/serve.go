@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen    string
+	serveWorkers   int
+	serveStateFile string
+	serveToken     string
+	serveDataDir   string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run as a service accepting migration tasks over HTTP",
+	Long: `Run an HTTP API that accepts migration tasks and processes them on a worker pool.
+
+Endpoints:
+  POST /tasks       submit a task using the same schema as an images entry in the config
+  GET  /tasks       list all tasks
+  GET  /tasks/{id}  get the status and result of a task
+  GET  /healthz     health check (no authentication)
+
+Submitted tasks are checked like the tasks of a configuration file.
+They save to and read archives and dir: or oci: sources from --data-dir,
+naming paths relative to it; without it they can only copy between
+registries.
+
+Registry credentials, mirrors, the registry policy and the task and
+operation timeouts are taken from --file when given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var cfg *config.Config
+		var auth docker.RegistryAuth
+		var mirrors map[string][]string
+		var sources map[string]docker.RegistryAuth
+		var timeouts config.Durations
+		if configFile != "" {
+			var err error
+			if cfg, err = config.LoadConfig(configFile); err != nil {
+				return loadError(fmt.Errorf("failed to load config: %w", err))
+			}
+			useConfigRegistries(cfg)
+			if auth, err = configAuth(cfg.Registry); err != nil {
+				return err
+			}
+			mirrors = cfg.Mirrors
+			sources = sourceAuth(cfg)
+			if timeouts, err = cfg.Timeouts.Parse(); err != nil {
+				return fmt.Errorf("timeouts: %v", err)
+			}
+		}
+		policies, err := loadPolicies(cfg)
+		if err != nil {
+			return err
+		}
+
+		if serveToken == "" {
+			serveToken = os.Getenv("IMG_MIGRATE_API_TOKEN")
+		}
+		if serveToken == "" {
+			warnf("no API token configured, the task API is unauthenticated")
+		}
+
+		client, err := newClient(nil, docker.WithMirrors(mirrors), docker.WithSourceAuth(sources))
+		if err != nil {
+			return err
+		}
+		defer removeIntermediate(client)
+		defer logoutRegistries(client)
+
+		store, err := server.OpenStore(serveStateFile)
+		if err != nil {
+			return fmt.Errorf("failed to open task state: %v", err)
+		}
+		defer store.Close()
+
+		srv, err := server.New(server.Options{
+			Store:   store,
+			Workers: serveWorkers,
+			Token:   serveToken,
+			Policy:  policies,
+			DataDir: serveDataDir,
+			Run: func(ctx context.Context, task config.ImageTask) (*docker.TaskResult, error) {
+				return processTask(ctx, client, 0, task, "", nil, auth, timeouts)
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Stopping the workers and waiting for them runs before the store
+		// closes, so the tasks they were running are written back
+		ctx, stop := interruptible()
+		srv.Start(ctx)
+		defer srv.Wait()
+		defer stop()
+
+		httpServer := &http.Server{Addr: serveListen, Handler: srv.Handler()}
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- httpServer.ListenAndServe()
+		}()
+		infof("Serving task API on %s with %d workers", serveListen, serveWorkers)
+
+		select {
+		case err := <-errCh:
+			if !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("task API server failed: %v", err)
+			}
+		case <-ctx.Done():
+			infof("Received shutdown signal, stopping task API")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveListen, "listen", ":8080", "Address to serve the task API on")
+	serveCmd.Flags().IntVar(&serveWorkers, "workers", 2, "Number of tasks processed concurrently")
+	serveCmd.Flags().StringVar(&serveStateFile, "state-file", "imgmigrate-tasks.db", "Database file used to persist task state across restarts")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Static bearer token required by the API (env IMG_MIGRATE_API_TOKEN)")
+	serveCmd.Flags().StringVar(&serveDataDir, "data-dir", "", "Directory submitted tasks save to and read archives and layouts from")
+	serveCmd.Flags().StringVarP(&configFile, "file", "f", "", "Configuration file providing registry credentials and policy")
+	serveCmd.Flags().StringVar(&policyFile, "policy-file", "", "Path to a central registry policy file")
+}
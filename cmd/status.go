@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusOutput    string
+	statusStateFile string
+	statusFailOn    []string
+)
+
+// Drift states of a task
+const (
+	driftUpToDate = "up-to-date"
+	driftStale    = "stale"
+	driftMissing  = "missing"
+	driftError    = "error"
+)
+
+// driftStates lists the drift states in the order counts are printed
+var driftStates = []string{driftUpToDate, driftStale, driftMissing, driftError}
+
+// taskDrift is the drift of the target of a task from its source
+type taskDrift struct {
+	Task         string `json:"task"`
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	Status       string `json:"status"`
+	SourceDigest string `json:"source_digest,omitempty"`
+	TargetDigest string `json:"target_digest,omitempty"`
+	// MirroredAt is when the state file recorded the last mirror
+	MirroredAt *time.Time `json:"mirrored_at,omitempty"`
+	Detail     string     `json:"detail,omitempty"`
+}
+
+// driftChecker resolves digests through the registry API, once per image
+type driftChecker struct {
+	ctx      context.Context
+	cfg      *config.Config
+	mirrored *state.File
+	clients  map[string]*registry.Client
+	digests  map[string]string
+	errs     map[string]error
+}
+
+// statusCmd reports whether the targets of a configuration are up to date
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which targets of a configuration have drifted from their sources",
+	Long: `Resolve the current digest of the source and the target of every task of a
+configuration with HEAD requests, without pulling anything, and report each
+task as up-to-date, stale, missing or error, with counts.
+
+A target whose digest is the source's, as verbatim tasks copy them, is up to
+date. Tasks that push a manifest list of their own, or per-platform tags
+without create_multi_arch, change the digest: they are up to date when the
+--state-file of the runs records the current source digest as mirrored to
+the target, and stale otherwise. Tasks pushing archives or local images only
+report whether their target exists, and tasks without a target are left out.
+
+Exits with 8 when a task is in a state --fail-on lists.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if statusOutput != "text" && statusOutput != "json" {
+			return fmt.Errorf("unsupported output format %q, use text or json", statusOutput)
+		}
+		for _, state := range statusFailOn {
+			if state != "none" && (state == driftUpToDate || !slices.Contains(driftStates, state)) {
+				return fmt.Errorf("invalid --fail-on %q, use stale, missing, error or none", state)
+			}
+		}
+		if configFile == "" {
+			return fmt.Errorf("config file path is required")
+		}
+		cfg, err := config.LoadConfig(configFile)
+		if err != nil {
+			return loadError(fmt.Errorf("failed to load config: %w", err))
+		}
+		useConfigRegistries(cfg)
+
+		checker := &driftChecker{
+			ctx:     context.Background(),
+			cfg:     cfg,
+			clients: make(map[string]*registry.Client),
+			digests: make(map[string]string),
+			errs:    make(map[string]error),
+		}
+		if statusStateFile != "" {
+			if checker.mirrored, err = state.Open(statusStateFile); err != nil {
+				return err
+			}
+		}
+
+		var drifts []taskDrift
+		skipped := 0
+		for i, task := range cfg.ImageTask {
+			if task.Target == "" {
+				skipped++
+				continue
+			}
+			drifts = append(drifts, checker.check(task.Label(i), task))
+		}
+
+		counts := make(map[string]int)
+		for _, drift := range drifts {
+			counts[drift.Status]++
+		}
+		out := cmd.OutOrStdout()
+		if statusOutput == "json" {
+			data, err := json.MarshalIndent(struct {
+				Tasks   []taskDrift    `json:"tasks"`
+				Counts  map[string]int `json:"counts"`
+				Skipped int            `json:"skipped,omitempty"`
+			}{drifts, counts, skipped}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, string(data))
+		} else {
+			printDrift(out, drifts, counts, skipped)
+		}
+
+		var failing []string
+		for _, state := range driftStates {
+			if counts[state] > 0 && slices.Contains(statusFailOn, state) {
+				failing = append(failing, fmt.Sprintf("%d %s", counts[state], state))
+			}
+		}
+		if len(failing) > 0 {
+			return &exitError{code: ExitDrift, err: fmt.Errorf("%s of %d tasks", strings.Join(failing, ", "), len(drifts))}
+		}
+		return nil
+	},
+}
+
+// check compares the target of a task with its source
+func (d *driftChecker) check(label string, task config.ImageTask) taskDrift {
+	drift := taskDrift{Task: label, Source: task.Source, Target: task.Target}
+	fail := func(err error) taskDrift {
+		drift.Status = driftError
+		drift.Detail = err.Error()
+		return drift
+	}
+
+	var entry state.Entry
+	recorded := false
+	if d.mirrored != nil {
+		entry, recorded = d.mirrored.Get(task.Source, task.Target)
+		if recorded && !entry.MirroredAt.IsZero() {
+			drift.MirroredAt = &entry.MirroredAt
+		}
+	}
+
+	targets, err := d.targetTags(task, entry)
+	if err != nil {
+		return fail(err)
+	}
+	var absent []string
+	for _, target := range targets {
+		digest, err := d.digest(target, false)
+		switch {
+		case errors.Is(err, errdefs.ErrNotFound):
+			absent = append(absent, target)
+		case err != nil:
+			return fail(err)
+		case target == task.Target:
+			drift.TargetDigest = digest
+		}
+	}
+	if len(absent) > 0 {
+		drift.Status = driftMissing
+		drift.Detail = "not in the registry: " + strings.Join(absent, ", ")
+		return drift
+	}
+
+	if task.LocalOnly || len(task.Archives) > 0 || task.ReadsLayout() {
+		drift.Status = driftUpToDate
+		drift.Detail = "target exists; its source isn't in a registry to compare with"
+		return drift
+	}
+	if drift.SourceDigest, err = d.digest(task.Source, true); err != nil {
+		return fail(err)
+	}
+
+	switch {
+	case drift.TargetDigest != "" && drift.TargetDigest == drift.SourceDigest:
+		drift.Status = driftUpToDate
+	case recorded && entry.Deferred != nil:
+		drift.Status = driftStale
+		drift.Detail = "deferred by the run of " + entry.Deferred.DeferredAt.Format(time.RFC3339)
+	case recorded && entry.Digest == drift.SourceDigest:
+		drift.Status = driftUpToDate
+		drift.Detail = "state file records the source digest as mirrored"
+	case recorded:
+		drift.Status = driftStale
+		drift.Detail = "source changed since it was mirrored from " + entry.Digest
+	case task.Verbatim:
+		drift.Status = driftStale
+		drift.Detail = "target digest differs from the source"
+	default:
+		drift.Status = driftStale
+		drift.Detail = "no mirror of the source digest is recorded; pass the --state-file of the runs"
+	}
+	return drift
+}
+
+// targetTags returns the tags a task pushes to its target: the target
+// itself, or the tag its arch_tag names for every platform for tasks that
+// push no manifest list. Those platforms are the ones the state file recorded, or else those
+// of the source the task selects.
+func (d *driftChecker) targetTags(task config.ImageTask, entry state.Entry) ([]string, error) {
+	if task.CreateMultiArch || task.Verbatim || task.LocalOnly || len(task.Archives) > 0 || task.ReadsLayout() {
+		return []string{task.Target}, nil
+	}
+	platforms := entry.Platforms
+	if len(platforms) == 0 {
+		host, repo, ref, err := splitReference(task.Source)
+		if err != nil {
+			return nil, err
+		}
+		client, err := d.client(host, true)
+		if err != nil {
+			return nil, err
+		}
+		images, _, err := client.Platforms(d.ctx, repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range images {
+			if selectsPlatform(task, image.Platform) {
+				platforms = append(platforms, docker.Platform{OS: image.Platform.OS, Architecture: image.Platform.Architecture, Variant: image.Platform.Variant}.String())
+			}
+		}
+	}
+	archTag, err := config.ParseArchTag(task.ArchTag)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, len(platforms))
+	for i, platform := range platforms {
+		if tags[i], err = archTag.Target(task.Target, platform); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// selectsPlatform reports whether a task migrates a platform of its source
+func selectsPlatform(task config.ImageTask, platform registry.Platform) bool {
+	if len(task.OperatingSystems) > 0 && !slices.Contains(task.OperatingSystems, platform.OS) {
+		return false
+	}
+	if task.AllArchitecture || len(task.Architectures) == 0 {
+		return true
+	}
+	return slices.Contains(task.Architectures, platform.Architecture) ||
+		(platform.Variant != "" && slices.Contains(task.Architectures, platform.Architecture+"/"+platform.Variant))
+}
+
+// digest resolves an image to its manifest digest with a HEAD request,
+// with the source or the target credentials
+func (d *driftChecker) digest(image string, source bool) (string, error) {
+	key := imageref.Key(image)
+	if digest, ok := d.digests[key]; ok {
+		return digest, d.errs[key]
+	}
+	host, repo, ref, err := splitReference(image)
+	if err == nil {
+		var client *registry.Client
+		if client, err = d.client(host, source); err == nil {
+			d.digests[key], err = client.Digest(d.ctx, repo, ref)
+		}
+	}
+	d.errs[key] = err
+	return d.digests[key], err
+}
+
+// client returns the registry client of host, using the source credentials
+// for sources and the target ones otherwise
+func (d *driftChecker) client(host string, source bool) (*registry.Client, error) {
+	key := host
+	flags := docker.RegistryAuth{Username: username, Password: redact.Secret(password), Token: redact.Secret(registryToken), Insecure: insecure}
+	if source {
+		key = "source " + host
+		flags = docker.RegistryAuth{Username: sourceUsername, Password: redact.Secret(sourcePassword), Insecure: sourceInsecure}
+	}
+	if client, ok := d.clients[key]; ok {
+		return client, nil
+	}
+	auth, err := imageAuth(d.cfg, host, flags)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newRegistryClient(host, auth)
+	if err != nil {
+		return nil, err
+	}
+	d.clients[key] = client
+	return client, nil
+}
+
+// printDrift prints the drift of every task and the counts of each state
+func printDrift(out io.Writer, drifts []taskDrift, counts map[string]int, skipped int) {
+	for _, drift := range drifts {
+		fmt.Fprintf(out, "%-10s  %s: %s -> %s\n", drift.Status, drift.Task, drift.Source, drift.Target)
+		if drift.Detail != "" {
+			fmt.Fprintf(out, "            %s\n", drift.Detail)
+		}
+	}
+	var parts []string
+	for _, state := range driftStates {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[state], state))
+	}
+	fmt.Fprintf(out, "\n%d tasks: %s\n", len(drifts), strings.Join(parts, ", "))
+	if skipped > 0 {
+		fmt.Fprintf(out, "%d tasks without a target were not checked\n", skipped)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&configFile, "file", "f", "", "Path or http(s) URL of the YAML or JSON configuration file")
+	statusCmd.Flags().StringVar(&statusStateFile, "state-file", "", "State file of the runs, recording the source digests they mirrored")
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "text", "Output format: text or json")
+	statusCmd.Flags().StringSliceVar(&statusFailOn, "fail-on", []string{driftStale, driftMissing, driftError}, "Exit with 8 when a task is in one of these states: stale, missing, error, or none")
+	addTargetAuthFlags(statusCmd)
+	addSourceAuthFlags(statusCmd, "resolving digests in")
+}
@@ -0,0 +1,706 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+	"github.com/Fr000g/ImgMigrate/pkg/tui"
+	"github.com/distribution/reference"
+)
+
+// interruptible returns a context cancelled by SIGINT or SIGTERM, so an
+// interrupted run stops its operations and still removes its temporary
+// files, intermediate tags and staged outputs, logs out and writes its
+// report. A second signal terminates the process.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// runTasks processes every task in the configuration and returns the number
+// of failed tasks. When mirrored is not nil, tasks whose source digest
+// matches the recorded one are skipped and completed tasks are recorded in
+// it. Processing stops between tasks once ctx is cancelled.
+func runTasks(ctx context.Context, client *docker.Client, cfg *config.Config, auth docker.RegistryAuth, mirrored *state.File, rep *report.Report) int {
+	configured, _ := cfg.Timeouts.Parse()
+	timeouts := timeoutFlags().Or(configured)
+	if timeouts.Run > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Run)
+		defer cancel()
+	}
+
+	// The audit log records the digest each source resolved to
+	var digests map[string]string
+	if mirrored != nil || len(cfg.ImageTask) > 1 || auditLog != nil {
+		digests = resolveSources(client, cfg.ImageTask)
+	}
+
+	// Keys of the --tui dashboard pause the run between tasks, skip the
+	// current task or abort the run
+	ctx, detach := attachDashboard(ctx, cfg.ImageTask)
+	defer detach()
+
+	estimator.Reset(len(cfg.ImageTask))
+	defer estimator.Finish()
+	if transferBudget != nil {
+		transferBudget.Reset()
+	}
+
+	var overall *progress.Bar
+	if renderer != nil {
+		overall = renderer.AddCounter("tasks", len(cfg.ImageTask))
+		defer overall.Remove()
+		defer showRemaining(overall)()
+	}
+
+	// Names of the tasks that completed or were deferred, for the tasks
+	// depending on them
+	completed := make(map[string]bool)
+	deferredNames := make(map[string]bool)
+	chains := newChain(cfg.ImageTask)
+
+	failed, deferred := 0, 0
+	for i, task := range cfg.ImageTask {
+		if overall != nil && i > 0 {
+			overall.Increment()
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			warnf("The run timed out after %s, skipping remaining %d tasks", timeouts.Run, len(cfg.ImageTask)-i)
+			for j := i; j < len(cfg.ImageTask); j++ {
+				rep.Add(cfg.ImageTask[j].Label(j), nil, &errdefs.TimeoutError{Err: fmt.Errorf("the run timed out after %s before the task started", timeouts.Run)})
+			}
+			failed += len(cfg.ImageTask) - i
+			break
+		}
+		if dashboard != nil {
+			dashboard.WaitWhilePaused(ctx)
+		}
+		waitForWindow(ctx, rep, fmt.Sprintf("task %d (%s)", i+1, task.Source))
+		if ctx.Err() != nil {
+			infof("Interrupted, skipping remaining %d tasks", len(cfg.ImageTask)-i)
+			break
+		}
+
+		estimator.StartTask(i)
+		if estimate := estimator.Estimate(); estimate.Known {
+			infof("Processing task %d: %s (%s)", i+1, task.Source, estimate)
+		} else {
+			infof("Processing task %d: %s", i+1, task.Source)
+		}
+
+		// A task only runs once the tasks it depends on completed, and is
+		// deferred with them
+		if dep, isDeferred := unmetDependency(task, completed, deferredNames); dep != "" {
+			if isDeferred {
+				infof("Deferring task %d with its dependency %s", i+1, dep)
+				deferred++
+				rep.AddDeferred(task.Label(i))
+				if dashboard != nil {
+					dashboard.MarkDeferred(i)
+				}
+				deferTask(mirrored, i, task, "", nil)
+				deferredNames[task.Name] = true
+				continue
+			}
+			errorf("Skipping task %d, its dependency %s did not complete", i+1, dep)
+			rep.Add(task.Label(i), nil, fmt.Errorf("dependency %s did not complete", dep))
+			failed++
+			continue
+		}
+
+		digest := digests[imageref.Key(task.Source)]
+		if task.SourceFrom != "" {
+			// A chained task copies exactly what the task before it pushed
+			var err error
+			if digest, err = chains.source(task); err != nil {
+				errorf("Skipping task %d: %v", i+1, err)
+				rep.Add(task.Label(i), nil, err)
+				failed++
+				continue
+			}
+		} else if len(task.DependsOn) > 0 && !task.LocalOnly && len(task.Archives) == 0 && !task.ReadsLayout() {
+			// The source may have just been pushed by a dependency
+			var err error
+			if digest, err = client.ResolveDigest(task.Source); err != nil {
+				warnf("%v, processing without reuse", err)
+			}
+		}
+		if mirrored != nil && digest != "" {
+			if entry, ok := mirrored.Get(task.Source, task.Target); ok && entry.Deferred != nil {
+				infof("Task %d was deferred by the run of %s, migrating it", i+1, entry.Deferred.DeferredAt.Format(time.RFC3339))
+			} else if ok && unchanged(entry, digest) {
+				infof("Task %d is up to date (%s, mirrored %s), skipping", i+1, digest, entry.MirroredAt.Format(time.RFC3339))
+				chains.record(client, rep, task, digest, nil)
+				completed[task.Name] = true
+				continue
+			}
+		}
+
+		// Once the --max-transfer budget is used up, tasks are left for a
+		// later run
+		if transferBudget != nil && !transferBudget.Allows() {
+			if deferred == 0 {
+				warnf("The transfer budget of %s is used up (%s transferred), deferring the remaining tasks to a later run",
+					progress.FormatBytes(transferBudget.Limit()), progress.FormatBytes(transferBudget.Used()))
+			}
+			deferred++
+			rep.AddDeferred(task.Label(i))
+			if dashboard != nil {
+				dashboard.MarkDeferred(i)
+			}
+			deferTask(mirrored, i, task, digest, nil)
+			deferredNames[task.Name] = true
+			continue
+		}
+
+		taskCtx, done := ctx, context.CancelFunc(func() {})
+		if dashboard != nil {
+			taskCtx, done = dashboard.TaskContext(ctx, i)
+		}
+		var pinned map[string]string
+		if mirrored != nil && task.Compression == archive.Auto {
+			// Archives keep the compression picked for them before, so
+			// re-runs don't flip formats
+			if entry, ok := mirrored.Get(task.Source, task.Target); ok {
+				pinned = entry.Compression
+			}
+		}
+		result, err := processTask(taskCtx, client, i, task, digest, pinned, auth, timeouts)
+		// Archives saved for an output URL are uploaded once the task is
+		// done, so a task isn't complete until they are
+		if uploadErr := uploadOutputs(ctx); uploadErr != nil && err == nil {
+			err = uploadErr
+		}
+		skipped := tui.Skipped(taskCtx)
+		done()
+		if result == nil {
+			// The task failed before reaching the client, so no completion event recorded it
+			rep.Add(task.Label(i), nil, err)
+		}
+		if task.RollbackOnFailure && result != nil && report.Status(result, err) == report.StatusFailed {
+			// The rollback runs even when the run timed out or was interrupted
+			rollbackTask(context.WithoutCancel(ctx), result, auth, rep)
+		}
+		if skipped {
+			dashboard.MarkSkipped()
+			warnf("Task %d was skipped from the dashboard", i+1)
+			failed++
+			continue
+		}
+		if err != nil {
+			errorf("Error processing task %d: %v", i+1, err)
+			failed++
+			if failFast {
+				infof("Stopping after the failure of task %d (--fail-fast), skipping remaining %d tasks", i+1, len(cfg.ImageTask)-i-1)
+				break
+			}
+			// Continue with other tasks
+			continue
+		}
+
+		if result != nil && result.Deferred() > 0 {
+			infof("Task %d deferred %d of its %d platforms to a later run", i+1, result.Deferred(), len(result.Platforms))
+			deferTask(mirrored, i, task, digest, result)
+			deferredNames[task.Name] = true
+			continue
+		}
+
+		if mirrored != nil && digest != "" && result != nil && result.Failed() == 0 {
+			if err := mirrored.Record(stateEntry(task, digest, result)); err != nil {
+				warnf("Failed to record task %d in the state file: %v", i+1, err)
+			}
+		}
+
+		if task.Prune != nil {
+			if err := pruneTask(ctx, client, task, auth, mirrored, rep); err != nil {
+				warnf("Failed to prune stale tags of %s: %v", task.Target, err)
+			}
+		}
+
+		chains.record(client, rep, task, digest, result)
+		completed[task.Name] = true
+		infof("Successfully completed task %d", i+1)
+	}
+
+	return failed
+}
+
+// unmetDependency returns the first task the task depends on, or takes its
+// source from, that didn't complete, and whether it was deferred rather
+// than failed or skipped
+func unmetDependency(task config.ImageTask, completed, deferred map[string]bool) (string, bool) {
+	names := task.DependsOn
+	if task.SourceFrom != "" {
+		names = append(names[:len(names):len(names)], task.SourceFrom)
+	}
+	for _, name := range names {
+		if !completed[name] {
+			return name, deferred[name]
+		}
+	}
+	return "", false
+}
+
+// orderTasks puts the tasks of cfg in the order they run, and logs that
+// order when priorities or dependencies decide it
+func orderTasks(cfg *config.Config) error {
+	tasks, order, err := executionOrder(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Ordered() {
+		infof("Execution order:")
+		for i, line := range executionPlan(tasks, order) {
+			infof("  %d. %s", i+1, line)
+		}
+	}
+	cfg.ImageTask = tasks
+	return nil
+}
+
+// executionOrder returns the tasks of cfg in the order they run, and the
+// index in the configuration of each
+func executionOrder(cfg *config.Config) ([]config.ImageTask, []int, error) {
+	order, err := cfg.ExecutionOrder()
+	if err != nil {
+		return nil, nil, err
+	}
+	tasks := make([]config.ImageTask, len(order))
+	for i, index := range order {
+		tasks[i] = cfg.ImageTask[index]
+	}
+	return tasks, order, nil
+}
+
+// executionPlan describes every task of tasks, in execution order, with its
+// position in the configuration, its priority and its dependencies
+func executionPlan(tasks []config.ImageTask, order []int) []string {
+	lines := make([]string, len(tasks))
+	for i, task := range tasks {
+		name := task.Name
+		if name == "" {
+			name = task.Source
+		}
+		line := fmt.Sprintf("%s (images[%d]", name, order[i])
+		if task.Priority != 0 {
+			line += fmt.Sprintf(", priority %d", task.Priority)
+		}
+		if len(task.DependsOn) > 0 {
+			line += ", after " + strings.Join(task.DependsOn, ", ")
+		}
+		if task.SourceFrom != "" {
+			line += ", source from " + task.SourceFrom
+		}
+		lines[i] = line + ")"
+	}
+	return lines
+}
+
+// resolveSources resolves the digest of every distinct source and logs the
+// tasks that share a source image, so the plan shows which pulls are
+// deduplicated. Digests are keyed by the canonical source reference, so
+// spellings of the same image are resolved once. Sources that fail to
+// resolve are missing from the result and are processed without reuse, as
+// are local-only tasks, tasks pushing archives and tasks reading dir: or oci:
+// layouts, which are not resolved.
+// Tasks with dependencies resolve their source when they start instead, and
+// chained tasks copy the digest the task before them pushed.
+func resolveSources(client *docker.Client, tasks []config.ImageTask) map[string]string {
+	digests := make(map[string]string)
+	shared := make(map[string][]int)
+	var order []string
+	for i, task := range tasks {
+		if task.LocalOnly || len(task.Archives) > 0 || task.ReadsLayout() || len(task.DependsOn) > 0 || task.SourceFrom != "" {
+			continue
+		}
+		source := imageref.Key(task.Source)
+		digest, resolved := digests[source]
+		if !resolved {
+			var err error
+			if digest, err = client.ResolveDigest(task.Source); err != nil {
+				warnf("%v, processing without reuse", err)
+			}
+			digests[source] = digest
+		}
+		if digest == "" {
+			continue
+		}
+		if len(shared[digest]) == 0 {
+			order = append(order, digest)
+		}
+		shared[digest] = append(shared[digest], i+1)
+	}
+
+	for _, digest := range order {
+		if indexes := shared[digest]; len(indexes) > 1 {
+			first := tasks[indexes[0]-1].Source
+			infof("Tasks %s share the source image %s (%s); each platform is pulled once", joinInts(indexes), first, digest)
+		}
+	}
+	return digests
+}
+
+// imageTasks returns a task for each source image with the platform and
+// archive options from the flags. targetFor returns the target of a source;
+// when it is nil the tasks save the images instead.
+func imageTasks(sources []string, targetFor func(source string) (string, error)) ([]config.ImageTask, error) {
+	var taskAnnotations map[string]string
+	var transform archive.Transform
+	var smoke *config.SmokeTestConfig
+	sourceChange, err := sourceChangeFlag()
+	if err != nil {
+		return nil, err
+	}
+	if targetFor != nil {
+		if taskAnnotations, err = annotationFlags(); err != nil {
+			return nil, err
+		}
+		if transform, err = transformFlags(); err != nil {
+			return nil, err
+		}
+		if smoke, err = smokeTestFlags(); err != nil {
+			return nil, err
+		}
+		if err := checkManifestListAnnotations(taskAnnotations); err != nil {
+			return nil, err
+		}
+		if err := checkSourceOrder(taskAnnotations); err != nil {
+			return nil, err
+		}
+	}
+	tasks := make([]config.ImageTask, 0, len(sources))
+	for _, source := range sources {
+		var target string
+		if targetFor != nil {
+			var err error
+			if target, err = targetFor(source); err != nil {
+				return nil, err
+			}
+		}
+		tasks = append(tasks, config.ImageTask{
+			Source:           source,
+			Target:           target,
+			Architectures:    architectures,
+			AllArchitecture:  allArch,
+			SaveOptions:      saveOptions(target == ""),
+			OperatingSystems: operatingSystems,
+			CreateMultiArch:  createMultiArch,
+			LocalOnly:        noPull,
+			Annotations:      taskAnnotations,
+			PreserveIndex:    preserveIndex,
+			SourceOrder:      sourceOrder,
+			Verbatim:         verbatim,
+			OnSourceChange:   sourceChange,
+			Squash:           transform.Squash,
+			Recompress:       string(transform.Recompress),
+			SmokeTest:        smoke,
+		})
+	}
+	return tasks, nil
+}
+
+// saveOptions returns the save options of the flags
+func saveOptions(save bool) config.SaveOptions {
+	return config.SaveOptions{
+		Save:                  save,
+		OutputDir:             outputDir,
+		Compress:              useCompression,
+		Compression:           compressionMode,
+		CompressionPreference: compressionPreference,
+		CompressionSample:     compressionSample,
+		RepoTags:              repoTags,
+		BlobStore:             blobStore,
+		Compat:                compat,
+	}
+}
+
+// prefixedTarget returns the target of a source below prefix, keeping the
+// repository path and tag of the source
+func prefixedTarget(prefix, source string) (string, error) {
+	named, err := imageref.Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %v", source, err)
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+	if !ok {
+		return "", fmt.Errorf("%s has no tag to push under --target-prefix", source)
+	}
+	return fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(prefix, "/"), reference.Path(named), tagged.Tag()), nil
+}
+
+// checkTargets checks the target of every task against the reference
+// grammar before any work starts and reports all invalid targets at once.
+// With --normalize-names the targets are normalized first. Tasks that are
+// then identical are merged with a notice, and tasks pushing the same
+// references are rejected, or only warned about with
+// --allow-duplicate-targets. The tasks left are returned.
+func checkTargets(tasks []config.ImageTask) ([]config.ImageTask, error) {
+	if err := normalizeTargets(tasks); err != nil {
+		return nil, err
+	}
+	tasks, merged := config.MergeDuplicates(tasks)
+	for _, notice := range merged {
+		infof("%s", notice)
+	}
+
+	collisions := config.TargetCollisions(tasks)
+	if len(collisions) > 0 && !allowDupTarget {
+		return nil, fmt.Errorf("tasks push to the same targets, the last overwriting the others (--allow-duplicate-targets only warns):\n%s", strings.Join(collisions, "\n"))
+	}
+	for _, collision := range collisions {
+		warnf("%s; the last one overwrites the others", collision)
+	}
+	return tasks, nil
+}
+
+// normalizeTargets checks the target of every task, normalized with
+// --normalize-names, and reports all invalid targets at once
+func normalizeTargets(tasks []config.ImageTask) error {
+	var invalid []string
+	for i := range tasks {
+		if tasks[i].Target == "" {
+			continue
+		}
+		target, err := checkTarget(tasks[i].Label(i), tasks[i].Target)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		tasks[i].Target = target
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid targets:\n%s", strings.Join(invalid, "\n"))
+	}
+	return nil
+}
+
+// checkTarget returns the target, normalized with --normalize-names, or an
+// error if it is not a valid reference. Targets deeper than their registry
+// allows are only warned about, as the registry has the final say.
+func checkTarget(label, target string) (string, error) {
+	if normalizeNames {
+		if normalized := imageref.Normalize(target); normalized != target {
+			infof("%s: normalized the target %s to %s", label, target, normalized)
+			target = normalized
+		}
+	}
+	if err := imageref.Validate(target); err != nil {
+		if !normalizeNames && imageref.Validate(imageref.Normalize(target)) == nil {
+			return "", fmt.Errorf("%s: %s: %v (--normalize-names fixes it)", label, target, err)
+		}
+		return "", fmt.Errorf("%s: %s: %v", label, target, err)
+	}
+	named, err := imageref.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s: %v", label, target, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return "", fmt.Errorf("%s: %s is a digest reference; images are pushed under a tag, and the registry computes their digest", label, target)
+	}
+	if limit, ok := imageref.PathLimit(named); ok {
+		if depth := strings.Count(reference.Path(named), "/") + 1; depth > limit {
+			warnf("%s: %s has %d path components, but %s accepts at most %d", label, target, depth, reference.Domain(named), limit)
+		}
+	}
+	return target, nil
+}
+
+// joinInts formats a list of task numbers
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// unchanged reports whether the state entry of a task lets the run skip
+// it: the source still has the digest mirrored last, no earlier run
+// deferred the task and --refresh-state isn't given
+func unchanged(entry state.Entry, digest string) bool {
+	return entry.Deferred == nil && entry.Digest == digest && !refreshState
+}
+
+// stateEntry returns the state entry recording a successful task
+func stateEntry(task config.ImageTask, digest string, result *docker.TaskResult) state.Entry {
+	entry := state.Entry{
+		Source:     task.Source,
+		Target:     task.Target,
+		Digest:     digest,
+		MirroredAt: time.Now().UTC(),
+	}
+	for _, platform := range result.Platforms {
+		entry.Platforms = append(entry.Platforms, platform.Platform)
+		if platform.Compression != nil {
+			if entry.Compression == nil {
+				entry.Compression = make(map[string]string)
+			}
+			entry.Compression[platform.Platform] = string(platform.Compression.Compression)
+		}
+	}
+	return entry
+}
+
+// setCompression sets the compression of the archives a task saves in
+// options, keeping the compressions pinned records for their platforms
+func setCompression(options *docker.SaveOptions, save config.SaveOptions, pinned map[string]string) error {
+	options.Compression = save.Compression
+	if save.Compression != archive.Auto {
+		return nil
+	}
+	var err error
+	if options.CompressionPreference, err = archive.ParsePreference(save.CompressionPreference); err != nil {
+		return err
+	}
+	if save.CompressionSample != "" {
+		if options.CompressionSample, err = bandwidth.ParseSize(save.CompressionSample); err != nil {
+			return err
+		}
+	}
+	options.PinnedCompression = make(map[string]archive.Compression)
+	for platform, compression := range pinned {
+		options.PinnedCompression[platform] = archive.Compression(compression)
+	}
+	return nil
+}
+
+// deferTask marks a task deferred by the transfer budget in the state file,
+// with its deferred platforms when result is not nil, so a later run
+// migrates it even if its source is unchanged
+func deferTask(mirrored *state.File, i int, task config.ImageTask, digest string, result *docker.TaskResult) {
+	if mirrored == nil {
+		return
+	}
+	deferral := state.Deferral{Digest: digest, DeferredAt: time.Now().UTC()}
+	if result != nil {
+		for _, platform := range result.Platforms {
+			if platform.Deferred {
+				deferral.Platforms = append(deferral.Platforms, platform.Platform)
+			}
+		}
+	}
+	if err := mirrored.Defer(task.Source, task.Target, deferral); err != nil {
+		warnf("Failed to record the deferral of task %d in the state file: %v", i+1, err)
+	}
+}
+
+// processTask runs a single configuration task within ctx. digest is the
+// resolved source digest, if known, which lets the task reuse platforms
+// earlier tasks pulled, and pinned the compressions the state file
+// recorded for its archives. The task and operation timeouts of the task
+// override those of defaults.
+func processTask(ctx context.Context, client *docker.Client, i int, task config.ImageTask, digest string, pinned map[string]string, auth docker.RegistryAuth, defaults config.Durations) (*docker.TaskResult, error) {
+	timeouts, err := task.Timeouts.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("task %d: %v", i+1, err)
+	}
+	timeouts = timeouts.Or(defaults)
+	if timeouts.Task > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Task)
+		defer cancel()
+	}
+
+	var bandwidthLimit int64
+	if task.BandwidthLimit != "" {
+		if bandwidthLimit, err = bandwidth.ParseRate(task.BandwidthLimit); err != nil {
+			return nil, fmt.Errorf("task %d: %v", i+1, err)
+		}
+	}
+
+	annotations, err := config.RenderAnnotations(task.Annotations, config.AnnotationData{
+		Name:         task.Name,
+		Source:       task.Source,
+		Target:       task.Target,
+		SourceDigest: digest,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("task %d: %v", i+1, err)
+	}
+	minSuccess, requireAll, err := task.MinSuccess.Count()
+	if err != nil {
+		return nil, fmt.Errorf("task %d: %v", i+1, err)
+	}
+	options := docker.SaveOptions{
+		Name:             task.Name,
+		UseCompression:   task.Compress,
+		OutputDir:        task.OutputDir,
+		OperatingSystems: task.OperatingSystems,
+		CreateMultiArch:  task.CreateMultiArch,
+		SourceDigest:     digest,
+		PinDigests:       task.SourceFrom != "",
+		OnSourceChange:   task.OnSourceChange,
+		ArchTag:          task.ArchTag,
+		RepoTags:         task.RepoTags,
+		BlobStore:        task.BlobStore,
+		Compat:           archive.Compat(task.Compat),
+		LocalOnly:        task.LocalOnly,
+		Annotations:      annotations,
+		PreserveIndex:    task.PreserveIndex,
+		SourceOrder:      task.SourceOrder,
+		Verbatim:         task.Verbatim,
+		Transform:        archive.Transform{Squash: task.Squash, Recompress: archive.Compression(task.Recompress)},
+		OperationTimeout: timeouts.Operation,
+		BandwidthLimit:   bandwidthLimit,
+		SplitPlatforms:   task.SplitPlatforms,
+		RequirePlatforms: task.RequirePlatforms,
+		MinSuccess:       minSuccess,
+		RequireAll:       requireAll,
+		Rollback:         task.RollbackOnFailure,
+	}
+	if err := setCompression(&options, task.SaveOptions, pinned); err != nil {
+		return nil, fmt.Errorf("task %d: %v", i+1, err)
+	}
+	if task.SmokeTest != nil {
+		options.SmokeTest = task.SmokeTest.Mode
+		if options.SmokeTest == "" {
+			options.SmokeTest = config.SmokeTestPull
+		}
+		options.SmokeTestRemove = task.SmokeTest.Remove
+	}
+
+	// Set default OS if not specified
+	if len(options.OperatingSystems) == 0 {
+		options.OperatingSystems = []string{"linux"}
+	}
+
+	// Tasks with a target are only pushed, whether or not they save
+	switch {
+	case len(task.Archives) > 0:
+	case task.ReadsLayout() && (task.Target != "" || task.Save):
+	case task.Target == "" && !task.Save:
+		return nil, fmt.Errorf("task %d: either target must be specified or save must be true", i+1)
+	case !task.AllArchitecture && len(task.Architectures) == 0:
+		return nil, fmt.Errorf("task %d: either all_architectures must be true or architectures must be specified", i+1)
+	}
+	return client.ProcessImageTask(ctx, docker.TaskSpec{
+		Source:           task.Source,
+		Target:           task.Target,
+		Architectures:    task.Architectures,
+		AllArchitectures: task.AllArchitecture,
+		Save:             task.Target == "",
+		Archives:         task.Archives,
+		Auth:             auth,
+		Options:          options,
+	})
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+)
+
+// useConfig writes a configuration to a temporary file and points
+// --config at it, resetting the flags resolveConfig reads once the test
+// ends
+func useConfig(t *testing.T, data string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "migrate.yaml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile = path
+	t.Cleanup(func() {
+		configFile, outputRoot, policyFile = "", "", ""
+		normalizeNames, allowDupTarget = false, false
+	})
+}
+
+// TestMergeAfterNormalizing resolves configurations whose tasks are only
+// identical once the targets are normalized or the output root applied,
+// which are merged rather than rejected as pushing the same target
+func TestMergeAfterNormalizing(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		name      string
+		config    string
+		normalize bool
+		root      string
+		// want holds the targets or output directories of the tasks left
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "identical",
+			config: `images:
+  - source: nginx:1.25
+    target: registry.example.com/nginx:1.25
+  - source: nginx:1.25
+    target: registry.example.com/nginx:1.25
+`,
+			want: []string{"registry.example.com/nginx:1.25"},
+		},
+		{
+			name: "normalized targets",
+			config: `images:
+  - source: nginx:1.25
+    target: registry.example.com/Team/nginx:1.25
+  - source: nginx:1.25
+    target: registry.example.com/team//nginx:1.25
+`,
+			normalize: true,
+			want:      []string{"registry.example.com/team/nginx:1.25"},
+		},
+		{
+			name: "unnormalized targets",
+			config: `images:
+  - source: nginx:1.25
+    target: registry.example.com/Team/nginx:1.25
+  - source: nginx:1.25
+    target: registry.example.com/team/nginx:1.25
+`,
+			wantErr: "--normalize-names fixes it",
+		},
+		{
+			name: "output root",
+			config: `images:
+  - source: nginx:1.25
+    save: true
+  - source: nginx:1.25
+    save: true
+    output_dir: ` + filepath.Join(root, "nginx") + `
+`,
+			root: root,
+			want: []string{filepath.Join(root, "nginx")},
+		},
+		{
+			name: "same target, other source",
+			config: `images:
+  - source: nginx:1.25
+    target: registry.example.com/Team/nginx:1.25
+  - source: nginx:1.25-alpine
+    target: registry.example.com/team/nginx:1.25
+`,
+			normalize: true,
+			wantErr:   "registry.example.com/team/nginx:1.25 is pushed by",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.config)
+			normalizeNames, outputRoot = tt.normalize, tt.root
+
+			cfg, err := resolveConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveConfig() error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveConfig(): %v", err)
+			}
+			var got []string
+			for _, task := range cfg.ImageTask {
+				if task.Target != "" {
+					got = append(got, task.Target)
+				} else {
+					got = append(got, task.OutputDir)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("tasks %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPolicyAfterNormalizing checks the policy of the configuration and
+// that of --policy-file against the targets as pushed
+func TestPolicyAfterNormalizing(t *testing.T) {
+	central := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(central, []byte("deny_latest_targets: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const policy = `policy:
+  allowed_target_registries: ["registry.example.com"]
+images:
+`
+	tests := []struct {
+		name      string
+		images    string
+		normalize bool
+		central   bool
+		wantErr   string
+	}{
+		{"normalized target allowed", "  - source: nginx:1.25\n    target: registry.example.com/Team/nginx:1.25\n", true, false, ""},
+		{"normalized registry allowed", "  - source: nginx:1.25\n    target: Registry.Example.com/nginx:1.25\n", true, false, ""},
+		{"registry denied", "  - source: nginx:1.25\n    target: harbor.example.com/Team/nginx:1.25\n", true, false, "target registry harbor.example.com"},
+		{"latest denied by --policy-file", "  - source: nginx:1.25\n    target: registry.example.com/Team/nginx\n", true, true, ":latest tag"},
+		{"both violated", "  - source: nginx:1.25\n    target: harbor.example.com/nginx\n", false, true, "target registry harbor.example.com is not in allowed_target_registries\ntask 1 (nginx:1.25): target \"harbor.example.com/nginx\" resolves to the :latest tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, policy+tt.images)
+			normalizeNames = tt.normalize
+			if tt.central {
+				policyFile = central
+			}
+
+			_, err := resolveConfig()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("resolveConfig(): %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("resolveConfig() error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestResumeRun decides which tasks of a partly finished run the next run
+// skips from the state file the first one left
+func TestResumeRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	first, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().UTC()
+	if err := first.Record(state.Entry{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", Digest: "sha256:aaa", MirroredAt: at}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Record(state.Entry{Source: "redis:7", Target: "registry.example.com/redis:7", Digest: "sha256:bbb", MirroredAt: at}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Defer("alpine:3.20", "registry.example.com/alpine:3.20", state.Deferral{Digest: "sha256:ccc", DeferredAt: at}); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		source  string
+		target  string
+		digest  string
+		refresh bool
+		want    bool
+	}{
+		{"mirrored, unchanged", "nginx:1.25", "registry.example.com/nginx:1.25", "sha256:aaa", false, true},
+		{"mirrored, other spelling", "docker.io/library/nginx:1.25", "registry.example.com/nginx:1.25", "sha256:aaa", false, true},
+		{"mirrored, source changed", "redis:7", "registry.example.com/redis:7", "sha256:bbc", false, false},
+		{"mirrored, --refresh-state", "nginx:1.25", "registry.example.com/nginx:1.25", "sha256:aaa", true, false},
+		{"deferred", "alpine:3.20", "registry.example.com/alpine:3.20", "sha256:ccc", false, false},
+		{"not reached", "busybox:1.36", "registry.example.com/busybox:1.36", "sha256:ddd", false, false},
+		{"other target", "nginx:1.25", "registry.example.com/mirror/nginx:1.25", "sha256:aaa", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refreshState = tt.refresh
+			t.Cleanup(func() { refreshState = false })
+			entry, ok := next.Get(tt.source, tt.target)
+			if got := ok && unchanged(entry, tt.digest); got != tt.want {
+				t.Errorf("skipped = %v, want %v (entry %+v)", got, tt.want, entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/spf13/cobra"
+)
+
+var validateOutput string
+
+// validateArchiveCmd checks saved archives without a docker daemon
+var validateArchiveCmd = &cobra.Command{
+	Use:   "validate-archive <path|dir|url|dir:path|oci:path>...",
+	Short: "Check that saved archives are complete and loadable",
+	Long: `Check image archives without a docker daemon: each archive is decompressed
+and its tar structure parsed, the configs, manifests and layers that
+manifest.json and index.json reference must be present, blobs must match the
+digests they are named after, and layers the diff IDs of their image config.
+When a SHA256SUMS file next to an archive lists it, its checksum is compared
+too.
+
+An http(s) URL argument downloads the archive, and the SHA256SUMS next to
+it when the server has one, to --tmpdir first, streaming it to disk.
+
+--compat docker-legacy also checks that every archive meets the profile
+Docker 17.x loads: a docker archive compressed with gzip or not at all,
+without index.json or oci-layout, whose images have a single RepoTag, a
+config named <digest>.json with a layers rootfs and uncompressed layers
+named <id>/layer.tar.
+
+A dir:/path or oci:/path[:ref] argument validates a layout skopeo copy
+wrote: every manifest and blob it references must be present and match its
+digest. Missing blobs are reported as skopeo reports them.
+
+A directory argument validates the archives and OCI layout directories in
+it. Exits with 4 when some archives are corrupt and 5 when all are.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if validateOutput != "text" && validateOutput != "json" {
+			return fmt.Errorf("unsupported output format %q, use text or json", validateOutput)
+		}
+		profile, err := archive.ParseCompat(compat)
+		if err != nil {
+			return fmt.Errorf("invalid --compat: %v", err)
+		}
+
+		var paths []string
+		// urls maps downloaded archives to the URLs they were given as
+		urls := make(map[string]string)
+		for _, arg := range args {
+			if archive.IsSource(arg) {
+				paths = append(paths, arg)
+				continue
+			}
+			if httpfile.IsURL(arg) {
+				local, cleanup, err := fetchArchive(context.Background(), arg, false)
+				if err != nil {
+					return err
+				}
+				defer cleanup()
+				urls[local] = httpfile.Redact(arg)
+				paths = append(paths, local)
+				continue
+			}
+			info, err := os.Stat(arg)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				paths = append(paths, arg)
+				continue
+			}
+			found, err := archive.ArchivesIn(arg)
+			if err != nil {
+				return err
+			}
+			if len(found) == 0 {
+				return fmt.Errorf("no archives found in %s", arg)
+			}
+			paths = append(paths, found...)
+		}
+
+		out := cmd.OutOrStdout()
+		validations := make([]*archive.Validation, 0, len(paths))
+		failed := 0
+		for _, path := range paths {
+			var v *archive.Validation
+			if archive.IsSource(path) {
+				v = archive.ValidateSource(path)
+				if profile != archive.CompatDefault {
+					v.Compat = profile
+					v.Problems = append(v.Problems, fmt.Sprintf("%s: a layout, not a docker archive", profile))
+				}
+			} else {
+				v = archive.ValidateCompat(path, profile)
+			}
+			if url, ok := urls[path]; ok {
+				v.Path = url
+			}
+			validations = append(validations, v)
+			if !v.OK() {
+				failed++
+			}
+			if validateOutput == "text" {
+				printValidation(out, v)
+			}
+		}
+		if validateOutput == "json" {
+			if err := printJSON(out, validations); err != nil {
+				return err
+			}
+		}
+
+		if failed == 0 {
+			return nil
+		}
+		code := ExitPartialFailure
+		if failed == len(validations) {
+			code = ExitFailure
+		}
+		if profile != archive.CompatDefault {
+			return &exitError{code: code, err: fmt.Errorf("%d of %d archives are corrupt or don't meet %s", failed, len(validations), profile)}
+		}
+		return &exitError{code: code, err: fmt.Errorf("%d of %d archives are corrupt", failed, len(validations))}
+	},
+}
+
+// printValidation prints the outcome of validating an archive
+func printValidation(out io.Writer, v *archive.Validation) {
+	if !v.OK() {
+		fmt.Fprintf(out, "CORRUPT  %s\n", v.Path)
+		for _, problem := range v.Problems {
+			fmt.Fprintf(out, "  - %s\n", problem)
+		}
+		return
+	}
+
+	images := "images"
+	if v.Images == 1 {
+		images = "image"
+	}
+	details := fmt.Sprintf("%s, %d %s", v.Format, v.Images, images)
+	if v.Checksum {
+		details += ", " + archive.ChecksumFile + " verified"
+	}
+	if v.Compat != archive.CompatDefault {
+		details += ", " + string(v.Compat)
+	}
+	fmt.Fprintf(out, "OK       %s (%s)\n", v.Path, details)
+}
+
+func init() {
+	rootCmd.AddCommand(validateArchiveCmd)
+
+	validateArchiveCmd.Flags().StringVarP(&validateOutput, "output", "o", "text", "Output format: text or json")
+	validateArchiveCmd.Flags().StringVar(&compat, "compat", "", "Also check that the archives meet this compatibility profile: docker-legacy for the classic docker save layout, gzip only and a single RepoTag, which Docker 17.x loads")
+}
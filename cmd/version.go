@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/version"
+	"github.com/spf13/cobra"
+)
+
+var versionOutput string
+
+// versionCmd prints build metadata and the versions of docker in use
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version information for imgMigrate and docker",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if versionOutput != "text" && versionOutput != "json" {
+			return fmt.Errorf("unsupported output format %q, use text or json", versionOutput)
+		}
+
+		info := struct {
+			version.Info
+			Docker      *docker.VersionInfo `json:"docker,omitempty"`
+			DockerError string              `json:"docker_error,omitempty"`
+		}{Info: version.Get()}
+
+		// Docker being unavailable is reported, not treated as a failure
+		client, err := docker.NewClient(append(dockerOptions(), docker.WithLogger(logger))...)
+		if err == nil {
+			var dockerInfo docker.VersionInfo
+			dockerInfo, err = client.Version()
+			info.Docker = &dockerInfo
+		}
+		if err != nil {
+			info.DockerError = err.Error()
+		}
+
+		out := cmd.OutOrStdout()
+		if versionOutput == "json" {
+			data, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, string(data))
+			return nil
+		}
+
+		fmt.Fprintf(out, "imgMigrate %s\n", info.Version)
+		fmt.Fprintf(out, "  commit:     %s\n", info.Commit)
+		fmt.Fprintf(out, "  built:      %s\n", info.Date)
+		fmt.Fprintf(out, "  go version: %s\n", info.GoVersion)
+		fmt.Fprintf(out, "docker\n")
+		if info.Docker != nil {
+			fmt.Fprintf(out, "  CLI:        %s\n", valueOr(info.Docker.CLIVersion, "unknown"))
+			fmt.Fprintf(out, "  daemon:     %s\n", valueOr(info.Docker.ServerVersion, "unknown"))
+			fmt.Fprintf(out, "  API:        %s\n", valueOr(info.Docker.APIVersion, "unknown"))
+		}
+		if info.DockerError != "" {
+			fmt.Fprintf(out, "  error:      %s\n", info.DockerError)
+		}
+		return nil
+	},
+}
+
+// valueOr returns value, or fallback when value is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().StringVarP(&versionOutput, "output", "o", "text", "Output format: text or json")
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/Fr000g/ImgMigrate/pkg/state"
+)
+
+// watchTasks runs the configured tasks in cycles separated by interval until
+// the process receives SIGINT or SIGTERM. A signal received mid-cycle lets the
+// current task finish and then stops; one received while sleeping stops
+// immediately. Cycles never overlap since each one runs to completion before
+// the next sleep starts.
+func watchTasks(client *docker.Client, cfg *config.Config, auth docker.RegistryAuth, mirrored *state.File, rep *report.Report, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("watch interval must be positive")
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	// Aborting from the --tui dashboard ends watch mode too
+	ctx, detach := attachDashboard(ctx, cfg.ImageTask)
+	defer detach()
+
+	for cycle := 1; ; cycle++ {
+		start := time.Now()
+		infof("Starting watch cycle %d", cycle)
+
+		rep.Reset()
+		failed := runTasks(ctx, client, cfg, auth, mirrored, rep)
+		// --refresh-state only forces the first cycle
+		refreshState = false
+		if err := finishReport(rep, nil); err != nil {
+			warnf("%v", err)
+		}
+		infof("Watch cycle %d finished in %s with %d failed tasks",
+			cycle, time.Since(start).Round(time.Second), failed)
+
+		if ctx.Err() != nil {
+			infof("Received shutdown signal, exiting watch mode")
+			return nil
+		}
+
+		infof("Next cycle at %s", time.Now().Add(interval).Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			infof("Received shutdown signal, exiting watch mode")
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/budget"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/Fr000g/ImgMigrate/pkg/window"
+)
+
+var (
+	windowSpec  string
+	maxTransfer string
+	// transferWindow limits transfers to a daily window, set by --window
+	transferWindow *window.Window
+	// transferBudget caps the bytes a run transfers, set by --max-transfer
+	transferBudget *budget.Budget
+)
+
+// waitForWindow blocks while the --window is closed, before the task or
+// platform named by before, and records the pause in rep. It returns how
+// long it waited, also when ctx ended first.
+func waitForWindow(ctx context.Context, rep *report.Report, before string) time.Duration {
+	if transferWindow == nil {
+		return 0
+	}
+	start := time.Now()
+	if transferWindow.Open(start) {
+		return 0
+	}
+	next := transferWindow.NextOpen(start)
+	infof("Outside the transfer window %s, pausing before %s until %s", transferWindow, before, next.Format(time.RFC3339))
+	if dashboard != nil {
+		dashboard.SetWaiting("for the transfer window until " + next.Format("15:04"))
+		defer dashboard.SetWaiting("")
+	}
+
+	_, err := transferWindow.Wait(ctx)
+	end := time.Now()
+	waited := end.Sub(start)
+	estimator.Exclude(waited)
+	if rep != nil {
+		rep.AddPause(report.PauseWindow, before, start, end)
+	}
+	if err == nil {
+		infof("The transfer window %s opened, resuming after a pause of %s", transferWindow, waited.Round(time.Second))
+	}
+	return waited
+}
@@ -0,0 +1,12 @@
+// Package fuzztest holds the inputs the fuzz targets of several packages
+// share
+package fuzztest
+
+// Platforms are the platforms the fuzz targets name tags and archives
+// for, as sources list them
+var Platforms = []string{"linux/amd64", "linux/arm64", "linux/arm64/v8", "linux/arm/v7", "linux/arm/v6", "linux/386", "linux/ppc64le", "linux/s390x", "linux/riscv64", "windows/amd64"}
+
+// Platform returns the platform a fuzzed index selects
+func Platform(i uint8) string {
+	return Platforms[int(i)%len(Platforms)]
+}
@@ -0,0 +1,217 @@
+// Package archive reads and writes the image archives the tool saves,
+// without a docker daemon: docker save tarballs, optionally compressed with
+// gzip or zstd, OCI image layout directories and OCI archives.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Layout is how the images in an archive are described
+type Layout string
+
+// Supported layouts
+const (
+	// LayoutDocker archives list their images in manifest.json, as written
+	// by docker save. Archives of Docker 25 and later are OCI layouts too.
+	LayoutDocker Layout = "docker-archive"
+	// LayoutOCI archives are OCI image layouts listing their images in
+	// index.json
+	LayoutOCI Layout = "oci"
+)
+
+// Files that identify the layout of an archive
+const (
+	dockerManifestFile = "manifest.json"
+	ociIndexFile       = "index.json"
+	ociLayoutFile      = "oci-layout"
+)
+
+// Format is the format of an image archive
+type Format struct {
+	Layout      Layout
+	Compression Compression
+	// Dir is set for an OCI image layout directory instead of a tar archive
+	Dir bool
+}
+
+// formats are the formats by the names ParseFormat accepts
+var formats = map[string]Format{
+	"tar":             {Layout: LayoutDocker},
+	"tar.gz":          {Layout: LayoutDocker, Compression: Gzip},
+	"tar.zst":         {Layout: LayoutDocker, Compression: Zstd},
+	"oci-layout":      {Layout: LayoutOCI, Dir: true},
+	"oci-archive":     {Layout: LayoutOCI},
+	"oci-archive.gz":  {Layout: LayoutOCI, Compression: Gzip},
+	"oci-archive.zst": {Layout: LayoutOCI, Compression: Zstd},
+}
+
+// FormatNames lists the names ParseFormat accepts
+const FormatNames = "tar, tar.gz, tar.zst, oci-layout, oci-archive, oci-archive.gz or oci-archive.zst"
+
+// String returns the name of the format as ParseFormat accepts it
+func (f Format) String() string {
+	for name, format := range formats {
+		if format == f {
+			return name
+		}
+	}
+	return fmt.Sprintf("%s (%s)", f.Layout, f.Compression)
+}
+
+// ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	format, ok := formats[name]
+	if !ok {
+		return Format{}, fmt.Errorf("unknown archive format %q, use %s", name, FormatNames)
+	}
+	return format, nil
+}
+
+// FormatForPath returns the format an output path asks for by its name:
+// .tar, .tar.gz, .tgz and .tar.zst are docker archives, .oci.tar,
+// .oci.tar.gz and .oci.tar.zst OCI archives, and existing directories or
+// paths ending in a separator OCI image layouts
+func FormatForPath(path string) (Format, error) {
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		return formats["oci-layout"], nil
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return formats["oci-layout"], nil
+	}
+
+	name := strings.ToLower(filepath.Base(path))
+	for _, suffix := range []struct {
+		ext    string
+		format string
+	}{
+		{".oci.tar", "oci-archive"},
+		{".oci.tar.gz", "oci-archive.gz"},
+		{".oci.tar.zst", "oci-archive.zst"},
+		{".tar", "tar"},
+		{".tar.gz", "tar.gz"},
+		{".tgz", "tar.gz"},
+		{".tar.zst", "tar.zst"},
+	} {
+		if strings.HasSuffix(name, suffix.ext) {
+			return formats[suffix.format], nil
+		}
+	}
+	return Format{}, fmt.Errorf("can't tell the archive format from the name %s, choose one of %s", path, FormatNames)
+}
+
+// Detect returns the format of an archive from its content. Archives with
+// a manifest.json are docker archives, even when they are OCI layouts as
+// well. Inputs that are not image archives fail with the reason.
+func Detect(path string) (Format, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Format{}, err
+	}
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, ociIndexFile)); err != nil {
+			return Format{}, fmt.Errorf("%s is not an OCI image layout: %s is missing", path, ociIndexFile)
+		}
+		return formats["oci-layout"], nil
+	}
+
+	tr, compression, closer, err := openTar(path)
+	if err != nil {
+		return Format{}, err
+	}
+	defer closer.Close()
+
+	format := Format{Compression: compression}
+	entries := 0
+	hasIndex := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Format{}, readError(path, compression, entries, err)
+		}
+		entries++
+
+		switch cleanName(hdr.Name) {
+		case dockerManifestFile:
+			format.Layout = LayoutDocker
+			return format, nil
+		case ociIndexFile:
+			hasIndex = true
+		}
+	}
+
+	if entries == 0 {
+		return Format{}, fmt.Errorf("%s is empty or not a tar archive", path)
+	}
+	if !hasIndex {
+		return Format{}, fmt.Errorf("%s is a tar archive but not an image archive: it has neither %s nor %s", path, dockerManifestFile, ociIndexFile)
+	}
+	format.Layout = LayoutOCI
+	return format, nil
+}
+
+// openTar opens a tar archive, decompressing it when needed. Uncompressed
+// archives are read from the file directly, so that skipping entries seeks
+// past them.
+func openTar(path string) (*tar.Reader, Compression, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Uncompressed, nil, err
+	}
+
+	compression := detectCompression(bufio.NewReader(file))
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, compression, nil, err
+	}
+	if compression == Uncompressed {
+		return tar.NewReader(file), compression, file, nil
+	}
+
+	rc, _, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, compression, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tar.NewReader(rc), compression, multiCloser{rc, file}, nil
+}
+
+// readError explains a failure to read the tar stream of an archive
+func readError(path string, compression Compression, entries int, err error) error {
+	switch {
+	case entries == 0 && compression == Uncompressed && errors.Is(err, tar.ErrHeader):
+		return fmt.Errorf("%s is not a tar archive, or is compressed with an unsupported format: %v", path, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%s is truncated: %v", path, err)
+	}
+	return fmt.Errorf("%s is corrupt: %v", path, err)
+}
+
+// cleanName returns the name of a tar entry without a leading ./ or /
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// multiCloser closes several closers in order
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// Auto is the compression mode that picks gzip or zstd for every archive
+// from a sample of its data; see AutoWriter
+const Auto = "auto"
+
+// DefaultSampleSize is how much of an archive Auto compresses with every
+// candidate before picking one
+const DefaultSampleSize = 16 << 20
+
+// Preference is what Auto weighs when picking a compression
+type Preference string
+
+// Supported preferences
+const (
+	// PreferRatio picks the compression writing the smallest sample
+	PreferRatio Preference = "ratio"
+	// PreferSpeed picks the fastest compression
+	PreferSpeed Preference = "speed"
+	// PreferBalanced picks the smallest unless another is at least twice
+	// as fast and at most 10% larger
+	PreferBalanced Preference = "balanced"
+)
+
+// ParsePreference parses a compression preference, balanced when empty
+func ParsePreference(name string) (Preference, error) {
+	switch preference := Preference(name); preference {
+	case "":
+		return PreferBalanced, nil
+	case PreferRatio, PreferSpeed, PreferBalanced:
+		return preference, nil
+	}
+	return "", fmt.Errorf("unknown compression preference %q, supported: ratio, speed, balanced", name)
+}
+
+// Trial is the result of compressing the sample with a candidate
+type Trial struct {
+	Compression Compression `json:"compression"`
+	// Ratio is the compressed size of the sample over its size
+	Ratio float64 `json:"ratio"`
+	// Speed is the throughput in bytes of the sample per second
+	Speed float64 `json:"speed"`
+}
+
+// Choice records the compression of an archive and how it was picked
+type Choice struct {
+	Compression Compression `json:"compression"`
+	Preference  Preference  `json:"preference,omitempty"`
+	SampleSize  int64       `json:"sample_size,omitempty"`
+	Trials      []Trial     `json:"trials,omitempty"`
+	// Pinned is set when the compression was kept from an earlier run
+	// instead of picked
+	Pinned bool `json:"pinned,omitempty"`
+	// Ratio is the size of the archive written over its size uncompressed
+	Ratio float64 `json:"ratio,omitempty"`
+}
+
+// Extension returns the file extension of a docker archive compressed
+// with compression
+func Extension(compression Compression) string {
+	switch compression {
+	case Gzip:
+		return ".tar.gz"
+	case Zstd:
+		return ".tar.zst"
+	}
+	return ".tar"
+}
+
+// choose compresses sample with every available candidate and picks one
+// as preference says. zstd is only a candidate when its command is
+// installed.
+func choose(sample []byte, preference Preference) (Choice, error) {
+	choice := Choice{Preference: preference, SampleSize: int64(len(sample))}
+	candidates := []Compression{Gzip}
+	if _, err := exec.LookPath(zstdBinary); err == nil {
+		candidates = append(candidates, Zstd)
+	}
+	for _, candidate := range candidates {
+		trial, err := try(sample, candidate)
+		if err != nil {
+			return choice, err
+		}
+		choice.Trials = append(choice.Trials, trial)
+	}
+
+	best := choice.Trials[0]
+	for _, trial := range choice.Trials[1:] {
+		if preference == PreferSpeed && trial.Speed > best.Speed || preference != PreferSpeed && trial.Ratio < best.Ratio {
+			best = trial
+		}
+	}
+	if preference == PreferBalanced {
+		smallest := best
+		for _, trial := range choice.Trials {
+			if trial.Speed >= 2*smallest.Speed && trial.Ratio <= smallest.Ratio*1.1 && trial.Speed > best.Speed {
+				best = trial
+			}
+		}
+	}
+	choice.Compression = best.Compression
+	return choice, nil
+}
+
+// try compresses sample with compression, timing it
+func try(sample []byte, compression Compression) (Trial, error) {
+	var out countWriter
+	start := time.Now()
+	w, err := compress(&out, compression)
+	if err != nil {
+		return Trial{}, err
+	}
+	if _, err := w.Write(sample); err != nil {
+		w.Close()
+		return Trial{}, err
+	}
+	if err := w.Close(); err != nil {
+		return Trial{}, err
+	}
+	trial := Trial{Compression: compression, Speed: float64(len(sample)) / max(time.Since(start).Seconds(), 1e-6)}
+	if len(sample) > 0 {
+		trial.Ratio = float64(out.n) / float64(len(sample))
+	}
+	return trial, nil
+}
+
+// countWriter counts what is written to it, passing it on to w or, without
+// one, discarding it
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.w == nil {
+		return len(p), nil
+	}
+	return c.w.Write(p)
+}
+
+// AutoWriter compresses an archive to w. It holds back the first
+// sampleSize bytes written, compresses them with every candidate to pick a
+// compression, and compresses all of the data with it; a pinned
+// compression is used from the start instead.
+type AutoWriter struct {
+	out        countWriter
+	preference Preference
+	sampleSize int64
+	sample     bytes.Buffer
+	written    int64
+	w          io.WriteCloser
+	choice     Choice
+}
+
+// NewAutoWriter returns an AutoWriter to w. pinned, when not Uncompressed,
+// is used instead of picking a compression.
+func NewAutoWriter(w io.Writer, preference Preference, sampleSize int64, pinned Compression) (*AutoWriter, error) {
+	if sampleSize <= 0 {
+		sampleSize = DefaultSampleSize
+	}
+	a := &AutoWriter{out: countWriter{w: w}, preference: preference, sampleSize: sampleSize}
+	if pinned != Uncompressed {
+		a.choice = Choice{Compression: pinned, Pinned: true}
+		var err error
+		if a.w, err = compress(&a.out, pinned); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *AutoWriter) Write(p []byte) (int, error) {
+	a.written += int64(len(p))
+	if a.w != nil {
+		return a.w.Write(p)
+	}
+	a.sample.Write(p)
+	if int64(a.sample.Len()) < a.sampleSize {
+		return len(p), nil
+	}
+	if err := a.pick(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// pick chooses the compression from the sample held back and writes it
+func (a *AutoWriter) pick() error {
+	choice, err := choose(a.sample.Bytes(), a.preference)
+	if err != nil {
+		return fmt.Errorf("failed to pick a compression: %v", err)
+	}
+	a.choice = choice
+	if a.w, err = compress(&a.out, choice.Compression); err != nil {
+		return err
+	}
+	_, err = a.w.Write(a.sample.Bytes())
+	a.sample = bytes.Buffer{}
+	return err
+}
+
+// Close picks the compression of an archive smaller than the sample and
+// finishes the compressed data, leaving w open
+func (a *AutoWriter) Close() error {
+	if a.w == nil {
+		if err := a.pick(); err != nil {
+			return err
+		}
+	}
+	return a.w.Close()
+}
+
+// Choice returns the compression used and, once the writer is closed, the
+// ratio of the whole archive
+func (a *AutoWriter) Choice() Choice {
+	choice := a.choice
+	if a.written > 0 {
+		choice.Ratio = float64(a.out.n) / float64(a.written)
+	}
+	return choice
+}
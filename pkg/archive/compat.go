@@ -0,0 +1,305 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Compat is a profile docker archives are written for, so that older
+// docker versions can load them
+type Compat string
+
+// Supported compatibility profiles
+const (
+	// CompatDefault writes docker archives as the docker daemon saves them
+	CompatDefault Compat = ""
+	// CompatDockerLegacy writes docker archives Docker 17.x loads: the
+	// classic layout of docker save, with manifest.json listing the image
+	// config as <digest>.json and every layer uncompressed as
+	// <diff ID>/layer.tar, without the files of an OCI layout, a single
+	// RepoTag per image and gzip as the only compression
+	CompatDockerLegacy Compat = "docker-legacy"
+)
+
+// CompatNames lists the profiles ParseCompat accepts besides the default
+const CompatNames = "docker-legacy"
+
+// ParseCompat returns the compatibility profile with the given name; ""
+// is the default
+func ParseCompat(name string) (Compat, error) {
+	switch compat := Compat(name); compat {
+	case CompatDefault, CompatDockerLegacy:
+		return compat, nil
+	}
+	return CompatDefault, fmt.Errorf("unknown compatibility profile %q, use %s", name, CompatNames)
+}
+
+// CheckCompression fails when archives of the profile can't be compressed
+// with compression, which may also be Auto
+func (c Compat) CheckCompression(compression Compression) error {
+	if c == CompatDockerLegacy && compression != Uncompressed && compression != Gzip {
+		return fmt.Errorf("%s archives can only be compressed with gzip, not %s", c, compression)
+	}
+	return nil
+}
+
+// WriteLegacy copies the docker save output of a single image from r to w
+// as a docker archive of the docker-legacy profile, named repoTag unless
+// it is empty. docker save writes manifest.json after the layers, so the
+// output is unpacked below tmpDir first, or below the system's temporary
+// directory when it is empty.
+func WriteLegacy(r io.Reader, w io.Writer, tmpDir, repoTag string) error {
+	stage, err := os.MkdirTemp(tmpDir, ".imgmigrate-legacy-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	if err := extract(tar.NewReader(r), stage); err != nil {
+		return fmt.Errorf("failed to read the saved image: %v", err)
+	}
+	// docker save stops at the end of the tar stream, not of its output
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(stage, ociIndexFile)); os.IsNotExist(err) {
+		if err := dockerToOCI(stage); err != nil {
+			return err
+		}
+	}
+
+	layout := layoutDir(stage)
+	images, err := layout.dockerImages()
+	if err != nil {
+		return err
+	}
+	if len(images) != 1 {
+		return fmt.Errorf("the saved output holds %d images instead of one", len(images))
+	}
+	if repoTag != "" {
+		images[0].repoTags = []string{repoTag}
+	}
+	return writeLegacyImages(layout, images, tmpDir, w)
+}
+
+// legacyConfig is what Docker 17.x needs of an image config
+type legacyConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// parseLegacyConfig returns the diff IDs of an image config with the
+// given number of layers, failing when Docker 17.x can't load it
+func parseLegacyConfig(data []byte, layers int) ([]string, error) {
+	var config legacyConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid image config: %v", err)
+	}
+	switch {
+	case config.Architecture == "" || config.OS == "":
+		return nil, fmt.Errorf("the image config names no architecture or OS")
+	case config.RootFS.Type != "layers":
+		return nil, fmt.Errorf("the image config has the rootfs type %q instead of layers", config.RootFS.Type)
+	case len(config.RootFS.DiffIDs) != layers:
+		return nil, fmt.Errorf("%d layers are listed but the image config has %d diff IDs", layers, len(config.RootFS.DiffIDs))
+	}
+	return config.RootFS.DiffIDs, nil
+}
+
+// writeLegacyImages writes images of an OCI layout as a docker archive of
+// the docker-legacy profile. Compressed layers are decompressed to a
+// temporary file below tmpDir, as their tar entries need their size, and
+// checked against their diff IDs.
+func writeLegacyImages(d layoutDir, images []*dockerImage, tmpDir string, w io.Writer) error {
+	type layer struct {
+		name   string
+		desc   registry.Descriptor
+		diffID string
+	}
+	var configs []registry.Descriptor
+	var layers []layer
+	written := make(map[string]bool)
+
+	entries := make([]dockerManifest, 0, len(images))
+	for _, image := range images {
+		if len(image.repoTags) != 1 {
+			names := "none"
+			if len(image.repoTags) > 0 {
+				names = strings.Join(image.repoTags, ", ")
+			}
+			return fmt.Errorf("%s archives carry a single RepoTag per image, image %s has %s", CompatDockerLegacy, image.manifest.Digest, names)
+		}
+		data, err := d.readBlob(image.manifest)
+		if err != nil {
+			return err
+		}
+		var manifest ociManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("invalid manifest %s: %v", image.manifest.Digest, err)
+		}
+		config, err := d.readBlob(manifest.Config)
+		if err != nil {
+			return err
+		}
+		diffIDs, err := parseLegacyConfig(config, len(manifest.Layers))
+		if err != nil {
+			return fmt.Errorf("%s: %v", image.repoTags[0], err)
+		}
+
+		entry := dockerManifest{Config: encoded(manifest.Config.Digest) + ".json", RepoTags: image.repoTags, Layers: []string{}}
+		if !written[entry.Config] {
+			written[entry.Config] = true
+			configs = append(configs, manifest.Config)
+		}
+		for i, desc := range manifest.Layers {
+			name := encoded(diffIDs[i]) + "/layer.tar"
+			entry.Layers = append(entry.Layers, name)
+			if !written[name] {
+				written[name] = true
+				layers = append(layers, layer{name, desc, diffIDs[i]})
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeTarFile(tw, dockerManifestFile, data); err != nil {
+		return err
+	}
+	for _, config := range configs {
+		if err := copyBlob(tw, d, encoded(config.Digest)+".json", config); err != nil {
+			return err
+		}
+	}
+	for _, l := range layers {
+		if err := copyLegacyLayer(tw, d, l.name, l.desc, l.diffID, tmpDir); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// copyLegacyLayer writes a layer of a layout to a tar stream under name
+// uncompressed, checking it against its diff ID
+func copyLegacyLayer(tw *tar.Writer, d layoutDir, name string, desc registry.Descriptor, diffID, tmpDir string) error {
+	rc, err := d.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	buffered := bufio.NewReader(rc)
+	if detectCompression(buffered) == Uncompressed {
+		if desc.Digest != diffID {
+			return fmt.Errorf("layer %s is uncompressed but differs from its diff ID %s", desc.Digest, diffID)
+		}
+		return copyBlob(tw, d, name, desc)
+	}
+
+	stream, _, err := decompress(buffered)
+	if err != nil {
+		return fmt.Errorf("layer %s: %v", desc.Digest, err)
+	}
+	defer stream.Close()
+	tmp, err := os.CreateTemp(tmpDir, ".imgmigrate-layer-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), stream)
+	if err != nil {
+		return fmt.Errorf("failed to decompress layer %s: %v", desc.Digest, err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("failed to decompress layer %s: %v", desc.Digest, err)
+	}
+	if got := "sha256:" + hex.EncodeToString(hash.Sum(nil)); got != diffID {
+		return fmt.Errorf("layer %s decompresses to %s, not its diff ID %s", desc.Digest, got, diffID)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}
+	normalizeHeader(hdr)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, tmp)
+	return err
+}
+
+// checkCompat checks that an archive meets a compatibility profile
+func (f archivedFiles) checkCompat(v *Validation, format Format, compat Compat) {
+	if compat != CompatDockerLegacy {
+		return
+	}
+	if format.Dir {
+		v.problemf("%s: an OCI layout directory, not a docker archive", compat)
+		return
+	}
+	if err := compat.CheckCompression(format.Compression); err != nil {
+		v.problemf("%v", err)
+	}
+	for _, name := range []string{ociIndexFile, ociLayoutFile} {
+		if _, ok := f[name]; ok {
+			v.problemf("%s: has %s, a file of OCI layouts", compat, name)
+		}
+	}
+	listing, ok := f.lookup(dockerManifestFile)
+	if !ok {
+		v.problemf("%s: %s is missing", compat, dockerManifestFile)
+		return
+	}
+	var entries []dockerManifest
+	if err := json.Unmarshal(listing.data, &entries); err != nil {
+		// checkDocker reports it
+		return
+	}
+
+	for _, entry := range entries {
+		image := entry.Config
+		if len(entry.RepoTags) > 0 {
+			image = entry.RepoTags[0]
+		}
+		if len(entry.RepoTags) != 1 {
+			v.problemf("%s: %s has %d RepoTags instead of one", compat, image, len(entry.RepoTags))
+		}
+		if name := cleanName(entry.Config); strings.Contains(name, "/") || !strings.HasSuffix(name, ".json") {
+			v.problemf("%s: %s has its config at %s instead of <digest>.json", compat, image, entry.Config)
+		}
+		if config, ok := f.lookup(entry.Config); ok {
+			if _, err := parseLegacyConfig(config.data, len(entry.Layers)); err != nil {
+				v.problemf("%s: %s: %v", compat, image, err)
+			}
+		}
+		for _, name := range entry.Layers {
+			if path.Base(cleanName(name)) != "layer.tar" {
+				v.problemf("%s: %s has the layer %s instead of <id>/layer.tar", compat, image, name)
+				continue
+			}
+			if layer, ok := f.lookup(name); ok && layer.compressed {
+				v.problemf("%s: %s has the compressed layer %s", compat, image, name)
+			}
+		}
+	}
+}
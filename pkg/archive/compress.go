@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Compression is the compression of a tar archive
+type Compression string
+
+// Supported compressions
+const (
+	Uncompressed Compression = ""
+	Gzip         Compression = "gzip"
+	Zstd         Compression = "zstd"
+)
+
+// Magic numbers of the compressed formats
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// zstdBinary is the command used for zstd, which the standard library
+// doesn't implement
+const zstdBinary = "zstd"
+
+// detectCompression returns the compression of the data r starts with
+func detectCompression(r *bufio.Reader) Compression {
+	head, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return Zstd
+	}
+	return Uncompressed
+}
+
+// decompress returns a reader of the decompressed data of r along with the
+// compression it detected
+func decompress(r io.Reader) (io.ReadCloser, Compression, error) {
+	buffered := bufio.NewReader(r)
+	compression := detectCompression(buffered)
+	switch compression {
+	case Gzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, compression, fmt.Errorf("invalid gzip data: %v", err)
+		}
+		return gz, compression, nil
+	case Zstd:
+		rc, err := newZstdReader(buffered)
+		return rc, compression, err
+	}
+	return io.NopCloser(buffered), compression, nil
+}
+
+// compress returns a writer compressing to w. Closing it flushes the
+// compressed data but leaves w open.
+func compress(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case Gzip:
+		return NewGzipWriter(w), nil
+	case Zstd:
+		return newZstdWriter(w)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// NewWriter returns a writer compressing to w with compression. Closing it
+// flushes the compressed data but leaves w open.
+func NewWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	return compress(w, compression)
+}
+
+// nopWriteCloser adds a Close that does nothing to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// zstdCommand returns a zstd command with args
+func zstdCommand(args ...string) (*zstdProcess, error) {
+	path, err := exec.LookPath(zstdBinary)
+	if err != nil {
+		return nil, fmt.Errorf("zstd compression needs the %s command: %v", zstdBinary, err)
+	}
+	cmd := exec.Command(path, args...)
+	process := &zstdProcess{cmd: cmd, stderr: &bytes.Buffer{}}
+	cmd.Stderr = process.stderr
+	return process, nil
+}
+
+// newZstdReader starts zstd decompressing r
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	process, err := zstdCommand("-d", "-c", "-q")
+	if err != nil {
+		return nil, err
+	}
+	process.cmd.Stdin = r
+	if process.out, err = process.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := process.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", zstdBinary, err)
+	}
+	return process, nil
+}
+
+// newZstdWriter starts zstd compressing to w
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	process, err := zstdCommand(append(zstdArgs(), "-c", "-q")...)
+	if err != nil {
+		return nil, err
+	}
+	process.cmd.Stdout = w
+	stdin, err := process.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := process.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", zstdBinary, err)
+	}
+	return &zstdWriter{WriteCloser: stdin, process: process}, nil
+}
+
+// zstdWriter feeds a zstd process and waits for it on Close
+type zstdWriter struct {
+	io.WriteCloser
+	process *zstdProcess
+}
+
+func (z *zstdWriter) Close() error {
+	z.WriteCloser.Close()
+	return z.process.wait()
+}
+
+// zstdProcess is a running zstd command
+type zstdProcess struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	stderr *bytes.Buffer
+
+	drained bool
+	waited  bool
+	err     error
+}
+
+func (p *zstdProcess) Read(b []byte) (int, error) {
+	n, err := p.out.Read(b)
+	if err == io.EOF {
+		p.drained = true
+		// Report a failed decompression instead of a clean end of data
+		if waitErr := p.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Close waits for the process. When its output was not read to the end,
+// the process is cut off and its exit status ignored.
+func (p *zstdProcess) Close() error {
+	p.out.Close()
+	err := p.wait()
+	if !p.drained {
+		return nil
+	}
+	return err
+}
+
+func (p *zstdProcess) wait() error {
+	if !p.waited {
+		p.waited = true
+		if err := p.cmd.Wait(); err != nil {
+			p.err = fmt.Errorf("%s failed: %v: %s", zstdBinary, err, strings.TrimSpace(p.stderr.String()))
+		}
+	}
+	return p.err
+}
@@ -0,0 +1,212 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Convert writes the archive at input to output in the given format and
+// returns the format input was detected as. Image names are kept: RepoTags
+// become name annotations of index.json and back. An archive that only
+// changes its compression is streamed; layout changes and directories are
+// unpacked to a temporary directory first. Output is only put in place once
+// it is complete, so a failed conversion leaves nothing behind. input may
+// also be a dir: or oci: source, which is converted as an OCI layout.
+func Convert(input, output string, to Format) (Format, error) {
+	input, cleanup, err := openSource(input)
+	if err != nil {
+		return Format{}, err
+	}
+	defer cleanup()
+	from, err := Detect(input)
+	if err != nil {
+		return from, err
+	}
+	if same, err := samePath(input, output); err != nil || same {
+		if err == nil {
+			err = fmt.Errorf("input and output are both %s", input)
+		}
+		return from, err
+	}
+
+	if !from.Dir && !to.Dir && from.Layout == to.Layout {
+		return from, writeArchive(output, to.Compression, func(w io.Writer) error {
+			tr, _, closer, err := openTar(input)
+			if err != nil {
+				return err
+			}
+			defer closer.Close()
+			if err := repack(tr, tar.NewWriter(w), from.Layout); err != nil {
+				return fmt.Errorf("%s: %w", input, err)
+			}
+			return nil
+		})
+	}
+
+	// Layout changes need random access to the blobs
+	stage, err := stageDir(output, to, "convert")
+	if err != nil {
+		return from, err
+	}
+	defer os.RemoveAll(stage)
+
+	layout := layoutDir(stage)
+	if from.Dir {
+		if to.Dir {
+			err = copyLayout(input, stage)
+		} else {
+			// Read a layout directory in place
+			layout = layoutDir(input)
+		}
+	} else {
+		err = unpack(input, from, stage)
+	}
+	if err != nil {
+		return from, err
+	}
+
+	if to.Layout == LayoutDocker {
+		return from, writeArchive(output, to.Compression, func(w io.Writer) error {
+			return writeDockerArchive(layout, w)
+		})
+	}
+	return from, writeLayout(layout, output, to)
+}
+
+// stageDir creates the temporary directory an archive is assembled in
+// before it is written to output. A directory output is staged next to it,
+// so it can be renamed into place.
+func stageDir(output string, to Format, command string) (string, error) {
+	if !to.Dir {
+		return os.MkdirTemp("", ".imgmigrate-"+command+"-*")
+	}
+	if err := checkOutputDir(output); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(filepath.Dir(filepath.Clean(output)), ".imgmigrate-"+command+"-*")
+}
+
+// writeLayout writes an OCI layout to output as an OCI archive, or moves
+// it there when output is a directory and layout the directory it was
+// staged in
+func writeLayout(layout layoutDir, output string, to Format) error {
+	if !to.Dir {
+		return writeArchive(output, to.Compression, func(w io.Writer) error {
+			return writeOCIArchive(string(layout), w)
+		})
+	}
+
+	stage := string(layout)
+	if err := pruneLayout(stage); err != nil {
+		return err
+	}
+	if _, err := layout.index(); err != nil {
+		return err
+	}
+	if err := os.Chmod(stage, 0755); err != nil {
+		return err
+	}
+	os.Remove(output)
+	return os.Rename(stage, output)
+}
+
+// unpack extracts an archive into dir and turns a docker archive into an
+// OCI layout. Archives of Docker 25 and later are OCI layouts already.
+func unpack(path string, format Format, dir string) error {
+	tr, _, closer, err := openTar(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	if err := extract(tr, dir); err != nil {
+		return readError(path, format.Compression, 1, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFile)); err == nil {
+		return nil
+	}
+	if format.Layout != LayoutDocker {
+		return fmt.Errorf("%s is not an OCI archive: %s is missing", path, ociIndexFile)
+	}
+	if err := dockerToOCI(dir); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// checkOutputDir fails when an output directory exists and isn't empty
+func checkOutputDir(path string) error {
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output directory %s is not empty", path)
+	}
+	return nil
+}
+
+// writeArchive writes an archive file through write, compressing it. The
+// data goes to a temporary file next to path that replaces path once
+// complete.
+func writeArchive(path string, compression Compression, write func(w io.Writer) error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	buffered := bufio.NewWriterSize(tmp, 1<<20)
+	cw, err := compress(buffered, compression)
+	if err != nil {
+		return err
+	}
+	if err := write(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// samePath reports whether two paths name the same file
+func samePath(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// Description tells which image an archive holds, to group the archives
+// save writes for the platforms of one image
+type Description struct {
+	// Name is the name of the image with the platform suffix save adds to
+	// tags removed, such as nginx:1.25, or empty when the archive doesn't
+	// name its image
+	Name string
+	// Platforms are the platforms of the image, several for a
+	// multi-platform image
+	Platforms []registry.Platform
+}
+
+// Describe returns the name and platforms of the image of an archive or
+// OCI layout. Inputs holding several images, such as stores, are an error.
+func Describe(input string) (*Description, error) {
+	layout, cleanup, err := openLayout(input)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	index, err := layout.index()
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("it holds no image")
+	}
+	var names []string
+	for _, desc := range index.Manifests {
+		if desc.Digest != index.Manifests[0].Digest {
+			return nil, fmt.Errorf("it holds several images")
+		}
+		for _, name := range imageNames(desc.Annotations) {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	manifests, err := layout.platformManifests(index.Manifests[0])
+	if err != nil {
+		return nil, err
+	}
+	description := &Description{}
+	for _, manifest := range manifests {
+		description.Platforms = append(description.Platforms, *manifest.Platform)
+	}
+	if len(names) == 0 {
+		return description, nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(names[0])
+	if err != nil {
+		return nil, err
+	}
+	description.Name = names[0]
+	if tagged, ok := named.(reference.NamedTagged); ok && len(description.Platforms) == 1 {
+		tag := valueOr(platformlessTag(tagged.Tag(), description.Platforms[0]), "latest")
+		description.Name = reference.FamiliarName(named) + ":" + tag
+	}
+	return description, nil
+}
+
+// openLayout returns the OCI layout of an archive or dir: or oci: source,
+// staged in a temporary directory the returned function removes, or of a
+// layout directory as it is
+func openLayout(input string) (layoutDir, func(), error) {
+	if IsSource(input) {
+		dir, cleanup, err := openSource(input)
+		return layoutDir(dir), cleanup, err
+	}
+	format, err := Detect(input)
+	if err != nil {
+		return "", nil, err
+	}
+	if format.Dir {
+		return layoutDir(input), func() {}, nil
+	}
+	stage, err := os.MkdirTemp("", ".imgmigrate-list-*")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := unpack(input, format, stage); err != nil {
+		os.RemoveAll(stage)
+		return "", nil, err
+	}
+	return layoutDir(stage), func() { os.RemoveAll(stage) }, nil
+}
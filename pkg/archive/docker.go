@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// dockerImage is an image of an OCI layout to write to a docker archive
+type dockerImage struct {
+	manifest registry.Descriptor
+	repoTags []string
+}
+
+// dockerImages returns the images of an OCI layout with their names.
+// Descriptors of index.json pointing to the same image are merged, so the
+// image is written once with all of its names.
+func (d layoutDir) dockerImages() ([]*dockerImage, error) {
+	index, err := d.index()
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("%s lists no images", ociIndexFile)
+	}
+
+	var images []*dockerImage
+	byDigest := make(map[string]*dockerImage)
+	for _, desc := range index.Manifests {
+		manifest, err := d.resolveImage(desc)
+		if err != nil {
+			return nil, err
+		}
+		image, ok := byDigest[manifest.Digest]
+		if !ok {
+			image = &dockerImage{manifest: manifest}
+			byDigest[manifest.Digest] = image
+			images = append(images, image)
+		}
+		for _, name := range imageNames(desc.Annotations) {
+			if !slices.Contains(image.repoTags, name) {
+				image.repoTags = append(image.repoTags, name)
+			}
+		}
+	}
+	return images, nil
+}
+
+// writeDockerArchive writes the images of an OCI layout as a docker
+// archive in the classic layout older docker versions load: the config as
+// <digest>.json and each layer as <digest>/layer.tar, listed in
+// manifest.json with the RepoTags of the image. Blobs are checked against
+// their digests as they are copied.
+func writeDockerArchive(d layoutDir, w io.Writer) error {
+	images, err := d.dockerImages()
+	if err != nil {
+		return err
+	}
+	return writeDockerImages(d, images, w)
+}
+
+// writeDockerImages writes images of an OCI layout as a docker archive
+func writeDockerImages(d layoutDir, images []*dockerImage, w io.Writer) error {
+	type file struct {
+		name string
+		desc registry.Descriptor
+	}
+	var files []file
+	written := make(map[string]bool)
+	add := func(name string, desc registry.Descriptor) {
+		if !written[name] {
+			written[name] = true
+			files = append(files, file{name, desc})
+		}
+	}
+
+	entries := make([]dockerManifest, 0, len(images))
+	for _, image := range images {
+		data, err := d.readBlob(image.manifest)
+		if err != nil {
+			return err
+		}
+		var manifest ociManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("invalid manifest %s: %v", image.manifest.Digest, err)
+		}
+
+		entry := dockerManifest{Config: encoded(manifest.Config.Digest) + ".json", RepoTags: image.repoTags, Layers: []string{}}
+		add(entry.Config, manifest.Config)
+		for _, layer := range manifest.Layers {
+			name := encoded(layer.Digest) + "/layer.tar"
+			entry.Layers = append(entry.Layers, name)
+			add(name, layer)
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeTarFile(tw, dockerManifestFile, data); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := copyBlob(tw, d, f.name, f.desc); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// copyBlob writes a blob of a layout to a tar stream under name
+func copyBlob(tw *tar.Writer, d layoutDir, name string, desc registry.Descriptor) error {
+	rc, err := d.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: desc.Size, Typeflag: tar.TypeReg}
+	normalizeHeader(hdr)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, rc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeTarFile writes data to a tar stream under name
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+	normalizeHeader(hdr)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// encoded returns the hex part of a digest
+func encoded(digest string) string {
+	_, hex, _ := strings.Cut(digest, ":")
+	return hex
+}
@@ -0,0 +1,254 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// Extraction is the image Extract wrote
+type Extraction struct {
+	Platform registry.Platform
+	Digest   string
+	RepoTags []string
+}
+
+// ExtractOptions configures Extract
+type ExtractOptions struct {
+	// RepoTags name the image instead of its names in the input; empty
+	// ones are ignored
+	RepoTags []string
+	// Compat is the compatibility profile of the docker archive written
+	Compat Compat
+}
+
+// Extract writes the image for a platform, in os/arch[/variant] form, of
+// the archive or OCI layout at input to output as a docker archive that
+// old docker versions can load. input may also be a dir: or oci: source.
+// Like Convert, output is only put in place once it is complete.
+func Extract(input, output, platform string, opts ExtractOptions) (*Extraction, error) {
+	want, err := registry.ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	to, err := FormatForPath(output)
+	if err != nil {
+		return nil, err
+	}
+	if to.Dir || to.Layout != LayoutDocker {
+		return nil, fmt.Errorf("%s is not a docker archive name, use .tar, .tar.gz, .tgz or .tar.zst", output)
+	}
+	if err := opts.Compat.CheckCompression(to.Compression); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, repoTag := range opts.RepoTags {
+		if repoTag == "" {
+			continue
+		}
+		name, err := familiarTag(repoTag)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	input, cleanup, err := openSource(input)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	from, err := Detect(input)
+	if err != nil {
+		return nil, err
+	}
+	if same, err := samePath(input, output); err != nil || same {
+		if err == nil {
+			err = fmt.Errorf("input and output are both %s", input)
+		}
+		return nil, err
+	}
+
+	layout := layoutDir(input)
+	if !from.Dir {
+		stage, err := os.MkdirTemp("", ".imgmigrate-extract-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(stage)
+		if err := unpack(input, from, stage); err != nil {
+			return nil, err
+		}
+		layout = layoutDir(stage)
+	}
+
+	image, found, err := layout.platformImage(want)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		image.repoTags = names
+	}
+	if len(image.repoTags) == 0 {
+		return nil, fmt.Errorf("the %s image in %s has no name to use as RepoTag", found, input)
+	}
+
+	err = writeArchive(output, to.Compression, func(w io.Writer) error {
+		if opts.Compat == CompatDockerLegacy {
+			return writeLegacyImages(layout, []*dockerImage{image}, "", w)
+		}
+		return writeDockerImages(layout, []*dockerImage{image}, w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Extraction{Platform: found, Digest: image.manifest.Digest, RepoTags: image.repoTags}, nil
+}
+
+// platformImage returns the image of the layout for a platform with the
+// names index.json gives it. It fails when the layout holds no image or
+// several different images for the platform.
+func (d layoutDir) platformImage(want registry.Platform) (*dockerImage, registry.Platform, error) {
+	index, err := d.index()
+	if err != nil {
+		return nil, registry.Platform{}, err
+	}
+
+	var images []*dockerImage
+	var platforms []registry.Platform
+	var available []string
+	for _, desc := range index.Manifests {
+		manifests, err := d.platformManifests(desc)
+		if err != nil {
+			return nil, registry.Platform{}, err
+		}
+
+		var matches []registry.Descriptor
+		for _, manifest := range manifests {
+			if !slices.Contains(available, manifest.Platform.String()) {
+				available = append(available, manifest.Platform.String())
+			}
+			if manifest.Platform.Matches(want) {
+				matches = append(matches, manifest)
+			}
+		}
+		if len(matches) > 1 {
+			return nil, registry.Platform{}, fmt.Errorf("%d images match platform %s, add the variant", len(matches), want)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		i := slices.IndexFunc(images, func(image *dockerImage) bool { return image.manifest.Digest == matches[0].Digest })
+		if i < 0 {
+			i = len(images)
+			images = append(images, &dockerImage{manifest: matches[0]})
+			platforms = append(platforms, *matches[0].Platform)
+		}
+		for _, name := range imageNames(desc.Annotations) {
+			if !slices.Contains(images[i].repoTags, name) {
+				images[i].repoTags = append(images[i].repoTags, name)
+			}
+		}
+	}
+
+	switch len(images) {
+	case 0:
+		if len(available) == 0 {
+			return nil, registry.Platform{}, fmt.Errorf("platform %s is not present, the archive holds no images", want)
+		}
+		return nil, registry.Platform{}, fmt.Errorf("platform %s is not present, the archive holds %s", want, strings.Join(available, ", "))
+	case 1:
+		return images[0], platforms[0], nil
+	}
+	var names []string
+	for _, image := range images {
+		if len(image.repoTags) == 0 {
+			names = append(names, image.manifest.Digest)
+			continue
+		}
+		names = append(names, strings.Join(image.repoTags, ", "))
+	}
+	return nil, registry.Platform{}, fmt.Errorf("%d different images are present for platform %s: %s", len(images), want, strings.Join(names, "; "))
+}
+
+// platformManifests returns the image manifests a descriptor of index.json
+// stands for, with their platforms. Indexes are followed and their
+// attestations left out.
+func (d layoutDir) platformManifests(desc registry.Descriptor) ([]registry.Descriptor, error) {
+	switch desc.MediaType {
+	case registry.MediaTypeOCIManifest, registry.MediaTypeManifest:
+		if desc.Platform == nil {
+			platform, err := d.configPlatform(desc)
+			if err != nil {
+				return nil, err
+			}
+			desc.Platform = &platform
+		}
+		return []registry.Descriptor{desc}, nil
+	case registry.MediaTypeOCIIndex, registry.MediaTypeManifestList:
+	default:
+		return nil, fmt.Errorf("manifest %s has the unsupported media type %q", desc.Digest, desc.MediaType)
+	}
+
+	data, err := d.readBlob(desc)
+	if err != nil {
+		return nil, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+	}
+	var manifests []registry.Descriptor
+	for _, child := range index.Manifests {
+		if child.IsAttestation() {
+			continue
+		}
+		found, err := d.platformManifests(child)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, found...)
+	}
+	return manifests, nil
+}
+
+// configPlatform reads the platform of an image manifest from its config
+func (d layoutDir) configPlatform(desc registry.Descriptor) (registry.Platform, error) {
+	var platform registry.Platform
+	data, err := d.readBlob(desc)
+	if err != nil {
+		return platform, err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return platform, fmt.Errorf("invalid manifest %s: %v", desc.Digest, err)
+	}
+	config, err := d.readBlob(manifest.Config)
+	if err != nil {
+		return platform, err
+	}
+	if err := json.Unmarshal(config, &platform); err != nil {
+		return platform, fmt.Errorf("invalid image config %s: %v", manifest.Config.Digest, err)
+	}
+	return platform, nil
+}
+
+// familiarTag returns an image name with a tag as docker lists it in
+// RepoTags, adding the latest tag when it has none
+func familiarTag(name string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid image name %q: %v", name, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return "", fmt.Errorf("invalid image name %q: a RepoTag can't have a digest", name)
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named)), nil
+}
@@ -0,0 +1,271 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// Merged is the multi-platform image Merge wrote
+type Merged struct {
+	Name      string
+	Digest    string
+	Platforms []registry.Platform
+}
+
+// mergeInput is an image of an archive to merge
+type mergeInput struct {
+	path     string
+	manifest registry.Descriptor
+	names    []string
+}
+
+// Merge combines the single-platform images of archives, such as the
+// per-platform archives save writes, into one multi-platform image: an
+// OCI index referencing the image of every input, written to output as an
+// OCI archive or layout. Layers the inputs share are stored once.
+//
+// The image is named name, or else after the RepoTags of the inputs with
+// their platform suffix removed: nginx:1.25-linux-amd64 and
+// nginx:1.25-linux-arm64 become nginx:1.25. Inputs of different
+// repositories are an error unless force is set.
+func Merge(inputs []string, output string, to Format, name string, force bool) (*Merged, error) {
+	if to.Layout != LayoutOCI {
+		return nil, fmt.Errorf("a docker archive can't hold a multi-platform image, write an OCI archive or layout")
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no archives to merge")
+	}
+	if name != "" {
+		var err error
+		if name, err = familiarTag(name); err != nil {
+			return nil, err
+		}
+	}
+
+	stage, err := stageDir(output, to, "merge")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+	layout := layoutDir(stage)
+
+	var images []mergeInput
+	for i, input := range inputs {
+		if same, err := samePath(input, output); err != nil || same {
+			if err == nil {
+				err = fmt.Errorf("%s is both an input and the output", input)
+			}
+			return nil, err
+		}
+		image, _, err := layout.importInput(input, filepath.Join(stage, fmt.Sprintf("input-%d", i)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", input, err)
+		}
+		images = append(images, image)
+	}
+
+	if name, err = mergedName(images, name, force); err != nil {
+		return nil, err
+	}
+
+	merged := &Merged{Name: name}
+	list := ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{}}
+	for _, image := range images {
+		i := slices.IndexFunc(list.Manifests, func(desc registry.Descriptor) bool {
+			return platformKey(*desc.Platform) == platformKey(*image.manifest.Platform)
+		})
+		if i >= 0 && list.Manifests[i].Digest != image.manifest.Digest {
+			return nil, fmt.Errorf("%s and another input both hold a %s image", image.path, image.manifest.Platform)
+		}
+		if i < 0 {
+			list.Manifests = append(list.Manifests, image.manifest)
+			merged.Platforms = append(merged.Platforms, *image.manifest.Platform)
+		}
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	if merged.Digest, err = layout.writeBlob(data); err != nil {
+		return nil, err
+	}
+	annotations, err := nameAnnotations(name)
+	if err != nil {
+		return nil, err
+	}
+	index := &ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{{
+		MediaType:   registry.MediaTypeOCIIndex,
+		Digest:      merged.Digest,
+		Size:        int64(len(data)),
+		Annotations: annotations,
+	}}}
+	if err := layout.writeIndex(index); err != nil {
+		return nil, err
+	}
+	return merged, writeLayout(layout, output, to)
+}
+
+// importInput adds the image of an archive or OCI layout to the layout,
+// unpacking archives to dir first, and returns the size of the blobs the
+// layout didn't have. The input must hold a single image.
+func (d layoutDir) importInput(path, dir string) (mergeInput, int64, error) {
+	image := mergeInput{path: path}
+	format, err := Detect(path)
+	if err != nil {
+		return image, 0, err
+	}
+	src := layoutDir(path)
+	if !format.Dir {
+		if err := unpack(path, format, dir); err != nil {
+			return image, 0, err
+		}
+		defer os.RemoveAll(dir)
+		src = layoutDir(dir)
+	}
+
+	index, err := src.index()
+	if err != nil {
+		return image, 0, err
+	}
+	for _, desc := range index.Manifests {
+		manifests, err := src.platformManifests(desc)
+		if err != nil {
+			return image, 0, err
+		}
+		for _, manifest := range manifests {
+			if image.manifest.Digest != "" && manifest.Digest != image.manifest.Digest {
+				return image, 0, fmt.Errorf("it holds more than one image")
+			}
+			image.manifest = manifest
+		}
+		for _, name := range imageNames(desc.Annotations) {
+			if !slices.Contains(image.names, name) {
+				image.names = append(image.names, name)
+			}
+		}
+	}
+	if image.manifest.Digest == "" {
+		return image, 0, fmt.Errorf("it holds no image")
+	}
+	image.manifest.Annotations = nil
+	added, err := d.importImage(src, image.manifest, !format.Dir)
+	return image, added, err
+}
+
+// importImage adds the manifest, config and layers of an image of src to
+// the layout and returns the size of the blobs it didn't have. Blobs of an
+// unpacked archive are linked, those of a layout directory copied and
+// checked against their digests.
+func (d layoutDir) importImage(src layoutDir, manifest registry.Descriptor, link bool) (int64, error) {
+	data, err := src.readBlob(manifest)
+	if err != nil {
+		return 0, err
+	}
+	var image ociManifest
+	if err := json.Unmarshal(data, &image); err != nil {
+		return 0, fmt.Errorf("invalid manifest %s: %v", manifest.Digest, err)
+	}
+
+	var added int64
+	for _, desc := range append([]registry.Descriptor{manifest, image.Config}, image.Layers...) {
+		from, err := src.blobPath(desc.Digest)
+		if err != nil {
+			return added, err
+		}
+		to, _ := d.blobPath(desc.Digest)
+		if _, err := os.Stat(to); err == nil {
+			continue
+		}
+		if !link {
+			name := filepath.ToSlash(filepath.Join("blobs", "sha256", encoded(desc.Digest)))
+			if err := copyPlainFile(from, to, name); err != nil {
+				return added, err
+			}
+			added += desc.Size
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return added, err
+		}
+		if err := os.Link(from, to); err != nil {
+			if os.IsNotExist(err) {
+				return added, fmt.Errorf("blob %s is missing", desc.Digest)
+			}
+			return added, err
+		}
+		added += desc.Size
+	}
+	return added, nil
+}
+
+// mergedName returns the name of a merged image: name when it is set,
+// else the name of its inputs with their platform suffix removed. Inputs
+// of different repositories are an error unless force is set.
+func mergedName(images []mergeInput, name string, force bool) (string, error) {
+	var names, repositories []string
+	for _, image := range images {
+		if len(image.names) == 0 {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(image.names[0])
+		if err != nil {
+			return "", err
+		}
+		repository := reference.FamiliarName(named)
+		if !slices.Contains(repositories, repository) {
+			repositories = append(repositories, repository)
+		}
+		if tagged, ok := named.(reference.NamedTagged); ok {
+			names = append(names, repository+":"+valueOr(platformlessTag(tagged.Tag(), *image.manifest.Platform), "latest"))
+		}
+	}
+
+	if len(repositories) > 1 && !force {
+		return "", fmt.Errorf("the inputs are images of different repositories (%s)", strings.Join(repositories, ", "))
+	}
+	if name != "" {
+		return name, nil
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("the inputs have no names to derive the image name from, name it explicitly")
+	}
+	for _, other := range names[1:] {
+		if other != names[0] && len(repositories) == 1 {
+			return "", fmt.Errorf("the inputs have different tags (%s and %s), name the image explicitly", names[0], other)
+		}
+	}
+	return names[0], nil
+}
+
+// platformlessTag removes the platform suffix save adds to tags, with or
+// without the variant: 1.25-linux-arm64-v8 and 1.25-linux-arm64 become 1.25
+func platformlessTag(tag string, platform registry.Platform) string {
+	for _, p := range []registry.Platform{platform, {OS: platform.OS, Architecture: platform.Architecture}} {
+		if trimmed, ok := strings.CutSuffix(tag, "-"+strings.ReplaceAll(p.String(), "/", "-")); ok {
+			return trimmed
+		}
+	}
+	return tag
+}
+
+// valueOr returns value, or fallback when value is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// platformKey identifies a platform, including the OS version that tells
+// Windows images apart
+func platformKey(p registry.Platform) string {
+	return p.String() + " " + p.OSVersion
+}
@@ -0,0 +1,363 @@
+package archive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// Media types written to OCI layouts
+const (
+	mediaTypeConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer     = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeLayerGzip = mediaTypeLayer + "+gzip"
+	mediaTypeLayerZstd = mediaTypeLayer + "+zstd"
+)
+
+// Annotations naming the images of an OCI layout, as set by docker save
+const (
+	annotationImageName = "io.containerd.image.name"
+	annotationRefName   = "org.opencontainers.image.ref.name"
+)
+
+// ociLayoutContent is the content of the oci-layout file
+const ociLayoutContent = `{"imageLayoutVersion":"1.0.0"}`
+
+// ociIndex is an OCI image index
+type ociIndex struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	MediaType     string                `json:"mediaType,omitempty"`
+	Manifests     []registry.Descriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest. Docker image manifests share its
+// structure.
+type ociManifest struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	MediaType     string                `json:"mediaType,omitempty"`
+	Config        registry.Descriptor   `json:"config"`
+	Layers        []registry.Descriptor `json:"layers"`
+}
+
+// dockerManifest is an entry of the manifest.json of a docker archive
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// layoutDir is an OCI image layout directory
+type layoutDir string
+
+// blobPath returns the path of a blob in the layout
+func (d layoutDir) blobPath(digest string) (string, error) {
+	encoded, err := encodedDigest(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(string(d), "blobs", "sha256", encoded), nil
+}
+
+// index reads index.json
+func (d layoutDir) index() (*ociIndex, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), ociIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ociIndexFile, err)
+	}
+	return &index, nil
+}
+
+// openBlob opens a blob for reading. Reading it fails at the end when its
+// content doesn't match the descriptor.
+func (d layoutDir) openBlob(desc registry.Descriptor) (io.ReadCloser, error) {
+	path, err := d.blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("blob %s is missing", desc.Digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedReader{r: file, closer: file, hash: sha256.New(), desc: desc}, nil
+}
+
+// readBlob reads a blob and checks it against the descriptor
+func (d layoutDir) readBlob(desc registry.Descriptor) ([]byte, error) {
+	rc, err := d.openBlob(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// writeBlob stores data as a blob and returns its digest
+func (d layoutDir) writeBlob(data []byte) (string, error) {
+	digest := digestOf(data)
+	path, _ := d.blobPath(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return digest, os.WriteFile(path, data, 0644)
+}
+
+// writeIndex writes index.json and the oci-layout file
+func (d layoutDir) writeIndex(index *ociIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(string(d), ociIndexFile), data, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(string(d), ociLayoutFile), []byte(ociLayoutContent), 0644)
+}
+
+// verifiedReader reads a blob and fails at its end when the content
+// doesn't match the size and digest of its descriptor
+type verifiedReader struct {
+	r      io.Reader
+	closer io.Closer
+	hash   hash.Hash
+	desc   registry.Descriptor
+	read   int64
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	v.read += int64(n)
+	if v.read > v.desc.Size {
+		return 0, fmt.Errorf("blob %s is corrupt: it has more than %d bytes", v.desc.Digest, v.desc.Size)
+	}
+	if err == io.EOF {
+		if v.read != v.desc.Size {
+			return n, fmt.Errorf("blob %s is corrupt: it has %d bytes instead of %d", v.desc.Digest, v.read, v.desc.Size)
+		}
+		if digest := "sha256:" + hex.EncodeToString(v.hash.Sum(nil)); digest != v.desc.Digest {
+			return n, fmt.Errorf("blob %s is corrupt: its content has digest %s", v.desc.Digest, digest)
+		}
+	}
+	return n, err
+}
+
+func (v *verifiedReader) Close() error {
+	if v.closer == nil {
+		return nil
+	}
+	return v.closer.Close()
+}
+
+// digestOf returns the sha256 digest of data
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// resolveImage returns the image manifest a descriptor of index.json
+// stands for. An index is followed when it holds a single image besides
+// its attestations; a docker archive can't hold the platforms of a
+// multi-platform index under one name.
+func (d layoutDir) resolveImage(desc registry.Descriptor) (registry.Descriptor, error) {
+	switch desc.MediaType {
+	case registry.MediaTypeOCIManifest, registry.MediaTypeManifest:
+		return desc, nil
+	case registry.MediaTypeOCIIndex, registry.MediaTypeManifestList:
+	default:
+		return desc, fmt.Errorf("manifest %s has the unsupported media type %q", desc.Digest, desc.MediaType)
+	}
+
+	data, err := d.readBlob(desc)
+	if err != nil {
+		return desc, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return desc, fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+	}
+
+	var images []registry.Descriptor
+	var platforms []string
+	for _, child := range index.Manifests {
+		if child.IsAttestation() {
+			continue
+		}
+		images = append(images, child)
+		if child.Platform != nil {
+			platforms = append(platforms, child.Platform.String())
+		}
+	}
+	switch len(images) {
+	case 0:
+		return desc, fmt.Errorf("index %s holds no image", desc.Digest)
+	case 1:
+		return d.resolveImage(images[0])
+	}
+	return desc, fmt.Errorf("index %s holds %d platforms (%s); a docker archive holds one platform per image name",
+		desc.Digest, len(images), strings.Join(platforms, ", "))
+}
+
+// imageNames returns the image names an index.json descriptor carries as
+// docker RepoTags. Reference names that are only a tag can't be turned
+// into an image name and are left out.
+func imageNames(annotations map[string]string) []string {
+	for _, name := range []string{annotations[annotationImageName], annotations[annotationRefName]} {
+		if name == "" || !strings.ContainsAny(name, ":/") {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			continue
+		}
+		if tagged, ok := named.(reference.NamedTagged); ok {
+			return []string{reference.FamiliarString(tagged)}
+		}
+	}
+	return nil
+}
+
+// nameAnnotations returns the annotations docker save sets for a RepoTag
+func nameAnnotations(repoTag string) (map[string]string, error) {
+	named, err := reference.ParseNormalizedNamed(repoTag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RepoTag %q: %v", repoTag, err)
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.NamedTagged)
+	if !ok {
+		return nil, fmt.Errorf("invalid RepoTag %q: it has no tag", repoTag)
+	}
+	return map[string]string{annotationImageName: tagged.String(), annotationRefName: tagged.Tag()}, nil
+}
+
+// dockerToOCI turns an extracted docker archive into an OCI layout in
+// place, keeping the RepoTags of its images as name annotations. Layer and
+// config files are linked into the blob store.
+func dockerToOCI(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, dockerManifestFile))
+	if err != nil {
+		return err
+	}
+	var entries []dockerManifest
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid %s: %v", dockerManifestFile, err)
+	}
+
+	layout := layoutDir(dir)
+	imported := make(map[string]registry.Descriptor)
+	index := &ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{}}
+	for _, entry := range entries {
+		config, err := layout.importBlob(entry.Config, imported)
+		if err != nil {
+			return err
+		}
+		config.MediaType = mediaTypeConfig
+
+		configData, err := layout.readBlob(config)
+		if err != nil {
+			return err
+		}
+		var platform registry.Platform
+		if err := json.Unmarshal(configData, &platform); err != nil {
+			return fmt.Errorf("invalid image config %s: %v", entry.Config, err)
+		}
+
+		manifest := ociManifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest, Config: config, Layers: []registry.Descriptor{}}
+		for _, name := range entry.Layers {
+			layer, err := layout.importBlob(name, imported)
+			if err != nil {
+				return err
+			}
+			manifest.Layers = append(manifest.Layers, layer)
+		}
+
+		manifestData, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		digest, err := layout.writeBlob(manifestData)
+		if err != nil {
+			return err
+		}
+		desc := registry.Descriptor{MediaType: registry.MediaTypeOCIManifest, Digest: digest, Size: int64(len(manifestData)), Platform: &platform}
+
+		if len(entry.RepoTags) == 0 {
+			index.Manifests = append(index.Manifests, desc)
+		}
+		for _, repoTag := range entry.RepoTags {
+			named := desc
+			if named.Annotations, err = nameAnnotations(repoTag); err != nil {
+				return err
+			}
+			index.Manifests = append(index.Manifests, named)
+		}
+	}
+	return layout.writeIndex(index)
+}
+
+// importBlob links a file of an extracted docker archive into the blob
+// store and returns its descriptor. The media type of layers is set from
+// their compression. Files already imported are looked up in imported.
+func (d layoutDir) importBlob(name string, imported map[string]registry.Descriptor) (registry.Descriptor, error) {
+	path := filepath.Join(string(d), filepath.FromSlash(cleanName(name)))
+	// Older docker versions link duplicate layers to the first copy
+	real, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return registry.Descriptor{}, fmt.Errorf("%s references %s, which is missing", dockerManifestFile, name)
+	}
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	if desc, ok := imported[real]; ok {
+		return desc, nil
+	}
+
+	file, err := os.Open(real)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	hash := sha256.New()
+	buffered := bufio.NewReader(file)
+	compression := detectCompression(buffered)
+	size, err := io.Copy(hash, buffered)
+	file.Close()
+	if err != nil {
+		return registry.Descriptor{}, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+
+	desc := registry.Descriptor{MediaType: mediaTypeLayer, Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Size: size}
+	switch compression {
+	case Gzip:
+		desc.MediaType = mediaTypeLayerGzip
+	case Zstd:
+		desc.MediaType = mediaTypeLayerZstd
+	}
+
+	blob, _ := d.blobPath(desc.Digest)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return desc, err
+		}
+		if err := os.Link(real, blob); err != nil {
+			return desc, err
+		}
+	}
+	imported[real] = desc
+	return desc, nil
+}
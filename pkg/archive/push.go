@@ -0,0 +1,390 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// annotationReferenceDigest names the image an attestation manifest
+// describes
+const annotationReferenceDigest = "vnd.docker.reference.digest"
+
+// PushProgress reports the upload of a blob
+type PushProgress struct {
+	Blob  registry.Descriptor
+	Bytes int64
+	// Exists is set when the repository has the blob already, so it is
+	// skipped
+	Exists bool
+	Done   bool
+}
+
+// Pushed is the image Push uploaded
+type Pushed struct {
+	Digest string
+	// ArchiveDigest is the digest of the image in the archive, which
+	// differs from Digest when annotations were added or platforms left out
+	ArchiveDigest string
+	MediaType     string
+	Platforms     []registry.Platform
+	// Missing lists the platforms of an index left out because the
+	// archive doesn't hold their blobs
+	Missing []string
+	// Transformed records how the layers of each image were changed
+	Transformed []Transformed
+	// Uploaded and Skipped count the blobs uploaded and those the
+	// repository had already, Bytes and SkippedBytes their sizes
+	Uploaded     int
+	Skipped      int
+	Bytes        int64
+	SkippedBytes int64
+}
+
+// PushOptions change what Push uploads
+type PushOptions struct {
+	// Image, if set, selects the image of that name from an archive or
+	// layout holding several, such as a Store
+	Image string
+	// Annotations are added to the index and manifests pushed, which
+	// changes their digests
+	Annotations map[string]string
+	// Transform squashes or recompresses the layers of the images first
+	Transform Transform
+	// Platform, if set, selects the image of a platform from an index to
+	// push instead of the index
+	Platform *registry.Platform
+	// Progress, if set, is called as blobs are uploaded
+	Progress func(PushProgress)
+}
+
+// Push uploads the image of the archive or OCI layout at input to a
+// repository of a registry and tags it, without a docker daemon. Blobs the
+// repository has already are skipped. A multi-platform index is pushed
+// with all of its images; platforms whose blobs the archive lacks, as
+// docker save leaves out for platforms that weren't pulled, are left out
+// of it. input may also be a dir: or oci: source, read in place.
+func Push(ctx context.Context, input string, client *registry.Client, repo, tag string, opts PushOptions) (*Pushed, error) {
+	input, cleanup, err := openSource(input)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	format, err := Detect(input)
+	if err != nil {
+		return nil, err
+	}
+	name := opts.Image
+	if name != "" {
+		if name, err = familiarTag(name); err != nil {
+			return nil, err
+		}
+	}
+
+	layout := layoutDir(input)
+	// Archives are unpacked, and layers are transformed in a copy of a
+	// layout directory
+	if !format.Dir || !opts.Transform.IsZero() {
+		stage, err := os.MkdirTemp("", ".imgmigrate-push-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(stage)
+		if format.Dir {
+			err = copyLayout(input, stage)
+		} else {
+			err = unpack(input, format, stage)
+		}
+		if err != nil {
+			return nil, err
+		}
+		layout = layoutDir(stage)
+	}
+
+	image, err := layout.pushedImage(input, name, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	archiveDigest := image.Digest
+	var transformed []Transformed
+	if !opts.Transform.IsZero() {
+		// Only the selected image is transformed
+		selected := &ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{image}}
+		if err := layout.writeIndex(selected); err != nil {
+			return nil, err
+		}
+		if transformed, err = layout.transform(opts.Transform); err != nil {
+			return nil, err
+		}
+		index, err := layout.index()
+		if err != nil {
+			return nil, err
+		}
+		image = index.Manifests[0]
+	}
+
+	p := &pusher{ctx: ctx, client: client, repo: repo, layout: layout, annotations: opts.Annotations, progress: opts.Progress,
+		result:    &Pushed{ArchiveDigest: archiveDigest, Transformed: transformed},
+		pushed:    make(map[string]bool),
+		manifests: make(map[string]registry.Descriptor)}
+	image.Annotations = nil
+	pushed, err := p.pushManifest(image, tag, true)
+	if err != nil {
+		return nil, err
+	}
+	p.result.Digest = pushed.Digest
+	return p.result, nil
+}
+
+// pushedImage returns the descriptor of the single image index.json
+// references, or of the one named name when it is set, or of its image for
+// platform when that is set. input names the archive in errors.
+func (d layoutDir) pushedImage(input, name string, platform *registry.Platform) (registry.Descriptor, error) {
+	index, err := d.index()
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	var images []registry.Descriptor
+	for _, desc := range index.Manifests {
+		if name != "" && !slices.Contains(imageNames(desc.Annotations), name) {
+			continue
+		}
+		if !slices.ContainsFunc(images, func(image registry.Descriptor) bool { return image.Digest == desc.Digest }) {
+			images = append(images, desc)
+		}
+	}
+	switch {
+	case len(images) == 0 && name != "":
+		return registry.Descriptor{}, fmt.Errorf("%s holds no image named %s", input, name)
+	case len(images) == 0:
+		return registry.Descriptor{}, fmt.Errorf("%s lists no images", ociIndexFile)
+	case len(images) > 1:
+		return registry.Descriptor{}, fmt.Errorf("%s holds %d different images, only one can be pushed to a tag; select one by name", input, len(images))
+	}
+	if platform == nil {
+		return images[0], nil
+	}
+
+	manifests, err := d.platformManifests(images[0])
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	for _, manifest := range manifests {
+		path, err := d.blobPath(manifest.Digest)
+		if err != nil {
+			return registry.Descriptor{}, err
+		}
+		if _, err := os.Stat(path); err == nil && manifest.Platform.Matches(*platform) {
+			return manifest, nil
+		}
+	}
+	return registry.Descriptor{}, fmt.Errorf("%s holds no image for %s", input, platform)
+}
+
+// pusher uploads the blobs and manifests of an OCI layout to a repository
+type pusher struct {
+	ctx         context.Context
+	client      *registry.Client
+	repo        string
+	layout      layoutDir
+	annotations map[string]string
+	progress    func(PushProgress)
+	result      *Pushed
+	// pushed records the blobs uploaded or found in the repository
+	pushed map[string]bool
+	// manifests maps the digests of manifests in the layout to the
+	// descriptors they were pushed as
+	manifests map[string]registry.Descriptor
+}
+
+// pushManifest uploads a manifest with the manifests and blobs it
+// references and returns the descriptor it was pushed as. Annotations
+// change its digest, so ref is replaced by the new digest when it is the
+// old one. top is set for the manifest index.json lists, whose platforms
+// are recorded.
+func (p *pusher) pushManifest(desc registry.Descriptor, ref string, top bool) (registry.Descriptor, error) {
+	if pushed, ok := p.manifests[desc.Digest]; ok && !top {
+		desc.Digest, desc.Size = pushed.Digest, pushed.Size
+		return desc, nil
+	}
+	data, err := p.layout.readBlob(desc)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+
+	switch desc.MediaType {
+	case registry.MediaTypeOCIIndex, registry.MediaTypeManifestList:
+		if data, err = p.pushIndex(desc, data); err != nil {
+			return registry.Descriptor{}, err
+		}
+	case registry.MediaTypeOCIManifest, registry.MediaTypeManifest:
+		var manifest ociManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return registry.Descriptor{}, fmt.Errorf("invalid manifest %s: %v", desc.Digest, err)
+		}
+		for _, blob := range append([]registry.Descriptor{manifest.Config}, manifest.Layers...) {
+			if err := p.pushBlob(blob); err != nil {
+				return registry.Descriptor{}, err
+			}
+		}
+		if top {
+			platform := desc.Platform
+			if platform == nil {
+				found, err := p.layout.configPlatform(desc)
+				if err != nil {
+					return registry.Descriptor{}, err
+				}
+				platform = &found
+			}
+			p.result.Platforms = append(p.result.Platforms, *platform)
+		}
+	default:
+		return registry.Descriptor{}, fmt.Errorf("manifest %s has the unsupported media type %q", desc.Digest, desc.MediaType)
+	}
+	if len(p.annotations) > 0 {
+		if data, err = registry.AddAnnotations(data, p.annotations, ""); err != nil {
+			return registry.Descriptor{}, fmt.Errorf("invalid manifest %s: %v", desc.Digest, err)
+		}
+	}
+
+	pushed := desc
+	pushed.Digest = digestOf(data)
+	pushed.Size = int64(len(data))
+	if ref == desc.Digest {
+		ref = pushed.Digest
+	}
+	if top {
+		p.result.MediaType = desc.MediaType
+	}
+	digest, err := p.client.PushManifest(p.ctx, p.repo, ref, desc.MediaType, data)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	if digest != "" {
+		pushed.Digest = digest
+	}
+	p.manifests[desc.Digest] = pushed
+	return pushed, nil
+}
+
+// pushIndex uploads the manifests of an index and returns the index to
+// push. When the layout lacks the blobs of some of them, they are left
+// out of the returned index.
+func (p *pusher) pushIndex(desc registry.Descriptor, data []byte) ([]byte, error) {
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+	}
+
+	var kept []registry.Descriptor
+	changed := false
+	for _, child := range index.Manifests {
+		path, err := p.layout.blobPath(child.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if !child.IsAttestation() && child.Platform != nil {
+				p.result.Missing = append(p.result.Missing, child.Platform.String())
+			}
+			continue
+		}
+		pushed, err := p.pushManifest(child, child.Digest, false)
+		if err != nil {
+			return nil, err
+		}
+		if !child.IsAttestation() && child.Platform != nil {
+			p.result.Platforms = append(p.result.Platforms, *child.Platform)
+		}
+		changed = changed || pushed.Digest != child.Digest
+		kept = append(kept, pushed)
+	}
+	if len(kept) == len(index.Manifests) && !changed {
+		return data, nil
+	}
+	// Attestations name the digest of the image they describe
+	for i, child := range kept {
+		subject, ok := p.manifests[child.Annotations[annotationReferenceDigest]]
+		if child.IsAttestation() && ok {
+			kept[i].Annotations = maps.Clone(child.Annotations)
+			kept[i].Annotations[annotationReferenceDigest] = subject.Digest
+		}
+	}
+	if len(p.result.Platforms) == 0 {
+		return nil, fmt.Errorf("the archive holds none of the images of index %s", desc.Digest)
+	}
+
+	// Keep the other fields of the index, such as its annotations
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+	}
+	manifests, err := json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	fields["manifests"] = manifests
+	return json.Marshal(fields)
+}
+
+// pushBlob uploads a blob unless the repository has it already
+func (p *pusher) pushBlob(desc registry.Descriptor) error {
+	if p.pushed[desc.Digest] {
+		return nil
+	}
+	exists, err := p.client.BlobExists(p.ctx, p.repo, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		p.pushed[desc.Digest] = true
+		p.result.Skipped++
+		p.result.SkippedBytes += desc.Size
+		p.report(PushProgress{Blob: desc, Bytes: desc.Size, Exists: true, Done: true})
+		return nil
+	}
+
+	err = p.client.PushBlob(p.ctx, p.repo, desc, func() (io.ReadCloser, error) {
+		rc, err := p.layout.openBlob(desc)
+		if err != nil {
+			return nil, err
+		}
+		return &progressReader{ReadCloser: rc, report: func(n int64) {
+			p.report(PushProgress{Blob: desc, Bytes: n})
+		}}, nil
+	})
+	if err != nil {
+		return err
+	}
+	p.pushed[desc.Digest] = true
+	p.result.Uploaded++
+	p.result.Bytes += desc.Size
+	p.report(PushProgress{Blob: desc, Bytes: desc.Size, Done: true})
+	return nil
+}
+
+func (p *pusher) report(progress PushProgress) {
+	if p.progress != nil {
+		p.progress(progress)
+	}
+}
+
+// progressReader reports the number of bytes read so far
+type progressReader struct {
+	io.ReadCloser
+	read   int64
+	report func(int64)
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.read += int64(n)
+	r.report(r.read)
+	return n, err
+}
@@ -0,0 +1,62 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// reproducible makes the archives and compressed data the package writes
+// the same byte for byte whenever their content is: tar entries get fixed
+// times and owners, and zstd runs with fixed parameters. zstd output is
+// only the same for the same version of the zstd command, as its encoder
+// changes between releases.
+var reproducible = true
+
+// epoch is the modification time of the entries of reproducible archives
+var epoch = time.Unix(0, 0)
+
+// SetReproducible turns reproducible output on or off. Without it, entries
+// keep the times of their files and zstd uses all cores.
+func SetReproducible(on bool) {
+	reproducible = on
+}
+
+// Reproducible reports whether output is reproducible
+func Reproducible() bool {
+	return reproducible
+}
+
+// NewGzipWriter returns a gzip writer with a fixed header: no name, a zero
+// modification time and the unknown OS, so the same data always
+// compresses to the same bytes
+func NewGzipWriter(w io.Writer) *gzip.Writer {
+	gz := gzip.NewWriter(w)
+	gz.Header = gzip.Header{OS: 255}
+	return gz
+}
+
+// normalizeHeader clears the fields of a tar header that depend on when and
+// by whom a file was written, if output is reproducible
+func normalizeHeader(hdr *tar.Header) {
+	if !reproducible {
+		return
+	}
+	hdr.ModTime = epoch
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.PAXRecords = nil
+	hdr.Format = tar.FormatUnknown
+}
+
+// zstdArgs returns the compression level and threads of zstd. The level is
+// fixed so ZSTD_CLEVEL can't change the output.
+func zstdArgs() []string {
+	if reproducible {
+		return []string{"-3", "-T1"}
+	}
+	return []string{"-T0"}
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// compressions are the compressions written archives are checked with.
+// zstd output is only the same for the same version of the zstd command.
+var compressions = []Compression{Uncompressed, Gzip, Zstd}
+
+// needCompression skips a test of zstd output when there is no zstd
+// command
+func needCompression(t *testing.T, compression Compression) {
+	t.Helper()
+	if compression != Zstd {
+		return
+	}
+	if _, err := exec.LookPath(zstdBinary); err != nil {
+		t.Skipf("%s is not installed", zstdBinary)
+	}
+}
+
+// savedImage returns docker save output of a single image whose entries
+// were written at modTime by uid
+func savedImage(t *testing.T, modTime time.Time, uid int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	files := []struct{ name, content string }{
+		{"blobs/sha256/config", `{"architecture":"amd64","os":"linux"}`},
+		{"blobs/sha256/layer", "layer content"},
+		{dockerManifestFile, `[{"Config":"blobs/sha256/config","RepoTags":["nginx:1.25"],"Layers":["blobs/sha256/layer"]}]`},
+	}
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name:       file.name,
+			Mode:       0644,
+			Size:       int64(len(file.content)),
+			ModTime:    modTime,
+			AccessTime: modTime,
+			ChangeTime: modTime,
+			Uid:        uid,
+			Gid:        uid,
+			Uname:      "builder",
+			Format:     tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// renamed returns saved renamed to repoTags, compressed with compression
+func renamed(t *testing.T, saved []byte, compression Compression, repoTags []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRepoTags(bytes.NewReader(saved), w, repoTags); err != nil {
+		t.Fatalf("SetRepoTags: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestReproducibleSave saves the same image twice, at different times and
+// by different users, as a save does
+func TestReproducibleSave(t *testing.T) {
+	first := savedImage(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), 0)
+	second := savedImage(t, time.Date(2025, 7, 9, 8, 30, 15, 0, time.UTC), 1000)
+	repoTags := []string{"registry.example.com/team/nginx:1.25-linux-amd64"}
+	for _, compression := range compressions {
+		t.Run(Extension(compression), func(t *testing.T) {
+			needCompression(t, compression)
+			a := renamed(t, first, compression, repoTags)
+			b := renamed(t, second, compression, repoTags)
+			if !bytes.Equal(a, b) {
+				t.Errorf("saving the same image twice wrote different bytes (%d and %d)", len(a), len(b))
+			}
+		})
+	}
+
+	SetReproducible(false)
+	defer SetReproducible(true)
+	if bytes.Equal(renamed(t, first, Uncompressed, repoTags), renamed(t, second, Uncompressed, repoTags)) {
+		t.Error("saves at different times are the same without reproducible output")
+	}
+}
+
+// writeTestLayout writes an OCI layout holding a blob to dir, with the
+// modification time of its files set to modTime
+func writeTestLayout(t *testing.T, dir string, modTime time.Time) {
+	t.Helper()
+	blob := []byte("layer content")
+	files := map[string][]byte{
+		ociLayoutFile: []byte(ociLayoutContent),
+		ociIndexFile:  []byte(`{"schemaVersion":2,"manifests":[]}`),
+		"blobs/sha256/" + encoded(digestOf(blob)): blob,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestReproducibleConvert converts copies of a layout written at different
+// times to OCI archives, which must come out the same
+func TestReproducibleConvert(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	writeTestLayout(t, first, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	writeTestLayout(t, second, time.Date(2025, 7, 9, 8, 30, 15, 0, time.UTC))
+
+	for _, compression := range compressions {
+		t.Run(Extension(compression), func(t *testing.T) {
+			needCompression(t, compression)
+			to := Format{Layout: LayoutOCI, Compression: compression}
+			var outputs [][]byte
+			for _, input := range []string{first, second} {
+				output := input + Extension(compression)
+				if _, err := Convert(input, output, to); err != nil {
+					t.Fatalf("Convert(%s): %v", input, err)
+				}
+				data, err := os.ReadFile(output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputs = append(outputs, data)
+			}
+			if !bytes.Equal(outputs[0], outputs[1]) {
+				t.Errorf("converting the same layout twice wrote different bytes (%d and %d)", len(outputs[0]), len(outputs[1]))
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/distribution/reference"
+)
+
+// dockerRepositoriesFile is the legacy tag file of docker archives
+const dockerRepositoriesFile = "repositories"
+
+// SetRepoTags copies the docker save output of a single image from r to w
+// with the names of the image replaced by repoTags. The RepoTags of
+// manifest.json, the legacy repositories file and the name annotations of
+// index.json are rewritten; every other file is copied as it is. Entry
+// headers are normalized when output is reproducible.
+func SetRepoTags(r io.Reader, w io.Writer, repoTags []string) error {
+	if len(repoTags) == 0 {
+		return fmt.Errorf("no RepoTags to set")
+	}
+	return rewriteSaved(r, w, repoTags)
+}
+
+// Normalize copies docker save output from r to w with the entry headers
+// normalized for reproducible output, in the order docker wrote them
+func Normalize(r io.Reader, w io.Writer) error {
+	return rewriteSaved(r, w, nil)
+}
+
+// rewriteSaved copies docker save output from r to w, normalizing the
+// entry headers and, when repoTags is set, renaming the image
+func rewriteSaved(r io.Reader, w io.Writer, repoTags []string) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the saved image: %v", err)
+		}
+
+		normalizeHeader(hdr)
+		var rewrite func([]byte, []string) ([]byte, error)
+		switch cleanName(hdr.Name) {
+		case dockerManifestFile:
+			rewrite = setManifestRepoTags
+		case dockerRepositoriesFile:
+			rewrite = setRepositories
+		case ociIndexFile:
+			rewrite = setIndexNames
+		}
+		if rewrite == nil || len(repoTags) == 0 || hdr.Typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("failed to read %s of the saved image: %v", hdr.Name, err)
+		}
+		if data, err = rewrite(data, repoTags); err != nil {
+			return err
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	// Drain the padding after the end of the archive
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// setManifestRepoTags sets the RepoTags of the single image of a
+// manifest.json, keeping its other fields
+func setManifestRepoTags(data []byte, repoTags []string) ([]byte, error) {
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", dockerManifestFile, err)
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("%s lists %d images, RepoTags can only be set for one", dockerManifestFile, len(entries))
+	}
+	tags, err := json.Marshal(repoTags)
+	if err != nil {
+		return nil, err
+	}
+	entries[0]["RepoTags"] = tags
+	return json.Marshal(entries)
+}
+
+// setRepositories rewrites the repositories file, which maps repositories
+// and tags to the ID of the top layer
+func setRepositories(data []byte, repoTags []string) ([]byte, error) {
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(data, &repositories); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", dockerRepositoriesFile, err)
+	}
+	var id string
+	for _, tags := range repositories {
+		for _, value := range tags {
+			if id != "" && value != id {
+				return nil, fmt.Errorf("%s lists several images, RepoTags can only be set for one", dockerRepositoriesFile)
+			}
+			id = value
+		}
+	}
+	if id == "" {
+		return data, nil
+	}
+
+	rewritten := make(map[string]map[string]string)
+	for _, repoTag := range repoTags {
+		tagged, err := parseRepoTag(repoTag)
+		if err != nil {
+			return nil, err
+		}
+		name := reference.FamiliarName(tagged)
+		if rewritten[name] == nil {
+			rewritten[name] = make(map[string]string)
+		}
+		rewritten[name][tagged.Tag()] = id
+	}
+	return json.Marshal(rewritten)
+}
+
+// setIndexNames replaces the named descriptors of an index.json by one
+// descriptor of its image per RepoTag, keeping the other fields
+func setIndexNames(data []byte, repoTags []string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ociIndexFile, err)
+	}
+	var manifests []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["manifests"], &manifests); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ociIndexFile, err)
+	}
+	if len(manifests) == 0 {
+		return data, nil
+	}
+	for _, desc := range manifests[1:] {
+		if string(desc["digest"]) != string(manifests[0]["digest"]) {
+			return nil, fmt.Errorf("%s lists several images, RepoTags can only be set for one", ociIndexFile)
+		}
+	}
+	annotations := make(map[string]string)
+	if raw, ok := manifests[0]["annotations"]; ok {
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", ociIndexFile, err)
+		}
+	}
+
+	named := make([]map[string]json.RawMessage, 0, len(repoTags))
+	for _, repoTag := range repoTags {
+		names, err := nameAnnotations(repoTag)
+		if err != nil {
+			return nil, err
+		}
+		desc := maps.Clone(manifests[0])
+		if desc["annotations"], err = json.Marshal(mergeMaps(annotations, names)); err != nil {
+			return nil, err
+		}
+		named = append(named, desc)
+	}
+	encoded, err := json.Marshal(named)
+	if err != nil {
+		return nil, err
+	}
+	fields["manifests"] = encoded
+	return json.Marshal(fields)
+}
+
+// mergeMaps returns a copy of a with the entries of b added
+func mergeMaps(a, b map[string]string) map[string]string {
+	merged := maps.Clone(a)
+	maps.Copy(merged, b)
+	return merged
+}
+
+// parseRepoTag parses a RepoTag, which must carry a tag
+func parseRepoTag(repoTag string) (reference.NamedTagged, error) {
+	named, err := reference.ParseNormalizedNamed(repoTag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RepoTag %q: %v", repoTag, err)
+	}
+	tagged, ok := named.(reference.NamedTagged)
+	if !ok {
+		return nil, fmt.Errorf("invalid RepoTag %q: it has no tag", repoTag)
+	}
+	return tagged, nil
+}
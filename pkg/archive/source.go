@@ -0,0 +1,417 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Transports of the directories an image source can name instead of a
+// registry, with the syntax of skopeo
+const (
+	// TransportDir reads the directory skopeo copy writes for dir:/path:
+	// the manifest as manifest.json, the manifests of the images of a list
+	// as <hex>.manifest.json and every blob under its hex digest
+	TransportDir = "dir"
+	// TransportOCI reads an OCI image layout, oci:/path or oci:/path:ref
+	TransportOCI = "oci"
+)
+
+// dirManifestFile is the manifest of a dir: layout, which is the index of a
+// multi-platform image
+const dirManifestFile = "manifest.json"
+
+// Source is an image read from a directory rather than pulled
+type Source struct {
+	Transport string
+	Path      string
+	// Ref selects the image of an OCI layout by its
+	// org.opencontainers.image.ref.name annotation
+	Ref string
+}
+
+// IsSource reports whether ref names a dir: or oci: layout rather than an
+// image in a registry
+func IsSource(ref string) bool {
+	return strings.HasPrefix(ref, TransportDir+":") || strings.HasPrefix(ref, TransportOCI+":")
+}
+
+// ParseSource parses a dir:/path or oci:/path[:ref] source. As for skopeo,
+// the path of an oci: source ends at its first colon.
+func ParseSource(ref string) (*Source, error) {
+	transport, rest, _ := strings.Cut(ref, ":")
+	s := &Source{Transport: transport, Path: rest}
+	switch transport {
+	case TransportDir:
+	case TransportOCI:
+		s.Path, s.Ref, _ = strings.Cut(rest, ":")
+	default:
+		return nil, fmt.Errorf("%s is not a dir: or oci: source", ref)
+	}
+	if s.Path == "" {
+		return nil, fmt.Errorf("%s names no directory", ref)
+	}
+	return s, nil
+}
+
+// String returns the source as ParseSource accepts it
+func (s *Source) String() string {
+	if s.Ref != "" {
+		return s.Transport + ":" + s.Path + ":" + s.Ref
+	}
+	return s.Transport + ":" + s.Path
+}
+
+// IncompleteError lists the manifests and blobs a source lacks or holds
+// corrupt, in the words of skopeo for the missing ones
+type IncompleteError struct {
+	Source   string
+	Problems []string
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("%s is incomplete: %s", e.Source, strings.Join(e.Problems, "; "))
+}
+
+// Check reads the manifests of the source and checks that every blob they
+// reference is present with its size, without reading the blobs, so a
+// source that can't be used fails before anything is written or pushed.
+// It returns an *IncompleteError listing every problem found.
+func (s *Source) Check() error {
+	content := s.read(false)
+	if len(content.problems) > 0 {
+		return &IncompleteError{Source: s.String(), Problems: content.problems}
+	}
+	return nil
+}
+
+// ValidateSource validates the image of a dir: or oci: source like
+// Validate does an archive, also checking the content of every blob
+// against its digest
+func ValidateSource(ref string) *Validation {
+	v := &Validation{Path: ref}
+	s, err := ParseSource(ref)
+	if err != nil {
+		v.problemf("%v", err)
+		return v
+	}
+	v.Format = s.Transport
+	if s.Transport == TransportOCI {
+		v.Format = Format{Layout: LayoutOCI, Dir: true}.String()
+	}
+	content := s.read(true)
+	v.Images = content.images
+	v.Problems = content.problems
+	return v
+}
+
+// Stage makes the image of the source an OCI layout in a temporary
+// directory below dir, or the default directory for temporary files when
+// dir is empty, and returns it with a function removing it. Blobs are
+// linked rather than copied. It fails like Check when the source is
+// incomplete.
+func (s *Source) Stage(dir string) (string, func(), error) {
+	content := s.read(false)
+	if len(content.problems) > 0 {
+		return "", nil, &IncompleteError{Source: s.String(), Problems: content.problems}
+	}
+
+	stage, err := os.MkdirTemp(dir, ".imgmigrate-source-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(stage) }
+	layout := layoutDir(stage)
+	for _, data := range content.manifests {
+		if _, err := layout.writeBlob(data); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	for digest, path := range content.blobs {
+		blob, _ := layout.blobPath(digest)
+		if err := linkFile(path, blob); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	if err := layout.writeIndex(&ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{content.top}}); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return stage, cleanup, nil
+}
+
+// sourceContent is what reading a source found: the descriptor of its
+// image, the content of its manifests and the paths of its other blobs by
+// digest, and the problems that make it unusable
+type sourceContent struct {
+	top       registry.Descriptor
+	manifests map[string][]byte
+	blobs     map[string]string
+	images    int
+	problems  []string
+}
+
+func (c *sourceContent) problemf(format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// read reads the manifests of the source and looks up its blobs, hashing
+// them when verify is set
+func (s *Source) read(verify bool) *sourceContent {
+	c := &sourceContent{manifests: make(map[string][]byte), blobs: make(map[string]string)}
+	info, err := os.Stat(s.Path)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", s.Path)
+	}
+	if err != nil {
+		c.problemf("%v", err)
+		return c
+	}
+
+	var data []byte
+	if s.Transport == TransportDir {
+		path := filepath.Join(s.Path, dirManifestFile)
+		if data, err = os.ReadFile(path); err != nil {
+			c.problemf("reading manifest: %v", err)
+			return c
+		}
+		c.top = registry.Descriptor{Digest: digestOf(data), Size: int64(len(data))}
+	} else {
+		if c.top, err = s.ociImage(); err != nil {
+			c.problemf("%v", err)
+			return c
+		}
+		if data, err = s.manifest(c.top); err != nil {
+			c.problemf("%v", err)
+			return c
+		}
+	}
+	if c.top.MediaType, err = manifestMediaType(data); err != nil {
+		c.problemf("manifest %s: %v", c.top.Digest, err)
+		return c
+	}
+	c.walk(s, c.top, data, verify)
+	return c
+}
+
+// ociImage returns the descriptor of the image an oci: source selects: the
+// one its ref names, or the only image of the layout
+func (s *Source) ociImage() (registry.Descriptor, error) {
+	index, err := layoutDir(s.Path).index()
+	if err != nil {
+		return registry.Descriptor{}, fmt.Errorf("reading index: %v", err)
+	}
+	var images []registry.Descriptor
+	for _, desc := range index.Manifests {
+		if s.Ref != "" && desc.Annotations[annotationRefName] != s.Ref {
+			continue
+		}
+		if !slices.ContainsFunc(images, func(image registry.Descriptor) bool { return image.Digest == desc.Digest }) {
+			images = append(images, desc)
+		}
+	}
+	switch {
+	case len(images) == 0 && s.Ref != "":
+		return registry.Descriptor{}, fmt.Errorf("no descriptor found for reference %q", s.Ref)
+	case len(images) == 0:
+		return registry.Descriptor{}, fmt.Errorf("no descriptors found in %s", ociIndexFile)
+	case len(images) > 1:
+		return registry.Descriptor{}, fmt.Errorf("more than one image in oci, choose an image")
+	}
+	return images[0], nil
+}
+
+// manifest reads the manifest desc stands for and checks its digest
+func (s *Source) manifest(desc registry.Descriptor) ([]byte, error) {
+	path, err := s.manifestPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %v", desc.Digest, err)
+	}
+	if digest := digestOf(data); digest != desc.Digest {
+		return nil, fmt.Errorf("manifest %s is corrupt: its content has digest %s", desc.Digest, digest)
+	}
+	return data, nil
+}
+
+// manifestPath returns the file of the manifest with a digest, which a
+// dir: layout keeps apart from its blobs for the images of a list
+func (s *Source) manifestPath(digest string) (string, error) {
+	if s.Transport == TransportOCI {
+		return layoutDir(s.Path).blobPath(digest)
+	}
+	encoded, err := encodedDigest(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Path, encoded+".manifest.json"), nil
+}
+
+// blobPath returns the file of a blob. Layouts of version 1.0 of the dir
+// transport name blobs with a .tar extension.
+func (s *Source) blobPath(digest string) (string, error) {
+	if s.Transport == TransportOCI {
+		return layoutDir(s.Path).blobPath(digest)
+	}
+	encoded, err := encodedDigest(digest)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.Path, encoded)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(path + ".tar"); err == nil {
+			return path + ".tar", nil
+		}
+	}
+	return path, nil
+}
+
+// walk records the manifest desc with its content data, and the manifests
+// and blobs it references
+func (c *sourceContent) walk(s *Source, desc registry.Descriptor, data []byte, verify bool) {
+	c.manifests[desc.Digest] = data
+	switch desc.MediaType {
+	case registry.MediaTypeOCIIndex, registry.MediaTypeManifestList:
+		var index ociIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			c.problemf("invalid index %s: %v", desc.Digest, err)
+			return
+		}
+		for _, child := range index.Manifests {
+			if _, ok := c.manifests[child.Digest]; ok {
+				continue
+			}
+			childData, err := s.manifest(child)
+			if err != nil {
+				c.problemf("%v", err)
+				continue
+			}
+			c.walk(s, child, childData, verify)
+		}
+		return
+	case registry.MediaTypeOCIManifest, registry.MediaTypeManifest:
+	default:
+		c.problemf("manifest %s has the unsupported media type %q", desc.Digest, desc.MediaType)
+		return
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		c.problemf("invalid manifest %s: %v", desc.Digest, err)
+		return
+	}
+	c.images++
+	for _, blob := range append([]registry.Descriptor{manifest.Config}, manifest.Layers...) {
+		if _, ok := c.blobs[blob.Digest]; ok {
+			continue
+		}
+		path, err := s.blobPath(blob.Digest)
+		if err != nil {
+			c.problemf("%v", err)
+			continue
+		}
+		if err := checkBlobFile(path, blob, verify); err != nil {
+			c.problemf("%v", err)
+			continue
+		}
+		c.blobs[blob.Digest] = path
+	}
+}
+
+// checkBlobFile checks that the file of a blob has the size of its
+// descriptor and, with verify, its digest
+func checkBlobFile(path string, desc registry.Descriptor, verify bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %v", desc.Digest, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %v", desc.Digest, err)
+	}
+	if info.Size() != desc.Size {
+		return fmt.Errorf("blob %s is corrupt: it has %d bytes instead of %d", desc.Digest, info.Size(), desc.Size)
+	}
+	if !verify {
+		return nil
+	}
+	_, err = io.Copy(io.Discard, &verifiedReader{r: file, hash: sha256.New(), desc: desc})
+	return err
+}
+
+// manifestMediaType returns the media type of a manifest, telling OCI
+// manifests and indexes without one apart by their fields
+func manifestMediaType(data []byte) (string, error) {
+	var manifest struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("invalid manifest: %v", err)
+	}
+	switch {
+	case manifest.SchemaVersion == 1:
+		return "", fmt.Errorf("schema 1 manifests are not supported, copy the image with skopeo --format v2s2 or oci")
+	case manifest.MediaType != "":
+		return manifest.MediaType, nil
+	case manifest.Manifests != nil:
+		return registry.MediaTypeOCIIndex, nil
+	}
+	return registry.MediaTypeOCIManifest, nil
+}
+
+// encodedDigest returns the hex part of a sha256 digest
+func encodedDigest(digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(encoded) != sha256.Size*2 {
+		return "", fmt.Errorf("unsupported or invalid digest %q", digest)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return encoded, nil
+}
+
+// linkFile links src to dst, creating the directory of dst, with a
+// symbolic link when src is on another file system
+func linkFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(abs, dst)
+}
+
+// openSource stages input when it is a dir: or oci: source, so that it can
+// be read as an OCI layout directory, and returns other inputs as they are
+func openSource(input string) (string, func(), error) {
+	if !IsSource(input) {
+		return input, func() {}, nil
+	}
+	s, err := ParseSource(input)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.Stage("")
+}
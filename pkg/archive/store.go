@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Store is an OCI image layout directory shared by many images: each image
+// is listed in index.json under its names, and every blob is stored once
+// however many images use it. push-archive reads it like any OCI layout.
+type Store struct {
+	layout layoutDir
+	mu     sync.Mutex
+}
+
+// Stored is an image added to a Store
+type Stored struct {
+	Digest   string
+	Platform registry.Platform
+	// ArchiveSize is the size of the archive the image was added from and
+	// Added the size of the blobs the store didn't have yet
+	ArchiveSize int64
+	Added       int64
+}
+
+// OpenStore opens the store at dir, creating it when it doesn't exist
+func OpenStore(dir string) (*Store, error) {
+	layout := layoutDir(dir)
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFile)); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		empty := &ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{}}
+		if err := layout.writeIndex(empty); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := layout.index(); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return &Store{layout: layout}, nil
+}
+
+// Add adds the single image of the archive or OCI layout at input to the
+// store and lists it under names, or the names the input gives it when
+// names is empty. Entries of other images with the same names are replaced.
+func (s *Store) Add(input string, names []string) (*Stored, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	// Unpack next to the blobs, so they can be linked into place
+	stage, err := os.MkdirTemp(string(s.layout), ".imgmigrate-add-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	image, added, err := s.layout.importInput(input, filepath.Join(stage, "input"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", input, err)
+	}
+	if len(names) == 0 {
+		names = image.names
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: the image has no name to list it under", input)
+	}
+
+	entries := make([]registry.Descriptor, 0, len(names))
+	for _, name := range names {
+		if name, err = familiarTag(name); err != nil {
+			return nil, err
+		}
+		entry := image.manifest
+		if entry.Annotations, err = nameAnnotations(name); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := s.list(entries); err != nil {
+		return nil, err
+	}
+
+	stored := &Stored{Digest: image.manifest.Digest, Platform: *image.manifest.Platform, Added: added, ArchiveSize: info.Size()}
+	if info.IsDir() {
+		stored.ArchiveSize = 0
+	}
+	return stored, nil
+}
+
+// AddIndex lists a multi-platform index of images in the store under name.
+// The images are given by digest and must have been added before.
+func (s *Store) AddIndex(name string, digests []string) (string, error) {
+	name, err := familiarTag(name)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, err := s.layout.index()
+	if err != nil {
+		return "", err
+	}
+
+	list := ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{}}
+	for _, digest := range digests {
+		i := slices.IndexFunc(index.Manifests, func(desc registry.Descriptor) bool { return desc.Digest == digest })
+		if i < 0 {
+			return "", fmt.Errorf("the store holds no image %s", digest)
+		}
+		image := index.Manifests[i]
+		image.Annotations = nil
+		if !slices.ContainsFunc(list.Manifests, func(desc registry.Descriptor) bool { return desc.Digest == digest }) {
+			list.Manifests = append(list.Manifests, image)
+		}
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	digest, err := s.layout.writeBlob(data)
+	if err != nil {
+		return "", err
+	}
+	annotations, err := nameAnnotations(name)
+	if err != nil {
+		return "", err
+	}
+	entry := registry.Descriptor{MediaType: registry.MediaTypeOCIIndex, Digest: digest, Size: int64(len(data)), Annotations: annotations}
+	return digest, s.list([]registry.Descriptor{entry})
+}
+
+// list adds named entries to index.json, dropping the entries they take
+// the names of
+func (s *Store) list(entries []registry.Descriptor) error {
+	index, err := s.layout.index()
+	if err != nil {
+		return err
+	}
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(desc registry.Descriptor) bool {
+		return slices.ContainsFunc(entries, func(entry registry.Descriptor) bool {
+			return desc.Annotations[annotationImageName] == entry.Annotations[annotationImageName]
+		})
+	})
+	index.Manifests = append(index.Manifests, entries...)
+	return s.layout.writeIndex(index)
+}
+
+// ImageNames returns the names of the images of an archive or OCI layout,
+// such as a store, in the order index.json lists them
+func ImageNames(input string) ([]string, error) {
+	layout, cleanup, err := openLayout(input)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	index, err := layout.index()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, desc := range index.Manifests {
+		for _, name := range imageNames(desc.Annotations) {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
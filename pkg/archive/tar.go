@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// extract unpacks a tar stream into dir. Entries can't be written or link
+// outside of dir.
+func extract(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := cleanName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = extractFile(tr, target)
+		case tar.TypeSymlink:
+			linked := path.Join(path.Dir(name), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || linked == ".." || strings.HasPrefix(linked, "../") {
+				return fmt.Errorf("entry %s links outside of the archive to %s", hdr.Name, hdr.Linkname)
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, target)
+			}
+		case tar.TypeLink:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Link(filepath.Join(dir, filepath.FromSlash(cleanName(hdr.Linkname))), target)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// extractFile writes the current tar entry to path
+func extractFile(r io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// layoutFiles returns the files of an OCI layout, relative to dir and in
+// the order they are archived: oci-layout, index.json and the blobs.
+// Anything else in dir, such as the files of a docker archive, is left out.
+func layoutFiles(dir string) ([]string, error) {
+	files := []string{ociLayoutFile, ociIndexFile}
+	err := filepath.WalkDir(filepath.Join(dir, "blobs"), func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Blobs of a staged source may be linked to its files
+		if entry.Type().IsRegular() || entry.Type()&fs.ModeSymlink != 0 {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// writeOCIArchive writes the OCI layout in dir as a tar stream
+func writeOCIArchive(dir string, w io.Writer) error {
+	files, err := layoutFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, name := range []string{"blobs/", "blobs/sha256/"} {
+		hdr := &tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir}
+		normalizeHeader(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range files {
+		if err := copyFile(tw, filepath.Join(dir, filepath.FromSlash(name)), name); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// copyFile writes a file of a layout to a tar stream under name. Blobs are
+// checked against the digest they are named after.
+func copyFile(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+	normalizeHeader(hdr)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, verifyBlob(file, name, info.Size()))
+	return err
+}
+
+// verifyBlob returns a reader of r that fails at the end when name is a
+// blob whose content doesn't match the digest in its name
+func verifyBlob(r io.Reader, name string, size int64) io.Reader {
+	encoded, ok := strings.CutPrefix(cleanName(name), "blobs/sha256/")
+	if !ok || len(encoded) != sha256.Size*2 {
+		return r
+	}
+	return &verifiedReader{r: r, hash: sha256.New(), desc: registry.Descriptor{Digest: "sha256:" + encoded, Size: size}}
+}
+
+// copyLayout copies the files of the OCI layout in src to dst
+func copyLayout(src, dst string) error {
+	files, err := layoutFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := copyPlainFile(filepath.Join(src, filepath.FromSlash(name)), filepath.Join(dst, filepath.FromSlash(name)), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPlainFile copies the file of a layout named name, creating the
+// directory of dst. Blobs are checked against their digest.
+func copyPlainFile(src, dst, name string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	return extractFile(verifyBlob(in, name, info.Size()), dst)
+}
+
+// pruneLayout removes everything but the OCI layout from dir, such as the
+// manifest.json and layer directories of a converted docker archive
+func pruneLayout(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch entry.Name() {
+		case ociLayoutFile, ociIndexFile, "blobs":
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repack copies the entries of a tar stream to another one, which changes
+// the compression of an archive without unpacking it. It fails when the
+// stream turns out not to be an image archive of the given layout or the
+// files its manifest.json or index.json reference are missing.
+func repack(tr *tar.Reader, tw *tar.Writer, layout Layout) error {
+	names := make(map[string]bool)
+	var listing []byte
+	listingFile := dockerManifestFile
+	if layout == LayoutOCI {
+		listingFile = ociIndexFile
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		names[cleanName(hdr.Name)] = true
+
+		normalizeHeader(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		data := verifyBlob(tr, hdr.Name, hdr.Size)
+		if cleanName(hdr.Name) == listingFile {
+			if listing, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+			data = bytes.NewReader(listing)
+		}
+		if _, err := io.Copy(tw, data); err != nil {
+			return err
+		}
+	}
+
+	if listing == nil {
+		return fmt.Errorf("not a %s archive: %s is missing", layout, listingFile)
+	}
+	referenced, err := listedFiles(listing, layout)
+	if err != nil {
+		return err
+	}
+	for _, name := range referenced {
+		if !names[cleanName(name)] {
+			return fmt.Errorf("%s references %s, which is missing", listingFile, name)
+		}
+	}
+	return tw.Close()
+}
+
+// listedFiles returns the files the manifest.json or index.json of an
+// archive references directly
+func listedFiles(listing []byte, layout Layout) ([]string, error) {
+	var files []string
+	if layout == LayoutDocker {
+		var entries []dockerManifest
+		if err := json.Unmarshal(listing, &entries); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", dockerManifestFile, err)
+		}
+		for _, entry := range entries {
+			files = append(files, entry.Config)
+			files = append(files, entry.Layers...)
+		}
+		return files, nil
+	}
+
+	var index ociIndex
+	if err := json.Unmarshal(listing, &index); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ociIndexFile, err)
+	}
+	for _, desc := range index.Manifests {
+		blob, err := layoutDir("").blobPath(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, filepath.ToSlash(blob))
+	}
+	return files, nil
+}
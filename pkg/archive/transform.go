@@ -0,0 +1,550 @@
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Docker media types of image configs and gzip compressed layers
+const (
+	mediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeDockerLayer  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Whiteout files of layers, which delete a path of the layers below or,
+// for the opaque whiteout, everything below a directory
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// Transform changes the layers of images before they are pushed
+type Transform struct {
+	// Squash flattens the layers of every image into one, which changes
+	// the digests of its config and manifest
+	Squash bool
+	// Recompress, if set, compresses the layers with gzip or zstd. Docker
+	// manifests have no zstd layers, so they are turned into OCI manifests.
+	Recompress Compression
+}
+
+// IsZero reports whether the transform leaves images as they are
+func (t Transform) IsZero() bool {
+	return !t.Squash && t.Recompress == Uncompressed
+}
+
+// ParseRecompression parses the compression to recompress layers with; an
+// empty name keeps the compression of every layer
+func ParseRecompression(name string) (Compression, error) {
+	switch compression := Compression(name); compression {
+	case Uncompressed, Gzip, Zstd:
+		return compression, nil
+	}
+	return Uncompressed, fmt.Errorf("unknown layer compression %q, supported: gzip, zstd", name)
+}
+
+// Transformed records how a transform changed the layers of an image
+type Transformed struct {
+	Platform     registry.Platform
+	LayersBefore int
+	LayersAfter  int
+	// SizeBefore and SizeAfter are the total sizes of the layer blobs
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// transform applies t to the images index.json references, rewriting
+// their manifests, configs and indexes in the layout. Squashing an index
+// with attestations is refused: they would describe layers that are gone.
+func (d layoutDir) transform(t Transform) ([]Transformed, error) {
+	index, err := d.index()
+	if err != nil {
+		return nil, err
+	}
+	tr := &transformer{layout: d, transform: t, done: make(map[string]registry.Descriptor)}
+	for i, desc := range index.Manifests {
+		if index.Manifests[i], err = tr.manifest(desc); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.writeIndex(index); err != nil {
+		return nil, err
+	}
+	return tr.results, nil
+}
+
+// transformer holds the state of a layout transform
+type transformer struct {
+	layout    layoutDir
+	transform Transform
+	results   []Transformed
+	// done maps the digests of transformed manifests to their new
+	// descriptors
+	done map[string]registry.Descriptor
+}
+
+// manifest transforms the image or index desc references and returns its
+// new descriptor, which keeps the platform and annotations of desc
+func (t *transformer) manifest(desc registry.Descriptor) (registry.Descriptor, error) {
+	if transformed, ok := t.done[desc.Digest]; ok {
+		desc.MediaType, desc.Digest, desc.Size = transformed.MediaType, transformed.Digest, transformed.Size
+		return desc, nil
+	}
+
+	var data []byte
+	var mediaType string
+	var err error
+	switch desc.MediaType {
+	case registry.MediaTypeOCIIndex, registry.MediaTypeManifestList:
+		data, mediaType, err = t.index(desc)
+	case registry.MediaTypeOCIManifest, registry.MediaTypeManifest:
+		data, mediaType, err = t.image(desc)
+	default:
+		err = fmt.Errorf("manifest %s has the unsupported media type %q", desc.Digest, desc.MediaType)
+	}
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+
+	digest, err := t.layout.writeBlob(data)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	t.done[desc.Digest] = registry.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+	desc.MediaType, desc.Digest, desc.Size = mediaType, digest, int64(len(data))
+	return desc, nil
+}
+
+// index transforms the images of an index and returns the index with their
+// new descriptors, keeping its other fields. Attestations are left as they
+// are, apart from the digest of the image they describe.
+func (t *transformer) index(desc registry.Descriptor) ([]byte, string, error) {
+	data, err := t.layout.readBlob(desc)
+	if err != nil {
+		return nil, "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, "", fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+	}
+	var children []registry.Descriptor
+	if err := json.Unmarshal(fields["manifests"], &children); err != nil {
+		return nil, "", fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+	}
+
+	mediaType := desc.MediaType
+	for i, child := range children {
+		if child.IsAttestation() {
+			if t.transform.Squash {
+				return nil, "", fmt.Errorf("index %s holds attestations, which would describe layers squashing removes", desc.Digest)
+			}
+			continue
+		}
+		path, err := t.layout.blobPath(child.Digest)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			// Left out when pushed, as docker save leaves out platforms
+			// that weren't pulled
+			continue
+		}
+		if children[i], err = t.manifest(child); err != nil {
+			return nil, "", err
+		}
+		if children[i].MediaType == registry.MediaTypeOCIManifest {
+			mediaType = registry.MediaTypeOCIIndex
+		}
+	}
+	for i, child := range children {
+		if subject, ok := t.done[child.Annotations[annotationReferenceDigest]]; ok && child.IsAttestation() {
+			children[i].Annotations = maps.Clone(child.Annotations)
+			children[i].Annotations[annotationReferenceDigest] = subject.Digest
+		}
+	}
+
+	if fields["manifests"], err = json.Marshal(children); err != nil {
+		return nil, "", err
+	}
+	if fields["mediaType"], err = json.Marshal(mediaType); err != nil {
+		return nil, "", err
+	}
+	data, err = json.Marshal(fields)
+	return data, mediaType, err
+}
+
+// image transforms the layers of an image manifest and returns the new
+// manifest, keeping its other fields
+func (t *transformer) image(desc registry.Descriptor) ([]byte, string, error) {
+	data, err := t.layout.readBlob(desc)
+	if err != nil {
+		return nil, "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, "", fmt.Errorf("invalid manifest %s: %v", desc.Digest, err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("invalid manifest %s: %v", desc.Digest, err)
+	}
+
+	platform := desc.Platform
+	if platform == nil {
+		found, err := t.layout.configPlatform(desc)
+		if err != nil {
+			return nil, "", err
+		}
+		platform = &found
+	}
+	result := Transformed{Platform: *platform, LayersBefore: len(manifest.Layers)}
+	for _, layer := range manifest.Layers {
+		result.SizeBefore += layer.Size
+	}
+
+	// zstd layers need an OCI manifest
+	mediaType := desc.MediaType
+	if t.transform.Recompress == Zstd {
+		mediaType = registry.MediaTypeOCIManifest
+	}
+
+	config := manifest.Config
+	var layers []registry.Descriptor
+	if t.transform.Squash {
+		if layers, config, err = t.squash(manifest, mediaType); err != nil {
+			return nil, "", err
+		}
+	} else {
+		for _, layer := range manifest.Layers {
+			recompressed, err := t.recompress(layer, mediaType)
+			if err != nil {
+				return nil, "", err
+			}
+			layers = append(layers, recompressed)
+		}
+	}
+	if mediaType == registry.MediaTypeOCIManifest && config.MediaType == mediaTypeDockerConfig {
+		config.MediaType = mediaTypeConfig
+	}
+
+	result.LayersAfter = len(layers)
+	for _, layer := range layers {
+		result.SizeAfter += layer.Size
+	}
+	t.results = append(t.results, result)
+
+	if fields["config"], err = json.Marshal(config); err != nil {
+		return nil, "", err
+	}
+	if fields["layers"], err = json.Marshal(layers); err != nil {
+		return nil, "", err
+	}
+	if fields["mediaType"], err = json.Marshal(mediaType); err != nil {
+		return nil, "", err
+	}
+	data, err = json.Marshal(fields)
+	return data, mediaType, err
+}
+
+// recompress compresses a layer with the compression of the transform and
+// returns its descriptor in a manifest of mediaType. Layers that have the
+// compression already are only given the media type. Non-distributable
+// layers, which an archive doesn't hold, are kept.
+func (t *transformer) recompress(layer registry.Descriptor, mediaType string) (registry.Descriptor, error) {
+	if nonDistributable(layer) {
+		return layer, nil
+	}
+	compression := t.transform.Recompress
+	if compression == layerCompression(layer) {
+		layer.MediaType = layerMediaType(mediaType, compression)
+		return layer, nil
+	}
+
+	digest, size, err := t.layout.writeBlobFrom(func(w io.Writer) error {
+		return compressTo(w, compression, func(w io.Writer) error {
+			return t.layout.readLayer(layer, func(r io.Reader) error {
+				_, err := io.Copy(w, r)
+				return err
+			})
+		})
+	})
+	if err != nil {
+		return registry.Descriptor{}, fmt.Errorf("failed to recompress layer %s: %v", layer.Digest, err)
+	}
+	return registry.Descriptor{MediaType: layerMediaType(mediaType, compression), Digest: digest, Size: size, Annotations: layer.Annotations}, nil
+}
+
+// squash writes the filesystem the layers of an image stack up to as a
+// single layer and returns it with the image config updated for it
+func (t *transformer) squash(manifest ociManifest, mediaType string) ([]registry.Descriptor, registry.Descriptor, error) {
+	for _, layer := range manifest.Layers {
+		if nonDistributable(layer) {
+			return nil, registry.Descriptor{}, fmt.Errorf("layer %s is non-distributable and can't be squashed", layer.Digest)
+		}
+	}
+	compression := t.transform.Recompress
+	if compression == Uncompressed {
+		compression = Gzip
+	}
+
+	diffID := sha256.New()
+	digest, size, err := t.layout.writeBlobFrom(func(w io.Writer) error {
+		return compressTo(w, compression, func(w io.Writer) error {
+			return t.layout.squashLayers(manifest.Layers, io.MultiWriter(w, diffID))
+		})
+	})
+	if err != nil {
+		return nil, registry.Descriptor{}, fmt.Errorf("failed to squash the layers of %s: %v", manifest.Config.Digest, err)
+	}
+	layer := registry.Descriptor{MediaType: layerMediaType(mediaType, compression), Digest: digest, Size: size}
+
+	config, err := t.layout.squashConfig(manifest.Config, "sha256:"+hex.EncodeToString(diffID.Sum(nil)), len(manifest.Layers))
+	if err != nil {
+		return nil, registry.Descriptor{}, err
+	}
+	return []registry.Descriptor{layer}, config, nil
+}
+
+// squashConfig writes an image config whose root filesystem is the single
+// layer diffID. The history is kept, with its entries marked as creating no
+// layer, and an entry for the squashed layer is added.
+func (d layoutDir) squashConfig(desc registry.Descriptor, diffID string, layers int) (registry.Descriptor, error) {
+	data, err := d.readBlob(desc)
+	if err != nil {
+		return desc, err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return desc, fmt.Errorf("invalid image config %s: %v", desc.Digest, err)
+	}
+	var history []map[string]any
+	if raw, ok := config["history"]; ok {
+		if err := json.Unmarshal(raw, &history); err != nil {
+			return desc, fmt.Errorf("invalid history in image config %s: %v", desc.Digest, err)
+		}
+	}
+	for _, entry := range history {
+		entry["empty_layer"] = true
+	}
+	history = append(history, map[string]any{"created_by": "imgMigrate squash", "comment": fmt.Sprintf("squashed %d layers", layers)})
+
+	if config["history"], err = json.Marshal(history); err != nil {
+		return desc, err
+	}
+	rootfs := map[string]any{"type": "layers", "diff_ids": []string{diffID}}
+	if config["rootfs"], err = json.Marshal(rootfs); err != nil {
+		return desc, err
+	}
+	if data, err = json.Marshal(config); err != nil {
+		return desc, err
+	}
+	if desc.Digest, err = d.writeBlob(data); err != nil {
+		return desc, err
+	}
+	desc.Size = int64(len(data))
+	return desc, nil
+}
+
+// squashLayers writes the files of layers, bottom first, as one tar to w.
+// Whiteouts are applied, so files deleted or replaced by an upper layer
+// are left out, as are the whiteouts themselves. The layers are read twice:
+// from the top to find the files that survive and from the bottom to
+// write them, so hard links follow their targets.
+func (d layoutDir) squashLayers(layers []registry.Descriptor, w io.Writer) error {
+	// hides records the paths an upper layer has; those that are not
+	// directories hide everything below them
+	hides := make(map[string]bool)
+	opaque := make(map[string]bool)
+	keep := make([]map[string]bool, len(layers))
+	hidden := func(name string) bool {
+		for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if hides[dir] || opaque[dir] {
+				return true
+			}
+		}
+		return false
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		keep[i] = make(map[string]bool)
+		layerOpaque := make(map[string]bool)
+		err := d.readLayerFiles(layers[i], func(hdr *tar.Header, _ io.Reader) error {
+			name := layerPath(hdr.Name)
+			dir, base := path.Split(name)
+			dir = path.Clean(dir)
+			if base == whiteoutOpaque {
+				layerOpaque[dir] = true
+				return nil
+			}
+			whiteout := strings.HasPrefix(base, whiteoutPrefix)
+			if whiteout {
+				name = path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			}
+			if _, ok := hides[name]; ok || hidden(name) {
+				return nil
+			}
+			hides[name] = whiteout || hdr.Typeflag != tar.TypeDir
+			if !whiteout {
+				keep[i][name] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		maps.Copy(opaque, layerOpaque)
+	}
+
+	tw := tar.NewWriter(w)
+	for i, layer := range layers {
+		err := d.readLayerFiles(layer, func(hdr *tar.Header, r io.Reader) error {
+			if !keep[i][layerPath(hdr.Name)] {
+				return nil
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// layerPath returns the path of a layer entry without leading slashes
+func layerPath(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+// readLayer calls fn with the uncompressed content of a layer blob
+func (d layoutDir) readLayer(layer registry.Descriptor, fn func(r io.Reader) error) error {
+	rc, err := d.openBlob(layer)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	plain, _, err := decompress(rc)
+	if err != nil {
+		return fmt.Errorf("layer %s: %v", layer.Digest, err)
+	}
+	err = fn(plain)
+	if err == nil {
+		// Read to the end, so the digest of the blob is checked
+		_, err = io.Copy(io.Discard, plain)
+	}
+	if closeErr := plain.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+// readLayerFiles calls fn for every entry of a layer
+func (d layoutDir) readLayerFiles(layer registry.Descriptor, fn func(hdr *tar.Header, r io.Reader) error) error {
+	return d.readLayer(layer, func(r io.Reader) error {
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid layer %s: %v", layer.Digest, err)
+			}
+			if err := fn(hdr, tr); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// writeBlobFrom stores what write writes as a blob and returns its digest
+// and size
+func (d layoutDir) writeBlobFrom(write func(w io.Writer) error) (string, int64, error) {
+	dir := filepath.Join(string(d), "blobs", "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	file, err := os.CreateTemp(dir, ".blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	err = write(io.MultiWriter(file, hash))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		return "", 0, err
+	}
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	path, _ := d.blobPath(digest)
+	return digest, info.Size(), os.Rename(file.Name(), path)
+}
+
+// compressTo compresses what write writes to w
+func compressTo(w io.Writer, compression Compression, write func(w io.Writer) error) error {
+	cw, err := compress(w, compression)
+	if err != nil {
+		return err
+	}
+	if err := write(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// layerCompression returns the compression of a layer from its media type
+func layerCompression(layer registry.Descriptor) Compression {
+	switch {
+	case strings.HasSuffix(layer.MediaType, "zstd"):
+		return Zstd
+	case strings.HasSuffix(layer.MediaType, "gzip"):
+		return Gzip
+	}
+	return Uncompressed
+}
+
+// layerMediaType returns the media type of a layer with a compression in a
+// manifest of mediaType
+func layerMediaType(mediaType string, compression Compression) string {
+	if mediaType == registry.MediaTypeManifest {
+		return mediaTypeDockerLayer
+	}
+	switch compression {
+	case Gzip:
+		return mediaTypeLayerGzip
+	case Zstd:
+		return mediaTypeLayerZstd
+	}
+	return mediaTypeLayer
+}
+
+// nonDistributable reports whether a layer is one registries don't serve,
+// such as the base layers of Windows images
+func nonDistributable(layer registry.Descriptor) bool {
+	return strings.Contains(layer.MediaType, "foreign") || strings.Contains(layer.MediaType, "nondistributable")
+}
@@ -0,0 +1,435 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// ChecksumFile is the name of the checksum list Validate compares archives
+// against when it is next to them, in the format of sha256sum
+const ChecksumFile = "SHA256SUMS"
+
+// maxBufferedFile is the size up to which Validate keeps the content of
+// archived files, which covers manifests and image configs
+const maxBufferedFile = 1 << 20
+
+// Validation is the outcome of validating an archive
+type Validation struct {
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"`
+	Images int    `json:"images"`
+	// Compat is the compatibility profile the archive was checked against
+	Compat Compat `json:"compat,omitempty"`
+	// Checksum is set when the archive is listed in a SHA256SUMS file
+	// next to it and its checksum matches
+	Checksum bool     `json:"checksum_verified,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether no problem was found
+func (v *Validation) OK() bool {
+	return len(v.Problems) == 0
+}
+
+func (v *Validation) problemf(format string, args ...any) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks that an archive or OCI layout directory can be loaded,
+// without a docker daemon: the tar stream decompresses and parses, the
+// manifests manifest.json and index.json reference are present, blobs match
+// the digests they are named after, layers match the diff IDs of their image
+// config, and the archive matches its entry in a SHA256SUMS file next to it.
+func Validate(archivePath string) *Validation {
+	return ValidateCompat(archivePath, CompatDefault)
+}
+
+// ValidateCompat validates an archive like Validate and also checks that
+// it meets a compatibility profile
+func ValidateCompat(archivePath string, compat Compat) *Validation {
+	v := &Validation{Path: archivePath, Compat: compat}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		v.problemf("%v", err)
+		return v
+	}
+
+	var files archivedFiles
+	format := Format{Layout: LayoutOCI, Dir: true}
+	if info.IsDir() {
+		files, err = readLayoutFiles(archivePath)
+	} else {
+		var sum string
+		files, format.Compression, sum, err = readArchiveFiles(archivePath)
+		if err == nil {
+			v.checkChecksum(archivePath, sum)
+		}
+		format.Dir = false
+		if _, ok := files[dockerManifestFile]; ok {
+			format.Layout = LayoutDocker
+		}
+	}
+	if err != nil {
+		v.problemf("%v", err)
+		return v
+	}
+
+	_, hasManifest := files[dockerManifestFile]
+	_, hasIndex := files[ociIndexFile]
+	if !hasManifest && !hasIndex {
+		v.problemf("not an image archive: it has neither %s nor %s", dockerManifestFile, ociIndexFile)
+		return v
+	}
+	v.Format = format.String()
+
+	files.checkBlobs(v)
+	if hasManifest {
+		files.checkDocker(v)
+	}
+	if hasIndex {
+		files.checkOCI(v)
+	}
+	files.checkCompat(v, format, compat)
+	return v
+}
+
+// ArchivesIn returns the archives in dir: files named like docker or OCI
+// archives and subdirectories holding an OCI layout. A directory that is an
+// OCI layout itself is returned as is.
+func ArchivesIn(dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFile)); err == nil {
+		return []string{dir}, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, entry := range entries {
+		full := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if _, err := os.Stat(filepath.Join(full, ociIndexFile)); err == nil {
+				archives = append(archives, full)
+			}
+			continue
+		}
+		if format, err := FormatForPath(full); err == nil && !format.Dir {
+			archives = append(archives, full)
+		}
+	}
+	return archives, nil
+}
+
+// checkChecksum compares the sha256 of an archive file with its entry in
+// the SHA256SUMS file of its directory, if there is one
+func (v *Validation) checkChecksum(archivePath, sum string) {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(archivePath), ChecksumFile))
+	if err != nil {
+		return
+	}
+	name := filepath.Base(archivePath)
+	for _, line := range strings.Split(string(data), "\n") {
+		expected, listed, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || strings.TrimPrefix(strings.TrimSpace(listed), "*") != name {
+			continue
+		}
+		if !strings.EqualFold(expected, sum) {
+			v.problemf("checksum %s does not match %s in %s", sum, expected, ChecksumFile)
+			return
+		}
+		v.Checksum = true
+		return
+	}
+}
+
+// archivedFile is a file of an archive as Validate sees it
+type archivedFile struct {
+	size       int64
+	digest     string
+	compressed bool
+	// data is the content of files up to maxBufferedFile
+	data []byte
+	// link is the file a symbolic or hard link points to
+	link string
+}
+
+// archivedFiles are the files of an archive by their cleaned name
+type archivedFiles map[string]*archivedFile
+
+// lookup returns a file, following links
+func (f archivedFiles) lookup(name string) (*archivedFile, bool) {
+	name = cleanName(name)
+	for range 16 {
+		file, ok := f[name]
+		if !ok || file.link == "" {
+			return file, ok
+		}
+		name = file.link
+	}
+	return nil, false
+}
+
+// readArchiveFiles reads every file of a tar archive and returns them with
+// the compression and the sha256 of the archive file itself
+func readArchiveFiles(archivePath string) (archivedFiles, Compression, string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, Uncompressed, "", err
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	raw := io.TeeReader(file, sum)
+	stream, compression, err := decompress(raw)
+	if err != nil {
+		return nil, compression, "", err
+	}
+	defer stream.Close()
+
+	files := make(archivedFiles)
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, compression, "", readError(archivePath, compression, len(files), err)
+		}
+
+		name := cleanName(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if files[name], err = readFile(tr); err != nil {
+				return nil, compression, "", readError(archivePath, compression, len(files), err)
+			}
+		case tar.TypeSymlink:
+			files[name] = &archivedFile{link: cleanName(path.Join(path.Dir(name), hdr.Linkname))}
+		case tar.TypeLink:
+			files[name] = &archivedFile{link: cleanName(hdr.Linkname)}
+		}
+	}
+
+	// The checksum covers the whole file, including what follows the tar
+	// stream. The decompressor is finished first, as zstd reads the file
+	// from another goroutine.
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return nil, compression, "", readError(archivePath, compression, len(files), err)
+	}
+	if err := stream.Close(); err != nil {
+		return nil, compression, "", readError(archivePath, compression, len(files), err)
+	}
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return nil, compression, "", err
+	}
+	if len(files) == 0 {
+		return nil, compression, "", fmt.Errorf("%s is empty or not a tar archive", archivePath)
+	}
+	return files, compression, hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// readLayoutFiles reads the files of an OCI layout directory
+func readLayoutFiles(dir string) (archivedFiles, error) {
+	names, err := layoutFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(archivedFiles)
+	for _, name := range names {
+		file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name], err = readFile(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+	}
+	return files, nil
+}
+
+// readFile hashes a file and keeps its content when it is small
+func readFile(r io.Reader) (*archivedFile, error) {
+	hash := sha256.New()
+	buffered := bufio.NewReader(r)
+	compressed := detectCompression(buffered) != Uncompressed
+
+	var data limitedBuffer
+	size, err := io.Copy(io.MultiWriter(hash, &data), buffered)
+	if err != nil {
+		return nil, err
+	}
+	file := &archivedFile{size: size, digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), compressed: compressed}
+	if size <= maxBufferedFile {
+		file.data = data.Bytes()
+	}
+	return file, nil
+}
+
+// limitedBuffer keeps the first maxBufferedFile bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxBufferedFile + 1 - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// checkBlobs checks that every blob matches the digest it is named after
+func (f archivedFiles) checkBlobs(v *Validation) {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		encoded, ok := strings.CutPrefix(name, "blobs/sha256/")
+		file := f[name]
+		if !ok || file.link != "" {
+			continue
+		}
+		if file.digest != "sha256:"+encoded {
+			v.problemf("blob %s is corrupt: its content has digest %s", name, file.digest)
+		}
+	}
+}
+
+// checkDocker checks that the configs and layers manifest.json lists are
+// present and that the layers match the diff IDs of the image config
+func (f archivedFiles) checkDocker(v *Validation) {
+	listing, _ := f.lookup(dockerManifestFile)
+	var entries []dockerManifest
+	if err := json.Unmarshal(listing.data, &entries); err != nil {
+		v.problemf("invalid %s: %v", dockerManifestFile, err)
+		return
+	}
+	v.Images = len(entries)
+
+	for _, entry := range entries {
+		image := entry.Config
+		if len(entry.RepoTags) > 0 {
+			image = entry.RepoTags[0]
+		}
+
+		config, ok := f.lookup(entry.Config)
+		if !ok {
+			v.problemf("%s: config %s is missing", image, entry.Config)
+			continue
+		}
+		if encoded, ok := strings.CutSuffix(path.Base(cleanName(entry.Config)), ".json"); ok && len(encoded) == sha256.Size*2 && config.digest != "sha256:"+encoded {
+			v.problemf("%s: config %s is corrupt: its content has digest %s", image, entry.Config, config.digest)
+		}
+
+		var imageConfig struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if err := json.Unmarshal(config.data, &imageConfig); err != nil {
+			v.problemf("%s: invalid config %s: %v", image, entry.Config, err)
+			continue
+		}
+		diffIDs := imageConfig.RootFS.DiffIDs
+		if len(diffIDs) != len(entry.Layers) {
+			v.problemf("%s: %d layers are listed but the config has %d diff IDs", image, len(entry.Layers), len(diffIDs))
+		}
+
+		for i, name := range entry.Layers {
+			layer, ok := f.lookup(name)
+			if !ok {
+				v.problemf("%s: layer %s is missing", image, name)
+				continue
+			}
+			// Compressed layers are checked against their blob name only
+			if !layer.compressed && i < len(diffIDs) && layer.digest != diffIDs[i] {
+				v.problemf("%s: layer %s has digest %s, the config expects %s", image, name, layer.digest, diffIDs[i])
+			}
+		}
+	}
+}
+
+// checkOCI checks that every manifest, config and layer reachable from
+// index.json is present with the size its descriptor states
+func (f archivedFiles) checkOCI(v *Validation) {
+	listing, _ := f.lookup(ociIndexFile)
+	var index ociIndex
+	if err := json.Unmarshal(listing.data, &index); err != nil {
+		v.problemf("invalid %s: %v", ociIndexFile, err)
+		return
+	}
+	if v.Images == 0 {
+		v.Images = len(index.Manifests)
+	}
+
+	checked := make(map[string]bool)
+	var check func(desc registry.Descriptor, parent string)
+	check = func(desc registry.Descriptor, parent string) {
+		if checked[desc.Digest] {
+			return
+		}
+		checked[desc.Digest] = true
+
+		name, err := layoutDir("").blobPath(desc.Digest)
+		if err != nil {
+			v.problemf("%s: %v", parent, err)
+			return
+		}
+		blob, ok := f.lookup(filepath.ToSlash(name))
+		if !ok {
+			v.problemf("%s references %s, which is missing", parent, desc.Digest)
+			return
+		}
+		if blob.size != desc.Size {
+			v.problemf("%s: blob %s has %d bytes instead of %d", parent, desc.Digest, blob.size, desc.Size)
+			return
+		}
+
+		switch desc.MediaType {
+		case registry.MediaTypeOCIIndex, registry.MediaTypeManifestList:
+			var child ociIndex
+			if err := json.Unmarshal(blob.data, &child); err != nil {
+				v.problemf("invalid index %s: %v", desc.Digest, err)
+				return
+			}
+			for _, entry := range child.Manifests {
+				check(entry, "index "+desc.Digest)
+			}
+		case registry.MediaTypeOCIManifest, registry.MediaTypeManifest:
+			var manifest ociManifest
+			if err := json.Unmarshal(blob.data, &manifest); err != nil {
+				v.problemf("invalid manifest %s: %v", desc.Digest, err)
+				return
+			}
+			check(manifest.Config, "manifest "+desc.Digest)
+			for _, layer := range manifest.Layers {
+				check(layer, "manifest "+desc.Digest)
+			}
+		}
+	}
+	for _, desc := range index.Manifests {
+		check(desc, ociIndexFile)
+	}
+}
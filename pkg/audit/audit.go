@@ -0,0 +1,166 @@
+// Package audit keeps an append-only record of every image and manifest
+// list pushed, to answer who put a digest into a registry and when.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/version"
+)
+
+// Kinds of pushes
+const (
+	// KindImage is the image of a single platform
+	KindImage = "image"
+	// KindManifest is a manifest list or index
+	KindManifest = "manifest"
+)
+
+// Entry records a push. Time, User, Host, Version and Config are filled in
+// by Record.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	Version string    `json:"version"`
+	Kind    string    `json:"kind"`
+	// Config is the configuration file of the run and Task the name of the
+	// task, if it has one
+	Config   string `json:"config,omitempty"`
+	Task     string `json:"task,omitempty"`
+	Source   string `json:"source"`
+	Platform string `json:"platform,omitempty"`
+	// SourceDigest is the digest the source resolved to, when known
+	SourceDigest string `json:"source_digest,omitempty"`
+	// Archives are the archives pushed instead of pulling the source
+	Archives     []string `json:"archives,omitempty"`
+	Target       string   `json:"target"`
+	TargetDigest string   `json:"target_digest,omitempty"`
+}
+
+// Options selects where entries are written
+type Options struct {
+	// Path is the JSON lines file entries are appended to
+	Path string
+	// Syslog also sends every entry to the local syslog daemon
+	Syslog bool
+	// Required makes a failure to record a push fail the push
+	Required bool
+	// Config names the configuration file of the run
+	Config string
+}
+
+// Log appends entries to the audit file and syslog. It is safe for
+// concurrent use.
+type Log struct {
+	mu     sync.Mutex
+	file   *os.File
+	syslog syslogWriter
+	opts   Options
+	user   string
+	host   string
+}
+
+// syslogWriter sends a line to syslog
+type syslogWriter interface {
+	Info(line string) error
+	Close() error
+}
+
+// Open opens the audit file for appending, creating it if needed, and
+// connects to syslog if requested
+func Open(opts Options) (*Log, error) {
+	if opts.Path == "" && !opts.Syslog {
+		return nil, fmt.Errorf("an audit log file or syslog is required")
+	}
+	l := &Log{opts: opts, user: currentUser()}
+	l.host, _ = os.Hostname()
+
+	if opts.Path != "" {
+		file, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the audit log: %w", err)
+		}
+		l.file = file
+	}
+	if opts.Syslog {
+		writer, err := openSyslog()
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		l.syslog = writer
+	}
+	return l, nil
+}
+
+// Required reports whether a push fails when it can't be recorded
+func (l *Log) Required() bool {
+	return l.opts.Required
+}
+
+// Record appends an entry as a line of JSON. The file is synced before
+// Record returns, so a recorded push survives a crash.
+func (l *Log) Record(entry Entry) error {
+	entry.Time = time.Now().UTC()
+	entry.User = l.user
+	entry.Host = l.host
+	entry.Version = version.Version
+	entry.Config = l.opts.Config
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	if l.file != nil {
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			errs = append(errs, err)
+		} else if err := l.file.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if l.syslog != nil {
+		if err := l.syslog.Info(string(line)); err != nil {
+			errs = append(errs, fmt.Errorf("syslog: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the audit file and the syslog connection
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	if l.file != nil {
+		errs = append(errs, l.file.Close())
+		l.file = nil
+	}
+	if l.syslog != nil {
+		errs = append(errs, l.syslog.Close())
+		l.syslog = nil
+	}
+	return errors.Join(errs...)
+}
+
+// currentUser returns the name of the user running the tool, and the user
+// who ran sudo as well when run through it
+func currentUser() string {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && sudoUser != name {
+		name += " (sudo by " + sudoUser + ")"
+	}
+	return name
+}
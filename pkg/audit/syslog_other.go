@@ -0,0 +1,10 @@
+//go:build !unix
+
+package audit
+
+import "errors"
+
+// syslog is not available on this platform
+func openSyslog() (syslogWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
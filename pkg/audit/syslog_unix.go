@@ -0,0 +1,9 @@
+//go:build unix
+
+package audit
+
+import "log/syslog"
+
+func openSyslog() (syslogWriter, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "imgmigrate")
+}
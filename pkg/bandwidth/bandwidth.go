@@ -0,0 +1,177 @@
+// Package bandwidth limits the rate of the tool's own data streams. A
+// Limiter is a token bucket shared by every stream that uses it, so the
+// limit applies to their sum. Streams find their limiter in their context.
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxChunk is the most a stream reads or writes before waiting for tokens,
+// so streams sharing a limiter take turns
+const maxChunk = 32 * 1024
+
+// units maps rate suffixes to their size in bytes; decimal units are powers
+// of 1000 and binary ones powers of 1024
+var units = []struct {
+	suffix string
+	size   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseRate parses a rate in bytes per second such as 50MB/s, 512KiB/s or
+// 1G. A number without a unit is in bytes.
+func ParseRate(s string) (int64, error) {
+	rate, err := ParseSize(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth limit %q, use a rate such as 50MB/s", s)
+	}
+	return rate, nil
+}
+
+// ParseSize parses a size in bytes with the units of a rate, such as 200GB
+// or 512MiB. A number without a unit is in bytes.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	size := int64(1)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value, size = strings.TrimSpace(number), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 || int64(n*float64(size)) < 1 {
+		return 0, fmt.Errorf("invalid size %q, use a size such as 200GB", s)
+	}
+	return int64(n * float64(size)), nil
+}
+
+// Limiter is a token bucket holding up to one second of its rate. A nil
+// Limiter doesn't limit anything.
+type Limiter struct {
+	rate  float64
+	chunk int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter allowing rate bytes per second
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: float64(rate), chunk: int(min(rate, maxChunk)), tokens: float64(rate), last: time.Now()}
+}
+
+// Rate returns the rate of the limiter in bytes per second, or 0 for a nil
+// limiter
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// wait takes n tokens, waiting until the bucket has refilled enough or ctx
+// ends
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate) - float64(n)
+	l.last = now
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Writer returns w limited by l
+func (l *Limiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{ctx: ctx, l: l, w: w}
+}
+
+// Reader returns r limited by l
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, l: l, r: r}
+}
+
+type writer struct {
+	ctx context.Context
+	l   *Limiter
+	w   io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.l.chunk)]
+		if err := w.l.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type reader struct {
+	ctx context.Context
+	l   *Limiter
+	r   io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), r.l.chunk)])
+	if n > 0 {
+		if waitErr := r.l.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying l, for the streams started with it
+func NewContext(ctx context.Context, l *Limiter) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the limiter ctx carries, or nil
+func FromContext(ctx context.Context) *Limiter {
+	l, _ := ctx.Value(contextKey{}).(*Limiter)
+	return l
+}
@@ -0,0 +1,86 @@
+// Package budget caps the bytes a run transfers, pulled plus pushed, as the
+// progress events of the docker client count them. Work that no longer fits
+// is deferred to a later run instead of started.
+package budget
+
+import (
+	"sync"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+)
+
+// Budget tracks the bytes transferred against a limit. It is registered as
+// an event handler of the client.
+type Budget struct {
+	limit int64
+
+	mu sync.Mutex
+	// transfers holds the bytes of every pull and push so far, by stage,
+	// source and platform, and platforms the platforms they belong to
+	transfers map[string]int64
+	platforms map[string]bool
+}
+
+// New returns a budget of limit bytes
+func New(limit int64) *Budget {
+	return &Budget{limit: limit, transfers: make(map[string]int64), platforms: make(map[string]bool)}
+}
+
+// Limit returns the bytes the budget allows
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// HandleEvent records the bytes pulls and pushes report
+func (b *Budget) HandleEvent(event docker.Event) {
+	var stage, source, platform string
+	var bytes int64
+	switch e := event.(type) {
+	case docker.PlatformPullProgress:
+		stage, source, platform, bytes = docker.StagePull, e.Source, e.Platform, e.Bytes
+	case docker.PlatformPushProgress:
+		stage, source, platform, bytes = docker.StagePush, e.Source, e.Platform, e.Bytes
+	default:
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transfers[stage+" "+source+" "+platform] = bytes
+	b.platforms[source+" "+platform] = true
+}
+
+// Used returns the bytes transferred so far
+func (b *Budget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used()
+}
+
+func (b *Budget) used() int64 {
+	var used int64
+	for _, bytes := range b.transfers {
+		used += bytes
+	}
+	return used
+}
+
+// Allows reports whether another platform may start: the bytes used so far
+// and the mean a platform transferred still fit in the limit
+func (b *Budget) Allows() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	used := b.used()
+	var mean int64
+	if len(b.platforms) > 0 {
+		mean = used / int64(len(b.platforms))
+	}
+	return used+mean <= b.limit && used < b.limit
+}
+
+// Reset forgets the bytes transferred, for a new run
+func (b *Budget) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transfers = make(map[string]int64)
+	b.platforms = make(map[string]bool)
+}
@@ -0,0 +1,130 @@
+// Package cluster lists the images the pods of a Kubernetes cluster run,
+// through kubectl so every authentication method of kubeconfig works.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Options selects the cluster and namespaces to list
+type Options struct {
+	// Kubectl is the kubectl binary, "kubectl" by default
+	Kubectl    string
+	Kubeconfig string
+	Context    string
+	// Namespaces limits the listing, all namespaces when empty
+	Namespaces []string
+}
+
+// Image is an image reference as pod specs write it and where it runs
+type Image struct {
+	Reference string
+	// Namespaces are those of the pods using the image, sorted
+	Namespaces []string
+}
+
+// podList is the part of kubectl's pod list the images are taken from
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers          []container `json:"containers"`
+			InitContainers      []container `json:"initContainers"`
+			EphemeralContainers []container `json:"ephemeralContainers"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+type container struct {
+	Image string `json:"image"`
+}
+
+// Images returns the distinct image references of all containers, init
+// containers and ephemeral containers of the selected pods, sorted
+func Images(ctx context.Context, opts Options) ([]Image, error) {
+	var lists []podList
+	if len(opts.Namespaces) == 0 {
+		list, err := getPods(ctx, opts, "--all-namespaces")
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	for _, namespace := range opts.Namespaces {
+		list, err := getPods(ctx, opts, "--namespace", namespace)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	images := make(map[string]*Image)
+	for _, list := range lists {
+		for _, pod := range list.Items {
+			containers := append(append(pod.Spec.InitContainers, pod.Spec.Containers...), pod.Spec.EphemeralContainers...)
+			for _, c := range containers {
+				if c.Image == "" {
+					continue
+				}
+				image, ok := images[c.Image]
+				if !ok {
+					image = &Image{Reference: c.Image}
+					images[c.Image] = image
+				}
+				if !slices.Contains(image.Namespaces, pod.Metadata.Namespace) {
+					image.Namespaces = append(image.Namespaces, pod.Metadata.Namespace)
+				}
+			}
+		}
+	}
+
+	result := make([]Image, 0, len(images))
+	for _, image := range images {
+		sort.Strings(image.Namespaces)
+		result = append(result, *image)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Reference < result[j].Reference })
+	return result, nil
+}
+
+func getPods(ctx context.Context, opts Options, scope ...string) (podList, error) {
+	kubectl := opts.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+	var args []string
+	if opts.Kubeconfig != "" {
+		args = append(args, "--kubeconfig", opts.Kubeconfig)
+	}
+	if opts.Context != "" {
+		args = append(args, "--context", opts.Context)
+	}
+	args = append(args, "get", "pods", "--output", "json")
+	args = append(args, scope...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, kubectl, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return podList{}, fmt.Errorf("listing pods: %s", msg)
+		}
+		return podList{}, fmt.Errorf("listing pods: %v", err)
+	}
+
+	var list podList
+	if err := json.Unmarshal(stdout.Bytes(), &list); err != nil {
+		return podList{}, fmt.Errorf("parsing the pod list: %v", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// Aliases maps the short names teams write for images, a repository or a
+// path above repositories such as internal, to the reference prefix they
+// stand for, such as registry.old.corp/platform
+type Aliases map[string]string
+
+// Resolve returns ref with the alias of the longest name or path prefix of
+// its repository replaced, and the alias used; ref as it is and an empty
+// alias when none matches
+func (a Aliases) Resolve(ref string) (string, string) {
+	name, rest := splitName(ref)
+	alias := ""
+	for key := range a {
+		if (name == key || strings.HasPrefix(name, key+"/")) && len(key) > len(alias) {
+			alias = key
+		}
+	}
+	if alias == "" {
+		return ref, ""
+	}
+	return a[alias] + strings.TrimPrefix(name, alias) + rest, alias
+}
+
+// Validate checks that every alias is a repository path standing for a
+// valid reference prefix, and that no two aliases conflict: spellings of
+// the same name, or an alias resolving to a name another alias rewrites
+// again
+func (a Aliases) Validate() error {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []string
+	spelled := make(map[string]string)
+	for _, key := range keys {
+		value := a[key]
+		if name, rest := splitName(key); key == "" || rest != "" || name != strings.Trim(key, "/") {
+			errs = append(errs, fmt.Sprintf("alias %q must be a repository or a path above repositories, without a tag, digest or slashes around it", key))
+			continue
+		}
+		if named, err := reference.ParseNormalizedNamed(value); err != nil || !reference.IsNameOnly(named) {
+			errs = append(errs, fmt.Sprintf("alias %s stands for %q, which is not a repository or a path above repositories", key, value))
+			continue
+		}
+		if other, ok := spelled[strings.ToLower(key)]; ok {
+			errs = append(errs, fmt.Sprintf("aliases %s and %s spell the same name", other, key))
+		}
+		spelled[strings.ToLower(key)] = key
+		if _, again := a.Resolve(value); again != "" {
+			errs = append(errs, fmt.Sprintf("alias %s stands for %s, which alias %s rewrites again", key, value, again))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// splitName splits a reference into its name and its tag and digest
+func splitName(ref string) (string, string) {
+	name, rest := ref, ""
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, rest = ref[:i], ref[i:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, rest = name[:i], name[i:]+rest
+	}
+	return name, rest
+}
+
+// applyAliases resolves the aliases in the sources of the tasks, recording
+// those used
+func (c *Config) applyAliases() {
+	c.usedAliases = make(map[string]bool)
+	for i := range c.ImageTask {
+		task := &c.ImageTask[i]
+		if task.SourceFrom != "" || task.ReadsLayout() {
+			continue
+		}
+		var alias string
+		if task.Source, alias = c.Aliases.Resolve(task.Source); alias != "" {
+			c.usedAliases[alias] = true
+		}
+	}
+}
+
+// UnusedAliases returns the aliases no source of a task used
+func (c *Config) UnusedAliases() []string {
+	var unused []string
+	for key := range c.Aliases {
+		if !c.usedAliases[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"text/template"
+)
+
+// AnnotationData holds the task fields annotation templates can use, such
+// as {{.Source}} or {{.Timestamp}}
+type AnnotationData struct {
+	Name   string
+	Source string
+	Target string
+	// SourceDigest is the resolved digest of the source, if known
+	SourceDigest string
+	// Timestamp is the time the task ran, in RFC 3339 format and UTC
+	Timestamp string
+}
+
+// RenderAnnotations executes annotation value templates with data
+func RenderAnnotations(templates map[string]string, data AnnotationData) (map[string]string, error) {
+	rendered := make(map[string]string, len(templates))
+	for key, text := range templates {
+		tmpl, err := parseAnnotation(key, text)
+		if err != nil {
+			return nil, err
+		}
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf("annotation %s: %v", key, err)
+		}
+		rendered[key] = value.String()
+	}
+	return rendered, nil
+}
+
+// parseAnnotation parses the value template of an annotation
+func parseAnnotation(key, text string) (*template.Template, error) {
+	if key == "" {
+		return nil, fmt.Errorf("annotation with an empty key")
+	}
+	tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("annotation %s: %v", key, err)
+	}
+	return tmpl, nil
+}
+
+// taskAnnotations returns the annotations of the configuration with those
+// of a task added, which take precedence. Tasks that create no manifest
+// list in a registry only get their own.
+func (c *Config) taskAnnotations(task ImageTask) map[string]string {
+	if len(c.Annotations) == 0 || task.Target == "" || !task.CreateMultiArch {
+		return task.Annotations
+	}
+	merged := maps.Clone(c.Annotations)
+	maps.Copy(merged, task.Annotations)
+	return merged
+}
+
+// ValidateAnnotations checks that annotation templates parse and render
+// with the fields of AnnotationData
+func ValidateAnnotations(templates map[string]string) error {
+	_, err := RenderAnnotations(templates, AnnotationData{})
+	return err
+}
@@ -0,0 +1,18 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ArchiveMaxAge returns the parsed max_archive_age, or zero when unset
+func (c *Config) ArchiveMaxAge() (time.Duration, error) {
+	if c.MaxArchiveAge == "" {
+		return 0, nil
+	}
+	age, err := ParseDuration(c.MaxArchiveAge)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("invalid max_archive_age %q", c.MaxArchiveAge)
+	}
+	return age, nil
+}
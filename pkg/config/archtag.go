@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/distribution/reference"
+)
+
+// DefaultArchTag is the arch_tag template that names tags as they are
+// named without one: the target tag suffixed with the platform, such as
+// 1.25-linux-arm64-v8
+const DefaultArchTag = "{{.Tag}}-{{.OS}}-{{.Arch}}{{with .Variant}}-{{.}}{{end}}"
+
+// ArchTagData holds the fields arch_tag templates can use, such as
+// {{.Tag}}_{{.Arch}}
+type ArchTagData struct {
+	// Tag is the tag of the target, latest if it has none
+	Tag     string
+	OS      string
+	Arch    string
+	Variant string
+	// Platform is the platform joined with dashes, such as linux-arm64-v8
+	Platform string
+}
+
+// anchoredTag matches a whole tag
+var anchoredTag = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// Patterns of the fields of ArchTagData in the tags a template names
+var archTagFields = map[string]string{
+	"Tag":      `(.+)`,
+	"OS":       `(?:aix|android|darwin|dragonfly|freebsd|illumos|ios|js|linux|netbsd|openbsd|plan9|solaris|wasip1|windows)`,
+	"Arch":     `[a-z0-9]+`,
+	"Variant":  `v[0-9]+`,
+	"Platform": `[a-z0-9]+-[a-z0-9]+(?:-v[0-9]+)?`,
+}
+
+// ArchTag names the tags the platforms of a target are pushed under
+type ArchTag struct {
+	tmpl *template.Template
+	// patterns match the tags the template names, with and without a
+	// variant, capturing the tag of the target
+	patterns []*regexp.Regexp
+}
+
+// ParseArchTag parses an arch_tag template and checks that it names a
+// valid tag of its own for every platform and target tag. An empty template
+// keeps the names of targets without one, where the platform suffixes the
+// whole target.
+func ParseArchTag(text string) (*ArchTag, error) {
+	a := &ArchTag{}
+	if text != "" {
+		tmpl, err := template.New("arch_tag").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arch_tag: %v", err)
+		}
+		a.tmpl = tmpl
+	} else {
+		text = DefaultArchTag
+	}
+	// The patterns render the template with placeholders for the fields
+	for _, variant := range []string{"\x00Variant\x00", ""} {
+		data := ArchTagData{Tag: "\x00Tag\x00", OS: "\x00OS\x00", Arch: "\x00Arch\x00", Variant: variant, Platform: "\x00Platform\x00"}
+		var rendered strings.Builder
+		if err := template.Must(template.New("arch_tag").Parse(text)).Execute(&rendered, data); err != nil {
+			return nil, fmt.Errorf("invalid arch_tag: %v", err)
+		}
+		pattern := regexp.QuoteMeta(rendered.String())
+		for field, fieldPattern := range archTagFields {
+			pattern = strings.Replace(pattern, "\x00"+field+"\x00", fieldPattern, 1)
+			pattern = strings.ReplaceAll(pattern, "\x00"+field+"\x00", `.+`)
+		}
+		a.patterns = append(a.patterns, regexp.MustCompile(`^`+pattern+`$`))
+	}
+
+	if a.tmpl == nil {
+		return a, nil
+	}
+	amd64, err := a.tag("1.0", "linux/amd64")
+	if err != nil {
+		return nil, err
+	}
+	arm64, err := a.tag("1.0", "linux/arm64")
+	if err != nil {
+		return nil, err
+	}
+	other, err := a.tag("2.0", "linux/amd64")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case amd64 == arm64:
+		return nil, fmt.Errorf("arch_tag %q names the tags of every platform the same; use {{.Arch}} or {{.Platform}}", text)
+	case amd64 == other:
+		return nil, fmt.Errorf("arch_tag %q names the tags of every target tag the same; use {{.Tag}}", text)
+	case amd64 == "1.0":
+		return nil, fmt.Errorf("arch_tag %q names a platform after the target tag itself, which holds the manifest list", text)
+	}
+	return a, nil
+}
+
+// Target returns the reference a platform, given as os/arch[/variant], of
+// target is pushed under
+func (a *ArchTag) Target(target, platform string) (string, error) {
+	name, tag, tagged := target, "latest", false
+	if i := strings.LastIndex(target, ":"); i > strings.LastIndex(target, "/") {
+		name, tag, tagged = target[:i], target[i+1:], true
+	}
+	if a.tmpl == nil {
+		suffixed := target + "-" + strings.ReplaceAll(platform, "/", "-")
+		// The suffix can make the tag longer than tags may be
+		if suffix := tag + suffixed[len(target):]; tagged && !anchoredTag.MatchString(suffix) {
+			return "", fmt.Errorf("%s of %s is named %q, which is not a valid tag", platform, target, suffix)
+		}
+		return suffixed, nil
+	}
+	rendered, err := a.tag(tag, platform)
+	if err != nil {
+		return "", err
+	}
+	return name + ":" + rendered, nil
+}
+
+// tag renders the tag of a platform of a target tag
+func (a *ArchTag) tag(tag, platform string) (string, error) {
+	data := ArchTagData{Tag: tag, Platform: strings.ReplaceAll(platform, "/", "-")}
+	parts := strings.SplitN(platform, "/", 3)
+	data.OS = parts[0]
+	if len(parts) > 1 {
+		data.Arch = parts[1]
+	}
+	if len(parts) > 2 {
+		data.Variant = parts[2]
+	}
+	var rendered strings.Builder
+	if err := a.tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("arch_tag: %v", err)
+	}
+	if !anchoredTag.MatchString(rendered.String()) {
+		return "", fmt.Errorf("arch_tag names %s of %s %q, which is not a valid tag", platform, tag, rendered.String())
+	}
+	return rendered.String(), nil
+}
+
+// Base returns the target tag a tag named for one of its platforms was
+// named after, or false if the template doesn't name it
+func (a *ArchTag) Base(tag string) (string, bool) {
+	for _, pattern := range a.patterns {
+		if match := pattern.FindStringSubmatch(tag); match != nil && len(match) > 1 {
+			return match[1], true
+		}
+	}
+	return "", false
+}
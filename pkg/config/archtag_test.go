@@ -0,0 +1,68 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Fr000g/ImgMigrate/internal/fuzztest"
+	"github.com/distribution/reference"
+)
+
+// fuzzArchTags are the arch_tag templates the fuzz targets render. Base
+// is only checked for the default, as custom templates can name the tags
+// of different target tags and platforms alike, such as v7-x of linux/arm/v7
+// and of linux/amd64 with {{.Platform}}-{{.Tag}}.
+var fuzzArchTags = []string{"", DefaultArchTag, "{{.Tag}}_{{.Arch}}{{with .Variant}}_{{.}}{{end}}", "{{.Platform}}-{{.Tag}}", "{{.OS}}.{{.Arch}}.{{.Tag}}"}
+
+// FuzzArchTag checks that the tag a platform of a tagged target is pushed
+// under is a valid reference to the repository of the target, and that
+// the default template names the target tag it was named after
+func FuzzArchTag(f *testing.F) {
+	f.Add("registry.example.com/team/app", "1.25", uint8(0), uint8(0))
+	f.Add("nginx", "1.25-linux-amd64", uint8(3), uint8(1))
+	f.Add("host:5000/app", "v7-x", uint8(0), uint8(3))
+	f.Add("app", "A_very.long-tag", uint8(2), uint8(2))
+	f.Add("app", strings.Repeat("1", 120), uint8(4), uint8(0))
+	f.Fuzz(func(t *testing.T, repo, tag string, platformIndex, templateIndex uint8) {
+		target := repo + ":" + tag
+		named, err := reference.ParseNormalizedNamed(target)
+		if err != nil {
+			return
+		}
+		tagged, ok := named.(reference.NamedTagged)
+		if !ok || tagged.Tag() != tag {
+			return
+		}
+		platform := fuzztest.Platform(platformIndex)
+		text := fuzzArchTags[int(templateIndex)%len(fuzzArchTags)]
+		archTag, err := ParseArchTag(text)
+		if err != nil {
+			t.Fatalf("ParseArchTag(%q): %v", text, err)
+		}
+
+		pushed, err := archTag.Target(target, platform)
+		if err != nil {
+			return
+		}
+		parsed, err := reference.ParseNormalizedNamed(pushed)
+		if err != nil {
+			t.Fatalf("%s of %s is pushed as %q, which doesn't parse: %v", platform, target, pushed, err)
+		}
+		if parsed.Name() != named.Name() {
+			t.Fatalf("%s of %s is pushed to %s, not to %s", platform, target, parsed.Name(), named.Name())
+		}
+		pushedTag, ok := parsed.(reference.Tagged)
+		if !ok {
+			t.Fatalf("%s of %s is pushed as %q, without a tag", platform, target, pushed)
+		}
+		if pushedTag.Tag() == tag {
+			t.Errorf("%s of %s is pushed under the target tag itself", platform, target)
+		}
+		if text != "" && text != DefaultArchTag {
+			return
+		}
+		if base, ok := archTag.Base(pushedTag.Tag()); !ok || base != tag {
+			t.Errorf("Base(%q) = %q, %v, want %q", pushedTag.Tag(), base, ok, tag)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package config
+
+import "fmt"
+
+// linkSources sets the source of every task taking it from the target of
+// another task. Names that match no task are left to Validate to report.
+func (c *Config) linkSources() error {
+	for i := range c.ImageTask {
+		task := &c.ImageTask[i]
+		if task.SourceFrom == "" {
+			continue
+		}
+		from, ok := c.taskNamed(task.SourceFrom)
+		if !ok {
+			continue
+		}
+		// A printed configuration repeats the linked source
+		if task.Source != "" && task.Source != from.Target {
+			return fmt.Errorf("%s: source and source_from can't be combined", task.Label(i))
+		}
+		task.Source = from.Target
+	}
+	return nil
+}
+
+// taskNamed returns the only task of the given name
+func (c *Config) taskNamed(name string) (ImageTask, bool) {
+	var found []ImageTask
+	for _, task := range c.ImageTask {
+		if task.Name == name {
+			found = append(found, task)
+		}
+	}
+	if len(found) != 1 {
+		return ImageTask{}, false
+	}
+	return found[0], true
+}
+
+// validateSourceFrom checks that the task source_from names pushes a
+// single manifest to its target for t to copy
+func (c *Config) validateSourceFrom(t ImageTask) error {
+	if t.SourceFrom == "" {
+		return nil
+	}
+	if t.LocalOnly || len(t.Archives) > 0 {
+		return fmt.Errorf("source_from copies from a registry and can't be combined with local_only or archives")
+	}
+	from, ok := c.taskNamed(t.SourceFrom)
+	if !ok {
+		// Reported by dependencies
+		return nil
+	}
+	switch {
+	case from.Target == "":
+		return fmt.Errorf("source_from names task %q, which has no target", t.SourceFrom)
+	case !from.CreateMultiArch && !from.Verbatim && len(from.Archives) == 0:
+		return fmt.Errorf("source_from names task %q, which pushes no image to its target; it needs create_multi_arch, verbatim or archives", t.SourceFrom)
+	}
+	return nil
+}
@@ -3,64 +3,603 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 
-	"gopkg.in/yaml.v3"
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/provision"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
 )
 
 // Config represents the main configuration structure
 type Config struct {
-	Registry  *RegistryConfig `yaml:"registry,omitempty"`
-	ImageTask []ImageTask     `yaml:"images"`
+	Registry *RegistryConfig `yaml:"registry,omitempty" json:"registry,omitempty"`
+	Policy   *PolicyConfig   `yaml:"policy,omitempty" json:"policy,omitempty"`
+	// Aliases maps short names of sources to the references they stand
+	// for, resolved before anything else reads the sources
+	Aliases Aliases `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	// Mirrors lists pull-through mirrors by source registry host, tried in
+	// order before the registry itself
+	Mirrors map[string][]string `yaml:"mirrors,omitempty" json:"mirrors,omitempty"`
+	// TLS configures certificates by registry host, for registries other
+	// than the one in Registry
+	TLS map[string]TLSConfig `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// Proxies overrides the proxy environment variables for the tool's own
+	// registry API calls by registry host: a proxy URL, or "direct"
+	Proxies map[string]string `yaml:"proxies,omitempty" json:"proxies,omitempty"`
+	// SourceAuth sets credentials by source registry host, used instead of
+	// those stored by docker login
+	SourceAuth map[string]CredentialConfig `yaml:"source_auth,omitempty" json:"source_auth,omitempty"`
+	// Annotations are added to the manifest lists of every task, with
+	// values templated from the task fields; see AnnotationData
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+	// Timeouts limits the run, every task and every platform operation;
+	// the timeouts of a task override them
+	Timeouts Timeouts `yaml:"timeouts,omitempty" json:"timeouts,omitzero"`
+	// OutputRoot gives saving tasks without an output_dir a directory of
+	// their own below it; see ApplyOutputRoot
+	OutputRoot string `yaml:"output_root,omitempty" json:"output_root,omitempty"`
+	// SplitPlatforms saves the archives of every task in a directory per
+	// platform
+	SplitPlatforms bool `yaml:"split_platforms,omitempty" json:"split_platforms,omitempty"`
+	// ArchTag is the template of the tags platforms are pushed under, for
+	// the tasks without one of their own; see ArchTagData
+	ArchTag string `yaml:"arch_tag,omitempty" json:"arch_tag,omitempty"`
+	// MaxDaemonDisk pauses platform pulls while the docker daemon uses more
+	// disk than this size, such as 200GB; PruneDaemonDisk removes the local
+	// tags of earlier tasks then, and DaemonDiskTimeout is how long a
+	// platform waits before it fails. See DaemonDisk.
+	MaxDaemonDisk     string `yaml:"max_daemon_disk,omitempty" json:"max_daemon_disk,omitempty"`
+	PruneDaemonDisk   bool   `yaml:"prune_daemon_disk,omitempty" json:"prune_daemon_disk,omitempty"`
+	DaemonDiskTimeout string `yaml:"daemon_disk_timeout,omitempty" json:"daemon_disk_timeout,omitempty"`
+	// MaxArchiveAge is how long ago an archive may have been saved for
+	// push-archive to push it, such as 30d; see ArchiveMaxAge
+	MaxArchiveAge string      `yaml:"max_archive_age,omitempty" json:"max_archive_age,omitempty"`
+	ImageTask     []ImageTask `yaml:"images" json:"images"`
+
+	// usedAliases records the aliases the sources used
+	usedAliases map[string]bool
 }
 
 // RegistryConfig contains registry authentication information
 type RegistryConfig struct {
-	URL      string `yaml:"url"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Insecure bool   `yaml:"insecure,omitempty"`
+	URL              string `yaml:"url" json:"url"`
+	CredentialConfig `yaml:",inline"`
+	// AuthProvider obtains credentials at run time instead; "ecr" requests
+	// Amazon ECR tokens
+	AuthProvider string `yaml:"auth_provider,omitempty" json:"auth_provider,omitempty"`
+	// Region overrides the AWS region taken from an ECR registry host
+	Region   string `yaml:"region,omitempty" json:"region,omitempty"`
+	Insecure bool   `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+	// Proxy overrides the proxy environment variables for API calls to the
+	// registry: a proxy URL, or "direct"
+	Proxy     string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	TLSConfig `yaml:",inline"`
+	// Type integrates with the registry's own API: "harbor" checks that the
+	// projects of the targets exist before anything is pulled
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// APIURL is the base URL of that API, https://<url> by default
+	APIURL string `yaml:"api_url,omitempty" json:"api_url,omitempty"`
+	// APIAuth are the credentials for that API, those of the registry by
+	// default
+	APIAuth *CredentialConfig `yaml:"api_auth,omitempty" json:"api_auth,omitempty"`
+	// EnsureProject creates missing projects instead of failing
+	EnsureProject bool `yaml:"ensure_project,omitempty" json:"ensure_project,omitempty"`
+	// PublicProjects makes the created projects public
+	PublicProjects bool `yaml:"public_projects,omitempty" json:"public_projects,omitempty"`
+}
+
+// CredentialConfig contains the credentials of a registry
+type CredentialConfig struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+	// PasswordCmd is a shell command printing the password, run when the
+	// credentials are first needed
+	PasswordCmd string `yaml:"password_cmd,omitempty" json:"password_cmd,omitempty"`
+	// AuthCmd is a shell command printing a JSON auth document with
+	// username, password, token or identity_token
+	AuthCmd string `yaml:"auth_cmd,omitempty" json:"auth_cmd,omitempty"`
+	// Token is a registry bearer token used instead of a password
+	Token string `yaml:"token,omitempty" json:"token,omitempty"`
+	// IdentityToken is a refresh token issued by the registry at login
+	IdentityToken string `yaml:"identity_token,omitempty" json:"identity_token,omitempty"`
+}
+
+// validate checks that the password comes from one place only
+func (c CredentialConfig) validate() error {
+	sources := 0
+	for _, value := range []string{c.Password, c.PasswordCmd, c.AuthCmd} {
+		if value != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("set only one of password, password_cmd and auth_cmd")
+	}
+	if c.AuthCmd != "" && c.Username != "" {
+		return fmt.Errorf("auth_cmd provides the username; remove username")
+	}
+	if c.PasswordCmd != "" && c.Username == "" {
+		return fmt.Errorf("password_cmd requires a username")
+	}
+	return nil
+}
+
+// TLSConfig configures the certificates used for a registry's API
+type TLSConfig struct {
+	CAFile   string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	// SkipVerify accepts any certificate while still using HTTPS, unlike
+	// Insecure which also allows plain HTTP
+	SkipVerify bool `yaml:"skip_tls_verify,omitempty" json:"skip_tls_verify,omitempty"`
 }
 
 // ImageTask represents a single image processing task
 type ImageTask struct {
-	Source           string   `yaml:"source"`
-	Target           string   `yaml:"target,omitempty"`
-	Architectures    []string `yaml:"architectures,omitempty"`
-	AllArchitecture  bool     `yaml:"all_architectures,omitempty"`
+	Name             string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Source           string   `yaml:"source" json:"source"`
+	Target           string   `yaml:"target,omitempty" json:"target,omitempty"`
+	Architectures    []string `yaml:"architectures,omitempty" json:"architectures,omitempty"`
+	AllArchitecture  bool     `yaml:"all_architectures,omitempty" json:"all_architectures,omitempty"`
 	SaveOptions      `yaml:",inline"`
-	OperatingSystems []string `yaml:"operating_systems,omitempty"`
-	CreateMultiArch  bool     `yaml:"create_multi_arch,omitempty"`
+	OperatingSystems []string `yaml:"operating_systems,omitempty" json:"operating_systems,omitempty"`
+	CreateMultiArch  bool     `yaml:"create_multi_arch,omitempty" json:"create_multi_arch,omitempty"`
+	// LocalOnly uses the source image already in the local daemon instead
+	// of resolving and pulling it
+	LocalOnly bool `yaml:"local_only,omitempty" json:"local_only,omitempty"`
+	// Archives pushes the image of these saved archives to the target
+	// instead of pulling the source, which then only names the image. The
+	// archives of several platforms are merged into one multi-platform image.
+	Archives []string `yaml:"archives,omitempty" json:"archives,omitempty"`
+	// Annotations are added to the pushed manifest list, with values
+	// templated from the task fields; see AnnotationData
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+	// PreserveIndex carries the media type and annotations of the source
+	// index over to the pushed manifest list; Annotations add to or replace
+	// its annotations
+	PreserveIndex bool `yaml:"preserve_index,omitempty" json:"preserve_index,omitempty"`
+	// SourceOrder lists the platforms of the pushed manifest list in the
+	// order of the source index, pushing it only when all of them were
+	SourceOrder bool `yaml:"source_order,omitempty" json:"source_order,omitempty"`
+	// Verbatim copies all architectures byte for byte through the registry
+	// API, keeping the digest of the source index
+	Verbatim bool `yaml:"verbatim,omitempty" json:"verbatim,omitempty"`
+	// OnSourceChange is what to do when the source tag moves to another
+	// digest while the task runs: warn, the default, fail or retry
+	OnSourceChange string `yaml:"on_source_change,omitempty" json:"on_source_change,omitempty"`
+	// ArchTag is the template of the tags the platforms are pushed under
+	// in the target repository, such as {{.Tag}}_{{.Arch}}; see ArchTagData
+	ArchTag string `yaml:"arch_tag,omitempty" json:"arch_tag,omitempty"`
+	// Squash flattens the layers of each pushed image into one and
+	// Recompress, gzip or zstd, recompresses them; both change its digest
+	Squash     bool   `yaml:"squash,omitempty" json:"squash,omitempty"`
+	Recompress string `yaml:"recompress,omitempty" json:"recompress,omitempty"`
+	// Timeouts limits the task and its platform operations, overriding the
+	// top-level timeouts
+	Timeouts Timeouts `yaml:"timeouts,omitempty" json:"timeouts,omitzero"`
+	// BandwidthLimit limits the task to a bandwidth of its own, such as
+	// 20MB/s, instead of the limit set by --bandwidth-limit
+	BandwidthLimit string `yaml:"bandwidth_limit,omitempty" json:"bandwidth_limit,omitempty"`
+	// RequirePlatforms lists platforms, as os/arch[/variant] or
+	// arch[/variant], that must succeed for the task to succeed
+	RequirePlatforms []string `yaml:"require_platforms,omitempty" json:"require_platforms,omitempty"`
+	// MinSuccess is the number of platforms that must succeed, or "all";
+	// at least one by default
+	MinSuccess MinSuccess `yaml:"min_success,omitempty" json:"min_success,omitempty"`
+	// RollbackOnFailure undoes the pushes of the task to the target when
+	// it fails, deleting the tags it created and pointing those it moved
+	// back to their previous manifests
+	RollbackOnFailure bool `yaml:"rollback_on_failure,omitempty" json:"rollback_on_failure,omitempty"`
+	// SmokeTest checks that the target serves every pushed platform once
+	// the task pushed them, failing the task when it doesn't
+	SmokeTest *SmokeTestConfig `yaml:"smoke_test,omitempty" json:"smoke_test,omitempty"`
+	// Prune removes stale tags from the target repository after a successful sync
+	Prune *PruneConfig `yaml:"prune,omitempty" json:"prune,omitempty"`
+	// Priority runs the task before those of lower priorities; tasks of
+	// the same priority run in configuration order
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"`
+	// DependsOn names the tasks that must complete before this one, such
+	// as those pushing its source
+	DependsOn []string `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
+	// SourceFrom names the task whose target is the source of this one.
+	// The task runs after it, copying the digest it pushed.
+	SourceFrom string `yaml:"source_from,omitempty" json:"source_from,omitempty"`
+}
+
+// Label returns a human readable identifier for the task at the given index
+func (t ImageTask) Label(index int) string {
+	if t.Name != "" {
+		return fmt.Sprintf("task %d (%s)", index+1, t.Name)
+	}
+	return fmt.Sprintf("task %d (%s)", index+1, t.Source)
+}
+
+// Validate checks the settings of a single task. Config.Validate checks
+// them for every task, along with the tasks they refer to.
+func (t ImageTask) Validate() error {
+	if _, err := t.Timeouts.Parse(); err != nil {
+		return err
+	}
+	if t.Timeouts.Run != "" {
+		return fmt.Errorf("the run timeout is only accepted in the top-level timeouts section")
+	}
+	if strings.Contains(t.Target, "@") {
+		return fmt.Errorf("target %s is a digest reference; images are pushed under a tag, and the registry computes their digest", t.Target)
+	}
+	if t.ArchTag != "" {
+		if t.Target == "" {
+			return fmt.Errorf("arch_tag names the tags pushed to the target and requires a target")
+		}
+		if _, err := ParseArchTag(t.ArchTag); err != nil {
+			return err
+		}
+	}
+	if t.SplitPlatforms && t.BlobStore != "" {
+		return fmt.Errorf("split_platforms can't be combined with blob_store, which keeps all platforms in one store")
+	}
+	if t.BandwidthLimit != "" {
+		if _, err := bandwidth.ParseRate(t.BandwidthLimit); err != nil {
+			return err
+		}
+	}
+	if err := ValidateRepoTags(t.RepoTags); err != nil {
+		return err
+	}
+	if err := ValidateAnnotations(t.Annotations); err != nil {
+		return err
+	}
+	if len(t.Annotations) > 0 && (t.Target == "" || !t.CreateMultiArch) {
+		return fmt.Errorf("annotations are added to the pushed manifest list and require a target and create_multi_arch")
+	}
+	if t.PreserveIndex && (t.Target == "" || !t.CreateMultiArch) {
+		return fmt.Errorf("preserve_index applies to the pushed manifest list and requires a target and create_multi_arch")
+	}
+	if t.PreserveIndex && t.LocalOnly {
+		return fmt.Errorf("preserve_index reads the source index from its registry and can't be combined with local_only")
+	}
+	if t.SourceOrder && (t.Target == "" || !t.CreateMultiArch) {
+		return fmt.Errorf("source_order applies to the pushed manifest list and requires a target and create_multi_arch")
+	}
+	if t.BlobStore != "" && (t.Target != "" || t.Compress || t.Compression != "") {
+		return fmt.Errorf("blob_store holds saved images with their layers as docker saves them and can't be combined with a target or compression")
+	}
+	if err := ValidateCompression(t.Compress, t.Compression, t.CompressionPreference, t.CompressionSample); err != nil {
+		return err
+	}
+	if err := ValidateCompat(t.Compat, t.Compression, t.RepoTags, t.BlobStore); err != nil {
+		return err
+	}
+	if _, err := archive.ParseRecompression(t.Recompress); err != nil {
+		return err
+	}
+	if (t.Squash || t.Recompress != "") && t.Target == "" {
+		return fmt.Errorf("squash and recompress change the pushed images and require a target")
+	}
+	if err := t.validateVerbatim(); err != nil {
+		return err
+	}
+	if err := t.validateArchives(); err != nil {
+		return err
+	}
+	if err := t.validateLayout(); err != nil {
+		return err
+	}
+	if err := t.validateRequirements(); err != nil {
+		return err
+	}
+	if err := t.validateSmokeTest(); err != nil {
+		return err
+	}
+	if err := t.validateSourceChange(); err != nil {
+		return err
+	}
+	if t.RollbackOnFailure && t.Target == "" {
+		return fmt.Errorf("rollback_on_failure undoes pushes and requires a target")
+	}
+	if t.Prune == nil {
+		return nil
+	}
+	if t.Target == "" {
+		return fmt.Errorf("prune requires a target")
+	}
+	if _, err := t.Prune.RetentionPeriod(); err != nil {
+		return fmt.Errorf("invalid prune retention: %v", err)
+	}
+	return nil
+}
+
+// validateVerbatim checks that a verbatim task copies all architectures to
+// a target and changes nothing about them
+func (t ImageTask) validateVerbatim() error {
+	switch {
+	case !t.Verbatim:
+		return nil
+	case t.Target == "" || !t.AllArchitecture:
+		return fmt.Errorf("verbatim copies the source index as it is and requires a target and all_architectures")
+	case t.LocalOnly:
+		return fmt.Errorf("verbatim copies from the source registry and can't be combined with local_only")
+	case len(t.Annotations) > 0:
+		return fmt.Errorf("verbatim keeps the digest of the source index and can't be combined with annotations")
+	case t.Squash || t.Recompress != "":
+		return fmt.Errorf("verbatim keeps the digest of the source index and can't be combined with squash or recompress")
+	}
+	return nil
+}
+
+// validateArchives checks that a task pushing archives only pushes them
+func (t ImageTask) validateArchives() error {
+	switch {
+	case len(t.Archives) == 0:
+		return nil
+	case t.Target == "":
+		return fmt.Errorf("archives are pushed to a target, which is required")
+	case t.Save || t.BlobStore != "":
+		return fmt.Errorf("archives are pushed as they are and can't be saved again")
+	case len(t.Architectures) > 0:
+		return fmt.Errorf("archives are pushed with all the platforms they hold, remove architectures")
+	case t.LocalOnly || t.Verbatim || t.PreserveIndex || t.SourceOrder:
+		return fmt.Errorf("archives can't be combined with local_only, verbatim, preserve_index or source_order, which read the source")
+	}
+	return nil
+}
+
+// ReadsLayout reports whether the source of the task is a dir: or oci:
+// layout directory, read instead of pulled
+func (t ImageTask) ReadsLayout() bool {
+	return archive.IsSource(t.Source)
+}
+
+// validateLayout checks that a task reading a layout directory only uses
+// what can be done without pulling
+func (t ImageTask) validateLayout() error {
+	if !t.ReadsLayout() {
+		return nil
+	}
+	if _, err := archive.ParseSource(t.Source); err != nil {
+		return err
+	}
+	switch {
+	case len(t.Archives) > 0:
+		return fmt.Errorf("a dir: or oci: source can't be combined with archives")
+	case len(t.Architectures) > 0:
+		return fmt.Errorf("a dir: or oci: source is read with all the platforms it holds, remove architectures")
+	case t.LocalOnly || t.Verbatim || t.PreserveIndex || t.SourceOrder || t.OnSourceChange != "":
+		return fmt.Errorf("a dir: or oci: source can't be combined with local_only, verbatim, preserve_index, source_order or on_source_change, which read a registry")
+	case t.BlobStore != "":
+		return fmt.Errorf("a dir: or oci: source is saved to archives and can't be combined with blob_store")
+	case t.Compression == archive.Auto:
+		return fmt.Errorf("a dir: or oci: source is saved without docker save, whose stream the auto compression samples; use gzip or zstd")
+	}
+	return nil
 }
 
 // SaveOptions contains options for saving images
 type SaveOptions struct {
-	Save      bool   `yaml:"save,omitempty"`
-	OutputDir string `yaml:"output_dir,omitempty"`
-	Compress  bool   `yaml:"compress,omitempty"`
+	Save      bool   `yaml:"save,omitempty" json:"save,omitempty"`
+	OutputDir string `yaml:"output_dir,omitempty" json:"output_dir,omitempty"`
+	Compress  bool   `yaml:"compress,omitempty" json:"compress,omitempty"`
+	// Compression, gzip, zstd or auto, compresses saved archives; auto
+	// picks gzip or zstd for every archive from its first
+	// CompressionSample bytes, as CompressionPreference, ratio, speed or
+	// balanced, prefers
+	Compression           string `yaml:"compression,omitempty" json:"compression,omitempty"`
+	CompressionPreference string `yaml:"compression_preference,omitempty" json:"compression_preference,omitempty"`
+	CompressionSample     string `yaml:"compression_sample,omitempty" json:"compression_sample,omitempty"`
+	// RepoTags selects the names embedded in saved archives, one of the
+	// RepoTags constants; the default is RepoTagsSuffixed
+	RepoTags string `yaml:"repo_tags,omitempty" json:"repo_tags,omitempty"`
+	// BlobStore is an OCI layout directory saved images are added to
+	// instead of archives of their own, storing shared layers once
+	BlobStore string `yaml:"blob_store,omitempty" json:"blob_store,omitempty"`
+	// SplitPlatforms saves each platform in a directory of its own below
+	// OutputDir, such as linux-arm64-v8
+	SplitPlatforms bool `yaml:"split_platforms,omitempty" json:"split_platforms,omitempty"`
+	// Compat is the compatibility profile of saved archives, such as
+	// docker-legacy for hosts running Docker 17.x
+	Compat string `yaml:"compat,omitempty" json:"compat,omitempty"`
+}
+
+// Names saved archives can embed as the RepoTags of their image
+const (
+	// RepoTagsSuffixed embeds the architecture-suffixed tag, such as
+	// nginx:1.25-linux-amd64
+	RepoTagsSuffixed = "suffixed"
+	// RepoTagsOriginal embeds the source reference, such as nginx:1.25
+	RepoTagsOriginal = "original"
+	// RepoTagsBoth embeds both
+	RepoTagsBoth = "both"
+)
+
+// ValidateRepoTags checks a repo_tags value
+func ValidateRepoTags(mode string) error {
+	switch mode {
+	case "", RepoTagsSuffixed, RepoTagsOriginal, RepoTagsBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown repo tags mode %q, supported: %s, %s, %s", mode, RepoTagsSuffixed, RepoTagsOriginal, RepoTagsBoth)
+}
+
+// ValidateCompression checks the compression settings of saved archives
+func ValidateCompression(compress bool, compression, preference, sample string) error {
+	switch archive.Compression(compression) {
+	case "", archive.Gzip, archive.Zstd, archive.Auto:
+	default:
+		return fmt.Errorf("unknown compression %q, supported: gzip, zstd, auto", compression)
+	}
+	if compress && compression != "" && compression != string(archive.Gzip) {
+		return fmt.Errorf("compress uses gzip and can't be combined with the compression %s", compression)
+	}
+	if compression != archive.Auto && (preference != "" || sample != "") {
+		return fmt.Errorf("compression_preference and compression_sample apply to the auto compression")
+	}
+	if _, err := archive.ParsePreference(preference); err != nil {
+		return err
+	}
+	if sample != "" {
+		if _, err := bandwidth.ParseSize(sample); err != nil {
+			return fmt.Errorf("invalid compression_sample: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateCompat checks a compat value against the compression, repo_tags
+// and blob_store options it is combined with
+func ValidateCompat(compat, compression, repoTags, blobStore string) error {
+	profile, err := archive.ParseCompat(compat)
+	if err != nil || profile != archive.CompatDockerLegacy {
+		return err
+	}
+	if err := profile.CheckCompression(archive.Compression(compression)); err != nil {
+		return err
+	}
+	if repoTags == RepoTagsBoth {
+		return fmt.Errorf("%s archives carry a single RepoTag, use repo_tags %s or %s", profile, RepoTagsSuffixed, RepoTagsOriginal)
+	}
+	if blobStore != "" {
+		return fmt.Errorf("%s applies to docker archives and can't be combined with blob_store", profile)
+	}
+	return nil
 }
 
-// LoadConfig loads configuration from a YAML file
-func LoadConfig(configFile string) (*Config, error) {
-	data, err := os.ReadFile(configFile)
+// LoadConfig loads configuration from a YAML or JSON file or http(s) URL,
+// then applies key=value overrides; see Config.Set and SetRemote
+func LoadConfig(configFile string, overrides ...string) (*Config, error) {
+	data, err := readFile(configFile)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %v", err)
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	if err := checkPin(configFile, data); err != nil {
+		return nil, err
 	}
 
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := unmarshal(configFile, data, &config); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %v", err)
 	}
+	if err := config.ApplyOverrides(overrides); err != nil {
+		return nil, err
+	}
+	config.applyAliases()
+	if err := config.linkSources(); err != nil {
+		return nil, err
+	}
+	for i := range config.ImageTask {
+		task := &config.ImageTask[i]
+		task.Annotations = config.taskAnnotations(*task)
+		if task.ArchTag == "" && task.Target != "" {
+			task.ArchTag = config.ArchTag
+		}
+	}
 
 	return &config, nil
 }
 
-// GenerateSampleConfig generates a sample YAML configuration
+// Auth providers supported in the registry section
+const (
+	AuthProviderECR = "ecr"
+)
+
+// Validate checks the configuration before any task is executed
+func (c *Config) Validate() error {
+	if c.Registry != nil {
+		switch c.Registry.AuthProvider {
+		case "", AuthProviderECR:
+		default:
+			return fmt.Errorf("unknown registry auth_provider %q, supported: %s", c.Registry.AuthProvider, AuthProviderECR)
+		}
+		if c.Registry.AuthProvider != "" && (c.Registry.PasswordCmd != "" || c.Registry.AuthCmd != "") {
+			return fmt.Errorf("registry %s: auth_provider can't be combined with password_cmd or auth_cmd", c.Registry.URL)
+		}
+		if err := c.Registry.CredentialConfig.validate(); err != nil {
+			return fmt.Errorf("registry %s: %v", c.Registry.URL, err)
+		}
+		if c.Registry.Type != "" {
+			if err := provision.ValidateType(c.Registry.Type); err != nil {
+				return fmt.Errorf("registry %s: %v", c.Registry.URL, err)
+			}
+		} else if c.Registry.APIURL != "" || c.Registry.APIAuth != nil || c.Registry.EnsureProject || c.Registry.PublicProjects {
+			return fmt.Errorf("registry %s: api_url, api_auth, ensure_project and public_projects require a type", c.Registry.URL)
+		}
+		if c.Registry.APIAuth != nil {
+			if err := c.Registry.APIAuth.validate(); err != nil {
+				return fmt.Errorf("registry %s api_auth: %v", c.Registry.URL, err)
+			}
+		}
+	}
+
+	for host, creds := range c.SourceAuth {
+		if err := creds.validate(); err != nil {
+			return fmt.Errorf("source_auth %s: %v", host, err)
+		}
+	}
+
+	proxies := make(map[string]string, len(c.Proxies)+1)
+	for host, proxy := range c.Proxies {
+		proxies[host] = proxy
+	}
+	if c.Registry != nil && c.Registry.Proxy != "" {
+		proxies[c.Registry.URL] = c.Registry.Proxy
+	}
+	for host, proxy := range proxies {
+		if proxy == "" || proxy == registry.ProxyDirect {
+			continue
+		}
+		if _, err := registry.ParseProxy(proxy); err != nil {
+			return fmt.Errorf("proxy for %s: %v", host, err)
+		}
+	}
+
+	for host, mirrors := range c.Mirrors {
+		for _, mirror := range mirrors {
+			if mirror == "" || strings.Contains(mirror, "/") {
+				return fmt.Errorf("invalid mirror %q for %s: a mirror is a registry host", mirror, host)
+			}
+		}
+	}
+
+	if _, err := c.Timeouts.Parse(); err != nil {
+		return fmt.Errorf("timeouts: %v", err)
+	}
+	if _, err := ParseArchTag(c.ArchTag); err != nil {
+		return err
+	}
+	if _, err := c.DaemonDisk(); err != nil {
+		return err
+	}
+	if _, err := c.ArchiveMaxAge(); err != nil {
+		return err
+	}
+	if err := c.Aliases.Validate(); err != nil {
+		return fmt.Errorf("aliases: %v", err)
+	}
+
+	for i, task := range c.ImageTask {
+		if err := task.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", task.Label(i), err)
+		}
+		if err := c.validateSourceFrom(task); err != nil {
+			return fmt.Errorf("%s: %v", task.Label(i), err)
+		}
+	}
+
+	if _, err := c.dependencies(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GenerateSampleConfig generates a sample configuration, in JSON when
+// filename ends in .json and in YAML otherwise
 func GenerateSampleConfig(filename string) error {
 	config := Config{
 		Registry: &RegistryConfig{
-			URL:      "registry.example.com",
-			Username: "username",
-			Password: "password",
+			URL: "registry.example.com",
+			CredentialConfig: CredentialConfig{
+				Username: "username",
+				Password: "password",
+			},
 			Insecure: false,
 		},
 		ImageTask: []ImageTask{
@@ -88,7 +627,7 @@ func GenerateSampleConfig(filename string) error {
 		},
 	}
 
-	data, err := yaml.Marshal(config)
+	data, err := marshal(filename, config)
 	if err != nil {
 		return fmt.Errorf("error marshaling config: %v", err)
 	}
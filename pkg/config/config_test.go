@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadBoth loads the same configuration from YAML and from JSON, checking
+// both load and are valid
+func loadBoth(t *testing.T, yamlFile, jsonFile string, overrides ...string) (*Config, *Config) {
+	t.Helper()
+	fromYAML, err := LoadConfig(yamlFile, overrides...)
+	if err != nil {
+		t.Fatalf("LoadConfig(%s): %v", yamlFile, err)
+	}
+	fromJSON, err := LoadConfig(jsonFile, overrides...)
+	if err != nil {
+		t.Fatalf("LoadConfig(%s): %v", jsonFile, err)
+	}
+	for _, cfg := range []*Config{fromYAML, fromJSON} {
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+	}
+	return fromYAML, fromJSON
+}
+
+// TestLoadYAMLAndJSON loads a configuration setting every section from
+// YAML and from JSON, which must read the same
+func TestLoadYAMLAndJSON(t *testing.T) {
+	fromYAML, fromJSON := loadBoth(t, filepath.Join("testdata", "migrate.yaml"), filepath.Join("testdata", "migrate.json"))
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML and JSON read differently:\nYAML: %+v\nJSON: %+v", fromYAML, fromJSON)
+	}
+
+	// Embedded and inline settings, and values written as numbers or strings
+	cfg := fromJSON
+	if cfg.Registry.Username != "ci" || cfg.Registry.CAFile != "certs/ca.pem" || cfg.Registry.APIAuth.Password != "admin-secret" {
+		t.Errorf("registry = %+v", cfg.Registry)
+	}
+	if len(cfg.ImageTask) != 4 {
+		t.Fatalf("%d tasks, want 4", len(cfg.ImageTask))
+	}
+	if nginx := cfg.ImageTask[0]; nginx.MinSuccess != "2" || nginx.Prune.Retention != "90d" || nginx.ArchTag != cfg.ArchTag {
+		t.Errorf("task nginx = %+v", nginx)
+	}
+	if app := cfg.ImageTask[1]; app.MinSuccess != MinSuccessAll {
+		t.Errorf("min_success of app = %q, want %q", app.MinSuccess, MinSuccessAll)
+	}
+	if archive := cfg.ImageTask[2]; archive.Source != "registry.example.com/library/nginx:1.25" || !archive.Save || archive.Compression != "auto" || !archive.SplitPlatforms {
+		t.Errorf("task nginx-archive = %+v", archive)
+	}
+}
+
+// TestLoadJSONByContent reads a configuration without an extension as
+// JSON when it is an object
+func TestLoadJSONByContent(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "migrate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "migrate")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, fromJSON := loadBoth(t, filepath.Join("testdata", "migrate.yaml"), path)
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML and JSON without an extension read differently:\nYAML: %+v\nJSON: %+v", fromYAML, fromJSON)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	fromYAML, fromJSON := loadBoth(t, filepath.Join("testdata", "migrate.yaml"), filepath.Join("testdata", "migrate.json"),
+		"registry.url=registry.new.example.com", "images[1].target=registry.example.com/team/app:2.1")
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML and JSON read differently with overrides:\nYAML: %+v\nJSON: %+v", fromYAML, fromJSON)
+	}
+	if fromJSON.Registry.URL != "registry.new.example.com" || fromJSON.ImageTask[1].Target != "registry.example.com/team/app:2.1" {
+		t.Errorf("overrides not applied: registry %s, target %s", fromJSON.Registry.URL, fromJSON.ImageTask[1].Target)
+	}
+}
+
+// TestSampleConfig generates the sample configuration in both formats,
+// which must read the same
+func TestSampleConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile, jsonFile := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "config.json")
+	for _, path := range []string{yamlFile, jsonFile} {
+		if err := GenerateSampleConfig(path); err != nil {
+			t.Fatalf("GenerateSampleConfig(%s): %v", path, err)
+		}
+	}
+	fromYAML, fromJSON := loadBoth(t, yamlFile, jsonFile)
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML and JSON samples read differently:\nYAML: %+v\nJSON: %+v", fromYAML, fromJSON)
+	}
+}
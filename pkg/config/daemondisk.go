@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+)
+
+// DaemonDisk is the parsed limit of the disk usage of the docker daemon,
+// zero where unset
+type DaemonDisk struct {
+	Max     int64
+	Prune   bool
+	Timeout time.Duration
+}
+
+// DaemonDisk returns the parsed max_daemon_disk, prune_daemon_disk and
+// daemon_disk_timeout settings
+func (c *Config) DaemonDisk() (DaemonDisk, error) {
+	disk := DaemonDisk{Prune: c.PruneDaemonDisk}
+	if c.MaxDaemonDisk != "" {
+		max, err := bandwidth.ParseSize(c.MaxDaemonDisk)
+		if err != nil || max <= 0 {
+			return disk, fmt.Errorf("invalid max_daemon_disk %q", c.MaxDaemonDisk)
+		}
+		disk.Max = max
+	}
+	if c.DaemonDiskTimeout != "" {
+		timeout, err := ParseDuration(c.DaemonDiskTimeout)
+		if err != nil || timeout <= 0 {
+			return disk, fmt.Errorf("invalid daemon_disk_timeout %q", c.DaemonDiskTimeout)
+		}
+		disk.Timeout = timeout
+	}
+	if disk.Max == 0 && (disk.Prune || disk.Timeout > 0) {
+		return disk, fmt.Errorf("prune_daemon_disk and daemon_disk_timeout apply to max_daemon_disk")
+	}
+	return disk, nil
+}
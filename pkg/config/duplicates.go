@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+)
+
+// MergeDuplicates returns the tasks without those repeating an earlier
+// task exactly, with the same source, target and options, and a notice for
+// each task dropped. A task named differently from the one it repeats is
+// kept, as other tasks may name it. Targets are compared as given, so they
+// are normalized first.
+func MergeDuplicates(tasks []ImageTask) ([]ImageTask, []string) {
+	kept := tasks[:0:0]
+	labels := make([]string, 0, len(tasks))
+	var merged []string
+	for i, task := range tasks {
+		repeated := -1
+		for j, other := range kept {
+			if sameTask(task, other) {
+				repeated = j
+				break
+			}
+		}
+		if repeated < 0 {
+			kept = append(kept, task)
+			labels = append(labels, task.Label(i))
+			continue
+		}
+		if kept[repeated].Name == "" {
+			kept[repeated].Name = task.Name
+		}
+		merged = append(merged, fmt.Sprintf("%s repeats %s and was merged into it", task.Label(i), labels[repeated]))
+	}
+	return kept, merged
+}
+
+// sameTask reports whether two tasks do the same, ignoring a name only one
+// of them has
+func sameTask(a, b ImageTask) bool {
+	if a.Name == "" || b.Name == "" {
+		a.Name, b.Name = "", ""
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// TargetCollisions returns a line for every reference several tasks push:
+// their targets, the -allarch manifest lists and the tags arch_tag names
+// for the platforms they list. The platforms of tasks migrating all
+// architectures or pushing archives are only known once their source is
+// read, so only their targets and manifest lists are compared.
+func TargetCollisions(tasks []ImageTask) []string {
+	pushers := make(map[string][]int)
+	var order []string
+	claim := func(i int, ref string) {
+		key := imageref.Key(ref)
+		if len(pushers[key]) == 0 {
+			order = append(order, key)
+		}
+		if n := len(pushers[key]); n == 0 || pushers[key][n-1] != i {
+			pushers[key] = append(pushers[key], i)
+		}
+	}
+	for i, task := range tasks {
+		if task.Target == "" {
+			continue
+		}
+		claim(i, task.Target)
+		if task.CreateMultiArch && !task.Verbatim && len(task.Archives) == 0 && !task.ReadsLayout() {
+			claim(i, task.Target+"-allarch")
+		}
+		archTag, err := ParseArchTag(task.ArchTag)
+		if err != nil || task.AllArchitecture || len(task.Archives) > 0 || task.ReadsLayout() {
+			continue
+		}
+		for _, platform := range task.platforms() {
+			if ref, err := archTag.Target(task.Target, platform); err == nil {
+				claim(i, ref)
+			}
+		}
+	}
+
+	var collisions []string
+	for _, key := range order {
+		if len(pushers[key]) < 2 {
+			continue
+		}
+		labels := make([]string, len(pushers[key]))
+		for j, i := range pushers[key] {
+			labels[j] = tasks[i].Label(i)
+		}
+		collisions = append(collisions, fmt.Sprintf("%s is pushed by %s", key, strings.Join(labels, ", ")))
+	}
+	return collisions
+}
+
+// platforms returns the platforms a task lists, as os/arch[/variant], for
+// every operating system it selects, linux by default
+func (t ImageTask) platforms() []string {
+	systems := t.OperatingSystems
+	if len(systems) == 0 {
+		systems = []string{"linux"}
+	}
+	var platforms []string
+	for _, os := range systems {
+		for _, arch := range t.Architectures {
+			platforms = append(platforms, os+"/"+arch)
+		}
+	}
+	return platforms
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeDuplicates(t *testing.T) {
+	nginx := ImageTask{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25"}
+	named := func(task ImageTask, name string) ImageTask {
+		task.Name = name
+		return task
+	}
+	tests := []struct {
+		name       string
+		tasks      []ImageTask
+		want       []ImageTask
+		wantMerged []string
+	}{
+		{"distinct", []ImageTask{nginx, {Source: "redis:7", Target: "registry.example.com/redis:7"}},
+			[]ImageTask{nginx, {Source: "redis:7", Target: "registry.example.com/redis:7"}}, nil},
+		{"identical", []ImageTask{nginx, nginx},
+			[]ImageTask{nginx}, []string{"task 2 (nginx:1.25) repeats task 1 (nginx:1.25) and was merged into it"}},
+		{"name of the repeat kept", []ImageTask{nginx, named(nginx, "web")},
+			[]ImageTask{named(nginx, "web")}, []string{"task 2 (web) repeats task 1 (nginx:1.25) and was merged into it"}},
+		{"differently named", []ImageTask{named(nginx, "web"), named(nginx, "proxy")},
+			[]ImageTask{named(nginx, "web"), named(nginx, "proxy")}, nil},
+		{"other options", []ImageTask{nginx, {Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", Verbatim: true}},
+			[]ImageTask{nginx, {Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", Verbatim: true}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, merged := MergeDuplicates(tt.tasks)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tasks %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(merged, tt.wantMerged) {
+				t.Errorf("notices %q, want %q", merged, tt.wantMerged)
+			}
+		})
+	}
+}
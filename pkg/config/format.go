@@ -0,0 +1,39 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isJSON reports whether a config file or URL is JSON: by its .json
+// extension, or otherwise by content starting with an object
+func isJSON(path string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(filePath(path))) {
+	case ".json":
+		return true
+	case ".yaml", ".yml":
+		return false
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// unmarshal decodes a config file in either format
+func unmarshal(path string, data []byte, v any) error {
+	if isJSON(path, data) {
+		return json.Unmarshal(data, v)
+	}
+	return yaml.Unmarshal(data, v)
+}
+
+// marshal encodes v as JSON for .json paths and as YAML otherwise
+func marshal(path string, v any) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err := json.MarshalIndent(v, "", "  ")
+		return append(data, '\n'), err
+	}
+	return yaml.Marshal(v)
+}
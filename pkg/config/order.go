@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dependencies returns, for every task, the indexes of the tasks its
+// depends_on and source_from name. It rejects names that match no task, or several, and
+// tasks depending on themselves or on each other in a cycle.
+func (c *Config) dependencies() ([][]int, error) {
+	byName := make(map[string][]int)
+	for i, task := range c.ImageTask {
+		if task.Name != "" {
+			byName[task.Name] = append(byName[task.Name], i)
+		}
+	}
+
+	deps := make([][]int, len(c.ImageTask))
+	for i, task := range c.ImageTask {
+		names := task.DependsOn
+		if task.SourceFrom != "" {
+			names = append(names[:len(names):len(names)], task.SourceFrom)
+		}
+		for j, name := range names {
+			field := "depends_on"
+			if j == len(task.DependsOn) {
+				field = "source_from"
+			}
+			matches := byName[name]
+			switch {
+			case len(matches) == 0:
+				return nil, fmt.Errorf("%s: %s names %q, which no task is named", task.Label(i), field, name)
+			case len(matches) > 1:
+				return nil, fmt.Errorf("%s: %s names %q, which %d tasks are named", task.Label(i), field, name, len(matches))
+			case matches[0] == i:
+				return nil, fmt.Errorf("%s: %s names the task itself", task.Label(i), field)
+			}
+			deps[i] = append(deps[i], matches[0])
+		}
+	}
+
+	// Walk the dependencies depth first; reaching a task that is still
+	// being visited closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(deps))
+	var path []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			var names []string
+			for j := len(path) - 1; j >= 0; j-- {
+				names = append([]string{c.ImageTask[path[j]].Name}, names...)
+				if path[j] == i {
+					break
+				}
+			}
+			return fmt.Errorf("tasks depend on each other in a cycle: %s -> %s", strings.Join(names, " -> "), c.ImageTask[i].Name)
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range deps[i] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range deps {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+// ExecutionOrder returns the indexes of the tasks in the order they run:
+// every task after the tasks it depends on, higher priorities first, and in
+// configuration order within a priority. A task runs with the highest
+// priority of the tasks depending on it, so its dependents aren't held back.
+func (c *Config) ExecutionOrder() ([]int, error) {
+	deps, err := c.dependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	// Raise the priority of dependencies until it settles; there are no
+	// cycles, so every chain ends
+	priority := make([]int, len(c.ImageTask))
+	for i, task := range c.ImageTask {
+		priority[i] = task.Priority
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range deps {
+			for _, dep := range deps[i] {
+				if priority[dep] < priority[i] {
+					priority[dep] = priority[i]
+					changed = true
+				}
+			}
+		}
+	}
+
+	// Repeatedly pick the first task of the highest priority whose
+	// dependencies all ran
+	order := make([]int, 0, len(c.ImageTask))
+	done := make([]bool, len(c.ImageTask))
+	for len(order) < len(c.ImageTask) {
+		next := -1
+		for i := range c.ImageTask {
+			if done[i] || !allDone(deps[i], done) {
+				continue
+			}
+			if next < 0 || priority[i] > priority[next] {
+				next = i
+			}
+		}
+		done[next] = true
+		order = append(order, next)
+	}
+	return order, nil
+}
+
+// allDone reports whether every task of indexes is done
+func allDone(indexes []int, done []bool) bool {
+	for _, i := range indexes {
+		if !done[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Ordered reports whether any task sets a priority or dependencies, which
+// can make the execution order differ from the configuration order
+func (c *Config) Ordered() bool {
+	for _, task := range c.ImageTask {
+		if task.Priority != 0 || len(task.DependsOn) > 0 || task.SourceFrom != "" {
+			return true
+		}
+	}
+	return false
+}
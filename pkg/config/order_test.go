@@ -0,0 +1,81 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecutionOrder(t *testing.T) {
+	task := func(name string, priority int, dependsOn ...string) ImageTask {
+		return ImageTask{Name: name, Source: name + ":1.0", Priority: priority, DependsOn: dependsOn}
+	}
+	from := func(name, sourceFrom string) ImageTask {
+		return ImageTask{Name: name, SourceFrom: sourceFrom}
+	}
+	tests := []struct {
+		name  string
+		tasks []ImageTask
+		// want holds the names of the tasks in execution order
+		want    []string
+		wantErr string
+	}{
+		{"configuration order", []ImageTask{task("a", 0), task("b", 0), task("c", 0)}, []string{"a", "b", "c"}, ""},
+		{"linear chain", []ImageTask{task("c", 0, "b"), task("b", 0, "a"), task("a", 0)}, []string{"a", "b", "c"}, ""},
+		{"diamond", []ImageTask{task("d", 0, "b", "c"), task("b", 0, "a"), task("c", 0, "a"), task("a", 0)}, []string{"a", "b", "c", "d"}, ""},
+		{"source_from", []ImageTask{from("mirror", "base"), task("base", 0)}, []string{"base", "mirror"}, ""},
+		{"priorities", []ImageTask{task("a", 0), task("b", 5), task("c", 10)}, []string{"c", "b", "a"}, ""},
+		{"priority inherited", []ImageTask{task("a", 0), task("b", 0), task("c", 10, "a")}, []string{"a", "c", "b"}, ""},
+		{"priority inherited along a chain", []ImageTask{task("x", 5), task("a", 0), task("b", 0, "a"), task("c", 10, "b")}, []string{"a", "b", "c", "x"}, ""},
+		{"self-cycle", []ImageTask{task("a", 0), task("b", 0, "b")}, nil, "task 2 (b): depends_on names the task itself"},
+		{"source_from itself", []ImageTask{from("a", "a")}, nil, "task 1 (a): source_from names the task itself"},
+		{"two-task cycle", []ImageTask{task("a", 0, "b"), task("b", 0, "a")}, nil, "a cycle: a -> b -> a"},
+		{"longer cycle", []ImageTask{task("start", 0, "a"), task("a", 0, "b"), task("b", 0, "c"), task("c", 0, "a")}, nil, "a cycle: a -> b -> c -> a"},
+		{"cycle through source_from", []ImageTask{from("a", "b"), task("b", 0, "a")}, nil, "a cycle: a -> b -> a"},
+		{"unknown depends_on", []ImageTask{task("a", 0, "missing")}, nil, `task 1 (a): depends_on names "missing", which no task is named`},
+		{"unknown source_from", []ImageTask{from("a", "missing")}, nil, `task 1 (a): source_from names "missing", which no task is named`},
+		{"ambiguous name", []ImageTask{task("base", 0), task("base", 0), task("app", 0, "base")}, nil, `task 3 (app): depends_on names "base", which 2 tasks are named`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{ImageTask: tt.tasks}
+			order, err := c.ExecutionOrder()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ExecutionOrder() = %v, %v, want an error containing %q", order, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExecutionOrder(): %v", err)
+			}
+			got := make([]string, len(order))
+			for i, index := range order {
+				got[i] = tt.tasks[index].Name
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExecutionOrder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []ImageTask
+		want  bool
+	}{
+		{"plain", []ImageTask{{Name: "a"}, {Name: "b"}}, false},
+		{"priority", []ImageTask{{Name: "a"}, {Name: "b", Priority: 1}}, true},
+		{"depends_on", []ImageTask{{Name: "a"}, {Name: "b", DependsOn: []string{"a"}}}, true},
+		{"source_from", []ImageTask{{Name: "a"}, {Name: "b", SourceFrom: "a"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (&Config{ImageTask: tt.tasks}).Ordered(); got != tt.want {
+				t.Errorf("Ordered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/distribution/reference"
+)
+
+// ApplyOutputRoot gives every saving task without an output_dir or
+// blob_store its own directory below OutputRoot, named after the task or,
+// for a task without a name, its source repository or the directory of its
+// dir: or oci: source, and passes
+// SplitPlatforms on to the tasks. It fails when tasks of different names
+// or repositories would share a directory.
+func (c *Config) ApplyOutputRoot() error {
+	owners := make(map[string]string)
+	for i := range c.ImageTask {
+		task := &c.ImageTask[i]
+		if c.SplitPlatforms && task.BlobStore == "" {
+			task.SplitPlatforms = true
+		}
+		if c.OutputRoot == "" || task.Target != "" || !task.Save || task.OutputDir != "" || task.BlobStore != "" {
+			continue
+		}
+
+		name, owner := task.Name, "task "+task.Name
+		if name == "" && task.ReadsLayout() {
+			source, err := archive.ParseSource(task.Source)
+			if err != nil {
+				return fmt.Errorf("%s: %v", task.Label(i), err)
+			}
+			name, owner = filepath.Base(source.Path), "source "+task.Source
+		} else if name == "" {
+			named, err := imageref.Parse(task.Source)
+			if err != nil {
+				return fmt.Errorf("%s: %v", task.Label(i), err)
+			}
+			name, owner = reference.FamiliarName(named), "repository "+named.Name()
+		}
+		dir := filepath.Join(c.OutputRoot, dirName(name))
+		if other, taken := owners[dir]; taken && other != owner {
+			return fmt.Errorf("%s: its output directory %s is also that of %s; give the tasks distinct names", task.Label(i), dir, other)
+		}
+		owners[dir] = owner
+		task.OutputDir = dir
+	}
+	return nil
+}
+
+// dirName turns a task name or repository into a directory name: path
+// separators become dashes and other characters unsafe in file names
+// underscores
+func dirName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		case r == '/':
+			return '-'
+		}
+		return '_'
+	}, name)
+	if strings.Trim(name, ".") == "" {
+		name = strings.ReplaceAll(name, ".", "_")
+	}
+	return name
+}
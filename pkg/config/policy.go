@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/distribution/reference"
+)
+
+// PolicyConfig restricts which registries tasks may pull from and push to
+type PolicyConfig struct {
+	AllowedSourceRegistries  []string `yaml:"allowed_source_registries,omitempty" json:"allowed_source_registries,omitempty"`
+	AllowedTargetRegistries  []string `yaml:"allowed_target_registries,omitempty" json:"allowed_target_registries,omitempty"`
+	DenyLatestTargets        bool     `yaml:"deny_latest_targets,omitempty" json:"deny_latest_targets,omitempty"`
+	RequireExplicitSourceTag bool     `yaml:"require_explicit_source_tag,omitempty" json:"require_explicit_source_tag,omitempty"`
+}
+
+// LoadPolicy loads a standalone policy from a YAML or JSON file or URL. The file may either
+// contain the policy fields at the top level or nested under a policy: key,
+// so a regular config file can also be used as a central policy.
+func LoadPolicy(policyFile string) (*PolicyConfig, error) {
+	data, err := readFile(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy file: %w", err)
+	}
+
+	var wrapped struct {
+		Policy *PolicyConfig `yaml:"policy" json:"policy"`
+	}
+	if err := unmarshal(policyFile, data, &wrapped); err != nil {
+		return nil, fmt.Errorf("error unmarshaling policy: %v", err)
+	}
+	if wrapped.Policy != nil {
+		return wrapped.Policy, nil
+	}
+
+	var policy PolicyConfig
+	if err := unmarshal(policyFile, data, &policy); err != nil {
+		return nil, fmt.Errorf("error unmarshaling policy: %v", err)
+	}
+
+	return &policy, nil
+}
+
+// Check evaluates every task against the policy and returns all violations
+func (p *PolicyConfig) Check(tasks []ImageTask) error {
+	if p == nil {
+		return nil
+	}
+
+	var errs []error
+	for i, task := range tasks {
+		if err := p.CheckTask(task); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", task.Label(i), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckTask evaluates a single task against the policy
+func (p *PolicyConfig) CheckTask(task ImageTask) error {
+	if p == nil {
+		return nil
+	}
+
+	// A dir: or oci: source is read from disk, not from a registry
+	if !task.ReadsLayout() {
+		source, err := reference.ParseNormalizedNamed(task.Source)
+		if err != nil {
+			return fmt.Errorf("invalid source reference %q: %v", task.Source, err)
+		}
+
+		if p.RequireExplicitSourceTag {
+			if _, ok := source.(reference.Tagged); !ok {
+				if _, ok := source.(reference.Digested); !ok {
+					return fmt.Errorf("source %q must specify an explicit tag or digest", task.Source)
+				}
+			}
+		}
+
+		if !matchRegistry(p.AllowedSourceRegistries, reference.Domain(source)) {
+			return fmt.Errorf("source registry %s is not in allowed_source_registries", reference.Domain(source))
+		}
+	}
+
+	if task.Target == "" {
+		return nil
+	}
+	return p.CheckTarget(task.Target)
+}
+
+// CheckTarget evaluates an image pushed to target against the policy
+func (p *PolicyConfig) CheckTarget(target string) error {
+	if p == nil {
+		return nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(target)
+	if err != nil {
+		return fmt.Errorf("invalid target reference %q: %v", target, err)
+	}
+
+	if !matchRegistry(p.AllowedTargetRegistries, reference.Domain(named)) {
+		return fmt.Errorf("target registry %s is not in allowed_target_registries", reference.Domain(named))
+	}
+
+	if p.DenyLatestTargets {
+		tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+		if ok && tagged.Tag() == "latest" {
+			return fmt.Errorf("target %q resolves to the :latest tag, which is denied by policy", target)
+		}
+	}
+
+	return nil
+}
+
+// matchRegistry reports whether host matches one of the glob patterns.
+// An empty pattern list allows every registry.
+func matchRegistry(patterns []string, host string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+
+	return false
+}
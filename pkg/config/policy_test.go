@@ -0,0 +1,69 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheckTask(t *testing.T) {
+	policy := &PolicyConfig{
+		AllowedSourceRegistries:  []string{"docker.io", "*.example.com"},
+		AllowedTargetRegistries:  []string{"registry.example.com", "harbor-*.internal"},
+		DenyLatestTargets:        true,
+		RequireExplicitSourceTag: true,
+	}
+	tests := []struct {
+		name   string
+		policy *PolicyConfig
+		task   ImageTask
+		// wantErr is part of the violation, empty if the task is allowed
+		wantErr string
+	}{
+		{"allowed", policy, ImageTask{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25"}, ""},
+		{"source glob", policy, ImageTask{Source: "ghcr.example.com/team/app:1.0", Target: "registry.example.com/app:1.0"}, ""},
+		{"target glob", policy, ImageTask{Source: "nginx:1.25", Target: "harbor-eu.internal/nginx:1.25"}, ""},
+		{"source digest", policy, ImageTask{Source: "nginx@sha256:" + strings.Repeat("a", 64), Target: "registry.example.com/nginx:1.25"}, ""},
+		{"no target", policy, ImageTask{Source: "nginx:1.25"}, ""},
+		{"layout source", policy, ImageTask{Source: "oci:layouts/nginx", Target: "registry.example.com/nginx:1.25"}, ""},
+		{"source registry denied", policy, ImageTask{Source: "quay.io/team/app:1.0", Target: "registry.example.com/app:1.0"}, "source registry quay.io"},
+		{"glob matches no subdomain", policy, ImageTask{Source: "nginx:1.25", Target: "harbor-eu.internal.example.org/nginx:1.25"}, "target registry harbor-eu.internal.example.org"},
+		{"target registry denied", policy, ImageTask{Source: "nginx:1.25", Target: "docker.io/team/nginx:1.25"}, "target registry docker.io"},
+		{"latest target", policy, ImageTask{Source: "nginx:1.25", Target: "registry.example.com/nginx:latest"}, ":latest tag"},
+		{"untagged target", policy, ImageTask{Source: "nginx:1.25", Target: "registry.example.com/nginx"}, ":latest tag"},
+		{"untagged source", policy, ImageTask{Source: "nginx", Target: "registry.example.com/nginx:1.25"}, "explicit tag or digest"},
+		{"uppercase target", policy, ImageTask{Source: "nginx:1.25", Target: "registry.example.com/Team/nginx:1.25"}, "invalid target reference"},
+		{"latest allowed", &PolicyConfig{AllowedTargetRegistries: []string{"registry.example.com"}}, ImageTask{Source: "nginx", Target: "registry.example.com/nginx"}, ""},
+		{"no policy", nil, ImageTask{Source: "nginx", Target: "docker.io/nginx"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckTask(tt.task)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckTask(%+v): %v", tt.task, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckTask(%+v) = %v, want an error containing %q", tt.task, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestPolicyCheck reports the violations of every task, labelled
+func TestPolicyCheck(t *testing.T) {
+	policy := &PolicyConfig{AllowedTargetRegistries: []string{"registry.example.com"}, DenyLatestTargets: true}
+	err := policy.Check([]ImageTask{
+		{Name: "web", Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25"},
+		{Name: "cache", Source: "redis:7", Target: "docker.io/team/redis:7"},
+		{Source: "alpine:3.20", Target: "registry.example.com/alpine"},
+	})
+	if err == nil {
+		t.Fatal("Check returned no violations")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "task 2 (cache): ") || !strings.HasPrefix(lines[1], "task 3 (alpine:3.20): ") {
+		t.Errorf("violations %q, want those of tasks cache and 3", lines)
+	}
+}
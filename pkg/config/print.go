@@ -0,0 +1,37 @@
+package config
+
+import "gopkg.in/yaml.v3"
+
+// masked replaces secrets in printed configurations
+const masked = "********"
+
+// MarshalRedacted returns the configuration as YAML with passwords and
+// tokens masked
+func (c *Config) MarshalRedacted() ([]byte, error) {
+	out := *c
+	if c.Registry != nil {
+		registry := *c.Registry
+		registry.CredentialConfig = registry.CredentialConfig.redacted()
+		if registry.APIAuth != nil {
+			apiAuth := registry.APIAuth.redacted()
+			registry.APIAuth = &apiAuth
+		}
+		out.Registry = &registry
+	}
+	if c.SourceAuth != nil {
+		out.SourceAuth = make(map[string]CredentialConfig, len(c.SourceAuth))
+		for host, creds := range c.SourceAuth {
+			out.SourceAuth[host] = creds.redacted()
+		}
+	}
+	return yaml.Marshal(&out)
+}
+
+func (c CredentialConfig) redacted() CredentialConfig {
+	for _, secret := range []*string{&c.Password, &c.Token, &c.IdentityToken} {
+		if *secret != "" {
+			*secret = masked
+		}
+	}
+	return c
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneConfig enables deleting stale tags from the target repository of a
+// task after it has been mirrored successfully. Only tags matching one of
+// TagPatterns are considered to have been created by the tool.
+type PruneConfig struct {
+	// TagPatterns are glob patterns of the tags the tool mirrors into the
+	// target repository
+	TagPatterns []string `yaml:"tag_patterns" json:"tag_patterns"`
+	// Retention removes matching tags whose image is older than this, e.g.
+	// 90d or 720h. Tags no longer present upstream are always removed.
+	Retention string `yaml:"retention,omitempty" json:"retention,omitempty"`
+}
+
+// RetentionPeriod returns the parsed retention, or zero when none is set
+func (p *PruneConfig) RetentionPeriod() (time.Duration, error) {
+	if p == nil || p.Retention == "" {
+		return 0, nil
+	}
+	return ParseDuration(p.Retention)
+}
+
+// ParseDuration parses a Go duration that may also use a d suffix for days
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// RemoteOptions configures how configuration and policy files given as
+// http(s) URLs are fetched
+type RemoteOptions struct {
+	// Username and Password authenticate with basic auth, Token with a
+	// bearer token instead
+	Username string
+	Password string
+	Token    string
+	// Timeout limits each fetch, DefaultRemoteTimeout when zero
+	Timeout time.Duration
+	// SHA256 is the expected hex digest of the configuration file, local or
+	// remote; a file with other content is refused
+	SHA256 string
+}
+
+// DefaultRemoteTimeout limits fetching a configuration file
+const DefaultRemoteTimeout = 30 * time.Second
+
+// maxRemoteSize limits the size of a fetched file
+const maxRemoteSize = 16 << 20
+
+var remote RemoteOptions
+
+// SetRemote sets the options used to fetch files given as URLs
+func SetRemote(opts RemoteOptions) {
+	remote = opts
+}
+
+// IsURL reports whether path is an http or https URL rather than a file
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// readFile reads a local file or fetches an http(s) URL. Fetch failures are
+// categorized with errdefs so they can be told apart from bad content.
+func readFile(path string) ([]byte, error) {
+	if !IsURL(path) {
+		return os.ReadFile(path)
+	}
+
+	timeout := remote.Timeout
+	if timeout == 0 {
+		timeout = DefaultRemoteTimeout
+	}
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case remote.Token != "":
+		req.Header.Set("Authorization", "Bearer "+remote.Token)
+	case remote.Username != "":
+		req.SetBasicAuth(remote.Username, remote.Password)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fetchError(path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errdefs.FromHTTPResponse(resp, fmt.Errorf("fetching %s: %s", redactURL(path), resp.Status))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return nil, fetchError(path, err)
+	}
+	if len(data) > maxRemoteSize {
+		return nil, fmt.Errorf("fetching %s: larger than %d MiB", redactURL(path), maxRemoteSize>>20)
+	}
+	return data, nil
+}
+
+func fetchError(path string, err error) error {
+	var netErr net.Error
+	timeout := errors.As(err, &netErr) && netErr.Timeout()
+	// The URL is already part of the message
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	err = fmt.Errorf("fetching %s: %v", redactURL(path), err)
+	if timeout {
+		return &errdefs.TimeoutError{Err: err}
+	}
+	return &errdefs.NetworkError{Err: err}
+}
+
+// checkPin verifies data against RemoteOptions.SHA256
+func checkPin(path string, data []byte) error {
+	if remote.SHA256 == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, strings.TrimPrefix(remote.SHA256, "sha256:")) {
+		return fmt.Errorf("%s has sha256 %s, expected %s", redactURL(path), got, remote.SHA256)
+	}
+	return nil
+}
+
+// redactURL drops credentials embedded in a URL
+func redactURL(path string) string {
+	u, err := url.Parse(path)
+	if err != nil || u.User == nil {
+		return path
+	}
+	u.User = nil
+	return u.String()
+}
+
+// filePath returns the path part of a file name or URL, for detecting the
+// format by extension
+func filePath(path string) string {
+	if IsURL(path) {
+		if u, err := url.Parse(path); err == nil {
+			return u.Path
+		}
+	}
+	return path
+}
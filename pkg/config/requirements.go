@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MinSuccessAll requires every selected platform of a task to succeed
+const MinSuccessAll = "all"
+
+// MinSuccess is the number of platforms of a task that must succeed, or
+// "all". It is written as a number or a string.
+type MinSuccess string
+
+// Count returns the number of platforms that must succeed, and all when
+// every one must. An empty value requires one platform, as tasks always
+// have.
+func (m MinSuccess) Count() (count int, all bool, err error) {
+	switch value := strings.TrimSpace(string(m)); value {
+	case "":
+		return 1, false, nil
+	case MinSuccessAll:
+		return 0, true, nil
+	default:
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			return 0, false, fmt.Errorf("invalid min_success %q: a number of platforms of at least 1, or %q", value, MinSuccessAll)
+		}
+		return count, false, nil
+	}
+}
+
+// MarshalYAML writes counts as numbers
+func (m MinSuccess) MarshalYAML() (interface{}, error) {
+	if count, err := strconv.Atoi(string(m)); err == nil {
+		return count, nil
+	}
+	return string(m), nil
+}
+
+// UnmarshalYAML accepts numbers and strings
+func (m *MinSuccess) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("min_success must be a number or %q", MinSuccessAll)
+	}
+	*m = MinSuccess(node.Value)
+	return nil
+}
+
+// MarshalJSON writes counts as numbers
+func (m MinSuccess) MarshalJSON() ([]byte, error) {
+	if count, err := strconv.Atoi(string(m)); err == nil {
+		return json.Marshal(count)
+	}
+	return json.Marshal(string(m))
+}
+
+// UnmarshalJSON accepts numbers and strings
+func (m *MinSuccess) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		*m = MinSuccess(number.String())
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("min_success must be a number or %q", MinSuccessAll)
+	}
+	*m = MinSuccess(value)
+	return nil
+}
+
+// validateRequirements checks the platform requirements of a task
+func (t ImageTask) validateRequirements() error {
+	if _, _, err := t.MinSuccess.Count(); err != nil {
+		return err
+	}
+	if len(t.RequirePlatforms) == 0 && t.MinSuccess == "" {
+		return nil
+	}
+	if t.Verbatim || len(t.Archives) > 0 {
+		return fmt.Errorf("require_platforms and min_success can't be combined with verbatim or archives, which push all platforms or none")
+	}
+	for _, platform := range t.RequirePlatforms {
+		if platform == "" || strings.Count(platform, "/") > 2 {
+			return fmt.Errorf("invalid required platform %q: use os/arch[/variant] or arch[/variant]", platform)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ApplyOverrides applies key=value overrides such as registry.url=x or
+// images[2].target=y to the configuration, in order
+func (c *Config) ApplyOverrides(overrides []string) error {
+	for _, override := range overrides {
+		path, value, ok := strings.Cut(override, "=")
+		if !ok || path == "" {
+			return fmt.Errorf("invalid override %q, expected key=value", override)
+		}
+		if err := c.Set(path, value); err != nil {
+			return fmt.Errorf("override %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Set assigns value to the field at a dotted path of yaml keys. List
+// elements are addressed as key[N] and map entries as key.name or
+// key[name]; the value is parsed as YAML into the field's type.
+func (c *Config) Set(path, value string) error {
+	keys, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	return setPath(reflect.ValueOf(c).Elem(), keys, 0, value)
+}
+
+// setPath sets the field at keys[i:] below v. Map entries aren't
+// addressable, so they are copied, set and stored back.
+func setPath(v reflect.Value, keys []pathKey, i int, value string) error {
+	if i == len(keys) {
+		return decodeInto(v, value)
+	}
+	if v.Kind() == reflect.Map && !keys[i].list {
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(keys[i].name).Convert(v.Type().Key())
+		entry := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			entry.Set(existing)
+		}
+		if err := setPath(entry, keys, i+1, value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, entry)
+		return nil
+	}
+	field, err := step(v, keys[i], keys[:i])
+	if err != nil {
+		return err
+	}
+	return setPath(field, keys, i+1, value)
+}
+
+// pathKey is one element of an override path: a yaml key or a list index
+type pathKey struct {
+	name  string
+	index int
+	list  bool
+	// entry is set for a map key given in brackets, which may contain dots
+	entry bool
+}
+
+func splitPath(path string) ([]pathKey, error) {
+	var keys []pathKey
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			if len(keys) == 0 {
+				return nil, fmt.Errorf("invalid path")
+			}
+			path = path[1:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 || len(keys) == 0 {
+				return nil, fmt.Errorf("invalid path")
+			}
+			inner := path[1:end]
+			if n, err := strconv.Atoi(inner); err == nil {
+				keys = append(keys, pathKey{index: n, list: true})
+			} else {
+				keys = append(keys, pathKey{name: inner, entry: true})
+			}
+			path = path[end+1:]
+			continue
+		}
+		end := strings.IndexAny(path, ".[")
+		if end < 0 {
+			end = len(path)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("invalid path")
+		}
+		keys = append(keys, pathKey{name: path[:end]})
+		path = path[end:]
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return keys, nil
+}
+
+// step resolves one key below v, allocating nil pointers on the way
+func step(v reflect.Value, key pathKey, parent []pathKey) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch {
+	case key.list:
+		if v.Kind() != reflect.Slice {
+			return reflect.Value{}, fmt.Errorf("%s is not a list", formatPath(parent))
+		}
+		if key.index < 0 || key.index >= v.Len() {
+			return reflect.Value{}, fmt.Errorf("%s has %d entries, no index %d", formatPath(parent), v.Len(), key.index)
+		}
+		return v.Index(key.index), nil
+	case key.entry:
+		return reflect.Value{}, fmt.Errorf("%s is not a map", formatPath(parent))
+	case v.Kind() == reflect.Struct:
+		if field, ok := structField(v, key.name); ok {
+			return field, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown key %s", formatPath(append(parent, key)))
+}
+
+// structField finds the field tagged with name, descending into inline
+// structs
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if tag == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			if field, ok := structField(v.Field(i), name); ok {
+				return field, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(f.Name)
+		}
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func decodeInto(field reflect.Value, value string) error {
+	target := reflect.New(field.Type())
+	if field.Kind() == reflect.String {
+		// Keep strings such as "yes", "1.10" or "null" verbatim
+		target.Elem().SetString(value)
+	} else if err := yaml.Unmarshal([]byte(value), target.Interface()); err != nil {
+		return fmt.Errorf("invalid %s value %q", field.Type(), value)
+	}
+	field.Set(target.Elem())
+	return nil
+}
+
+func formatPath(keys []pathKey) string {
+	var b strings.Builder
+	for _, key := range keys {
+		switch {
+		case key.list:
+			fmt.Fprintf(&b, "[%d]", key.index)
+		case key.entry:
+			fmt.Fprintf(&b, "[%s]", key.name)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(key.name)
+		}
+	}
+	return b.String()
+}
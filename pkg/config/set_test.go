@@ -0,0 +1,90 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	const base = `registry:
+  url: registry.example.com
+tls:
+  registry.example.com:
+    ca_file: certs/ca.pem
+images:
+  - source: nginx:1.25
+    target: registry.example.com/nginx:1.25
+    architectures: [amd64]
+  - source: redis:7
+    save: true
+`
+	tests := []struct {
+		name      string
+		overrides []string
+		// get returns the value the overrides set, compared with want
+		get     func(c *Config) any
+		want    any
+		wantErr string
+	}{
+		{"top-level key", []string{"output_root=/srv/archives"}, func(c *Config) any { return c.OutputRoot }, "/srv/archives", ""},
+		{"nested key", []string{"registry.url=registry.new.example.com"}, func(c *Config) any { return c.Registry.URL }, "registry.new.example.com", ""},
+		{"inline field", []string{"registry.username=ci"}, func(c *Config) any { return c.Registry.Username }, "ci", ""},
+		{"nil pointer allocated", []string{"registry.api_auth.password=secret"}, func(c *Config) any { return c.Registry.APIAuth.Password }, "secret", ""},
+		{"nil section allocated", []string{"policy.deny_latest_targets=true"}, func(c *Config) any { return c.Policy.DenyLatestTargets }, true, ""},
+		{"list index", []string{"images[1].target=registry.example.com/redis:7"}, func(c *Config) any { return c.ImageTask[1].Target }, "registry.example.com/redis:7", ""},
+		{"inline task field", []string{"images[1].save=false"}, func(c *Config) any { return c.ImageTask[1].Save }, false, ""},
+		{"integer", []string{"images[0].priority=10"}, func(c *Config) any { return c.ImageTask[0].Priority }, 10, ""},
+		{"list value", []string{"images[0].architectures=[arm64, amd64]"}, func(c *Config) any { return c.ImageTask[0].Architectures }, []string{"arm64", "amd64"}, ""},
+		{"string kept verbatim", []string{"images[0].name=1.10"}, func(c *Config) any { return c.ImageTask[0].Name }, "1.10", ""},
+		{"yes kept verbatim", []string{"images[0].name=yes"}, func(c *Config) any { return c.ImageTask[0].Name }, "yes", ""},
+		{"empty value", []string{"images[0].target="}, func(c *Config) any { return c.ImageTask[0].Target }, "", ""},
+		{"value with =", []string{"annotations.note=a=b"}, func(c *Config) any { return c.Annotations["note"] }, "a=b", ""},
+		{"map entry", []string{"aliases.nginx=docker.io/library/nginx:1.25"}, func(c *Config) any { return c.Aliases["nginx"] }, "docker.io/library/nginx:1.25", ""},
+		{"map entry with dots", []string{"proxies[registry.example.com]=direct"}, func(c *Config) any { return c.Proxies["registry.example.com"] }, "direct", ""},
+		{"field of a map entry", []string{"tls[registry.example.com].skip_tls_verify=true"},
+			func(c *Config) any { return c.TLS["registry.example.com"] }, TLSConfig{CAFile: "certs/ca.pem", SkipVerify: true}, ""},
+		{"new map entry", []string{"tls[harbor.internal].ca_file=harbor.pem"}, func(c *Config) any { return len(c.TLS) }, 2, ""},
+		{"list in a map", []string{"mirrors[docker.io]=[mirror.example.com]"}, func(c *Config) any { return c.Mirrors["docker.io"] }, []string{"mirror.example.com"}, ""},
+		{"applied in order", []string{"images[0].priority=1", "images[0].priority=2"}, func(c *Config) any { return c.ImageTask[0].Priority }, 2, ""},
+
+		{"no =", []string{"registry.url"}, nil, nil, `invalid override "registry.url", expected key=value`},
+		{"no key", []string{"=x"}, nil, nil, `invalid override "=x", expected key=value`},
+		{"leading dot", []string{".registry.url=x"}, nil, nil, "override .registry.url: invalid path"},
+		{"leading index", []string{"[0].target=x"}, nil, nil, "override [0].target: invalid path"},
+		{"unclosed bracket", []string{"images[0.target=x"}, nil, nil, "override images[0.target: invalid path"},
+		{"unknown key", []string{"registry.nope=x"}, nil, nil, "override registry.nope: unknown key registry.nope"},
+		{"unknown task key", []string{"images[0].nope=x"}, nil, nil, "unknown key images[0].nope"},
+		{"unknown key of a map entry", []string{"tls[registry.example.com].nope=x"}, nil, nil, "unknown key tls[registry.example.com].nope"},
+		{"index out of range", []string{"images[5].target=x"}, nil, nil, "images has 2 entries, no index 5"},
+		{"negative index", []string{"images[-1].target=x"}, nil, nil, "images has 2 entries, no index -1"},
+		{"index of a struct", []string{"registry[0]=x"}, nil, nil, "registry is not a list"},
+		{"entry of a struct", []string{"images[0][name]=x"}, nil, nil, "images[0] is not a map"},
+		{"invalid integer", []string{"images[0].priority=high"}, nil, nil, `invalid int value "high"`},
+		{"invalid boolean", []string{"images[1].save=maybe"}, nil, nil, `invalid bool value "maybe"`},
+		{"mapping for a list", []string{"images[0].architectures={arch: amd64}"}, nil, nil, `invalid []string value`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			if err := yaml.Unmarshal([]byte(base), &c); err != nil {
+				t.Fatal(err)
+			}
+			err := c.ApplyOverrides(tt.overrides)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplyOverrides(%q) error %v, want one containing %q", tt.overrides, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyOverrides(%q): %v", tt.overrides, err)
+			}
+			if got := tt.get(&c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ApplyOverrides(%q) set %#v, want %#v", tt.overrides, got, tt.want)
+			}
+		})
+	}
+}
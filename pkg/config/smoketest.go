@@ -0,0 +1,50 @@
+package config
+
+import "fmt"
+
+// Smoke test modes
+const (
+	// SmokeTestPull pulls every pushed platform of the target into the
+	// docker daemon
+	SmokeTestPull = "pull"
+	// SmokeTestAPI checks the manifest and every blob of each pushed
+	// platform through the registry API, without pulling
+	SmokeTestAPI = "api"
+)
+
+// SmokeTestConfig checks, once a task pushed its images, that the target
+// registry serves every pushed platform with the pushed digest
+type SmokeTestConfig struct {
+	// Mode is SmokeTestPull, the default, or SmokeTestAPI
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Remove removes the images the pull mode pulled once it is done
+	Remove bool `yaml:"remove,omitempty" json:"remove,omitempty"`
+}
+
+// ValidateSmokeTest checks a smoke test mode
+func ValidateSmokeTest(mode string) error {
+	switch mode {
+	case "", SmokeTestPull, SmokeTestAPI:
+		return nil
+	}
+	return fmt.Errorf("unknown smoke test mode %q, supported: %s, %s", mode, SmokeTestPull, SmokeTestAPI)
+}
+
+// validateSmokeTest checks the smoke test of a task
+func (t ImageTask) validateSmokeTest() error {
+	if t.SmokeTest == nil {
+		return nil
+	}
+	if err := ValidateSmokeTest(t.SmokeTest.Mode); err != nil {
+		return err
+	}
+	switch {
+	case t.Target == "":
+		return fmt.Errorf("smoke_test checks the pushed images and requires a target")
+	case t.Verbatim || len(t.Archives) > 0:
+		return fmt.Errorf("smoke_test can't be combined with verbatim or archives, which push through the registry API")
+	case t.SmokeTest.Remove && t.SmokeTest.Mode == SmokeTestAPI:
+		return fmt.Errorf("smoke_test remove applies to the pull mode, the api mode pulls nothing")
+	}
+	return nil
+}
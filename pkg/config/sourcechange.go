@@ -0,0 +1,42 @@
+package config
+
+import "fmt"
+
+// Actions taken when the tag of a source moves to another digest while its
+// task runs, so its platforms may come from both
+const (
+	// SourceChangeWarn warns and creates the manifest list anyway, the
+	// default
+	SourceChangeWarn = "warn"
+	// SourceChangeFail fails the task without a manifest list
+	SourceChangeFail = "fail"
+	// SourceChangeRetry restarts the task with every platform pulled by
+	// the digest the source moved to
+	SourceChangeRetry = "retry"
+)
+
+// ValidateSourceChange checks an on_source_change action
+func ValidateSourceChange(action string) error {
+	switch action {
+	case "", SourceChangeWarn, SourceChangeFail, SourceChangeRetry:
+		return nil
+	}
+	return fmt.Errorf("unknown on_source_change %q, supported: %s, %s, %s", action, SourceChangeWarn, SourceChangeFail, SourceChangeRetry)
+}
+
+// validateSourceChange checks the on_source_change action of a task
+func (t ImageTask) validateSourceChange() error {
+	if t.OnSourceChange == "" {
+		return nil
+	}
+	if err := ValidateSourceChange(t.OnSourceChange); err != nil {
+		return err
+	}
+	switch {
+	case t.LocalOnly || len(t.Archives) > 0:
+		return fmt.Errorf("on_source_change watches the source tag in its registry and can't be combined with local_only or archives")
+	case t.SourceFrom != "":
+		return fmt.Errorf("on_source_change can't be combined with source_from, which pins the digest the task before pushed")
+	}
+	return nil
+}
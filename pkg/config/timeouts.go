@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timeouts limits how long work may take, as durations such as 45m, 6h or
+// 1d. An unset timeout doesn't limit anything.
+type Timeouts struct {
+	// Run limits a whole run, or each cycle in watch mode. It is only
+	// accepted in the top-level timeouts section.
+	Run string `yaml:"run,omitempty" json:"run,omitempty"`
+	// Task limits each task
+	Task string `yaml:"task,omitempty" json:"task,omitempty"`
+	// Operation limits each pull, save and push of a platform
+	Operation string `yaml:"operation,omitempty" json:"operation,omitempty"`
+}
+
+// Durations are parsed Timeouts, zero where unset
+type Durations struct {
+	Run       time.Duration
+	Task      time.Duration
+	Operation time.Duration
+}
+
+// Parse returns the parsed timeouts
+func (t Timeouts) Parse() (Durations, error) {
+	var d Durations
+	for _, field := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"run", t.Run, &d.Run},
+		{"task", t.Task, &d.Task},
+		{"operation", t.Operation, &d.Operation},
+	} {
+		if field.value == "" {
+			continue
+		}
+		parsed, err := ParseDuration(field.value)
+		if err != nil || parsed <= 0 {
+			return d, fmt.Errorf("invalid %s timeout %q", field.name, field.value)
+		}
+		*field.to = parsed
+	}
+	return d, nil
+}
+
+// Or returns the timeouts with the unset ones taken from fallback
+func (d Durations) Or(fallback Durations) Durations {
+	if d.Run == 0 {
+		d.Run = fallback.Run
+	}
+	if d.Task == 0 {
+		d.Task = fallback.Task
+	}
+	if d.Operation == 0 {
+		d.Operation = fallback.Operation
+	}
+	return d
+}
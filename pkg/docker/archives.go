@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// PushArchives pushes the image of saved archives to the target through the
+// registry API, without the docker daemon
+//
+// Deprecated: use ProcessImageTask with Target and Archives.
+func (c *Client) PushArchives(archives []string, sourceImage, targetImage string, auth RegistryAuth, options SaveOptions) (*TaskResult, error) {
+	return c.ProcessImageTask(options.Context, TaskSpec{Source: sourceImage, Target: targetImage, Archives: archives, Auth: auth, Options: options})
+}
+
+// pushArchives pushes the image of saved archives to the target of m. A
+// single archive is pushed as it is; the archives of several platforms of
+// one image, as save writes them, are merged into a multi-platform image
+// first. The source of m names the image in output and results.
+func (c *Client) pushArchives(m migration, archives []string) (result *TaskResult, err error) {
+	m.ctx = c.taskContext(m.options)
+	result = &TaskResult{Source: m.source, Target: m.target, StartedAt: time.Now(), BandwidthLimit: bandwidth.FromContext(m.ctx).Rate()}
+	c.emit(TaskStarted{Source: m.source, Target: m.target})
+	defer func() {
+		result.Duration = Duration(time.Since(result.StartedAt))
+		if err != nil {
+			result.Error = redact.String(err.Error())
+		}
+		recordImage(m.source, err)
+		c.emit(TaskCompleted{Result: result, Err: err})
+	}()
+
+	if len(archives) == 0 {
+		return result, fmt.Errorf("no archives to push")
+	}
+	if c.dryRun {
+		c.infof("Dry run: %s would be pushed to %s", strings.Join(archives, ", "), m.target)
+		result.DryRun = true
+		return result, nil
+	}
+	host, repo, tag, err := splitImage(m.target)
+	if err != nil {
+		return result, err
+	}
+	target, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		return result, err
+	}
+
+	input := archives[0]
+	if len(archives) > 1 {
+		dir, err := os.MkdirTemp(c.tmpDir, tempPrefix+"merge-*")
+		if err != nil {
+			return result, err
+		}
+		defer os.RemoveAll(dir)
+		input = filepath.Join(dir, "image")
+
+		err = timeStage(&result.Stages, StageManifestCreate, func() (int64, error) {
+			merged, err := archive.Merge(archives, input, archive.Format{Layout: archive.LayoutOCI, Dir: true}, m.source, false)
+			if err == nil {
+				c.infof("Merged %d archives into a %d-platform image of %s", len(archives), len(merged.Platforms), m.source)
+			}
+			return 0, err
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to merge the archives of %s: %w", m.source, err)
+		}
+	}
+
+	return result, c.pushInput(m, result, target, repo, tag, input, fmt.Sprintf("%d archives", len(archives)), archives)
+}
+
+// pushInput pushes the image of the archive or OCI layout at input to the
+// target of m through the registry client target, recording it in result.
+// from describes the input in messages and archives are the archive files
+// it was made of, for the audit log.
+func (c *Client) pushInput(m migration, result *TaskResult, target *registry.Client, repo, tag, input, from string, archives []string) error {
+	before := c.beforePush(m, m.target)
+	var pushed *archive.Pushed
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	err := timeStage(&result.Stages, StagePush, func() (int64, error) {
+		defer c.inFlight("pushing", m.source+" to "+m.target)()
+		err := c.withRetry("Push of "+m.source, func() error {
+			var err error
+			pushed, err = archive.Push(ctx, input, target, repo, tag, archive.PushOptions{
+				Annotations: m.options.Annotations,
+				Transform:   m.options.Transform,
+			})
+			return timeoutError(ctx, "Push of "+m.source, err)
+		})
+		if pushed == nil {
+			return 0, err
+		}
+		return pushed.Bytes, err
+	})
+	if err != nil {
+		if len(archives) > 0 {
+			from = strings.Join(archives, ", ")
+		}
+		return fmt.Errorf("failed to push %s to %s: %w", from, m.target, err)
+	}
+
+	before.Digest = pushed.Digest
+	recordPushed(result, before)
+	result.addStorage(StorageUsage{NewBytes: pushed.Bytes, TotalBytes: pushed.Bytes + pushed.SkippedBytes})
+
+	kind := audit.KindImage
+	if len(pushed.Platforms) > 1 {
+		kind = audit.KindManifest
+	}
+	if err := c.audit(m, audit.Entry{Kind: kind, SourceDigest: pushed.ArchiveDigest, Archives: archives, Target: m.target, TargetDigest: pushed.Digest}); err != nil {
+		return err
+	}
+
+	if len(pushed.Missing) > 0 {
+		c.warnf("Left out platforms %s of %s, whose images are missing from %s", strings.Join(pushed.Missing, ", "), m.source, from)
+	}
+	for _, platform := range pushed.Platforms {
+		result.Platforms = append(result.Platforms, PlatformResult{Platform: platform.String(), Tag: m.target})
+	}
+	if len(pushed.Platforms) > 1 {
+		result.ManifestList = m.target
+	}
+	c.infof("Pushed %s from %s to %s as %s (%d blobs uploaded, %d already present)",
+		m.source, from, m.target, pushed.Digest, pushed.Uploaded, pushed.Skipped)
+	return nil
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix starts the names of the temporary files outputs are written
+// to before they are renamed into place
+const tempPrefix = ".imgmigrate-tmp-"
+
+// WithTempDir writes outputs to temporary files in dir, such as a large
+// scratch volume, instead of next to their destination
+func WithTempDir(dir string) ClientOption {
+	return func(c *Client) {
+		c.tmpDir = dir
+	}
+}
+
+// tempPattern returns the os.CreateTemp pattern of the temporary files of
+// outputPath. The random suffix keeps them from looking like archives.
+func tempPattern(outputPath string) string {
+	return tempPrefix + filepath.Base(outputPath) + "-*"
+}
+
+// createTemp creates the temporary file outputPath is written to, after
+// removing those a crashed or interrupted run left behind for it
+func (c *Client) createTemp(outputPath string) (*os.File, error) {
+	dir := c.tmpDir
+	if dir == "" {
+		dir = filepath.Dir(outputPath)
+	}
+	c.removeLeftovers(dir, outputPath)
+	if dir != filepath.Dir(outputPath) {
+		c.removeLeftovers(filepath.Dir(outputPath), outputPath)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	return os.CreateTemp(dir, tempPattern(outputPath))
+}
+
+// removeLeftovers removes the temporary files of outputPath in dir. They
+// are never complete outputs, since an output is only renamed into place
+// once it is.
+func (c *Client) removeLeftovers(dir, outputPath string) {
+	entries, _ := os.ReadDir(dir)
+	prefix := strings.TrimSuffix(tempPattern(outputPath), "*")
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err == nil {
+			c.warnf("Removed %s, left behind by an interrupted save", path)
+		}
+	}
+}
+
+// commitTemp syncs and closes the complete temporary file tmp and renames
+// it to outputPath. A temporary file on another filesystem is first copied
+// next to outputPath, so the output still appears at once.
+func (c *Client) commitTemp(tmp *os.File, outputPath string) error {
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	err := os.Rename(tmp.Name(), outputPath)
+	if err == nil || filepath.Dir(tmp.Name()) == filepath.Dir(outputPath) {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	src, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	local, err := os.CreateTemp(filepath.Dir(outputPath), tempPattern(outputPath))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(local, bufio.NewReaderSize(src, 1<<20)); err != nil {
+		local.Close()
+		os.Remove(local.Name())
+		return fmt.Errorf("failed to move %s to %s: %v", tmp.Name(), outputPath, err)
+	}
+	if err := c.commitTemp(local, outputPath); err != nil {
+		os.Remove(local.Name())
+		return err
+	}
+	return nil
+}
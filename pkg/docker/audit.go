@@ -0,0 +1,39 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+)
+
+// WithAuditLog records every image and manifest list the client pushes in
+// log
+func WithAuditLog(log *audit.Log) ClientOption {
+	return func(c *Client) {
+		c.auditLog = log
+	}
+}
+
+// audit records a push of the migration in the audit log. A push that
+// can't be recorded fails when the log is required, and is only warned
+// about otherwise.
+func (c *Client) audit(m migration, entry audit.Entry) error {
+	if c.auditLog == nil {
+		return nil
+	}
+	entry.Task = m.options.Name
+	entry.Source = m.source
+	if entry.SourceDigest == "" {
+		entry.SourceDigest = m.options.SourceDigest
+	}
+	err := c.auditLog.Record(entry)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("failed to record the push of %s in the audit log: %w", entry.Target, err)
+	if c.auditLog.Required() {
+		return err
+	}
+	c.warnf("%v", err)
+	return nil
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	registrytypes "github.com/docker/docker/api/types/registry"
+)
+
+// storedAuth returns the credentials stored by docker login for a registry
+// host as a daemon auth config. Without stored credentials the config is
+// empty apart from the server address.
+func storedAuth(host string) registrytypes.AuthConfig {
+	creds, _ := registry.StoredCredentials(host)
+	redact.Register(creds.Password, creds.IdentityToken)
+	return registrytypes.AuthConfig{
+		Username:      creds.Username,
+		Password:      creds.Password,
+		IdentityToken: creds.IdentityToken,
+		ServerAddress: registry.ConfigKey(host),
+	}
+}
+
+// WithSourceAuth sets credentials by source registry host, used for pulls
+// and manifest queries instead of those stored by docker login
+func WithSourceAuth(auth map[string]RegistryAuth) ClientOption {
+	return func(c *Client) {
+		c.sourceAuth = make(map[string]RegistryAuth, len(auth))
+		for host, a := range auth {
+			c.sourceAuth[registry.NormalizeHost(host)] = a
+		}
+	}
+}
+
+// SourceAuth returns the credentials configured for a source registry
+// host, which are empty when it has none
+func (c *Client) SourceAuth(host string) RegistryAuth {
+	host = registry.NormalizeHost(host)
+	source, ok := c.sourceAuth[host]
+	if !ok {
+		return RegistryAuth{}
+	}
+	source.URL = host
+	return source
+}
+
+// authFor returns the explicit credentials for a registry host: auth when
+// it belongs to the host, otherwise the source credentials configured for it
+func (c *Client) authFor(host string, auth RegistryAuth) (RegistryAuth, bool) {
+	if auth.hasCredentials() && (auth.URL == "" || registry.NormalizeHost(auth.URL) == host) {
+		return auth, true
+	}
+	source, ok := c.sourceAuth[host]
+	return source, ok && source.hasCredentials()
+}
+
+// encodedAuthFor returns the encoded registry auth header for an image
+// reference. Explicit credentials are used when they belong to the image's
+// registry; otherwise credentials stored by docker login are looked up.
+// Without any credentials an empty auth config is encoded, which the daemon
+// treats as anonymous access.
+func (c *Client) encodedAuthFor(imageName string, auth RegistryAuth) (string, error) {
+	host := registryHost(imageName)
+
+	if auth, ok := c.authFor(host, auth); ok {
+		resolved, err := auth.resolve(c.ctx)
+		if err != nil {
+			return "", err
+		}
+		return c.getAuthConfig(resolved)
+	}
+
+	encoded, err := registrytypes.EncodeAuthConfig(storedAuth(host))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode credentials for %s: %v", host, err)
+	}
+	redact.Register(encoded)
+	return encoded, nil
+}
+
+// resolveStored returns a function reading the credentials docker login
+// stored for a host again, which picks up tokens renewed by a credential
+// helper
+func resolveStored(host string) func() (registry.Credentials, error) {
+	return func() (registry.Credentials, error) {
+		creds, _ := registry.StoredCredentials(host)
+		return creds, nil
+	}
+}
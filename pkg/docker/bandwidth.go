@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+)
+
+// WithBandwidthLimiter limits the bandwidth of saves and registry API
+// transfers with limiter, shared by all of them. The daemon doesn't limit
+// its own pushes, so they run one at a time instead.
+func WithBandwidthLimiter(limiter *bandwidth.Limiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// taskLimiter returns the limiter of a task limited to rate bytes per
+// second, shared by the tasks with the same limit, or the limiter of the
+// client when rate is zero
+func (c *Client) taskLimiter(rate int64) *bandwidth.Limiter {
+	if rate <= 0 {
+		return c.limiter
+	}
+	c.limitersMu.Lock()
+	defer c.limitersMu.Unlock()
+	if c.limiters == nil {
+		c.limiters = make(map[int64]*bandwidth.Limiter)
+	}
+	limiter, ok := c.limiters[rate]
+	if !ok {
+		limiter = bandwidth.NewLimiter(rate)
+		c.limiters[rate] = limiter
+	}
+	return limiter
+}
+
+// limiterFor returns the limiter of the streams started with ctx
+func (c *Client) limiterFor(ctx context.Context) *bandwidth.Limiter {
+	if limiter := bandwidth.FromContext(ctx); limiter != nil {
+		return limiter
+	}
+	return c.limiter
+}
@@ -0,0 +1,162 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+)
+
+// DefaultCacheTTL is how long manifest inspections stay valid on disk
+const DefaultCacheTTL = 24 * time.Hour
+
+// manifestCache remembers the platforms of image references together with
+// the digest they were inspected at, so an entry is only used while the
+// reference still points to the same manifest
+type manifestCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	// dir stores entries on disk across runs; empty keeps them in memory
+	dir string
+	ttl time.Duration
+}
+
+// cacheVersion is the version of the cache entry format. Entries of other
+// versions, which may lack fields of Platform, are inspected again.
+const cacheVersion = 2
+
+// cacheEntry is a cached manifest inspection
+type cacheEntry struct {
+	Version   int        `json:"version"`
+	Reference string     `json:"reference"`
+	Digest    string     `json:"digest"`
+	Platforms []Platform `json:"platforms"`
+	CachedAt  time.Time  `json:"cached_at"`
+}
+
+// WithManifestCache stores manifest inspections in dir for ttl, in addition
+// to the in-memory cache every client has. A zero ttl keeps entries in
+// memory only.
+func WithManifestCache(dir string, ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		if ttl > 0 {
+			c.manifests.dir = dir
+			c.manifests.ttl = ttl
+		}
+	}
+}
+
+// WithoutManifestCache disables caching, so every manifest is inspected
+func WithoutManifestCache() ClientOption {
+	return func(c *Client) {
+		c.manifests = nil
+	}
+}
+
+// DefaultCacheDir returns the directory of the on-disk manifest cache,
+// ~/.cache/imgmigrate on Linux, or "" if the user has no cache directory
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "imgmigrate")
+}
+
+// get returns the platforms cached for a reference at digest
+func (m *manifestCache) get(reference, digest string) ([]Platform, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.entries[reference]; ok && entry.Digest == digest {
+		return entry.Platforms, true
+	}
+	if m.dir == "" {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(m.path(reference))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || entry.Version != cacheVersion || entry.Reference != reference || entry.Digest != digest ||
+		time.Since(entry.CachedAt) > m.ttl {
+		return nil, false
+	}
+	m.entries[reference] = entry
+	return entry.Platforms, true
+}
+
+// put caches the platforms of a reference at digest. Failing to write the
+// disk cache only costs a later inspection, so it is not reported.
+func (m *manifestCache) put(reference, digest string, platforms []Platform) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := cacheEntry{Version: cacheVersion, Reference: reference, Digest: digest, Platforms: platforms, CachedAt: time.Now().UTC()}
+	m.entries[reference] = entry
+	if m.dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil || os.MkdirAll(filepath.Dir(m.path(reference)), 0755) != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path(reference)), ".manifest-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil && closeErr == nil {
+		os.Rename(tmp.Name(), m.path(reference))
+	}
+}
+
+// path returns the file caching a reference
+func (m *manifestCache) path(reference string) string {
+	sum := sha256.Sum256([]byte(reference))
+	return filepath.Join(m.dir, "manifests", hex.EncodeToString(sum[:])+".json")
+}
+
+// inspectPlatforms returns the platforms of an image, from the cache when
+// the reference still points to the cached digest. digest may be passed when
+// the caller already resolved it; otherwise it is resolved here, which is
+// much cheaper than inspecting the manifest.
+func (c *Client) inspectPlatforms(imageName, digest string) ([]Platform, error) {
+	if c.manifests == nil {
+		return c.getAvailablePlatforms(imageName)
+	}
+
+	if digest == "" {
+		if _, pinned, ok := strings.Cut(imageName, "@"); ok {
+			digest = pinned
+		} else if resolved, err := c.ResolveDigest(imageName); err == nil {
+			digest = resolved
+		} else {
+			// Without a digest the cache can't be validated
+			return c.getAvailablePlatforms(imageName)
+		}
+	}
+
+	if platforms, ok := c.manifests.get(imageref.Key(imageName), digest); ok {
+		c.infof("Using cached platforms of %s (%s)", imageName, digest)
+		return platforms, nil
+	}
+
+	platforms, err := c.getAvailablePlatforms(imageName)
+	if err != nil {
+		return nil, err
+	}
+	c.manifests.put(imageref.Key(imageName), digest, platforms)
+	return platforms, nil
+}
@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+)
+
+// recordChecksum lists the sha256 checksum of an archive in the
+// SHA256SUMS file of its directory, in the format of sha256sum, replacing
+// an earlier entry for the same file, and the archive in the run index of
+// the directory with the source and digest it was saved from
+func (c *Client) recordChecksum(archivePath, checksum, source, sourceDigest string) error {
+	c.checksumsMu.Lock()
+	defer c.checksumsMu.Unlock()
+
+	path := filepath.Join(filepath.Dir(archivePath), archive.ChecksumFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	name := filepath.Base(archivePath)
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		_, listed, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || strings.TrimPrefix(strings.TrimSpace(listed), "*") == name {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("%s  %s", checksum, name))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPattern(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := c.commitTemp(tmp, path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.recordRun(filepath.Dir(archivePath), archivePath, source, sourceDigest)
+}
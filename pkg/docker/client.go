@@ -1,19 +1,28 @@
 package docker
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/logging"
+	"github.com/Fr000g/ImgMigrate/pkg/metrics"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/version"
+	"github.com/distribution/reference"
+	registrytypes "github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 )
 
@@ -21,29 +30,262 @@ import (
 type Client struct {
 	cli *client.Client
 	ctx context.Context
+
+	handlers []EventHandler
+	eventsMu sync.Mutex
+
+	// pulled and archives remember local tags and archives by source, so
+	// later tasks for the same image reuse them instead of pulling again
+	reuseMu  sync.Mutex
+	pulled   map[string]string
+	archives map[string]string
+
+	// stores holds the shared blob stores images are saved to, by directory
+	storesMu sync.Mutex
+	stores   map[string]*archive.Store
+
+	// manifests caches manifest inspections; nil disables caching
+	manifests *manifestCache
+
+	// logins records the registries logged in to during the run, by
+	// normalized host and username, with the password used
+	loginsMu sync.Mutex
+	logins   map[loginKey]login
+
+	// mirrors lists pull-through mirrors by source registry host
+	mirrors map[string][]string
+	// sourceAuth holds configured credentials by source registry host
+	sourceAuth map[string]RegistryAuth
+
+	// registries holds the registry API clients by host, created with the
+	// TLS options tlsFor and the proxy proxyFor returns
+	registriesMu sync.Mutex
+	registries   map[string]*registry.Client
+	tlsFor       func(host string) registry.TLSOptions
+	proxyFor     func(host string) string
+
+	// tmpDir holds the temporary files outputs are written to, or is empty
+	// to write them next to their destination; checksumsMu serializes
+	// updates of SHA256SUMS files
+	tmpDir      string
+	checksumsMu sync.Mutex
+
+	// limiter limits the bandwidth of the client's streams, and limiters
+	// those of tasks with limits of their own, by rate; pushMu runs daemon
+	// pushes one at a time while they are limited
+	limiter    *bandwidth.Limiter
+	limitersMu sync.Mutex
+	limiters   map[int64]*bandwidth.Limiter
+	pushMu     sync.Mutex
+
+	// runID scopes the local tags of saved platforms to the run, and
+	// intermediate lists those the run created. runDirs lists the
+	// directories the run saved to, whose run index records it; it is
+	// guarded by checksumsMu.
+	runID          string
+	runStarted     time.Time
+	intermediateMu sync.Mutex
+	intermediate   []string
+	runDirs        []string
+
+	// dockerBin is the docker CLI run, through sudo if sudo is set, unless
+	// runner builds the commands. dockerHost is the daemon both the CLI and
+	// the Engine API client connect to, or empty for the environment's.
+	dockerBin  string
+	sudo       bool
+	runner     Runner
+	dockerHost string
+	// heartbeat is the interval of OperationHeartbeat events, or zero
+	heartbeat time.Duration
+	// platformGate, if set, is waited on before every platform of a task
+	// but the first, see WithPlatformGate
+	platformGate func(ctx context.Context, source, platform string) time.Duration
+	// diskLimit, if its Max is set, pauses platform pulls while the daemon
+	// uses too much disk, see WithDiskLimit
+	diskLimit DiskLimit
+	// pauses holds the registries paused for a Retry-After, each for at
+	// most maxRetryAfter, see WithMaxRetryAfter
+	pauses        *registry.Pauses
+	maxRetryAfter time.Duration
+	// platformBudget, if set, defers the platforms that no longer fit in
+	// the transfer budget, see WithPlatformBudget
+	platformBudget func() bool
+	// engine is the Engine API client given with WithEngine
+	engine *client.Client
+
+	// dryRun resolves and logs what tasks would do without doing it
+	dryRun bool
+
+	// auditLog records every push, if set
+	auditLog *audit.Log
+
+	log *slog.Logger
 }
 
 // RegistryAuth contains authentication information for a Docker registry
 type RegistryAuth struct {
 	Username string
-	Password string
-	URL      string
-	Insecure bool
+	Password redact.Secret
+	// Token is a registry bearer token used as is, such as a GitLab CI job
+	// token or an ACR access token
+	Token redact.Secret
+	// IdentityToken is a refresh token the registry exchanges for access tokens
+	IdentityToken redact.Secret
+	URL           string
+	Insecure      bool
+	// Provider, if set, supplies the credentials when they are needed, such
+	// as short-lived tokens that are renewed during a run
+	Provider registry.CredentialProvider
+}
+
+// hasCredentials reports whether explicit credentials are set
+func (a RegistryAuth) hasCredentials() bool {
+	return a.Username != "" || a.Token != "" || a.IdentityToken != "" || a.Provider != nil
 }
 
-// Platform represents an image platform
+// resolve returns the auth with the credentials of its provider filled in.
+// The secrets are registered for redaction before they are used.
+func (a RegistryAuth) resolve(ctx context.Context) (RegistryAuth, error) {
+	if a.Provider != nil {
+		creds, err := a.Provider.Credentials(ctx)
+		if err != nil {
+			return a, err
+		}
+		a.Username = creds.Username
+		a.Password = redact.Secret(creds.Password)
+		a.IdentityToken = redact.Secret(creds.IdentityToken)
+		a.Token = redact.Secret(creds.RegistryToken)
+	}
+	redact.Register(a.Password.Reveal(), a.IdentityToken.Reveal(), a.Token.Reveal())
+	return a, nil
+}
+
+// credentials returns the credentials of the auth for the registry API
+func (a RegistryAuth) credentials() registry.Credentials {
+	return registry.Credentials{
+		Username:      a.Username,
+		Password:      a.Password.Reveal(),
+		IdentityToken: a.IdentityToken.Reveal(),
+		RegistryToken: a.Token.Reveal(),
+	}
+}
+
+// Platform represents an image platform, with the image manifest the
+// source lists for it when it was read from a registry
 type Platform struct {
 	OS           string
 	Architecture string
 	Variant      string
+	OSVersion    string
+	// Digest, Size and MediaType describe the image manifest of the
+	// platform; they are empty when the source didn't report them
+	Digest    string
+	Size      int64
+	MediaType string
+	// Nested is set when an index nested in the source index lists the
+	// platform. The daemon doesn't follow nested indexes, so the platform
+	// is pulled by its digest.
+	Nested bool
+}
+
+// String returns the platform in os/arch[/variant] form
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
 }
 
 // SaveOptions represents options for saving images
 type SaveOptions struct {
-	UseCompression   bool
-	OutputDir        string
-	OperatingSystems []string
-	CreateMultiArch  bool
+	// Name is the name of the configuration task, recorded in the audit log
+	Name           string
+	UseCompression bool
+	// Compression, gzip, zstd or archive.Auto, compresses saved archives
+	// instead of UseCompression. Auto picks one for every archive from a
+	// sample of CompressionSample bytes as CompressionPreference says,
+	// keeping the one PinnedCompression records for its platform.
+	Compression           string
+	CompressionPreference archive.Preference
+	CompressionSample     int64
+	PinnedCompression     map[string]archive.Compression
+	OutputDir             string
+	OperatingSystems      []string
+	CreateMultiArch       bool
+	// SourceDigest is the resolved digest of the source image. When set,
+	// platforms an earlier task pulled for the same digest are reused.
+	SourceDigest string
+	// PinDigests pulls every platform by the digest SourceDigest lists for
+	// it rather than by tag, so a tag moved meanwhile can't change what is
+	// copied
+	PinDigests bool
+	// OnSourceChange, one of the config.SourceChange constants, is what
+	// to do when the source tag moves to another digest between the start
+	// of the task and its manifest list; warn by default
+	OnSourceChange string
+	// ArchTag is the config.ArchTag template of the tags the platforms are
+	// pushed under in the target repository; empty suffixes the target
+	// with the platform
+	ArchTag string
+	// Annotations are added to the manifest list pushed to the target.
+	// Adding them changes the digest of the manifest list; the images it
+	// references are left as they are.
+	Annotations map[string]string
+	// PreserveIndex carries the media type and annotations of the source
+	// index, including those of its platforms, over to the manifest list
+	// pushed to the target
+	PreserveIndex bool
+	// SourceOrder lists the platforms of the pushed manifest list in the
+	// order of the source index, and skips the list unless every selected
+	// platform was pushed
+	SourceOrder bool
+	// Verbatim copies all architectures through the registry API instead
+	// of the docker daemon, keeping the source index and its digest
+	Verbatim bool
+	// Transform squashes or recompresses the layers of every platform
+	// before it is pushed, through the registry API
+	Transform archive.Transform
+	// RepoTags selects the names embedded in saved archives, one of the
+	// config.RepoTags constants
+	RepoTags string
+	// BlobStore, if set, is an OCI layout directory saved images are added
+	// to instead of being written to archives of their own, so layers they
+	// share are stored once
+	BlobStore string
+	// Compat is the compatibility profile of saved archives
+	Compat archive.Compat
+	// LocalOnly uses the source image in the local daemon as it is: its
+	// platforms are taken from the local image and nothing is pulled, so
+	// no registry is contacted for the source
+	LocalOnly bool
+	// Context, if set, bounds the task: once it is cancelled or its
+	// deadline passes, the running operation is stopped and the remaining
+	// platforms fail
+	Context context.Context
+	// OperationTimeout limits each pull, save and push of a platform; zero
+	// for no limit
+	OperationTimeout time.Duration
+	// SplitPlatforms saves each platform in a directory of its own below
+	// OutputDir, named after the platform
+	SplitPlatforms bool
+	// BandwidthLimit limits the task to its own bandwidth in bytes per
+	// second, instead of the limit of the client; zero for the client's
+	BandwidthLimit int64
+	// RequirePlatforms lists platforms, as os/arch[/variant] or
+	// arch[/variant], that must succeed; MinSuccess is the number of
+	// platforms that must, and RequireAll requires every one. A task that
+	// misses them fails without a manifest list.
+	RequirePlatforms []string
+	MinSuccess       int
+	RequireAll       bool
+	// Rollback looks up what every target tag points to before pushing
+	// it, so the pushes of a failed task can be undone
+	Rollback bool
+	// SmokeTest, one of the config.SmokeTest constants, checks that the
+	// target serves every pushed platform with its pushed digest, failing
+	// the task when it doesn't; SmokeTestRemove removes what it pulled
+	SmokeTest       string
+	SmokeTestRemove bool
 }
 
 // PullOptions for docker pull
@@ -56,31 +298,87 @@ type PushOptions struct {
 	RegistryAuth string
 }
 
-// NewClient creates a new Docker client
-func NewClient() (*Client, error) {
+// NewClient creates a new Docker client. Without options it runs the docker
+// CLI found on PATH and connects to the daemon the environment names, as
+// the docker CLI does. Options that contradict each other are rejected.
+func NewClient(opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		ctx:        context.Background(),
+		dockerBin:  DefaultDockerBinary,
+		log:        slog.New(logging.Redacting(logging.NewConsoleHandler(os.Stdout, slog.LevelInfo, false))),
+		pulled:     make(map[string]string),
+		archives:   make(map[string]string),
+		stores:     make(map[string]*archive.Store),
+		manifests:  &manifestCache{entries: make(map[string]cacheEntry)},
+		registries: make(map[string]*registry.Client),
+		logins:     make(map[loginKey]login),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	switch {
+	case c.runner != nil && (c.sudo || c.dockerBin != DefaultDockerBinary):
+		return nil, fmt.Errorf("WithRunner builds the docker commands itself and can't be combined with WithSudo or WithDockerBinary")
+	case c.engine != nil && (c.sudo || c.dockerHost != ""):
+		return nil, fmt.Errorf("WithEngine sets the Engine API client and can't be combined with WithSudo or WithDockerHost")
+	}
+	c.pauses = registry.NewPauses(c.maxRetryAfter, c.notifyPause)
+	if c.runner == nil && c.dockerBin == DefaultDockerBinary {
+		c.dockerBin = defaultDockerBinary()
+	}
+
 	// Check if docker CLI is available
-	cmd := exec.Command("docker", "--version")
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("docker command not found or not executable: %v", err)
+	if output, err := c.command(c.ctx, "--version").CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("docker command %s not found or not executable: %v", c.commandLine(nil), err)
 	}
 
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if c.engine != nil {
+		c.cli = c.engine
+		return c, nil
+	}
+	apiOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation(), client.WithUserAgent(version.UserAgent())}
+	if c.dockerHost != "" {
+		apiOpts = append(apiOpts, client.WithHost(c.dockerHost))
+	}
+	if c.sudo {
+		apiOpts = append(apiOpts, client.WithDialContext(c.dialStdio))
+	}
+	cli, err := client.NewClientWithOpts(apiOpts...)
 	if err != nil {
 		return nil, err
 	}
+	c.cli = cli
+
+	return c, nil
+}
+
+// infof logs a progress message
+func (c *Client) infof(format string, args ...interface{}) {
+	c.log.Info(fmt.Sprintf(format, args...))
+}
+
+// warnf logs a problem that doesn't stop processing
+func (c *Client) warnf(format string, args ...interface{}) {
+	c.log.Warn(fmt.Sprintf(format, args...))
+}
 
-	return &Client{
-		cli: cli,
-		ctx: ctx,
-	}, nil
+// errorf logs a failure
+func (c *Client) errorf(format string, args ...interface{}) {
+	c.log.Error(fmt.Sprintf(format, args...))
 }
 
-// getAuthConfig returns a base64 encoded auth config for registry authentication
+// getAuthConfig returns a base64 encoded auth config for registry
+// authentication. The encoded config is registered for redaction, since it
+// contains the credentials.
 func (c *Client) getAuthConfig(auth RegistryAuth) (string, error) {
-	authConfig := registry.AuthConfig{
-		Username: auth.Username,
-		Password: auth.Password,
+	authConfig := registrytypes.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password.Reveal(),
+		IdentityToken: auth.IdentityToken.Reveal(),
+		RegistryToken: auth.Token.Reveal(),
 	}
 
 	if auth.URL != "" {
@@ -91,161 +389,157 @@ func (c *Client) getAuthConfig(auth RegistryAuth) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return base64.URLEncoding.EncodeToString(encodedJSON), nil
+	encoded := base64.URLEncoding.EncodeToString(encodedJSON)
+	redact.Register(encoded)
+	return encoded, nil
 }
 
-// loginRegistry logs in to a Docker registry
-func (c *Client) loginRegistry(auth RegistryAuth) error {
-	if auth.Username == "" || auth.Password == "" || auth.URL == "" {
-		return nil // Skip login if credentials are not provided
+// imageSize returns the uncompressed size of a local image, or 0 if unknown
+func (c *Client) imageSize(imageName string) int64 {
+	output, err := c.runDocker("image", "inspect", "--format", "{{.Size}}", imageName)
+	if err != nil {
+		return 0
 	}
 
-	args := []string{"login", "--username", auth.Username, "--password-stdin"}
-	if auth.Insecure {
-		args = append(args, "--insecure")
-	}
-	args = append(args, auth.URL)
+	size, _ := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	return size
+}
 
-	cmd := exec.Command("docker", args...)
-	stdin, err := cmd.StdinPipe()
+// registryHost returns the registry host an image reference points to
+func registryHost(imageName string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
 	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %v", err)
+		return ""
 	}
+	return reference.Domain(named)
+}
 
-	go func() {
-		defer stdin.Close()
-		io.WriteString(stdin, auth.Password)
-	}()
+// observeStage records the duration of a pipeline stage
+func observeStage(stage string, elapsed time.Duration) {
+	metrics.StageDuration.Observe(elapsed.Seconds(), stage)
+}
 
-	output, err := cmd.CombinedOutput()
+// recordImage records the final status of an image once processing returns
+func recordImage(imageName string, err error) {
+	status := "success"
 	if err != nil {
-		return fmt.Errorf("failed to login to registry: %v, output: %s", err, string(output))
+		status = "failure"
+		metrics.Failures.Inc(imageName, "", "task")
 	}
+	metrics.ImagesProcessed.Inc(imageName, status)
+}
 
-	return nil
+// VersionInfo describes the docker CLI and daemon the client talks to
+type VersionInfo struct {
+	CLIVersion    string `json:"cli_version,omitempty"`
+	ServerVersion string `json:"server_version,omitempty"`
+	APIVersion    string `json:"api_version,omitempty"`
 }
 
-// pullImage pulls a Docker image
-func (c *Client) pullImage(imageName string, platform string) error {
-	fmt.Printf("Pulling image %s for platform %s...\n", imageName, platform)
+// Version returns the versions of the docker CLI and the connected daemon,
+// along with the negotiated API version
+func (c *Client) Version() (VersionInfo, error) {
+	var info VersionInfo
 
-	args := []string{"pull"}
-	if platform != "" {
-		args = append(args, "--platform", platform)
+	output, err := c.runDocker("version", "--format", "{{.Client.Version}}")
+	if err != nil {
+		return info, fmt.Errorf("failed to get docker CLI version: %w", err)
 	}
-	args = append(args, imageName)
+	info.CLIVersion = strings.TrimSpace(string(output))
 
-	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	server, err := c.cli.ServerVersion(c.ctx)
+	if err != nil {
+		return info, fmt.Errorf("failed to get docker daemon version: %w", errdefs.Classify(err, ""))
+	}
+	info.ServerVersion = server.Version
+	info.APIVersion = c.cli.ClientVersion()
 
-	return cmd.Run()
+	return info, nil
 }
 
-// saveImage saves a Docker image to a file with optional compression
-func (c *Client) saveImage(imageName string, outputPath string, useCompression bool) error {
-	fmt.Printf("Saving image %s to %s...\n", imageName, outputPath)
-
-	// Create output directory if it doesn't exist
-	outputDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+// ResolveDigest returns the digest of the manifest or manifest list an image
+// reference currently points to. The registry is asked directly, falling
+// back to the daemon when that fails.
+func (c *Client) ResolveDigest(imageName string) (string, error) {
+	digest, err := c.registryDigest(imageName)
+	if err == nil {
+		return digest, nil
 	}
+	c.log.Debug(fmt.Sprintf("Registry API digest lookup of %s failed, asking the daemon: %v", imageName, err))
 
-	args := []string{"save", imageName}
-	cmd := exec.Command("docker", args...)
-
-	var err error
-	if useCompression {
-		// Use compression
-		outFile, err := os.Create(outputPath)
-		if err != nil {
-			return fmt.Errorf("failed to create output file: %v", err)
-		}
-		defer outFile.Close()
-
-		gzWriter := gzip.NewWriter(outFile)
-		defer gzWriter.Close()
-
-		cmd.Stdout = gzWriter
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
-	} else {
-		// No compression
-		outFile, err := os.Create(outputPath)
-		if err != nil {
-			return fmt.Errorf("failed to create output file: %v", err)
-		}
-		defer outFile.Close()
-
-		cmd.Stdout = outFile
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
+	encodedAuth, err := c.encodedAuthFor(imageName, RegistryAuth{})
+	if err != nil {
+		return "", err
 	}
-
-	return err
-}
-
-// tagImage tags a Docker image
-func (c *Client) tagImage(sourceImage, targetImage string) error {
-	fmt.Printf("Tagging %s as %s...\n", sourceImage, targetImage)
-	cmd := exec.Command("docker", "tag", sourceImage, targetImage)
-	output, err := cmd.CombinedOutput()
+	inspect, err := c.cli.DistributionInspect(c.ctx, imageName, encodedAuth)
 	if err != nil {
-		return fmt.Errorf("failed to tag image: %v, output: %s", err, string(output))
+		return "", fmt.Errorf("failed to resolve digest for %s: %w", imageName, errdefs.Classify(err, ""))
 	}
-	return nil
+	return inspect.Descriptor.Digest.String(), nil
 }
 
-// pushImage pushes a Docker image to a registry
-func (c *Client) pushImage(imageName string, auth RegistryAuth) error {
-	fmt.Printf("Pushing image %s...\n", imageName)
+// getAvailablePlatforms returns the platforms of an image. The registry API
+// is queried directly; docker manifest inspect is the fallback for
+// registries the tool can't reach or authenticate to itself.
+func (c *Client) getAvailablePlatforms(imageName string) ([]Platform, error) {
+	c.infof("Getting available platforms for %s...", imageName)
 
-	// Login to registry first if credentials are provided
-	if err := c.loginRegistry(auth); err != nil {
-		return err
+	platforms, err := c.registryPlatforms(imageName)
+	if err == nil {
+		return platforms, nil
 	}
+	c.log.Debug(fmt.Sprintf("Registry API manifest query of %s failed, using docker manifest inspect: %v", imageName, err))
 
-	cmd := exec.Command("docker", "push", imageName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
+	return c.inspectManifestCLI(imageName)
 }
 
-// getAvailablePlatforms uses docker CLI to get the available platforms for an image
-// This is a workaround for the API limitations
-func (c *Client) getAvailablePlatforms(imageName string) ([]Platform, error) {
-	fmt.Printf("Getting available platforms for %s...\n", imageName)
-
-	// Pull image manifest first to ensure we have the latest info
-	inspectCmd := exec.Command("docker", "manifest", "inspect", imageName)
-	output, err := inspectCmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("failed to inspect manifest: %v, output: %s", err, string(output))
-	}
-
-	var manifestData struct {
-		Manifests []struct {
-			Platform struct {
-				Architecture string `json:"architecture"`
-				OS           string `json:"os"`
-				Variant      string `json:"variant,omitempty"`
-			} `json:"platform"`
-		} `json:"manifests"`
-	}
+// inspectManifestCLI uses the docker CLI to get the available platforms for
+// an image, leaving out attestation manifests. Nested indexes are inspected
+// by digest in turn, down to registry.MaxIndexDepth levels.
+func (c *Client) inspectManifestCLI(imageName string) ([]Platform, error) {
+	var platforms []Platform
+	seen := make(map[string]bool)
+	level := []string{imageName}
+	for depth := 1; len(level) > 0; depth++ {
+		var nested []string
+		for _, name := range level {
+			output, err := c.runDocker("manifest", "inspect", name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to inspect manifest: %w, output: %s", err, string(output))
+			}
 
-	if err := json.Unmarshal(output, &manifestData); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest: %v", err)
-	}
+			var manifestData struct {
+				Manifests []registry.Descriptor `json:"manifests"`
+			}
+			if err := json.Unmarshal(output, &manifestData); err != nil {
+				return nil, fmt.Errorf("failed to parse manifest: %v", err)
+			}
 
-	var platforms []Platform
-	for _, m := range manifestData.Manifests {
-		platforms = append(platforms, Platform{
-			OS:           m.Platform.OS,
-			Architecture: m.Platform.Architecture,
-			Variant:      m.Platform.Variant,
-		})
+			for _, m := range manifestData.Manifests {
+				if registry.IsIndexType(m.MediaType) {
+					if depth >= registry.MaxIndexDepth {
+						return nil, fmt.Errorf("index of %s nests indexes more than %d levels deep", imageName, registry.MaxIndexDepth)
+					}
+					nested = append(nested, digestReference(imageName, m.Digest))
+					continue
+				}
+				if m.Platform == nil || m.IsAttestation() || seen[m.Platform.String()] {
+					continue
+				}
+				seen[m.Platform.String()] = true
+				platforms = append(platforms, Platform{
+					OS:           m.Platform.OS,
+					Architecture: m.Platform.Architecture,
+					Variant:      m.Platform.Variant,
+					OSVersion:    m.Platform.OSVersion,
+					Digest:       m.Digest,
+					Size:         m.Size,
+					MediaType:    m.MediaType,
+					Nested:       depth > 1,
+				})
+			}
+		}
+		level = nested
 	}
 
 	return platforms, nil
@@ -291,554 +585,171 @@ func (c *Client) filterPlatforms(platforms []Platform, os []string, archs []stri
 	return filtered
 }
 
-// PullAllArchitectures pulls all available architectures for an image
-func (c *Client) PullAllArchitectures(imageName string, options SaveOptions) error {
-	// Get available platforms
-	platforms, err := c.getAvailablePlatforms(imageName)
-	if err != nil {
-		return fmt.Errorf("failed to get available platforms: %v", err)
-	}
-
-	if len(platforms) == 0 {
-		return fmt.Errorf("no platform information found for image %s", imageName)
-	}
-
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
-	}
-
-	// Filter platforms by OS if specified
-	if len(options.OperatingSystems) > 0 {
-		platforms = c.filterPlatforms(platforms, options.OperatingSystems, nil)
-		fmt.Printf("Filtered to %d platforms based on specified operating systems: %v\n",
-			len(platforms), options.OperatingSystems)
-	}
-
-	fmt.Printf("Found %d architectures for %s\n", len(platforms), imageName)
-
-	var taggedImages []string
-
-	for _, platform := range platforms {
-		arch := platform.Architecture
-		if platform.Variant != "" {
-			arch = fmt.Sprintf("%s/%s", arch, platform.Variant)
-		}
-
-		platformStr := fmt.Sprintf("%s/%s", platform.OS, arch)
-		fmt.Printf("Processing image for architecture: %s\n", platformStr)
-
-		// Pull the image for this platform
-		if err := c.pullImage(imageName, platformStr); err != nil {
-			fmt.Printf("Failed to pull image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Tag the image with architecture
-		baseImage := strings.Split(imageName, ":")[0]
-		var tag string
-		if len(strings.Split(imageName, ":")) > 1 {
-			tag = strings.Split(imageName, ":")[1]
-		} else {
-			tag = "latest"
-		}
-
-		newTag := fmt.Sprintf("%s:%s-%s", baseImage, tag, strings.Replace(platformStr, "/", "-", -1))
-		if err := c.tagImage(imageName, newTag); err != nil {
-			fmt.Printf("Failed to tag image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Verify the tagged image exists locally
-		verifyCmd := exec.Command("docker", "image", "inspect", newTag)
-		if verifyErr := verifyCmd.Run(); verifyErr != nil {
-			fmt.Printf("Warning: Tagged image %s not found locally after tagging\n", newTag)
-			continue
-		}
-
-		// Add to list of tagged images for multi-arch manifest
-		taggedImages = append(taggedImages, newTag)
-
-		// Wait a moment for the tag to complete
-		time.Sleep(1 * time.Second)
-
-		// Save the image with appropriate extension
-		extension := ".tar"
-		if options.UseCompression {
-			extension = ".tar.gz"
-		}
-
-		outputPath := filepath.Join(options.OutputDir, fmt.Sprintf("%s%s", strings.Replace(newTag, "/", "-", -1), extension))
-		if err := c.saveImage(newTag, outputPath, options.UseCompression); err != nil {
-			fmt.Printf("Failed to save image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		fmt.Printf("Successfully saved image %s to %s\n", newTag, outputPath)
-	}
-
-	// Create multi-arch manifest if requested
-	if options.CreateMultiArch && len(taggedImages) > 0 {
-		fmt.Printf("Create multi-arch manifest option is enabled\n")
-		baseImage := strings.Split(imageName, ":")[0]
-		var tag string
-		if len(strings.Split(imageName, ":")) > 1 {
-			tag = strings.Split(imageName, ":")[1]
-		} else {
-			tag = "latest"
-		}
-
-		manifestTag := fmt.Sprintf("%s:%s-allarch", baseImage, tag)
-		if err := c.createManifestList(imageName, manifestTag, taggedImages); err != nil {
-			fmt.Printf("Failed to create multi-arch manifest: %v\n", err)
-		} else {
-			fmt.Printf("Successfully created multi-arch manifest %s\n", manifestTag)
-
-			// Save the manifest image if saving locally
-			if options.UseCompression {
-				extension := ".tar.gz"
-				outputPath := filepath.Join(options.OutputDir, fmt.Sprintf("%s%s", strings.Replace(manifestTag, "/", "-", -1), extension))
-				if err := c.saveImage(manifestTag, outputPath, true); err != nil {
-					fmt.Printf("Failed to save multi-arch manifest image: %v\n", err)
-				} else {
-					fmt.Printf("Successfully saved multi-arch manifest image to %s\n", outputPath)
-				}
-			}
-		}
-	} else if len(taggedImages) > 0 {
-		fmt.Printf("Create multi-arch manifest option is disabled, skipping manifest creation\n")
-	}
-
-	return nil
+// TaskSpec describes an image task, as a task of the configuration file does
+type TaskSpec struct {
+	// Source is the image to migrate. Target, if set, is the image its
+	// platforms are pushed to; without it they are saved.
+	Source string
+	Target string
+	// Architectures selects the platforms to migrate by architecture, or
+	// AllArchitectures every platform of the source
+	Architectures    []string
+	AllArchitectures bool
+	// Save saves the platforms to Options.OutputDir, before pushing them
+	// when Target is set
+	Save bool
+	// Archives, if set, are saved archives pushed to Target through the
+	// registry API instead of pulling Source, which then only names the
+	// image
+	Archives []string
+	// Auth authenticates to the registry of Target
+	Auth RegistryAuth
+	// Options sets how the platforms are saved and pushed, the manifest
+	// list created for them and what the task requires to succeed
+	Options SaveOptions
 }
 
-// PullSpecificArchitectures pulls specific architectures for an image
-func (c *Client) PullSpecificArchitectures(imageName string, archs []string, options SaveOptions) error {
-	// Get available platforms
-	platforms, err := c.getAvailablePlatforms(imageName)
-	if err != nil {
-		return fmt.Errorf("failed to get available platforms: %v", err)
-	}
-
-	if len(platforms) == 0 {
-		return fmt.Errorf("no platform information found for image %s", imageName)
-	}
-
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
-	}
-
-	// Filter platforms by OS and architecture
-	platforms = c.filterPlatforms(platforms, options.OperatingSystems, archs)
-
-	fmt.Printf("Filtering for architectures: %v and operating systems: %v\n",
-		archs, options.OperatingSystems)
-
-	if len(platforms) == 0 {
-		return fmt.Errorf("no matching platforms found for the specified OS and architectures")
-	}
-
-	fmt.Printf("Found %d matching platforms after filtering\n", len(platforms))
-
-	var taggedImages []string
-
-	for _, platform := range platforms {
-		arch := platform.Architecture
-		if platform.Variant != "" {
-			arch = fmt.Sprintf("%s/%s", arch, platform.Variant)
-		}
-
-		platformStr := fmt.Sprintf("%s/%s", platform.OS, arch)
-		fmt.Printf("Processing image for architecture: %s\n", platformStr)
-
-		// Pull the image for this platform
-		if err := c.pullImage(imageName, platformStr); err != nil {
-			fmt.Printf("Failed to pull image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Tag the image with architecture
-		baseImage := strings.Split(imageName, ":")[0]
-		var tag string
-		if len(strings.Split(imageName, ":")) > 1 {
-			tag = strings.Split(imageName, ":")[1]
-		} else {
-			tag = "latest"
-		}
-
-		newTag := fmt.Sprintf("%s:%s-%s", baseImage, tag, strings.Replace(platformStr, "/", "-", -1))
-		if err := c.tagImage(imageName, newTag); err != nil {
-			fmt.Printf("Failed to tag image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Verify the tagged image exists locally
-		verifyCmd := exec.Command("docker", "image", "inspect", newTag)
-		if verifyErr := verifyCmd.Run(); verifyErr != nil {
-			fmt.Printf("Warning: Tagged image %s not found locally after tagging\n", newTag)
-			continue
-		}
-
-		// Add to list of tagged images for multi-arch manifest
-		taggedImages = append(taggedImages, newTag)
-
-		// Wait a moment for the tag to complete
-		time.Sleep(1 * time.Second)
-
-		// Save the image with appropriate extension
-		extension := ".tar"
-		if options.UseCompression {
-			extension = ".tar.gz"
-		}
-
-		outputPath := filepath.Join(options.OutputDir, fmt.Sprintf("%s%s", strings.Replace(newTag, "/", "-", -1), extension))
-		if err := c.saveImage(newTag, outputPath, options.UseCompression); err != nil {
-			fmt.Printf("Failed to save image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
+// ProcessImageTask runs an image task within ctx: it pulls the selected
+// platforms of the source, saves them when the task saves, and pushes them
+// and their manifest list when it has a target. A task that both saves and
+// pushes completes as two tasks, and the returned result merges them.
+func (c *Client) ProcessImageTask(ctx context.Context, spec TaskSpec) (*TaskResult, error) {
+	options := spec.Options
+	options.Context = ctx
+
+	var archs []string
+	if !spec.AllArchitectures {
+		if len(spec.Architectures) == 0 && len(spec.Archives) == 0 && !archive.IsSource(spec.Source) {
+			return nil, fmt.Errorf("either all architectures or specific architectures must be selected")
+		}
+		archs = spec.Architectures
+	}
+	m := migration{source: spec.Source, target: spec.Target, archs: archs, options: options, auth: spec.Auth}
+
+	switch {
+	case archive.IsSource(spec.Source):
+		return c.processLayout(m, spec.Save)
+	case len(spec.Archives) > 0:
+		if spec.Target == "" {
+			return nil, fmt.Errorf("archives can only be pushed to a target")
+		}
+		return c.pushArchives(m, spec.Archives)
+	case spec.Target == "" && !spec.Save:
+		return nil, fmt.Errorf("either a target must be specified or the task must save")
+	case spec.Target == "":
+		return c.migrate(m)
+	case !spec.Save:
+		return c.push(m)
+	}
+
+	result := &TaskResult{Source: spec.Source, Target: spec.Target, StartedAt: time.Now()}
+	defer func() {
+		result.Duration = Duration(time.Since(result.StartedAt))
+	}()
 
-		fmt.Printf("Successfully saved image %s to %s\n", newTag, outputPath)
+	// The platforms are saved as a task without a target would, then
+	// pushed
+	save := m
+	save.target = ""
+	saved, err := c.migrate(save)
+	result.merge(saved)
+	if err != nil {
+		return result, fmt.Errorf("failed to pull and save %s: %w", describeArchs(archs), err)
 	}
 
-	// Create multi-arch manifest if requested
-	if options.CreateMultiArch && len(taggedImages) > 0 {
-		fmt.Printf("Create multi-arch manifest option is enabled\n")
-		baseImage := strings.Split(imageName, ":")[0]
-		var tag string
-		if len(strings.Split(imageName, ":")) > 1 {
-			tag = strings.Split(imageName, ":")[1]
-		} else {
-			tag = "latest"
-		}
-
-		manifestTag := fmt.Sprintf("%s:%s-allarch", baseImage, tag)
-		if err := c.createManifestList(imageName, manifestTag, taggedImages); err != nil {
-			fmt.Printf("Failed to create multi-arch manifest: %v\n", err)
-		} else {
-			fmt.Printf("Successfully created multi-arch manifest %s\n", manifestTag)
-		}
-	} else if len(taggedImages) > 0 {
-		fmt.Printf("Create multi-arch manifest option is disabled, skipping manifest creation\n")
+	pushed, err := c.push(m)
+	result.merge(pushed)
+	if err != nil {
+		return result, fmt.Errorf("failed to push %s: %w", describeArchs(archs), err)
 	}
-
-	return nil
+	return result, nil
 }
 
-// ProcessImageTask processes a single image task which can include pulling, saving, and pushing
-func (c *Client) ProcessImageTask(sourceImage string, targetImage string, archs []string, allArch bool,
-	saveLocally bool, options SaveOptions, auth RegistryAuth) error {
-
-	// Handle local save options
-	var localOptions SaveOptions = options
-	// Only create multi-arch manifest locally if we're not pushing to remote
-	if targetImage == "" {
-		// Don't create manifest when only saving locally
-		localOptions.CreateMultiArch = false
+// push pushes the platforms of a migration to its target, copying the
+// source index through the registry API for a verbatim copy of every
+// platform
+func (c *Client) push(m migration) (*TaskResult, error) {
+	if m.options.Verbatim && m.archs == nil && !c.dryRun {
+		return c.copyImage(m)
 	}
+	return c.migrate(m)
+}
 
-	// Pull and save images if requested
-	if saveLocally {
-		if allArch {
-			if err := c.PullAllArchitectures(sourceImage, localOptions); err != nil {
-				return fmt.Errorf("failed to pull and save all architectures: %v", err)
-			}
-		} else {
-			if err := c.PullSpecificArchitectures(sourceImage, archs, localOptions); err != nil {
-				return fmt.Errorf("failed to pull and save specific architectures: %v", err)
-			}
-		}
+// describeArchs names the architectures a task selects in errors
+func describeArchs(archs []string) string {
+	if archs == nil {
+		return "all architectures"
 	}
+	return "specific architectures"
+}
 
-	// Push to registry if target image is specified
-	if targetImage != "" {
-		// Create multi-arch manifest when pushing to remote if requested
-		pushOptions := options
-
-		if allArch {
-			if err := c.PushAllArchitectures(sourceImage, targetImage, auth, pushOptions); err != nil {
-				return fmt.Errorf("failed to push all architectures: %v", err)
-			}
-		} else {
-			if err := c.PushSpecificArchitectures(sourceImage, targetImage, archs, auth, pushOptions); err != nil {
-				return fmt.Errorf("failed to push specific architectures: %v", err)
-			}
-		}
-	}
+// PullAllArchitectures pulls all available architectures for an image
+//
+// Deprecated: use ProcessImageTask with AllArchitectures and Save.
+func (c *Client) PullAllArchitectures(imageName string, options SaveOptions) (*TaskResult, error) {
+	return c.ProcessImageTask(options.Context, TaskSpec{Source: imageName, AllArchitectures: true, Save: true, Options: options})
+}
 
-	return nil
+// PullSpecificArchitectures pulls specific architectures for an image
+//
+// Deprecated: use ProcessImageTask with Architectures and Save.
+func (c *Client) PullSpecificArchitectures(imageName string, archs []string, options SaveOptions) (*TaskResult, error) {
+	return c.ProcessImageTask(options.Context, TaskSpec{Source: imageName, Architectures: archs, Save: true, Options: options})
 }
 
 // PushAllArchitectures pulls all architectures from source image and pushes them to target registry
-func (c *Client) PushAllArchitectures(sourceImage, targetImage string, auth RegistryAuth, options SaveOptions) error {
-	// Get available platforms
-	platforms, err := c.getAvailablePlatforms(sourceImage)
-	if err != nil {
-		return fmt.Errorf("failed to get available platforms: %v", err)
-	}
-
-	if len(platforms) == 0 {
-		return fmt.Errorf("no platform information found for image %s", sourceImage)
-	}
-
-	// Filter platforms by OS if specified
-	if len(options.OperatingSystems) > 0 {
-		platforms = c.filterPlatforms(platforms, options.OperatingSystems, nil)
-		fmt.Printf("Filtered to %d platforms based on specified operating systems: %v\n",
-			len(platforms), options.OperatingSystems)
-	}
-
-	fmt.Printf("Found %d architectures for %s\n", len(platforms), sourceImage)
-
-	var taggedImages []string
-
-	for _, platform := range platforms {
-		arch := platform.Architecture
-		if platform.Variant != "" {
-			arch = fmt.Sprintf("%s/%s", arch, platform.Variant)
-		}
-
-		platformStr := fmt.Sprintf("%s/%s", platform.OS, arch)
-		fmt.Printf("Processing image for architecture: %s\n", platformStr)
-
-		// Pull the image for this platform
-		if err := c.pullImage(sourceImage, platformStr); err != nil {
-			fmt.Printf("Failed to pull image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Tag with target image name
-		targetTag := fmt.Sprintf("%s-%s", targetImage, strings.Replace(platformStr, "/", "-", -1))
-		if err := c.tagImage(sourceImage, targetTag); err != nil {
-			fmt.Printf("Failed to tag image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Verify the tagged image exists locally
-		verifyCmd := exec.Command("docker", "image", "inspect", targetTag)
-		if verifyErr := verifyCmd.Run(); verifyErr != nil {
-			fmt.Printf("Warning: Tagged image %s not found locally after tagging\n", targetTag)
-			continue
-		}
-
-		// Add to list of tagged images for multi-arch manifest
-		taggedImages = append(taggedImages, targetTag)
-
-		// Wait a moment for the tag to complete
-		time.Sleep(1 * time.Second)
-
-		// Push to target registry
-		if err := c.pushImage(targetTag, auth); err != nil {
-			fmt.Printf("Failed to push image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		fmt.Printf("Successfully pushed image %s\n", targetTag)
-	}
-
-	// Create multi-arch manifest if requested
-	if options.CreateMultiArch && len(taggedImages) > 0 {
-		fmt.Printf("Preparing to create multi-arch manifest for remote registry with %d images\n", len(taggedImages))
-
-		// Verify all tagged images exist locally
-		var validImages []string
-		for _, img := range taggedImages {
-			verifyCmd := exec.Command("docker", "image", "inspect", img)
-			if err := verifyCmd.Run(); err == nil {
-				validImages = append(validImages, img)
-			} else {
-				fmt.Printf("Warning: Image %s not found locally, will be excluded from manifest\n", img)
-			}
-		}
-
-		if len(validImages) == 0 {
-			fmt.Printf("No valid images found for manifest creation, skipping\n")
-		} else {
-			fmt.Printf("Creating multi-arch manifest for remote registry push\n")
-			manifestTag := fmt.Sprintf("%s-allarch", targetImage)
-			if err := c.createManifestList(sourceImage, manifestTag, validImages); err != nil {
-				fmt.Printf("Failed to create multi-arch manifest: %v\n", err)
-			} else {
-				fmt.Printf("Successfully created multi-arch manifest %s\n", manifestTag)
-
-				// Also tag the manifest with the base targetImage
-				if err := c.tagImage(manifestTag, targetImage); err != nil {
-					fmt.Printf("Failed to tag manifest with base image name: %v\n", err)
-				} else {
-					fmt.Printf("Successfully tagged manifest as %s\n", targetImage)
-					// Push the base tag
-					if err := c.pushImage(targetImage, auth); err != nil {
-						fmt.Printf("Failed to push base manifest tag: %v\n", err)
-					} else {
-						fmt.Printf("Successfully pushed multi-arch image to %s\n", targetImage)
-					}
-				}
-			}
-		}
-	} else {
-		fmt.Printf("Multi-arch manifest creation is disabled, skipping\n")
-	}
-
-	return nil
+//
+// Deprecated: use ProcessImageTask with Target and AllArchitectures.
+func (c *Client) PushAllArchitectures(sourceImage, targetImage string, auth RegistryAuth, options SaveOptions) (*TaskResult, error) {
+	return c.ProcessImageTask(options.Context, TaskSpec{Source: sourceImage, Target: targetImage, AllArchitectures: true, Auth: auth, Options: options})
 }
 
 // PushSpecificArchitectures pulls specific architectures from source image and pushes them to target registry
-func (c *Client) PushSpecificArchitectures(sourceImage, targetImage string, archs []string, auth RegistryAuth, options SaveOptions) error {
-	// Get available platforms
-	platforms, err := c.getAvailablePlatforms(sourceImage)
-	if err != nil {
-		return fmt.Errorf("failed to get available platforms: %v", err)
-	}
-
-	if len(platforms) == 0 {
-		return fmt.Errorf("no platform information found for image %s", sourceImage)
-	}
-
-	// Filter platforms by OS and architecture
-	platforms = c.filterPlatforms(platforms, options.OperatingSystems, archs)
-
-	fmt.Printf("Filtering for architectures: %v and operating systems: %v\n",
-		archs, options.OperatingSystems)
-
-	if len(platforms) == 0 {
-		return fmt.Errorf("no matching platforms found for the specified OS and architectures")
-	}
-
-	fmt.Printf("Found %d matching platforms after filtering\n", len(platforms))
-
-	var taggedImages []string
-
-	for _, platform := range platforms {
-		arch := platform.Architecture
-		if platform.Variant != "" {
-			arch = fmt.Sprintf("%s/%s", arch, platform.Variant)
-		}
-
-		platformStr := fmt.Sprintf("%s/%s", platform.OS, arch)
-		fmt.Printf("Processing image for architecture: %s\n", platformStr)
-
-		// Pull the image for this platform
-		if err := c.pullImage(sourceImage, platformStr); err != nil {
-			fmt.Printf("Failed to pull image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Tag with target image name
-		targetTag := fmt.Sprintf("%s-%s", targetImage, strings.Replace(platformStr, "/", "-", -1))
-		if err := c.tagImage(sourceImage, targetTag); err != nil {
-			fmt.Printf("Failed to tag image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		// Verify the tagged image exists locally
-		verifyCmd := exec.Command("docker", "image", "inspect", targetTag)
-		if verifyErr := verifyCmd.Run(); verifyErr != nil {
-			fmt.Printf("Warning: Tagged image %s not found locally after tagging\n", targetTag)
-			continue
-		}
-
-		// Add to list of tagged images for multi-arch manifest
-		taggedImages = append(taggedImages, targetTag)
-
-		// Wait a moment for the tag to complete
-		time.Sleep(1 * time.Second)
-
-		// Push to target registry
-		if err := c.pushImage(targetTag, auth); err != nil {
-			fmt.Printf("Failed to push image for architecture %s: %v\n", platformStr, err)
-			continue
-		}
-
-		fmt.Printf("Successfully pushed image %s\n", targetTag)
-	}
-
-	// Create multi-arch manifest if requested
-	if options.CreateMultiArch && len(taggedImages) > 0 {
-		fmt.Printf("Preparing to create multi-arch manifest for remote registry with %d images\n", len(taggedImages))
-
-		// Verify all tagged images exist locally
-		var validImages []string
-		for _, img := range taggedImages {
-			verifyCmd := exec.Command("docker", "image", "inspect", img)
-			if err := verifyCmd.Run(); err == nil {
-				validImages = append(validImages, img)
-			} else {
-				fmt.Printf("Warning: Image %s not found locally, will be excluded from manifest\n", img)
-			}
-		}
-
-		if len(validImages) == 0 {
-			fmt.Printf("No valid images found for manifest creation, skipping\n")
-		} else {
-			fmt.Printf("Creating multi-arch manifest for remote registry push\n")
-			manifestTag := fmt.Sprintf("%s-allarch", targetImage)
-			if err := c.createManifestList(sourceImage, manifestTag, validImages); err != nil {
-				fmt.Printf("Failed to create multi-arch manifest: %v\n", err)
-			} else {
-				fmt.Printf("Successfully created multi-arch manifest %s\n", manifestTag)
-
-				// Also tag the manifest with the base targetImage
-				if err := c.tagImage(manifestTag, targetImage); err != nil {
-					fmt.Printf("Failed to tag manifest with base image name: %v\n", err)
-				} else {
-					fmt.Printf("Successfully tagged manifest as %s\n", targetImage)
-					// Push the base tag
-					if err := c.pushImage(targetImage, auth); err != nil {
-						fmt.Printf("Failed to push base manifest tag: %v\n", err)
-					} else {
-						fmt.Printf("Successfully pushed multi-arch image to %s\n", targetImage)
-					}
-				}
-			}
-		}
-	} else {
-		fmt.Printf("Multi-arch manifest creation is disabled, skipping\n")
-	}
-
-	return nil
+//
+// Deprecated: use ProcessImageTask with Target and Architectures.
+func (c *Client) PushSpecificArchitectures(sourceImage, targetImage string, archs []string, auth RegistryAuth, options SaveOptions) (*TaskResult, error) {
+	return c.ProcessImageTask(options.Context, TaskSpec{Source: sourceImage, Target: targetImage, Architectures: archs, Auth: auth, Options: options})
 }
 
-// createManifestList creates a multi-architecture manifest for the tagged images
-func (c *Client) createManifestList(baseImage string, targetImage string, taggedImages []string) error {
-	fmt.Printf("Creating multi-architecture manifest %s with %d images...\n", targetImage, len(taggedImages))
+// createManifestList creates a multi-architecture manifest for the tagged
+// images, and returns its digest when it was pushed to a registry
+func (c *Client) createManifestList(baseImage string, targetImage string, taggedImages []string) (string, error) {
+	c.infof("Creating multi-architecture manifest %s with %d images...", targetImage, len(taggedImages))
 
 	// Verify tagged images exist locally and get their full IDs for manifest creation
 	var localImageRefs []string
 	for _, img := range taggedImages {
-		inspectCmd := exec.Command("docker", "image", "inspect", "--format", "{{.Id}}", img)
-		output, err := inspectCmd.Output()
+		output, err := c.runDocker("image", "inspect", "--format", "{{.Id}}", img)
 		if err != nil {
-			fmt.Printf("Warning: Image %s not found locally, manifest creation may fail\n", img)
+			c.warnf("Image %s not found locally, manifest creation may fail", img)
 			// Still add the original tag to the list, in case it does exist
 			localImageRefs = append(localImageRefs, img)
 		} else {
 			// Found local image, use it
 			imageID := strings.TrimSpace(string(output))
-			fmt.Printf("Found local image %s with ID %s\n", img, imageID)
+			c.infof("Found local image %s with ID %s", img, imageID)
 			localImageRefs = append(localImageRefs, img)
 		}
 	}
 
 	if len(localImageRefs) == 0 {
-		return fmt.Errorf("no local images found to create manifest")
+		return "", fmt.Errorf("no local images found to create manifest")
 	}
 
 	// Remove any existing manifest with this name
-	removeCmd := exec.Command("docker", "manifest", "rm", targetImage)
 	// Ignore errors as the manifest might not exist yet
-	removeCmd.Run()
+	c.runDocker("manifest", "rm", targetImage)
 
 	// Create manifest
 	args := []string{"manifest", "create", targetImage}
 	args = append(args, localImageRefs...)
 
-	fmt.Printf("Creating manifest with command: docker %s\n", strings.Join(args, " "))
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
+	c.infof("Creating manifest with command: docker %s", strings.Join(args, " "))
+	output, err := c.runDocker(args...)
 	if err != nil {
-		return fmt.Errorf("failed to create manifest: %v, output: %s", err, string(output))
+		return "", fmt.Errorf("failed to create manifest: %w, output: %s", err, string(output))
 	}
-	fmt.Printf("Successfully created manifest list locally\n")
+	c.infof("Successfully created manifest list locally")
 
 	// Annotate manifest entries with platform info if needed
 	for _, img := range localImageRefs {
@@ -870,32 +781,37 @@ func (c *Client) createManifestList(baseImage string, targetImage string, tagged
 			annotateArgs = append(annotateArgs, "--variant", variant)
 		}
 
-		fmt.Printf("Annotating manifest with command: docker %s\n", strings.Join(annotateArgs, " "))
-		annotateCmd := exec.Command("docker", annotateArgs...)
-		annoOutput, annoErr := annotateCmd.CombinedOutput()
+		c.infof("Annotating manifest with command: docker %s", strings.Join(annotateArgs, " "))
+		annoOutput, annoErr := c.runDocker(annotateArgs...)
 		if annoErr != nil {
-			fmt.Printf("Warning: Failed to annotate manifest for %s: %v, output: %s\n", img, annoErr, string(annoOutput))
+			c.warnf("Failed to annotate manifest for %s: %v, output: %s", img, annoErr, string(annoOutput))
 		} else {
-			fmt.Printf("Annotated manifest for %s with os=linux, arch=%s, variant=%s\n", img, arch, variant)
+			c.infof("Annotated manifest for %s with os=linux, arch=%s, variant=%s", img, arch, variant)
 		}
 	}
 
 	// Push manifest to registry if target contains a registry reference
 	if strings.Contains(targetImage, "/") {
-		fmt.Printf("Pushing multi-arch manifest to registry: %s\n", targetImage)
-		pushCmd := exec.Command("docker", "manifest", "push", "--purge", targetImage)
-		pushOutput, pushErr := pushCmd.CombinedOutput()
+		c.infof("Pushing multi-arch manifest to registry: %s", targetImage)
+		if err := c.waitForRegistry(c.ctx, targetImage); err != nil {
+			return "", err
+		}
+		pushOutput, pushErr := c.runDocker("manifest", "push", "--purge", targetImage)
 		if pushErr != nil {
-			return fmt.Errorf("failed to push manifest: %v, output: %s", pushErr, string(pushOutput))
+			c.pauseRegistry(targetImage, pushErr)
+			return "", fmt.Errorf("failed to push manifest: %w, output: %s", pushErr, string(pushOutput))
+		}
+		c.infof("Successfully pushed manifest to registry")
+		// docker manifest push prints the digest of the pushed list last
+		if fields := strings.Fields(string(pushOutput)); len(fields) > 0 && strings.HasPrefix(fields[len(fields)-1], "sha256:") {
+			return fields[len(fields)-1], nil
 		}
-		fmt.Printf("Successfully pushed manifest to registry\n")
 	} else {
 		// If not pushing to registry, we keep it locally
 		// We could inspect it to display information
-		inspectCmd := exec.Command("docker", "manifest", "inspect", targetImage)
-		inspectOutput, _ := inspectCmd.CombinedOutput()
-		fmt.Printf("Manifest inspect result:\n%s\n", string(inspectOutput))
+		inspectOutput, _ := c.runDocker("manifest", "inspect", targetImage)
+		c.infof("Manifest inspect result:\n%s", string(inspectOutput))
 	}
 
-	return nil
+	return "", nil
 }
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// TestClientOptions builds a client with every option that configures how
+// it reaches docker and runs, and checks the settings it ends up with
+func TestClientOptions(t *testing.T) {
+	bin, _ := fakeDocker(t)
+	t.Setenv("DOCKER_HOST", "")
+	engine, err := client.NewClientWithOpts(client.WithHost("unix:///run/engine.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := RunnerFunc(func(ctx context.Context, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, bin, args...)
+	})
+	logger := slog.New(slog.DiscardHandler)
+
+	tests := []struct {
+		name  string
+		opts  []ClientOption
+		check func(t *testing.T, c *Client)
+	}{
+		{"defaults", nil, func(t *testing.T, c *Client) {
+			if c.dockerBin != defaultDockerBinary() || c.sudo || c.runner != nil || c.dockerHost != "" || c.dryRun {
+				t.Errorf("bin %s, sudo %v, runner %v, host %q, dry run %v, want the docker of PATH run directly", c.dockerBin, c.sudo, c.runner, c.dockerHost, c.dryRun)
+			}
+			if host := c.cli.DaemonHost(); host != client.DefaultDockerHost {
+				t.Errorf("Engine API host = %s, want %s", host, client.DefaultDockerHost)
+			}
+		}},
+		{"WithDockerBinary", []ClientOption{WithDockerBinary(bin)}, func(t *testing.T, c *Client) {
+			if c.dockerBin != bin || c.commandLine(nil) != bin {
+				t.Errorf("bin %s runs as %q, want %s", c.dockerBin, c.commandLine(nil), bin)
+			}
+		}},
+		{"WithDockerBinary empty", []ClientOption{WithDockerBinary("")}, func(t *testing.T, c *Client) {
+			if c.dockerBin != defaultDockerBinary() {
+				t.Errorf("bin = %s, want the default", c.dockerBin)
+			}
+		}},
+		{"WithSudo", []ClientOption{WithSudo()}, func(t *testing.T, c *Client) {
+			if !c.sudo || c.commandLine([]string{"info"}) != "sudo "+c.dockerBin+" info" {
+				t.Errorf("sudo %v runs as %q, want sudo %s info", c.sudo, c.commandLine([]string{"info"}), c.dockerBin)
+			}
+		}},
+		{"WithDockerHost", []ClientOption{WithDockerHost("tcp://build-host:2376")}, func(t *testing.T, c *Client) {
+			if c.dockerHost != "tcp://build-host:2376" || c.commandLine([]string{"info"}) != c.dockerBin+" --host tcp://build-host:2376 info" {
+				t.Errorf("host %q runs as %q", c.dockerHost, c.commandLine([]string{"info"}))
+			}
+			if host := c.cli.DaemonHost(); host != "tcp://build-host:2376" {
+				t.Errorf("Engine API host = %s, want tcp://build-host:2376", host)
+			}
+		}},
+		{"WithEngine", []ClientOption{WithEngine(engine)}, func(t *testing.T, c *Client) {
+			if c.cli != engine {
+				t.Error("the Engine API client isn't the one given")
+			}
+		}},
+		{"WithRunner", []ClientOption{WithRunner(runner)}, func(t *testing.T, c *Client) {
+			if c.runner == nil || c.dockerBin != DefaultDockerBinary {
+				t.Errorf("runner %v, bin %s, want the runner to build the commands", c.runner, c.dockerBin)
+			}
+		}},
+		{"WithDryRun", []ClientOption{WithDryRun()}, func(t *testing.T, c *Client) {
+			if !c.dryRun {
+				t.Error("dry run not set")
+			}
+		}},
+		{"WithRunID", []ClientOption{WithRunID("3f9a0c1e")}, func(t *testing.T, c *Client) {
+			if c.RunID() != "3f9a0c1e" || c.runStarted.IsZero() {
+				t.Errorf("run %q started %v", c.RunID(), c.runStarted)
+			}
+		}},
+		{"WithLogger", []ClientOption{WithLogger(logger)}, func(t *testing.T, c *Client) {
+			if c.log != logger {
+				t.Error("the logger isn't the one given")
+			}
+		}},
+		{"WithTempDir", []ClientOption{WithTempDir("/var/tmp/imgmigrate")}, func(t *testing.T, c *Client) {
+			if c.tmpDir != "/var/tmp/imgmigrate" {
+				t.Errorf("temporary directory = %q", c.tmpDir)
+			}
+		}},
+		{"WithHeartbeat", []ClientOption{WithHeartbeat(30 * time.Second)}, func(t *testing.T, c *Client) {
+			if c.heartbeat != 30*time.Second {
+				t.Errorf("heartbeat = %v", c.heartbeat)
+			}
+		}},
+		{"WithMaxRetryAfter", []ClientOption{WithMaxRetryAfter(5 * time.Minute)}, func(t *testing.T, c *Client) {
+			if c.maxRetryAfter != 5*time.Minute || c.pauses.Max() != 5*time.Minute {
+				t.Errorf("max retry-after = %v, pauses up to %v", c.maxRetryAfter, c.pauses.Max())
+			}
+		}},
+		{"combined", []ClientOption{WithDockerBinary(bin), WithSudo(), WithDockerHost("unix:///run/user/1000/docker.sock"), WithDryRun()}, func(t *testing.T, c *Client) {
+			want := "sudo " + bin + " --host unix:///run/user/1000/docker.sock info"
+			if got := c.commandLine([]string{"info"}); got != want || !c.dryRun {
+				t.Errorf("runs as %q with dry run %v, want %q", got, c.dryRun, want)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.opts...)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestEngineConflicts(t *testing.T) {
+	fakeDocker(t)
+	engine, err := client.NewClientWithOpts(client.WithHost("unix:///run/engine.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []ClientOption{WithSudo(), WithDockerHost("tcp://build-host:2376")} {
+		if _, err := NewClient(WithEngine(engine), opt); err == nil {
+			t.Error("NewClient accepted WithEngine with WithSudo or WithDockerHost")
+		}
+	}
+}
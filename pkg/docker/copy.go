@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// copyImage copies all architectures of the source image to the target
+// through the registry API, without the docker daemon. The source index is
+// copied byte for byte with every manifest it lists, attestations
+// included, so the target keeps its digest.
+func (c *Client) copyImage(m migration) (result *TaskResult, err error) {
+	m.ctx = c.taskContext(m.options)
+	result = &TaskResult{Source: m.source, Target: m.target, StartedAt: time.Now(), BandwidthLimit: bandwidth.FromContext(m.ctx).Rate()}
+	c.emit(TaskStarted{Source: m.source, Target: m.target})
+	defer func() {
+		result.Duration = Duration(time.Since(result.StartedAt))
+		if err != nil {
+			result.Error = redact.String(err.Error())
+		}
+		recordImage(m.source, err)
+		c.emit(TaskCompleted{Result: result, Err: err})
+	}()
+
+	host, repo, tag, err := splitImage(m.target)
+	if err != nil {
+		return result, err
+	}
+	target, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		return result, err
+	}
+
+	pushed := c.beforePush(m, m.target)
+	var copied *registry.Copied
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	err = timeStage(&result.Stages, StagePush, func() (int64, error) {
+		defer c.inFlight("copying", m.source+" to "+m.target)()
+		_, err := c.fromSources(m.source, "Copy", func(imageName string) error {
+			source, sourceRepo, ref, err := c.registryFor(imageName)
+			if err != nil {
+				return err
+			}
+			if m.options.SourceDigest != "" {
+				ref = m.options.SourceDigest
+			}
+			return c.withRetry("Copy of "+imageName, func() error {
+				copied, err = target.Copy(ctx, source, sourceRepo, ref, repo, tag)
+				return timeoutError(ctx, "Copy of "+imageName, err)
+			})
+		})
+		if copied == nil {
+			return 0, err
+		}
+		return copied.Bytes, err
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to copy %s to %s: %w", m.source, m.target, err)
+	}
+
+	pushed.Digest = copied.Digest
+	recordPushed(result, pushed)
+	result.addStorage(StorageUsage{NewBytes: copied.Bytes, TotalBytes: copied.Bytes + copied.SkippedBytes})
+
+	// The index is copied byte for byte, so the source has the same digest
+	if err := c.audit(m, audit.Entry{Kind: audit.KindManifest, SourceDigest: copied.Digest, Target: m.target, TargetDigest: copied.Digest}); err != nil {
+		return result, err
+	}
+
+	for _, platform := range copied.Platforms {
+		result.Platforms = append(result.Platforms, PlatformResult{Platform: platform.String(), Tag: m.target})
+	}
+	if len(copied.Platforms) > 0 {
+		result.ManifestList = m.target
+	}
+	c.infof("Copied %s to %s verbatim as %s (%d blobs uploaded, %d already present)",
+		m.source, m.target, copied.Digest, copied.Uploaded, copied.Skipped)
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialStdio connects to the Engine API through docker system dial-stdio,
+// so the API is reachable with the privileges of the docker CLI
+func (c *Client) dialStdio(_ context.Context, _, _ string) (net.Conn, error) {
+	// The connection outlives the dial context, so the command must not be
+	// bound to it
+	cmd := c.command(context.Background(), "system", "dial-stdio")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	conn := &stdioConn{cmd: cmd, stdin: stdin, stdout: stdout, name: c.commandLine([]string{"system", "dial-stdio"})}
+	cmd.Stderr = &conn.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, c.runError(err)
+	}
+	return conn, nil
+}
+
+// stdioConn is a connection over the standard input and output of a
+// command. Deadlines are not supported.
+type stdioConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	name   string
+	stderr lockedBuffer
+
+	closeOnce sync.Once
+}
+
+func (s *stdioConn) Read(p []byte) (int, error) {
+	n, err := s.stdout.Read(p)
+	if err == io.EOF && s.stderr.String() != "" {
+		err = fmt.Errorf("%s: %s", s.name, strings.TrimSpace(s.stderr.String()))
+	}
+	return n, err
+}
+
+func (s *stdioConn) Write(p []byte) (int, error) {
+	return s.stdin.Write(p)
+}
+
+func (s *stdioConn) Close() error {
+	s.closeOnce.Do(func() {
+		s.stdin.Close()
+		if s.cmd.Process != nil {
+			s.cmd.Process.Kill()
+		}
+		s.cmd.Wait()
+	})
+	return nil
+}
+
+func (s *stdioConn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (s *stdioConn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (s *stdioConn) SetDeadline(t time.Time) error      { return nil }
+func (s *stdioConn) SetReadDeadline(t time.Time) error  { return nil }
+func (s *stdioConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// stdioAddr is the address of a stdioConn
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+// lockedBuffer collects the error output of a command while it runs
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/distribution/reference"
+)
+
+// digestTagLength is the number of hex digits of a digest kept in the tag
+// standing in for it, as many as docker shows of image IDs
+const digestTagLength = 12
+
+// digestTag returns the tag standing in for a digest in local tags and
+// file names, such as sha256-0123456789ab
+func digestTag(digest string) string {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok {
+		return digest
+	}
+	if len(encoded) > digestTagLength {
+		encoded = encoded[:digestTagLength]
+	}
+	return algorithm + "-" + encoded
+}
+
+// pinnedDigest returns the digest a source reference is given by, or an
+// empty string for a source given by tag
+func pinnedDigest(imageName string) string {
+	named, err := imageref.Parse(imageName)
+	if err != nil {
+		return ""
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		return digested.Digest().String()
+	}
+	return ""
+}
+
+// pinSource makes the migration of a source given by digest copy that
+// index: every platform is pulled by the digest the index lists for it,
+// and the manifest list pushed for it lists them in the order of the index,
+// with its media type and annotations
+func pinSource(m *migration) {
+	digest := pinnedDigest(m.source)
+	if digest == "" || m.options.LocalOnly {
+		return
+	}
+	if m.options.SourceDigest == "" {
+		m.options.SourceDigest = digest
+	}
+	m.options.PinDigests = true
+	if m.target != "" && m.options.CreateMultiArch {
+		m.options.SourceOrder, m.options.PreserveIndex = true, true
+	}
+}
+
+// checkIndexCopy warns when the manifest list pushed for a source given by
+// digest doesn't list the manifests of the source index, because the daemon
+// pushed some platforms under digests of their own
+func (c *Client) checkIndexCopy(m migration, result *TaskResult) {
+	if result.ManifestList == "" || pinnedDigest(m.source) == "" || !m.options.Transform.IsZero() {
+		return
+	}
+	var changed []string
+	for _, platform := range result.Platforms {
+		if platform.Pushed != nil && platform.Pushed.Digest != "" && platform.SourceDigest != "" && platform.Pushed.Digest != platform.SourceDigest {
+			changed = append(changed, platform.Platform)
+		}
+	}
+	if len(changed) > 0 {
+		c.warnf("The daemon pushed %s of %s under new digests, so %s doesn't list the manifests of the source index; verbatim copies it byte for byte",
+			strings.Join(changed, ", "), m.source, m.target)
+	}
+}
@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/docker/docker/api/types"
+)
+
+// DefaultDiskTimeout is how long a platform waits for the disk usage of
+// the daemon to drop below its limit unless DiskLimit says otherwise
+const DefaultDiskTimeout = time.Hour
+
+// diskPollInterval is how often the disk usage is queried while waiting
+const diskPollInterval = 30 * time.Second
+
+// DiskLimit keeps the disk usage of the docker daemon, as docker system df
+// totals it, below Max bytes while platforms are pulled
+type DiskLimit struct {
+	Max int64
+	// Prune removes the local tags the run created for earlier tasks when
+	// the limit is exceeded, as the run would when it finishes
+	Prune bool
+	// Timeout is how long a platform waits before it fails, or
+	// DefaultDiskTimeout when zero
+	Timeout time.Duration
+}
+
+// WithDiskLimit queries the disk usage of the daemon before every platform
+// pull and waits while it exceeds the limit. The wait counts towards the
+// task timeouts and is recorded as the Paused time of the task.
+func WithDiskLimit(limit DiskLimit) ClientOption {
+	return func(c *Client) {
+		c.diskLimit = limit
+	}
+}
+
+// DaemonDiskUsage returns the bytes the daemon stores for images,
+// containers, volumes and the build cache
+func (c *Client) DaemonDiskUsage(ctx context.Context) (int64, error) {
+	du, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the disk usage of the docker daemon: %w", err)
+	}
+	usage := du.LayersSize
+	for _, container := range du.Containers {
+		usage += container.SizeRw
+	}
+	for _, volume := range du.Volumes {
+		if volume.UsageData != nil && volume.UsageData.Size > 0 {
+			usage += volume.UsageData.Size
+		}
+	}
+	for _, cache := range du.BuildCache {
+		if !cache.Shared {
+			usage += cache.Size
+		}
+	}
+	return usage, nil
+}
+
+// waitForDisk blocks while the disk usage of the daemon exceeds the limit,
+// before platform of m is pulled, and returns how long it waited. keep
+// lists the local tags of the task, which are never pruned. It fails once
+// the timeout passes or the task ends; a usage it can't query is warned
+// about and not waited for.
+func (c *Client) waitForDisk(m migration, platform string, keep []string) (time.Duration, error) {
+	if c.diskLimit.Max <= 0 {
+		return 0, nil
+	}
+	usage, err := c.DaemonDiskUsage(m.ctx)
+	if err != nil {
+		c.warnf("%v, pulling %s %s without checking the disk limit", err, m.source, platform)
+		return 0, nil
+	}
+	if usage <= c.diskLimit.Max {
+		return 0, nil
+	}
+
+	wait := DiskWaited{Source: m.source, Platform: platform, Usage: usage, Limit: c.diskLimit.Max, Start: time.Now()}
+	defer func() {
+		wait.End = time.Now()
+		c.emit(wait)
+	}()
+	c.infof("The docker daemon uses %s of disk, above the limit of %s, pausing before %s %s",
+		progress.FormatBytes(usage), progress.FormatBytes(c.diskLimit.Max), m.source, platform)
+
+	if c.diskLimit.Prune {
+		wait.Pruned = c.pruneIntermediate(keep)
+		if len(wait.Pruned) > 0 {
+			if usage, err = c.DaemonDiskUsage(m.ctx); err != nil {
+				c.warnf("%v, pulling %s %s without checking the disk limit", err, m.source, platform)
+				return time.Since(wait.Start), nil
+			}
+		}
+	}
+
+	timeout := c.diskLimit.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDiskTimeout
+	}
+	deadline := wait.Start.Add(timeout)
+	for usage > c.diskLimit.Max {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			wait.Err = &errdefs.DiskFullError{Err: fmt.Errorf("the docker daemon still uses %s of disk after waiting %s for it to drop below %s",
+				progress.FormatBytes(usage), timeout, progress.FormatBytes(c.diskLimit.Max))}
+			return time.Since(wait.Start), wait.Err
+		}
+		select {
+		case <-m.ctx.Done():
+			wait.Err = timeoutError(m.ctx, "Waiting for disk space for "+m.source+" "+platform, m.ctx.Err())
+			return time.Since(wait.Start), wait.Err
+		case <-time.After(min(remaining, diskPollInterval)):
+		}
+		if usage, err = c.DaemonDiskUsage(m.ctx); err != nil {
+			if m.ctx.Err() != nil {
+				continue
+			}
+			c.warnf("%v, pulling %s %s without checking the disk limit", err, m.source, platform)
+			return time.Since(wait.Start), nil
+		}
+	}
+
+	waited := time.Since(wait.Start)
+	c.infof("The docker daemon uses %s of disk, resuming %s %s after a pause of %s",
+		progress.FormatBytes(usage), m.source, platform, waited.Round(time.Second))
+	return waited, nil
+}
+
+// pruneIntermediate removes the local tags of the run but those of keep,
+// and returns those it removed
+func (c *Client) pruneIntermediate(keep []string) []string {
+	c.intermediateMu.Lock()
+	var tags []string
+	for _, tag := range c.intermediate {
+		if !slices.Contains(keep, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	c.intermediateMu.Unlock()
+	if len(tags) == 0 {
+		return nil
+	}
+
+	failed, err := c.RemoveTags(tags)
+	if err != nil {
+		c.warnf("Failed to prune %d local tags of run %s: %v", len(failed), c.runID, err)
+	}
+	pruned := slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(failed, tag) })
+
+	c.intermediateMu.Lock()
+	c.intermediate = slices.DeleteFunc(c.intermediate, func(tag string) bool { return slices.Contains(pruned, tag) })
+	c.intermediateMu.Unlock()
+	if len(pruned) > 0 {
+		c.infof("Pruned %d local tags of run %s to free disk space", len(pruned), c.runID)
+	}
+	return pruned
+}
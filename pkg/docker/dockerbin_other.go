@@ -0,0 +1,8 @@
+//go:build !windows
+
+package docker
+
+// defaultDockerBinary returns the docker CLI run when no other binary is set
+func defaultDockerBinary() string {
+	return DefaultDockerBinary
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package docker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// defaultDockerBinary returns the docker CLI run when no other binary is
+// set: docker.exe from PATH, or else the one Docker Desktop installs, which
+// isn't on PATH until Docker Desktop added it
+func defaultDockerBinary() string {
+	if _, err := exec.LookPath("docker.exe"); err == nil {
+		return DefaultDockerBinary
+	}
+	for _, dir := range []string{os.Getenv("ProgramFiles"), os.Getenv("ProgramW6432")} {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, "Docker", "Docker", "resources", "bin", "docker.exe")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return DefaultDockerBinary
+}
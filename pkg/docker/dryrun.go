@@ -0,0 +1,34 @@
+package docker
+
+// WithDryRun makes tasks resolve the platforms they select and log what they
+// would pull, save and push, without changing the daemon, the filesystem or
+// any registry. Results are marked DryRun and list the planned platforms
+// with the names they would get.
+func WithDryRun() ClientOption {
+	return func(c *Client) {
+		c.dryRun = true
+	}
+}
+
+// planPlatforms records the platforms a dry run selected in result
+func (c *Client) planPlatforms(m migration, result *TaskResult, platforms []Platform) {
+	result.DryRun = true
+	for _, platform := range platforms {
+		platformStr := platform.String()
+		name := platformName(m, platformStr)
+		switch {
+		case m.options.LocalOnly && m.target == "":
+			c.infof("Dry run: the local %s would be saved as %s", platformStr, name)
+		case m.options.LocalOnly:
+			c.infof("Dry run: the local %s would be pushed as %s", platformStr, name)
+		case m.target == "":
+			c.infof("Dry run: %s would be pulled and saved as %s", platformStr, name)
+		default:
+			c.infof("Dry run: %s would be pulled and pushed as %s", platformStr, name)
+		}
+		result.Platforms = append(result.Platforms, PlatformResult{Platform: platformStr, SourceDigest: platform.Digest, Tag: name})
+	}
+	if m.target != "" && m.options.CreateMultiArch {
+		c.infof("Dry run: the manifest list %s-allarch would be pushed, and tagged %s", m.target, m.target)
+	}
+}
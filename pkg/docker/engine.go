@@ -0,0 +1,21 @@
+package docker
+
+import "github.com/docker/docker/client"
+
+// WithEngine uses an Engine API client created by the caller instead of one
+// configured from the environment. The docker CLI still connects to the
+// daemon of the environment, so the client should reach the same one.
+func WithEngine(engine *client.Client) ClientOption {
+	return func(c *Client) {
+		c.engine = engine
+	}
+}
+
+// WithDockerHost connects the docker CLI and the Engine API client to the
+// daemon at host, such as unix:///run/user/1000/docker.sock or
+// tcp://build-host:2376, instead of the one DOCKER_HOST names
+func WithDockerHost(host string) ClientOption {
+	return func(c *Client) {
+		c.dockerHost = host
+	}
+}
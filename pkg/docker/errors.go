@@ -0,0 +1,15 @@
+package docker
+
+import "github.com/Fr000g/ImgMigrate/pkg/errdefs"
+
+// Failure classes of errors returned by the client, for use with errors.Is.
+// They are the sentinels of package errdefs, which also provides typed
+// errors and report categories.
+var (
+	ErrAuth        = errdefs.ErrAuthentication
+	ErrForbidden   = errdefs.ErrAuthorization
+	ErrNotFound    = errdefs.ErrNotFound
+	ErrRateLimited = errdefs.ErrRateLimited
+	ErrNetwork     = errdefs.ErrNetwork
+	ErrDiskFull    = errdefs.ErrDiskFull
+)
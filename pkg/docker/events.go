@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Event is emitted by the client as an image is processed. Handlers receive
+// one of the concrete event types below and should switch on the type.
+type Event interface {
+	// Type returns a stable name for the event kind
+	Type() string
+}
+
+// TaskStarted is emitted when processing of a source image begins
+type TaskStarted struct {
+	Source string
+	Target string
+}
+
+// PlatformPullProgress is emitted while a platform is being pulled
+type PlatformPullProgress struct {
+	Source   string
+	Platform string
+	Bytes    int64
+	Total    int64
+}
+
+// PlatformSaveProgress is emitted while a platform is being written to an
+// archive. Bytes counts the uncompressed archive stream; Total is the image
+// size, which is an estimate of the final stream length.
+type PlatformSaveProgress struct {
+	Source   string
+	Platform string
+	Bytes    int64
+	Total    int64
+}
+
+// PlatformPushProgress is emitted while a platform is being pushed
+type PlatformPushProgress struct {
+	Source   string
+	Platform string
+	Bytes    int64
+	Total    int64
+}
+
+// PlatformPulled is emitted after a platform has been pulled. PulledFrom is
+// the reference it was pulled from, which differs from the source when a
+// mirror served it.
+type PlatformPulled struct {
+	Source     string
+	Platform   string
+	PulledFrom string
+}
+
+// PlatformSaved is emitted after a platform has been written to an archive.
+// SHA256 is the checksum of the archive; it is empty for images added to a
+// blob store.
+type PlatformSaved struct {
+	Source   string
+	Platform string
+	Tag      string
+	Path     string
+	Size     int64
+	SHA256   string
+}
+
+// PlatformPushed is emitted after a platform has been pushed to the target registry
+type PlatformPushed struct {
+	Source   string
+	Platform string
+	Tag      string
+	Digest   string
+}
+
+// PlatformFailed is emitted when a stage fails for a platform
+type PlatformFailed struct {
+	Source   string
+	Platform string
+	Stage    string
+	Err      error
+}
+
+// ManifestCreated is emitted after the multi-architecture manifest list has been created
+type ManifestCreated struct {
+	Source       string
+	ManifestList string
+	Images       []string
+}
+
+// SourceChanged is emitted when the source tag moved to another digest
+// while its task ran; Action is what the task did about it
+type SourceChanged struct {
+	Source string
+	From   string
+	To     string
+	Action string
+}
+
+// DiskWaited is emitted once a platform waited for the disk usage of the
+// daemon, Usage when the wait started, to drop below Limit. Pruned lists
+// the local tags removed to free space, and Err is set when the platform
+// gave up waiting.
+type DiskWaited struct {
+	Source   string
+	Platform string
+	Usage    int64
+	Limit    int64
+	Start    time.Time
+	End      time.Time
+	Pruned   []string
+	Err      error
+}
+
+// TaskCompleted is emitted when processing of a source image finishes,
+// successfully or not
+type TaskCompleted struct {
+	Result *TaskResult
+	Err    error
+}
+
+// OperationHeartbeat is emitted every heartbeat interval while an
+// operation is in flight, so that long silent pulls, saves and pushes show
+// signs of life
+type OperationHeartbeat struct {
+	// Operation is what is being done, such as "pulling"
+	Operation string
+	// Subject is what it is done to, such as "nginx:1.25 linux/arm64"
+	Subject string
+	Elapsed time.Duration
+}
+
+// String describes the operation as "Still pulling nginx:1.25 linux/arm64,
+// 14m0s elapsed"
+func (h OperationHeartbeat) String() string {
+	return fmt.Sprintf("Still %s %s, %s elapsed", h.Operation, h.Subject, h.Elapsed.Round(time.Second))
+}
+
+func (TaskStarted) Type() string          { return "task_started" }
+func (PlatformPullProgress) Type() string { return "platform_pull_progress" }
+func (PlatformSaveProgress) Type() string { return "platform_save_progress" }
+func (PlatformPushProgress) Type() string { return "platform_push_progress" }
+func (PlatformPulled) Type() string       { return "platform_pulled" }
+func (PlatformSaved) Type() string        { return "platform_saved" }
+func (PlatformPushed) Type() string       { return "platform_pushed" }
+func (PlatformFailed) Type() string       { return "platform_failed" }
+func (ManifestCreated) Type() string      { return "manifest_created" }
+func (SourceChanged) Type() string        { return "source_changed" }
+func (DiskWaited) Type() string           { return "disk_waited" }
+func (TaskCompleted) Type() string        { return "task_completed" }
+func (OperationHeartbeat) Type() string   { return "heartbeat" }
+
+// EventHandler receives events emitted by the client. Events are delivered
+// one at a time, so handlers don't need their own locking even when
+// platforms are processed concurrently.
+type EventHandler interface {
+	HandleEvent(Event)
+}
+
+// EventHandlerFunc adapts a function to the EventHandler interface
+type EventHandlerFunc func(Event)
+
+// HandleEvent calls f(e)
+func (f EventHandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithEventHandler registers a handler that receives every event emitted by the client
+func WithEventHandler(handler EventHandler) ClientOption {
+	return func(c *Client) {
+		c.handlers = append(c.handlers, handler)
+	}
+}
+
+// WithLogger sets the logger that receives progress messages, warnings and
+// errors. It defaults to plain lines on os.Stdout.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.log = logger
+	}
+}
+
+// emit delivers an event to all registered handlers
+func (c *Client) emit(e Event) {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	for _, handler := range c.handlers {
+		handler.HandleEvent(e)
+	}
+}
@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// DefaultDockerBinary is the docker CLI run when no other binary is set
+const DefaultDockerBinary = "docker"
+
+// WithDockerBinary sets the docker CLI to run, by name or path
+func WithDockerBinary(path string) ClientOption {
+	return func(c *Client) {
+		if path != "" {
+			c.dockerBin = path
+		}
+	}
+}
+
+// WithSudo runs the docker CLI through non-interactive sudo, for hosts where
+// only root may use docker. The Engine API is then reached through
+// docker system dial-stdio run the same way.
+func WithSudo() ClientOption {
+	return func(c *Client) {
+		c.sudo = true
+	}
+}
+
+// Runner builds the commands that run the docker CLI, for embedding
+// programs that run it their own way, such as in a container or through a
+// fake in tests. The client attaches the standard streams and runs them.
+type Runner interface {
+	Command(ctx context.Context, args ...string) *exec.Cmd
+}
+
+// RunnerFunc adapts a function to the Runner interface
+type RunnerFunc func(ctx context.Context, args ...string) *exec.Cmd
+
+// Command calls f(ctx, args...)
+func (f RunnerFunc) Command(ctx context.Context, args ...string) *exec.Cmd {
+	return f(ctx, args...)
+}
+
+// WithRunner builds the docker CLI commands with runner instead of running
+// the docker binary. It can't be combined with WithDockerBinary or WithSudo,
+// which choose how the binary is run.
+func WithRunner(runner Runner) ClientOption {
+	return func(c *Client) {
+		c.runner = runner
+	}
+}
+
+// killWait is how long a command killed when its context ends may keep
+// its output open, through processes it started, before it is abandoned
+const killWait = 5 * time.Second
+
+// command returns the command running the docker CLI with args, through
+// the runner or sudo if configured. It is killed when ctx ends.
+func (c *Client) command(ctx context.Context, args ...string) *exec.Cmd {
+	if c.dockerHost != "" {
+		args = append([]string{"--host", c.dockerHost}, args...)
+	}
+	var cmd *exec.Cmd
+	if c.runner != nil {
+		cmd = c.runner.Command(ctx, args...)
+	} else if c.sudo {
+		cmd = exec.CommandContext(ctx, "sudo", append([]string{"-n", "--", c.dockerBin}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, c.dockerBin, args...)
+	}
+	cmd.WaitDelay = killWait
+	return cmd
+}
+
+// commandLine returns args as the command line that is run, for logs
+func (c *Client) commandLine(args []string) string {
+	if c.dockerHost != "" {
+		args = append([]string{"--host", c.dockerHost}, args...)
+	}
+	parts := append([]string{c.dockerBin}, args...)
+	if c.sudo {
+		parts = append([]string{"sudo"}, parts...)
+	}
+	return strings.Join(parts, " ")
+}
+
+// runError names the docker binary in errors starting the command, such
+// as a missing executable. Failures of the command itself are returned as is.
+func (c *Client) runError(err error) error {
+	var exitErr *exec.ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		return err
+	}
+	return fmt.Errorf("failed to run %s: %w", c.commandLine(nil), err)
+}
+
+// runDocker runs a docker CLI command and returns its combined output
+func (c *Client) runDocker(args ...string) ([]byte, error) {
+	return c.runDockerInput(nil, args...)
+}
+
+// runDockerInput runs a docker CLI command with stdin attached and returns
+// its combined output
+func (c *Client) runDockerInput(stdin io.Reader, args ...string) ([]byte, error) {
+	return c.runCommand(c.ctx, stdin, args...)
+}
+
+// runCommand runs a docker CLI command within ctx, with stdin attached if
+// set, and returns its combined output. The command line, duration and
+// output are logged at debug level, and a failure is classified from the
+// output.
+func (c *Client) runCommand(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := c.command(ctx, args...)
+	cmd.Stdin = stdin
+	defer c.inFlight("running", redact.String("docker "+strings.Join(args, " ")))()
+
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	err = c.runError(err)
+	c.logCommand(args, output, err, time.Since(start))
+
+	return output, errdefs.Classify(err, string(output))
+}
+
+// logCommand records an executed docker command at debug level, with
+// registered secrets masked
+func (c *Client) logCommand(args []string, output []byte, err error, elapsed time.Duration) {
+	attrs := []any{
+		"command", redact.String(c.commandLine(args)),
+		"duration", elapsed,
+		"output", redact.String(string(output)),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", redact.String(err.Error()))
+	}
+	c.log.Debug("docker command finished", attrs...)
+}
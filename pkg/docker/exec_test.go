@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeDocker writes a docker and a sudo script to a temporary directory
+// put first on PATH. Both append their command line to the returned log
+// file; sudo then runs the command it was given, as sudo -n -- does.
+func fakeDocker(t *testing.T) (bin, log string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake docker CLI is a shell script")
+	}
+	dir := t.TempDir()
+	log = filepath.Join(dir, "commands.log")
+	bin = filepath.Join(dir, "docker")
+	scripts := map[string]string{
+		"docker": "#!/bin/sh\necho \"$0 $*\" >> " + log + "\necho 'Docker version 28.1.1, build 4eba377'\n",
+		"sudo":   "#!/bin/sh\necho \"sudo $*\" >> " + log + "\n[ \"$1\" = -n ] && [ \"$2\" = -- ] || exit 1\nshift 2\nexec \"$@\"\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return bin, log
+}
+
+// commands returns the command lines the fake docker CLI logged
+func commands(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func equalLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDockerBinaryAndSudo(t *testing.T) {
+	bin, _ := fakeDocker(t)
+	tests := []struct {
+		name string
+		opts []ClientOption
+		want []string
+	}{
+		{
+			name: "docker binary",
+			opts: []ClientOption{WithDockerBinary(bin)},
+			want: []string{
+				bin + " --version",
+				bin + " image inspect --format {{.Size}} nginx:1.25",
+			},
+		},
+		{
+			name: "sudo",
+			opts: []ClientOption{WithDockerBinary(bin), WithSudo()},
+			want: []string{
+				"sudo -n -- " + bin + " --version",
+				bin + " --version",
+				"sudo -n -- " + bin + " image inspect --format {{.Size}} nginx:1.25",
+				bin + " image inspect --format {{.Size}} nginx:1.25",
+			},
+		},
+		{
+			name: "sudo and docker host",
+			opts: []ClientOption{WithDockerBinary(bin), WithSudo(), WithDockerHost("tcp://build-host:2375")},
+			want: []string{
+				"sudo -n -- " + bin + " --host tcp://build-host:2375 --version",
+				bin + " --host tcp://build-host:2375 --version",
+				"sudo -n -- " + bin + " --host tcp://build-host:2375 image inspect --format {{.Size}} nginx:1.25",
+				bin + " --host tcp://build-host:2375 image inspect --format {{.Size}} nginx:1.25",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := filepath.Join(filepath.Dir(bin), "commands.log")
+			os.Remove(log)
+			c, err := NewClient(tt.opts...)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			c.imageSize("nginx:1.25")
+			equalLines(t, commands(t, log), tt.want)
+		})
+	}
+}
+
+func TestRunner(t *testing.T) {
+	bin, log := fakeDocker(t)
+	var calls [][]string
+	runner := RunnerFunc(func(ctx context.Context, args ...string) *exec.Cmd {
+		calls = append(calls, args)
+		return exec.CommandContext(ctx, bin, args...)
+	})
+	c, err := NewClient(WithRunner(runner), WithDockerHost("unix:///run/user/1000/docker.sock"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := c.runDocker("image", "ls"); err != nil {
+		t.Fatalf("runDocker: %v", err)
+	}
+
+	want := []string{
+		"--host unix:///run/user/1000/docker.sock --version",
+		"--host unix:///run/user/1000/docker.sock image ls",
+	}
+	var got []string
+	for _, args := range calls {
+		got = append(got, strings.Join(args, " "))
+	}
+	equalLines(t, got, want)
+	equalLines(t, commands(t, log), []string{bin + " " + want[0], bin + " " + want[1]})
+}
+
+func TestRunnerConflicts(t *testing.T) {
+	runner := RunnerFunc(func(ctx context.Context, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "true")
+	})
+	for _, opt := range []ClientOption{WithSudo(), WithDockerBinary("/opt/docker/bin/docker")} {
+		if _, err := NewClient(WithRunner(runner), opt); err == nil {
+			t.Error("NewClient accepted WithRunner with WithSudo or WithDockerBinary")
+		}
+	}
+}
+
+func TestMissingBinary(t *testing.T) {
+	bin, _ := fakeDocker(t)
+	missing := filepath.Join(filepath.Dir(bin), "missing", "docker")
+	for _, opts := range [][]ClientOption{
+		{WithDockerBinary(missing)},
+		{WithDockerBinary(missing), WithSudo()},
+	} {
+		_, err := NewClient(opts...)
+		if err == nil {
+			t.Fatalf("NewClient succeeded with the missing binary %s", missing)
+		}
+		if !strings.Contains(err.Error(), missing) {
+			t.Errorf("error %q doesn't name the binary %s", err, missing)
+		}
+	}
+}
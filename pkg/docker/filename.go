@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// fileNameRules are the rules of the file system archives are saved to,
+// which decide how image names and platforms become file names
+type fileNameRules struct {
+	// windows replaces the characters NTFS rejects, such as the colon of a
+	// tag, and renames the reserved device names
+	windows bool
+}
+
+// hostRules are the file name rules of the host the tool runs on
+var hostRules = fileNameRules{windows: runtime.GOOS == "windows"}
+
+// windowsReserved are the device names Windows reserves, with or without
+// an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// fileName turns an image name or platform into a file name, keeping
+// different names apart. The slashes of the repository become dashes, so
+// its own dashes are doubled: team/app is saved as team-app, team-app as
+// team--app. On Windows the colons before a port or tag become
+// underscores, so underscores are doubled; upper case letters get a caret
+// before them, since NTFS ignores case; and the other characters it
+// rejects become underscores.
+func (r fileNameRules) fileName(name string) string {
+	// The tag follows the last colon after the last slash, and holds no
+	// slash
+	repo, tag := name, ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		repo, tag = name[:i], name[i:]
+	}
+	name = strings.ReplaceAll(strings.ReplaceAll(repo, "-", "--"), "/", "-") + tag
+	if !r.windows {
+		return name
+	}
+
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c == '_':
+			b.WriteString("__")
+		case c == ':':
+			b.WriteByte('_')
+		case c == '\\':
+			b.WriteByte('-')
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte('^')
+			b.WriteRune(unicode.ToLower(c))
+		case c < 0x20, strings.ContainsRune(`<>"|?*`, c):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	name = b.String()
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	return name
+}
+
+// archivePath returns the path the archive of a platform, named name, is
+// saved to below dir: in a directory of its own named after the platform
+// with split, and with extension ext
+func (r fileNameRules) archivePath(dir, name, platform string, split bool, ext string) string {
+	if split {
+		dir = filepath.Join(dir, r.fileName(platform))
+	}
+	return filepath.Join(dir, r.fileName(name)+ext)
+}
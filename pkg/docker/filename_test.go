@@ -0,0 +1,256 @@
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Fr000g/ImgMigrate/internal/fuzztest"
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/runs"
+	"github.com/distribution/reference"
+)
+
+var (
+	unixRules    = fileNameRules{}
+	windowsRules = fileNameRules{windows: true}
+)
+
+// windowsInvalid reports why NTFS rejects a file name, or "" if it doesn't
+func windowsInvalid(name string) string {
+	for _, c := range name {
+		if c < 0x20 || strings.ContainsRune(`<>:"|?*/\`, c) {
+			return "it holds " + strings.TrimSpace(string(c))
+		}
+	}
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(base)] {
+		return "it is the reserved name " + base
+	}
+	return ""
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		unix    string
+		windows string
+	}{
+		{"nginx:1.25-linux-amd64", "nginx:1.25-linux-amd64", "nginx_1.25-linux-amd64"},
+		{"registry.example.com:5000/team/app:v1-linux-arm-v7", "registry.example.com:5000-team-app:v1-linux-arm-v7", "registry.example.com_5000-team-app_v1-linux-arm-v7"},
+		{"linux/arm64/v8", "linux-arm64-v8", "linux-arm64-v8"},
+		// Names only differing in / and -, : and _ or in case stay apart
+		{"team/app:v1-linux-amd64", "team-app:v1-linux-amd64", "team-app_v1-linux-amd64"},
+		{"team-app:v1-linux-amd64", "team--app:v1-linux-amd64", "team--app_v1-linux-amd64"},
+		{"my-host.example.com/my_app:v1_2", "my--host.example.com-my_app:v1_2", "my--host.example.com-my__app_v1__2"},
+		{"app:V1", "app:V1", "app_^v1"},
+		// A name must not become a drive-relative or absolute Windows path
+		{`c:/images/nginx`, "c:-images-nginx", "c_-images-nginx"},
+		{`C:\images\nginx`, `C:\images\nginx`, "^c_-images-nginx"},
+		{`team\app`, `team\app`, "team-app"},
+		{`a<b>c"d|e?f*g`, `a<b>c"d|e?f*g`, "a_b_c_d_e_f_g"},
+		{"tab\there", "tab\there", "tab_here"},
+		// Reserved device names, with or without an extension
+		{"con", "con", "_con"},
+		{"aux", "aux", "_aux"},
+		{"AUX", "AUX", "^a^u^x"},
+		{"nul.tar", "nul.tar", "_nul.tar"},
+		{"com1.backup.tar.gz", "com1.backup.tar.gz", "_com1.backup.tar.gz"},
+		{"lpt9", "lpt9", "_lpt9"},
+		{"console", "console", "console"},
+		{"con:1.0", "con:1.0", "con_1.0"},
+	}
+	for _, tt := range tests {
+		if got := unixRules.fileName(tt.name); got != tt.unix {
+			t.Errorf("unix fileName(%q) = %q, want %q", tt.name, got, tt.unix)
+		}
+		got := windowsRules.fileName(tt.name)
+		if got != tt.windows {
+			t.Errorf("windows fileName(%q) = %q, want %q", tt.name, got, tt.windows)
+		}
+		if reason := windowsInvalid(got); reason != "" {
+			t.Errorf("windows fileName(%q) = %q is invalid: %s", tt.name, got, reason)
+		}
+	}
+}
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		rules fileNameRules
+		split bool
+		want  string
+	}{
+		{unixRules, false, filepath.Join("out", "registry.example.com:5000-team-app:v1-linux-arm-v7.tar.gz")},
+		{unixRules, true, filepath.Join("out", "linux-arm-v7", "registry.example.com:5000-team-app:v1-linux-arm-v7.tar.gz")},
+		{windowsRules, false, filepath.Join("out", "registry.example.com_5000-team-app_v1-linux-arm-v7.tar.gz")},
+		{windowsRules, true, filepath.Join("out", "linux-arm-v7", "registry.example.com_5000-team-app_v1-linux-arm-v7.tar.gz")},
+	}
+	for _, tt := range tests {
+		got := tt.rules.archivePath("out", "registry.example.com:5000/team/app:v1-linux-arm-v7", "linux/arm/v7", tt.split, ".tar.gz")
+		if got != tt.want {
+			t.Errorf("archivePath(windows=%v, split=%v) = %s, want %s", tt.rules.windows, tt.split, got, tt.want)
+		}
+	}
+}
+
+// writeTestArchive writes a gzip compressed tar holding a file with content
+// to path; Validate reports it lacks an image, but checks its checksum
+func writeTestArchive(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: "content", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSaveFlowWindowsRules saves archives named by the Windows rules and
+// records them as a save does: in the SHA256SUMS file and the run index of
+// their directory, which validate-archive and push-archive read back
+func TestSaveFlowWindowsRules(t *testing.T) {
+	bin, _ := fakeDocker(t)
+	c, err := NewClient(WithDockerBinary(bin), WithRunID("3f9a0c1e"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	dir := t.TempDir()
+	saves := []struct {
+		source   string
+		platform string
+		split    bool
+	}{
+		{"registry.example.com:5000/team/app:v1", "linux/amd64", false},
+		{"registry.example.com:5000/team/app:v1", "linux/arm/v7", true},
+		{"con:1.0", "windows/amd64", false},
+		{"nul", "linux/arm64/v8", false},
+	}
+	for i, save := range saves {
+		name := archTag(save.source, save.platform)
+		path := windowsRules.archivePath(dir, name, save.platform, save.split, archive.Extension(archive.Gzip))
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			if reason := windowsInvalid(part); reason != "" {
+				t.Errorf("%s of %s is saved as %s, which is invalid: %s", save.platform, save.source, rel, reason)
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestArchive(t, path, name)
+		sum, _, err := fileChecksum(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := "sha256:" + strings.Repeat(string(rune('a'+i)), 64)
+		if err := c.recordChecksum(path, sum, save.source, digest); err != nil {
+			t.Fatalf("recordChecksum(%s): %v", rel, err)
+		}
+
+		if v := archive.Validate(path); !v.Checksum {
+			t.Errorf("%s isn't matched with its SHA256SUMS entry: %v", rel, v.Problems)
+		}
+		saved, err := runs.Find(path)
+		if err != nil {
+			t.Fatalf("runs.Find(%s): %v", rel, err)
+		}
+		if saved == nil || saved.Source != save.source || saved.SourceDigest != digest {
+			t.Errorf("run index records %s as %+v, want %s at %s", rel, saved, save.source, digest)
+		}
+	}
+
+	// Saving an archive again replaces its SHA256SUMS entry
+	path := windowsRules.archivePath(dir, archTag(saves[0].source, saves[0].platform), saves[0].platform, false, archive.Extension(archive.Gzip))
+	writeTestArchive(t, path, "saved again")
+	sum, _, err := fileChecksum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.recordChecksum(path, sum, saves[0].source, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, archive.ChecksumFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), filepath.Base(path)); n != 1 {
+		t.Errorf("%s lists %s %d times:\n%s", archive.ChecksumFile, filepath.Base(path), n, data)
+	}
+	if v := archive.Validate(path); !v.Checksum {
+		t.Errorf("%s isn't matched with its new SHA256SUMS entry: %v", filepath.Base(path), v.Problems)
+	}
+}
+
+// FuzzFileName checks that file names hold no path separator, and none of
+// the characters and names Windows rejects under its rules
+func FuzzFileName(f *testing.F) {
+	for _, name := range []string{"nginx:1.25-linux-amd64", `C:\images\nginx`, "con", "nul.tar", "a<b>c\x00d"} {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		if got := unixRules.fileName(name); strings.Contains(got, "/") {
+			t.Errorf("unix fileName(%q) = %q holds a path separator", name, got)
+		}
+		// Archive names are followed by their extension
+		got := windowsRules.fileName(name) + ".tar"
+		if reason := windowsInvalid(got); reason != "" {
+			t.Errorf("windows fileName(%q) = %q is invalid: %s", name, got, reason)
+		}
+	})
+}
+
+// FuzzArchiveNames checks that the archives of different repositories,
+// tags and platforms are saved to different files, as the default naming
+// names them
+func FuzzArchiveNames(f *testing.F) {
+	f.Add("registry.example.com:5000/team/app", "1.25", "registry.example.com:5000/team/app", "1.25-linux", uint8(0), uint8(1), false)
+	f.Add("nginx", "1.25-linux-arm64", "nginx", "1.25", uint8(1), uint8(0), true)
+	f.Add("team/app", "v1", "team-app", "v1", uint8(0), uint8(0), false)
+	f.Add("host:5000/app", "v1_2", "host_5000/app", "v1:2", uint8(0), uint8(0), false)
+	f.Add("app", "V1", "app", "v1", uint8(2), uint8(2), true)
+	f.Add("con", "v1", "con", "v1-linux-arm", uint8(3), uint8(0), false)
+	f.Fuzz(func(t *testing.T, repo1, tag1, repo2, tag2 string, platform1, platform2 uint8, split bool) {
+		for _, repo := range []string{repo1, repo2} {
+			if named, err := reference.ParseNormalizedNamed(repo); err != nil || !reference.IsNameOnly(named) {
+				return
+			}
+		}
+		for _, image := range []string{repo1 + ":" + tag1, repo2 + ":" + tag2} {
+			if _, err := reference.ParseNormalizedNamed(image); err != nil {
+				return
+			}
+		}
+		p1, p2 := fuzztest.Platform(platform1), fuzztest.Platform(platform2)
+		if repo1 == repo2 && tag1 == tag2 && p1 == p2 {
+			return
+		}
+		for _, rules := range []fileNameRules{unixRules, windowsRules} {
+			path1 := rules.archivePath("out", archTag(repo1+":"+tag1, p1), p1, split, ".tar")
+			path2 := rules.archivePath("out", archTag(repo2+":"+tag2, p2), p2, split, ".tar")
+			if path1 == path2 || (rules.windows && strings.EqualFold(path1, path2)) {
+				t.Errorf("%s of %s:%s and %s of %s:%s are both saved to %s (windows=%v)", p1, repo1, tag1, p2, repo2, tag2, path1, rules.windows)
+			}
+		}
+	})
+}
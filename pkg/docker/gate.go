@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"context"
+	"time"
+)
+
+// WithPlatformGate calls gate with the source and platform of every
+// platform of a task but the first, before migrating it. It blocks while the platforms should wait, such as outside a
+// transfer window, and returns how long it did, which is recorded as the
+// Paused time of the task. The wait counts towards the task timeouts.
+func WithPlatformGate(gate func(ctx context.Context, source, platform string) time.Duration) ClientOption {
+	return func(c *Client) {
+		c.platformGate = gate
+	}
+}
+
+// WithPlatformBudget asks allows before every platform of a task is
+// started. Once it returns false, that platform and the rest of the task
+// are deferred: they are recorded as Deferred without being transferred,
+// and the task creates no manifest list that would leave them out.
+func WithPlatformBudget(allows func() bool) ClientOption {
+	return func(c *Client) {
+		c.platformBudget = allows
+	}
+}
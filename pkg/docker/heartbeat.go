@@ -0,0 +1,35 @@
+package docker
+
+import "time"
+
+// WithHeartbeat emits an OperationHeartbeat every interval while a pull,
+// save, push, registry copy or docker command is in flight. Zero, the
+// default, emits none.
+func WithHeartbeat(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.heartbeat = interval
+	}
+}
+
+// inFlight emits heartbeats for an operation until the returned function
+// is called
+func (c *Client) inFlight(operation, subject string) func() {
+	if c.heartbeat <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(c.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.emit(OperationHeartbeat{Operation: operation, Subject: subject, Elapsed: time.Since(start)})
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,405 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/docker/docker/api/types/image"
+)
+
+// PullImageOptions configures PullImage
+type PullImageOptions struct {
+	// Platform selects the image of a multi-platform reference, in
+	// os/arch[/variant] form; empty pulls the daemon's own platform
+	Platform string
+	// ByDigest resolves the reference through the registry first and pulls
+	// that digest, so a tag moved during the pull can't mix images
+	ByDigest bool
+	// Auth authenticates to the registry of the reference, if it belongs to
+	// it; credentials configured for the registry are used otherwise
+	Auth RegistryAuth
+	// Progress receives the aggregate layer download progress
+	Progress func(current, total int64)
+}
+
+// PullResult describes a pulled image
+type PullResult struct {
+	// Reference is the reference pulled, pinned to its digest with ByDigest
+	Reference string
+	// Digest is the digest the daemon pulled, if it reported it
+	Digest string
+	// Size is the uncompressed size of the pulled image, or 0 if unknown
+	Size int64
+}
+
+// PullImage pulls an image into the daemon
+func (c *Client) PullImage(ctx context.Context, ref string, opts PullImageOptions) (PullResult, error) {
+	result := PullResult{Reference: ref}
+	if opts.ByDigest {
+		digest, err := c.ResolveDigest(ref)
+		if err != nil {
+			return result, err
+		}
+		result.Reference = digestReference(ref, digest)
+		result.Digest = digest
+	}
+	subject := result.Reference
+	if opts.Platform != "" {
+		c.infof("Pulling image %s for platform %s...", result.Reference, opts.Platform)
+		subject += " " + opts.Platform
+	} else {
+		c.infof("Pulling image %s...", result.Reference)
+	}
+	defer c.inFlight("pulling", subject)()
+
+	encodedAuth, err := c.encodedAuthFor(result.Reference, opts.Auth)
+	if err != nil {
+		return result, err
+	}
+	if err := c.waitForRegistry(ctx, result.Reference); err != nil {
+		return result, err
+	}
+	reader, err := c.cli.ImagePull(ctx, result.Reference, image.PullOptions{
+		Platform:     opts.Platform,
+		RegistryAuth: encodedAuth,
+	})
+	if err != nil {
+		err = errdefs.Classify(err, "")
+		c.pauseRegistry(result.Reference, err)
+		return result, err
+	}
+	defer reader.Close()
+
+	pulled, err := streamProgress(reader, opts.Progress)
+	if err != nil {
+		err = errdefs.Classify(err, "")
+		c.pauseRegistry(result.Reference, err)
+		return result, err
+	}
+	if pulled.digest != "" {
+		result.Digest = pulled.digest
+	}
+	result.Size = c.imageSize(result.Reference)
+	return result, nil
+}
+
+// TagImage tags a local image
+func (c *Client) TagImage(ctx context.Context, source, target string) error {
+	c.infof("Tagging %s as %s...", source, target)
+	output, err := c.runCommand(ctx, nil, "tag", source, target)
+	if err != nil {
+		return fmt.Errorf("failed to tag image: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// PushImageOptions configures PushImage
+type PushImageOptions struct {
+	// Auth authenticates to the registry of the reference; the docker CLI
+	// is logged in with it too, so it can push manifest lists later
+	Auth RegistryAuth
+	// Retries is how many times a push failing with a transient error is
+	// retried, waiting longer every time; zero pushes once
+	Retries int
+	// Progress receives the aggregate layer upload progress
+	Progress func(current, total int64)
+}
+
+// PushResult describes a push
+type PushResult struct {
+	// Digest is the pushed digest, if the daemon reported it
+	Digest string
+	// Uploaded is the uncompressed size of the layers the registry didn't
+	// have yet; it is set for a failed push too
+	Uploaded int64
+}
+
+// PushImage pushes a local image to its registry
+func (c *Client) PushImage(ctx context.Context, ref string, opts PushImageOptions) (PushResult, error) {
+	defer c.inFlight("pushing", ref)()
+	if opts.Retries <= 0 {
+		return c.pushOnce(ctx, ref, opts)
+	}
+	var result PushResult
+	err := c.withAttempts("Push of "+ref, opts.Retries+1, func() error {
+		var err error
+		result, err = c.pushOnce(ctx, ref, opts)
+		return err
+	})
+	return result, err
+}
+
+// pushOnce makes a single attempt of PushImage
+func (c *Client) pushOnce(ctx context.Context, ref string, opts PushImageOptions) (PushResult, error) {
+	c.infof("Pushing image %s...", ref)
+
+	// Login to registry first if credentials are provided, so that the
+	// docker CLI can push manifest lists to it later
+	if err := c.loginRegistry(opts.Auth); err != nil {
+		return PushResult{}, err
+	}
+
+	encodedAuth, err := c.encodedAuthFor(ref, opts.Auth)
+	if err != nil {
+		return PushResult{}, err
+	}
+
+	if err := c.waitForRegistry(ctx, ref); err != nil {
+		return PushResult{}, err
+	}
+	if c.limiterFor(ctx) != nil {
+		c.pushMu.Lock()
+		defer c.pushMu.Unlock()
+	}
+	var pushed streamed
+	reader, err := c.cli.ImagePush(ctx, ref, image.PushOptions{RegistryAuth: encodedAuth})
+	if err == nil {
+		defer reader.Close()
+		pushed, err = streamProgress(reader, opts.Progress)
+		if err == nil {
+			return PushResult{Digest: pushed.digest, Uploaded: pushed.pushed}, nil
+		}
+	}
+
+	err = errdefs.Classify(err, "")
+	c.pauseRegistry(ref, err)
+	if errors.Is(err, errdefs.ErrAuthentication) {
+		// Make the retry log in again, with fresh credentials
+		c.forgetLogin(opts.Auth)
+		if opts.Auth.Provider != nil {
+			opts.Auth.Provider.Invalidate()
+		}
+	}
+	return PushResult{Uploaded: pushed.pushed}, err
+}
+
+// SaveImageOptions configures SaveImage
+type SaveImageOptions struct {
+	// Path is the archive to write. It is written to a temporary file that
+	// is only renamed to Path once it is complete, and removed when saving
+	// fails. Writer receives the archive instead when Path is empty.
+	Path   string
+	Writer io.Writer
+	// Compression compresses the archive with archive.Gzip or archive.Zstd
+	Compression archive.Compression
+	// AutoCompression picks the compression from the first bytes of the
+	// archive instead, replacing the .tar extension of Path with its own
+	AutoCompression *AutoCompression
+	// Checksum computes the sha256 checksum of the archive as written
+	Checksum bool
+	// RepoTags names the image in the archive instead of the reference
+	RepoTags []string
+	// Compat is the compatibility profile of the archive. docker-legacy
+	// archives are rewritten to the classic layout as they are saved.
+	Compat archive.Compat
+	// Progress receives the number of bytes read from docker save
+	Progress func(written int64)
+}
+
+// SaveResult describes a saved archive
+type SaveResult struct {
+	// Path is the archive written, if it was written to a file
+	Path string
+	// Size is the size of the archive as written, and SHA256 its checksum
+	// when it was requested
+	Size   int64
+	SHA256 string
+	// Compression records the compression AutoCompression picked
+	Compression *archive.Choice
+}
+
+// AutoCompression configures how SaveImage picks the compression of an
+// archive; see archive.AutoWriter
+type AutoCompression struct {
+	Preference archive.Preference
+	SampleSize int64
+	// Pinned is the compression picked for the archive by an earlier run,
+	// which is kept rather than picked again
+	Pinned archive.Compression
+}
+
+// SaveImage saves a local image as a docker save archive
+func (c *Client) SaveImage(ctx context.Context, ref string, opts SaveImageOptions) (result SaveResult, err error) {
+	switch {
+	case (opts.Path == "") == (opts.Writer == nil):
+		return result, fmt.Errorf("either a path or a writer must be given to save %s to", ref)
+	case opts.Compression != archive.Uncompressed && opts.Compression != archive.Gzip && opts.Compression != archive.Zstd:
+		return result, fmt.Errorf("docker save archives can't be compressed with %s, only with gzip or zstd", opts.Compression)
+	case opts.AutoCompression != nil && opts.Compression != archive.Uncompressed:
+		return result, fmt.Errorf("an archive can't be given a compression and pick one too")
+	case opts.Compat == archive.CompatDockerLegacy && opts.AutoCompression != nil:
+		return result, opts.Compat.CheckCompression(archive.Auto)
+	case opts.Compat == archive.CompatDockerLegacy && len(opts.RepoTags) > 1:
+		return result, fmt.Errorf("%s archives carry a single RepoTag, not %s", opts.Compat, strings.Join(opts.RepoTags, ", "))
+	}
+	if err := opts.Compat.CheckCompression(opts.Compression); err != nil {
+		return result, err
+	}
+
+	defer c.inFlight("saving", ref)()
+	dst := opts.Writer
+	var tmp *os.File
+	if opts.Path != "" {
+		c.infof("Saving image %s to %s...", ref, opts.Path)
+		if err := os.MkdirAll(filepath.Dir(opts.Path), 0755); err != nil {
+			return result, fmt.Errorf("failed to create output directory: %v", err)
+		}
+		if tmp, err = c.createTemp(opts.Path); err != nil {
+			return result, fmt.Errorf("failed to create output file: %v", err)
+		}
+		defer func() {
+			if err != nil {
+				tmp.Close()
+				os.Remove(tmp.Name())
+			}
+		}()
+		dst = tmp
+	} else {
+		c.infof("Saving image %s...", ref)
+	}
+
+	// written counts the archive as written, counter the stream of docker
+	// save before compression
+	written := &countingWriter{w: dst}
+	var output io.Writer = written
+	var sum hash.Hash
+	if opts.Checksum {
+		sum = sha256.New()
+		output = io.MultiWriter(written, sum)
+	}
+	var compressor io.WriteCloser
+	var auto *archive.AutoWriter
+	switch {
+	case opts.AutoCompression != nil:
+		if auto, err = archive.NewAutoWriter(output, opts.AutoCompression.Preference, opts.AutoCompression.SampleSize, opts.AutoCompression.Pinned); err != nil {
+			return result, err
+		}
+		compressor = auto
+	case opts.Compression != archive.Uncompressed:
+		if compressor, err = archive.NewWriter(output, opts.Compression); err != nil {
+			return result, err
+		}
+	}
+	if compressor != nil {
+		output = compressor
+		// A zstd process is left running unless the writer is closed
+		defer func() {
+			if err != nil {
+				compressor.Close()
+			}
+		}()
+	}
+
+	var stderr bytes.Buffer
+	counter := &countingWriter{w: c.limiterFor(ctx).Writer(ctx, output), report: opts.Progress}
+	args := []string{"save", ref}
+	cmd := c.command(ctx, args...)
+	cmd.Stdout = counter
+	cmd.Stderr = &stderr
+
+	// Rewrite the names and normalize the entries of the saved stream as
+	// it is written, or the whole archive to the legacy layout
+	var retagWriter *io.PipeWriter
+	retagged := make(chan error, 1)
+	if len(opts.RepoTags) > 0 || archive.Reproducible() || opts.Compat == archive.CompatDockerLegacy {
+		var retagReader *io.PipeReader
+		retagReader, retagWriter = io.Pipe()
+		cmd.Stdout = retagWriter
+		go func() {
+			var err error
+			switch {
+			case opts.Compat == archive.CompatDockerLegacy:
+				var repoTag string
+				if len(opts.RepoTags) > 0 {
+					repoTag = opts.RepoTags[0]
+				}
+				err = archive.WriteLegacy(retagReader, counter, c.tmpDir, repoTag)
+			case len(opts.RepoTags) > 0:
+				err = archive.SetRepoTags(retagReader, counter, opts.RepoTags)
+			default:
+				err = archive.Normalize(retagReader, counter)
+			}
+			retagReader.CloseWithError(err)
+			retagged <- err
+		}()
+	}
+
+	start := time.Now()
+	err = c.runError(cmd.Run())
+	c.logCommand(args, stderr.Bytes(), err, time.Since(start))
+	var retagErr error
+	if retagWriter != nil {
+		retagWriter.Close()
+		retagErr = <-retagged
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to save image: %w, output: %s", errdefs.Classify(err, stderr.String()), strings.TrimSpace(stderr.String()))
+	}
+	if retagErr != nil {
+		return result, fmt.Errorf("failed to rewrite the saved image %s: %v", ref, retagErr)
+	}
+	counter.finish()
+
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return result, fmt.Errorf("failed to finish compressed archive: %v", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	result.Size = written.written
+	if sum != nil {
+		result.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	}
+	path := opts.Path
+	if auto != nil {
+		choice := auto.Choice()
+		result.Compression = &choice
+		if path != "" {
+			path = strings.TrimSuffix(path, ".tar") + archive.Extension(choice.Compression)
+		}
+	}
+	if tmp != nil {
+		if err := c.commitTemp(tmp, path); err != nil {
+			return result, fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		result.Path = path
+	}
+	return result, nil
+}
+
+// compression returns the compression of archives saved with or without
+// gzip
+func compression(compress bool) archive.Compression {
+	if compress {
+		return archive.Gzip
+	}
+	return archive.Uncompressed
+}
+
+// archiveCompression returns the compression of the archive of a platform
+// and, with the auto compression, how to pick it. A compression picked
+// for the platform by an earlier run is kept, and so known in advance.
+func (o SaveOptions) archiveCompression(platform string) (archive.Compression, *AutoCompression) {
+	switch o.Compression {
+	case "":
+		return compression(o.UseCompression), nil
+	case archive.Auto:
+		auto := &AutoCompression{Preference: o.CompressionPreference, SampleSize: o.CompressionSample, Pinned: o.PinnedCompression[platform]}
+		return auto.Pinned, auto
+	}
+	return archive.Compression(o.Compression), nil
+}
@@ -0,0 +1,192 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/metrics"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// processLayout runs a task whose source is a dir: or oci: layout
+// directory, as skopeo copy writes them. Nothing is pulled: the image is
+// read from the directory, which is checked to be complete first, and
+// pushed to the target through the registry API without the docker daemon.
+// With save, or without a target, every platform is also written to a
+// docker archive in the output directory.
+func (c *Client) processLayout(m migration, save bool) (result *TaskResult, err error) {
+	m.ctx = c.taskContext(m.options)
+	result = &TaskResult{Source: m.source, Target: m.target, StartedAt: time.Now(), BandwidthLimit: bandwidth.FromContext(m.ctx).Rate()}
+	c.emit(TaskStarted{Source: m.source, Target: m.target})
+	defer func() {
+		result.Duration = Duration(time.Since(result.StartedAt))
+		if err != nil {
+			result.Error = redact.String(err.Error())
+		}
+		recordImage(m.source, err)
+		c.emit(TaskCompleted{Result: result, Err: err})
+	}()
+
+	source, err := archive.ParseSource(m.source)
+	if err != nil {
+		return result, err
+	}
+	err = timeStage(&result.Stages, StagePull, func() (int64, error) {
+		return 0, source.Check()
+	})
+	if err != nil {
+		return result, err
+	}
+	if c.dryRun {
+		if m.target != "" {
+			c.infof("Dry run: %s would be pushed to %s", source, m.target)
+		}
+		if save || m.target == "" {
+			c.infof("Dry run: the platforms of %s would be saved", source)
+		}
+		result.DryRun = true
+		return result, nil
+	}
+
+	staged, cleanup, err := source.Stage(c.tmpDir)
+	if err != nil {
+		return result, err
+	}
+	defer cleanup()
+	description, err := archive.Describe(staged)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", source, err)
+	}
+	platforms := make([]string, len(description.Platforms))
+	for i, platform := range description.Platforms {
+		platforms[i] = platform.String()
+	}
+	c.infof("Read %s without pulling: %s", source, strings.Join(platforms, ", "))
+
+	if save || m.target == "" {
+		image, err := layoutImageName(m, description)
+		if err != nil {
+			return result, err
+		}
+		failed := 0
+		for _, platform := range description.Platforms {
+			saved := c.saveLayoutPlatform(m, staged, image, platform)
+			if saved.Error != "" {
+				failed++
+			}
+			result.Platforms = append(result.Platforms, saved)
+		}
+		if failed > 0 {
+			return result, fmt.Errorf("failed to save %d of %d platforms of %s", failed, len(description.Platforms), source)
+		}
+	}
+	if m.target == "" {
+		return result, nil
+	}
+
+	host, repo, tag, err := splitImage(m.target)
+	if err != nil {
+		return result, err
+	}
+	target, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		return result, err
+	}
+	return result, c.pushInput(m, result, target, repo, tag, staged, source.String(), nil)
+}
+
+// layoutImageName returns the name the archives saved from a layout give
+// its image: the name the layout has, or else the target, or else the name
+// of the task when it is an image reference, such as nginx:1.25
+func layoutImageName(m migration, description *archive.Description) (string, error) {
+	for _, name := range []string{description.Name, m.target, m.options.Name} {
+		if name == "" {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			continue
+		}
+		tag := "latest"
+		if tagged, ok := named.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		// A digest the target pins is not part of a RepoTag
+		if named, err = reference.WithTag(reference.TrimNamed(named), tag); err == nil {
+			return reference.FamiliarString(named), nil
+		}
+	}
+	return "", fmt.Errorf("%s doesn't name its image; give the task a target or a name that is an image reference, such as nginx:1.25, to name its archives", m.source)
+}
+
+// saveLayoutPlatform writes the image of a platform of the staged layout
+// input to a docker archive named as a pulled platform's would be
+func (c *Client) saveLayoutPlatform(m migration, input, image string, platform registry.Platform) PlatformResult {
+	platformStr := platform.String()
+	result := PlatformResult{Platform: platformStr}
+
+	named := m
+	named.source, named.target = image, ""
+	name := platformName(named, platformStr)
+	compression, _ := m.options.archiveCompression(platformStr)
+	outputPath := hostRules.archivePath(m.options.OutputDir, name, platformStr, m.options.SplitPlatforms, archive.Extension(compression))
+	repoTags := c.archiveRepoTags(named, name)
+	if len(repoTags) == 0 {
+		repoTags = []string{familiarName(name)}
+	}
+
+	var checksum string
+	err := timeStage(&result.Stages, StageSave, func() (int64, error) {
+		extracted, err := archive.Extract(input, outputPath, platformStr, archive.ExtractOptions{RepoTags: repoTags, Compat: m.options.Compat})
+		if err != nil {
+			return 0, err
+		}
+		result.SourceDigest = extracted.Digest
+		var size int64
+		checksum, size, err = fileChecksum(outputPath)
+		return size, err
+	})
+	if err != nil {
+		metrics.Failures.Inc(m.source, platformStr, StageSave)
+		result.Error = redact.String(err.Error())
+		result.ErrorCategory = errdefs.CategoryOf(err)
+		c.emit(PlatformFailed{Source: m.source, Platform: platformStr, Stage: StageSave, Err: err})
+		return result
+	}
+	result.Path = outputPath
+	result.SHA256 = checksum
+	result.RepoTags = repoTags
+	if err := c.recordChecksum(outputPath, checksum, m.source, result.SourceDigest); err != nil {
+		c.warnf("Failed to record the checksum of %s: %v", outputPath, err)
+	}
+	size := result.Stages[len(result.Stages)-1].Bytes
+	metrics.BytesSaved.Add(float64(size), m.source, platformStr)
+	c.infof("Saved %s of %s to %s", platformStr, m.source, outputPath)
+	c.emit(PlatformSaved{Source: m.source, Platform: platformStr, Tag: name, Path: outputPath, Size: size, SHA256: checksum})
+	return result
+}
+
+// fileChecksum returns the sha256 checksum and size of a file
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	dockererrdefs "github.com/docker/docker/errdefs"
+)
+
+// LocalPlatforms returns the platforms of an image available in the local
+// daemon, without contacting a registry. With the containerd image store an
+// image may hold several platforms; only those whose content is present
+// are returned. The classic image store holds a single platform per image.
+func (c *Client) LocalPlatforms(imageName string) ([]Platform, error) {
+	var opts []client.ImageInspectOption
+	c.cli.NegotiateAPIVersion(c.ctx)
+	if versions.GreaterThanOrEqualTo(c.cli.ClientVersion(), "1.48") {
+		opts = append(opts, client.ImageInspectWithManifests(true))
+	}
+
+	inspect, err := c.cli.ImageInspect(c.ctx, imageName, opts...)
+	if dockererrdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("image %s is not in the local docker image store", imageName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect local image %s: %w", imageName, err)
+	}
+
+	if len(inspect.Manifests) == 0 {
+		return []Platform{{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant, OSVersion: inspect.OsVersion}}, nil
+	}
+	var platforms []Platform
+	for _, manifest := range inspect.Manifests {
+		if manifest.Kind != image.ManifestKindImage || !manifest.Available || manifest.ImageData == nil {
+			continue
+		}
+		p := manifest.ImageData.Platform
+		platforms = append(platforms, Platform{
+			OS:           p.OS,
+			Architecture: p.Architecture,
+			Variant:      p.Variant,
+			OSVersion:    p.OSVersion,
+			Digest:       manifest.Descriptor.Digest.String(),
+			Size:         manifest.Descriptor.Size,
+			MediaType:    manifest.Descriptor.MediaType,
+		})
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("the local image %s holds no platform's content", imageName)
+	}
+	return platforms, nil
+}
+
+// missingPlatforms returns the architectures of archs no platform of
+// platforms matches
+func (c *Client) missingPlatforms(platforms []Platform, archs []string) []string {
+	var missing []string
+	for _, arch := range archs {
+		if len(c.filterPlatforms(platforms, nil, []string{arch})) == 0 {
+			missing = append(missing, arch)
+		}
+	}
+	return missing
+}
+
+// FormatPlatforms lists platforms for messages
+func FormatPlatforms(platforms []Platform) string {
+	names := make([]string, len(platforms))
+	for i, platform := range platforms {
+		names[i] = platform.String()
+	}
+	return strings.Join(names, ", ")
+}
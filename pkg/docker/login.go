@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// loginKey identifies a registry login
+type loginKey struct {
+	host     string
+	username string
+}
+
+// login records a docker login performed during the run
+type login struct {
+	url      string
+	password string
+}
+
+// loginRegistry logs in to a Docker registry. A registry already logged in
+// to with the same username and password during the run is skipped.
+func (c *Client) loginRegistry(auth RegistryAuth) error {
+	auth, err := auth.resolve(c.ctx)
+	if err != nil {
+		return err
+	}
+	if auth.Username == "" || auth.Password == "" || auth.URL == "" {
+		return nil // Skip login if credentials are not provided
+	}
+
+	key := loginKey{host: registry.NormalizeHost(auth.URL), username: auth.Username}
+	c.loginsMu.Lock()
+	defer c.loginsMu.Unlock()
+	if previous, ok := c.logins[key]; ok && previous.password == auth.Password.Reveal() {
+		c.log.Debug(fmt.Sprintf("Already logged in to %s as %s", key.host, key.username))
+		return nil
+	}
+
+	args := []string{"login", "--username", auth.Username, "--password-stdin"}
+	if auth.Insecure {
+		args = append(args, "--insecure")
+	}
+	args = append(args, auth.URL)
+
+	output, err := c.runDockerInput(strings.NewReader(auth.Password.Reveal()), args...)
+	if err != nil {
+		return fmt.Errorf("failed to login to registry: %w, output: %s", err, string(output))
+	}
+
+	c.logins[key] = login{url: auth.URL, password: auth.Password.Reveal()}
+	return nil
+}
+
+// forgetLogin discards the recorded logins to the registry of auth after it
+// rejected a push, so the next push logs in again
+func (c *Client) forgetLogin(auth RegistryAuth) {
+	if auth.URL == "" {
+		return
+	}
+	host := registry.NormalizeHost(auth.URL)
+
+	c.loginsMu.Lock()
+	defer c.loginsMu.Unlock()
+	for key := range c.logins {
+		if key.host == host {
+			delete(c.logins, key)
+		}
+	}
+}
+
+// Logout runs docker logout for every registry the client logged in to
+// during the run. This also removes credentials for those registries that
+// were stored before the run.
+func (c *Client) Logout() error {
+	c.loginsMu.Lock()
+	defer c.loginsMu.Unlock()
+
+	var errs []error
+	done := make(map[string]bool)
+	for key, l := range c.logins {
+		delete(c.logins, key)
+		if done[key.host] {
+			continue
+		}
+		done[key.host] = true
+
+		if output, err := c.runDocker("logout", l.url); err != nil {
+			errs = append(errs, fmt.Errorf("failed to log out of %s: %w, output: %s", key.host, err, strings.TrimSpace(string(output))))
+			continue
+		}
+		c.infof("Logged out of %s", key.host)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/Fr000g/ImgMigrate/pkg/logging"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// TestLoginRedacted fails a docker login whose output repeats the
+// password, as it is logged at debug level and reported, and checks the
+// log never holds the password
+func TestLoginRedacted(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the fake docker CLI is a shell script")
+	}
+	const password = "s3cr3t-login-password"
+	// The fake docker CLI echoes the password it reads from stdin
+	script := `case "$*" in
+*login*) echo "Error response from daemon: login with password $(cat) rejected: unauthorized: incorrect username or password"; exit 1 ;;
+esac
+echo 'Docker version 28.1.1, build 4eba377'`
+	runner := RunnerFunc(func(ctx context.Context, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", append([]string{"-c", script, "docker"}, args...)...)
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(logging.Redacting(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	c, err := NewClient(WithRunner(runner), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	auth := RegistryAuth{Username: "ci", Password: redact.Secret(password), URL: "registry.example.com"}
+	err = c.loginRegistry(auth)
+	if err == nil {
+		t.Fatal("login succeeded")
+	}
+	c.errorf("Login to %s failed: %v", auth.URL, err)
+	logger.Warn("task failed", "auth", auth, "error", err)
+
+	output := buf.String()
+	if strings.Contains(output, password) {
+		t.Errorf("the password is logged:\n%s", output)
+	}
+	if !strings.Contains(output, "docker login --username ci --password-stdin registry.example.com") {
+		t.Errorf("the login command isn't logged:\n%s", output)
+	}
+	if !strings.Contains(output, "login with password "+redact.Mask+" rejected") {
+		t.Errorf("the output of the login isn't logged masked:\n%s", output)
+	}
+}
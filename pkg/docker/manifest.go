@@ -0,0 +1,174 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// WithRegistryTLS sets the TLS options used when the client talks to a
+// registry API directly, by registry host
+func WithRegistryTLS(tlsFor func(host string) registry.TLSOptions) ClientOption {
+	return func(c *Client) {
+		c.tlsFor = tlsFor
+	}
+}
+
+// WithRegistryProxy sets the proxy used when the client talks to a registry
+// API directly, by registry host. An empty setting, the default, uses the
+// proxy environment variables; see registry.Options.
+func WithRegistryProxy(proxyFor func(host string) string) ClientOption {
+	return func(c *Client) {
+		c.proxyFor = proxyFor
+	}
+}
+
+// registryProxy returns the proxy setting for a registry host
+func (c *Client) registryProxy(host string) string {
+	if c.proxyFor == nil {
+		return ""
+	}
+	return c.proxyFor(host)
+}
+
+// registryTLS returns the TLS options for a registry host
+func (c *Client) registryTLS(host string) registry.TLSOptions {
+	if c.tlsFor == nil {
+		return registry.TLSOptions{}
+	}
+	return c.tlsFor(host)
+}
+
+// registryFor returns the registry API client for the registry hosting an
+// image, along with the repository and the tag or digest it references.
+// Clients are kept per host so bearer tokens are reused across calls.
+func (c *Client) registryFor(imageName string) (*registry.Client, string, string, error) {
+	host, repo, ref, err := splitImage(imageName)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	c.registriesMu.Lock()
+	defer c.registriesMu.Unlock()
+
+	client, ok := c.registries[host]
+	if !ok {
+		if client, err = c.newRegistryClient(host, RegistryAuth{}); err != nil {
+			return nil, "", "", err
+		}
+		c.registries[host] = client
+	}
+	return client, repo, ref, nil
+}
+
+// newRegistryClient returns a registry API client for a host, using auth
+// when it belongs to the host, then the source credentials configured for
+// it and finally those stored by docker login
+func (c *Client) newRegistryClient(host string, auth RegistryAuth) (*registry.Client, error) {
+	opts := registry.Options{TLS: c.registryTLS(host), Proxy: c.registryProxy(host), Limiter: c.limiter, Pauses: c.pauses}
+	if auth, configured := c.authFor(host, auth); configured {
+		resolved, err := auth.resolve(c.ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts.Credentials = resolved.credentials()
+		opts.Insecure = auth.Insecure
+		if auth.Provider != nil {
+			opts.Resolve = func() (registry.Credentials, error) {
+				auth.Provider.Invalidate()
+				return auth.Provider.Credentials(c.ctx)
+			}
+		}
+	} else {
+		opts.Credentials, _ = registry.StoredCredentials(host)
+		opts.Resolve = resolveStored(host)
+	}
+	return registry.NewClient(host, opts)
+}
+
+// splitImage splits an image reference into its registry host, repository
+// and the tag or digest it references
+func splitImage(imageName string) (string, string, string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid image reference %s: %v", imageName, err)
+	}
+
+	ref := "latest"
+	if canonical, ok := named.(reference.Canonical); ok {
+		ref = canonical.Digest().String()
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		ref = tagged.Tag()
+	}
+	return reference.Domain(named), reference.Path(named), ref, nil
+}
+
+// registryPlatforms lists the platforms of an image through the registry
+// API, asking the mirrors of its registry first
+func (c *Client) registryPlatforms(imageName string) ([]Platform, error) {
+	var found []registry.PlatformImage
+	_, err := c.fromSources(imageName, "Manifest query", func(source string) error {
+		client, repo, ref, err := c.registryFor(source)
+		if err != nil {
+			return err
+		}
+		found, _, err = client.Platforms(c.ctx, repo, ref)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	platforms := make([]Platform, 0, len(found))
+	for _, p := range found {
+		platforms = append(platforms, Platform{
+			OS:           p.Platform.OS,
+			Architecture: p.Platform.Architecture,
+			Variant:      p.Platform.Variant,
+			OSVersion:    p.Platform.OSVersion,
+			Digest:       p.Digest,
+			Size:         p.Size,
+			MediaType:    p.MediaType,
+			Nested:       p.Nested,
+		})
+	}
+	return platforms, nil
+}
+
+// digestReference returns the reference to the manifest of a repository
+// with digest, dropping the tag and digest of imageName
+func digestReference(imageName, digest string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return imageName + "@" + digest
+	}
+	return reference.TrimNamed(named).String() + "@" + digest
+}
+
+// nestedPlatforms returns the platforms listed by indexes nested in the
+// source index
+func nestedPlatforms(platforms []Platform) []Platform {
+	var nested []Platform
+	for _, platform := range platforms {
+		if platform.Nested {
+			nested = append(nested, platform)
+		}
+	}
+	return nested
+}
+
+// registryDigest resolves an image reference through the registry API,
+// asking the mirrors of its registry first
+func (c *Client) registryDigest(imageName string) (string, error) {
+	var digest string
+	_, err := c.fromSources(imageName, "Digest lookup", func(source string) error {
+		client, repo, ref, err := c.registryFor(source)
+		if err != nil {
+			return err
+		}
+		digest, err = client.Digest(c.ctx, repo, ref)
+		return err
+	})
+	return digest, err
+}
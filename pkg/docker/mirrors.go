@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// WithMirrors sets pull-through mirrors by source registry host. Pulls and
+// manifest queries try the mirrors in order and fall back to the registry
+// itself when a mirror fails.
+func WithMirrors(mirrors map[string][]string) ClientOption {
+	return func(c *Client) {
+		c.mirrors = make(map[string][]string, len(mirrors))
+		for host, hosts := range mirrors {
+			c.mirrors[registry.NormalizeHost(host)] = hosts
+		}
+	}
+}
+
+// sources returns the references to fetch an image from: the image on each
+// mirror of its registry, then the image itself
+func (c *Client) sources(imageName string) []string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return []string{imageName}
+	}
+
+	var refs []string
+	for _, mirror := range c.mirrors[reference.Domain(named)] {
+		refs = append(refs, mirrorReference(named, mirror))
+	}
+	return append(refs, imageName)
+}
+
+// mirrorReference rewrites an image reference to point to a mirror,
+// keeping the repository path, tag and digest
+func mirrorReference(named reference.Named, mirror string) string {
+	ref := mirror + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref += ":" + tagged.Tag()
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		ref += "@" + canonical.Digest().String()
+	}
+	return ref
+}
+
+// fromSources runs fn for each source of an image until one succeeds and
+// returns the reference that served it. Failures of mirrors are logged
+// before falling back to the next source.
+func (c *Client) fromSources(imageName, what string, fn func(ref string) error) (string, error) {
+	var err error
+	for _, ref := range c.sources(imageName) {
+		if err = fn(ref); err == nil {
+			return ref, nil
+		}
+		if ref != imageName {
+			c.warnf("%s from mirror %s failed, trying the next source: %v", what, strings.SplitN(ref, "/", 2)[0], err)
+		}
+	}
+	return "", err
+}
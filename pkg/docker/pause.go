@@ -0,0 +1,48 @@
+package docker
+
+import (
+	"context"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// WithMaxRetryAfter caps how long a registry is paused for a single
+// Retry-After, registry.DefaultMaxPause by default. While a registry that
+// asked to wait is paused, every pull, push and API request to it waits
+// and doesn't spend a retry, and tasks on other registries go on.
+func WithMaxRetryAfter(max time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetryAfter = max
+	}
+}
+
+// notifyPause logs a registry paused for a Retry-After
+func (c *Client) notifyPause(host string, wait time.Duration, reason error) {
+	c.warnf("%s asked to retry after %s, pausing its pulls and pushes until %s: %v",
+		host, wait.Round(time.Second), time.Now().Add(wait).Format(time.TimeOnly), reason)
+}
+
+// waitForRegistry blocks while the registry of ref is paused
+func (c *Client) waitForRegistry(ctx context.Context, ref string) error {
+	host, _, _, err := splitImage(ref)
+	if err != nil {
+		return nil
+	}
+	if remaining := c.pauses.Remaining(host); remaining > 0 {
+		c.infof("Waiting %s for %s to resume before %s", remaining.Round(time.Second), host, ref)
+	}
+	_, err = c.pauses.Wait(ctx, host)
+	return err
+}
+
+// pauseRegistry pauses the registry of ref when err carries a Retry-After
+func (c *Client) pauseRegistry(ref string, err error) {
+	wait := errdefs.RetryAfter(err)
+	if wait <= 0 {
+		return
+	}
+	if host, _, _, splitErr := splitImage(ref); splitErr == nil {
+		c.pauses.Pause(host, wait, err)
+	}
+}
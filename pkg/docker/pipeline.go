@@ -0,0 +1,941 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/audit"
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+	"github.com/Fr000g/ImgMigrate/pkg/metrics"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/distribution/reference"
+)
+
+// Retry policy for stages that fail with a rate limit or network error
+const (
+	retryAttempts = 3
+	retryDelay    = 2 * time.Second
+)
+
+// migration describes how each platform of a source image is processed
+type migration struct {
+	source string
+	// target is the push destination; empty when the images are only saved
+	target string
+	// archs restricts the platforms to the given architectures; nil selects all
+	archs   []string
+	options SaveOptions
+	auth    RegistryAuth
+	// archTag names the tags the platforms are pushed under
+	archTag *config.ArchTag
+	// ctx bounds the task; see SaveOptions.Context
+	ctx context.Context
+}
+
+// taskContext returns the context of a task with options, carrying the
+// bandwidth limiter of the task
+func (c *Client) taskContext(options SaveOptions) context.Context {
+	ctx := c.ctx
+	if options.Context != nil {
+		ctx = options.Context
+	}
+	return bandwidth.NewContext(ctx, c.taskLimiter(options.BandwidthLimit))
+}
+
+// operationContext returns the context of a pull, save or push of a
+// platform: the task context, limited by the operation timeout
+func (m migration) operationContext() (context.Context, context.CancelFunc) {
+	if m.options.OperationTimeout > 0 {
+		return context.WithTimeout(m.ctx, m.options.OperationTimeout)
+	}
+	return context.WithCancel(m.ctx)
+}
+
+// timeoutError classifies the error of an operation run with ctx as a
+// timeout when the deadline of ctx, or of the task, has passed
+func timeoutError(ctx context.Context, what string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return &errdefs.TimeoutError{Err: fmt.Errorf("%s timed out: %w", what, err)}
+}
+
+// migrate resolves the platforms of the source image and pulls, tags and
+// then saves or pushes every one of them, optionally finishing with a
+// multi-architecture manifest. Every stage is timed in the returned result.
+func (c *Client) migrate(m migration) (result *TaskResult, err error) {
+	pinSource(&m)
+	m.ctx = c.taskContext(m.options)
+	result = &TaskResult{Source: m.source, Target: m.target, StartedAt: time.Now(), BandwidthLimit: bandwidth.FromContext(m.ctx).Rate()}
+	c.emit(TaskStarted{Source: m.source, Target: m.target})
+	defer func() {
+		result.Duration = Duration(time.Since(result.StartedAt))
+		if err != nil {
+			result.Error = redact.String(err.Error())
+		}
+		recordImage(m.source, err)
+		c.emit(TaskCompleted{Result: result, Err: err})
+	}()
+	if m.archTag, err = config.ParseArchTag(m.options.ArchTag); err != nil {
+		return result, err
+	}
+
+	for {
+		err = c.migratePlatforms(m, result)
+		var moved *sourceMoved
+		if !errors.As(err, &moved) {
+			return result, err
+		}
+		// Start over with every platform pulled by the digest the source
+		// moved to, so the task can't mix them again
+		c.warnf("Restarting the task of %s pinned to %s", m.source, moved.to)
+		m.options.SourceDigest, m.options.PinDigests = moved.to, true
+		*result = TaskResult{Source: m.source, Target: m.target, StartedAt: result.StartedAt, BandwidthLimit: result.BandwidthLimit, SourceChanges: result.SourceChanges}
+	}
+}
+
+// migratePlatforms resolves the platforms of the source image and migrates
+// them into result, see migrate
+func (c *Client) migratePlatforms(m migration, result *TaskResult) error {
+	// The digest the source tag points to now, checked again before the
+	// manifest list is created
+	started := c.sourceDigestAtStart(m)
+
+	// Get available platforms, from the local daemon in local only mode
+	var platforms []Platform
+	err := timeStage(&result.Stages, StageManifestInspect, func() (int64, error) {
+		if m.options.LocalOnly {
+			var err error
+			platforms, err = c.LocalPlatforms(m.source)
+			return 0, err
+		}
+		inspectErr := c.withRetry("Manifest inspect of "+m.source, func() error {
+			var err error
+			platforms, err = c.inspectPlatforms(m.source, m.options.SourceDigest)
+			return err
+		})
+		return 0, inspectErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get available platforms: %w", err)
+	}
+
+	if len(platforms) == 0 {
+		return fmt.Errorf("no platform information found for image %s", m.source)
+	}
+
+	// Create output directory if it doesn't exist
+	if m.target == "" && m.options.BlobStore == "" {
+		result.OutputDir = m.options.OutputDir
+	}
+	if m.target == "" && !c.dryRun {
+		if err := os.MkdirAll(m.options.OutputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+	}
+
+	available := platforms
+	if m.archs == nil {
+		// Filter platforms by OS if specified
+		if len(m.options.OperatingSystems) > 0 {
+			platforms = c.filterPlatforms(platforms, m.options.OperatingSystems, nil)
+			c.infof("Filtered to %d platforms based on specified operating systems: %v",
+				len(platforms), m.options.OperatingSystems)
+		}
+
+		if m.options.LocalOnly && len(platforms) == 0 {
+			return fmt.Errorf("no platform of %s for operating systems %v is available locally; the local image has %s",
+				m.source, m.options.OperatingSystems, FormatPlatforms(available))
+		}
+		c.infof("Found %d architectures for %s", len(platforms), m.source)
+	} else {
+		if m.options.LocalOnly {
+			matching := c.filterPlatforms(platforms, m.options.OperatingSystems, nil)
+			if missing := c.missingPlatforms(matching, m.archs); len(missing) > 0 {
+				return fmt.Errorf("architectures %v of %s are not available locally; the local image has %s",
+					missing, m.source, FormatPlatforms(available))
+			}
+		}
+
+		// Filter platforms by OS and architecture
+		platforms = c.filterPlatforms(platforms, m.options.OperatingSystems, m.archs)
+
+		c.infof("Filtering for architectures: %v and operating systems: %v",
+			m.archs, m.options.OperatingSystems)
+
+		if len(platforms) == 0 {
+			return fmt.Errorf("no matching platforms found for the specified OS and architectures")
+		}
+
+		c.infof("Found %d matching platforms after filtering", len(platforms))
+	}
+
+	if err := checkPlatformNames(m, platforms); err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		c.planPlatforms(m, result, platforms)
+		return nil
+	}
+
+	if nested := nestedPlatforms(platforms); len(nested) > 0 && !m.options.LocalOnly {
+		c.warnf("The index of %s nests indexes, which the daemon doesn't follow: %s are pulled by digest, and the manifest list created for them is flattened",
+			m.source, FormatPlatforms(nested))
+	}
+
+	var taggedImages []string
+	for i, platform := range platforms {
+		if i > 0 && c.platformGate != nil {
+			result.Paused += Duration(c.platformGate(m.ctx, m.source, platform.String()))
+		}
+		if c.platformBudget != nil && !c.platformBudget() {
+			c.warnf("The transfer budget is used up, deferring %d platforms of %s: %s", len(platforms)-i, m.source, FormatPlatforms(platforms[i:]))
+			for _, rest := range platforms[i:] {
+				result.Platforms = append(result.Platforms, PlatformResult{Platform: rest.String(), SourceDigest: rest.Digest, Deferred: true})
+			}
+			break
+		}
+		platformResult := c.migratePlatform(m, platform, taggedImages)
+		result.Platforms = append(result.Platforms, platformResult)
+		result.Paused += platformResult.DiskWait
+		if platformResult.Pushed != nil {
+			recordPushed(result, *platformResult.Pushed)
+		}
+		if platformResult.Storage != nil {
+			result.addStorage(*platformResult.Storage)
+		}
+
+		if platformResult.Tag != "" {
+			// Add to list of tagged images for multi-arch manifest
+			taggedImages = append(taggedImages, platformResult.Tag)
+		}
+	}
+
+	// The platforms are complete once the deferred ones are migrated too
+	if result.Deferred() > 0 {
+		if m.options.CreateMultiArch {
+			c.warnf("Skipping the multi-arch manifest of %s until its deferred platforms are migrated", m.source)
+		}
+		return nil
+	}
+
+	// A task missing its required platforms fails, and gets no manifest
+	// list that would leave them out
+	if m.options.hasRequirements() {
+		if unmet := unmetRequirement(m.options, result.Platforms); unmet != "" {
+			result.UnmetRequirement = unmet
+			if m.options.CreateMultiArch {
+				c.warnf("Skipping the multi-arch manifest of %s, which misses its platform requirement", m.source)
+			}
+			return fmt.Errorf("platform requirement not met: %s", unmet)
+		}
+	}
+
+	if err := c.checkSourceChange(m, result, started); err != nil {
+		return err
+	}
+
+	if m.target == "" {
+		c.createLocalManifest(m, result, taggedImages)
+	} else {
+		c.createRemoteManifest(m, result, taggedImages)
+		c.checkIndexCopy(m, result)
+		if m.options.SmokeTest != "" {
+			if err := c.smokeTest(m, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migratePlatform pulls, tags and saves or pushes a single platform. The
+// returned result carries the local tag when tagging succeeded, even if a
+// later stage failed, so the tag can still take part in the manifest list.
+// taggedImages are the local tags of the platforms of the task before it,
+// which pruning for disk space leaves alone.
+func (c *Client) migratePlatform(m migration, platform Platform, taggedImages []string) PlatformResult {
+	platformStr := platform.String()
+	result := PlatformResult{Platform: platformStr, SourceDigest: platform.Digest}
+	c.infof("Processing image for architecture: %s", platformStr)
+
+	fail := func(stage string, err error) PlatformResult {
+		metrics.Failures.Inc(m.source, platformStr, stage)
+		result.Error = redact.String(err.Error())
+		result.ErrorCategory = errdefs.CategoryOf(err)
+		c.emit(PlatformFailed{Source: m.source, Platform: platformStr, Stage: stage, Err: err})
+		return result
+	}
+
+	// A task past its deadline fails its remaining platforms without
+	// starting them
+	if err := m.ctx.Err(); err != nil {
+		return fail(StagePull, timeoutError(m.ctx, "Task "+m.source, err))
+	}
+
+	// Reuse the platform if an earlier task pulled the same source digest,
+	// otherwise pull it
+	from := m.source
+	if m.options.SourceDigest != "" {
+		if reused := c.reusableImage(m.options.SourceDigest, platformStr); reused != "" {
+			c.infof("Reusing %s, pulled earlier for the same source digest", reused)
+			from = reused
+			result.ReusedFrom = reused
+		}
+	}
+	if m.options.LocalOnly {
+		c.infof("Using local image %s for %s without pulling", m.source, platformStr)
+	} else if result.ReusedFrom == "" {
+		waited, err := c.waitForDisk(m, platformStr, taggedImages)
+		result.DiskWait = Duration(waited)
+		if err != nil {
+			return fail(StagePull, err)
+		}
+		pulled, err := c.pullPlatform(m, platform, &result)
+		if err != nil {
+			return fail(StagePull, err)
+		}
+		from = pulled
+	}
+
+	// Tag the image with architecture. A saved platform is tagged for the
+	// run only, while its archive is named after the plain tag.
+	name := platformName(m, platformStr)
+	tag := name
+	if m.target == "" {
+		tag = c.runTag(name)
+	}
+
+	err := timeStage(&result.Stages, StageTag, func() (int64, error) {
+		return 0, c.TagImage(m.ctx, from, tag)
+	})
+	if err != nil {
+		return fail(StageTag, err)
+	}
+	if tag != name {
+		c.trackIntermediate(tag)
+	}
+
+	// Verify the tagged image exists locally
+	if _, verifyErr := c.runDocker("image", "inspect", tag); verifyErr != nil {
+		return fail("verify", fmt.Errorf("tagged image %s not found locally after tagging", tag))
+	}
+	result.Tag = tag
+	if m.options.SourceDigest != "" {
+		c.rememberImage(m.options.SourceDigest, platformStr, tag)
+	}
+
+	// Wait a moment for the tag to complete
+	time.Sleep(1 * time.Second)
+
+	if m.target == "" {
+		// Save the image with the extension of its compression; one the
+		// auto compression picks replaces .tar once it is known
+		compression, auto := m.options.archiveCompression(platformStr)
+		outputPath := hostRules.archivePath(m.options.OutputDir, name, platformStr, m.options.SplitPlatforms, archive.Extension(compression))
+		repoTags := c.archiveRepoTags(m, name)
+		if len(repoTags) == 0 && tag != name {
+			repoTags = []string{familiarName(name)}
+		}
+		total := c.imageSize(tag)
+		report := func(written int64) {
+			c.emit(PlatformSaveProgress{Source: m.source, Platform: platformStr, Bytes: written, Total: total})
+		}
+		var stored *archive.Stored
+		var checksum string
+		err = timeStage(&result.Stages, StageSave, func() (int64, error) {
+			ctx, cancel := m.operationContext()
+			defer cancel()
+			var err error
+			if m.options.BlobStore != "" {
+				if stored, err = c.saveToStore(ctx, m.options.BlobStore, tag, repoTags, report); err != nil {
+					return 0, timeoutError(ctx, "Save of "+platformStr, err)
+				}
+				return stored.Added, nil
+			}
+			if earlier := c.reusableArchive(tag, repoTags, compression, m.options.Compat); auto == nil && earlier != "" && earlier != outputPath {
+				c.infof("Copying %s, saved earlier for the same image, to %s...", earlier, outputPath)
+				checksum, err = c.copyArchive(earlier, outputPath)
+			} else {
+				opts := SaveImageOptions{Path: outputPath, Compression: compression, Checksum: true, RepoTags: repoTags, Compat: m.options.Compat, Progress: report}
+				if auto != nil {
+					opts.Compression, opts.AutoCompression = archive.Uncompressed, auto
+				}
+				var saved SaveResult
+				saved, err = c.SaveImage(ctx, tag, opts)
+				checksum = saved.SHA256
+				if saved.Compression != nil {
+					result.Compression, compression = saved.Compression, saved.Compression.Compression
+					outputPath = saved.Path
+					c.infof("Compressed %s with %s to %.0f%% of its size", outputPath, compression, saved.Compression.Ratio*100)
+				}
+			}
+			if err != nil {
+				return 0, timeoutError(ctx, "Save of "+platformStr, err)
+			}
+			info, err := os.Stat(outputPath)
+			if err != nil {
+				return 0, nil
+			}
+			return info.Size(), nil
+		})
+		if err != nil {
+			return fail(StageSave, err)
+		}
+		result.Path = outputPath
+		result.RepoTags = repoTags
+		if len(repoTags) == 0 {
+			result.RepoTags = []string{familiarName(tag)}
+		}
+		if stored != nil {
+			outputPath = m.options.BlobStore
+			result.Path = outputPath
+			result.Stored = &StoredImage{Digest: stored.Digest, ArchiveSize: stored.ArchiveSize, AddedSize: stored.Added}
+			c.infof("Added %s to the blob store %s: %s of new blobs, %s shared with images stored before", tag, outputPath,
+				progress.FormatBytes(stored.Added), progress.FormatBytes(max(stored.ArchiveSize-stored.Added, 0)))
+		} else {
+			result.SHA256 = checksum
+			if err := c.recordChecksum(outputPath, checksum, m.source, result.SourceDigest); err != nil {
+				c.warnf("Failed to record the checksum of %s: %v", outputPath, err)
+			}
+			c.rememberArchive(tag, repoTags, compression, m.options.Compat, outputPath)
+		}
+		size := result.Stages[len(result.Stages)-1].Bytes
+		metrics.BytesSaved.Add(float64(size), m.source, platformStr)
+
+		c.emit(PlatformSaved{Source: m.source, Platform: platformStr, Tag: tag, Path: outputPath, Size: size, SHA256: result.SHA256})
+		return result
+	}
+
+	// Push to target registry, through the registry API when the layers
+	// are transformed
+	pushed := c.beforePush(m, tag)
+	var digest string
+	var transformed *archive.Transformed
+	var storage StorageUsage
+	report := func(current, total int64) {
+		c.emit(PlatformPushProgress{Source: m.source, Platform: platformStr, Bytes: current, Total: total})
+	}
+	err = timeStage(&result.Stages, StagePush, func() (int64, error) {
+		ctx, cancel := m.operationContext()
+		defer cancel()
+		pushErr := c.withRetry("Push of "+tag, func() error {
+			var err error
+			if !m.options.Transform.IsZero() {
+				var uploaded *archive.Pushed
+				uploaded, transformed, err = c.pushTransformed(ctx, m, tag, platformStr, report)
+				if uploaded != nil {
+					digest = uploaded.Digest
+					storage = StorageUsage{NewBytes: uploaded.Bytes, TotalBytes: uploaded.Bytes + uploaded.SkippedBytes}
+				}
+			} else {
+				// Layers uploaded by a failed attempt are stored too
+				var pushed PushResult
+				pushed, err = c.PushImage(ctx, tag, PushImageOptions{Auth: m.auth, Progress: report})
+				digest = pushed.Digest
+				storage.NewBytes += pushed.Uploaded
+				storage.Estimated = true
+			}
+			return timeoutError(ctx, "Push of "+tag, err)
+		})
+		if pushErr != nil {
+			return 0, pushErr
+		}
+		if transformed != nil {
+			return transformed.SizeAfter, nil
+		}
+		size := c.imageSize(tag)
+		if storage.Estimated {
+			storage.TotalBytes = size
+		}
+		return size, nil
+	})
+	if err == nil || storage.NewBytes > 0 {
+		result.Storage = &storage
+	}
+	if err != nil {
+		return fail(StagePush, err)
+	}
+	if transformed != nil {
+		result.Transform = layerTransform(m.options.Transform, transformed)
+		c.infof("Transformed the layers of %s from %s", tag, result.Transform)
+	}
+	pushed.Digest = digest
+	result.Pushed = &pushed
+	if err := c.audit(m, audit.Entry{Kind: audit.KindImage, Platform: platformStr, Target: tag, TargetDigest: digest}); err != nil {
+		return fail(StagePush, err)
+	}
+	metrics.PlatformsPushed.Inc(m.source, platformStr, registryHost(m.target))
+	metrics.BytesPushed.Add(float64(result.Stages[len(result.Stages)-1].Bytes), m.source, platformStr, registryHost(m.target))
+
+	c.emit(PlatformPushed{Source: m.source, Platform: platformStr, Tag: tag, Digest: digest})
+	return result
+}
+
+// platformName returns the name a platform is saved or pushed under: its
+// architecture tag of the source, or the tag the arch tag template names in
+// the target when it is pushed. checkPlatformNames checked the template
+// names it.
+func platformName(m migration, platformStr string) string {
+	if m.target == "" {
+		return archTag(m.source, platformStr)
+	}
+	name, err := m.archTag.Target(m.target, platformStr)
+	if err != nil {
+		return fmt.Sprintf("%s-%s", m.target, strings.Replace(platformStr, "/", "-", -1))
+	}
+	return name
+}
+
+// checkPlatformNames checks that the arch tag template names a valid tag
+// of its own for every platform pushed to the target
+func checkPlatformNames(m migration, platforms []Platform) error {
+	if m.target == "" {
+		return nil
+	}
+	named := make(map[string]string)
+	for _, platform := range platforms {
+		name, err := m.archTag.Target(m.target, platform.String())
+		if err != nil {
+			return err
+		}
+		if other, ok := named[name]; ok {
+			return fmt.Errorf("the arch tag template pushes %s and %s both as %s", other, platform, name)
+		}
+		named[name] = platform.String()
+	}
+	return nil
+}
+
+// pullPlatform pulls a single platform of the source image, timing it as
+// the pull stage of result, and returns the reference to tag it from. The
+// mirrors of the source registry are tried first; an image pulled from a
+// mirror is tagged with the source reference so the rest of the pipeline
+// only sees canonical names. A platform of a nested index, or of a task
+// pinning digests, is pulled by digest and tagged from the reference that
+// served it.
+func (c *Client) pullPlatform(m migration, platform Platform, result *PlatformResult) (string, error) {
+	platformStr := platform.String()
+	source, from := m.source, m.source
+	pinned := platform.Nested || (m.options.PinDigests && platform.Digest != "")
+	if pinned {
+		source = digestReference(m.source, platform.Digest)
+	}
+	var pulled PullResult
+	err := timeStage(&result.Stages, StagePull, func() (int64, error) {
+		ctx, cancel := m.operationContext()
+		defer cancel()
+		served, err := c.fromSources(source, "Pull of "+platformStr, func(ref string) error {
+			return c.withRetry("Pull of "+platformStr, func() error {
+				var err error
+				pulled, err = c.PullImage(ctx, ref, PullImageOptions{
+					Platform: platformStr,
+					Progress: func(current, total int64) {
+						c.emit(PlatformPullProgress{Source: m.source, Platform: platformStr, Bytes: current, Total: total})
+					},
+				})
+				return timeoutError(ctx, "Pull of "+platformStr, err)
+			})
+		})
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case pinned:
+			from = served
+		case served != m.source:
+			if err := c.TagImage(ctx, served, m.source); err != nil {
+				return 0, err
+			}
+			c.infof("Pulled %s from mirror %s", m.source, served)
+		}
+		result.PulledFrom = served
+		return pulled.Size, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	metrics.PlatformsPulled.Inc(m.source, platformStr)
+	c.emit(PlatformPulled{Source: m.source, Platform: platformStr, PulledFrom: result.PulledFrom})
+	return from, nil
+}
+
+// createLocalManifest creates the -allarch manifest list for locally saved images
+func (c *Client) createLocalManifest(m migration, result *TaskResult, taggedImages []string) {
+	if len(taggedImages) == 0 {
+		return
+	}
+	if !m.options.CreateMultiArch {
+		c.infof("Create multi-arch manifest option is disabled, skipping manifest creation")
+		return
+	}
+
+	c.infof("Create multi-arch manifest option is enabled")
+	manifestTag := manifestListTag(m.source)
+	if m.options.BlobStore != "" {
+		c.storeIndex(m, result, manifestTag)
+	}
+	err := timeStage(&result.Stages, StageManifestCreate, func() (int64, error) {
+		_, err := c.createManifestList(m.source, manifestTag, taggedImages)
+		return 0, err
+	})
+	if err != nil {
+		c.errorf("Failed to create multi-arch manifest: %v", err)
+		metrics.Failures.Inc(m.source, "", "manifest")
+		return
+	}
+	result.ManifestList = manifestTag
+	c.emit(ManifestCreated{Source: m.source, ManifestList: manifestTag, Images: taggedImages})
+
+	// Save the manifest image if saving locally
+	if m.options.UseCompression {
+		extension := ".tar.gz"
+		outputPath := filepath.Join(m.options.OutputDir, hostRules.fileName(manifestTag)+extension)
+		var checksum string
+		err := timeStage(&result.Stages, StageSave, func() (int64, error) {
+			saved, err := c.SaveImage(m.ctx, manifestTag, SaveImageOptions{Path: outputPath, Compression: archive.Gzip, Checksum: true, Compat: m.options.Compat})
+			checksum = saved.SHA256
+			return 0, err
+		})
+		if err != nil {
+			c.errorf("Failed to save multi-arch manifest image: %v", err)
+		} else {
+			if err := c.recordChecksum(outputPath, checksum, m.source, m.options.SourceDigest); err != nil {
+				c.warnf("Failed to record the checksum of %s: %v", outputPath, err)
+			}
+			c.infof("Successfully saved multi-arch manifest image to %s", outputPath)
+		}
+	}
+}
+
+// createRemoteManifest creates and pushes the multi-arch manifest list for pushed images
+func (c *Client) createRemoteManifest(m migration, result *TaskResult, taggedImages []string) {
+	if !m.options.CreateMultiArch || len(taggedImages) == 0 {
+		c.infof("Multi-arch manifest creation is disabled, skipping")
+		return
+	}
+
+	c.infof("Preparing to create multi-arch manifest for remote registry with %d images", len(taggedImages))
+
+	// Verify all tagged images exist locally
+	var validImages []string
+	for _, img := range taggedImages {
+		if _, err := c.runDocker("image", "inspect", img); err == nil {
+			validImages = append(validImages, img)
+		} else {
+			c.warnf("Image %s not found locally, will be excluded from manifest", img)
+		}
+	}
+
+	if len(validImages) == 0 {
+		c.infof("No valid images found for manifest creation, skipping")
+		return
+	}
+	if m.options.SourceOrder && (result.Failed() > 0 || len(validImages) < len(taggedImages)) {
+		c.errorf("Not all platforms of %s were pushed, skipping the manifest list, which would not list the platforms of the source", m.source)
+		metrics.Failures.Inc(m.source, "", "manifest")
+		return
+	}
+
+	c.infof("Creating multi-arch manifest for remote registry push")
+	manifestTag := fmt.Sprintf("%s-allarch", m.target)
+	list := c.beforePush(m, manifestTag)
+	err := timeStage(&result.Stages, StageManifestCreate, func() (int64, error) {
+		var err error
+		list.Digest, err = c.createManifestList(m.source, manifestTag, validImages)
+		return 0, err
+	})
+	if err == nil {
+		recordPushed(result, list)
+		err = c.audit(m, audit.Entry{Kind: audit.KindManifest, Target: manifestTag, TargetDigest: list.Digest})
+	}
+	if err != nil {
+		c.errorf("Failed to create multi-arch manifest: %v", err)
+		metrics.Failures.Inc(m.source, "", "manifest")
+		return
+	}
+	result.ManifestList = manifestTag
+	c.emit(ManifestCreated{Source: m.source, ManifestList: manifestTag, Images: validImages})
+
+	// Also tag the manifest with the base targetImage
+	if err := c.TagImage(m.ctx, manifestTag, m.target); err != nil {
+		c.errorf("Failed to tag manifest with base image name: %v", err)
+		return
+	}
+	c.infof("Successfully tagged manifest as %s", m.target)
+
+	// Push the base tag
+	base := c.beforePush(m, m.target)
+	err = timeStage(&result.Stages, StagePush, func() (int64, error) {
+		pushed, err := c.PushImage(m.ctx, m.target, PushImageOptions{Auth: m.auth})
+		if err != nil {
+			return 0, err
+		}
+		base.Digest = pushed.Digest
+		recordPushed(result, base)
+		return 0, c.audit(m, audit.Entry{Kind: audit.KindManifest, Target: m.target, TargetDigest: base.Digest})
+	})
+	if err != nil {
+		c.errorf("Failed to push base manifest tag: %v", err)
+	} else {
+		c.infof("Successfully pushed multi-arch image to %s", m.target)
+	}
+
+	// Annotations, the metadata of the source index and its order can only
+	// be given to the manifest list through the registry, after docker
+	// pushed it
+	if len(m.options.Annotations) == 0 && !m.options.PreserveIndex && !m.options.SourceOrder {
+		return
+	}
+	pushed := err == nil
+	meta, err := c.indexMetadata(m)
+	if err != nil {
+		c.errorf("Failed to read the manifest list of %s: %v", m.source, err)
+		metrics.Failures.Inc(m.source, "", "annotate")
+		return
+	}
+	if m.options.SourceOrder {
+		// The platforms were pushed in the order of the source
+		for _, platform := range result.Platforms {
+			meta.Order = append(meta.Order, platform.Platform)
+		}
+	}
+	if meta.MediaType == "" && len(meta.Annotations) == 0 && len(meta.Platforms) == 0 && len(meta.Order) == 0 {
+		return
+	}
+	refs := []string{manifestTag}
+	if pushed {
+		refs = append(refs, m.target)
+	}
+	for _, ref := range refs {
+		annotated, err := c.rewriteManifestList(m, ref, meta)
+		if err == nil && annotated.DigestChanged {
+			recordPushed(result, PushedReference{Reference: ref, Digest: annotated.Digest, PreviousUnknown: true})
+			err = c.audit(m, audit.Entry{Kind: audit.KindManifest, Target: ref, TargetDigest: annotated.Digest})
+		}
+		if err != nil {
+			c.errorf("Failed to annotate %s: %v", ref, err)
+			metrics.Failures.Inc(m.source, "", "annotate")
+			continue
+		}
+		if annotated.DigestChanged {
+			c.infof("Annotated %s, which changed its digest from %s to %s", ref, annotated.DigestBefore, annotated.Digest)
+		}
+		result.Annotated = append(result.Annotated, annotated)
+	}
+}
+
+// indexMetadata returns the metadata to give the manifest list pushed for
+// a migration: its annotations and, when the source index is preserved,
+// the media type and annotations of the source index, which the
+// migration's annotations add to or replace
+func (c *Client) indexMetadata(m migration) (registry.IndexMetadata, error) {
+	if !m.options.PreserveIndex {
+		if len(m.options.Annotations) == 0 {
+			return registry.IndexMetadata{}, nil
+		}
+		return registry.IndexMetadata{MediaType: registry.MediaTypeOCIIndex, Annotations: m.options.Annotations}, nil
+	}
+
+	var source *registry.IndexMetadata
+	_, err := c.fromSources(m.source, "Manifest list query", func(imageName string) error {
+		client, repo, ref, err := c.registryFor(imageName)
+		if err != nil {
+			return err
+		}
+		if m.options.SourceDigest != "" {
+			ref = m.options.SourceDigest
+		}
+		source, err = client.IndexMetadata(c.ctx, repo, ref)
+		return err
+	})
+	if err != nil {
+		return registry.IndexMetadata{}, err
+	}
+
+	meta := registry.IndexMetadata{}
+	if source == nil {
+		c.warnf("%s is a single image, there is no manifest list to preserve", m.source)
+	} else {
+		meta = *source
+		// The order of the source applies to the selected platforms only
+		// with SourceOrder, which sets it
+		meta.Order = nil
+	}
+	annotations := make(map[string]string)
+	maps.Copy(annotations, meta.Annotations)
+	maps.Copy(annotations, m.options.Annotations)
+	meta.Annotations = annotations
+	if len(annotations) > 0 && meta.MediaType != registry.MediaTypeOCIIndex {
+		if meta.MediaType == registry.MediaTypeManifestList {
+			c.warnf("%s is a docker manifest list, which has no annotations; pushing an OCI index to carry the annotations", m.source)
+		}
+		meta.MediaType = registry.MediaTypeOCIIndex
+	}
+	return meta, nil
+}
+
+// rewriteManifestList gives a manifest list in the target registry the
+// metadata of meta and checks the registry kept it
+func (c *Client) rewriteManifestList(m migration, imageName string, meta registry.IndexMetadata) (AnnotatedManifest, error) {
+	host, repo, tag, err := splitImage(imageName)
+	if err != nil {
+		return AnnotatedManifest{}, err
+	}
+	client, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		return AnnotatedManifest{}, err
+	}
+	before, after, err := client.RewriteIndex(c.ctx, repo, tag, meta)
+	if err != nil {
+		return AnnotatedManifest{}, err
+	}
+
+	stored, err := client.IndexMetadata(c.ctx, repo, tag)
+	if err != nil {
+		return AnnotatedManifest{}, fmt.Errorf("failed to verify the annotations: %w", err)
+	}
+	if missing := meta.Missing(stored); len(missing) > 0 {
+		sort.Strings(missing)
+		return AnnotatedManifest{}, fmt.Errorf("the registry didn't keep %s", strings.Join(missing, "; "))
+	}
+	return AnnotatedManifest{
+		Reference:           imageName,
+		MediaType:           stored.MediaType,
+		Annotations:         meta.Annotations,
+		PlatformAnnotations: meta.Platforms,
+		Order:               meta.Order,
+		Digest:              after,
+		DigestBefore:        before,
+		DigestChanged:       after != before,
+	}, nil
+}
+
+// withRetry runs fn until it succeeds, fails with an error that is not
+// transient, or runs out of attempts. The delay between attempts doubles
+// every time. An authentication failure is retried once without counting
+// as an attempt: fn resolves credentials on every call, so a token that
+// expired during a long run is replaced if it has been renewed. A failure
+// carrying a Retry-After waits as long as the registry asked, capped, and
+// doesn't count as an attempt either until the waits add up to the longest
+// pause; other operations on that registry are paused meanwhile.
+func (c *Client) withRetry(what string, fn func() error) error {
+	return c.withAttempts(what, retryAttempts, fn)
+}
+
+// withAttempts runs fn as withRetry does, for up to attempts attempts
+func (c *Client) withAttempts(what string, attempts int, fn func() error) error {
+	delay := retryDelay
+	resolved := false
+	var paused time.Duration
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err != nil && !resolved && errors.Is(err, errdefs.ErrAuthentication) {
+			resolved = true
+			attempt--
+			c.warnf("%s was rejected by the registry, resolving credentials again: %v", what, err)
+			continue
+		}
+		if wait := min(errdefs.RetryAfter(err), c.pauses.Max()); wait > 0 && !errors.Is(err, errdefs.ErrTimeout) && paused < c.pauses.Max() {
+			paused += wait
+			attempt--
+			c.warnf("%s failed with a %s error, retrying in %s as the registry asked: %v", what, errdefs.CategoryOf(err), wait, err)
+			time.Sleep(wait)
+			continue
+		}
+		if err == nil || attempt >= attempts || !errdefs.Retryable(err) {
+			return err
+		}
+
+		c.warnf("%s failed with a %s error, retrying in %s (attempt %d of %d): %v",
+			what, errdefs.CategoryOf(err), delay, attempt+1, attempts, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// splitImageTag splits an image reference into its canonical name in the
+// short form docker shows and its tag, defaulting the tag to latest, so
+// every spelling of an image gets the same local tags and file names. A
+// source given by digest alone, which a tag can't contain, gets a tag
+// named after the digest.
+func splitImageTag(imageName string) (string, string) {
+	if named, err := imageref.Parse(imageName); err == nil {
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			return reference.FamiliarName(named), tagged.Tag()
+		}
+		if digested, ok := named.(reference.Digested); ok {
+			return reference.FamiliarName(named), digestTag(digested.Digest().String())
+		}
+	}
+	name, digest, digested := strings.Cut(imageName, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+	if digested {
+		return name, digestTag(digest)
+	}
+	return name, "latest"
+}
+
+// archTag returns the architecture specific tag used for a locally saved platform
+func archTag(imageName string, platform string) string {
+	baseImage, tag := splitImageTag(imageName)
+	return fmt.Sprintf("%s:%s-%s", baseImage, tag, strings.Replace(platform, "/", "-", -1))
+}
+
+// archiveRepoTags returns the names to embed in the archive of a platform
+// tag, or nil to keep the tag itself as docker save does
+func (c *Client) archiveRepoTags(m migration, tag string) []string {
+	if m.options.RepoTags != config.RepoTagsOriginal && m.options.RepoTags != config.RepoTagsBoth {
+		return nil
+	}
+	named, err := reference.ParseNormalizedNamed(m.source)
+	if err != nil {
+		return nil
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+	if !ok {
+		c.warnf("%s has no tag to name its archives by, keeping %s", m.source, tag)
+		return nil
+	}
+	// A digest the source pins is not part of a RepoTag
+	original, err := reference.WithTag(reference.TrimNamed(named), tagged.Tag())
+	if err != nil {
+		return nil
+	}
+	if m.options.RepoTags == config.RepoTagsOriginal {
+		return []string{reference.FamiliarString(original)}
+	}
+	return []string{familiarName(tag), reference.FamiliarString(original)}
+}
+
+// familiarName returns an image reference in the short form docker shows
+func familiarName(imageName string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return imageName
+	}
+	return reference.FamiliarString(named)
+}
+
+// manifestListTag returns the tag of the local multi-arch manifest list
+func manifestListTag(imageName string) string {
+	baseImage, tag := splitImageTag(imageName)
+	return fmt.Sprintf("%s:%s-allarch", baseImage, tag)
+}
@@ -0,0 +1,219 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+	"github.com/Fr000g/ImgMigrate/pkg/version"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/versions"
+)
+
+// MinAPIVersion is the oldest Docker Engine API version the tool supports
+const MinAPIVersion = "1.40"
+
+// pingTimeout limits how long a registry has to answer /v2/
+const pingTimeout = 10 * time.Second
+
+// CheckDaemon returns the docker versions in use and fails when the daemon
+// can't be reached or its API is older than MinAPIVersion
+func (c *Client) CheckDaemon() (VersionInfo, error) {
+	info, err := c.Version()
+	if err != nil {
+		return info, err
+	}
+	if versions.LessThan(info.APIVersion, MinAPIVersion) {
+		return info, fmt.Errorf("docker API version %s is older than the required %s", info.APIVersion, MinAPIVersion)
+	}
+	return info, nil
+}
+
+// DaemonProxy holds the proxies the docker daemon uses for pulls and pushes
+type DaemonProxy struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy string
+}
+
+// DaemonProxy returns the proxy configuration of the docker daemon, which
+// is independent of the proxy the tool itself uses
+func (c *Client) DaemonProxy() (DaemonProxy, error) {
+	info, err := c.cli.Info(c.ctx)
+	if err != nil {
+		return DaemonProxy{}, fmt.Errorf("failed to get docker daemon info: %w", errdefs.Classify(err, ""))
+	}
+	return DaemonProxy{HTTP: info.HTTPProxy, HTTPS: info.HTTPSProxy, NoProxy: info.NoProxy}, nil
+}
+
+// CheckCommand reports whether the docker CLI supports a subcommand, by
+// running it with --help
+func (c *Client) CheckCommand(args ...string) error {
+	output, err := c.runDocker(append(args, "--help")...)
+	if err != nil {
+		return fmt.Errorf("docker %s is not available: %w, output: %s", args[0], err, string(output))
+	}
+	return nil
+}
+
+// CheckRegistry verifies that a registry is reachable and, when credentials
+// for it are configured or stored by docker login, that it accepts them. The
+// credentials are validated by the daemon without being stored. It reports
+// whether credentials were checked.
+func (c *Client) CheckRegistry(host string, auth RegistryAuth) (bool, error) {
+	authConfig := storedAuth(host)
+	if auth, ok := c.authFor(host, auth); ok {
+		auth, err := auth.resolve(c.ctx)
+		if err != nil {
+			return true, fmt.Errorf("failed to get credentials for %s: %w", host, err)
+		}
+		authConfig = registrytypes.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password.Reveal(),
+			IdentityToken: auth.IdentityToken.Reveal(),
+			ServerAddress: registry.ConfigKey(host),
+		}
+	}
+
+	if authConfig.Username == "" && authConfig.IdentityToken == "" {
+		return false, pingRegistry(host, auth.Insecure, c.registryTLS(host), c.registryProxy(host))
+	}
+
+	if _, err := c.cli.RegistryLogin(c.ctx, authConfig); err != nil {
+		return true, fmt.Errorf("login to %s failed: %w", host, errdefs.Classify(err, ""))
+	}
+	return true, nil
+}
+
+// pingRegistry sends a HEAD request to the /v2/ endpoint of a registry. Any
+// answer from the registry, including 401, means it is reachable. Insecure
+// registries skip certificate verification and fall back to plain HTTP.
+func pingRegistry(host string, insecure bool, tlsOpts registry.TLSOptions, proxySetting string) error {
+	name := host
+	host = registry.APIHost(host)
+
+	tlsOpts.SkipVerify = tlsOpts.SkipVerify || insecure
+	tlsConfig, err := tlsOpts.Config()
+	if err != nil {
+		return fmt.Errorf("invalid TLS options for %s: %v", name, err)
+	}
+	proxy, err := registry.ProxyFunc(proxySetting)
+	if err != nil {
+		return fmt.Errorf("registry %s: %v", name, err)
+	}
+	client := &http.Client{Timeout: pingTimeout, Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: proxy}}
+
+	schemes := []string{"https"}
+	if insecure {
+		schemes = append(schemes, "http")
+	}
+
+	for _, scheme := range schemes {
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodHead, scheme+"://"+host+"/v2/", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", version.UserAgent())
+
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized {
+			return nil
+		}
+		return fmt.Errorf("registry %s answered /v2/ with %s", host, resp.Status)
+	}
+
+	if explained := registry.ExplainTLSError(name, err); explained != err {
+		return explained
+	}
+	return fmt.Errorf("registry %s is not reachable: %w", host, errdefs.Classify(err, ""))
+}
+
+// CheckSource resolves the manifest of a source image with the credentials a
+// pull would use, and returns its digest
+func (c *Client) CheckSource(imageName string, auth RegistryAuth) (string, error) {
+	encodedAuth, err := c.encodedAuthFor(imageName, auth)
+	if err != nil {
+		return "", err
+	}
+
+	inspect, err := c.cli.DistributionInspect(c.ctx, imageName, encodedAuth)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", imageName, errdefs.Classify(err, ""))
+	}
+	return inspect.Descriptor.Digest.String(), nil
+}
+
+// EstimateSize returns the compressed size of the layers of the platforms of
+// an image that match the given operating systems and architectures, as a
+// lower bound for the space needed to save them. All architectures are
+// included when archs is nil.
+func (c *Client) EstimateSize(imageName string, operatingSystems, archs []string) (int64, error) {
+	output, err := c.runDocker("manifest", "inspect", "--verbose", imageName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect manifest: %w, output: %s", err, string(output))
+	}
+
+	type layers struct {
+		Layers []struct {
+			Size int64 `json:"size"`
+		} `json:"layers"`
+	}
+	type verboseManifest struct {
+		Descriptor struct {
+			Platform *struct {
+				Architecture string `json:"architecture"`
+				OS           string `json:"os"`
+				Variant      string `json:"variant,omitempty"`
+			} `json:"platform"`
+		} `json:"Descriptor"`
+		SchemaV2Manifest *layers `json:"SchemaV2Manifest"`
+		OCIManifest      *layers `json:"OCIManifest"`
+	}
+
+	// A manifest list is printed as an array, a single manifest as an object
+	var manifests []verboseManifest
+	if err := json.Unmarshal(output, &manifests); err != nil {
+		var single verboseManifest
+		if err := json.Unmarshal(output, &single); err != nil {
+			return 0, fmt.Errorf("failed to parse manifest: %v", err)
+		}
+		manifests = []verboseManifest{single}
+	}
+
+	var size int64
+	for _, m := range manifests {
+		if m.Descriptor.Platform != nil {
+			platform := Platform{
+				OS:           m.Descriptor.Platform.OS,
+				Architecture: m.Descriptor.Platform.Architecture,
+				Variant:      m.Descriptor.Platform.Variant,
+			}
+			if len(c.filterPlatforms([]Platform{platform}, operatingSystems, archs)) == 0 {
+				continue
+			}
+		}
+
+		manifest := m.SchemaV2Manifest
+		if manifest == nil {
+			manifest = m.OCIManifest
+		}
+		if manifest == nil {
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+	}
+
+	return size, nil
+}
@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// progressInterval limits how often progress callbacks fire
+const progressInterval = 200 * time.Millisecond
+
+// layerProgress tracks the transferred and total bytes of a single layer
+type layerProgress struct {
+	current int64
+	total   int64
+}
+
+// streamed is what the JSON message stream of a pull or push announced: the
+// digest of the image, and the total size of the layers docker reported
+// as pushed, which it didn't find in the registry
+type streamed struct {
+	digest string
+	pushed int64
+}
+
+// streamProgress consumes the JSON message stream of an image pull or push,
+// reporting the aggregate byte progress across all layers through report.
+// What the stream announced until it ended or failed is returned.
+func streamProgress(r io.Reader, report func(current, total int64)) (streamed, error) {
+	decoder := json.NewDecoder(r)
+	layers := make(map[string]layerProgress)
+	var result streamed
+	var last time.Time
+
+	notify := func(force bool) {
+		if report == nil || (!force && time.Since(last) < progressInterval) {
+			return
+		}
+		var current, total int64
+		for _, layer := range layers {
+			current += layer.current
+			total += layer.total
+		}
+		report(current, total)
+		last = time.Now()
+	}
+
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return result, err
+		}
+
+		if msg.Error != nil {
+			return result, msg.Error
+		}
+
+		if msg.Aux != nil {
+			var aux struct {
+				Digest string `json:"Digest"`
+			}
+			if err := json.Unmarshal(*msg.Aux, &aux); err == nil && aux.Digest != "" {
+				result.digest = aux.Digest
+			}
+		}
+
+		// Pulls announce the digest they resolved as a status
+		if digest, ok := strings.CutPrefix(msg.Status, "Digest: "); ok {
+			result.digest = digest
+		}
+
+		if msg.ID == "" {
+			continue
+		}
+
+		switch {
+		case msg.Status == "Downloading" || msg.Status == "Pushing":
+			if msg.Progress != nil && msg.Progress.Total > 0 {
+				layers[msg.ID] = layerProgress{current: msg.Progress.Current, total: msg.Progress.Total}
+			}
+		case msg.Status == "Download complete" || msg.Status == "Pull complete" ||
+			msg.Status == "Already exists" || msg.Status == "Pushed" ||
+			msg.Status == "Layer already exists" || strings.HasPrefix(msg.Status, "Mounted from"):
+			if layer, ok := layers[msg.ID]; ok {
+				layer.current = layer.total
+				layers[msg.ID] = layer
+			}
+			if msg.Status == "Pushed" {
+				result.pushed += layers[msg.ID].total
+			}
+		}
+
+		notify(false)
+	}
+
+	notify(true)
+	return result, nil
+}
+
+// countingWriter counts the bytes written through it and reports the running
+// total through report, at most once per progressInterval
+type countingWriter struct {
+	w      io.Writer
+	report func(written int64)
+
+	mu      sync.Mutex
+	written int64
+	last    time.Time
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+
+	cw.mu.Lock()
+	cw.written += int64(n)
+	if cw.report != nil && time.Since(cw.last) >= progressInterval {
+		cw.report(cw.written)
+		cw.last = time.Now()
+	}
+	cw.mu.Unlock()
+
+	return n, err
+}
+
+// finish reports the final byte count
+func (cw *countingWriter) finish() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.report != nil {
+		cw.report(cw.written)
+	}
+}
@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// PulledImage is an image PullPlatform pulled under its own reference
+type PulledImage struct {
+	Reference string
+	Platform  string
+	// ID is the local image ID and Digest the repository digest the image
+	// was pulled by
+	ID     string
+	Digest string
+	// Path is the archive the image was saved to, if any, and SHA256 its
+	// checksum
+	Path   string
+	SHA256 string
+}
+
+// PullPlatform pulls a single platform of an image and keeps it under its
+// own reference, like docker pull --platform, without the architecture
+// tags of the migration pipeline. When outputDir is set the image is also
+// saved there, with the reference as its repository tag.
+func (c *Client) PullPlatform(imageName, platform, outputDir string, useCompression bool) (*PulledImage, error) {
+	served, err := c.fromSources(imageName, "Pull of "+platform, func(source string) error {
+		return c.withRetry("Pull of "+platform, func() error {
+			_, err := c.PullImage(c.ctx, source, PullImageOptions{
+				Platform: platform,
+				Progress: func(current, total int64) {
+					c.emit(PlatformPullProgress{Source: imageName, Platform: platform, Bytes: current, Total: total})
+				},
+			})
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if served != imageName {
+		if err := c.TagImage(c.ctx, served, imageName); err != nil {
+			return nil, err
+		}
+		c.infof("Pulled %s from mirror %s", imageName, served)
+	}
+	c.emit(PlatformPulled{Source: imageName, Platform: platform, PulledFrom: served})
+
+	inspect, err := c.cli.ImageInspect(c.ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect pulled image %s: %w", imageName, err)
+	}
+	pulled := &PulledImage{
+		Reference: imageName,
+		Platform:  platform,
+		ID:        inspect.ID,
+		Digest:    repoDigest(inspect.RepoDigests, served),
+	}
+	if outputDir == "" {
+		return pulled, nil
+	}
+
+	extension := ".tar"
+	if useCompression {
+		extension = ".tar.gz"
+	}
+	outputPath := filepath.Join(outputDir, hostRules.fileName(imageName)+extension)
+	total := c.imageSize(imageName)
+	saved, err := c.SaveImage(c.ctx, imageName, SaveImageOptions{
+		Path:        outputPath,
+		Compression: compression(useCompression),
+		Checksum:    true,
+		Progress: func(written int64) {
+			c.emit(PlatformSaveProgress{Source: imageName, Platform: platform, Bytes: written, Total: total})
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	checksum := saved.SHA256
+	pulled.Path = outputPath
+	pulled.SHA256 = checksum
+	if err := c.recordChecksum(outputPath, checksum, imageName, pulled.Digest); err != nil {
+		c.warnf("Failed to record the checksum of %s: %v", outputPath, err)
+	}
+
+	var size int64
+	if info, err := os.Stat(outputPath); err == nil {
+		size = info.Size()
+	}
+	c.emit(PlatformSaved{Source: imageName, Platform: platform, Tag: imageName, Path: outputPath, Size: size, SHA256: checksum})
+	return pulled, nil
+}
+
+// repoDigest returns the digest of the repository digest of an image that
+// belongs to the repository of served, or the first one if none does
+func repoDigest(repoDigests []string, served string) string {
+	var repo string
+	if named, err := reference.ParseNormalizedNamed(served); err == nil {
+		repo = named.Name()
+	}
+	digest := ""
+	for _, repoDigest := range repoDigests {
+		name, value, ok := strings.Cut(repoDigest, "@")
+		if !ok {
+			continue
+		}
+		if named, err := reference.ParseNormalizedNamed(name); err == nil && named.Name() == repo {
+			return value
+		}
+		if digest == "" {
+			digest = value
+		}
+	}
+	return digest
+}
@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hasRequirements reports whether the task requires more platforms than the
+// one every task needs
+func (o SaveOptions) hasRequirements() bool {
+	return len(o.RequirePlatforms) > 0 || o.MinSuccess > 1 || o.RequireAll
+}
+
+// unmetRequirement returns which platform requirement of options the
+// results miss, or an empty string when they are all met
+func unmetRequirement(options SaveOptions, platforms []PlatformResult) string {
+	succeeded := 0
+	for _, platform := range platforms {
+		if platform.Error == "" {
+			succeeded++
+		}
+	}
+
+	var missing []string
+	for _, required := range options.RequirePlatforms {
+		met := false
+		for _, platform := range platforms {
+			if platform.Error == "" && platformMatches(platform.Platform, required) {
+				met = true
+				break
+			}
+		}
+		if !met {
+			missing = append(missing, required)
+		}
+	}
+
+	switch {
+	case len(missing) > 0:
+		return fmt.Sprintf("require_platforms: %s did not succeed", strings.Join(missing, ", "))
+	case options.RequireAll && succeeded < len(platforms):
+		return fmt.Sprintf("min_success all: %d of %d platforms succeeded", succeeded, len(platforms))
+	case succeeded < options.MinSuccess:
+		return fmt.Sprintf("min_success %d: %d of %d platforms succeeded", options.MinSuccess, succeeded, len(platforms))
+	}
+	return ""
+}
+
+// platformMatches reports whether a platform in os/arch[/variant] form is
+// the required one, given with or without its OS and variant: arm64 and
+// linux/arm64 both match linux/arm64/v8
+func platformMatches(platform, required string) bool {
+	_, arch, _ := strings.Cut(platform, "/")
+	for _, candidate := range []string{platform, arch} {
+		if candidate == required || strings.HasPrefix(candidate, required+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,325 @@
+package docker
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// Pipeline stages recorded in task results
+const (
+	StageManifestInspect = "manifest_inspect"
+	StagePull            = "pull"
+	StageTag             = "tag"
+	StageSave            = "save"
+	StagePush            = "push"
+	StageManifestCreate  = "manifest_create"
+	StageVerify          = "verify"
+)
+
+// Duration is a time.Duration that is encoded in JSON as fractional seconds
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as seconds
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Seconds())
+}
+
+// UnmarshalJSON decodes a duration encoded as seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return err
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// String formats the duration rounded to milliseconds
+func (d Duration) String() string {
+	return time.Duration(d).Round(time.Millisecond).String()
+}
+
+// StageResult records the duration of a single pipeline stage and the bytes it moved
+type StageResult struct {
+	Stage    string   `json:"stage"`
+	Duration Duration `json:"duration_seconds"`
+	Bytes    int64    `json:"bytes,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// PlatformResult records the outcome of processing a single platform of an image
+type PlatformResult struct {
+	Platform string `json:"platform"`
+	// SourceDigest is the image manifest the source lists for the platform,
+	// if the source reported it
+	SourceDigest string `json:"source_digest,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	Path         string `json:"path,omitempty"`
+	// SHA256 is the checksum of the archive at Path
+	SHA256 string `json:"sha256,omitempty"`
+	// RepoTags are the names the archive at Path embeds for the image
+	RepoTags []string `json:"repo_tags,omitempty"`
+	// Compression records the compression picked for the archive at Path
+	// and its ratio, with the auto compression
+	Compression *archive.Choice `json:"compression,omitempty"`
+	// PulledFrom is the reference the platform was pulled from, which
+	// differs from the source when a mirror served it
+	PulledFrom string `json:"pulled_from,omitempty"`
+	// ReusedFrom is the local tag an earlier task pulled the platform to,
+	// when it was reused instead of pulled again
+	ReusedFrom string        `json:"reused_from,omitempty"`
+	Stages     []StageResult `json:"stages"`
+	Error      string        `json:"error,omitempty"`
+	// ErrorCategory classifies Error, if it was recognized
+	ErrorCategory errdefs.Category `json:"error_category,omitempty"`
+	// Deferred is set when the platform wasn't started because the run's
+	// transfer budget was used up, see WithPlatformBudget. It didn't fail
+	// and is left for a later run.
+	Deferred bool `json:"deferred,omitempty"`
+	// Transform records how the layers were squashed or recompressed
+	// before the push
+	Transform *LayerTransform `json:"transform,omitempty"`
+	// Stored records the image added to a shared blob store, which Path
+	// names then
+	Stored *StoredImage `json:"stored,omitempty"`
+	// Pushed records the push of the platform to the target, made even if
+	// a later step failed the platform
+	Pushed *PushedReference `json:"pushed,omitempty"`
+	// Storage records the blobs the push of the platform stored in the
+	// target registry
+	Storage *StorageUsage `json:"storage,omitempty"`
+	// DiskWait is how long the platform waited for disk space on the
+	// daemon before its pull, see WithDiskLimit
+	DiskWait Duration `json:"disk_wait_seconds,omitempty"`
+}
+
+// PushedReference records a tag a task pushed to the target registry, and
+// what it pointed to before, so the push can be rolled back
+type PushedReference struct {
+	Reference string `json:"reference"`
+	// Digest is the pushed digest, if the daemon or registry reported it
+	Digest string `json:"digest,omitempty"`
+	// Previous is the digest the tag pointed to before the push, empty if
+	// it didn't exist; PreviousUnknown is set when that couldn't be found
+	// out, or wasn't looked up because the task can't be rolled back
+	Previous        string `json:"previous,omitempty"`
+	PreviousUnknown bool   `json:"previous_unknown,omitempty"`
+}
+
+// StoredImage records an image added to a shared blob store: the size of
+// the archive it would have had on its own and of the blobs the store
+// didn't have yet
+type StoredImage struct {
+	Digest      string `json:"digest"`
+	ArchiveSize int64  `json:"archive_size"`
+	AddedSize   int64  `json:"added_size"`
+}
+
+// LayerTransform records how squashing or recompressing changed the
+// layers of a pushed image. Sizes are those of the compressed layers.
+type LayerTransform struct {
+	Squashed     bool   `json:"squashed,omitempty"`
+	Compression  string `json:"compression,omitempty"`
+	LayersBefore int    `json:"layers_before"`
+	LayersAfter  int    `json:"layers_after"`
+	SizeBefore   int64  `json:"size_before"`
+	SizeAfter    int64  `json:"size_after"`
+}
+
+// Duration returns the total time spent on the platform
+func (p PlatformResult) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range p.Stages {
+		total += time.Duration(stage.Duration)
+	}
+	return total
+}
+
+// TaskResult records the outcome of processing a single image
+type TaskResult struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  Duration  `json:"duration_seconds"`
+	// Paused is the part of Duration the task waited between its platforms,
+	// see WithPlatformGate, and for disk space, see WithDiskLimit
+	Paused Duration `json:"paused_seconds,omitempty"`
+	// DryRun is set when the task only resolved what it would do, see
+	// WithDryRun
+	DryRun bool `json:"dry_run,omitempty"`
+	// OutputDir is the directory the archives of the task were saved to
+	OutputDir    string           `json:"output_dir,omitempty"`
+	Stages       []StageResult    `json:"stages,omitempty"`
+	Platforms    []PlatformResult `json:"platforms"`
+	ManifestList string           `json:"manifest_list,omitempty"`
+	// Annotated lists the manifest lists annotations were added to or the
+	// metadata of the source index was carried over to
+	Annotated []AnnotatedManifest `json:"annotated,omitempty"`
+	// BandwidthLimit is the bandwidth limit the task ran with, in bytes per
+	// second
+	BandwidthLimit int64 `json:"bandwidth_limit,omitempty"`
+	// Pushed lists the tags the task pushed to the target, in the order
+	// they were pushed
+	Pushed []PushedReference `json:"pushed,omitempty"`
+	// Storage records the blobs the task stored in the target registry
+	Storage *StorageUsage `json:"storage,omitempty"`
+	// UnmetRequirement names the platform requirement of the task its
+	// platforms missed
+	UnmetRequirement string `json:"unmet_requirement,omitempty"`
+	// SourceChanges lists the digests the source tag moved to while the
+	// task ran
+	SourceChanges []SourceChange `json:"source_changes,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// StorageUsage records the size of the blobs pushed to a target registry:
+// NewBytes that of the blobs it didn't have yet and were uploaded, and
+// TotalBytes that of every blob of the pushed images. Estimated is set when
+// they were taken from docker push, which reports uncompressed layer
+// sizes, rather than counted through the registry API.
+type StorageUsage struct {
+	NewBytes   int64 `json:"new_bytes"`
+	TotalBytes int64 `json:"total_bytes"`
+	Estimated  bool  `json:"estimated,omitempty"`
+}
+
+// Add adds the sizes of other to s
+func (s *StorageUsage) Add(other StorageUsage) {
+	s.NewBytes += other.NewBytes
+	s.TotalBytes += other.TotalBytes
+	s.Estimated = s.Estimated || other.Estimated
+}
+
+// addStorage adds the size of blobs pushed to the target to the task
+func (r *TaskResult) addStorage(usage StorageUsage) {
+	if r.Storage == nil {
+		r.Storage = &StorageUsage{}
+	}
+	r.Storage.Add(usage)
+}
+
+// AnnotatedManifest records the media type, annotations and order given to
+// a manifest list in a registry, which changed its digest
+type AnnotatedManifest struct {
+	Reference   string            `json:"reference"`
+	MediaType   string            `json:"media_type"`
+	Annotations map[string]string `json:"annotations"`
+	// PlatformAnnotations are those of the image descriptors, by platform
+	PlatformAnnotations map[string]map[string]string `json:"platform_annotations,omitempty"`
+	// Order lists the platforms in the order they were put in to match the
+	// source
+	Order []string `json:"platform_order,omitempty"`
+	// Digest is the digest after adding the annotations and DigestBefore
+	// the one docker pushed
+	Digest        string `json:"digest"`
+	DigestBefore  string `json:"digest_before"`
+	DigestChanged bool   `json:"digest_changed"`
+}
+
+// Failed returns the number of platforms that failed
+func (r *TaskResult) Failed() int {
+	failed := 0
+	for _, platform := range r.Platforms {
+		if platform.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Deferred returns the number of deferred platforms
+func (r *TaskResult) Deferred() int {
+	deferred := 0
+	for _, platform := range r.Platforms {
+		if platform.Deferred {
+			deferred++
+		}
+	}
+	return deferred
+}
+
+// PushedDigest returns the digest the task pushed to reference, or an empty
+// string if it didn't push it or its digest wasn't reported
+func (r *TaskResult) PushedDigest(reference string) string {
+	for _, pushed := range r.Pushed {
+		if pushed.Reference == reference {
+			return pushed.Digest
+		}
+	}
+	return ""
+}
+
+// StageTotals sums durations and bytes per stage across the task and its
+// platforms, in pipeline order
+func (r *TaskResult) StageTotals() []StageResult {
+	order := []string{StageManifestInspect, StagePull, StageTag, StageSave, StagePush, StageManifestCreate, StageVerify}
+	totals := make(map[string]*StageResult)
+
+	add := func(stage StageResult) {
+		total, ok := totals[stage.Stage]
+		if !ok {
+			total = &StageResult{Stage: stage.Stage}
+			totals[stage.Stage] = total
+		}
+		total.Duration += stage.Duration
+		total.Bytes += stage.Bytes
+	}
+
+	for _, stage := range r.Stages {
+		add(stage)
+	}
+	for _, platform := range r.Platforms {
+		for _, stage := range platform.Stages {
+			add(stage)
+		}
+	}
+
+	var result []StageResult
+	for _, name := range order {
+		if total, ok := totals[name]; ok {
+			result = append(result, *total)
+		}
+	}
+	return result
+}
+
+// merge appends the stages and platforms of other to r
+func (r *TaskResult) merge(other *TaskResult) {
+	if other == nil {
+		return
+	}
+	if r.Target == "" {
+		r.Target = other.Target
+	}
+	if other.ManifestList != "" {
+		r.ManifestList = other.ManifestList
+	}
+	if other.Storage != nil {
+		r.addStorage(*other.Storage)
+	}
+	r.Stages = append(r.Stages, other.Stages...)
+	r.Platforms = append(r.Platforms, other.Platforms...)
+	r.SourceChanges = append(r.SourceChanges, other.SourceChanges...)
+}
+
+// timeStage runs fn as the named stage, appending its duration, byte count
+// and error to stages
+func timeStage(stages *[]StageResult, stage string, fn func() (int64, error)) error {
+	start := time.Now()
+	bytes, err := fn()
+	elapsed := time.Since(start)
+
+	observeStage(stage, elapsed)
+
+	result := StageResult{Stage: stage, Duration: Duration(elapsed), Bytes: bytes}
+	if err != nil {
+		result.Error = redact.String(err.Error())
+	}
+	*stages = append(*stages, result)
+
+	return err
+}
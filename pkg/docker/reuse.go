@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+)
+
+// reuseKey identifies a platform of a source image by its manifest digest,
+// so that different references to the same image share one entry
+func reuseKey(digest, platform string) string {
+	return digest + " " + platform
+}
+
+// reusableImage returns a local tag holding the platform of a source digest
+// that an earlier task in this process pulled, or "" if there is none or
+// the tag has been removed since
+func (c *Client) reusableImage(digest, platform string) string {
+	c.reuseMu.Lock()
+	tag := c.pulled[reuseKey(digest, platform)]
+	c.reuseMu.Unlock()
+
+	if tag == "" {
+		return ""
+	}
+	if _, err := c.runDocker("image", "inspect", tag); err != nil {
+		return ""
+	}
+	return tag
+}
+
+// rememberImage records the local tag holding a platform of a source digest
+func (c *Client) rememberImage(digest, platform, tag string) {
+	c.reuseMu.Lock()
+	defer c.reuseMu.Unlock()
+	c.pulled[reuseKey(digest, platform)] = tag
+}
+
+// archiveKey identifies an archive by the local tag saved, the names
+// embedded in it, its compression and its compatibility profile
+func archiveKey(tag string, repoTags []string, compression archive.Compression, compat archive.Compat) string {
+	return fmt.Sprintf("%s %s %s %s", tag, strings.Join(repoTags, ","), compression, compat)
+}
+
+// reusableArchive returns an archive an earlier task saved for the same
+// local tag, RepoTags, compression and compatibility profile, or "" if
+// there is none or it has been removed
+func (c *Client) reusableArchive(tag string, repoTags []string, compression archive.Compression, compat archive.Compat) string {
+	c.reuseMu.Lock()
+	path := c.archives[archiveKey(tag, repoTags, compression, compat)]
+	c.reuseMu.Unlock()
+
+	if path == "" {
+		return ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// rememberArchive records the archive saved for a local tag
+func (c *Client) rememberArchive(tag string, repoTags []string, compression archive.Compression, compat archive.Compat, path string) {
+	c.reuseMu.Lock()
+	defer c.reuseMu.Unlock()
+	c.archives[archiveKey(tag, repoTags, compression, compat)] = path
+}
+
+// copyArchive copies a saved archive to outputPath through a temporary
+// file, like saveImage, and returns the sha256 checksum of the copy
+func (c *Client) copyArchive(src, outputPath string) (checksum string, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive %s: %v", src, err)
+	}
+	defer in.Close()
+
+	tmp, err := c.createTemp(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), in); err != nil {
+		return "", fmt.Errorf("failed to copy archive %s: %v", src, err)
+	}
+	if err := c.commitTemp(tmp, outputPath); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", outputPath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,51 @@
+package docker
+
+import (
+	"errors"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// beforePush returns the record of a push of ref about to be made, with
+// the digest ref points to now when the task can be rolled back
+func (c *Client) beforePush(m migration, ref string) PushedReference {
+	pushed := PushedReference{Reference: ref, PreviousUnknown: true}
+	if !m.options.Rollback {
+		return pushed
+	}
+	host, repo, tag, err := splitImage(ref)
+	if err != nil {
+		c.warnf("Not recording what %s pointed to before the push: %v", ref, err)
+		return pushed
+	}
+	client, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		c.warnf("Not recording what %s pointed to before the push: %v", ref, err)
+		return pushed
+	}
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	digest, err := client.Digest(ctx, repo, tag)
+	switch {
+	case err == nil:
+		pushed.Previous = digest
+	case !errors.Is(err, errdefs.ErrNotFound):
+		c.warnf("Not recording what %s pointed to before the push, it can't be rolled back: %v", ref, err)
+		return pushed
+	}
+	pushed.PreviousUnknown = false
+	return pushed
+}
+
+// recordPushed adds a push to the pushed references of a task. A tag
+// pushed again, as when annotations rewrite a manifest list, keeps what it
+// pointed to before its first push.
+func recordPushed(result *TaskResult, pushed PushedReference) {
+	for i := range result.Pushed {
+		if result.Pushed[i].Reference == pushed.Reference {
+			result.Pushed[i].Digest = pushed.Digest
+			return
+		}
+	}
+	result.Pushed = append(result.Pushed, pushed)
+}
@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/runs"
+)
+
+// WithRunID scopes the local tags the client creates for saved platforms
+// to a run, so runs on the same daemon don't share them, and lets
+// RemoveIntermediate remove exactly those of the run
+func WithRunID(id string) ClientOption {
+	return func(c *Client) {
+		c.runID = id
+		c.runStarted = time.Now().UTC()
+	}
+}
+
+// RunID returns the ID of the run the client belongs to, if any
+func (c *Client) RunID() string {
+	return c.runID
+}
+
+// runTag returns the local tag of the run for tag: tag suffixed with the
+// run ID, such as nginx:1.25-linux-amd64-3f9a0c1e
+func (c *Client) runTag(tag string) string {
+	if c.runID == "" {
+		return tag
+	}
+	return tag + "-" + c.runID
+}
+
+// trackIntermediate records a local tag the run created
+func (c *Client) trackIntermediate(tag string) {
+	c.intermediateMu.Lock()
+	defer c.intermediateMu.Unlock()
+	if !slices.Contains(c.intermediate, tag) {
+		c.intermediate = append(c.intermediate, tag)
+	}
+}
+
+// RemoveIntermediate removes the local tags of the run, and only those: an
+// image keeps its other tags and is only deleted when the run's tag was
+// its last one. Tags of other runs are never touched, even when they name
+// the same image.
+func (c *Client) RemoveIntermediate() error {
+	c.intermediateMu.Lock()
+	tags := c.intermediate
+	c.intermediate = nil
+	c.intermediateMu.Unlock()
+	if len(tags) == 0 {
+		return nil
+	}
+
+	c.log.Debug(fmt.Sprintf("Removing %d local tags of run %s", len(tags), c.runID))
+	output, err := c.runDocker(append([]string{"image", "rm", "--no-prune"}, tags...)...)
+	if err != nil {
+		return fmt.Errorf("failed to remove the local tags of run %s: %w, output: %s", c.runID, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// recordRun lists an archive the run wrote with the source and digest it
+// was saved from, or with an empty path only the directory it saved to, in
+// the run index of that directory; the caller must hold checksumsMu
+func (c *Client) recordRun(dir, archivePath, source, sourceDigest string) error {
+	if c.runID == "" {
+		return nil
+	}
+	if !slices.Contains(c.runDirs, dir) {
+		c.runDirs = append(c.runDirs, dir)
+	}
+	run := runs.Run{ID: c.runID, StartedAt: c.runStarted}
+	if archivePath != "" {
+		run.Archives = []string{filepath.Base(archivePath)}
+		run.Saved = []runs.Saved{{
+			Archive:      filepath.Base(archivePath),
+			Source:       source,
+			SourceDigest: sourceDigest,
+			SavedAt:      time.Now().UTC(),
+		}}
+	}
+	return runs.Record(dir, run)
+}
+
+// KeepIntermediate records the local tags of the run in the run index of
+// every directory it saved to, instead of removing them, so gc can remove
+// them once they are old enough
+func (c *Client) KeepIntermediate() error {
+	c.intermediateMu.Lock()
+	tags := slices.Clone(c.intermediate)
+	c.intermediateMu.Unlock()
+	if len(tags) == 0 {
+		return nil
+	}
+
+	c.checksumsMu.Lock()
+	defer c.checksumsMu.Unlock()
+	for _, dir := range c.runDirs {
+		if err := runs.Record(dir, runs.Run{ID: c.runID, StartedAt: c.runStarted, Tags: tags}); err != nil {
+			return fmt.Errorf("failed to record the local tags of run %s: %w", c.runID, err)
+		}
+	}
+	return nil
+}
+
+// LocalTags returns those of tags that exist on the daemon
+func (c *Client) LocalTags(tags []string) []string {
+	var found []string
+	for _, tag := range tags {
+		if _, err := c.runDocker("image", "inspect", tag); err == nil {
+			found = append(found, tag)
+		}
+	}
+	return found
+}
+
+// RemoveTags removes local tags the way RemoveIntermediate does, one at a
+// time, and returns those it couldn't remove with the last error
+func (c *Client) RemoveTags(tags []string) ([]string, error) {
+	var failed []string
+	var lastErr error
+	for _, tag := range tags {
+		output, err := c.runDocker("image", "rm", "--no-prune", tag)
+		if err != nil {
+			failed = append(failed, tag)
+			lastErr = fmt.Errorf("failed to remove local tag %s: %w, output: %s", tag, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return failed, lastErr
+}
@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/metrics"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// smokeTest checks that the target serves every pushed platform of a task
+// as it was pushed, timing each check as the verify stage of the platform.
+// A platform that fails the check is failed with a verification error, as
+// is the task.
+func (c *Client) smokeTest(m migration, result *TaskResult) error {
+	var failed, pulled []string
+	for i := range result.Platforms {
+		platform := &result.Platforms[i]
+		if platform.Error != "" || platform.Pushed == nil {
+			continue
+		}
+		ref := smokeTestReference(result, *platform)
+		err := timeStage(&platform.Stages, StageVerify, func() (int64, error) {
+			didPull, err := c.verifyPlatform(m, ref, *platform)
+			if didPull && !slices.Contains(pulled, ref) {
+				pulled = append(pulled, ref)
+			}
+			return 0, err
+		})
+		if err != nil {
+			// The cause is formatted without wrapping, so the failure is
+			// classified as a failed verification only
+			err = &errdefs.VerificationError{Err: fmt.Errorf("smoke test of %s for %s failed: %v", ref, platform.Platform, err)}
+			metrics.Failures.Inc(m.source, platform.Platform, "verify")
+			platform.Error = redact.String(err.Error())
+			platform.ErrorCategory = errdefs.CategoryOf(err)
+			c.emit(PlatformFailed{Source: m.source, Platform: platform.Platform, Stage: StageVerify, Err: err})
+			c.errorf("%v", err)
+			failed = append(failed, platform.Platform)
+			continue
+		}
+		c.infof("Smoke test of %s for %s passed", ref, platform.Platform)
+	}
+
+	if m.options.SmokeTestRemove && len(pulled) > 0 {
+		output, err := c.runDocker(append([]string{"image", "rm", "--no-prune"}, pulled...)...)
+		if err != nil {
+			c.warnf("Failed to remove the images pulled by the smoke test: %v, output: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	if len(failed) > 0 {
+		return &errdefs.VerificationError{Err: fmt.Errorf("smoke test failed: the target does not serve %s as pushed", strings.Join(failed, ", "))}
+	}
+	return nil
+}
+
+// smokeTestReference returns the reference a pushed platform is checked
+// through: the target when the task pushed it, then its -allarch manifest
+// list, or else the architecture tag the platform was pushed to
+func smokeTestReference(result *TaskResult, platform PlatformResult) string {
+	for _, ref := range []string{result.Target, result.ManifestList} {
+		for _, pushed := range result.Pushed {
+			if ref != "" && pushed.Reference == ref {
+				return ref
+			}
+		}
+	}
+	return platform.Pushed.Reference
+}
+
+// verifyPlatform resolves the platform of ref through the registry API and
+// checks it is the pushed image, then pulls it into the daemon or checks
+// that the registry has each of its blobs. It reports whether it pulled
+// ref.
+func (c *Client) verifyPlatform(m migration, ref string, platform PlatformResult) (pulled bool, err error) {
+	host, repo, tag, err := splitImage(ref)
+	if err != nil {
+		return false, err
+	}
+	client, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	var resolved string
+	var entry registry.ImageEntry
+	err = c.withRetry("Smoke test of "+ref, func() error {
+		image, err := client.Inspect(ctx, repo, tag)
+		if err != nil {
+			return timeoutError(ctx, "Smoke test of "+ref, err)
+		}
+		resolved = image.Digest
+		entry, err = image.Entry(platform.Platform)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if platform.Pushed.Digest != "" && entry.Digest != platform.Pushed.Digest {
+		return false, fmt.Errorf("it resolves to %s, but %s was pushed", entry.Digest, platform.Pushed.Digest)
+	}
+
+	if m.options.SmokeTest == config.SmokeTestAPI {
+		blobs := entry.Layers
+		if entry.Config != nil {
+			blobs = append([]registry.Descriptor{*entry.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			exists, err := client.BlobExists(ctx, repo, blob.Digest)
+			if err != nil {
+				return false, err
+			}
+			if !exists {
+				return false, fmt.Errorf("the registry doesn't have the blob %s of %s", blob.Digest, entry.Digest)
+			}
+		}
+		return false, nil
+	}
+
+	err = c.withRetry("Smoke test pull of "+ref, func() error {
+		_, err := c.PullImage(ctx, ref, PullImageOptions{Platform: platform.Platform, Auth: m.auth})
+		return timeoutError(ctx, "Smoke test pull of "+ref, err)
+	})
+	if err != nil {
+		return false, err
+	}
+	inspect, err := c.cli.ImageInspect(ctx, ref)
+	if err != nil {
+		return true, fmt.Errorf("failed to inspect the pulled image: %v", err)
+	}
+	// The classic image store identifies images by their config, the
+	// containerd store by the manifest or list they were pulled through
+	if entry.Config != nil && inspect.ID != entry.Config.Digest && inspect.ID != entry.Digest && inspect.ID != resolved {
+		return true, fmt.Errorf("the daemon pulled %s, but the registry serves %s", inspect.ID, entry.Config.Digest)
+	}
+	return true, nil
+}
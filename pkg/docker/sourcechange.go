@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+)
+
+// SourceChange records that the source tag moved to another digest while
+// its task ran
+type SourceChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Action is the config.SourceChange action taken
+	Action string `json:"action"`
+}
+
+// sourceMoved is returned by migratePlatforms when the source moved and the
+// task is to be restarted
+type sourceMoved struct {
+	to string
+}
+
+func (e *sourceMoved) Error() string {
+	return "source moved to " + e.to
+}
+
+// sourceDigestAtStart resolves the digest the source tag points to as a
+// task starts, or returns an empty digest for tasks that can't mix
+// platforms: local images, sources pinned by digest and tasks pulling
+// every platform by digest
+func (c *Client) sourceDigestAtStart(m migration) string {
+	if c.dryRun || m.options.LocalOnly || m.options.PinDigests || strings.Contains(m.source, "@") {
+		return ""
+	}
+	digest, err := c.ResolveDigest(m.source)
+	if err != nil {
+		c.warnf("Not watching %s for changes during the task: %v", m.source, err)
+		return ""
+	}
+	return digest
+}
+
+// checkSourceChange resolves the source again before the manifest list is
+// created and, when it moved since the task started, warns, fails or asks
+// migrate to restart the task as m.options.OnSourceChange says
+func (c *Client) checkSourceChange(m migration, result *TaskResult, started string) error {
+	if started == "" {
+		return nil
+	}
+	current, err := c.ResolveDigest(m.source)
+	if err != nil {
+		c.warnf("Can't check whether %s changed during the task: %v", m.source, err)
+		return nil
+	}
+	if current == started {
+		return nil
+	}
+
+	action := m.options.OnSourceChange
+	if action == "" {
+		action = config.SourceChangeWarn
+	}
+	result.SourceChanges = append(result.SourceChanges, SourceChange{From: started, To: current, Action: action})
+	c.emit(SourceChanged{Source: m.source, From: started, To: current, Action: action})
+
+	switch action {
+	case config.SourceChangeFail:
+		return fmt.Errorf("source %s changed from %s to %s during the task", m.source, started, current)
+	case config.SourceChangeRetry:
+		return &sourceMoved{to: current}
+	}
+	c.warnf("Source %s changed from %s to %s during the task, its platforms may come from both", m.source, started, current)
+	return nil
+}
@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"context"
+	"os"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/metrics"
+)
+
+// blobStore returns the shared blob store at dir, opening it on first use
+func (c *Client) blobStore(dir string) (*archive.Store, error) {
+	c.storesMu.Lock()
+	defer c.storesMu.Unlock()
+	if store, ok := c.stores[dir]; ok {
+		return store, nil
+	}
+	store, err := archive.OpenStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.stores[dir] = store
+	return store, nil
+}
+
+// saveToStore saves an image to a temporary archive and adds it to the
+// shared blob store at dir under repoTags, so layers it shares with images
+// saved before are stored once
+func (c *Client) saveToStore(ctx context.Context, dir, tag string, repoTags []string, progress func(written int64)) (*archive.Stored, error) {
+	store, err := c.blobStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	temp, err := os.CreateTemp(dir, ".imgmigrate-save-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	if _, err := c.SaveImage(ctx, tag, SaveImageOptions{Path: temp.Name(), RepoTags: repoTags, Progress: progress}); err != nil {
+		return nil, err
+	}
+	if len(repoTags) == 0 {
+		repoTags = []string{familiarName(tag)}
+	}
+	stored, err := store.Add(temp.Name(), repoTags)
+	if err != nil {
+		return nil, err
+	}
+	c.checksumsMu.Lock()
+	defer c.checksumsMu.Unlock()
+	if err := c.recordRun(dir, "", "", ""); err != nil {
+		c.warnf("Failed to record run %s in %s: %v", c.runID, dir, err)
+	}
+	return stored, nil
+}
+
+// storeIndex lists the platforms of a task added to its blob store as one
+// multi-platform image named name, which push-archive can push as a whole
+func (c *Client) storeIndex(m migration, result *TaskResult, name string) {
+	var digests []string
+	for _, platform := range result.Platforms {
+		if platform.Stored != nil {
+			digests = append(digests, platform.Stored.Digest)
+		}
+	}
+	if len(digests) == 0 {
+		return
+	}
+	store, err := c.blobStore(m.options.BlobStore)
+	if err == nil {
+		_, err = store.AddIndex(name, digests)
+	}
+	if err != nil {
+		c.errorf("Failed to add the multi-arch image %s to the blob store %s: %v", name, m.options.BlobStore, err)
+		metrics.Failures.Inc(m.source, "", "manifest")
+		return
+	}
+	c.infof("Added the multi-arch image %s of %d platforms to the blob store %s", familiarName(name), len(digests), m.options.BlobStore)
+}
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// pushTransformed pushes a local platform image with its layers squashed
+// or recompressed. docker push can't change layers, so the image is saved
+// and pushed through the registry API instead. It returns the pushed image
+// and how its layers changed.
+func (c *Client) pushTransformed(ctx context.Context, m migration, tag, platform string, report func(current, total int64)) (*archive.Pushed, *archive.Transformed, error) {
+	// The docker CLI pushes the manifest list later
+	if err := c.loginRegistry(m.auth); err != nil {
+		return nil, nil, err
+	}
+	want, err := registry.ParsePlatform(platform)
+	if err != nil {
+		return nil, nil, err
+	}
+	host, repo, ref, err := splitImage(tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := c.newRegistryClient(host, m.auth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir, err := os.MkdirTemp(c.tmpDir, ".imgmigrate-transform-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	saved := filepath.Join(dir, "image.tar")
+	if _, err := c.SaveImage(ctx, tag, SaveImageOptions{Path: saved}); err != nil {
+		return nil, nil, err
+	}
+
+	var uploaded int64
+	pushed, err := archive.Push(ctx, saved, client, repo, ref, archive.PushOptions{
+		Transform: m.options.Transform,
+		Platform:  &want,
+		Progress: func(p archive.PushProgress) {
+			if p.Done {
+				uploaded += p.Bytes
+				report(uploaded, 0)
+				return
+			}
+			report(uploaded+p.Bytes, 0)
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, transformed := range pushed.Transformed {
+		if transformed.Platform.Matches(want) {
+			return pushed, &pushed.Transformed[i], nil
+		}
+	}
+	return pushed, nil, nil
+}
+
+// layerTransform returns the report entry of a transform
+func layerTransform(transform archive.Transform, transformed *archive.Transformed) *LayerTransform {
+	return &LayerTransform{
+		Squashed:     transform.Squash,
+		Compression:  string(transform.Recompress),
+		LayersBefore: transformed.LayersBefore,
+		LayersAfter:  transformed.LayersAfter,
+		SizeBefore:   transformed.SizeBefore,
+		SizeAfter:    transformed.SizeAfter,
+	}
+}
+
+// String describes the change of the layers for messages
+func (t *LayerTransform) String() string {
+	return fmt.Sprintf("%d layers (%s) to %d layers (%s)", t.LayersBefore, progress.FormatBytes(t.SizeBefore),
+		t.LayersAfter, progress.FormatBytes(t.SizeAfter))
+}
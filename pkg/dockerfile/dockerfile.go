@@ -0,0 +1,271 @@
+// Package dockerfile finds the base images of Dockerfiles in their FROM
+// instructions, so they can be mirrored before the Dockerfiles are built
+// where the original registries can't be reached.
+package dockerfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Base is an image a FROM instruction builds on
+type Base struct {
+	// Reference is the image with the build arguments substituted, and
+	// Original the image as the Dockerfile writes it
+	Reference string
+	Original  string
+	// Platform is the platform --platform selects, if given and resolved
+	Platform string
+	// Line is the line the FROM instruction starts on
+	Line int
+}
+
+// ParseFile returns the base images of the Dockerfile at path, see Parse
+func ParseFile(path string, buildArgs map[string]string) ([]Base, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	bases, err := Parse(f, buildArgs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return bases, nil
+}
+
+// Parse returns the images the FROM instructions of a Dockerfile build on,
+// leaving out scratch and the stages of the Dockerfile itself. Build
+// arguments are substituted from the ARG instructions before the first
+// FROM, buildArgs overriding their defaults as --build-arg does. An image
+// using an argument without a value is an error; a platform using one, such
+// as the $BUILDPLATFORM the builder sets, is left out.
+func Parse(r io.Reader, buildArgs map[string]string) ([]Base, error) {
+	instructions, err := split(r)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]string)
+	stages := make(map[string]bool)
+	seenFrom := false
+	var bases []Base
+	for _, inst := range instructions {
+		keyword, rest, _ := strings.Cut(inst.text, " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToUpper(keyword) {
+		case "ARG":
+			// Only the arguments declared before the first FROM apply to
+			// FROM instructions
+			if seenFrom {
+				continue
+			}
+			for _, decl := range strings.Fields(rest) {
+				name, value, hasDefault := strings.Cut(decl, "=")
+				if v, ok := buildArgs[name]; ok {
+					args[name] = v
+					continue
+				}
+				if hasDefault {
+					value, _ = expand(unquote(value), args)
+					args[name] = value
+				}
+			}
+
+		case "FROM":
+			seenFrom = true
+			base, stage, err := parseFrom(rest, args)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", inst.line, err)
+			}
+			if base.Reference != "scratch" && !stages[strings.ToLower(base.Reference)] {
+				base.Line = inst.line
+				bases = append(bases, base)
+			}
+			if stage != "" {
+				stages[stage] = true
+			}
+		}
+	}
+	return bases, nil
+}
+
+// parseFrom parses the arguments of a FROM instruction, returning the base
+// image and the lower case name of the stage it starts, if any
+func parseFrom(rest string, args map[string]string) (Base, string, error) {
+	fields := strings.Fields(rest)
+	var base Base
+	var platform string
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		if value, ok := strings.CutPrefix(fields[0], "--platform="); ok {
+			platform = value
+		}
+		fields = fields[1:]
+	}
+	switch {
+	case len(fields) == 0:
+		return base, "", fmt.Errorf("FROM names no image")
+	case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+	case len(fields) != 1:
+		return base, "", fmt.Errorf("invalid FROM %q, expected an image and an optional AS name", rest)
+	}
+
+	base.Original = fields[0]
+	reference, missing := expand(fields[0], args)
+	if len(missing) > 0 {
+		return base, "", fmt.Errorf("FROM %s uses the build arguments %s without a value; pass them with --build-arg", fields[0], strings.Join(missing, ", "))
+	}
+	base.Reference = reference
+	if platform != "" {
+		if resolved, missing := expand(platform, args); len(missing) == 0 {
+			base.Platform = resolved
+		}
+	}
+
+	stage := ""
+	if len(fields) == 3 {
+		stage = strings.ToLower(fields[2])
+	}
+	return base, stage, nil
+}
+
+// variable matches a reference to a build argument: $NAME, ${NAME} or
+// ${NAME<op>word} with the operators :-, -, :+ and +
+var variable = regexp.MustCompile(`\$(?:([A-Za-z_][A-Za-z0-9_]*)|\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-+])([^}]*))?\})`)
+
+// expand substitutes the build arguments of args in s, returning the names
+// of the arguments without a value that s needed
+func expand(s string, args map[string]string) (string, []string) {
+	var missing []string
+	expanded := variable.ReplaceAllStringFunc(s, func(match string) string {
+		parts := variable.FindStringSubmatch(match)
+		name := parts[1] + parts[2]
+		value, set := args[name]
+		switch op, word := parts[3], parts[4]; op {
+		case ":-":
+			if value == "" {
+				return word
+			}
+			return value
+		case "-":
+			if !set {
+				return word
+			}
+			return value
+		case ":+":
+			if value != "" {
+				return word
+			}
+			return ""
+		case "+":
+			if set {
+				return word
+			}
+			return ""
+		}
+		if !set && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	return expanded, missing
+}
+
+// unquote removes the quotes around an ARG default
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// instruction is an instruction joined from its continuation lines
+type instruction struct {
+	text string
+	line int
+}
+
+// heredoc matches the start of a here-document, <<EOF or <<-"EOF"
+var heredoc = regexp.MustCompile(`<<-?["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+
+// split reads the instructions of a Dockerfile: parser directives are read
+// for the escape character, comments are dropped, continuation lines are
+// joined and the bodies of here-documents skipped
+func split(r io.Reader) ([]instruction, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	escape := `\`
+	directives := true
+	var instructions []instruction
+	var current strings.Builder
+	start, line := 0, 0
+	var terminators []string
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+
+		if len(terminators) > 0 {
+			if strings.TrimLeft(text, "\t") == terminators[0] {
+				terminators = terminators[1:]
+			}
+			continue
+		}
+		if directives {
+			if key, value, ok := directive(trimmed); ok {
+				if key == "escape" {
+					escape = value
+				}
+				continue
+			}
+			directives = false
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if current.Len() == 0 {
+			start = line
+		} else {
+			current.WriteString(" ")
+		}
+		if continued, ok := strings.CutSuffix(trimmed, escape); ok {
+			current.WriteString(strings.TrimSpace(continued))
+			continue
+		}
+		current.WriteString(trimmed)
+		text = current.String()
+		current.Reset()
+		instructions = append(instructions, instruction{text: text, line: start})
+		for _, m := range heredoc.FindAllStringSubmatch(text, -1) {
+			terminators = append(terminators, m[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current.Len() > 0 {
+		instructions = append(instructions, instruction{text: current.String(), line: start})
+	}
+	return instructions, nil
+}
+
+// directive parses a parser directive such as "# escape=`"
+func directive(line string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(line, "#")
+	if !ok {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(rest, "=")
+	key = strings.ToLower(strings.TrimSpace(key))
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(value), true
+}
@@ -0,0 +1,76 @@
+package dockerfile
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Glob returns the files matching pattern, like filepath.Glob, where a **
+// path element also matches any number of directories, so
+// services/**/Dockerfile finds the Dockerfiles at any depth below services
+func Glob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+	elements := strings.Split(filepath.ToSlash(pattern), "/")
+	for _, element := range elements {
+		if element != "**" {
+			if _, err := filepath.Match(element, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Walk from the directory above the first element with a wildcard
+	static := 0
+	for static < len(elements)-1 && !strings.ContainsAny(elements[static], `*?[\`) {
+		static++
+	}
+	root := strings.Join(elements[:static], "/")
+	switch {
+	case static == 0:
+		root = "."
+	case root == "":
+		root = "/"
+	}
+	rest := elements[static:]
+
+	var matches []string
+	err := filepath.WalkDir(filepath.FromSlash(root), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(root), path)
+		if err != nil {
+			return nil
+		}
+		if match(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// match reports whether the path elements match the pattern elements
+func match(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if match(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+		return false
+	}
+	return match(pattern[1:], path[1:])
+}
@@ -0,0 +1,176 @@
+// Package ecr obtains registry credentials for Amazon ECR. Tokens are
+// requested through the AWS CLI rather than the AWS SDK, which keeps the
+// SDK and its dependencies out of the binary for the users of one
+// provider, while every source of the standard AWS credential chain the
+// CLI resolves still works. They are renewed before they expire.
+package ecr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Token timing
+const (
+	// refreshMargin renews a token this long before it expires, so an
+	// operation started with it can finish
+	refreshMargin = 30 * time.Minute
+	// commandTimeout limits how long the AWS CLI may take
+	commandTimeout = time.Minute
+)
+
+// awsCommand is the AWS CLI, looked up in PATH
+const awsCommand = "aws"
+
+// hostPattern matches ECR registry hosts and captures the account ID and region
+var hostPattern = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ParseHost returns the account ID and region of an ECR registry host
+func ParseHost(host string) (account, region string, ok bool) {
+	match := hostPattern.FindStringSubmatch(host)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// TokenSource provides the credentials of an ECR registry and caches them
+// until shortly before they expire. It implements registry.CredentialProvider.
+type TokenSource struct {
+	host    string
+	account string
+	region  string
+
+	mu      sync.Mutex
+	creds   registry.Credentials
+	expires time.Time
+}
+
+// NewTokenSource creates a token source for an ECR registry host. The
+// region is taken from the host unless region overrides it. The AWS CLI is
+// looked up here, so a missing one is reported before any image is pulled.
+func NewTokenSource(host, region string) (*TokenSource, error) {
+	account, hostRegion, ok := ParseHost(host)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ECR registry host (<account>.dkr.ecr.<region>.amazonaws.com)", host)
+	}
+	if _, err := exec.LookPath(awsCommand); err != nil {
+		return nil, missingCLI(host)
+	}
+	if region == "" {
+		region = hostRegion
+	}
+	return &TokenSource{host: host, account: account, region: region}, nil
+}
+
+// Credentials returns the cached credentials, requesting a new token when
+// there is none or it expires within refreshMargin
+func (s *TokenSource) Credentials(ctx context.Context) (registry.Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.creds.Empty() && time.Until(s.expires) > refreshMargin {
+		return s.creds, nil
+	}
+
+	creds, expires, err := s.fetch(ctx)
+	if err != nil {
+		return registry.Credentials{}, err
+	}
+	s.creds = creds
+	s.expires = expires
+	return creds, nil
+}
+
+// Invalidate discards the cached token
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = registry.Credentials{}
+}
+
+// fetch requests an authorization token with aws ecr get-authorization-token
+func (s *TokenSource) fetch(ctx context.Context) (registry.Credentials, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, awsCommand, "ecr", "get-authorization-token",
+		"--region", s.region, "--registry-ids", s.account, "--output", "json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); errors.Is(err, exec.ErrNotFound) {
+		return registry.Credentials{}, time.Time{}, missingCLI(s.host)
+	} else if err != nil {
+		return registry.Credentials{}, time.Time{}, &errdefs.AuthenticationError{
+			Err: fmt.Errorf("failed to get an ECR token for %s: %v: %s", s.host, err, strings.TrimSpace(stderr.String())),
+		}
+	}
+
+	var response struct {
+		AuthorizationData []struct {
+			AuthorizationToken string          `json:"authorizationToken"`
+			ExpiresAt          json.RawMessage `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return registry.Credentials{}, time.Time{}, fmt.Errorf("failed to parse the ECR token response for %s: %v", s.host, err)
+	}
+	if len(response.AuthorizationData) == 0 {
+		return registry.Credentials{}, time.Time{}, fmt.Errorf("ECR returned no token for %s", s.host)
+	}
+	data := response.AuthorizationData[0]
+
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return registry.Credentials{}, time.Time{}, fmt.Errorf("failed to decode the ECR token for %s: %v", s.host, err)
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return registry.Credentials{}, time.Time{}, fmt.Errorf("malformed ECR token for %s", s.host)
+	}
+
+	return registry.Credentials{Username: user, Password: pass}, parseExpiry(data.ExpiresAt), nil
+}
+
+// missingCLI returns the error of an ECR registry whose token can't be
+// requested because the AWS CLI isn't installed
+func missingCLI(host string) error {
+	return fmt.Errorf("auth_provider ecr of %s needs the AWS CLI, but %s is not in PATH; install it or give the registry credentials instead", host, awsCommand)
+}
+
+// parseExpiry reads the token expiry, which the AWS CLI prints as a
+// timestamp string or as seconds since the epoch. ECR tokens are valid for
+// 12 hours, which is assumed when the expiry can't be read.
+func parseExpiry(raw json.RawMessage) time.Time {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999-07:00"} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t
+			}
+		}
+		if seconds, err := strconv.ParseFloat(text, 64); err == nil {
+			return time.Unix(int64(seconds), 0)
+		}
+	}
+
+	var seconds float64
+	if json.Unmarshal(raw, &seconds) == nil && seconds > 0 {
+		return time.Unix(int64(seconds), 0)
+	}
+	return time.Now().Add(12 * time.Hour)
+}
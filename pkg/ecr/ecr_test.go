@@ -0,0 +1,105 @@
+package ecr
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		account string
+		region  string
+		ok      bool
+	}{
+		{"123456789012.dkr.ecr.eu-west-1.amazonaws.com", "123456789012", "eu-west-1", true},
+		{"123456789012.dkr.ecr-fips.us-east-1.amazonaws.com", "123456789012", "us-east-1", true},
+		{"123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn", "123456789012", "cn-north-1", true},
+		{"12345.dkr.ecr.eu-west-1.amazonaws.com", "", "", false},
+		{"public.ecr.aws", "", "", false},
+		{"registry.example.com", "", "", false},
+	}
+	for _, tt := range tests {
+		account, region, ok := ParseHost(tt.host)
+		if account != tt.account || region != tt.region || ok != tt.ok {
+			t.Errorf("ParseHost(%s) = %q, %q, %v, want %q, %q, %v", tt.host, account, region, ok, tt.account, tt.region, tt.ok)
+		}
+	}
+}
+
+// TestMissingCLI reports a missing AWS CLI when the token source is
+// created, before any token is needed
+func TestMissingCLI(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := NewTokenSource("123456789012.dkr.ecr.eu-west-1.amazonaws.com", "")
+	if err == nil || !strings.Contains(err.Error(), "needs the AWS CLI") {
+		t.Errorf("NewTokenSource without the AWS CLI: %v, want an error naming it", err)
+	}
+}
+
+// TestCredentials requests a token from a fake AWS CLI, which logs its
+// arguments, and caches it until shortly before it expires
+func TestCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake AWS CLI is a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "aws.log")
+	token := base64.StdEncoding.EncodeToString([]byte("AWS:secret"))
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	script := "#!/bin/sh\necho \"$*\" >> " + log + "\n" +
+		`echo '{"authorizationData": [{"authorizationToken": "` + token + `", "expiresAt": "` + expires + `"}]}'` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "aws"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	source, err := NewTokenSource("123456789012.dkr.ecr.eu-west-1.amazonaws.com", "eu-central-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		creds, err := source.Credentials(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.Username != "AWS" || creds.Password != "secret" {
+			t.Errorf("credentials %s:%s, want AWS:secret", creds.Username, creds.Password)
+		}
+	}
+	source.Invalidate()
+	if _, err := source.Credentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 2 {
+		t.Errorf("%d tokens requested, want 2: the cached one is renewed only when invalidated", len(calls))
+	}
+	if want := "ecr get-authorization-token --region eu-central-1 --registry-ids 123456789012 --output json"; calls[0] != want {
+		t.Errorf("aws %s, want aws %s", calls[0], want)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	want := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []string{`"2026-05-01T12:00:00Z"`, `"2026-05-01T14:00:00+02:00"`, `"2026-05-01T12:00:00.000000+00:00"`, `1777636800`, `"1777636800.0"`}
+	for _, raw := range tests {
+		if got := parseExpiry([]byte(raw)); !got.Equal(want) {
+			t.Errorf("parseExpiry(%s) = %v, want %v", raw, got, want)
+		}
+	}
+	if got := parseExpiry([]byte(`"soon"`)); time.Until(got) < 11*time.Hour {
+		t.Errorf("parseExpiry of an unreadable expiry = %v, want 12 hours from now", got)
+	}
+}
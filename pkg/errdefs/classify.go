@@ -0,0 +1,172 @@
+package errdefs
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	dockererrdefs "github.com/docker/docker/errdefs"
+)
+
+// patterns maps lower-cased fragments of daemon, registry and docker CLI
+// error output to failure categories. They are checked in order, so the
+// more specific messages come first.
+var patterns = []struct {
+	fragment string
+	category Category
+}{
+	{"no space left on device", CategoryDiskFull},
+	{"toomanyrequests", CategoryRateLimit},
+	{"too many requests", CategoryRateLimit},
+	{"rate limit", CategoryRateLimit},
+	{"service unavailable", CategoryUnavailable},
+	{"503 service", CategoryUnavailable},
+	// Docker Hub reports both missing and inaccessible repositories this way;
+	// a missing repository is by far the more common cause
+	{"repository does not exist", CategoryNotFound},
+	{"manifest unknown", CategoryNotFound},
+	{"name unknown", CategoryNotFound},
+	{"no such manifest", CategoryNotFound},
+	{"no such image", CategoryNotFound},
+	{"not found: manifest", CategoryNotFound},
+	{"requested access to the resource is denied", CategoryAuthorization},
+	{"insufficient_scope", CategoryAuthorization},
+	{"403 forbidden", CategoryAuthorization},
+	{"denied:", CategoryAuthorization},
+	{"unauthorized", CategoryAuthentication},
+	{"authentication required", CategoryAuthentication},
+	{"incorrect username or password", CategoryAuthentication},
+	{"no basic auth credentials", CategoryAuthentication},
+	{"dial tcp", CategoryNetwork},
+	{"i/o timeout", CategoryNetwork},
+	{"connection refused", CategoryNetwork},
+	{"connection reset", CategoryNetwork},
+	{"no such host", CategoryNetwork},
+	{"tls handshake timeout", CategoryNetwork},
+	{"network is unreachable", CategoryNetwork},
+}
+
+// Classify wraps err in the typed error recognized from the error itself or
+// from the command output that accompanied it. The message is unchanged.
+// Errors that are already classified or can't be recognized are returned as is.
+func Classify(err error, output string) error {
+	if err == nil || CategoryOf(err) != "" {
+		return err
+	}
+
+	switch detect(err, output) {
+	case CategoryAuthentication:
+		return &AuthenticationError{Err: err}
+	case CategoryAuthorization:
+		return &AuthorizationError{Err: err}
+	case CategoryNotFound:
+		return &NotFoundError{Err: err}
+	case CategoryRateLimit:
+		return &RateLimitError{Err: err, RetryAfter: retryAfter(err, output)}
+	case CategoryUnavailable:
+		return &UnavailableError{Err: err, RetryAfter: retryAfter(err, output)}
+	case CategoryNetwork:
+		return &NetworkError{Err: err}
+	case CategoryDiskFull:
+		return &DiskFullError{Err: err}
+	}
+	return err
+}
+
+// FromHTTPStatus wraps err, the failure of a request answered with the
+// HTTP status code, in the typed error of the status. Other statuses are
+// classified from the message of err as Classify does, and server errors
+// that still aren't recognized count as network errors.
+func FromHTTPStatus(code int, err error) error {
+	return fromStatus(code, err, 0)
+}
+
+// FromHTTPResponse is FromHTTPStatus for resp, taking how long a 429 or
+// 503 response asked to wait from its Retry-After header
+func FromHTTPResponse(resp *http.Response, err error) error {
+	wait, _ := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return fromStatus(resp.StatusCode, err, wait)
+}
+
+func fromStatus(code int, err error, wait time.Duration) error {
+	switch code {
+	case http.StatusUnauthorized:
+		return &AuthenticationError{Err: err}
+	case http.StatusForbidden:
+		return &AuthorizationError{Err: err}
+	case http.StatusNotFound:
+		return &NotFoundError{Err: err}
+	case http.StatusTooManyRequests:
+		return &RateLimitError{Err: err, RetryAfter: wait}
+	case http.StatusServiceUnavailable:
+		return &UnavailableError{Err: err, RetryAfter: wait}
+	}
+	if err = Classify(err, ""); CategoryOf(err) == "" && code >= 500 {
+		return &NetworkError{Err: err}
+	}
+	return err
+}
+
+// retryAfterPattern finds the wait a registry asked for in docker output,
+// which repeats the Retry-After header as "Retry-After: 120" or says
+// "retry after 2m"
+var retryAfterPattern = regexp.MustCompile(`(?i)retry[-_ ]after["']?\s*[:=]?\s*"?([0-9][0-9a-z.]*)`)
+
+// retryAfter returns the wait err or its output asked for, or zero
+func retryAfter(err error, output string) time.Duration {
+	match := retryAfterPattern.FindStringSubmatch(err.Error() + "\n" + output)
+	if match == nil {
+		return 0
+	}
+	wait, _ := ParseRetryAfter(match[1], time.Now())
+	return wait
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, a number of
+// seconds or an HTTP date, relative to now. A Go duration such as 2m30s is
+// accepted too, as some registries write one in their messages.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if wait, err := time.ParseDuration(value); err == nil {
+		return max(wait, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// detect returns the category of err, or "" if it isn't recognized
+func detect(err error, output string) Category {
+	message := strings.ToLower(err.Error() + "\n" + output)
+	for _, pattern := range patterns {
+		if strings.Contains(message, pattern.fragment) {
+			return pattern.category
+		}
+	}
+
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return CategoryDiskFull
+	case dockererrdefs.IsUnauthorized(err):
+		return CategoryAuthentication
+	case dockererrdefs.IsForbidden(err):
+		return CategoryAuthorization
+	case dockererrdefs.IsNotFound(err):
+		return CategoryNotFound
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return CategoryNetwork
+	}
+	return ""
+}
@@ -0,0 +1,267 @@
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	dockererrdefs "github.com/docker/docker/errdefs"
+)
+
+// TestClassify classifies the errors docker and registries report, as the
+// docker CLI, the daemon and the registry API client word them
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        string
+		output     string
+		category   Category
+		retryAfter time.Duration
+	}{
+		{
+			name:     "pull without credentials",
+			err:      `Error response from daemon: Head "https://registry.example.com/v2/team/app/manifests/1.0": unauthorized: authentication required`,
+			category: CategoryAuthentication,
+		},
+		{
+			name:     "login with a wrong password",
+			err:      "exit status 1",
+			output:   `Error response from daemon: Get "https://registry-1.docker.io/v2/": unauthorized: incorrect username or password`,
+			category: CategoryAuthentication,
+		},
+		{
+			name:     "push without a login",
+			err:      "exit status 1",
+			output:   "The push refers to repository [registry.example.com/team/app]\n5f70bf18a086: Preparing\nno basic auth credentials",
+			category: CategoryAuthentication,
+		},
+		{
+			name:     "registry API 401",
+			err:      `GET https://registry.example.com/v2/team/app/manifests/1.0: 401 Unauthorized: {"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`,
+			category: CategoryAuthentication,
+		},
+		{
+			name:     "push to a repository of another account",
+			err:      "exit status 1",
+			output:   "The push refers to repository [docker.io/someone/app]\n5f70bf18a086: Preparing\ndenied: requested access to the resource is denied",
+			category: CategoryAuthorization,
+		},
+		{
+			name:     "token without push scope",
+			err:      `GET https://auth.example.com/token?scope=repository%3Ateam%2Fapp%3Apush: 403 Forbidden: {"errors":[{"code":"DENIED","message":"insufficient_scope: authorization failed"}]}`,
+			category: CategoryAuthorization,
+		},
+		{
+			name:     "package permission",
+			err:      "exit status 1",
+			output:   "denied: permission_denied: write_package",
+			category: CategoryAuthorization,
+		},
+		{
+			name:     "pull of a private or missing Hub repository",
+			err:      "Error response from daemon: pull access denied for team/private, repository does not exist or may require 'docker login': denied: requested access to the resource is denied",
+			category: CategoryNotFound,
+		},
+		{
+			name:     "missing tag",
+			err:      "Error response from daemon: manifest for nginx:nope not found: manifest unknown: manifest unknown",
+			category: CategoryNotFound,
+		},
+		{
+			name:     "missing repository",
+			err:      `GET https://123456789012.dkr.ecr.eu-west-1.amazonaws.com/v2/app/manifests/1.0: 404 Not Found: {"errors":[{"code":"NAME_UNKNOWN","message":"name unknown: The repository with name 'app' does not exist in the registry with id '123456789012'"}]}`,
+			category: CategoryNotFound,
+		},
+		{
+			name:     "missing local image",
+			err:      "Error response from daemon: No such image: nginx:nope",
+			category: CategoryNotFound,
+		},
+		{
+			name:     "Hub pull rate limit",
+			err:      "Error response from daemon: toomanyrequests: You have reached your pull rate limit. You may increase the limit by authenticating and upgrading: https://www.docker.com/increase-rate-limit",
+			category: CategoryRateLimit,
+		},
+		{
+			name:       "registry API 429",
+			err:        "GET https://registry.example.com/v2/team/app/manifests/1.0: 429 Too Many Requests",
+			output:     "Retry-After: 120",
+			category:   CategoryRateLimit,
+			retryAfter: 2 * time.Minute,
+		},
+		{
+			name:       "rate limit with a wait in the message",
+			err:        "exit status 1",
+			output:     "toomanyrequests: rate limit exceeded, retry after 2m30s",
+			category:   CategoryRateLimit,
+			retryAfter: 2*time.Minute + 30*time.Second,
+		},
+		{
+			name:     "registry in maintenance",
+			err:      "received unexpected HTTP status: 503 Service Unavailable",
+			category: CategoryUnavailable,
+		},
+		{
+			name:     "unknown registry host",
+			err:      `Error response from daemon: Get "https://registry.example.com/v2/": dial tcp: lookup registry.example.com on 127.0.0.53:53: no such host`,
+			category: CategoryNetwork,
+		},
+		{
+			name:     "registry down",
+			err:      `Error response from daemon: Get "https://10.0.0.5:5000/v2/": dial tcp 10.0.0.5:5000: connect: connection refused`,
+			category: CategoryNetwork,
+		},
+		{
+			name:     "slow TLS",
+			err:      `Get "https://10.0.0.5:5000/v2/": net/http: TLS handshake timeout`,
+			category: CategoryNetwork,
+		},
+		{
+			name:     "connection reset during a push",
+			err:      "exit status 1",
+			output:   "5f70bf18a086: Pushing [=====>      ]  12.3MB/45.6MB\nwrite tcp 10.0.0.2:51234->10.0.0.5:5000: write: connection reset by peer",
+			category: CategoryNetwork,
+		},
+		{
+			name:     "daemon out of space during a pull",
+			err:      "exit status 1",
+			output:   "failed to register layer: write /var/lib/docker/overlay2/3f1c/diff/usr/lib/libLLVM.so: no space left on device",
+			category: CategoryDiskFull,
+		},
+		{
+			name: "invalid reference",
+			err:  "Error parsing reference: \"nginx:\" is not a valid repository/tag: invalid reference format",
+		},
+		{
+			name:   "unsupported platform",
+			err:    "exit status 1",
+			output: "no matching manifest for linux/s390x in the manifest list entries",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := errors.New(tt.err)
+			classified := Classify(err, tt.output)
+			if category := CategoryOf(classified); category != tt.category {
+				t.Errorf("category = %q, want %q", category, tt.category)
+			}
+			if classified.Error() != tt.err {
+				t.Errorf("message = %q, want it unchanged", classified.Error())
+			}
+			if !errors.Is(classified, err) {
+				t.Error("classified error doesn't wrap the error")
+			}
+			if wait := RetryAfter(classified); wait != tt.retryAfter {
+				t.Errorf("RetryAfter = %v, want %v", wait, tt.retryAfter)
+			}
+		})
+	}
+}
+
+// TestClassifyTyped classifies errors by their type where their message
+// says nothing
+func TestClassifyTyped(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category Category
+	}{
+		{"ENOSPC", &os.PathError{Op: "write", Path: "out/app.tar", Err: syscall.ENOSPC}, CategoryDiskFull},
+		{"daemon unauthorized", dockererrdefs.Unauthorized(errors.New("login required")), CategoryAuthentication},
+		{"daemon forbidden", dockererrdefs.Forbidden(errors.New("pulls are blocked")), CategoryAuthorization},
+		{"daemon not found", dockererrdefs.NotFound(errors.New("image gone")), CategoryNotFound},
+		{"net.Error", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, CategoryNetwork},
+		{"already classified", &TimeoutError{Err: errors.New("no such host")}, CategoryTimeout},
+		{"wrapped", fmt.Errorf("pull: %w", &NotFoundError{Err: errors.New("unauthorized")}), CategoryNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if category := CategoryOf(Classify(tt.err, "")); category != tt.category {
+				t.Errorf("category = %q, want %q", category, tt.category)
+			}
+		})
+	}
+	if err := Classify(nil, "unauthorized"); err != nil {
+		t.Errorf("Classify(nil) = %v", err)
+	}
+}
+
+func TestFromHTTPStatus(t *testing.T) {
+	tests := []struct {
+		code     int
+		message  string
+		category Category
+	}{
+		{http.StatusUnauthorized, "GET /v2/ returned 401 Unauthorized", CategoryAuthentication},
+		{http.StatusForbidden, "GET /v2/team/app/manifests/v1 returned 403 Forbidden", CategoryAuthorization},
+		{http.StatusNotFound, "fetching https://config.example.com/migrate.yaml: 404 Not Found", CategoryNotFound},
+		{http.StatusTooManyRequests, "downloading https://files.example.com/app.tar: 429 Too Many Requests", CategoryRateLimit},
+		{http.StatusServiceUnavailable, "harbor GET /api/v2.0/projects returned 503 Service Unavailable", CategoryUnavailable},
+		{http.StatusBadGateway, "uploading https://files.example.com/app.tar: 502 Bad Gateway", CategoryNetwork},
+		{http.StatusInternalServerError, "PUT /v2/team/app/blobs/uploads/1 returned 500: no space left on device", CategoryDiskFull},
+		{http.StatusBadRequest, "PUT /v2/team/app/manifests/v1 returned 400: MANIFEST_INVALID: manifest invalid", ""},
+		{http.StatusBadRequest, "PUT /v2/team/app/manifests/v1 returned 400: DENIED: requested access to the resource is denied", CategoryAuthorization},
+	}
+	for _, tt := range tests {
+		err := FromHTTPStatus(tt.code, errors.New(tt.message))
+		if category := CategoryOf(err); category != tt.category {
+			t.Errorf("FromHTTPStatus(%d, %q) is %q, want %q", tt.code, tt.message, category, tt.category)
+		}
+		if err.Error() != tt.message {
+			t.Errorf("FromHTTPStatus(%d) changed the message to %q", tt.code, err)
+		}
+	}
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}}
+	if wait := RetryAfter(FromHTTPResponse(resp, errors.New("toomanyrequests"))); wait != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want the 30s of the Retry-After header", wait)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&RateLimitError{Err: errors.New("toomanyrequests")}, true},
+		{&UnavailableError{Err: errors.New("503 Service Unavailable")}, true},
+		{&NetworkError{Err: errors.New("connection reset")}, true},
+		{&TimeoutError{Err: &NetworkError{Err: errors.New("i/o timeout")}}, false},
+		{&AuthenticationError{Err: errors.New("unauthorized")}, false},
+		{&NotFoundError{Err: errors.New("manifest unknown")}, false},
+		{errors.New("exit status 1"), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%T %v) = %v, want %v", tt.err, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 7, 9, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-3", 0, true},
+		{"2m30s", 2*time.Minute + 30*time.Second, true},
+		{"Wed, 09 Jul 2025 08:31:00 GMT", time.Minute, true},
+		{"Wed, 09 Jul 2025 08:29:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package errdefs defines the classes of registry and daemon failures the
+// tool reacts to. Errors are classified once, where docker output is parsed,
+// and can then be tested anywhere with errors.Is against the sentinels or
+// errors.As against the typed errors.
+package errdefs
+
+import (
+	"errors"
+	"time"
+)
+
+// Category names a class of failure in reports
+type Category string
+
+// Failure categories
+const (
+	CategoryAuthentication Category = "authentication"
+	CategoryAuthorization  Category = "authorization"
+	CategoryNotFound       Category = "not_found"
+	CategoryRateLimit      Category = "rate_limit"
+	CategoryUnavailable    Category = "unavailable"
+	CategoryNetwork        Category = "network"
+	CategoryDiskFull       Category = "disk_full"
+	CategoryTimeout        Category = "timeout"
+	CategoryVerification   Category = "verification"
+)
+
+// Sentinels matched by the typed errors below
+var (
+	ErrAuthentication = errors.New("registry authentication failed")
+	ErrAuthorization  = errors.New("registry access denied")
+	ErrNotFound       = errors.New("image not found")
+	ErrRateLimited    = errors.New("registry rate limit exceeded")
+	ErrUnavailable    = errors.New("registry temporarily unavailable")
+	ErrNetwork        = errors.New("network error")
+	ErrDiskFull       = errors.New("no space left on device")
+	ErrTimeout        = errors.New("timed out")
+	ErrVerification   = errors.New("verification failed")
+)
+
+// AuthenticationError reports missing or rejected credentials (401)
+type AuthenticationError struct{ Err error }
+
+// AuthorizationError reports valid credentials without access to the repository (403)
+type AuthorizationError struct{ Err error }
+
+// NotFoundError reports a missing repository, tag or manifest
+type NotFoundError struct{ Err error }
+
+// RateLimitError reports that the registry throttled the request (429).
+// RetryAfter is how long the registry asked to wait, if it said.
+type RateLimitError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// UnavailableError reports a registry that is temporarily unable to serve
+// requests (503), such as during maintenance or garbage collection.
+// RetryAfter is how long the registry asked to wait, if it said.
+type UnavailableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// NetworkError reports a connection failure or timeout
+type NetworkError struct{ Err error }
+
+// DiskFullError reports that the daemon or the output directory ran out of space
+type DiskFullError struct{ Err error }
+
+// TimeoutError reports an operation, task or run cut short by its
+// configured timeout
+type TimeoutError struct{ Err error }
+
+// VerificationError reports a pushed image the target registry doesn't
+// serve as it was pushed
+type VerificationError struct{ Err error }
+
+func (e *AuthenticationError) Error() string { return e.Err.Error() }
+func (e *AuthorizationError) Error() string  { return e.Err.Error() }
+func (e *NotFoundError) Error() string       { return e.Err.Error() }
+func (e *RateLimitError) Error() string      { return e.Err.Error() }
+func (e *UnavailableError) Error() string    { return e.Err.Error() }
+func (e *NetworkError) Error() string        { return e.Err.Error() }
+func (e *DiskFullError) Error() string       { return e.Err.Error() }
+func (e *TimeoutError) Error() string        { return e.Err.Error() }
+func (e *VerificationError) Error() string   { return e.Err.Error() }
+
+func (e *AuthenticationError) Unwrap() error { return e.Err }
+func (e *AuthorizationError) Unwrap() error  { return e.Err }
+func (e *NotFoundError) Unwrap() error       { return e.Err }
+func (e *RateLimitError) Unwrap() error      { return e.Err }
+func (e *UnavailableError) Unwrap() error    { return e.Err }
+func (e *NetworkError) Unwrap() error        { return e.Err }
+func (e *DiskFullError) Unwrap() error       { return e.Err }
+func (e *TimeoutError) Unwrap() error        { return e.Err }
+func (e *VerificationError) Unwrap() error   { return e.Err }
+
+func (e *AuthenticationError) Is(target error) bool { return target == ErrAuthentication }
+func (e *AuthorizationError) Is(target error) bool  { return target == ErrAuthorization }
+func (e *NotFoundError) Is(target error) bool       { return target == ErrNotFound }
+func (e *RateLimitError) Is(target error) bool      { return target == ErrRateLimited }
+func (e *UnavailableError) Is(target error) bool    { return target == ErrUnavailable }
+func (e *NetworkError) Is(target error) bool        { return target == ErrNetwork }
+func (e *DiskFullError) Is(target error) bool       { return target == ErrDiskFull }
+func (e *TimeoutError) Is(target error) bool        { return target == ErrTimeout }
+func (e *VerificationError) Is(target error) bool   { return target == ErrVerification }
+
+// categories maps each sentinel to its report category, in precedence order.
+// A timeout comes first: the error it cut short may look like any other.
+// A failed verification comes next, as the push it checked succeeded.
+var categories = []struct {
+	sentinel error
+	category Category
+}{
+	{ErrTimeout, CategoryTimeout},
+	{ErrVerification, CategoryVerification},
+	{ErrAuthentication, CategoryAuthentication},
+	{ErrAuthorization, CategoryAuthorization},
+	{ErrNotFound, CategoryNotFound},
+	{ErrRateLimited, CategoryRateLimit},
+	{ErrUnavailable, CategoryUnavailable},
+	{ErrNetwork, CategoryNetwork},
+	{ErrDiskFull, CategoryDiskFull},
+}
+
+// CategoryOf returns the failure category of err, or "" if it is unclassified
+func CategoryOf(err error) Category {
+	if err == nil {
+		return ""
+	}
+	for _, c := range categories {
+		if errors.Is(err, c.sentinel) {
+			return c.category
+		}
+	}
+	return ""
+}
+
+// Retryable reports whether err is a transient failure worth retrying.
+// Timeouts are not: the time they allowed has run out.
+func Retryable(err error) bool {
+	if errors.Is(err, ErrTimeout) {
+		return false
+	}
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrNetwork)
+}
+
+// RetryAfter returns how long the registry asked to wait before the request
+// that failed with err is sent again, or zero if it didn't say
+func RetryAfter(err error) time.Duration {
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) && rateLimit.RetryAfter > 0 {
+		return rateLimit.RetryAfter
+	}
+	var unavailable *UnavailableError
+	if errors.As(err, &unavailable) && unavailable.RetryAfter > 0 {
+		return unavailable.RetryAfter
+	}
+	return 0
+}
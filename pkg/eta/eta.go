@@ -0,0 +1,234 @@
+// Package eta estimates how long the current task and the rest of a run
+// will take from the events of the docker client: the download sizes the
+// pulls report, taken from the layer sizes of the manifests, and the time
+// completed tasks took for theirs.
+package eta
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+)
+
+// Estimator follows the tasks of a run and estimates the time they have
+// left. It is registered as an event handler of the client for the sizes of
+// the pulls, and told by the run loop when a task starts, since a task can
+// be skipped before it emits any event or complete as several client tasks.
+type Estimator struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	tasks   int
+	current int
+	started time.Time
+	// sizes holds the download size of every platform of the current task
+	sizes map[string]int64
+
+	// completed tasks: their count and durations, and the durations and
+	// download sizes of those that pulled anything
+	completed int
+	elapsed   time.Duration
+	sized     time.Duration
+	bytes     int64
+
+	predictions []prediction
+	finished    time.Time
+}
+
+// prediction is an estimate of when the run ends, made when a task started
+type prediction struct {
+	at  time.Time
+	end time.Time
+}
+
+// Estimate is what an Estimator expects at a point of the run
+type Estimate struct {
+	// Task and Tasks are the number of the current task, counted from 1,
+	// and the number of tasks of the run
+	Task  int
+	Tasks int
+	// TaskRemaining and RunRemaining are the time the current task and the
+	// whole run are expected to take still; Known is false until a task
+	// completed to measure them by
+	TaskRemaining time.Duration
+	RunRemaining  time.Duration
+	Known         bool
+}
+
+// Accuracy compares the estimates of a run with how long it took
+type Accuracy struct {
+	// Predictions is the number of estimates of the end of the run
+	Predictions int `json:"predictions"`
+	// MeanError is the mean absolute difference between the estimated and
+	// the actual end of the run, and MeanRelativeError that difference
+	// relative to the time the run had left when it was estimated
+	MeanError         docker.Duration `json:"mean_error_seconds"`
+	MeanRelativeError float64         `json:"mean_relative_error"`
+}
+
+// New returns an estimator for a run without tasks yet
+func New() *Estimator {
+	return &Estimator{now: time.Now, current: -1}
+}
+
+// Reset starts estimating a run of tasks tasks
+func (e *Estimator) Reset(tasks int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tasks, e.current = tasks, -1
+	e.started, e.sizes = time.Time{}, nil
+	e.completed, e.elapsed, e.sized, e.bytes = 0, 0, 0, 0
+	e.predictions, e.finished = nil, time.Time{}
+}
+
+// StartTask records that the task index, counted from 0, starts now and
+// predicts when the run ends. The previous task ended with it.
+func (e *Estimator) StartTask(index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.complete()
+	e.current = index
+	e.started = e.now()
+	e.sizes = make(map[string]int64)
+	if estimate := e.estimate(); estimate.Known {
+		e.predictions = append(e.predictions, prediction{at: e.started, end: e.started.Add(estimate.RunRemaining)})
+	}
+}
+
+// Finish records that the last task and the run ended now. The predictions
+// are compared with that end.
+func (e *Estimator) Finish() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.complete()
+	e.finished = e.now()
+}
+
+// Exclude leaves d, a time the run waited for something other than the
+// transfers, out of the time the current task takes
+func (e *Estimator) Exclude(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.started.IsZero() {
+		e.started = e.started.Add(d)
+	}
+}
+
+// complete records how long the current task took, if one is running
+func (e *Estimator) complete() {
+	if e.started.IsZero() {
+		return
+	}
+	took := e.now().Sub(e.started)
+	e.completed++
+	e.elapsed += took
+	if size := e.taskSize(); size > 0 {
+		e.sized += took
+		e.bytes += size
+	}
+	e.started = time.Time{}
+}
+
+// HandleEvent records the download sizes of the platforms of the current
+// task, and leaves the time they waited for disk space out like Exclude
+func (e *Estimator) HandleEvent(event docker.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch event := event.(type) {
+	case docker.PlatformPullProgress:
+		if e.sizes != nil && event.Total > 0 {
+			e.sizes[event.Platform] = event.Total
+		}
+	case docker.DiskWaited:
+		if !e.started.IsZero() {
+			e.started = e.started.Add(event.End.Sub(event.Start))
+		}
+	}
+}
+
+// Estimate returns the current estimate
+func (e *Estimator) Estimate() Estimate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.estimate()
+}
+
+func (e *Estimator) estimate() Estimate {
+	estimate := Estimate{Task: e.current + 1, Tasks: e.tasks}
+	if e.completed == 0 {
+		return estimate
+	}
+	average := e.elapsed / time.Duration(e.completed)
+
+	// The current task is expected to take as long per byte as the tasks
+	// that pulled anything, or as long as the average task
+	expected := average
+	if size := e.taskSize(); size > 0 && e.bytes > 0 {
+		expected = time.Duration(float64(e.sized) * float64(size) / float64(e.bytes))
+	}
+	if !e.started.IsZero() {
+		estimate.TaskRemaining = max(expected-e.now().Sub(e.started), 0)
+	}
+	pending := max(e.tasks-e.current-1, 0)
+	estimate.RunRemaining = estimate.TaskRemaining + time.Duration(pending)*average
+	estimate.Known = true
+	return estimate
+}
+
+// taskSize sums the download sizes of the platforms of the current task
+func (e *Estimator) taskSize() int64 {
+	var size int64
+	for _, s := range e.sizes {
+		size += s
+	}
+	return size
+}
+
+// Accuracy compares the predictions made during the run with its end, or
+// returns nil when none was made or the run hasn't finished
+func (e *Estimator) Accuracy() *Accuracy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.predictions) == 0 || e.finished.IsZero() {
+		return nil
+	}
+	var total time.Duration
+	var relative float64
+	for _, p := range e.predictions {
+		diff := p.end.Sub(e.finished).Abs()
+		total += diff
+		if left := e.finished.Sub(p.at); left > 0 {
+			relative += float64(diff) / float64(left)
+		}
+	}
+	n := len(e.predictions)
+	return &Accuracy{
+		Predictions:       n,
+		MeanError:         docker.Duration(total / time.Duration(n)),
+		MeanRelativeError: relative / float64(n),
+	}
+}
+
+// Format formats a remaining time the way it is shown: to the second below
+// a minute and to the minute above
+func Format(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("~%ds", int(d.Round(time.Second).Seconds()))
+	}
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("~%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("~%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// String describes the estimate as "task 14/60, ~2h10m remaining"
+func (e Estimate) String() string {
+	s := fmt.Sprintf("task %d/%d", e.Task, e.Tasks)
+	if e.Known {
+		s += ", " + Format(e.RunRemaining) + " remaining"
+	}
+	return s
+}
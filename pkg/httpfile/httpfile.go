@@ -0,0 +1,246 @@
+// Package httpfile uploads and downloads archives at http(s) URLs, for
+// transfer gateways that expose a plain file API with PUT and GET rather
+// than object storage.
+package httpfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/version"
+)
+
+// Retry policy for uploads cut short
+const (
+	retryAttempts = 3
+	retryDelay    = 2 * time.Second
+)
+
+// Options configures how files are transferred
+type Options struct {
+	// Username and Password authenticate with basic auth, Token with a
+	// bearer token instead
+	Username string
+	Password string
+	Token    string
+	// Limiter limits the bandwidth of uploads and downloads
+	Limiter *bandwidth.Limiter
+}
+
+// Client transfers files to and from http(s) URLs
+type Client struct {
+	opts Options
+	http *http.Client
+}
+
+// New creates a client. Transfers are only limited by their context, since
+// archives can be large.
+func New(opts Options) *Client {
+	return &Client{opts: opts, http: &http.Client{}}
+}
+
+// IsURL reports whether path is an http or https URL rather than a file
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// Join returns the URL of the file name below the directory URL base.
+// name is a slash separated relative path.
+func Join(base, name string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %v", Redact(base), err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(name, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Redact returns rawURL without the credentials it may carry
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// Upload streams the file at path to rawURL with a chunked PUT. When the
+// connection breaks or the server fails, the upload is retried; a server
+// that accepts byte ranges and kept what it received is sent the rest with
+// a Content-Range, others the whole file again.
+func (c *Client) Upload(ctx context.Context, path, rawURL string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	delay := retryDelay
+	var offset int64
+	for attempt := 1; ; attempt++ {
+		err := c.put(ctx, path, rawURL, offset, size)
+		if err == nil || attempt >= retryAttempts || !errdefs.Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		offset = c.received(ctx, rawURL, size)
+	}
+}
+
+// put sends the file at path from offset on
+func (c *Client) put(ctx context.Context, path, rawURL string, offset, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Hiding the length makes the request chunked
+	body := struct{ io.Reader }{c.opts.Limiter.Reader(ctx, f)}
+	req, err := c.newRequest(ctx, http.MethodPut, rawURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if offset > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return transferError("uploading", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError("uploading", rawURL, resp)
+	}
+	return nil
+}
+
+// received returns how much of a broken upload of size bytes the server
+// kept, to resume from, or zero to upload it again from the start
+func (c *Client) received(ctx context.Context, rawURL string, size int64) int64 {
+	req, err := c.newRequest(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return 0
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0
+	}
+	length, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil || length <= 0 || length >= size {
+		return 0
+	}
+	return length
+}
+
+// Download streams rawURL to the file at path without holding it in memory
+// and returns the sha256 checksum of what it wrote
+func (c *Client) Download(ctx context.Context, rawURL, path string) (string, error) {
+	body, err := c.Open(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, sum), body); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", transferError("downloading", rawURL, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// Open returns the body of a GET of rawURL, which the caller closes
+func (c *Client) Open(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, transferError("downloading", rawURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError("downloading", rawURL, resp)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{c.opts.Limiter.Reader(ctx, resp.Body), resp.Body}, nil
+}
+
+// newRequest creates an authenticated request
+func (c *Client) newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %v", Redact(rawURL), err)
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	switch {
+	case c.opts.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	case c.opts.Username != "":
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+	return req, nil
+}
+
+// statusError reports the response a server gave to the transfer of
+// rawURL, without the credentials of the URL
+func statusError(what, rawURL string, resp *http.Response) error {
+	return errdefs.FromHTTPResponse(resp, fmt.Errorf("%s %s: %s", what, Redact(rawURL), resp.Status))
+}
+
+// transferError classifies a failed transfer
+func transferError(what, rawURL string, err error) error {
+	var netErr net.Error
+	timeout := errors.As(err, &netErr) && netErr.Timeout()
+	// The URL is already part of the message
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	err = fmt.Errorf("%s %s: %v", what, Redact(rawURL), err)
+	if timeout {
+		return &errdefs.TimeoutError{Err: err}
+	}
+	return &errdefs.NetworkError{Err: err}
+}
@@ -0,0 +1,185 @@
+// Package imagediff compares two images in registries platform by platform:
+// which platforms they cover, and the manifest digest, layers and runtime
+// configuration of each platform both cover.
+package imagediff
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Image is an image with the image config of each of its entries.
+// Attestation entries are left out.
+type Image struct {
+	*registry.Image
+	// Configs holds the image config of each entry by platform key
+	Configs map[string]*registry.ImageConfig
+}
+
+// Load fetches the manifests of a tag or digest and the image config of
+// every entry that is not an attestation
+func Load(ctx context.Context, client *registry.Client, repo, ref string) (*Image, error) {
+	image, err := client.Inspect(ctx, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &Image{Image: image, Configs: make(map[string]*registry.ImageConfig)}
+	for _, entry := range image.Entries {
+		if entry.Attestation {
+			continue
+		}
+		manifest := &registry.Manifest{MediaType: entry.MediaType, Config: entry.Config, Layers: entry.Layers, Digest: entry.Digest}
+		config, err := client.ImageConfig(ctx, repo, entry.Digest, manifest)
+		if err != nil {
+			return nil, err
+		}
+		loaded.Configs[platformKey(entry.Platform)] = config
+	}
+	return loaded, nil
+}
+
+// Difference is one way two images differ. Left holds what is only on the
+// left side, Right what is only on the right side.
+type Difference struct {
+	// Platform is the platform key, or empty for the platform coverage
+	Platform string
+	// Field is what differs: platforms, digest, layers, env, labels,
+	// entrypoint, cmd, working dir or user
+	Field string
+	Left  []string
+	Right []string
+}
+
+// Compare returns the differences between two images. Platforms are
+// matched by os/arch/variant and os.version, so a single image manifest is
+// compared with the matching entry of a list.
+func Compare(left, right *Image) []Difference {
+	leftEntries := entries(left)
+	rightEntries := entries(right)
+
+	var diffs []Difference
+	onlyLeft, onlyRight := setDiff(sortedKeys(leftEntries), sortedKeys(rightEntries))
+	if len(onlyLeft) > 0 || len(onlyRight) > 0 {
+		diffs = append(diffs, Difference{Field: "platforms", Left: onlyLeft, Right: onlyRight})
+	}
+
+	for _, key := range sortedKeys(leftEntries) {
+		r, ok := rightEntries[key]
+		if !ok {
+			continue
+		}
+		l := leftEntries[key]
+
+		add := func(field string, leftValues, rightValues []string) {
+			diffs = append(diffs, Difference{Platform: key, Field: field, Left: leftValues, Right: rightValues})
+		}
+
+		if l.Digest != r.Digest {
+			add("digest", []string{l.Digest}, []string{r.Digest})
+		}
+		if lLayers, rLayers := layerDigests(l), layerDigests(r); !slices.Equal(lLayers, rLayers) {
+			onlyLeft, onlyRight := setDiff(lLayers, rLayers)
+			if len(onlyLeft) == 0 && len(onlyRight) == 0 {
+				// Same layers in another order
+				onlyLeft, onlyRight = lLayers, rLayers
+			}
+			add("layers", onlyLeft, onlyRight)
+		}
+
+		lConfig, rConfig := left.Configs[key], right.Configs[key]
+		if lConfig == nil || rConfig == nil {
+			continue
+		}
+		lRun, rRun := lConfig.Config, rConfig.Config
+		if onlyLeft, onlyRight := setDiff(lRun.Env, rRun.Env); len(onlyLeft) > 0 || len(onlyRight) > 0 {
+			add("env", onlyLeft, onlyRight)
+		}
+		if onlyLeft, onlyRight := setDiff(labels(lRun.Labels), labels(rRun.Labels)); len(onlyLeft) > 0 || len(onlyRight) > 0 {
+			add("labels", onlyLeft, onlyRight)
+		}
+		if !slices.Equal(lRun.Entrypoint, rRun.Entrypoint) {
+			add("entrypoint", []string{registry.FormatArgs(lRun.Entrypoint)}, []string{registry.FormatArgs(rRun.Entrypoint)})
+		}
+		if !slices.Equal(lRun.Cmd, rRun.Cmd) {
+			add("cmd", []string{registry.FormatArgs(lRun.Cmd)}, []string{registry.FormatArgs(rRun.Cmd)})
+		}
+		if lRun.WorkingDir != rRun.WorkingDir {
+			add("working dir", []string{lRun.WorkingDir}, []string{rRun.WorkingDir})
+		}
+		if lRun.User != rRun.User {
+			add("user", []string{lRun.User}, []string{rRun.User})
+		}
+	}
+	return diffs
+}
+
+// entries returns the entries of an image that are not attestations by
+// platform key
+func entries(image *Image) map[string]registry.ImageEntry {
+	byKey := make(map[string]registry.ImageEntry)
+	for _, entry := range image.Entries {
+		if !entry.Attestation {
+			byKey[platformKey(entry.Platform)] = entry
+		}
+	}
+	return byKey
+}
+
+// platformKey identifies a platform, including the os.version that tells
+// Windows images apart
+func platformKey(p registry.Platform) string {
+	if p.OSVersion != "" {
+		return fmt.Sprintf("%s (%s)", p, p.OSVersion)
+	}
+	return p.String()
+}
+
+// layerDigests returns the digests of the layers of an entry in order
+func layerDigests(entry registry.ImageEntry) []string {
+	digests := make([]string, len(entry.Layers))
+	for i, layer := range entry.Layers {
+		digests[i] = layer.Digest
+	}
+	return digests
+}
+
+// labels returns labels as sorted key=value pairs
+func labels(values map[string]string) []string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// setDiff returns the values only in left and those only in right, each in
+// their original order
+func setDiff(left, right []string) (onlyLeft, onlyRight []string) {
+	for _, value := range left {
+		if !slices.Contains(right, value) {
+			onlyLeft = append(onlyLeft, value)
+		}
+	}
+	for _, value := range right {
+		if !slices.Contains(left, value) {
+			onlyRight = append(onlyRight, value)
+		}
+	}
+	return onlyLeft, onlyRight
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
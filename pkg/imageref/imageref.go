@@ -0,0 +1,102 @@
+// Package imageref canonicalizes image references. Users write the same
+// image as nginx, library/nginx, docker.io/nginx or
+// docker.io/library/nginx:latest; all of them have the canonical form
+// docker.io/library/nginx:latest, which keys the image internally while
+// output keeps the spelling the user wrote.
+package imageref
+
+import (
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// Parse parses an image reference, filling in the default registry
+// docker.io and its library namespace. The registry and repository are
+// lower-cased, so they compare equal however they were written; tags and
+// digests are kept as they are.
+func Parse(ref string) (reference.Named, error) {
+	return reference.ParseNormalizedNamed(lowerName(ref))
+}
+
+// Canonical returns the canonical form of an image reference: its
+// registry, repository and tag, latest unless one or a digest is given
+func Canonical(ref string) (string, error) {
+	named, err := Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
+
+// Key returns the canonical form of an image reference to use as the key
+// of the image, or the reference itself when it doesn't parse
+func Key(ref string) string {
+	canonical, err := Canonical(ref)
+	if err != nil {
+		return ref
+	}
+	return canonical
+}
+
+// Familiar returns the canonical form of an image reference in the short
+// form docker shows, such as nginx:latest, or the reference itself when it
+// doesn't parse
+func Familiar(ref string) string {
+	named, err := Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// Validate checks a reference as it is written against the reference
+// grammar, without the lower-casing of Parse, as a registry would
+func Validate(ref string) error {
+	_, err := reference.ParseNormalizedNamed(ref)
+	return err
+}
+
+// Normalize fixes the mistakes of generated references: it lower-cases the
+// registry and repository and drops empty path components, such as those
+// of a double slash. Tags and digests are kept as they are.
+func Normalize(ref string) string {
+	ref = lowerName(ref)
+	name, rest := ref, ""
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, rest = ref[:i], ref[i:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, rest = name[:i], name[i:]+rest
+	}
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	return strings.Join(parts, "/") + rest
+}
+
+// pathLimits is the number of path components registries that limit it
+// accept below their host
+var pathLimits = map[string]int{
+	"docker.io": 2,
+}
+
+// PathLimit returns the number of path components the registry of a
+// reference accepts, or false when it sets no limit
+func PathLimit(named reference.Named) (int, bool) {
+	limit, ok := pathLimits[reference.Domain(named)]
+	return limit, ok
+}
+
+// lowerName lower-cases the registry and repository of a reference,
+// keeping its tag and digest
+func lowerName(ref string) string {
+	name, digest, digested := strings.Cut(ref, "@")
+	var tag string
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i:]
+	}
+	ref = strings.ToLower(name) + tag
+	if digested {
+		ref += "@" + digest
+	}
+	return ref
+}
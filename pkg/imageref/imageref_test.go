@@ -0,0 +1,135 @@
+package imageref
+
+import "testing"
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		ref       string
+		canonical string
+		familiar  string
+	}{
+		{"nginx", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"nginx:1.25", "docker.io/library/nginx:1.25", "nginx:1.25"},
+		{"library/nginx", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"docker.io/nginx", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"docker.io/library/nginx:latest", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"index.docker.io/library/nginx", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"user/repo:tag", "docker.io/user/repo:tag", "user/repo:tag"},
+		{"User/Repo:Tag", "docker.io/user/repo:Tag", "user/repo:Tag"},
+		{"host:5000/repo", "host:5000/repo:latest", "host:5000/repo:latest"},
+		{"host:5000/team/repo:1.0", "host:5000/team/repo:1.0", "host:5000/team/repo:1.0"},
+		{"localhost/repo", "localhost/repo:latest", "localhost/repo:latest"},
+		{"REGISTRY.example.com/Team/App:v1", "registry.example.com/team/app:v1", "registry.example.com/team/app:v1"},
+		{"nginx@" + digest, "docker.io/library/nginx@" + digest, "nginx@" + digest},
+		{"nginx:1.25@" + digest, "docker.io/library/nginx:1.25@" + digest, "nginx:1.25@" + digest},
+		{"host:5000/repo@" + digest, "host:5000/repo@" + digest, "host:5000/repo@" + digest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			canonical, err := Canonical(tt.ref)
+			if err != nil {
+				t.Fatalf("Canonical: %v", err)
+			}
+			if canonical != tt.canonical {
+				t.Errorf("Canonical = %s, want %s", canonical, tt.canonical)
+			}
+			if key := Key(tt.ref); key != tt.canonical {
+				t.Errorf("Key = %s, want %s", key, tt.canonical)
+			}
+			if familiar := Familiar(tt.ref); familiar != tt.familiar {
+				t.Errorf("Familiar = %s, want %s", familiar, tt.familiar)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, ref := range []string{"", ":latest", "nginx:", "nginx@sha256:short", "host:port/repo", "repo//name", "-nginx"} {
+		if _, err := Parse(ref); err == nil {
+			t.Errorf("Parse(%q) succeeded", ref)
+		}
+		if key := Key(ref); key != ref {
+			t.Errorf("Key(%q) = %s, want the reference itself", ref, key)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate("nginx:1.25"); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	// Parse lower-cases the repository, Validate checks it as it is written
+	if err := Validate("Team/App"); err == nil {
+		t.Error("Validate accepted an upper-case repository")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct{ ref, want string }{
+		{"registry.example.com//team/App:V1", "registry.example.com/team/app:V1"},
+		{"host:5000/Repo/", "host:5000/repo"},
+		{"repo@" + digest, "repo@" + digest},
+		{"Repo:Tag@" + digest, "repo:Tag@" + digest},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.ref); got != tt.want {
+			t.Errorf("Normalize(%q) = %s, want %s", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestPathLimit(t *testing.T) {
+	named, err := Parse("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit, ok := PathLimit(named); !ok || limit != 2 {
+		t.Errorf("PathLimit of docker.io = %d, %v, want 2", limit, ok)
+	}
+	named, err = Parse("registry.example.com/a/b/c/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := PathLimit(named); ok {
+		t.Error("PathLimit limits registry.example.com")
+	}
+}
+
+// FuzzParse checks that the canonical form of a reference is a fixed
+// point: it parses to the same repository and canonicalizes to itself, and
+// is shown as the reference is. The familiar form isn't parsed back, as a
+// docker.io repository such as 0.0/app is shown as 0.0/app, whose first
+// component names a registry.
+func FuzzParse(f *testing.F) {
+	for _, ref := range []string{"nginx", "library/nginx", "docker.io/library/nginx:latest", "User/Repo:Tag", "host:5000/repo", "nginx:1.25@" + digest} {
+		f.Add(ref)
+	}
+	f.Fuzz(func(t *testing.T, ref string) {
+		named, err := Parse(ref)
+		if err != nil {
+			return
+		}
+		canonical, err := Canonical(ref)
+		if err != nil {
+			t.Fatalf("Canonical(%q) failed after Parse succeeded: %v", ref, err)
+		}
+		again, err := Parse(canonical)
+		if err != nil {
+			t.Fatalf("canonical form %q of %q doesn't parse: %v", canonical, ref, err)
+		}
+		if again.Name() != named.Name() {
+			t.Errorf("canonical form %q of %q names %s, not %s", canonical, ref, again.Name(), named.Name())
+		}
+		if err := Validate(canonical); err != nil {
+			t.Errorf("canonical form %q of %q isn't a valid reference: %v", canonical, ref, err)
+		}
+		if key := Key(canonical); key != canonical {
+			t.Errorf("canonical form %q of %q canonicalizes to %q", canonical, ref, key)
+		}
+		if familiar := Familiar(canonical); familiar != Familiar(ref) {
+			t.Errorf("canonical form %q of %q is shown as %q, not %q", canonical, ref, familiar, Familiar(ref))
+		}
+	})
+}
@@ -0,0 +1,50 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// AssertPlatforms checks that repo:ref is a manifest list of exactly the
+// platforms want, in os/arch[/variant] form and in any order
+func AssertPlatforms(ctx context.Context, client *registry.Client, repo, ref string, want []string) error {
+	manifest, err := client.Manifest(ctx, repo, ref)
+	if err != nil {
+		return err
+	}
+	if !manifest.IsList() {
+		return fmt.Errorf("%s:%s is a %s, not a manifest list", repo, ref, manifest.MediaType)
+	}
+	images, err := client.PlatformImages(ctx, repo, manifest)
+	if err != nil {
+		return err
+	}
+	var got []string
+	for _, image := range images {
+		got = append(got, image.Platform.String())
+	}
+	slices.Sort(got)
+	want = slices.Sorted(slices.Values(want))
+	if !slices.Equal(got, want) {
+		return fmt.Errorf("%s:%s lists platforms %s, want %s", repo, ref, strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+	return nil
+}
+
+// AssertDigest checks that repo:ref resolves to digest
+func AssertDigest(ctx context.Context, client *registry.Client, repo, ref, digest string) error {
+	got, err := client.Digest(ctx, repo, ref)
+	if err != nil {
+		return err
+	}
+	if got != digest {
+		return fmt.Errorf("%s:%s resolves to %s, want %s", repo, ref, got, digest)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build integration
+
+// Package integration holds the helpers of the integration tests in
+// test/integration, which run the migration flow end to end against a
+// throwaway registry:2 container. It needs a docker daemon and is only
+// built with the integration build tag. The registry and fixture helpers
+// are exported for the tests of features that need real images.
+package integration
@@ -0,0 +1,74 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// FixturePlatforms are the platforms of the fixture the tests migrate
+var FixturePlatforms = []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}
+
+// Errors of Setup when the docker daemon can't be reached, or can't run the
+// registry, such as when registry:2 can't be pulled
+var (
+	ErrNoDaemon   = errors.New("no docker daemon available")
+	ErrNoRegistry = errors.New("no registry available")
+)
+
+// Env is what the integration tests run against: a registry holding the
+// fixture and clients for the registry API and the docker daemon
+type Env struct {
+	Registry *Registry
+	API      *registry.Client
+	Docker   *docker.Client
+	Fixture  *Fixture
+}
+
+// Source returns the reference of the fixture
+func (e *Env) Source() string {
+	return e.Registry.Image(e.Fixture.Repo, e.Fixture.Tag)
+}
+
+// Auth returns the credentials of the registry, which has none
+func (e *Env) Auth() docker.RegistryAuth {
+	return docker.RegistryAuth{URL: e.Registry.Host, Insecure: true}
+}
+
+// Setup starts a registry and pushes the fixture to it
+func Setup(ctx context.Context, client *docker.Client) (*Env, error) {
+	if _, err := runDocker(ctx, "info", "--format", "{{.ServerVersion}}"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoDaemon, err)
+	}
+	reg, err := StartRegistry(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoRegistry, err)
+	}
+	api, err := reg.Client()
+	if err != nil {
+		reg.Close()
+		return nil, err
+	}
+	fixture, err := PushFixture(ctx, api, "fixture", "latest", FixturePlatforms)
+	if err != nil {
+		reg.Close()
+		return nil, err
+	}
+	return &Env{Registry: reg, API: api, Docker: client, Fixture: fixture}, nil
+}
+
+// CheckResult fails a task that returned an error or has failed platforms
+func CheckResult(result *docker.TaskResult, err error) error {
+	if err != nil {
+		return err
+	}
+	if failed := result.Failed(); failed > 0 {
+		return fmt.Errorf("%d platforms failed", failed)
+	}
+	return nil
+}
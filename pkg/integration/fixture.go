@@ -0,0 +1,155 @@
+//go:build integration
+
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// Media types of the fixture images, which the docker daemon pulls as it
+// pulls any image
+const (
+	mediaTypeConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeLayer  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Fixture is a multi-platform image pushed to a registry
+type Fixture struct {
+	Repo string
+	Tag  string
+	// Digest is the digest of the manifest list
+	Digest string
+	// Platforms maps the platforms of the list to the digests of their
+	// image manifests
+	Platforms map[string]string
+}
+
+// PushFixture builds a tiny image for each platform, in os/arch[/variant]
+// form, and pushes them with a manifest list to repo:tag. Every image is a
+// single layer holding a file that names its platform, so the images of
+// two platforms never share a digest.
+func PushFixture(ctx context.Context, client *registry.Client, repo, tag string, platforms []string) (*Fixture, error) {
+	fixture := &Fixture{Repo: repo, Tag: tag, Platforms: make(map[string]string)}
+	list := registry.Manifest{MediaType: registry.MediaTypeManifestList}
+	for _, name := range platforms {
+		platform, err := registry.ParsePlatform(name)
+		if err != nil {
+			return nil, err
+		}
+		desc, err := pushImage(ctx, client, repo, platform)
+		if err != nil {
+			return nil, fmt.Errorf("failed to push the %s fixture image: %v", name, err)
+		}
+		desc.Platform = &platform
+		list.Manifests = append(list.Manifests, desc)
+		fixture.Platforms[platform.String()] = desc.Digest
+	}
+
+	data, err := manifestJSON(list)
+	if err != nil {
+		return nil, err
+	}
+	fixture.Digest, err = client.PushManifest(ctx, repo, tag, list.MediaType, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to push the fixture manifest list: %v", err)
+	}
+	return fixture, nil
+}
+
+// pushImage pushes the layer, config and manifest of the fixture image of
+// a platform and returns the descriptor of its manifest
+func pushImage(ctx context.Context, client *registry.Client, repo string, platform registry.Platform) (registry.Descriptor, error) {
+	layer, diffID, err := fixtureLayer(platform)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	config, err := json.Marshal(map[string]any{
+		"architecture": platform.Architecture,
+		"os":           platform.OS,
+		"variant":      platform.Variant,
+		"created":      time.Unix(0, 0).UTC(),
+		"config":       map[string]any{"Cmd": []string{"/platform"}},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{diffID}},
+	})
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+
+	manifest := registry.Manifest{MediaType: registry.MediaTypeManifest}
+	for _, blob := range []struct {
+		data      []byte
+		mediaType string
+	}{{config, mediaTypeConfig}, {layer, mediaTypeLayer}} {
+		desc := registry.Descriptor{MediaType: blob.mediaType, Digest: digestOf(blob.data), Size: int64(len(blob.data))}
+		data := blob.data
+		if err := client.PushBlob(ctx, repo, desc, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}); err != nil {
+			return registry.Descriptor{}, err
+		}
+		if manifest.Config == nil {
+			manifest.Config = &desc
+		} else {
+			manifest.Layers = append(manifest.Layers, desc)
+		}
+	}
+
+	data, err := manifestJSON(manifest)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	digest, err := client.PushManifest(ctx, repo, digestOf(data), manifest.MediaType, data)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	return registry.Descriptor{MediaType: manifest.MediaType, Digest: digest, Size: int64(len(data))}, nil
+}
+
+// fixtureLayer returns the gzipped layer of the fixture image of a
+// platform and the digest of its uncompressed tar
+func fixtureLayer(platform registry.Platform) ([]byte, string, error) {
+	var layer bytes.Buffer
+	gz := archive.NewGzipWriter(&layer)
+	diff := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(gz, diff))
+	content := []byte(platform.String() + "\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "platform", Mode: 0644, Size: int64(len(content)), ModTime: time.Unix(0, 0)}); err != nil {
+		return nil, "", err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, "", err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return layer.Bytes(), "sha256:" + hex.EncodeToString(diff.Sum(nil)), nil
+}
+
+// manifestJSON encodes a manifest with the schema version registries
+// require
+func manifestJSON(m registry.Manifest) ([]byte, error) {
+	return json.Marshal(struct {
+		SchemaVersion int `json:"schemaVersion"`
+		registry.Manifest
+	}{2, m})
+}
+
+// digestOf returns the sha256 digest of data
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// RegistryImage is the image the throwaway registry runs
+const RegistryImage = "registry:2"
+
+// readyTimeout limits how long the registry may take to answer
+const readyTimeout = 30 * time.Second
+
+// Registry is a registry:2 container listening on a loopback port
+type Registry struct {
+	// Host is the host:port of the registry, which the daemon pushes to
+	// over plain HTTP since it is on localhost
+	Host      string
+	container string
+}
+
+// StartRegistry starts a registry container and waits until it answers.
+// Close removes it.
+func StartRegistry(ctx context.Context) (*Registry, error) {
+	id, err := runDocker(ctx, "run", "--detach", "--rm", "--publish", "127.0.0.1::5000", RegistryImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", RegistryImage, err)
+	}
+	r := &Registry{container: id}
+
+	port, err := runDocker(ctx, "port", id, "5000/tcp")
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to find the port of the registry: %v", err)
+	}
+	// docker port prints one address per line, IPv4 first
+	_, p, err := net.SplitHostPort(strings.SplitN(port, "\n", 2)[0])
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("unexpected registry address %q: %v", port, err)
+	}
+	r.Host = "localhost:" + p
+
+	if err := r.wait(ctx); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// wait polls the API of the registry until it answers
+func (r *Registry) wait(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+r.Host+"/v2/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("registry at %s didn't become ready: %v", r.Host, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// Client returns a registry API client for the registry
+func (r *Registry) Client() (*registry.Client, error) {
+	return registry.NewClient(r.Host, registry.Options{Insecure: true})
+}
+
+// Image returns a reference to repo:tag in the registry
+func (r *Registry) Image(repo, tag string) string {
+	return r.Host + "/" + repo + ":" + tag
+}
+
+// Close removes the registry container and everything pushed to it
+func (r *Registry) Close() error {
+	_, err := runDocker(context.Background(), "rm", "--force", "--volumes", r.container)
+	return err
+}
+
+// runDocker runs the docker CLI and returns its trimmed output
+func runDocker(ctx context.Context, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,116 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when the lock is already held by another process
+var ErrLocked = errors.New("lock is held by another process")
+
+// pollInterval is how often a waiting process retries the lock
+const pollInterval = 500 * time.Millisecond
+
+// Owner identifies the process holding a lock. It is written to the lock
+// file so that a process failing to take the lock can name the holder.
+type Owner struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Since    time.Time `json:"since"`
+}
+
+// String describes the owner for error messages
+func (o Owner) String() string {
+	if o.PID == 0 {
+		return "an unknown process"
+	}
+	return fmt.Sprintf("PID %d on %s since %s", o.PID, o.Hostname, o.Since.Format(time.RFC3339))
+}
+
+// HeldError reports a lock held by another process. It matches ErrLocked
+// with errors.Is.
+type HeldError struct {
+	Path  string
+	Owner Owner
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is held by %s", e.Path, e.Owner)
+}
+
+func (e *HeldError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Lock is an advisory lock backed by a file on disk
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// Acquire takes the lock at path without blocking. If another process holds
+// the lock, a *HeldError naming the holder is returned.
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+
+	if err := tryLock(file); err != nil {
+		file.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, &HeldError{Path: path, Owner: readOwner(path)}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+
+	hostname, _ := os.Hostname()
+	owner, _ := json.Marshal(Owner{PID: os.Getpid(), Hostname: hostname, Since: time.Now()})
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt(owner, 0)
+	}
+
+	return &Lock{path: path, file: file}, nil
+}
+
+// AcquireWait takes the lock at path, waiting up to timeout for another
+// process to release it. A zero timeout fails immediately like Acquire.
+func AcquireWait(path string, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := Acquire(path)
+		if err == nil || !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return l, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// readOwner reads the owner recorded in a lock file, which is empty when
+// the holder hasn't written it yet
+func readOwner(path string) Owner {
+	var owner Owner
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &owner)
+	}
+	return owner
+}
+
+// Release releases the lock. The lock file itself is left in place so that a
+// process waiting on it never ends up locking a different inode. A process
+// killed by a signal releases the lock as well, since the operating system
+// drops it with the file handle.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	l.file.Truncate(0)
+	err := unlock(l.file)
+	l.file.Close()
+	l.file = nil
+	return err
+}
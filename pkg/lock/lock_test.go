@@ -0,0 +1,35 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".imgmigrate.lock")
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// The holder is named while the lock is held
+	_, err = Acquire(path)
+	var held *HeldError
+	if !errors.As(err, &held) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("Acquire of a held lock = %v, want a HeldError", err)
+	}
+	if held.Owner.PID != os.Getpid() {
+		t.Errorf("holder = %s, want PID %d", held.Owner, os.Getpid())
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	l, err = AcquireWait(path, 0)
+	if err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+	l.Release()
+}
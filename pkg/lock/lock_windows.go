@@ -0,0 +1,33 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset is where the locked byte lies, far past the owner written at
+// the start of the file. Windows locks are mandatory, so locking the owner
+// would keep a process failing to take the lock from reading it.
+const lockOffset = 1 << 62
+
+func tryLock(file *os.File) error {
+	ol := lockOverlapped()
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
+
+func unlock(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, lockOverlapped())
+}
+
+// lockOverlapped returns the position of the locked byte
+func lockOverlapped() *windows.Overlapped {
+	return &windows.Overlapped{Offset: uint32(lockOffset & 0xffffffff), OffsetHigh: uint32(lockOffset >> 32)}
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// ANSI escape sequences used to color warnings and errors
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// ConsoleHandler is a slog.Handler that writes one human readable line per
+// record: the message followed by its attributes as key=value pairs.
+// Warnings are prefixed with "Warning: ", and warnings and errors are
+// colored when color is enabled. Group names are not shown.
+type ConsoleHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Leveler
+	color bool
+	attrs []slog.Attr
+}
+
+// NewConsoleHandler returns a handler writing records at or above level to out
+func NewConsoleHandler(out io.Writer, level slog.Leveler, color bool) *ConsoleHandler {
+	return &ConsoleHandler{mu: &sync.Mutex{}, out: out, level: level, color: color}
+}
+
+// Enabled reports whether records at level are written
+func (h *ConsoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes the record as a single line
+func (h *ConsoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf strings.Builder
+	if r.Level >= slog.LevelWarn && r.Level < slog.LevelError {
+		buf.WriteString("Warning: ")
+	}
+	buf.WriteString(r.Message)
+
+	for _, attr := range h.attrs {
+		writeAttr(&buf, attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&buf, attr)
+		return true
+	})
+
+	line := buf.String()
+	if h.color {
+		switch {
+		case r.Level >= slog.LevelError:
+			line = colorRed + line + colorReset
+		case r.Level >= slog.LevelWarn:
+			line = colorYellow + line + colorReset
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, line+"\n")
+	return err
+}
+
+// WithAttrs returns a handler that appends attrs to every record
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup returns h, since groups are not shown on the console
+func (h *ConsoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// writeAttr appends a key=value pair, skipping empty attributes
+func writeAttr(buf *strings.Builder, attr slog.Attr) {
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	fmt.Fprintf(buf, " %s=%v", attr.Key, attr.Value.Resolve())
+}
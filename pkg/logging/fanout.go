@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// fanout is a slog.Handler that passes every record to several handlers
+type fanout []slog.Handler
+
+// Fanout returns a handler that writes each record to every handler that is
+// enabled for its level
+func Fanout(handlers ...slog.Handler) slog.Handler {
+	return fanout(handlers)
+}
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
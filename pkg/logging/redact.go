@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// redacting is a slog.Handler that masks registered secrets in the message
+// and string attributes of every record before passing it on
+type redacting struct {
+	handler slog.Handler
+}
+
+// Redacting returns a handler that masks the secrets registered with the
+// redact package in records written to h
+func Redacting(h slog.Handler) slog.Handler {
+	return redacting{handler: h}
+}
+
+func (r redacting) Enabled(ctx context.Context, level slog.Level) bool {
+	return r.handler.Enabled(ctx, level)
+}
+
+func (r redacting) Handle(ctx context.Context, record slog.Record) error {
+	masked := slog.NewRecord(record.Time, record.Level, redact.String(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		masked.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return r.handler.Handle(ctx, masked)
+}
+
+func (r redacting) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		masked[i] = redactAttr(attr)
+	}
+	return redacting{handler: r.handler.WithAttrs(masked)}
+}
+
+func (r redacting) WithGroup(name string) slog.Handler {
+	return redacting{handler: r.handler.WithGroup(name)}
+}
+
+// redactAttr masks secrets in an attribute value, formatting values other
+// than groups as strings
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		group := value.Group()
+		masked := make([]any, len(group))
+		for i, a := range group {
+			masked[i] = redactAttr(a)
+		}
+		return slog.Group(attr.Key, masked...)
+	case slog.KindString, slog.KindAny:
+		return slog.String(attr.Key, redact.String(value.String()))
+	default:
+		return slog.Attr{Key: attr.Key, Value: value}
+	}
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// TestRedacting logs a registered password every way a record can carry it
+// and checks the output never holds it
+func TestRedacting(t *testing.T) {
+	const password = "hunter2-registry-password"
+	redact.Register(password)
+
+	credentials := struct {
+		Username string
+		Password string
+	}{"ci", password}
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+			var handler slog.Handler = slog.NewTextHandler(&buf, opts)
+			if format == "json" {
+				handler = slog.NewJSONHandler(&buf, opts)
+			}
+			logger := slog.New(Redacting(handler))
+
+			logger.Info("logging in with " + password)
+			logger.Debug("docker command finished", "command", "docker login --password "+password, "output", "unauthorized: "+password)
+			logger.Error("login failed", "error", fmt.Errorf("registry rejected %s: %w", password, errors.New("unauthorized")))
+			logger.Warn("credentials", "auth", credentials, "secret", redact.Secret(password))
+			logger.Info("task", slog.Group("auth", "password", password, slog.Group("nested", "token", password)))
+			logger.With("password", password).WithGroup("task").Info("done", "output", password)
+
+			output := buf.String()
+			if strings.Contains(output, password) {
+				t.Errorf("the password is logged:\n%s", output)
+			}
+			if n := strings.Count(output, redact.Mask); n < 10 {
+				t.Errorf("%d masks logged, want one for every password:\n%s", n, output)
+			}
+		})
+	}
+}
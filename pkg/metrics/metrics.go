@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric names and labels exposed by the tool. They are part of the tool's
+// external interface and must stay stable across releases:
+//
+//	imgmigrate_images_processed_total{image,status}             counter, status is success or failure
+//	imgmigrate_platforms_pulled_total{image,platform}            counter
+//	imgmigrate_platforms_pushed_total{image,platform,registry}   counter
+//	imgmigrate_bytes_saved_total{image,platform}                 counter, bytes written to archives
+//	imgmigrate_bytes_pushed_total{image,platform,registry}       counter, uncompressed image size pushed
+//	imgmigrate_failures_total{image,platform,reason}             counter, reason is pull, tag, verify, save, push, manifest or task
+//	imgmigrate_stage_duration_seconds{stage}                     summary (sum and count)
+var (
+	ImagesProcessed = Default.NewCounter("imgmigrate_images_processed_total",
+		"Number of images processed, by final status.", "image", "status")
+	PlatformsPulled = Default.NewCounter("imgmigrate_platforms_pulled_total",
+		"Number of image platforms pulled.", "image", "platform")
+	PlatformsPushed = Default.NewCounter("imgmigrate_platforms_pushed_total",
+		"Number of image platforms pushed.", "image", "platform", "registry")
+	BytesSaved = Default.NewCounter("imgmigrate_bytes_saved_total",
+		"Bytes written to saved image archives.", "image", "platform")
+	BytesPushed = Default.NewCounter("imgmigrate_bytes_pushed_total",
+		"Uncompressed bytes of image platforms pushed.", "image", "platform", "registry")
+	Failures = Default.NewCounter("imgmigrate_failures_total",
+		"Number of failed operations, by reason.", "image", "platform", "reason")
+	StageDuration = Default.NewSummary("imgmigrate_stage_duration_seconds",
+		"Time spent in each pipeline stage.", "stage")
+)
+
+// Default is the registry used by the tool's built-in metrics
+var Default = NewRegistry()
+
+// Registry holds a set of metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+// NewRegistry creates an empty metrics registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter is a monotonically increasing metric partitioned by labels
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a new counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter for the given label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %v\n", c.name, key, c.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Summary tracks the sum and count of observations partitioned by labels
+type Summary struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	sums   map[string]float64
+	counts map[string]uint64
+}
+
+// NewSummary registers a new summary with the given label names
+func (r *Registry) NewSummary(name, help string, labels ...string) *Summary {
+	s := &Summary{
+		name:   name,
+		help:   help,
+		labels: labels,
+		sums:   make(map[string]float64),
+		counts: make(map[string]uint64),
+	}
+	r.register(s)
+	return s
+}
+
+// Observe records a single observation for the given label values
+func (s *Summary) Observe(v float64, labelValues ...string) {
+	key := formatLabels(s.labels, labelValues)
+	s.mu.Lock()
+	s.sums[key] += v
+	s.counts[key]++
+	s.mu.Unlock()
+}
+
+func (s *Summary) write(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", s.name, s.help, s.name); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(s.sums) {
+		if _, err := fmt.Fprintf(w, "%s_sum%s %v\n%s_count%s %d\n", s.name, key, s.sums[key], s.name, key, s.counts[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP exposes the metrics for scraping
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+// WriteTextfile writes the current metric values to path in the node_exporter
+// textfile collector format. The file is replaced atomically so the collector
+// never reads a partially written file.
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".imgmigrate-metrics-*")
+	if err != nil {
+		return fmt.Errorf("failed to create metrics file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := r.WriteText(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Serve starts an HTTP server exposing the default registry on /metrics
+func Serve(addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start metrics server on %s: %v", addr, err)
+	}
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go server.Serve(listener)
+
+	return server, nil
+}
+
+// formatLabels renders label pairs as {a="x",b="y"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs[i] = name + `="` + value + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
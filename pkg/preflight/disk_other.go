@@ -0,0 +1,8 @@
+//go:build !unix
+
+package preflight
+
+// Free space can't be queried on this platform
+func FreeSpace(dir string) (uint64, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package preflight
+
+import "syscall"
+
+// FreeSpace returns the number of bytes available to unprivileged users on
+// the filesystem containing dir
+func FreeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
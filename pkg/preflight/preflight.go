@@ -0,0 +1,89 @@
+// Package preflight collects the results of the checks run before a
+// migration and prints them as a checklist.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+// Check outcomes. A warning is a failed check that doesn't block a run.
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// ErrUnsupported is returned by checks that aren't available on this platform
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// Item is the result of a single check
+type Item struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	// err is the error of a failed check
+	err error
+}
+
+// Checklist collects check results in the order they were run
+type Checklist struct {
+	Items []Item `json:"items"`
+}
+
+// Pass records a successful check
+func (c *Checklist) Pass(name, detail string) {
+	c.Items = append(c.Items, Item{Name: name, Status: StatusPass, Detail: detail})
+}
+
+// Warn records a failed check that doesn't block a run
+func (c *Checklist) Warn(name string, err error) {
+	c.Items = append(c.Items, Item{Name: name, Status: StatusWarn, Detail: redact.String(err.Error()), err: err})
+}
+
+// Fail records a failed check that blocks a run
+func (c *Checklist) Fail(name string, err error) {
+	c.Items = append(c.Items, Item{Name: name, Status: StatusFail, Detail: redact.String(err.Error()), err: err})
+}
+
+// Errors returns the errors of the blocking checks that failed
+func (c *Checklist) Errors() []error {
+	var errs []error
+	for _, item := range c.Items {
+		if item.Status == StatusFail {
+			errs = append(errs, item.err)
+		}
+	}
+	return errs
+}
+
+// OK reports whether no blocking check failed
+func (c *Checklist) OK() bool {
+	return len(c.Errors()) == 0
+}
+
+// Print writes one line per check followed by a summary
+func (c *Checklist) Print(w io.Writer) {
+	warned := 0
+	for _, item := range c.Items {
+		if item.Status == StatusWarn {
+			warned++
+		}
+
+		mark := map[Status]string{StatusPass: "[ OK ]", StatusWarn: "[WARN]", StatusFail: "[FAIL]"}[item.Status]
+		if item.Detail != "" {
+			fmt.Fprintf(w, "%s %s: %s\n", mark, item.Name, item.Detail)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", mark, item.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "%d checks, %d failed, %d warnings\n", len(c.Items), len(c.Errors()), warned)
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package progress
+
+import "os"
+
+// supportsANSI reports whether the terminal of f interprets escape
+// sequences, which every terminal outside Windows does
+func supportsANSI(f *os.File) bool {
+	return true
+}
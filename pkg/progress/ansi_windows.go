@@ -0,0 +1,24 @@
+//go:build windows
+
+package progress
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// supportsANSI reports whether the console of f interprets escape
+// sequences, enabling virtual terminal processing if it is off. Consoles
+// older than Windows 10 can't enable it and would print the sequences.
+func supportsANSI(f *os.File) bool {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
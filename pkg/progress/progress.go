@@ -0,0 +1,232 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/moby/term"
+)
+
+// barWidth is the number of cells in the filled part of a bar
+const barWidth = 30
+
+// IsTerminal reports whether f is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(f.Fd())
+}
+
+// SupportsANSI reports whether f is a terminal that interprets the escape
+// sequences of colors and progress bars. On Windows the console must
+// support virtual terminal processing, which is enabled for f.
+func SupportsANSI(f *os.File) bool {
+	return IsTerminal(f) && supportsANSI(f)
+}
+
+// Renderer draws progress bars at the bottom of a terminal. Log output
+// written to the renderer is printed above the bars, which are redrawn
+// after every complete line.
+type Renderer struct {
+	mu      sync.Mutex
+	out     *os.File
+	bars    []*Bar
+	drawn   int
+	partial []byte
+	stopped bool
+}
+
+// Bar is a single progress bar managed by a Renderer
+type Bar struct {
+	r       *Renderer
+	label   string
+	status  string
+	current int64
+	total   int64
+	// count renders current and total as plain numbers rather than bytes
+	count bool
+}
+
+// New returns a renderer that draws to out, which should be a terminal
+func New(out *os.File) *Renderer {
+	return &Renderer{out: out}
+}
+
+// AddBar adds a bar measuring bytes
+func (r *Renderer) AddBar(label string) *Bar {
+	return r.add(&Bar{label: label})
+}
+
+// AddCounter adds a bar counting completed items out of total
+func (r *Renderer) AddCounter(label string, total int) *Bar {
+	return r.add(&Bar{label: label, total: int64(total), count: true})
+}
+
+func (r *Renderer) add(b *Bar) *Bar {
+	b.r = r
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bars = append(r.bars, b)
+	r.redraw()
+	return b
+}
+
+// Set updates the status and progress of the bar
+func (b *Bar) Set(status string, current, total int64) {
+	b.r.mu.Lock()
+	defer b.r.mu.Unlock()
+	b.status = status
+	b.current = current
+	b.total = total
+	b.r.redraw()
+}
+
+// SetLabel changes the label shown after the bar
+func (b *Bar) SetLabel(label string) {
+	b.r.mu.Lock()
+	defer b.r.mu.Unlock()
+	b.label = label
+	b.r.redraw()
+}
+
+// Increment advances a counter by one
+func (b *Bar) Increment() {
+	b.r.mu.Lock()
+	defer b.r.mu.Unlock()
+	b.current++
+	b.r.redraw()
+}
+
+// Remove takes the bar off the screen
+func (b *Bar) Remove() {
+	r := b.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, bar := range r.bars {
+		if bar == b {
+			r.bars = append(r.bars[:i], r.bars[i+1:]...)
+			break
+		}
+	}
+	r.redraw()
+}
+
+// Write prints complete lines of p above the bars. Incomplete lines are
+// buffered until their newline arrives.
+func (r *Renderer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return r.out.Write(p)
+	}
+
+	r.partial = append(r.partial, p...)
+	end := bytes.LastIndexByte(r.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	r.clear()
+	if _, err := r.out.Write(r.partial[:end+1]); err != nil {
+		return 0, err
+	}
+	r.partial = append(r.partial[:0], r.partial[end+1:]...)
+	r.draw()
+
+	return len(p), nil
+}
+
+// Stop removes all bars from the screen and flushes buffered output. Later
+// writes go straight to the terminal.
+func (r *Renderer) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clear()
+	if len(r.partial) > 0 {
+		r.out.Write(r.partial)
+		r.partial = nil
+	}
+	r.bars = nil
+	r.stopped = true
+}
+
+// redraw replaces the bars currently on screen
+func (r *Renderer) redraw() {
+	if r.stopped {
+		return
+	}
+	r.clear()
+	r.draw()
+}
+
+// clear erases the lines drawn by the last draw
+func (r *Renderer) clear() {
+	if r.drawn == 0 {
+		return
+	}
+	io.WriteString(r.out, strings.Repeat("\x1b[1A\x1b[2K", r.drawn))
+	r.drawn = 0
+}
+
+// draw writes every bar on its own line, truncated to the terminal width
+func (r *Renderer) draw() {
+	width := 80
+	if size, err := term.GetWinsize(r.out.Fd()); err == nil && size.Width > 0 {
+		width = int(size.Width)
+	}
+
+	var buf strings.Builder
+	for _, b := range r.bars {
+		line := b.String()
+		if len(line) >= width {
+			line = line[:width-1]
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	io.WriteString(r.out, buf.String())
+	r.drawn = len(r.bars)
+}
+
+// String renders the bar as a single line
+func (b *Bar) String() string {
+	filled := 0
+	percent := 0
+	if b.total > 0 {
+		current := min(b.current, b.total)
+		filled = int(current * barWidth / b.total)
+		percent = int(current * 100 / b.total)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	var amount string
+	if b.count {
+		amount = fmt.Sprintf("%d/%d", b.current, b.total)
+	} else if b.total > 0 {
+		amount = fmt.Sprintf("%s / %s", FormatBytes(b.current), FormatBytes(b.total))
+	} else {
+		amount = FormatBytes(b.current)
+	}
+
+	return fmt.Sprintf("%-6s [%s] %3d%% %s  %s", b.status, bar, percent, amount, b.label)
+}
+
+// FormatBytes formats a byte count using binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
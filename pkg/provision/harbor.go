@@ -0,0 +1,139 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// requestTimeout limits how long a single Harbor API request may take
+const requestTimeout = 30 * time.Second
+
+// harbor manages projects through the Harbor v2 API
+type harbor struct {
+	base string
+	opts Options
+	http *http.Client
+}
+
+func newHarbor(opts Options) (*harbor, error) {
+	base, err := url.Parse(opts.APIURL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid Harbor API URL %q", opts.APIURL)
+	}
+
+	tlsOpts := opts.TLS
+	tlsOpts.SkipVerify = tlsOpts.SkipVerify || opts.Insecure
+	tlsConfig, err := tlsOpts.Config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS options for %s: %v", base.Host, err)
+	}
+	proxy, err := registry.ProxyFunc(opts.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("harbor %s: %v", base.Host, err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	redact.Register(opts.Credentials.Password)
+
+	return &harbor{
+		base: strings.TrimSuffix(base.String(), "/") + "/api/v2.0",
+		opts: opts,
+		http: &http.Client{Timeout: requestTimeout, Transport: transport},
+	}, nil
+}
+
+// ProjectExists asks Harbor whether a project of that name exists
+func (h *harbor) ProjectExists(ctx context.Context, project string) (bool, error) {
+	resp, err := h.do(ctx, http.MethodHead, "/projects?project_name="+url.QueryEscape(project), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, h.statusError(resp)
+	}
+}
+
+// CreateProject creates a project. A project created concurrently by
+// someone else counts as created.
+func (h *harbor) CreateProject(ctx context.Context, project string, public bool) error {
+	body, err := json.Marshal(map[string]any{
+		"project_name": project,
+		"metadata":     map[string]string{"public": strconv.FormatBool(public)},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := h.do(ctx, http.MethodPost, "/projects", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return h.statusError(resp)
+}
+
+// do sends an API request with the credentials of the provider
+func (h *harbor) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if creds := h.opts.Credentials; creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := h.http.Do(req)
+	if err != nil {
+		return nil, &errdefs.NetworkError{Err: fmt.Errorf("request to %s failed: %w", req.URL.Host, err)}
+	}
+	return resp, nil
+}
+
+// statusError converts an unsuccessful response into a classified error
+// carrying Harbor's error message
+func (h *harbor) statusError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := strings.TrimSpace(string(data))
+	var harborErr struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(data, &harborErr) == nil && len(harborErr.Errors) > 0 {
+		message = harborErr.Errors[0].Message
+	}
+
+	err := fmt.Errorf("harbor %s %s returned %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+	if message != "" {
+		err = fmt.Errorf("%w: %s", err, message)
+	}
+	return errdefs.FromHTTPResponse(resp, err)
+}
@@ -0,0 +1,86 @@
+// Package provision prepares target registries for a push where the
+// registry API alone is not enough, such as the projects Harbor needs to
+// exist before images can be pushed into them. Each registry type is a
+// Provider; New returns the one of a type.
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/registry"
+)
+
+// TypeHarbor is the registry type of Harbor
+const TypeHarbor = "harbor"
+
+// Provider manages the projects of a registry type, the top-level
+// namespaces repositories are pushed into
+type Provider interface {
+	// ProjectExists reports whether the project exists
+	ProjectExists(ctx context.Context, project string) (bool, error)
+	// CreateProject creates the project, public or private
+	CreateProject(ctx context.Context, project string, public bool) error
+}
+
+// Options configures a provider
+type Options struct {
+	// APIURL is the base URL of the registry's own API
+	APIURL      string
+	Credentials registry.Credentials
+	Insecure    bool
+	TLS         registry.TLSOptions
+	// Proxy overrides the proxy environment variables: a proxy URL, or
+	// registry.ProxyDirect
+	Proxy string
+}
+
+// New returns the provider of a registry type
+func New(kind string, opts Options) (Provider, error) {
+	switch kind {
+	case TypeHarbor:
+		return newHarbor(opts)
+	default:
+		return nil, fmt.Errorf("unsupported registry type %q, use %s", kind, TypeHarbor)
+	}
+}
+
+// ValidateType checks that a registry type has a provider
+func ValidateType(kind string) error {
+	if kind != TypeHarbor {
+		return fmt.Errorf("unsupported registry type %q, use %s", kind, TypeHarbor)
+	}
+	return nil
+}
+
+// Project returns the project of a repository path, its first component
+func Project(path string) string {
+	project, _, _ := strings.Cut(path, "/")
+	return project
+}
+
+// Ensure checks that every project exists, creating the missing ones when
+// create is set and failing on the first missing one otherwise. It returns
+// the projects it created.
+func Ensure(ctx context.Context, p Provider, projects []string, create, public bool) ([]string, error) {
+	var created []string
+	for _, project := range projects {
+		exists, err := p.ProjectExists(ctx, project)
+		if err != nil {
+			return created, err
+		}
+		if exists {
+			continue
+		}
+		if !create {
+			return created, &errdefs.NotFoundError{Err: fmt.Errorf("project %s doesn't exist; create it or set ensure_project in the registry section", project)}
+		}
+		if err := p.CreateProject(ctx, project, public); err != nil {
+			return created, fmt.Errorf("failed to create project %s: %w", project, err)
+		}
+		created = append(created, project)
+	}
+	return created, nil
+}
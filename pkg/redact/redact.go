@@ -0,0 +1,92 @@
+// Package redact keeps credentials out of output. Secret values print as a
+// mask, and every value registered as a secret is masked in text passing
+// through String, such as log messages, errors and reports.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces secret values in output
+const Mask = "****"
+
+// minLength is the length below which values are not registered, since
+// masking them would mangle unrelated text
+const minLength = 4
+
+// Secret is a credential that prints as Mask in every format and in JSON.
+// Reveal returns the value where it is actually needed.
+type Secret string
+
+// Reveal returns the secret value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String returns Mask, or "" for an empty secret
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Mask
+}
+
+// Format prints the secret as Mask with every verb, including %v, %s, %q
+// and %#v
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", s.String())
+		return
+	}
+	fmt.Fprint(f, s.String())
+}
+
+// MarshalJSON encodes the secret as Mask
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+var (
+	mu      sync.RWMutex
+	secrets = make(map[string]bool)
+	// ordered lists the secrets longest first, so a secret containing
+	// another one is masked as a whole
+	ordered []string
+)
+
+// Register adds values to the secrets masked by String. Empty and very
+// short values are ignored.
+func Register(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	changed := false
+	for _, value := range values {
+		if len(value) < minLength || secrets[value] {
+			continue
+		}
+		secrets[value] = true
+		ordered = append(ordered, value)
+		changed = true
+	}
+	if changed {
+		sort.Slice(ordered, func(i, j int) bool { return len(ordered[i]) > len(ordered[j]) })
+	}
+}
+
+// String masks every registered secret in s
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, secret := range ordered {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, Mask)
+		}
+	}
+	return s
+}
@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// RawManifest fetches the manifest a tag or digest of a repository points
+// to as the registry stores it, along with its media type and digest
+func (c *Client) RawManifest(ctx context.Context, repo, ref string) ([]byte, string, string, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repo, ref), pullScope(repo), manifestTypes...)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to fetch manifest %s/%s:%s: %w", c.host, repo, ref, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read manifest %s/%s:%s: %v", c.host, repo, ref, err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", "", fmt.Errorf("failed to parse manifest %s/%s:%s: %v", c.host, repo, ref, err)
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = resp.Header.Get("Content-Type")
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = DigestOf(data)
+	}
+	return data, mediaType, digest, nil
+}
+
+// IndexMetadata is what an index carries besides the manifests it lists:
+// its media type, its annotations and the annotations of its image
+// descriptors, by platform in os/arch[/variant] form
+type IndexMetadata struct {
+	MediaType   string
+	Annotations map[string]string
+	Platforms   map[string]map[string]string
+	// Order lists the platforms of the image descriptors in the order of
+	// the index
+	Order []string
+}
+
+// IndexMetadata returns the metadata of the manifest list or OCI index a
+// tag or digest of a repository points to, or nil if it is a single image
+func (c *Client) IndexMetadata(ctx context.Context, repo, ref string) (*IndexMetadata, error) {
+	data, mediaType, _, err := c.RawManifest(ctx, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != MediaTypeManifestList && mediaType != MediaTypeOCIIndex {
+		return nil, nil
+	}
+
+	var index struct {
+		Annotations map[string]string `json:"annotations"`
+		Manifests   []Descriptor      `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid manifest list %s/%s:%s: %v", c.host, repo, ref, err)
+	}
+	meta := &IndexMetadata{MediaType: mediaType, Annotations: index.Annotations}
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil || desc.IsAttestation() {
+			continue
+		}
+		meta.Order = append(meta.Order, desc.Platform.String())
+		if len(desc.Annotations) == 0 {
+			continue
+		}
+		if meta.Platforms == nil {
+			meta.Platforms = make(map[string]map[string]string)
+		}
+		meta.Platforms[desc.Platform.String()] = desc.Annotations
+	}
+	return meta, nil
+}
+
+// Missing lists what of m the metadata stored in a registry lacks, such as
+// annotations that were dropped or have another value
+func (m IndexMetadata) Missing(stored *IndexMetadata) []string {
+	if stored == nil {
+		return []string{"the manifest list itself, the tag references a single image"}
+	}
+	var missing []string
+	if m.MediaType != "" && stored.MediaType != m.MediaType {
+		missing = append(missing, fmt.Sprintf("media type %s, it is %s", m.MediaType, stored.MediaType))
+	}
+	if len(m.Order) > 0 {
+		want := slices.DeleteFunc(slices.Clone(m.Order), func(platform string) bool { return !slices.Contains(stored.Order, platform) })
+		got := slices.DeleteFunc(slices.Clone(stored.Order), func(platform string) bool { return !slices.Contains(m.Order, platform) })
+		if !slices.Equal(want, got) {
+			missing = append(missing, fmt.Sprintf("platform order %s, it is %s", strings.Join(want, ", "), strings.Join(got, ", ")))
+		}
+	}
+	missing = append(missing, missingAnnotations("", m.Annotations, stored.Annotations)...)
+	for platform, annotations := range m.Platforms {
+		missing = append(missing, missingAnnotations(" of "+platform, annotations, stored.Platforms[platform])...)
+	}
+	return missing
+}
+
+// missingAnnotations lists the annotations of want that stored lacks or
+// has with another value. where qualifies them, e.g. " of linux/amd64".
+func missingAnnotations(where string, want, stored map[string]string) []string {
+	var missing []string
+	for key, value := range want {
+		if current, ok := stored[key]; !ok {
+			missing = append(missing, fmt.Sprintf("annotation %s%s", key, where))
+		} else if current != value {
+			missing = append(missing, fmt.Sprintf("annotation %s%s %q, it is %q", key, where, value, current))
+		}
+	}
+	return missing
+}
+
+// RewriteIndex gives the manifest list or OCI index a tag of a repository
+// points to the media type, if set, the annotations and the order of meta,
+// and
+// pushes it back under the tag. A docker manifest list has no annotations,
+// so meta should turn it into an OCI index to add any. Annotations of
+// platforms the index doesn't list are ignored. It returns the digests of
+// the index before and after; they differ unless it had the metadata
+// already.
+func (c *Client) RewriteIndex(ctx context.Context, repo, tag string, meta IndexMetadata) (string, string, error) {
+	data, mediaType, from, err := c.RawManifest(ctx, repo, tag)
+	if err != nil {
+		return "", "", err
+	}
+	if mediaType != MediaTypeManifestList && mediaType != MediaTypeOCIIndex {
+		return "", "", fmt.Errorf("%s/%s:%s is not a manifest list but %s", c.host, repo, tag, mediaType)
+	}
+
+	rewritten, err := SetIndexMetadata(data, meta)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid manifest list %s/%s:%s: %v", c.host, repo, tag, err)
+	}
+	if DigestOf(rewritten) == DigestOf(data) {
+		return from, from, nil
+	}
+	if meta.MediaType != "" {
+		mediaType = meta.MediaType
+	}
+	to, err := c.PushManifest(ctx, repo, tag, mediaType, rewritten)
+	if err != nil {
+		return "", "", err
+	}
+	if to == "" {
+		to = DigestOf(rewritten)
+	}
+	return from, to, nil
+}
+
+// SetIndexMetadata returns an index with the media type, if set, and the
+// annotations of meta added to its own and those of its descriptors,
+// keeping its other fields. With an order, its descriptors are sorted by
+// their platform's position in it; those of other platforms follow.
+func SetIndexMetadata(data []byte, meta IndexMetadata) ([]byte, error) {
+	data, err := AddAnnotations(data, meta.Annotations, meta.MediaType)
+	if err != nil || (len(meta.Platforms) == 0 && len(meta.Order) == 0) {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var manifests []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["manifests"], &manifests); err != nil {
+		return nil, fmt.Errorf("invalid manifests: %v", err)
+	}
+	changed := false
+	position := make([]int, len(manifests))
+	for i, desc := range manifests {
+		position[i] = len(meta.Order)
+		var platform Platform
+		if raw, ok := desc["platform"]; !ok || json.Unmarshal(raw, &platform) != nil {
+			continue
+		}
+		if index := slices.Index(meta.Order, platform.String()); index >= 0 {
+			position[i] = index
+		}
+		added, err := addAnnotations(desc, meta.Platforms[platform.String()])
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || added
+	}
+	if !slices.IsSorted(position) {
+		order := make([]int, len(manifests))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int { return position[a] - position[b] })
+		sorted := make([]map[string]json.RawMessage, len(manifests))
+		for i, index := range order {
+			sorted[i] = manifests[index]
+		}
+		manifests = sorted
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	if fields["manifests"], err = json.Marshal(manifests); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// AddAnnotations returns a manifest or index with annotations added to
+// those it has, keeping its other fields. mediaType, if set, replaces its
+// media type.
+func AddAnnotations(data []byte, annotations map[string]string, mediaType string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	changed, err := addAnnotations(fields, annotations)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "" {
+		current, _ := json.Marshal(mediaType)
+		if string(fields["mediaType"]) != string(current) {
+			fields["mediaType"] = current
+			changed = true
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(fields)
+}
+
+// addAnnotations adds annotations to the annotations field of a JSON object
+// and reports whether any was added or changed
+func addAnnotations(fields map[string]json.RawMessage, annotations map[string]string) (bool, error) {
+	if len(annotations) == 0 {
+		return false, nil
+	}
+	merged := make(map[string]string)
+	if raw, ok := fields["annotations"]; ok {
+		if err := json.Unmarshal(raw, &merged); err != nil {
+			return false, fmt.Errorf("invalid annotations: %v", err)
+		}
+	}
+	changed := false
+	for key, value := range annotations {
+		if current, ok := merged[key]; !ok || current != value {
+			merged[key] = value
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return false, err
+	}
+	fields["annotations"] = raw
+	return true, nil
+}
+
+// DigestOf returns the sha256 digest of content
+func DigestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
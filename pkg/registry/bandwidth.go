@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+)
+
+// limitedTransport limits the bandwidth of the bodies a transport sends and
+// receives
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *bandwidth.Limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := bandwidth.FromContext(req.Context())
+	if limiter == nil {
+		limiter = t.limiter
+	}
+	if limiter == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(ctx)
+		req.Body = limitedBody{Reader: limiter.Reader(ctx, req.Body), Closer: req.Body}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = limitedBody{Reader: limiter.Reader(ctx, resp.Body), Closer: resp.Body}
+	return resp, nil
+}
+
+// limitedBody is a body read through a limiter
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// pageSize is the number of entries requested per page of a listing
+const pageSize = 100
+
+// errStop ends a listing early once the visitor has seen enough
+var errStop = errors.New("stop listing")
+
+// Catalog lists every repository of the registry through the _catalog
+// endpoint, following pagination, and calls visit for each. Listing stops
+// early when visit returns false.
+func (c *Client) Catalog(ctx context.Context, visit func(repo string) bool) error {
+	path := fmt.Sprintf("/v2/_catalog?n=%d", pageSize)
+	for path != "" {
+		resp, err := c.get(ctx, path, "registry:catalog:*", "application/json")
+		if err != nil {
+			return fmt.Errorf("failed to list repositories of %s: %w", c.host, err)
+		}
+
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse catalog of %s: %v", c.host, err)
+		}
+
+		for _, repo := range page.Repositories {
+			if !visit(repo) {
+				return nil
+			}
+		}
+		path = nextLink(resp)
+	}
+	return nil
+}
+
+// Tags lists every tag of a repository, following pagination
+func (c *Client) Tags(ctx context.Context, repo string) ([]string, error) {
+	var tags []string
+	path := fmt.Sprintf("/v2/%s/tags/list?n=%d", repo, pageSize)
+	for path != "" {
+		resp, err := c.get(ctx, path, "repository:"+repo+":pull", "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s/%s: %w", c.host, repo, err)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tags of %s/%s: %v", c.host, repo, err)
+		}
+
+		tags = append(tags, page.Tags...)
+		path = nextLink(resp)
+	}
+	return tags, nil
+}
+
+// Repositories lists the repositories below namespace, or every repository
+// when namespace is empty, and calls visit for each. The _catalog endpoint is
+// used first. Registries that restrict it, like Harbor for non-admin users,
+// or don't implement it, like Quay, are listed through their project APIs.
+func (c *Client) Repositories(ctx context.Context, namespace string, visit func(repo string) bool) error {
+	prefix := ""
+	if namespace != "" {
+		prefix = strings.TrimSuffix(namespace, "/") + "/"
+	}
+
+	err := c.Catalog(ctx, func(repo string) bool {
+		if !strings.HasPrefix(repo, prefix) {
+			return true
+		}
+		return visit(repo)
+	})
+	if err == nil || namespace == "" || !catalogUnavailable(err) {
+		return err
+	}
+
+	harborErr := c.harborRepositories(ctx, namespace, visit)
+	if harborErr == nil || errors.Is(harborErr, errStop) {
+		return nil
+	}
+	quayErr := c.quayRepositories(ctx, namespace, visit)
+	if quayErr == nil || errors.Is(quayErr, errStop) {
+		return nil
+	}
+
+	return fmt.Errorf("%w (Harbor API: %v; Quay API: %v)", err, harborErr, quayErr)
+}
+
+// catalogUnavailable reports whether a _catalog failure means the endpoint
+// is restricted or missing rather than the registry being unreachable
+func catalogUnavailable(err error) bool {
+	return errors.Is(err, errdefs.ErrAuthentication) || errors.Is(err, errdefs.ErrAuthorization) ||
+		errors.Is(err, errdefs.ErrNotFound)
+}
+
+// harborRepositories lists the repositories of a Harbor project
+func (c *Client) harborRepositories(ctx context.Context, project string, visit func(repo string) bool) error {
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/api/v2.0/projects/%s/repositories?page=%d&page_size=%d",
+			url.PathEscape(project), page, pageSize)
+		resp, err := c.get(ctx, path, "", "application/json")
+		if err != nil {
+			return err
+		}
+
+		var repos []struct {
+			Name string `json:"name"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&repos)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse Harbor repositories: %v", err)
+		}
+
+		for _, repo := range repos {
+			if !visit(repo.Name) {
+				return errStop
+			}
+		}
+		if len(repos) < pageSize {
+			return nil
+		}
+	}
+}
+
+// quayRepositories lists the repositories of a Quay namespace
+func (c *Client) quayRepositories(ctx context.Context, namespace string, visit func(repo string) bool) error {
+	query := url.Values{"namespace": {namespace}}
+	for {
+		resp, err := c.get(ctx, "/api/v1/repository?"+query.Encode(), "", "application/json")
+		if err != nil {
+			return err
+		}
+
+		var page struct {
+			Repositories []struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+			} `json:"repositories"`
+			NextPage string `json:"next_page"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse Quay repositories: %v", err)
+		}
+
+		for _, repo := range page.Repositories {
+			if !visit(repo.Namespace + "/" + repo.Name) {
+				return errStop
+			}
+		}
+		if page.NextPage == "" {
+			return nil
+		}
+		query.Set("next_page", page.NextPage)
+	}
+}
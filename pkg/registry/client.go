@@ -0,0 +1,475 @@
+// Package registry talks to the Docker Registry HTTP API V2 directly, for
+// the operations the docker daemon doesn't offer, such as listing
+// repositories and tags. It also resolves the credentials stored by docker
+// login.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/bandwidth"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"github.com/Fr000g/ImgMigrate/pkg/version"
+)
+
+// Retry policy for requests the registry throttled
+const (
+	retryAttempts = 3
+	retryDelay    = 2 * time.Second
+)
+
+// requestTimeout limits how long a single API request may take. Uploads
+// are only limited by their context, since blobs can be large.
+const requestTimeout = 60 * time.Second
+
+// Options configures a registry API client
+type Options struct {
+	Credentials Credentials
+	// Insecure skips certificate verification and falls back to plain HTTP
+	Insecure bool
+	// TLS adds certificate authorities and a client certificate
+	TLS TLSOptions
+	// Proxy overrides the proxy taken from the environment: a proxy URL, or
+	// ProxyDirect to connect without one
+	Proxy string
+	// RequestInterval is the minimum time between two requests; zero disables
+	// rate limiting
+	RequestInterval time.Duration
+	// Resolve, if set, is called once when the registry rejects the
+	// credentials, to pick up credentials renewed since the client was
+	// created, such as an expired token
+	Resolve func() (Credentials, error)
+	// Limiter limits the bandwidth of request and response bodies, unless
+	// the context of a request carries a limiter of its own
+	Limiter *bandwidth.Limiter
+	// Pauses, if set, pauses the registry when it asks for requests to
+	// wait, for the requests of every client sharing it
+	Pauses *Pauses
+}
+
+// Client makes authenticated requests to the API of a single registry
+type Client struct {
+	host   string
+	opts   Options
+	http   *http.Client
+	upload *http.Client
+
+	mu     sync.Mutex
+	scheme string
+	creds  Credentials
+	// tokens caches bearer tokens by scope
+	tokens map[string]string
+	last   time.Time
+}
+
+// NewClient creates a client for the registry at host. It fails when the
+// TLS options name files that can't be loaded or the proxy is invalid.
+func NewClient(host string, opts Options) (*Client, error) {
+	tlsOpts := opts.TLS
+	tlsOpts.SkipVerify = tlsOpts.SkipVerify || opts.Insecure
+	tlsConfig, err := tlsOpts.Config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS options for %s: %v", host, err)
+	}
+
+	proxy, err := ProxyFunc(opts.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: %v", host, err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	opts.Credentials.register()
+	limited := &limitedTransport{base: transport, limiter: opts.Limiter}
+
+	return &Client{
+		host:   host,
+		opts:   opts,
+		http:   &http.Client{Timeout: requestTimeout, Transport: limited},
+		upload: &http.Client{Transport: limited},
+		scheme: "https",
+		creds:  opts.Credentials,
+		tokens: make(map[string]string),
+	}, nil
+}
+
+// Host returns the registry host the client talks to
+func (c *Client) Host() string {
+	return c.host
+}
+
+// endpoint returns the host serving the registry API
+func (c *Client) endpoint() string {
+	return APIHost(c.host)
+}
+
+// get sends a GET request for path, which may include a query, and returns
+// the response when it succeeded. scope is the token scope the request
+// needs when the registry uses bearer authentication.
+func (c *Client) get(ctx context.Context, path, scope string, accept ...string) (*http.Response, error) {
+	return c.request(ctx, http.MethodGet, path, scope, accept...)
+}
+
+// request sends a request for path and returns the response when it
+// succeeded, retrying while the registry throttles requests. When the
+// registry rejects the credentials, they are resolved again once.
+func (c *Client) request(ctx context.Context, method, path, scope string, accept ...string) (*http.Response, error) {
+	return c.requestBody(ctx, method, path, scope, nil, accept...)
+}
+
+// body is the content of a request. open is called for every attempt,
+// since a request may be sent again.
+type body struct {
+	open        func() (io.ReadCloser, error)
+	size        int64
+	contentType string
+}
+
+// requestBody sends a request with a body like request. path may also be
+// an absolute URL, such as the upload location a registry returned. A
+// registry that answers with a Retry-After while Options.Pauses is set is
+// paused for every request to it, without spending an attempt, until the
+// waits of the request add up to the longest pause.
+func (c *Client) requestBody(ctx context.Context, method, path, scope string, b *body, accept ...string) (*http.Response, error) {
+	delay := retryDelay
+	resolved := false
+	var paused time.Duration
+	for attempt := 1; ; attempt++ {
+		if _, err := c.opts.Pauses.Wait(ctx, c.host); err != nil {
+			return nil, err
+		}
+		resp, err := c.send(ctx, method, path, scope, b, accept)
+		if err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+			wait := retryAfter(resp)
+			if wait > 0 && c.opts.Pauses != nil && paused < c.opts.Pauses.Max() {
+				paused += c.opts.Pauses.Pause(c.host, wait, c.statusError(resp))
+				resp.Body.Close()
+				attempt--
+				continue
+			}
+			if attempt < retryAttempts {
+				resp.Body.Close()
+				if wait == 0 {
+					wait = delay
+				}
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(wait):
+				}
+				delay *= 2
+				continue
+			}
+		}
+		if err == nil && resp.StatusCode >= 300 {
+			err = c.statusError(resp)
+			resp.Body.Close()
+		}
+		if err != nil {
+			if !resolved && errors.Is(err, errdefs.ErrAuthentication) && c.resolve() {
+				resolved = true
+				continue
+			}
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// retryAfter returns how long the Retry-After header of resp asks to wait,
+// or zero if it has none
+func retryAfter(resp *http.Response) time.Duration {
+	wait, _ := errdefs.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return wait
+}
+
+// resolve replaces the credentials with freshly resolved ones and drops
+// the cached tokens. It reports whether there was a way to resolve them.
+func (c *Client) resolve() bool {
+	if c.opts.Resolve == nil {
+		return false
+	}
+	creds, err := c.opts.Resolve()
+	if err != nil {
+		return false
+	}
+	creds.register()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creds = creds
+	c.tokens = make(map[string]string)
+	return true
+}
+
+// credentials returns the current credentials
+func (c *Client) credentials() Credentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.creds
+}
+
+// send sends a single request, answering an authentication challenge once
+func (c *Client) send(ctx context.Context, method, path, scope string, b *body, accept []string) (*http.Response, error) {
+	resp, err := c.do(ctx, method, path, scope, b, accept)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(ctx, challenge, scope); err != nil {
+		return nil, err
+	}
+	return c.do(ctx, method, path, scope, b, accept)
+}
+
+// do sends a request with the cached token for scope, or basic credentials
+// when there is none
+func (c *Client) do(ctx context.Context, method, path, scope string, b *body, accept []string) (*http.Response, error) {
+	c.throttle()
+
+	c.mu.Lock()
+	scheme := c.scheme
+	token := c.tokens[scope]
+	creds := c.creds
+	c.mu.Unlock()
+
+	target := scheme + "://" + c.endpoint() + path
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		target = path
+	}
+	client := c.http
+	var content io.ReadCloser
+	if b != nil {
+		var err error
+		if content, err = b.open(); err != nil {
+			return nil, err
+		}
+		client = c.upload
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, content)
+	if err != nil {
+		if content != nil {
+			content.Close()
+		}
+		return nil, err
+	}
+	if b != nil {
+		req.ContentLength = b.size
+		req.Header.Set("Content-Type", b.contentType)
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	for _, mediaType := range accept {
+		req.Header.Add("Accept", mediaType)
+	}
+	if token == "" {
+		token = creds.RegistryToken
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil && c.opts.Insecure && scheme == "https" && target != path {
+		// Insecure registries may only speak plain HTTP
+		c.mu.Lock()
+		c.scheme = "http"
+		c.mu.Unlock()
+		return c.do(ctx, method, path, scope, b, accept)
+	}
+	if err != nil {
+		if explained := ExplainTLSError(c.host, err); explained != err {
+			return nil, explained
+		}
+		return nil, &errdefs.NetworkError{Err: fmt.Errorf("request to %s failed: %w", c.host, err)}
+	}
+	return resp, nil
+}
+
+// throttle waits until RequestInterval has passed since the previous request
+func (c *Client) throttle() {
+	if c.opts.RequestInterval <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	wait := time.Until(c.last.Add(c.opts.RequestInterval))
+	if wait < 0 {
+		wait = 0
+	}
+	c.last = time.Now().Add(wait)
+	c.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// authenticate answers a WWW-Authenticate challenge. A bearer challenge is
+// answered by fetching a token from the realm it names; basic
+// authentication needs no preparation since credentials are always sent.
+func (c *Client) authenticate(ctx context.Context, challenge, scope string) error {
+	creds := c.credentials()
+	if creds.RegistryToken != "" {
+		// A registry token is used as is, so there is nothing to exchange
+		return &errdefs.AuthenticationError{Err: fmt.Errorf("%s rejected the registry token, it may have expired", c.host)}
+	}
+
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") {
+		if creds.Empty() {
+			return &errdefs.AuthenticationError{Err: fmt.Errorf("%s requires authentication", c.host)}
+		}
+		return &errdefs.AuthenticationError{Err: fmt.Errorf("%s rejected the credentials", c.host)}
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("%s sent a bearer challenge without a realm", c.host)
+	}
+
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	} else if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+
+	var req *http.Request
+	var err error
+	if creds.IdentityToken != "" {
+		query.Set("grant_type", "refresh_token")
+		query.Set("refresh_token", creds.IdentityToken)
+		query.Set("client_id", "imgMigrate")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(query.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+		if err == nil && creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &errdefs.NetworkError{Err: fmt.Errorf("token request to %s failed: %w", realm, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return c.statusError(resp)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to parse token response from %s: %v", realm, err)
+	}
+
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return &errdefs.AuthenticationError{Err: fmt.Errorf("%s issued no token", realm)}
+	}
+
+	redact.Register(token)
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+
+	return scheme, params
+}
+
+// statusError converts an unsuccessful response into a classified error
+// carrying the registry's error message
+func (c *Client) statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	message := strings.TrimSpace(string(body))
+	var registryErr struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &registryErr) == nil && len(registryErr.Errors) > 0 {
+		var parts []string
+		for _, e := range registryErr.Errors {
+			parts = append(parts, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		message = strings.Join(parts, "; ")
+	}
+
+	err := fmt.Errorf("%s %s returned %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, message)
+	if resp.StatusCode == http.StatusMethodNotAllowed && resp.Request.Method == http.MethodDelete {
+		return fmt.Errorf("%s has deletion disabled (a distribution registry needs REGISTRY_STORAGE_DELETE_ENABLED=true): %w", c.host, err)
+	}
+	return errdefs.FromHTTPResponse(resp, err)
+}
+
+// nextLink returns the path and query of the next page announced in a Link
+// header, or "" on the last page
+func nextLink(resp *http.Response) string {
+	for _, link := range resp.Header.Values("Link") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+
+		target = strings.Trim(strings.TrimSpace(target), "<>")
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return ""
+		}
+		if parsed.RawQuery != "" {
+			return parsed.Path + "?" + parsed.RawQuery
+		}
+		return parsed.Path
+	}
+	return ""
+}
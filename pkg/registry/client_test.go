@@ -0,0 +1,348 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// testRepo is the repository the recorded responses were recorded for
+const testRepo = "library/app"
+
+// readRecorded reads a response recorded from a registry in testdata, with
+// {{server}} replaced by the URL of the server replaying it
+func readRecorded(t *testing.T, name, server string) (*http.Response, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.ReplaceAll(data, []byte("{{server}}"), []byte(server))
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return resp, body
+}
+
+// recordedDigest returns the digest a recorded response reported
+func recordedDigest(t *testing.T, name string) string {
+	t.Helper()
+	resp, _ := readRecorded(t, name, "")
+	return resp.Header.Get("Docker-Content-Digest")
+}
+
+// replay is a registry serving recorded responses. Manifests and blobs are
+// served by the digest they were recorded with; routes maps other request
+// paths to the responses they get in turn, the last one repeating.
+type replay struct {
+	t      *testing.T
+	server *httptest.Server
+	routes map[string][]string
+	// token, if set, is the bearer token requests must carry, issued to
+	// username and password
+	token              string
+	username, password string
+
+	mu       sync.Mutex
+	requests []string
+	// tokenRequests are the queries of the token requests
+	tokenRequests []string
+}
+
+// newReplay starts a registry replaying the responses in testdata
+func newReplay(t *testing.T, routes map[string][]string) *replay {
+	r := &replay{t: t, routes: make(map[string][]string)}
+	for path, names := range routes {
+		r.routes[path] = names
+	}
+	names, err := filepath.Glob(filepath.Join("testdata", "*.http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		name = filepath.Base(name)
+		if digest := recordedDigest(t, name); digest != "" {
+			r.routes["/v2/"+testRepo+"/manifests/"+digest] = []string{name}
+			r.routes["/v2/"+testRepo+"/blobs/"+digest] = []string{name}
+		}
+	}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// requireToken makes the registry answer requests without the token it
+// issues to username and password with a bearer challenge
+func (r *replay) requireToken(username, password string) {
+	_, body := readRecorded(r.t, "token.http", "")
+	var token struct{ Token string }
+	if err := json.Unmarshal(body, &token); err != nil {
+		r.t.Fatal(err)
+	}
+	r.token, r.username, r.password = token.Token, username, password
+}
+
+func (r *replay) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	name := ""
+	switch {
+	case req.URL.Path == "/token":
+		r.tokenRequests = append(r.tokenRequests, req.URL.RawQuery)
+		name = "token.http"
+		if username, password, ok := req.BasicAuth(); !ok || username != r.username || password != r.password {
+			name = "unauthorized.http"
+		}
+	case r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token:
+		name = "unauthorized.http"
+	default:
+		responses := r.routes[req.URL.RequestURI()]
+		if len(responses) == 0 {
+			r.t.Errorf("unexpected request %s %s", req.Method, req.URL.RequestURI())
+			name = "manifest-unknown.http"
+			break
+		}
+		name = responses[0]
+		if len(responses) > 1 {
+			r.routes[req.URL.RequestURI()] = responses[1:]
+		}
+	}
+	r.mu.Unlock()
+
+	resp, body := readRecorded(r.t, name, r.server.URL)
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// client returns a client of the replaying registry
+func (r *replay) client(creds Credentials) *Client {
+	r.t.Helper()
+	c, err := NewClient(strings.TrimPrefix(r.server.URL, "https://"), Options{Credentials: creds, Insecure: true})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return c
+}
+
+// TestTokenAuth answers a bearer challenge with a token fetched with the
+// credentials, which is used for every request of the same scope
+func TestTokenAuth(t *testing.T) {
+	r := newReplay(t, map[string][]string{"/v2/library/app/manifests/1.25": {"index.http"}})
+	r.requireToken("user", "secret")
+	c := r.client(Credentials{Username: "user", Password: "secret"})
+
+	digest, err := c.Digest(t.Context(), testRepo, "1.25")
+	if err != nil {
+		t.Fatalf("Digest: %v", err)
+	}
+	if want := recordedDigest(t, "index.http"); digest != want {
+		t.Errorf("Digest = %s, want %s", digest, want)
+	}
+	if _, err := c.Manifest(t.Context(), testRepo, "1.25"); err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+
+	want := []string{
+		"HEAD /v2/library/app/manifests/1.25",
+		"GET /token",
+		"HEAD /v2/library/app/manifests/1.25",
+		"GET /v2/library/app/manifests/1.25",
+	}
+	if !reflect.DeepEqual(r.requests, want) {
+		t.Errorf("requests = %q, want %q", r.requests, want)
+	}
+	if want := []string{"scope=repository%3Alibrary%2Fapp%3Apull&service=registry.example.com"}; !reflect.DeepEqual(r.tokenRequests, want) {
+		t.Errorf("token requests = %q, want %q", r.tokenRequests, want)
+	}
+}
+
+func TestTokenAuthRejected(t *testing.T) {
+	r := newReplay(t, nil)
+	r.requireToken("user", "secret")
+	c := r.client(Credentials{Username: "user", Password: "wrong"})
+
+	_, err := c.Manifest(t.Context(), testRepo, "1.25")
+	if !errors.Is(err, errdefs.ErrAuthentication) {
+		t.Fatalf("Manifest with a wrong password = %v, want an authentication error", err)
+	}
+	if !strings.Contains(err.Error(), "UNAUTHORIZED: authentication required") {
+		t.Errorf("error %q lacks the message of the registry", err)
+	}
+}
+
+// TestInspectIndex inspects an OCI index of two platforms and the
+// attestation BuildKit added for one of them
+func TestInspectIndex(t *testing.T) {
+	r := newReplay(t, map[string][]string{"/v2/library/app/manifests/1.25": {"index.http"}})
+	c := r.client(Credentials{})
+
+	image, err := c.Inspect(t.Context(), testRepo, "1.25")
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if !image.IsList() || image.MediaType != MediaTypeOCIIndex {
+		t.Errorf("media type = %s, want an OCI index", image.MediaType)
+	}
+	if want := recordedDigest(t, "index.http"); image.Digest != want {
+		t.Errorf("digest = %s, want %s", image.Digest, want)
+	}
+
+	want := []struct {
+		platform    string
+		manifest    string
+		attestation bool
+		layers      int
+	}{
+		{"linux/amd64", "manifest-amd64.http", false, 2},
+		{"linux/arm64/v8", "manifest-arm64.http", false, 2},
+		{"unknown/unknown", "manifest-attestation.http", true, 1},
+	}
+	if len(image.Entries) != len(want) {
+		t.Fatalf("%d entries, want %d: %+v", len(image.Entries), len(want), image.Entries)
+	}
+	for i, entry := range image.Entries {
+		w := want[i]
+		if entry.Platform.String() != w.platform || entry.Digest != recordedDigest(t, w.manifest) ||
+			entry.Attestation != w.attestation || len(entry.Layers) != w.layers || entry.MediaType != MediaTypeOCIManifest {
+			t.Errorf("entry %d = %s %s attestation=%v with %d layers, want %s %s attestation=%v with %d layers",
+				i, entry.Platform, entry.Digest, entry.Attestation, len(entry.Layers), w.platform, recordedDigest(t, w.manifest), w.attestation, w.layers)
+		}
+	}
+	// The platforms share their base layer, which is downloaded once
+	if image.DownloadSize != 3627258 {
+		t.Errorf("download size = %d, want 3627258", image.DownloadSize)
+	}
+
+	entry, err := image.Entry("linux/arm64")
+	if err != nil {
+		t.Fatalf("Entry: %v", err)
+	}
+	if entry.Digest != recordedDigest(t, "manifest-arm64.http") {
+		t.Errorf("linux/arm64 is %s, want the arm64/v8 manifest", entry.Digest)
+	}
+	if _, err := image.Entry("unknown/unknown"); err == nil {
+		t.Error("Entry matched the attestation")
+	}
+
+	platforms, digest, err := c.Platforms(t.Context(), testRepo, "1.25")
+	if err != nil {
+		t.Fatalf("Platforms: %v", err)
+	}
+	var names []string
+	for _, platform := range platforms {
+		names = append(names, platform.Platform.String())
+	}
+	if !reflect.DeepEqual(names, []string{"linux/amd64", "linux/arm64/v8"}) || digest != image.Digest {
+		t.Errorf("Platforms = %v at %s, want linux/amd64 and linux/arm64/v8 at %s", names, digest, image.Digest)
+	}
+}
+
+// TestPlatformsManifest reads the platform of a single image manifest from
+// its image config
+func TestPlatformsManifest(t *testing.T) {
+	r := newReplay(t, map[string][]string{"/v2/library/app/manifests/single": {"manifest-v2.http"}})
+	c := r.client(Credentials{})
+
+	platforms, digest, err := c.Platforms(t.Context(), testRepo, "single")
+	if err != nil {
+		t.Fatalf("Platforms: %v", err)
+	}
+	if want := recordedDigest(t, "manifest-v2.http"); digest != want || len(platforms) != 1 || platforms[0].Digest != want {
+		t.Fatalf("Platforms = %+v at %s, want the manifest %s", platforms, digest, want)
+	}
+	if platforms[0].Platform.String() != "linux/amd64" || platforms[0].MediaType != MediaTypeManifest {
+		t.Errorf("platform = %s %s, want linux/amd64 %s", platforms[0].Platform, platforms[0].MediaType, MediaTypeManifest)
+	}
+
+	created, err := c.Created(t.Context(), testRepo, "single")
+	if err != nil {
+		t.Fatalf("Created: %v", err)
+	}
+	if got := created.UTC().Format("2006-01-02T15:04:05"); got != "2025-07-08T17:21:43" {
+		t.Errorf("Created = %s, want 2025-07-08T17:21:43", got)
+	}
+}
+
+// TestStatusErrors classifies the errors registries answer with and keeps
+// their message
+func TestStatusErrors(t *testing.T) {
+	r := newReplay(t, map[string][]string{
+		"/v2/library/app/manifests/nope": {"manifest-unknown.http"},
+		"/v2/private/app/manifests/1.0":  {"denied.http"},
+	})
+	c := r.client(Credentials{})
+
+	tests := []struct {
+		repo, ref string
+		sentinel  error
+		message   string
+	}{
+		{testRepo, "nope", errdefs.ErrNotFound, "404 Not Found: MANIFEST_UNKNOWN: manifest unknown"},
+		{"private/app", "1.0", errdefs.ErrAuthorization, "403 Forbidden: DENIED: requested access to the resource is denied"},
+	}
+	for _, tt := range tests {
+		_, err := c.Manifest(t.Context(), tt.repo, tt.ref)
+		if !errors.Is(err, tt.sentinel) {
+			t.Errorf("Manifest(%s:%s) = %v, want %v", tt.repo, tt.ref, err, tt.sentinel)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("Manifest(%s:%s) = %q, want it to say %q", tt.repo, tt.ref, err, tt.message)
+		}
+	}
+}
+
+// TestRateLimitRetry waits as long as a throttling registry asks before
+// sending the request again
+func TestRateLimitRetry(t *testing.T) {
+	r := newReplay(t, map[string][]string{"/v2/library/app/manifests/1.25": {"toomanyrequests.http", "index.http"}})
+	c := r.client(Credentials{})
+
+	digest, err := c.Digest(t.Context(), testRepo, "1.25")
+	if err != nil {
+		t.Fatalf("Digest: %v", err)
+	}
+	if want := recordedDigest(t, "index.http"); digest != want {
+		t.Errorf("Digest = %s, want %s", digest, want)
+	}
+	if len(r.requests) != 2 {
+		t.Errorf("requests = %q, want the throttled one and its retry", r.requests)
+	}
+}
+
+func TestTagsPagination(t *testing.T) {
+	r := newReplay(t, map[string][]string{
+		"/v2/library/app/tags/list?n=100":           {"tags.http"},
+		"/v2/library/app/tags/list?last=1.25&n=100": {"tags-last.http"},
+	})
+	c := r.client(Credentials{})
+
+	tags, err := c.Tags(t.Context(), testRepo)
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if want := []string{"1.24", "1.25", "latest", "single"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Tags = %v, want %v", tags, want)
+	}
+}
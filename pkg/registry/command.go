@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// CommandTimeout limits how long a credential command may take
+const CommandTimeout = 30 * time.Second
+
+// CommandProvider obtains the credentials of a registry by running a
+// configured shell command, such as a secret manager lookup. The output is
+// kept for the rest of the run and never logged. It implements
+// CredentialProvider.
+type CommandProvider struct {
+	host     string
+	username string
+	command  string
+	// document is set when the command prints a JSON auth document instead
+	// of a password
+	document bool
+
+	mu    sync.Mutex
+	creds Credentials
+}
+
+// NewPasswordCommand creates a provider whose command prints the password
+// of username
+func NewPasswordCommand(host, username, command string) *CommandProvider {
+	return &CommandProvider{host: host, username: username, command: command}
+}
+
+// NewAuthCommand creates a provider whose command prints a JSON auth
+// document with username, password, token or identity_token
+func NewAuthCommand(host, command string) *CommandProvider {
+	return &CommandProvider{host: host, command: command, document: true}
+}
+
+// Credentials runs the command the first time and returns its credentials
+func (p *CommandProvider) Credentials(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.creds.Empty() {
+		return p.creds, nil
+	}
+
+	output, err := p.run(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	creds := Credentials{Username: p.username, Password: output}
+	if p.document {
+		var doc struct {
+			Username      string `json:"username"`
+			Password      string `json:"password"`
+			Token         string `json:"token"`
+			IdentityToken string `json:"identity_token"`
+		}
+		if err := json.Unmarshal([]byte(output), &doc); err != nil {
+			// The output is not included, it may hold a secret
+			return Credentials{}, p.failure(errors.New("output is not a JSON auth document"))
+		}
+		creds = Credentials{Username: doc.Username, Password: doc.Password, RegistryToken: doc.Token, IdentityToken: doc.IdentityToken}
+	}
+	if creds.Empty() || (creds.Username != "" && creds.Password == "" && creds.IdentityToken == "") {
+		return Credentials{}, p.failure(errors.New("no credentials in the output"))
+	}
+
+	p.creds = creds
+	return creds, nil
+}
+
+// Invalidate discards the credentials, so the command runs again
+func (p *CommandProvider) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.creds = Credentials{}
+}
+
+// run executes the command and returns its trimmed standard output
+func (p *CommandProvider) run(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, CommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := shellCommand(ctx, p.command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", p.failure(fmt.Errorf("timed out after %s", CommandTimeout))
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", p.failure(err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// failure attributes a command error to the registry
+func (p *CommandProvider) failure(err error) error {
+	return &errdefs.AuthenticationError{
+		Err: fmt.Errorf("credential command for %s failed: %v", p.host, err),
+	}
+}
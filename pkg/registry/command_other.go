@@ -0,0 +1,13 @@
+//go:build !unix
+
+package registry
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs a command line with the Windows command interpreter
+func shellCommand(ctx context.Context, line string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", line)
+}
@@ -0,0 +1,141 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Copied is the image Copy copied
+type Copied struct {
+	Digest    string
+	MediaType string
+	Platforms []Platform
+	// Uploaded and Skipped count the blobs uploaded and those the target
+	// repository had already, Bytes and SkippedBytes their sizes
+	Uploaded     int
+	Skipped      int
+	Bytes        int64
+	SkippedBytes int64
+}
+
+// Blob opens a blob of a repository
+func (c *Client) Blob(ctx context.Context, repo, digest string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repo, digest), pullScope(repo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blob %s from %s/%s: %w", digest, c.host, repo, err)
+	}
+	return resp.Body, nil
+}
+
+// Copy copies the manifest a tag or digest of a repository of src points
+// to, with every manifest and blob it references, to a repository of c
+// and tags it. Manifests are pushed byte for byte, so the copy keeps the
+// digest, the order and the annotations of the source, attestations
+// and indexes nested up to MaxIndexDepth levels included. Blobs the target
+// repository has already are skipped.
+func (c *Client) Copy(ctx context.Context, src *Client, srcRepo, ref, repo, tag string) (*Copied, error) {
+	copier := &copier{src: src, srcRepo: srcRepo, dst: c, repo: repo, copied: &Copied{}, pushed: make(map[string]bool), platforms: make(map[string]bool)}
+	digest, mediaType, err := copier.copyManifest(ctx, ref, tag, 1)
+	if err != nil {
+		return nil, err
+	}
+	copier.copied.Digest = digest
+	copier.copied.MediaType = mediaType
+	return copier.copied, nil
+}
+
+// copier holds the state of a Copy
+type copier struct {
+	src     *Client
+	srcRepo string
+	dst     *Client
+	repo    string
+	copied  *Copied
+	// pushed holds the manifests pushed by digest, and platforms those
+	// recorded in copied, so a manifest nested indexes share is copied once
+	pushed    map[string]bool
+	platforms map[string]bool
+}
+
+// copyManifest copies the manifest ref points to and what it references,
+// pushing it under tag, or its digest when tag is empty. depth is the level
+// of indexes the manifest is nested at.
+func (p *copier) copyManifest(ctx context.Context, ref, tag string, depth int) (string, string, error) {
+	data, mediaType, digest, err := p.src.RawManifest(ctx, p.srcRepo, ref)
+	if err != nil {
+		return "", "", err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", "", fmt.Errorf("invalid manifest %s/%s@%s: %v", p.src.host, p.srcRepo, digest, err)
+	}
+
+	if manifest.IsList() {
+		if depth > MaxIndexDepth {
+			return "", "", fmt.Errorf("index %s/%s@%s is nested more than %d levels deep", p.src.host, p.srcRepo, digest, MaxIndexDepth)
+		}
+		for _, desc := range manifest.Manifests {
+			if !p.pushed[desc.Digest] {
+				if _, _, err := p.copyManifest(ctx, desc.Digest, "", depth+1); err != nil {
+					return "", "", err
+				}
+			}
+			if desc.Platform != nil && !desc.IsAttestation() && !IsIndexType(desc.MediaType) && !p.platforms[desc.Platform.String()] {
+				p.platforms[desc.Platform.String()] = true
+				p.copied.Platforms = append(p.copied.Platforms, *desc.Platform)
+			}
+		}
+	} else {
+		blobs := manifest.Layers
+		if manifest.Config != nil {
+			blobs = append([]Descriptor{*manifest.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			if err := p.copyBlob(ctx, blob); err != nil {
+				return "", "", err
+			}
+		}
+	}
+
+	if tag == "" {
+		tag = digest
+	}
+	pushed, err := p.dst.PushManifest(ctx, p.repo, tag, mediaType, data)
+	if err != nil {
+		return "", "", err
+	}
+	if pushed != "" && pushed != digest {
+		return "", "", fmt.Errorf("%s/%s stored manifest %s as %s", p.dst.host, p.repo, digest, pushed)
+	}
+	p.pushed[digest] = true
+	return digest, mediaType, nil
+}
+
+// copyBlob uploads a blob unless the target repository has it already.
+// Non-distributable layers, which registries don't serve, are skipped.
+func (p *copier) copyBlob(ctx context.Context, blob Descriptor) error {
+	if strings.Contains(blob.MediaType, "foreign") || strings.Contains(blob.MediaType, "nondistributable") {
+		return nil
+	}
+	exists, err := p.dst.BlobExists(ctx, p.repo, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		p.copied.Skipped++
+		p.copied.SkippedBytes += blob.Size
+		return nil
+	}
+	err = p.dst.PushBlob(ctx, p.repo, blob, func() (io.ReadCloser, error) {
+		return p.src.Blob(ctx, p.srcRepo, blob.Digest)
+	})
+	if err != nil {
+		return err
+	}
+	p.copied.Uploaded++
+	p.copied.Bytes += blob.Size
+	return nil
+}
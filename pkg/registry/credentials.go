@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// DockerHubConfigKey is the key docker login uses for Docker Hub credentials
+const DockerHubConfigKey = "https://index.docker.io/v1/"
+
+// Credentials authenticate against a registry
+type Credentials struct {
+	Username string
+	Password string
+	// IdentityToken is a refresh token issued by the registry in place of a password
+	IdentityToken string
+	// RegistryToken is a bearer token sent to the registry as is
+	RegistryToken string
+}
+
+// Empty reports whether no credentials are set
+func (c Credentials) Empty() bool {
+	return c.Username == "" && c.IdentityToken == "" && c.RegistryToken == ""
+}
+
+// register makes the redact package mask the secret parts of the credentials
+func (c Credentials) register() {
+	redact.Register(c.Password, c.IdentityToken, c.RegistryToken)
+}
+
+// dockerConfigFile mirrors the parts of ~/.docker/config.json used for authentication
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// ConfigKey returns the key docker login stores the credentials of a registry host under
+func ConfigKey(host string) string {
+	if host == "docker.io" {
+		return DockerHubConfigKey
+	}
+	return host
+}
+
+// StoredCredentials looks up credentials stored by docker login for a
+// registry host the same way the docker CLI does: a per-registry credential
+// helper, then the default credential store, then the inline auths section
+// of the config file.
+func StoredCredentials(host string) (Credentials, bool) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return Credentials{}, false
+	}
+
+	var cfg dockerConfigFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Credentials{}, false
+	}
+
+	key := ConfigKey(host)
+
+	if helper, ok := cfg.CredHelpers[host]; ok {
+		return credentialHelper(helper, key)
+	}
+	if cfg.CredsStore != "" {
+		if creds, ok := credentialHelper(cfg.CredsStore, key); ok {
+			return creds, true
+		}
+	}
+
+	for _, candidate := range []string{key, "https://" + host, "http://" + host} {
+		entry, ok := cfg.Auths[candidate]
+		if !ok {
+			continue
+		}
+
+		creds := Credentials{IdentityToken: entry.IdentityToken}
+		if decoded, err := base64.StdEncoding.DecodeString(entry.Auth); err == nil {
+			if user, pass, ok := strings.Cut(string(decoded), ":"); ok {
+				creds.Username = user
+				creds.Password = pass
+			}
+		}
+		return creds, true
+	}
+
+	return Credentials{}, false
+}
+
+// credentialHelper queries a docker credential helper for serverURL
+func credentialHelper(helper, serverURL string) (Credentials, bool) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, false
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return Credentials{}, false
+	}
+
+	if creds.Username == "<token>" {
+		return Credentials{IdentityToken: creds.Secret}, true
+	}
+	return Credentials{Username: creds.Username, Password: creds.Secret}, true
+}
+
+// NormalizeHost strips the scheme and path from a registry URL, mapping the
+// Docker Hub endpoints to docker.io
+func NormalizeHost(url string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return host
+}
+
+// CredentialProvider supplies credentials obtained at run time, such as
+// short-lived tokens, instead of configured literals
+type CredentialProvider interface {
+	// Credentials returns the current credentials, renewing them when they
+	// are missing or about to expire
+	Credentials(ctx context.Context) (Credentials, error)
+	// Invalidate discards the current credentials after a registry rejected
+	// them, so the next call to Credentials renews them
+	Invalidate()
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+)
+
+// Image describes what a tag or digest resolves to: the entries of a
+// manifest list, or a single image manifest as its only entry
+type Image struct {
+	Digest    string       `json:"digest"`
+	MediaType string       `json:"media_type"`
+	Entries   []ImageEntry `json:"entries"`
+	// DownloadSize is the compressed size of the distinct blobs of every
+	// entry that is not an attestation
+	DownloadSize int64 `json:"download_size"`
+}
+
+// IsList reports whether the image is a manifest list or OCI index
+func (i *Image) IsList() bool {
+	return i.MediaType == MediaTypeManifestList || i.MediaType == MediaTypeOCIIndex
+}
+
+// Entry returns the image for a platform in os/arch[/variant] form. Without
+// a variant, any variant of the architecture matches as long as only one
+// does. Attestations are never matched.
+func (i *Image) Entry(platform string) (ImageEntry, error) {
+	want, err := ParsePlatform(platform)
+	if err != nil {
+		return ImageEntry{}, err
+	}
+
+	var matches []ImageEntry
+	for _, entry := range i.Entries {
+		if !entry.Attestation && entry.Platform.Matches(want) {
+			matches = append(matches, entry)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return ImageEntry{}, fmt.Errorf("no image for platform %s", platform)
+	case 1:
+		return matches[0], nil
+	}
+	return ImageEntry{}, fmt.Errorf("%d images match platform %s, add the variant", len(matches), platform)
+}
+
+// ImageEntry is one image manifest of an Image
+type ImageEntry struct {
+	Platform    Platform `json:"platform"`
+	Digest      string   `json:"digest"`
+	MediaType   string   `json:"media_type"`
+	Attestation bool     `json:"attestation,omitempty"`
+	// Nested is set when an index nested in the inspected one lists it
+	Nested bool         `json:"nested,omitempty"`
+	Config *Descriptor  `json:"config,omitempty"`
+	Layers []Descriptor `json:"layers"`
+	// Size is the compressed size of the config and layers
+	Size int64 `json:"size"`
+}
+
+// Inspect fetches the manifests a tag or digest points to. The manifest of
+// every list entry, those of nested indexes included, is fetched for its
+// layers; a single image manifest carries no platform, so it is read from
+// the image config.
+func (c *Client) Inspect(ctx context.Context, repo, ref string) (*Image, error) {
+	manifest, err := c.Manifest(ctx, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{Digest: manifest.Digest, MediaType: manifest.MediaType}
+
+	if !manifest.IsList() {
+		config, err := c.ImageConfig(ctx, repo, ref, manifest)
+		if err != nil {
+			return nil, err
+		}
+		image.Entries = []ImageEntry{newImageEntry(config.Platform, manifest)}
+	} else {
+		descriptors, err := c.Flatten(ctx, repo, manifest)
+		if err != nil {
+			return nil, err
+		}
+		platforms := make(map[string]bool)
+		for _, descriptor := range descriptors {
+			// A platform listed by several nested indexes is kept where it
+			// is nested the least, so Entry finds it once
+			attestation := descriptor.IsAttestation()
+			if descriptor.Platform != nil && !attestation {
+				if platforms[descriptor.Platform.String()] {
+					continue
+				}
+				platforms[descriptor.Platform.String()] = true
+			}
+
+			child, err := c.Manifest(ctx, repo, descriptor.Digest)
+			if err != nil {
+				return nil, err
+			}
+			child.Digest = descriptor.Digest
+			if child.MediaType == "" {
+				child.MediaType = descriptor.MediaType
+			}
+
+			var platform Platform
+			if descriptor.Platform != nil {
+				platform = *descriptor.Platform
+			}
+			entry := newImageEntry(platform, child)
+			entry.Attestation = attestation
+			entry.Nested = descriptor.Nested
+			image.Entries = append(image.Entries, entry)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range image.Entries {
+		if entry.Attestation {
+			continue
+		}
+		blobs := entry.Layers
+		if entry.Config != nil {
+			blobs = append([]Descriptor{*entry.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			if !seen[blob.Digest] {
+				seen[blob.Digest] = true
+				image.DownloadSize += blob.Size
+			}
+		}
+	}
+	return image, nil
+}
+
+// newImageEntry describes an image manifest
+func newImageEntry(platform Platform, manifest *Manifest) ImageEntry {
+	entry := ImageEntry{
+		Platform:  platform,
+		Digest:    manifest.Digest,
+		MediaType: manifest.MediaType,
+		Config:    manifest.Config,
+		Layers:    manifest.Layers,
+	}
+	if entry.Config != nil {
+		entry.Size += entry.Config.Size
+	}
+	for _, layer := range entry.Layers {
+		entry.Size += layer.Size
+	}
+	return entry
+}
@@ -0,0 +1,249 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Manifest media types
+const (
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// manifestTypes are accepted when fetching manifests, so registries return
+// lists and indexes instead of converting them
+var manifestTypes = []string{MediaTypeManifestList, MediaTypeOCIIndex, MediaTypeManifest, MediaTypeOCIManifest}
+
+// attestationType is the reference type annotation BuildKit sets on the
+// attestation manifests it adds to an index
+const attestationType = "attestation-manifest"
+
+// Platform describes the platform an image runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+	OSVersion    string `json:"os.version,omitempty"`
+}
+
+// String returns the platform in os/arch[/variant] form
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// ParsePlatform parses a platform in os/arch[/variant] form
+func ParsePlatform(platform string) (Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, use os/arch[/variant]", platform)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// Matches reports whether p is the platform want. Without a variant, want
+// matches any variant of its architecture.
+func (p Platform) Matches(want Platform) bool {
+	if p.OS != want.OS || p.Architecture != want.Architecture {
+		return false
+	}
+	return want.Variant == "" || p.Variant == want.Variant
+}
+
+// Descriptor references a manifest or blob by digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// IsAttestation reports whether the descriptor references an attestation
+// manifest rather than an image
+func (d Descriptor) IsAttestation() bool {
+	return d.Annotations["vnd.docker.reference.type"] == attestationType ||
+		(d.Platform != nil && d.Platform.OS == "unknown" && d.Platform.Architecture == "unknown")
+}
+
+// Manifest is an image manifest, manifest list or OCI index. Lists and
+// indexes carry Manifests; image manifests carry Config and Layers.
+type Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []Descriptor `json:"manifests,omitempty"`
+	Config    *Descriptor  `json:"config,omitempty"`
+	Layers    []Descriptor `json:"layers,omitempty"`
+
+	// Digest is the digest the registry reported for the manifest, Size
+	// its length in bytes
+	Digest string `json:"-"`
+	Size   int64  `json:"-"`
+}
+
+// IsList reports whether the manifest is a manifest list or OCI index
+func (m *Manifest) IsList() bool {
+	return m.MediaType == MediaTypeManifestList || m.MediaType == MediaTypeOCIIndex || len(m.Manifests) > 0
+}
+
+// pullScope returns the token scope for reading a repository
+func pullScope(repo string) string {
+	return "repository:" + repo + ":pull"
+}
+
+// Digest resolves a tag or digest of a repository to the digest of its manifest
+func (c *Client) Digest(ctx context.Context, repo, ref string) (string, error) {
+	resp, err := c.request(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repo, ref), pullScope(repo), manifestTypes...)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s/%s:%s: %w", c.host, repo, ref, err)
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("%s did not report a digest for %s:%s", c.host, repo, ref)
+	}
+	return digest, nil
+}
+
+// Manifest fetches the manifest a tag or digest of a repository points to
+func (c *Client) Manifest(ctx context.Context, repo, ref string) (*Manifest, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repo, ref), pullScope(repo), manifestTypes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest %s/%s:%s: %w", c.host, repo, ref, err)
+	}
+	defer resp.Body.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s/%s:%s: %v", c.host, repo, ref, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = resp.Header.Get("Content-Type")
+	}
+	manifest.Digest = resp.Header.Get("Docker-Content-Digest")
+	if resp.ContentLength > 0 {
+		manifest.Size = resp.ContentLength
+	}
+	return &manifest, nil
+}
+
+// ImageConfig is the part of an image config the client reads
+type ImageConfig struct {
+	Created time.Time `json:"created"`
+	Platform
+	Config RuntimeConfig `json:"config"`
+}
+
+// RuntimeConfig is the configuration a container of the image starts with
+type RuntimeConfig struct {
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	User       string            `json:"User,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+// FormatArgs formats an entrypoint or command as a JSON array, as in a
+// Dockerfile
+func FormatArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = strconv.Quote(arg)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// Created returns the creation time recorded in the image config a tag or
+// digest points to. For a manifest list the first image is used.
+func (c *Client) Created(ctx context.Context, repo, ref string) (time.Time, error) {
+	manifest, err := c.Manifest(ctx, repo, ref)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if manifest.IsList() {
+		if len(manifest.Manifests) == 0 {
+			return time.Time{}, fmt.Errorf("manifest list %s/%s:%s is empty", c.host, repo, ref)
+		}
+		if manifest, err = c.Manifest(ctx, repo, manifest.Manifests[0].Digest); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	config, err := c.ImageConfig(ctx, repo, ref, manifest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return config.Created, nil
+}
+
+// Platforms returns the platforms of the images a tag or digest points to,
+// along with the digest it resolved to. The platforms of indexes nested in
+// an index are included, and attestation manifests are left out. A single
+// image manifest carries no platform, so it is read from the image config.
+func (c *Client) Platforms(ctx context.Context, repo, ref string) ([]PlatformImage, string, error) {
+	manifest, err := c.Manifest(ctx, repo, ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !manifest.IsList() {
+		config, err := c.ImageConfig(ctx, repo, ref, manifest)
+		if err != nil {
+			return nil, "", err
+		}
+		return []PlatformImage{{Platform: config.Platform, Digest: manifest.Digest, Size: manifest.Size, MediaType: manifest.MediaType}}, manifest.Digest, nil
+	}
+
+	platforms, err := c.PlatformImages(ctx, repo, manifest)
+	if err != nil {
+		return nil, "", err
+	}
+	return platforms, manifest.Digest, nil
+}
+
+// ImageConfig fetches the image config of an image manifest. ref names the
+// manifest in errors.
+func (c *Client) ImageConfig(ctx context.Context, repo, ref string, manifest *Manifest) (*ImageConfig, error) {
+	if manifest.Config == nil {
+		return nil, fmt.Errorf("manifest %s/%s:%s has no image config", c.host, repo, ref)
+	}
+
+	resp, err := c.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repo, manifest.Config.Digest), pullScope(repo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image config of %s/%s:%s: %w", c.host, repo, ref, err)
+	}
+	defer resp.Body.Close()
+
+	var config ImageConfig
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse image config of %s/%s:%s: %v", c.host, repo, ref, err)
+	}
+	return &config, nil
+}
+
+// DeleteManifest deletes a manifest by digest, which removes every tag
+// pointing to it
+func (c *Client) DeleteManifest(ctx context.Context, repo, digest string) error {
+	resp, err := c.request(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", repo, digest),
+		"repository:"+repo+":pull,push,delete")
+	if err != nil {
+		return fmt.Errorf("failed to delete %s/%s@%s: %w", c.host, repo, digest, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxIndexDepth is how many levels of indexes nested in an index are
+// followed, the top level index included
+const MaxIndexDepth = 4
+
+// IsIndexType reports whether a media type is a manifest list or OCI index
+func IsIndexType(mediaType string) bool {
+	return mediaType == MediaTypeManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// IndexEntry is an image manifest an index lists, directly or through an
+// index nested in it
+type IndexEntry struct {
+	Descriptor
+	// Nested is set when the manifest is listed by a nested index
+	Nested bool
+}
+
+// Flatten returns the image manifests an index lists, following the indexes
+// nested in it up to MaxIndexDepth levels. The entries of an index come
+// before those of the indexes it nests, and a manifest listed through
+// several paths is returned once.
+func (c *Client) Flatten(ctx context.Context, repo string, index *Manifest) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	seen := make(map[string]bool)
+	level := []*Manifest{index}
+	for depth := 1; len(level) > 0; depth++ {
+		var nested []*Manifest
+		for _, list := range level {
+			for _, desc := range list.Manifests {
+				if seen[desc.Digest] {
+					continue
+				}
+				seen[desc.Digest] = true
+				if !IsIndexType(desc.MediaType) {
+					entries = append(entries, IndexEntry{Descriptor: desc, Nested: depth > 1})
+					continue
+				}
+				if depth >= MaxIndexDepth {
+					return nil, fmt.Errorf("index %s/%s@%s nests indexes more than %d levels deep", c.host, repo, index.Digest, MaxIndexDepth)
+				}
+				child, err := c.Manifest(ctx, repo, desc.Digest)
+				if err != nil {
+					return nil, err
+				}
+				nested = append(nested, child)
+			}
+		}
+		level = nested
+	}
+	return entries, nil
+}
+
+// PlatformImage is a platform of an index and the image manifest it
+// resolves to
+type PlatformImage struct {
+	Platform  Platform
+	Digest    string
+	Size      int64
+	MediaType string
+	// Nested is set when the manifest is listed by a nested index
+	Nested bool
+}
+
+// PlatformImages returns the platforms of the images an index lists,
+// nested indexes included. Attestations are left out, and a platform listed
+// through several paths is kept where it is nested the least.
+func (c *Client) PlatformImages(ctx context.Context, repo string, index *Manifest) ([]PlatformImage, error) {
+	entries, err := c.Flatten(ctx, repo, index)
+	if err != nil {
+		return nil, err
+	}
+	var images []PlatformImage
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Platform == nil || entry.IsAttestation() || seen[entry.Platform.String()] {
+			continue
+		}
+		seen[entry.Platform.String()] = true
+		images = append(images, PlatformImage{Platform: *entry.Platform, Digest: entry.Digest, Size: entry.Size, MediaType: entry.MediaType, Nested: entry.Nested})
+	}
+	return images, nil
+}
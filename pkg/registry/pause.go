@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPause is the longest a registry is paused for a single
+// Retry-After unless NewPauses is given a cap
+const DefaultMaxPause = 10 * time.Minute
+
+// Pauses tracks the registries that asked for their requests to wait, with
+// a Retry-After header or its equivalent in docker output, such as during
+// a garbage collection window. Every operation on a paused registry waits
+// for the pause to end instead of spending its retries, while operations on
+// other registries go on. A nil *Pauses never pauses.
+type Pauses struct {
+	max    time.Duration
+	notify func(host string, wait time.Duration, reason error)
+
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// NewPauses creates the pauses of a run. A single Retry-After pauses a
+// registry for at most max, or DefaultMaxPause when it is zero. notify, if
+// set, is called whenever a registry is paused or its pause extended.
+func NewPauses(max time.Duration, notify func(host string, wait time.Duration, reason error)) *Pauses {
+	if max <= 0 {
+		max = DefaultMaxPause
+	}
+	return &Pauses{max: max, notify: notify, until: make(map[string]time.Time)}
+}
+
+// Max returns the longest a single pause lasts
+func (p *Pauses) Max() time.Duration {
+	if p == nil {
+		return 0
+	}
+	return p.max
+}
+
+// Pause pauses host for wait, capped at Max, because a request failed
+// with reason. A pause that would end before the current one doesn't
+// shorten it. It returns the wait as capped.
+func (p *Pauses) Pause(host string, wait time.Duration, reason error) time.Duration {
+	if p == nil || wait <= 0 {
+		return 0
+	}
+	wait = min(wait, p.max)
+	until := time.Now().Add(wait)
+
+	host = APIHost(host)
+	p.mu.Lock()
+	extended := until.After(p.until[host])
+	if extended {
+		p.until[host] = until
+	}
+	p.mu.Unlock()
+
+	if extended && p.notify != nil {
+		p.notify(host, wait, reason)
+	}
+	return wait
+}
+
+// Remaining returns how long host stays paused, or zero
+func (p *Pauses) Remaining(host string) time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return max(time.Until(p.until[APIHost(host)]), 0)
+}
+
+// Wait blocks while host is paused and returns how long it did. It fails
+// when ctx ends first.
+func (p *Pauses) Wait(ctx context.Context, host string) (time.Duration, error) {
+	start := time.Now()
+	for {
+		remaining := p.Remaining(host)
+		if remaining <= 0 {
+			return time.Since(start), nil
+		}
+		// The pause may be extended while waiting
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
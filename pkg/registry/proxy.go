@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyDirect as a proxy setting makes requests to a registry bypass any
+// proxy, including one from the environment
+const ProxyDirect = "direct"
+
+// ProxyFunc returns the transport proxy function for a proxy setting. An
+// empty setting uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the
+// environment, ProxyDirect uses no proxy, and anything else is the URL of
+// the proxy to use.
+func ProxyFunc(setting string) (func(*http.Request) (*url.URL, error), error) {
+	switch setting {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case ProxyDirect:
+		return nil, nil
+	}
+	proxy, err := ParseProxy(setting)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(proxy), nil
+}
+
+// ParseProxy parses a proxy URL. A value without a scheme is an HTTP proxy,
+// as in the proxy environment variables.
+func ParseProxy(setting string) (*url.URL, error) {
+	if !strings.Contains(setting, "://") {
+		setting = "http://" + setting
+	}
+	proxy, err := url.Parse(setting)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", setting, err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %s", setting, proxy.Scheme)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", setting)
+	}
+	return proxy, nil
+}
+
+// ProxyFor returns the proxy used for HTTPS API requests to a registry host
+// with a proxy setting, or nil when they connect directly
+func ProxyFor(host, setting string) (*url.URL, error) {
+	proxy, err := ProxyFunc(setting)
+	if err != nil || proxy == nil {
+		return nil, err
+	}
+	return proxy(&http.Request{Method: http.MethodGet, URL: &url.URL{Scheme: "https", Host: APIHost(host), Path: "/v2/"}})
+}
+
+// APIHost returns the host serving the API of a registry
+func APIHost(host string) string {
+	if host == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return host
+}
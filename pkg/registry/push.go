@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+)
+
+// pushScope returns the token scope for writing to a repository
+func pushScope(repo string) string {
+	return "repository:" + repo + ":pull,push"
+}
+
+// BlobExists reports whether a repository has a blob
+func (c *Client) BlobExists(ctx context.Context, repo, digest string) (bool, error) {
+	resp, err := c.request(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/blobs/%s", repo, digest), pushScope(repo))
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check blob %s in %s/%s: %w", digest, c.host, repo, err)
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// PushBlob uploads a blob to a repository in a single request. open is
+// called for every attempt and returns the content of the blob.
+func (c *Client) PushBlob(ctx context.Context, repo string, desc Descriptor, open func() (io.ReadCloser, error)) error {
+	resp, err := c.request(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", repo), pushScope(repo))
+	if err != nil {
+		return fmt.Errorf("failed to start upload of %s to %s/%s: %w", desc.Digest, c.host, repo, err)
+	}
+	resp.Body.Close()
+
+	location, err := uploadLocation(resp, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to %s/%s: %v", desc.Digest, c.host, repo, err)
+	}
+	resp, err = c.requestBody(ctx, http.MethodPut, location, pushScope(repo),
+		&body{open: open, size: desc.Size, contentType: "application/octet-stream"})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to %s/%s: %w", desc.Digest, c.host, repo, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// uploadLocation returns where to finish an upload the registry started,
+// with the digest of the blob added to its query
+func uploadLocation(resp *http.Response, digest string) (string, error) {
+	header := resp.Header.Get("Location")
+	if header == "" {
+		return "", fmt.Errorf("the registry returned no upload location")
+	}
+	location, err := resp.Request.URL.Parse(header)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %q: %v", header, err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+	if location.Host == resp.Request.URL.Host && location.Scheme == resp.Request.URL.Scheme {
+		return (&url.URL{Path: location.Path, RawPath: location.RawPath, RawQuery: location.RawQuery}).String(), nil
+	}
+	return location.String(), nil
+}
+
+// PushManifest uploads a manifest under a tag or its digest and returns
+// the digest the registry reported for it
+func (c *Client) PushManifest(ctx context.Context, repo, ref, mediaType string, data []byte) (string, error) {
+	open := func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	resp, err := c.requestBody(ctx, http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", repo, ref), pushScope(repo),
+		&body{open: open, size: int64(len(data)), contentType: mediaType})
+	if err != nil {
+		return "", fmt.Errorf("failed to push manifest %s/%s:%s: %w", c.host, repo, ref, err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
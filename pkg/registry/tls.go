@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions configures TLS for a registry beyond the system trust store
+type TLSOptions struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system ones
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// to registries that require mutual TLS
+	CertFile string
+	KeyFile  string
+	// SkipVerify accepts any server certificate, still speaking HTTPS
+	SkipVerify bool
+}
+
+// Config builds the TLS configuration for the options
+func (o TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.SkipVerify}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// ExplainTLSError rewrites a certificate failure talking to host into a
+// message naming the problem and how to fix it. Other errors are returned
+// unchanged.
+func ExplainTLSError(host string, err error) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return fmt.Errorf("the certificate of %s is signed by an unknown authority; pass its CA with --registry-ca or ca_file, or set skip_tls_verify: %w", host, err)
+	case errors.As(err, &hostname):
+		return fmt.Errorf("the certificate of %s is not valid for that name (it covers %s): %w", host, strings.Join(hostname.Certificate.DNSNames, ", "), err)
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return fmt.Errorf("the certificate of %s has expired or is not yet valid: %w", host, err)
+		}
+		return fmt.Errorf("the certificate of %s is invalid: %w", host, err)
+	case errors.As(err, &verification):
+		return fmt.Errorf("the certificate of %s could not be verified: %w", host, err)
+	case strings.Contains(err.Error(), "tls: certificate required"), strings.Contains(err.Error(), "tls: bad certificate"):
+		return fmt.Errorf("%s requires a valid client certificate; pass --registry-cert and --registry-key or cert_file and key_file: %w", host, err)
+	}
+	return err
+}
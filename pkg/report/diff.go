@@ -0,0 +1,222 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+)
+
+// Image is an image a run mirrored: the target of a task, or the source of
+// a task that saved it, with the digest pushed under the target and the
+// digest of every platform
+type Image struct {
+	Image     string            `json:"image"`
+	Task      string            `json:"task"`
+	Digest    string            `json:"digest,omitempty"`
+	Platforms map[string]string `json:"platforms,omitempty"`
+}
+
+// DigestChange is an image both runs mirrored whose digests differ
+type DigestChange struct {
+	Image     string           `json:"image"`
+	From      string           `json:"from,omitempty"`
+	To        string           `json:"to,omitempty"`
+	Platforms []PlatformChange `json:"platforms,omitempty"`
+}
+
+// PlatformChange is a platform of an image whose digest differs; From or To
+// is empty for a platform only one run mirrored
+type PlatformChange struct {
+	Platform string `json:"platform"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+}
+
+// StatusChange is a task that failed in one run and not in the other
+type StatusChange struct {
+	Task string `json:"task"`
+	// From is the status in the older run, empty if it had no such task
+	From  string `json:"from,omitempty"`
+	To    string `json:"to"`
+	Error string `json:"error,omitempty"`
+}
+
+// Comparison is what changed from one run to another
+type Comparison struct {
+	Old          string         `json:"old"`
+	New          string         `json:"new"`
+	Added        []Image        `json:"added,omitempty"`
+	Removed      []Image        `json:"removed,omitempty"`
+	Updated      []DigestChange `json:"updated,omitempty"`
+	NewlyFailing []StatusChange `json:"newly_failing,omitempty"`
+	NewlyFixed   []StatusChange `json:"newly_fixed,omitempty"`
+}
+
+// Empty reports whether nothing changed
+func (c *Comparison) Empty() bool {
+	return len(c.Added)+len(c.Removed)+len(c.Updated)+len(c.NewlyFailing)+len(c.NewlyFixed) == 0
+}
+
+// Compare compares the report of a run with the report of an earlier one,
+// named oldName and newName in the comparison. Tasks are matched by the
+// image they mirror, so reordering the tasks of a configuration changes
+// nothing.
+func Compare(oldName string, old *Report, newName string, new *Report) *Comparison {
+	c := &Comparison{Old: oldName, New: newName}
+	oldImages, oldStatus := old.images()
+	newImages, newStatus := new.images()
+
+	for _, key := range sortedKeys(newImages) {
+		image := newImages[key]
+		before, ok := oldImages[key]
+		if !ok {
+			c.Added = append(c.Added, image)
+			continue
+		}
+		if change, changed := compareImage(before, image); changed {
+			c.Updated = append(c.Updated, change)
+		}
+	}
+	for _, key := range sortedKeys(oldImages) {
+		if _, ok := newStatus[key]; !ok {
+			c.Removed = append(c.Removed, oldImages[key])
+		}
+	}
+
+	for _, key := range sortedKeys(newStatus) {
+		task, before := newStatus[key], oldStatus[key]
+		switch {
+		case task.Status == StatusFailed && before.Status != StatusFailed:
+			c.NewlyFailing = append(c.NewlyFailing, StatusChange{Task: task.Name, From: before.Status, To: task.Status, Error: task.Error})
+		case task.Status != StatusFailed && before.Status == StatusFailed:
+			c.NewlyFixed = append(c.NewlyFixed, StatusChange{Task: task.Name, From: before.Status, To: task.Status})
+		}
+	}
+	return c
+}
+
+// images returns the images the tasks of a report mirrored, and every task,
+// by the canonical image they mirror
+func (r *Report) images() (map[string]Image, map[string]TaskReport) {
+	images := make(map[string]Image)
+	tasks := make(map[string]TaskReport)
+	for _, task := range r.Tasks {
+		key := task.Name
+		if result := task.Result; result != nil {
+			key = imageref.Key(result.Source)
+			if result.Target != "" {
+				key = imageref.Key(result.Target)
+			}
+		}
+		tasks[key] = task
+		if task.Result == nil || task.Result.DryRun || task.Status == StatusFailed || task.Status == StatusDeferred {
+			continue
+		}
+
+		result := task.Result
+		image := Image{Image: result.Source, Task: task.Name, Platforms: make(map[string]string)}
+		if result.Target != "" {
+			image.Image = result.Target
+			image.Digest = result.PushedDigest(result.Target)
+		}
+		for _, platform := range result.Platforms {
+			if platform.Error != "" || platform.Deferred {
+				continue
+			}
+			digest := platform.SourceDigest
+			if platform.Pushed != nil && platform.Pushed.Digest != "" {
+				digest = platform.Pushed.Digest
+			}
+			image.Platforms[platform.Platform] = digest
+		}
+		images[key] = image
+	}
+	return images, tasks
+}
+
+// compareImage returns the digest changes of an image between two runs
+func compareImage(old, new Image) (DigestChange, bool) {
+	change := DigestChange{Image: new.Image}
+	if old.Digest != new.Digest {
+		change.From, change.To = old.Digest, new.Digest
+	}
+	platforms := make(map[string]bool)
+	for platform := range old.Platforms {
+		platforms[platform] = true
+	}
+	for platform := range new.Platforms {
+		platforms[platform] = true
+	}
+	for _, platform := range sortedKeys(platforms) {
+		if from, to := old.Platforms[platform], new.Platforms[platform]; from != to {
+			change.Platforms = append(change.Platforms, PlatformChange{Platform: platform, From: from, To: to})
+		}
+	}
+	return change, change.From != change.To || len(change.Platforms) > 0
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Print prints the comparison as text
+func (c *Comparison) Print(w io.Writer) {
+	fmt.Fprintf(w, "Comparing %s with %s\n", c.Old, c.New)
+	if c.Empty() {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	if len(c.Added) > 0 {
+		fmt.Fprintf(w, "\nAdded (%d):\n", len(c.Added))
+		for _, image := range c.Added {
+			fmt.Fprintf(w, "  + %s\n", pinned(image.Image, image.Digest))
+		}
+	}
+	if len(c.Removed) > 0 {
+		fmt.Fprintf(w, "\nRemoved (%d):\n", len(c.Removed))
+		for _, image := range c.Removed {
+			fmt.Fprintf(w, "  - %s\n", pinned(image.Image, image.Digest))
+		}
+	}
+	if len(c.Updated) > 0 {
+		fmt.Fprintf(w, "\nUpdated (%d):\n", len(c.Updated))
+		for _, change := range c.Updated {
+			fmt.Fprintf(w, "  ~ %s", change.Image)
+			if change.From != change.To {
+				fmt.Fprintf(w, ": %s -> %s", orNone(change.From), orNone(change.To))
+			}
+			fmt.Fprintln(w)
+			for _, platform := range change.Platforms {
+				fmt.Fprintf(w, "      %s: %s -> %s\n", platform.Platform, orNone(platform.From), orNone(platform.To))
+			}
+		}
+	}
+	if len(c.NewlyFailing) > 0 {
+		fmt.Fprintf(w, "\nNewly failing (%d):\n", len(c.NewlyFailing))
+		for _, task := range c.NewlyFailing {
+			fmt.Fprintf(w, "  ! %s: %s\n", task.Task, task.Error)
+		}
+	}
+	if len(c.NewlyFixed) > 0 {
+		fmt.Fprintf(w, "\nNewly fixed (%d):\n", len(c.NewlyFixed))
+		for _, task := range c.NewlyFixed {
+			fmt.Fprintf(w, "  * %s (%s)\n", task.Task, task.To)
+		}
+	}
+}
+
+// orNone formats a digest only one run has
+func orNone(digest string) string {
+	if digest == "" {
+		return "(none)"
+	}
+	return digest
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// Event is a pipeline event as a line of the event stream. Type is one of
+// task_started, platform_pulled, platform_saved, platform_pushed,
+// platform_failed, manifest_created, source_changed, disk_waited and
+// task_completed; the other fields are set as they apply to it.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	RunID    string    `json:"run_id,omitempty"`
+	Source   string    `json:"source"`
+	Target   string    `json:"target,omitempty"`
+	Platform string    `json:"platform,omitempty"`
+	// PulledFrom is the reference a platform was pulled from, a mirror or
+	// the source
+	PulledFrom string `json:"pulled_from,omitempty"`
+	// Tag is the local tag of a saved or pushed platform
+	Tag    string `json:"tag,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	// PreviousDigest is the digest a changed source had when its task
+	// started, and Action what the task did about the change
+	PreviousDigest string `json:"previous_digest,omitempty"`
+	Action         string `json:"action,omitempty"`
+	// Stage is the stage a platform failed in
+	Stage        string   `json:"stage,omitempty"`
+	ManifestList string   `json:"manifest_list,omitempty"`
+	Images       []string `json:"images,omitempty"`
+	// DiskUsage is the disk usage of the daemon that made a platform wait
+	// for it to drop below DiskLimit, and Pruned the local tags removed
+	DiskUsage int64    `json:"disk_usage,omitempty"`
+	DiskLimit int64    `json:"disk_limit,omitempty"`
+	Pruned    []string `json:"pruned,omitempty"`
+	// Status is the outcome of a completed task: success, partial or failed
+	Status          string           `json:"status,omitempty"`
+	DurationSeconds float64          `json:"duration_seconds,omitempty"`
+	PausedSeconds   float64          `json:"paused_seconds,omitempty"`
+	Error           string           `json:"error,omitempty"`
+	ErrorCategory   errdefs.Category `json:"error_category,omitempty"`
+}
+
+// EventStream writes every notable pipeline event as a line of JSON, for
+// CI systems to follow a run. Progress events are left out.
+type EventStream struct {
+	enc   *json.Encoder
+	runID string
+}
+
+// NewEventStream returns an event handler writing events to w
+func NewEventStream(w io.Writer, runID string) *EventStream {
+	return &EventStream{enc: json.NewEncoder(w), runID: runID}
+}
+
+// HandleEvent writes the event, if it is one the stream carries
+func (s *EventStream) HandleEvent(e docker.Event) {
+	event := Event{Type: e.Type(), Time: time.Now().UTC(), RunID: s.runID}
+	switch e := e.(type) {
+	case docker.TaskStarted:
+		event.Source, event.Target = e.Source, e.Target
+	case docker.PlatformPulled:
+		event.Source, event.Platform, event.PulledFrom = e.Source, e.Platform, e.PulledFrom
+	case docker.PlatformSaved:
+		event.Source, event.Platform, event.Tag = e.Source, e.Platform, e.Tag
+		event.Path, event.Size, event.SHA256 = e.Path, e.Size, e.SHA256
+	case docker.PlatformPushed:
+		event.Source, event.Platform, event.Tag, event.Digest = e.Source, e.Platform, e.Tag, e.Digest
+	case docker.PlatformFailed:
+		event.Source, event.Platform, event.Stage = e.Source, e.Platform, e.Stage
+		event.Error = redact.String(e.Err.Error())
+		event.ErrorCategory = errdefs.CategoryOf(e.Err)
+	case docker.ManifestCreated:
+		event.Source, event.ManifestList, event.Images = e.Source, e.ManifestList, e.Images
+	case docker.SourceChanged:
+		event.Source, event.Digest, event.PreviousDigest, event.Action = e.Source, e.To, e.From, e.Action
+	case docker.DiskWaited:
+		event.Source, event.Platform = e.Source, e.Platform
+		event.DiskUsage, event.DiskLimit, event.Pruned = e.Usage, e.Limit, e.Pruned
+		event.DurationSeconds = e.End.Sub(e.Start).Seconds()
+		if e.Err != nil {
+			event.Error = redact.String(e.Err.Error())
+			event.ErrorCategory = errdefs.CategoryOf(e.Err)
+		}
+	case docker.TaskCompleted:
+		if e.Result == nil {
+			return
+		}
+		event.Source, event.Target = e.Result.Source, e.Result.Target
+		event.Status = Status(e.Result, e.Err)
+		event.DurationSeconds = time.Duration(e.Result.Duration).Seconds()
+		event.PausedSeconds = time.Duration(e.Result.Paused).Seconds()
+		if e.Err != nil {
+			event.Error = redact.String(e.Err.Error())
+			event.ErrorCategory = errdefs.CategoryOf(e.Err)
+		}
+	default:
+		return
+	}
+	// A failed write can't be reported on the stream itself, and must not
+	// stop the run
+	_ = s.enc.Encode(event)
+}
@@ -0,0 +1,88 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// historyPrefix and historySuffix frame the names of the reports in a
+// history directory
+const (
+	historyPrefix = "report-"
+	historySuffix = ".json"
+)
+
+// WriteHistory writes the report to dir, named after the time the run
+// started and its run ID, such as report-20261016T020000Z-3f9a0c1e.json,
+// and removes the oldest reports beyond the keep newest; keep 0 keeps
+// all of them. It returns the path written.
+func (r *Report) WriteHistory(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating report history: %v", err)
+	}
+	name := historyPrefix + r.StartedAt.UTC().Format("20060102T150405Z")
+	if r.RunID != "" {
+		name += "-" + r.RunID
+	}
+	path := filepath.Join(dir, name+historySuffix)
+
+	// The report is renamed into place, so History never reads half of it
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := r.WriteFile(tmp); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("error writing report: %v", err)
+	}
+
+	if keep <= 0 {
+		return path, nil
+	}
+	reports, err := History(dir)
+	if err != nil {
+		return path, err
+	}
+	for len(reports) > keep {
+		if err := os.Remove(reports[0]); err != nil {
+			return path, fmt.Errorf("error removing old report: %v", err)
+		}
+		reports = reports[1:]
+	}
+	return path, nil
+}
+
+// History returns the reports of a history directory, oldest first
+func History(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report history: %v", err)
+	}
+	var reports []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, historyPrefix) && strings.HasSuffix(name, historySuffix) {
+			reports = append(reports, filepath.Join(dir, name))
+		}
+	}
+	// The names start with the time of the run, so they sort by it
+	sort.Strings(reports)
+	return reports, nil
+}
+
+// ReadFile reads a report written by WriteFile
+func ReadFile(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %v", err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error parsing report %s: %v", path, err)
+	}
+	return &r, nil
+}
@@ -0,0 +1,628 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/errdefs"
+	"github.com/Fr000g/ImgMigrate/pkg/eta"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+)
+
+// Task statuses used in the report. A deferred task, or one with deferred
+// platforms, left work to a later run because the transfer budget was used
+// up; it didn't fail.
+const (
+	StatusSuccess  = "success"
+	StatusPartial  = "partial"
+	StatusFailed   = "failed"
+	StatusDeferred = "deferred"
+)
+
+// Report collects the results of all tasks in a run
+type Report struct {
+	// RunID identifies the run in local tags and the log
+	RunID     string          `json:"run_id,omitempty"`
+	StartedAt time.Time       `json:"started_at"`
+	Duration  docker.Duration `json:"duration_seconds"`
+	Tasks     []TaskReport    `json:"tasks"`
+	// LogFile is the path of the detailed log written during the run, if any
+	LogFile string `json:"log_file,omitempty"`
+	// Pruned lists the stale tags deleted from target repositories
+	Pruned []PrunedTag `json:"pruned,omitempty"`
+	// Stored sums up the images added to shared blob stores
+	Stored *StoreSavings `json:"stored,omitempty"`
+	// Storage sums up the blobs the tasks stored in target registries
+	Storage *docker.StorageUsage `json:"storage,omitempty"`
+	// RolledBack lists how the tags failed tasks pushed were rolled back
+	RolledBack []RollbackAction `json:"rolled_back,omitempty"`
+	// ETA compares the estimates of the end of the run made as it went
+	// with its actual end
+	ETA *eta.Accuracy `json:"eta,omitempty"`
+	// Pauses lists the periods the run waited for its transfer window to
+	// open or for disk space on the daemon, and Paused their total, which
+	// Duration includes
+	Pauses []Pause         `json:"pauses,omitempty"`
+	Paused docker.Duration `json:"paused_seconds,omitempty"`
+	// Budget records the bytes the run transferred against its transfer
+	// budget, if it had one
+	Budget *TransferBudget `json:"transfer_budget,omitempty"`
+	// Lineage traces the images of tasks taking their source from another
+	// task across the hops that copied them
+	Lineage []Lineage `json:"lineage,omitempty"`
+
+	// errs holds the task and platform errors of the run
+	errs []error
+}
+
+// TaskReport is the outcome of a single task in the report
+type TaskReport struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// ErrorCategory classifies the task error, or the first classified
+	// platform error when the task itself didn't fail
+	ErrorCategory errdefs.Category   `json:"error_category,omitempty"`
+	Result        *docker.TaskResult `json:"result,omitempty"`
+}
+
+// PrunedTag records a tag deleted from a target repository, or one that
+// would have been deleted in a dry run
+type PrunedTag struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+	Reason string `json:"reason"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// Reasons the run paused
+const (
+	// PauseWindow is a wait for the transfer window to open
+	PauseWindow = "window"
+	// PauseDisk is a wait for the disk usage of the daemon to drop below
+	// its limit
+	PauseDisk = "daemon_disk"
+)
+
+// Pause records a period the run waited before the task or platform
+// Before, for the Reason given
+type Pause struct {
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Duration docker.Duration `json:"duration_seconds"`
+	Before   string          `json:"before"`
+	Reason   string          `json:"reason"`
+}
+
+// TransferBudget records the bytes a run pulled and pushed, Used, against
+// the Limit of its transfer budget
+type TransferBudget struct {
+	Limit int64 `json:"limit"`
+	Used  int64 `json:"used"`
+}
+
+// Lineage lists the hops that copied the image of Task, starting with the
+// first task of the chain and ending with Task
+type Lineage struct {
+	Task string `json:"task"`
+	Hops []Hop  `json:"hops"`
+}
+
+// Hop records the digest a task copied from its source and the digest it
+// left its target at
+type Hop struct {
+	Task         string `json:"task"`
+	Source       string `json:"source"`
+	SourceDigest string `json:"source_digest,omitempty"`
+	Target       string `json:"target"`
+	TargetDigest string `json:"target_digest,omitempty"`
+}
+
+// Rollback actions
+const (
+	RollbackDeleted   = "deleted"
+	RollbackRestored  = "restored"
+	RollbackUnchanged = "unchanged"
+	RollbackFailed    = "failed"
+)
+
+// RollbackAction records how a tag a failed task pushed was rolled back:
+// deleted, restored to the manifest it pointed to before, left unchanged
+// because it already pointed there, or failed with the reason in Error
+type RollbackAction struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	// Previous is the digest a restored tag points to again
+	Previous string `json:"previous,omitempty"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StoreSavings compares the blobs images added to shared blob stores with
+// the per-image archives they would have been saved to otherwise
+type StoreSavings struct {
+	Images      int   `json:"images"`
+	ArchiveSize int64 `json:"archive_size"`
+	AddedSize   int64 `json:"added_size"`
+	SavedSize   int64 `json:"saved_size"`
+}
+
+// New creates a report for a run starting now
+func New() *Report {
+	return &Report{StartedAt: time.Now()}
+}
+
+// Status returns the status of a task that finished with result and err
+func Status(result *docker.TaskResult, err error) string {
+	switch {
+	case err != nil:
+		return StatusFailed
+	case result != nil && result.Failed() == len(result.Platforms) && len(result.Platforms) > 0:
+		return StatusFailed
+	case result != nil && result.Failed() > 0:
+		return StatusPartial
+	case result != nil && result.Deferred() > 0:
+		return StatusDeferred
+	}
+	return StatusSuccess
+}
+
+// Add records the outcome of a task
+func (r *Report) Add(name string, result *docker.TaskResult, err error) {
+	task := TaskReport{Name: name, Status: Status(result, err), Result: result}
+	if err != nil {
+		task.Error = redact.String(err.Error())
+		r.errs = append(r.errs, err)
+	}
+
+	task.ErrorCategory = errdefs.CategoryOf(err)
+	if task.ErrorCategory == "" && result != nil {
+		for _, platform := range result.Platforms {
+			if platform.ErrorCategory != "" {
+				task.ErrorCategory = platform.ErrorCategory
+				break
+			}
+		}
+	}
+	r.Tasks = append(r.Tasks, task)
+}
+
+// AddDeferred records a task deferred before it started
+func (r *Report) AddDeferred(name string) {
+	r.Tasks = append(r.Tasks, TaskReport{Name: name, Status: StatusDeferred})
+}
+
+// HandleEvent records every completed task, so the report can be registered
+// directly as a client event handler
+func (r *Report) HandleEvent(e docker.Event) {
+	if failed, ok := e.(docker.PlatformFailed); ok {
+		r.errs = append(r.errs, failed.Err)
+		return
+	}
+	if wait, ok := e.(docker.DiskWaited); ok {
+		r.AddPause(PauseDisk, wait.Source+" "+wait.Platform, wait.Start, wait.End)
+		return
+	}
+
+	done, ok := e.(docker.TaskCompleted)
+	if !ok || done.Result == nil {
+		return
+	}
+
+	name := done.Result.Source
+	if done.Result.Target != "" {
+		name = fmt.Sprintf("%s -> %s", done.Result.Source, done.Result.Target)
+	}
+	r.Add(name, done.Result, done.Err)
+}
+
+// AddLineage records the hops that copied the image of a task
+func (r *Report) AddLineage(lineage Lineage) {
+	r.Lineage = append(r.Lineage, lineage)
+}
+
+// AddRollback records a rollback action
+func (r *Report) AddRollback(action RollbackAction) {
+	r.RolledBack = append(r.RolledBack, action)
+}
+
+// AddPruned records a pruned tag
+func (r *Report) AddPruned(tag PrunedTag) {
+	r.Pruned = append(r.Pruned, tag)
+}
+
+// AddPause records that the run waited from start to end before before,
+// for reason
+func (r *Report) AddPause(reason, before string, start, end time.Time) {
+	r.Pauses = append(r.Pauses, Pause{Start: start, End: end, Duration: docker.Duration(end.Sub(start)), Before: before, Reason: reason})
+	r.Paused += docker.Duration(end.Sub(start))
+}
+
+// Reset clears the recorded tasks and restarts the run clock
+func (r *Report) Reset() {
+	r.StartedAt = time.Now()
+	r.Duration = 0
+	r.Tasks = nil
+	r.Pruned = nil
+	r.Stored = nil
+	r.Storage = nil
+	r.RolledBack = nil
+	r.Pauses = nil
+	r.Paused = 0
+	r.Lineage = nil
+	r.errs = nil
+}
+
+// Errors returns the task and platform errors recorded during the run
+func (r *Report) Errors() []error {
+	return r.errs
+}
+
+// Failed returns the number of tasks that did not fully succeed, deferred
+// tasks aside
+func (r *Report) Failed() int {
+	failed := 0
+	for _, task := range r.Tasks {
+		if task.Status != StatusSuccess && task.Status != StatusDeferred {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Deferred returns the number of deferred tasks
+func (r *Report) Deferred() int {
+	deferred := 0
+	for _, task := range r.Tasks {
+		if task.Status == StatusDeferred {
+			deferred++
+		}
+	}
+	return deferred
+}
+
+// Finish records the total duration of the run and sums up the images
+// added to blob stores and the blobs stored in target registries
+func (r *Report) Finish() {
+	r.Duration = docker.Duration(time.Since(r.StartedAt))
+	r.Stored = r.storeSavings()
+	r.Storage = r.storage()
+}
+
+// storage sums up the blobs stored in target registries, failed tasks
+// included, or returns nil if nothing was pushed
+func (r *Report) storage() *docker.StorageUsage {
+	var storage *docker.StorageUsage
+	for _, task := range r.Tasks {
+		if task.Result == nil || task.Result.Storage == nil {
+			continue
+		}
+		if storage == nil {
+			storage = &docker.StorageUsage{}
+		}
+		storage.Add(*task.Result.Storage)
+	}
+	return storage
+}
+
+// storeSavings sums up the images added to blob stores, or returns nil if
+// there are none
+func (r *Report) storeSavings() *StoreSavings {
+	var savings StoreSavings
+	for _, task := range r.Tasks {
+		if task.Result == nil {
+			continue
+		}
+		for _, platform := range task.Result.Platforms {
+			if platform.Stored == nil {
+				continue
+			}
+			savings.Images++
+			savings.ArchiveSize += platform.Stored.ArchiveSize
+			savings.AddedSize += platform.Stored.AddedSize
+		}
+	}
+	if savings.Images == 0 {
+		return nil
+	}
+	savings.SavedSize = max(savings.ArchiveSize-savings.AddedSize, 0)
+	return &savings
+}
+
+// WriteFile writes the report as JSON to path
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling report: %v", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+
+	return nil
+}
+
+// PrintSummary prints a table with the status and per-stage durations of every task
+func (r *Report) PrintSummary(w io.Writer) {
+	stages := []string{docker.StageManifestInspect, docker.StagePull, docker.StageTag,
+		docker.StageSave, docker.StagePush, docker.StageManifestCreate}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TASK\tSTATUS\tPLATFORMS\tDURATION\tINSPECT\tPULL\tTAG\tSAVE\tPUSH\tMANIFEST\n")
+	for _, task := range r.Tasks {
+		platforms := "-"
+		duration := "-"
+		totals := make(map[string]docker.StageResult)
+		if task.Result != nil {
+			platforms = fmt.Sprintf("%d/%d", len(task.Result.Platforms)-task.Result.Failed()-task.Result.Deferred(), len(task.Result.Platforms))
+			duration = task.Result.Duration.String()
+			if task.Result.Paused > 0 {
+				duration += fmt.Sprintf(" (%s paused)", task.Result.Paused)
+			}
+			for _, total := range task.Result.StageTotals() {
+				totals[total.Stage] = total
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s", task.Name, task.Status, platforms, duration)
+		for _, stage := range stages {
+			if total, ok := totals[stage]; ok {
+				fmt.Fprintf(tw, "\t%s", total.Duration)
+			} else {
+				fmt.Fprintf(tw, "\t-")
+			}
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "%d tasks, %d failed", len(r.Tasks), r.Failed())
+	if deferred := r.Deferred(); deferred > 0 {
+		fmt.Fprintf(w, ", %d deferred", deferred)
+	}
+	fmt.Fprintf(w, ", total time %s", r.Duration)
+	if r.Paused > 0 {
+		fmt.Fprintf(w, ", of which %s paused in %s", r.Paused, r.pauses())
+	}
+	fmt.Fprintln(w)
+	for _, task := range r.Tasks {
+		if task.Result == nil {
+			continue
+		}
+		if task.Result.UnmetRequirement != "" {
+			fmt.Fprintf(w, "%s failed its platform requirement, %s\n", task.Name, task.Result.UnmetRequirement)
+		}
+		for _, change := range task.Result.SourceChanges {
+			fmt.Fprintf(w, "The source of %s changed from %s to %s during the task (%s)\n", task.Name, change.From, change.To, change.Action)
+		}
+	}
+	for _, lineage := range r.Lineage {
+		fmt.Fprintf(w, "Lineage of %s:\n", lineage.Task)
+		for _, hop := range lineage.Hops {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", hop.Task, pinned(hop.Source, hop.SourceDigest), pinned(hop.Target, hop.TargetDigest))
+		}
+	}
+	if reused := r.reused(); reused > 0 {
+		fmt.Fprintf(w, "Reused %d platforms pulled by earlier tasks\n", reused)
+	}
+	if compressed := r.autoCompressed(); compressed != "" {
+		fmt.Fprintf(w, "Compressed archives automatically: %s\n", compressed)
+	}
+	if len(r.Pruned) > 0 {
+		verb := "Pruned"
+		if r.Pruned[0].DryRun {
+			verb = "Would prune"
+		}
+		fmt.Fprintf(w, "%s %d stale tags\n", verb, len(r.Pruned))
+	}
+	if len(r.RolledBack) > 0 {
+		failed := 0
+		for _, action := range r.RolledBack {
+			if action.Action == RollbackFailed {
+				failed++
+			}
+		}
+		fmt.Fprintf(w, "Rolled back %d tags pushed by failed tasks", len(r.RolledBack)-failed)
+		if failed > 0 {
+			fmt.Fprintf(w, ", %d could not be rolled back:", failed)
+		}
+		fmt.Fprintln(w)
+		for _, action := range r.RolledBack {
+			if action.Action == RollbackFailed {
+				fmt.Fprintf(w, "  %s: %s\n", action.Image, action.Error)
+			}
+		}
+	}
+	if r.Stored != nil {
+		fmt.Fprintf(w, "Stored %d images in blob stores: %s of new blobs instead of %s of per-image archives, %s saved\n",
+			r.Stored.Images, progress.FormatBytes(r.Stored.AddedSize), progress.FormatBytes(r.Stored.ArchiveSize),
+			progress.FormatBytes(r.Stored.SavedSize))
+	}
+	if r.Storage != nil {
+		fmt.Fprintf(w, "Stored %s of new blobs in target registries, of %s pushed%s\n",
+			progress.FormatBytes(r.Storage.NewBytes), progress.FormatBytes(r.Storage.TotalBytes), estimated(*r.Storage))
+	}
+	if r.Budget != nil {
+		fmt.Fprintf(w, "Transferred %s of the %s transfer budget", progress.FormatBytes(r.Budget.Used), progress.FormatBytes(r.Budget.Limit))
+		if deferred := r.Deferred(); deferred > 0 {
+			fmt.Fprintf(w, ", %d tasks deferred to a later run", deferred)
+		}
+		fmt.Fprintln(w)
+	}
+	if r.ETA != nil {
+		fmt.Fprintf(w, "Estimates of the end of the run were off by %s on average (%.0f%% of the time left), over %d estimates\n",
+			r.ETA.MeanError, r.ETA.MeanRelativeError*100, r.ETA.Predictions)
+	}
+	if categories := r.categoryCounts(); categories != "" {
+		fmt.Fprintf(w, "Failures by category: %s\n", categories)
+	}
+	if r.RunID != "" {
+		fmt.Fprintf(w, "Run ID: %s\n", r.RunID)
+	}
+	if r.LogFile != "" {
+		fmt.Fprintf(w, "Detailed log: %s\n", r.LogFile)
+	}
+}
+
+// pinned formats an image with the digest it pointed to, if known
+func pinned(image, digest string) string {
+	if digest == "" {
+		return image
+	}
+	return image + "@" + digest
+}
+
+// reused counts the platforms taken from an earlier task instead of pulled
+func (r *Report) reused() int {
+	reused := 0
+	for _, task := range r.Tasks {
+		if task.Result == nil {
+			continue
+		}
+		for _, platform := range task.Result.Platforms {
+			if platform.ReusedFrom != "" {
+				reused++
+			}
+		}
+	}
+	return reused
+}
+
+// pauses formats the number of pauses by reason, such as "3 waits for the
+// transfer window and 1 for disk space on the daemon"
+func (r *Report) pauses() string {
+	window, disk := 0, 0
+	for _, pause := range r.Pauses {
+		if pause.Reason == PauseDisk {
+			disk++
+		} else {
+			window++
+		}
+	}
+	switch {
+	case disk == 0:
+		return fmt.Sprintf("%d waits for the transfer window", window)
+	case window == 0:
+		return fmt.Sprintf("%d waits for disk space on the daemon", disk)
+	}
+	return fmt.Sprintf("%d waits for the transfer window and %d for disk space on the daemon", window, disk)
+}
+
+// autoCompressed formats the number of archives the auto compression
+// compressed with each compression, and the average ratio of each
+func (r *Report) autoCompressed() string {
+	counts := make(map[archive.Compression]int)
+	ratios := make(map[archive.Compression]float64)
+	pinned := 0
+	for _, task := range r.Tasks {
+		if task.Result == nil {
+			continue
+		}
+		for _, platform := range task.Result.Platforms {
+			if choice := platform.Compression; choice != nil {
+				counts[choice.Compression]++
+				ratios[choice.Compression] += choice.Ratio
+				if choice.Pinned {
+					pinned++
+				}
+			}
+		}
+	}
+	var parts []string
+	for _, compression := range []archive.Compression{archive.Gzip, archive.Zstd} {
+		if n := counts[compression]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d with %s (%.0f%% of their size)", n, compression, ratios[compression]/float64(n)*100))
+		}
+	}
+	summary := strings.Join(parts, ", ")
+	if pinned > 0 {
+		summary += fmt.Sprintf("; %d kept the compression of an earlier run", pinned)
+	}
+	return summary
+}
+
+// categoryCounts formats the number of failed tasks per error category
+func (r *Report) categoryCounts() string {
+	counts := make(map[errdefs.Category]int)
+	var order []errdefs.Category
+	for _, task := range r.Tasks {
+		if task.Status == StatusSuccess || task.ErrorCategory == "" {
+			continue
+		}
+		if counts[task.ErrorCategory] == 0 {
+			order = append(order, task.ErrorCategory)
+		}
+		counts[task.ErrorCategory]++
+	}
+
+	var parts []string
+	for _, category := range order {
+		parts = append(parts, fmt.Sprintf("%s=%d", category, counts[category]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PrintTaskTotals prints the time and throughput of each stage of a task
+func PrintTaskTotals(w io.Writer, result *docker.TaskResult) {
+	if result == nil {
+		return
+	}
+
+	if result.BandwidthLimit > 0 {
+		fmt.Fprintf(w, "Stage totals for %s (%s, limited to %s/s):\n", result.Source, result.Duration, FormatBytes(result.BandwidthLimit))
+	} else {
+		fmt.Fprintf(w, "Stage totals for %s (%s):\n", result.Source, result.Duration)
+	}
+	for _, total := range result.StageTotals() {
+		if total.Bytes > 0 {
+			fmt.Fprintf(w, "  %-16s %10s  %10s  %s\n", total.Stage, total.Duration,
+				FormatBytes(total.Bytes), Throughput(total))
+		} else {
+			fmt.Fprintf(w, "  %-16s %10s\n", total.Stage, total.Duration)
+		}
+	}
+	if result.Storage != nil {
+		fmt.Fprintf(w, "  Stored %s of new blobs in the target, of %s pushed%s\n", FormatBytes(result.Storage.NewBytes),
+			FormatBytes(result.Storage.TotalBytes), estimated(*result.Storage))
+	}
+}
+
+// estimated notes storage sizes estimated from docker push
+func estimated(storage docker.StorageUsage) string {
+	if storage.Estimated {
+		return " (estimated from the uncompressed layer sizes docker push reports)"
+	}
+	return ""
+}
+
+// Throughput formats the effective throughput of a stage
+func Throughput(stage docker.StageResult) string {
+	seconds := time.Duration(stage.Duration).Seconds()
+	if seconds <= 0 || stage.Bytes == 0 {
+		return "-"
+	}
+	return FormatBytes(int64(float64(stage.Bytes)/seconds)) + "/s"
+}
+
+// FormatBytes formats a byte count with a binary unit suffix
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
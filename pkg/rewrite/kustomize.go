@@ -0,0 +1,73 @@
+package rewrite
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KustomizeImage is an entry of the images field of a kustomization
+type KustomizeImage struct {
+	Name    string `yaml:"name"`
+	NewName string `yaml:"newName,omitempty"`
+	NewTag  string `yaml:"newTag,omitempty"`
+	Digest  string `yaml:"digest,omitempty"`
+}
+
+// Kustomize returns the images entries of a kustomization that point the
+// images at their mirrors; mirrors maps every image as the manifests write
+// it to its mirror. Kustomize matches images by name alone, so when the
+// tags of one name mirror to different digests only the name is replaced,
+// and when they mirror to different repositories or tags the name is left
+// out. Both are returned as warnings.
+func Kustomize(mirrors map[string]string) ([]KustomizeImage, []string) {
+	byName := make(map[string][]mirrored)
+	for image, mirror := range mirrors {
+		name, tag, _ := split(image)
+		newName, newTag, digest := split(mirror)
+		byName[name] = append(byName[name], mirrored{tag: tag, name: newName, newTag: newTag, digest: digest})
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var images []KustomizeImage
+	var warnings []string
+	for _, name := range names {
+		all := byName[name]
+		first := all[0]
+		sameName, identical, keepsTags := true, true, true
+		for _, m := range all {
+			sameName = sameName && m.name == first.name
+			identical = identical && m.newTag == first.newTag && m.digest == first.digest
+			keepsTags = keepsTags && m.newTag == m.tag
+		}
+		switch {
+		case sameName && identical:
+			images = append(images, KustomizeImage{Name: name, NewName: first.name, NewTag: first.newTag, Digest: first.digest})
+		case sameName && keepsTags:
+			images = append(images, KustomizeImage{Name: name, NewName: first.name})
+			warnings = append(warnings, fmt.Sprintf("the tags of %s mirror to different digests, so only its name is replaced and the tags aren't pinned", name))
+		default:
+			warnings = append(warnings, fmt.Sprintf("the tags of %s mirror to different repositories or tags, which kustomize can't tell apart; rewrite copies of the manifests instead", name))
+		}
+	}
+	return images, warnings
+}
+
+// mirrored is the mirror of one tag of an image name
+type mirrored struct {
+	tag, name, newTag, digest string
+}
+
+// split splits an image reference into its name, tag and digest
+func split(ref string) (name, tag, digest string) {
+	name, digest, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
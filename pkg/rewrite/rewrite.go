@@ -0,0 +1,159 @@
+// Package rewrite points the container images of Kubernetes manifests at
+// their mirrors. Only the image values are replaced in the text of a
+// manifest, so its formatting and comments are kept as they are.
+package rewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Image is a container image a manifest references
+type Image struct {
+	Reference string
+	// Line is the line of the image in the manifest
+	Line int
+}
+
+// containerLists are the pod spec fields listing containers
+var containerLists = map[string]bool{
+	"containers":          true,
+	"initContainers":      true,
+	"ephemeralContainers": true,
+}
+
+// Images returns the images the containers of every document of a manifest
+// reference, in the order they appear. Pod specs are found at any depth, so
+// Deployments, CronJobs and lists of resources all work.
+func Images(data []byte) ([]Image, error) {
+	nodes, err := imageNodes(data)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]Image, len(nodes))
+	for i, node := range nodes {
+		images[i] = Image{Reference: node.Value, Line: node.Line}
+	}
+	return images, nil
+}
+
+// Rewrite returns the manifest with every image that mapping maps replaced
+// by its mirror, keeping the rest of the text and the quotes around the
+// image as they are. It also returns the images mapping left unmapped.
+func Rewrite(data []byte, mapping func(image string) (string, bool)) ([]byte, []Image, error) {
+	nodes, err := imageNodes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines := lineOffsets(data)
+	type edit struct {
+		start, end int
+		value      string
+	}
+	var edits []edit
+	var unmapped []Image
+	for _, node := range nodes {
+		mirror, ok := mapping(node.Value)
+		if !ok {
+			unmapped = append(unmapped, Image{Reference: node.Value, Line: node.Line})
+			continue
+		}
+		start, end, err := scalarSpan(data, lines, node)
+		if err != nil {
+			return nil, nil, err
+		}
+		edits = append(edits, edit{start: start, end: end, value: mirror})
+	}
+
+	// Apply the edits from the end, so the offsets of the earlier ones hold
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := bytes.Clone(data)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(e.value), out[e.end:]...)...)
+	}
+	return out, unmapped, nil
+}
+
+// imageNodes returns the scalar nodes holding the image of a container
+func imageNodes(data []byte) ([]*yaml.Node, error) {
+	var nodes []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, findImages(&doc, false)...)
+	}
+	return nodes, nil
+}
+
+// findImages walks a node for the image fields of containers; inList is set
+// for the items of a container list
+func findImages(node *yaml.Node, inList bool) []*yaml.Node {
+	var found []*yaml.Node
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			found = append(found, findImages(child, false)...)
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			found = append(found, findImages(child, inList)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if inList && key.Value == "image" && value.Kind == yaml.ScalarNode && value.Value != "" {
+				found = append(found, value)
+				continue
+			}
+			found = append(found, findImages(value, containerLists[key.Value] && value.Kind == yaml.SequenceNode)...)
+		}
+	}
+	return found
+}
+
+// lineOffsets returns the offset of the start of every line
+func lineOffsets(data []byte) []int {
+	offsets := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// scalarSpan returns where the value of a scalar node is written, without
+// the quotes around it
+func scalarSpan(data []byte, lines []int, node *yaml.Node) (int, int, error) {
+	if node.Line < 1 || node.Line > len(lines) {
+		return 0, 0, fmt.Errorf("line %d: image %s is out of the manifest", node.Line, node.Value)
+	}
+	// Columns count characters
+	start := lines[node.Line-1]
+	for column := 1; column < node.Column && start < len(data); column++ {
+		_, size := utf8.DecodeRune(data[start:])
+		start += size
+	}
+	if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+		start++
+	}
+	if !strings.HasPrefix(string(data[start:]), node.Value) {
+		return 0, 0, fmt.Errorf("line %d: image %s is written in a form that can't be rewritten in place", node.Line, node.Value)
+	}
+	return start, start + len(node.Value), nil
+}
@@ -0,0 +1,207 @@
+package runs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// checksumFile is the checksum list the tool writes next to its archives,
+// see archive.ChecksumFile
+const checksumFile = "SHA256SUMS"
+
+// tempPrefix starts the names of the temporary files the tool writes
+const tempPrefix = ".imgmigrate-"
+
+// Garbage is what the tool created in a directory before a cutoff
+type Garbage struct {
+	Dir string
+	// Archives lists the names of the archives only runs before the cutoff
+	// wrote, which weren't modified since
+	Archives []string
+	// Temp lists the names of temporary files left behind by runs that
+	// were interrupted before the cutoff
+	Temp []string
+	// Tags lists the local tags kept by runs before the cutoff
+	Tags []string
+	// Size is the size of the archives and temporary files
+	Size int64
+	// Skipped explains the archives listed by runs before the cutoff that
+	// are kept because they can't be attributed to the tool anymore
+	Skipped []string
+
+	runs   []Run
+	cutoff time.Time
+	// last is when the newest run writing each archive started
+	last map[string]time.Time
+}
+
+// Collect finds what the runs recorded in the index of dir created before
+// cutoff. An archive is only collected when every run that wrote it started
+// before the cutoff, SHA256SUMS lists it and it wasn't modified since.
+func Collect(dir string, cutoff time.Time) (*Garbage, error) {
+	list, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	g := &Garbage{Dir: dir, runs: list, cutoff: cutoff}
+
+	sums, err := checksums(dir)
+	if err != nil {
+		return nil, err
+	}
+	last := make(map[string]time.Time)
+	g.last = last
+	for _, run := range list {
+		for _, name := range run.Archives {
+			if run.StartedAt.After(last[name]) {
+				last[name] = run.StartedAt
+			}
+		}
+		if run.StartedAt.Before(cutoff) {
+			g.Tags = append(g.Tags, run.Tags...)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, run := range list {
+		for _, name := range run.Archives {
+			if !last[name].Before(cutoff) || seen[name] {
+				continue
+			}
+			seen[name] = true
+			info, err := os.Lstat(filepath.Join(dir, name))
+			switch {
+			case os.IsNotExist(err):
+				continue
+			case err != nil:
+				return nil, err
+			case !info.Mode().IsRegular():
+				g.Skipped = append(g.Skipped, fmt.Sprintf("%s is not a regular file", name))
+			case !sums[name]:
+				g.Skipped = append(g.Skipped, fmt.Sprintf("%s is not listed in %s", name, checksumFile))
+			case !info.ModTime().Before(cutoff):
+				g.Skipped = append(g.Skipped, fmt.Sprintf("%s was modified after the cutoff", name))
+			default:
+				g.Archives = append(g.Archives, name)
+				g.Size += info.Size()
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), tempPrefix) || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		g.Temp = append(g.Temp, entry.Name())
+		g.Size += info.Size()
+	}
+	return g, nil
+}
+
+// Empty reports whether there is nothing to remove
+func (g *Garbage) Empty() bool {
+	return len(g.Archives) == 0 && len(g.Temp) == 0 && len(g.Tags) == 0
+}
+
+// Remove deletes the archives and temporary files, then compacts the run
+// index and SHA256SUMS of the directory: archives that no longer exist are
+// dropped from both, runs before the cutoff drop the archives newer runs
+// wrote again, and those left with nothing are dropped. kept lists the tags that couldn't be removed, which stay
+// recorded.
+func (g *Garbage) Remove(kept []string) error {
+	for _, name := range append(slices.Clone(g.Archives), g.Temp...) {
+		if err := os.Remove(filepath.Join(g.Dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(g.Dir, name))
+		return err == nil
+	}
+	var list []Run
+	for _, run := range g.runs {
+		run.Archives = slices.DeleteFunc(run.Archives, func(name string) bool { return !exists(name) })
+		if run.StartedAt.Before(g.cutoff) {
+			// Archives a newer run wrote again belong to that run
+			run.Archives = slices.DeleteFunc(run.Archives, func(name string) bool { return g.last[name].After(run.StartedAt) })
+			run.Tags = slices.DeleteFunc(run.Tags, func(tag string) bool { return !slices.Contains(kept, tag) })
+		}
+		run.Saved = slices.DeleteFunc(run.Saved, func(s Saved) bool { return !slices.Contains(run.Archives, s.Archive) })
+		if run.StartedAt.Before(g.cutoff) && len(run.Archives) == 0 && len(run.Tags) == 0 {
+			continue
+		}
+		list = append(list, run)
+	}
+	if err := Save(g.Dir, list); err != nil {
+		return err
+	}
+	return compactChecksums(g.Dir, exists)
+}
+
+// checksums returns the names of the files SHA256SUMS lists in dir
+func checksums(dir string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	data, err := os.ReadFile(filepath.Join(dir, checksumFile))
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if _, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			names[strings.TrimPrefix(strings.TrimSpace(name), "*")] = true
+		}
+	}
+	return names, nil
+}
+
+// compactChecksums drops the entries of files that no longer exist from
+// the SHA256SUMS of dir, removing it when none are left
+func compactChecksums(dir string, exists func(name string) bool) error {
+	path := filepath.Join(dir, checksumFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		_, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || !exists(strings.TrimPrefix(strings.TrimSpace(name), "*")) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return os.Remove(path)
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPrefix+checksumFile+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,146 @@
+// Package runs records the runs that saved archives to a directory: when
+// they started, the archives they wrote and the local tags they kept, so
+// that what the tool created can later be told apart from everything else.
+package runs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// IndexFile is the name of the index of the runs that saved to a directory
+const IndexFile = "imgmigrate-runs.json"
+
+// Run records what one run created
+type Run struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	// Archives lists the names of the archives the run wrote to the
+	// directory
+	Archives []string `json:"archives,omitempty"`
+	// Tags lists the local tags of saved platforms the run kept with
+	// --keep-intermediate
+	Tags []string `json:"tags,omitempty"`
+	// Saved records the image every archive was saved from and when
+	Saved []Saved `json:"saved,omitempty"`
+}
+
+// Saved records where an archive of a run came from
+type Saved struct {
+	Archive string `json:"archive"`
+	Source  string `json:"source,omitempty"`
+	// SourceDigest is the digest of the image the archive holds as the
+	// source served it
+	SourceDigest string    `json:"source_digest,omitempty"`
+	SavedAt      time.Time `json:"saved_at"`
+}
+
+// Load returns the runs recorded in the index of dir, oldest first, or none
+// if it has no index
+func Load(dir string) ([]Run, error) {
+	path := filepath.Join(dir, IndexFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading run index: %v", err)
+	}
+	var list []Run
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing run index %s: %v", path, err)
+	}
+	return list, nil
+}
+
+// Record adds the archives and tags of run to its entry in the index of
+// dir, creating the entry and the index if needed. The caller serializes
+// updates of the same directory.
+func Record(dir string, run Run) error {
+	list, err := Load(dir)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(list, func(r Run) bool { return r.ID == run.ID })
+	if i < 0 {
+		list = append(list, Run{ID: run.ID, StartedAt: run.StartedAt})
+		i = len(list) - 1
+	}
+	for _, name := range run.Archives {
+		if !slices.Contains(list[i].Archives, name) {
+			list[i].Archives = append(list[i].Archives, name)
+		}
+	}
+	for _, tag := range run.Tags {
+		if !slices.Contains(list[i].Tags, tag) {
+			list[i].Tags = append(list[i].Tags, tag)
+		}
+	}
+	for _, saved := range run.Saved {
+		list[i].Saved = slices.DeleteFunc(list[i].Saved, func(s Saved) bool { return s.Archive == saved.Archive })
+		list[i].Saved = append(list[i].Saved, saved)
+	}
+	return Save(dir, list)
+}
+
+// Find returns the latest record of the archive at path in the index of
+// its directory, or nil when no run recorded it. Runs recorded by older
+// versions only list the archive; it is then taken as saved when the run
+// started, without a source.
+func Find(path string) (*Saved, error) {
+	list, err := Load(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	var found *Saved
+	for _, run := range list {
+		saved := Saved{Archive: name, SavedAt: run.StartedAt}
+		if i := slices.IndexFunc(run.Saved, func(s Saved) bool { return s.Archive == name }); i >= 0 {
+			saved = run.Saved[i]
+		} else if !slices.Contains(run.Archives, name) {
+			continue
+		}
+		if found == nil || !saved.SavedAt.Before(found.SavedAt) {
+			found = &saved
+		}
+	}
+	return found, nil
+}
+
+// Save replaces the index of dir with runs atomically, removing it when
+// no runs are left
+func Save(dir string, list []Run) error {
+	path := filepath.Join(dir, IndexFile)
+	if len(list) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing run index: %v", err)
+		}
+		return nil
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling run index: %v", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".imgmigrate-runs-*")
+	if err != nil {
+		return fmt.Errorf("error writing run index: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing run index: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing run index: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
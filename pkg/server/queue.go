@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// queue holds the IDs of queued tasks for the workers, the highest
+// priority first and in submission order within a priority
+type queue struct {
+	mu    sync.Mutex
+	items []queued
+	// pending holds a token for every item, for workers to wait on
+	pending chan struct{}
+}
+
+// queued is a task ID and its priority
+type queued struct {
+	id       string
+	priority int
+}
+
+// newQueue returns a queue holding up to size tasks
+func newQueue(size int) *queue {
+	return &queue{pending: make(chan struct{}, size)}
+}
+
+// push queues a task, or returns false when the queue is full
+func (q *queue) push(id string, priority int) bool {
+	q.mu.Lock()
+	if len(q.items) == cap(q.pending) {
+		q.mu.Unlock()
+		return false
+	}
+	// Insert after every task of the same or a higher priority
+	at := len(q.items)
+	for i, item := range q.items {
+		if item.priority < priority {
+			at = i
+			break
+		}
+	}
+	q.items = append(q.items, queued{})
+	copy(q.items[at+1:], q.items[at:])
+	q.items[at] = queued{id: id, priority: priority}
+	q.mu.Unlock()
+
+	q.pending <- struct{}{}
+	return true
+}
+
+// pop waits for a task and returns its ID, or returns false once ctx ends
+func (q *queue) pop(ctx context.Context) (string, bool) {
+	select {
+	case <-ctx.Done():
+		return "", false
+	case <-q.pending:
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id := q.items[0].id
+	q.items = q.items[1:]
+	return id, true
+}
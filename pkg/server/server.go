@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/archive"
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/httpfile"
+	"github.com/Fr000g/ImgMigrate/pkg/redact"
+	"gopkg.in/yaml.v3"
+)
+
+// RunFunc executes a single image task
+type RunFunc func(ctx context.Context, task config.ImageTask) (*docker.TaskResult, error)
+
+// Options configures the API server
+type Options struct {
+	Store   *Store
+	Run     RunFunc
+	Workers int
+	Token   string
+	Policy  []*config.PolicyConfig
+	// DataDir is the directory submitted tasks save to and read archives
+	// and layouts from. Their paths are relative to it and can't leave it;
+	// without it, tasks can't name paths or save at all.
+	DataDir string
+}
+
+// Server accepts migration tasks over HTTP and runs them on a worker pool,
+// those of a higher priority first
+type Server struct {
+	opts  Options
+	queue *queue
+	// workers counts the running workers, for Wait
+	workers sync.WaitGroup
+}
+
+// New creates a new API server
+func New(opts Options) (*Server, error) {
+	if opts.Store == nil || opts.Run == nil {
+		return nil, fmt.Errorf("store and run function are required")
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	return &Server{
+		opts:  opts,
+		queue: newQueue(1024),
+	}, nil
+}
+
+// Start launches the workers and re-queues tasks that were queued or running
+// when the server last stopped. Workers exit once ctx is cancelled, which
+// Wait waits for.
+func (s *Server) Start(ctx context.Context) {
+	s.workers.Add(s.opts.Workers)
+	for i := 0; i < s.opts.Workers; i++ {
+		go func() {
+			defer s.workers.Done()
+			s.worker(ctx)
+		}()
+	}
+
+	for _, task := range s.opts.Store.List() {
+		if task.Status != StatusQueued && task.Status != StatusRunning {
+			continue
+		}
+		var spec config.ImageTask
+		if err := yaml.Unmarshal(task.Spec, &spec); err != nil {
+			s.finish(task.ID, nil, fmt.Errorf("invalid task spec: %v", err))
+			continue
+		}
+		s.opts.Store.Update(task.ID, func(t *Task) {
+			t.Status = StatusQueued
+			t.StartedAt = nil
+		})
+		if !s.queue.push(task.ID, spec.Priority) {
+			s.finish(task.ID, nil, fmt.Errorf("task queue is full"))
+		}
+	}
+}
+
+// Wait waits for the workers to exit after the context of Start is
+// cancelled, so the store isn't closed while they write to it
+func (s *Server) Wait() {
+	s.workers.Wait()
+}
+
+// Handler returns the HTTP handler serving the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("POST /tasks", s.authorize(http.HandlerFunc(s.submitTask)))
+	mux.Handle("GET /tasks", s.authorize(http.HandlerFunc(s.listTasks)))
+	mux.Handle("GET /tasks/{id}", s.authorize(http.HandlerFunc(s.getTask)))
+	return mux
+}
+
+// authorize rejects requests without the configured bearer token
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) submitTask(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to read request: %v", err))
+		return
+	}
+
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "request body must be a JSON object")
+		return
+	}
+
+	// The task uses the same schema as an images entry in the config file,
+	// which YAML decoding accepts in its JSON form as well
+	var spec config.ImageTask
+	if err := yaml.Unmarshal(body, &spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task: %v", err))
+		return
+	}
+	if spec.Source == "" {
+		writeError(w, http.StatusBadRequest, "source is required")
+		return
+	}
+	if len(spec.DependsOn) > 0 || spec.SourceFrom != "" {
+		writeError(w, http.StatusBadRequest, "depends_on and source_from name tasks of a configuration file and aren't accepted for submitted tasks")
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task: %v", err))
+		return
+	}
+	if _, err := s.confine(spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task: %v", err))
+		return
+	}
+
+	for _, policy := range s.opts.Policy {
+		if err := policy.CheckTask(spec); err != nil {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("policy violation: %v", err))
+			return
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	task := Task{
+		ID:          id,
+		Source:      spec.Source,
+		Target:      spec.Target,
+		Status:      StatusQueued,
+		SubmittedAt: time.Now().UTC(),
+		Spec:        json.RawMessage(body),
+	}
+	if err := s.opts.Store.Put(task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !s.queue.push(id, spec.Priority) {
+		s.opts.Store.Update(id, func(t *Task) {
+			t.Status = StatusFailed
+			t.Error = "task queue is full"
+		})
+		writeError(w, http.StatusServiceUnavailable, "task queue is full")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, task)
+}
+
+func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.opts.Store.List())
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := s.opts.Store.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// worker runs queued tasks until ctx is cancelled
+func (s *Server) worker(ctx context.Context) {
+	for {
+		id, ok := s.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		s.runTask(ctx, id)
+	}
+}
+
+func (s *Server) runTask(ctx context.Context, id string) {
+	task, ok := s.opts.Store.Get(id)
+	if !ok {
+		return
+	}
+
+	var spec config.ImageTask
+	if err := yaml.Unmarshal(task.Spec, &spec); err != nil {
+		s.finish(id, nil, fmt.Errorf("invalid task spec: %v", err))
+		return
+	}
+	// Tasks queued before a restart are checked against the current data
+	// directory
+	spec, err := s.confine(spec)
+	if err != nil {
+		s.finish(id, nil, err)
+		return
+	}
+
+	started := time.Now().UTC()
+	s.opts.Store.Update(id, func(t *Task) {
+		t.Status = StatusRunning
+		t.StartedAt = &started
+	})
+
+	result, err := s.opts.Run(ctx, spec)
+	if err != nil && ctx.Err() != nil {
+		// The server is stopping, so the task runs again once it restarts
+		s.opts.Store.Update(id, func(t *Task) {
+			t.Status = StatusQueued
+			t.StartedAt = nil
+		})
+		return
+	}
+	s.finish(id, result, err)
+}
+
+func (s *Server) finish(id string, result *docker.TaskResult, err error) {
+	finished := time.Now().UTC()
+	s.opts.Store.Update(id, func(t *Task) {
+		t.FinishedAt = &finished
+		t.Result = result
+		if err != nil {
+			t.Status = StatusFailed
+			t.Error = redact.String(err.Error())
+		} else {
+			t.Status = StatusSucceeded
+		}
+	})
+}
+
+// confine returns the task with the paths it names resolved below the data
+// directory, failing when they would leave it
+func (s *Server) confine(spec config.ImageTask) (config.ImageTask, error) {
+	if s.opts.DataDir == "" {
+		switch {
+		case spec.Save || spec.OutputDir != "" || spec.BlobStore != "":
+			return spec, fmt.Errorf("the server has no data directory, submitted tasks can't save")
+		case len(spec.Archives) > 0 || spec.ReadsLayout():
+			return spec, fmt.Errorf("the server has no data directory, submitted tasks can't read archives or dir: and oci: sources")
+		}
+		return spec, nil
+	}
+
+	var err error
+	if spec.Save && spec.OutputDir == "" {
+		spec.OutputDir = s.opts.DataDir
+	} else if spec.OutputDir != "" {
+		if spec.OutputDir, err = s.dataPath("output_dir", spec.OutputDir); err != nil {
+			return spec, err
+		}
+	}
+	if spec.BlobStore != "" {
+		if spec.BlobStore, err = s.dataPath("blob_store", spec.BlobStore); err != nil {
+			return spec, err
+		}
+	}
+	if len(spec.Archives) > 0 {
+		archives := make([]string, len(spec.Archives))
+		for i, path := range spec.Archives {
+			if archives[i], err = s.dataPath("archives", path); err != nil {
+				return spec, err
+			}
+		}
+		spec.Archives = archives
+	}
+	if spec.ReadsLayout() {
+		source, err := archive.ParseSource(spec.Source)
+		if err != nil {
+			return spec, err
+		}
+		if source.Path, err = s.dataPath("source", source.Path); err != nil {
+			return spec, err
+		}
+		spec.Source = source.String()
+	}
+	return spec, nil
+}
+
+// dataPath resolves a path of a submitted task below the data directory
+func (s *Server) dataPath(field, path string) (string, error) {
+	if httpfile.IsURL(path) || !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%s %s must be a path relative to the data directory of the server", field, path)
+	}
+	return filepath.Join(s.opts.DataDir, path), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate task id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": redact.String(message)})
+}
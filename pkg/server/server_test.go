@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/config"
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// newTestServer creates a server on a store in a temporary directory,
+// which is closed once the test ends
+func newTestServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	opts.Store = store
+	if opts.Run == nil {
+		opts.Run = func(ctx context.Context, task config.ImageTask) (*docker.TaskResult, error) {
+			return nil, nil
+		}
+	}
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSubmitTask(t *testing.T) {
+	dataDir := t.TempDir()
+	s := newTestServer(t, Options{
+		Token:   "secret",
+		Policy:  []*config.PolicyConfig{{AllowedTargetRegistries: []string{"registry.example.com"}, DenyLatestTargets: true}},
+		DataDir: dataDir,
+	})
+	handler := s.Handler()
+
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		status int
+	}{
+		{"no token", "", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`, http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`, http.StatusUnauthorized},
+		{"token without scheme", "secret", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`, http.StatusUnauthorized},
+		{"basic scheme", "Basic secret", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`, http.StatusUnauthorized},
+		{"not JSON", "Bearer secret", `source: nginx:1.25`, http.StatusBadRequest},
+		{"no source", "Bearer secret", `{"target": "registry.example.com/nginx:1.25"}`, http.StatusBadRequest},
+		{"depends_on", "Bearer secret", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25", "depends_on": ["base"]}`, http.StatusBadRequest},
+		{"output_dir outside", "Bearer secret", `{"source": "nginx:1.25", "save": true, "output_dir": "../archives"}`, http.StatusBadRequest},
+		{"archive absolute", "Bearer secret", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25", "archives": ["/etc/passwd"]}`, http.StatusBadRequest},
+		{"layout outside", "Bearer secret", `{"source": "oci:../layout", "target": "registry.example.com/nginx:1.25"}`, http.StatusBadRequest},
+		{"target registry denied", "Bearer secret", `{"source": "nginx:1.25", "target": "docker.io/team/nginx:1.25"}`, http.StatusForbidden},
+		{"latest target denied", "Bearer secret", `{"source": "nginx:1.25", "target": "registry.example.com/nginx"}`, http.StatusForbidden},
+		{"accepted", "Bearer secret", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`, http.StatusAccepted},
+		{"save accepted", "Bearer secret", `{"source": "nginx:1.25", "save": true, "output_dir": "archives/nginx"}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusAccepted {
+				return
+			}
+
+			var task Task
+			if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+				t.Fatal(err)
+			}
+			stored, ok := s.opts.Store.Get(task.ID)
+			if !ok || stored.Status != StatusQueued || stored.Source != "nginx:1.25" {
+				t.Errorf("stored task %+v, want it queued", stored)
+			}
+		})
+	}
+}
+
+// TestQueueFull fails a submitted task once the queue holds as many as it
+// can
+func TestQueueFull(t *testing.T) {
+	s := newTestServer(t, Options{})
+	s.queue = newQueue(1)
+	handler := s.Handler()
+
+	for i, status := range []int{http.StatusAccepted, http.StatusServiceUnavailable} {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Fatalf("submission %d: status %d, want %d: %s", i+1, rec.Code, status, rec.Body)
+		}
+	}
+	var failed int
+	for _, task := range s.opts.Store.List() {
+		if task.Status == StatusFailed && task.Error == "task queue is full" {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d tasks failed for the full queue, want 1", failed)
+	}
+}
+
+func TestConfine(t *testing.T) {
+	dataDir := filepath.Join("srv", "data")
+	tests := []struct {
+		name    string
+		dataDir string
+		spec    string
+		// want holds the source, output_dir, blob_store and archives of
+		// the confined task
+		want    []string
+		wantErr bool
+	}{
+		{"copy without data directory", "", `{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`, []string{"nginx:1.25", "", "", ""}, false},
+		{"save without data directory", "", `{"source": "nginx:1.25", "save": true}`, nil, true},
+		{"archives without data directory", "", `{"source": "nginx:1.25", "archives": ["nginx.tar"]}`, nil, true},
+		{"layout without data directory", "", `{"source": "dir:layouts/nginx"}`, nil, true},
+		{"save to data directory", dataDir, `{"source": "nginx:1.25", "save": true}`, []string{"nginx:1.25", dataDir, "", ""}, false},
+		{"relative paths", dataDir, `{"source": "oci:layouts/nginx:1.25", "output_dir": "out", "blob_store": "blobs", "archives": ["a/nginx.tar"]}`,
+			[]string{"oci:" + filepath.Join(dataDir, "layouts", "nginx") + ":1.25", filepath.Join(dataDir, "out"), filepath.Join(dataDir, "blobs"), filepath.Join(dataDir, "a", "nginx.tar")}, false},
+		{"parent output_dir", dataDir, `{"source": "nginx:1.25", "output_dir": "../out"}`, nil, true},
+		{"absolute blob_store", dataDir, `{"source": "nginx:1.25", "blob_store": "/var/lib/blobs"}`, nil, true},
+		{"archive leaving through a subdirectory", dataDir, `{"source": "nginx:1.25", "archives": ["a/../../nginx.tar"]}`, nil, true},
+		{"archive URL", dataDir, `{"source": "nginx:1.25", "archives": ["https://files.example.com/nginx.tar"]}`, nil, true},
+		{"parent layout", dataDir, `{"source": "dir:../nginx"}`, nil, true},
+		{"absolute layout", dataDir, `{"source": "oci:/etc/nginx"}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec config.ImageTask
+			if err := yaml.Unmarshal([]byte(tt.spec), &spec); err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{opts: Options{DataDir: tt.dataDir}}
+			got, err := s.confine(spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("confine(%s) = %+v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("confine(%s): %v", tt.spec, err)
+			}
+			fields := []string{got.Source, got.OutputDir, got.BlobStore, strings.Join(got.Archives, ",")}
+			if strings.Join(fields, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("confine(%s) = %q, want %q", tt.spec, fields, tt.want)
+			}
+		})
+	}
+}
+
+// TestRequeue starts a server on a store holding the tasks of a server
+// that stopped, which runs those that were queued or running again
+func TestRequeue(t *testing.T) {
+	ran := make(chan string, 4)
+	s := newTestServer(t, Options{Run: func(ctx context.Context, task config.ImageTask) (*docker.TaskResult, error) {
+		ran <- task.Source
+		return nil, nil
+	}})
+	started := time.Now().UTC()
+	tasks := []Task{
+		{ID: "queued", Status: StatusQueued, Spec: json.RawMessage(`{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`)},
+		{ID: "running", Status: StatusRunning, StartedAt: &started, Spec: json.RawMessage(`{"source": "redis:7", "target": "registry.example.com/redis:7"}`)},
+		{ID: "succeeded", Status: StatusSucceeded, Spec: json.RawMessage(`{"source": "alpine:3.20", "target": "registry.example.com/alpine:3.20"}`)},
+		{ID: "invalid", Status: StatusQueued, Spec: json.RawMessage(`"not a task"`)},
+	}
+	for i, task := range tasks {
+		task.SubmittedAt = started.Add(time.Duration(i) * time.Second)
+		if err := s.opts.Store.Put(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	sources := map[string]bool{}
+	for range 2 {
+		select {
+		case source := <-ran:
+			sources[source] = true
+		case <-time.After(10 * time.Second):
+			t.Fatal("the queued tasks didn't run")
+		}
+	}
+	if !sources["nginx:1.25"] || !sources["redis:7"] {
+		t.Errorf("ran %v, want the queued and running tasks", sources)
+	}
+	// The tasks are finished once a worker asks for the next one
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		queued, _ := s.opts.Store.Get("queued")
+		running, _ := s.opts.Store.Get("running")
+		if queued.Status == StatusSucceeded && running.Status == StatusSucceeded {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	s.Wait()
+
+	want := map[string]TaskStatus{"queued": StatusSucceeded, "running": StatusSucceeded, "succeeded": StatusSucceeded, "invalid": StatusFailed}
+	for id, status := range want {
+		if task, _ := s.opts.Store.Get(id); task.Status != status {
+			t.Errorf("task %s is %s, want %s", id, task.Status, status)
+		}
+	}
+	select {
+	case source := <-ran:
+		t.Errorf("%s ran again", source)
+	default:
+	}
+}
+
+// TestShutdown stops a server while it runs a task, which is queued again
+// for the next start rather than failed
+func TestShutdown(t *testing.T) {
+	running := make(chan struct{})
+	s := newTestServer(t, Options{Run: func(ctx context.Context, task config.ImageTask) (*docker.TaskResult, error) {
+		close(running)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}})
+	err := s.opts.Store.Put(Task{ID: "task", Status: StatusQueued, SubmittedAt: time.Now().UTC(),
+		Spec: json.RawMessage(`{"source": "nginx:1.25", "target": "registry.example.com/nginx:1.25"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	select {
+	case <-running:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the task didn't run")
+	}
+	cancel()
+	s.Wait()
+
+	task, _ := s.opts.Store.Get("task")
+	if task.Status != StatusQueued || task.StartedAt != nil || task.Error != "" {
+		t.Errorf("task %+v, want it queued again", task)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	bolt "go.etcd.io/bbolt"
+)
+
+// TaskStatus is the lifecycle state of a submitted task
+type TaskStatus string
+
+const (
+	StatusQueued    TaskStatus = "queued"
+	StatusRunning   TaskStatus = "running"
+	StatusSucceeded TaskStatus = "succeeded"
+	StatusFailed    TaskStatus = "failed"
+)
+
+// Task is a migration task submitted through the API
+type Task struct {
+	ID          string             `json:"id"`
+	Source      string             `json:"source"`
+	Target      string             `json:"target,omitempty"`
+	Status      TaskStatus         `json:"status"`
+	Error       string             `json:"error,omitempty"`
+	SubmittedAt time.Time          `json:"submitted_at"`
+	StartedAt   *time.Time         `json:"started_at,omitempty"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty"`
+	Spec        json.RawMessage    `json:"spec"`
+	Result      *docker.TaskResult `json:"result,omitempty"`
+}
+
+// tasksBucket holds the tasks as JSON, keyed by their ID
+var tasksBucket = []byte("tasks")
+
+// Store persists tasks in a bolt database so that history survives
+// restarts. Every change is a transaction of its own, so a crash loses at
+// most the change being written.
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens the task store at path, creating it if it doesn't exist.
+// The JSON task state of earlier versions is imported, and kept next to it
+// with the .json suffix.
+func OpenStore(path string) (*Store, error) {
+	legacy, err := readLegacyTasks(path)
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		if err := os.Rename(path, path+".json"); err != nil {
+			return nil, fmt.Errorf("error moving JSON task state aside: %v", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("task state %s is in use by another server", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening task state: %v", err)
+	}
+	store := &Store{db: db}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(tasksBucket)
+		if err != nil {
+			return err
+		}
+		for _, task := range legacy {
+			if err := putTask(bucket, task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing task state: %v", err)
+	}
+	return store, nil
+}
+
+// readLegacyTasks returns the tasks of a JSON task state file, or nil when
+// path doesn't hold one
+func readLegacyTasks(path string) ([]*Task, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading task state: %v", err)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return nil, nil
+	}
+
+	tasks := []*Task{}
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("error parsing JSON task state: %v", err)
+	}
+	return tasks, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get returns the task with the given ID
+func (s *Store) Get(id string) (Task, bool) {
+	var task Task
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tasksBucket).Get([]byte(id))
+		found = data != nil && json.Unmarshal(data, &task) == nil
+		return nil
+	})
+	return task, found
+}
+
+// List returns all tasks ordered by submission time
+func (s *Store) List() []Task {
+	tasks := []Task{}
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).ForEach(func(_, data []byte) error {
+			var task Task
+			if json.Unmarshal(data, &task) == nil {
+				tasks = append(tasks, task)
+			}
+			return nil
+		})
+	})
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].SubmittedAt.Before(tasks[j].SubmittedAt)
+	})
+	return tasks
+}
+
+// Put stores the task
+func (s *Store) Put(task Task) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putTask(tx.Bucket(tasksBucket), &task)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing task state: %v", err)
+	}
+	return nil
+}
+
+// Update applies fn to the stored task within a single transaction
+func (s *Store) Update(id string, fn func(task *Task)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tasksBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("task %s not found", id)
+		}
+		var task Task
+		if err := json.Unmarshal(data, &task); err != nil {
+			return fmt.Errorf("error parsing task %s: %v", id, err)
+		}
+		fn(&task)
+		return putTask(bucket, &task)
+	})
+}
+
+func putTask(bucket *bolt.Bucket, task *Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("error marshaling task %s: %v", task.ID, err)
+	}
+	return bucket.Put([]byte(task.ID), data)
+}
@@ -0,0 +1,233 @@
+// Package state records what has been mirrored, so that later runs can skip
+// sources whose digest hasn't changed.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/imageref"
+)
+
+// Entry records the last successful mirror of a source to a target
+type Entry struct {
+	Source string `json:"source"`
+	Target string `json:"target,omitempty"`
+	// Digest is the manifest list digest of the source when it was mirrored
+	Digest     string    `json:"digest"`
+	Platforms  []string  `json:"platforms,omitempty"`
+	MirroredAt time.Time `json:"mirrored_at"`
+	// SavedAt is when the archive push-archive pushed was saved from the
+	// source, which is older than MirroredAt
+	SavedAt time.Time `json:"saved_at,omitempty"`
+	// Compression records the compression the auto compression picked for
+	// the archive of every platform, which later runs keep
+	Compression map[string]string `json:"compression,omitempty"`
+	// Deferred records work a run left for a later one, which doesn't skip
+	// the source even if its digest is unchanged
+	Deferred *Deferral `json:"deferred,omitempty"`
+}
+
+// Deferral records a task, or some of its platforms, deferred because the
+// transfer budget of a run was used up
+type Deferral struct {
+	// Digest is the source digest when the task was deferred
+	Digest string `json:"digest,omitempty"`
+	// Platforms lists the deferred platforms; it is empty when the whole
+	// task was deferred
+	Platforms  []string  `json:"platforms,omitempty"`
+	DeferredAt time.Time `json:"deferred_at"`
+}
+
+// key identifies the entry of a source and target pair by their canonical
+// references, so every spelling of an image finds the same entry
+func key(source, target string) string {
+	return imageref.Key(source) + " -> " + imageref.Key(target)
+}
+
+// File holds the mirror state in memory and, when it has a path, persists
+// it as JSON
+type File struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// Open loads the state file at path, starting empty if it doesn't exist. An
+// empty path keeps the state in memory only.
+func Open(path string) (*File, error) {
+	f := &File{path: path, entries: make(map[string]Entry)}
+	if path == "" {
+		return f, nil
+	}
+
+	entries, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	f.entries = entries
+	return f, nil
+}
+
+// Get returns the entry recorded for a source and target
+func (f *File) Get(source, target string) (Entry, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, ok := f.entries[key(source, target)]
+	return entry, ok
+}
+
+// Entries returns all recorded entries ordered by source
+func (f *File) Entries() []Entry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := make([]Entry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return key(entries[i].Source, entries[i].Target) < key(entries[j].Source, entries[j].Target)
+	})
+	return entries
+}
+
+// ContentTime returns when the source was read for the content the entry
+// mirrored: when its archive was saved, or when it was mirrored
+func (e Entry) ContentTime() time.Time {
+	if !e.SavedAt.IsZero() {
+		return e.SavedAt
+	}
+	return e.MirroredAt
+}
+
+// Record stores an entry and persists the state. The file is re-read first
+// and an entry only replaces one that was mirrored earlier, so tasks that
+// finish out of order, in this or another process, don't lose updates.
+func (f *File) Record(entry Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.update(func(entries map[string]Entry) {
+		k := key(entry.Source, entry.Target)
+		if existing, ok := entries[k]; ok && existing.MirroredAt.After(entry.MirroredAt) {
+			return
+		}
+		entries[k] = entry
+	})
+}
+
+// Defer marks the entry of a source and target as deferred, creating it if
+// it doesn't exist, and persists the state. Recording the next mirror of the
+// pair clears the mark.
+func (f *File) Defer(source, target string, deferral Deferral) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.update(func(entries map[string]Entry) {
+		k := key(source, target)
+		entry, ok := entries[k]
+		if !ok {
+			entry = Entry{Source: source, Target: target}
+		}
+		entry.Deferred = &deferral
+		entries[k] = entry
+	})
+}
+
+// Forget removes the entries recorded for a target and persists the state
+func (f *File) Forget(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.update(func(entries map[string]Entry) {
+		for k, entry := range entries {
+			if imageref.Key(entry.Target) == imageref.Key(target) {
+				delete(entries, k)
+			}
+		}
+	})
+}
+
+// update merges the file on disk into memory, applies fn and writes the
+// result back; the caller must hold the lock
+func (f *File) update(fn func(entries map[string]Entry)) error {
+	if f.path != "" {
+		onDisk, err := f.load()
+		if err != nil {
+			return err
+		}
+		for k, entry := range onDisk {
+			if existing, ok := f.entries[k]; !ok || entry.MirroredAt.After(existing.MirroredAt) {
+				f.entries[k] = entry
+			}
+		}
+	}
+
+	fn(f.entries)
+
+	if f.path == "" {
+		return nil
+	}
+	return f.save()
+}
+
+// load reads the entries stored at the file's path
+func (f *File) load() (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %v", err)
+	}
+
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %v", f.path, err)
+	}
+	for _, entry := range list {
+		entries[key(entry.Source, entry.Target)] = entry
+	}
+	return entries, nil
+}
+
+// save writes the state atomically; the caller must hold the lock
+func (f *File) save() error {
+	list := make([]Entry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return key(list[i].Source, list[i].Target) < key(list[j].Source, list[j].Target)
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".imgmigrate-state-*")
+	if err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var mirroredAt = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// TestResume reopens the state of a run that mirrored some tasks, deferred
+// one and didn't reach the last, as the next run does
+func TestResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	run, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []error{
+		run.Record(Entry{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", Digest: "sha256:aaa", MirroredAt: mirroredAt}),
+		run.Record(Entry{Source: "redis:7", Target: "registry.example.com/redis:7", Digest: "sha256:bbb", MirroredAt: mirroredAt}),
+		run.Defer("alpine:3.20", "registry.example.com/alpine:3.20", Deferral{Digest: "sha256:ccc", Platforms: []string{"linux/arm64"}, DeferredAt: mirroredAt}),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every spelling of a reference finds the entry
+	if entry, ok := next.Get("docker.io/library/nginx:1.25", "registry.example.com/nginx:1.25"); !ok || entry.Digest != "sha256:aaa" || entry.Deferred != nil {
+		t.Errorf("nginx entry %+v, %v, want it mirrored", entry, ok)
+	}
+	if entry, ok := next.Get("redis:7", "registry.example.com/redis:7"); !ok || entry.Digest != "sha256:bbb" {
+		t.Errorf("redis entry %+v, %v, want it mirrored", entry, ok)
+	}
+	deferred, ok := next.Get("alpine:3.20", "registry.example.com/alpine:3.20")
+	if !ok || deferred.Deferred == nil || deferred.Deferred.Digest != "sha256:ccc" || len(deferred.Deferred.Platforms) != 1 {
+		t.Errorf("alpine entry %+v, %v, want it deferred", deferred, ok)
+	}
+	if entry, ok := next.Get("busybox:1.36", "registry.example.com/busybox:1.36"); ok {
+		t.Errorf("busybox entry %+v, want none", entry)
+	}
+
+	// Mirroring the deferred task clears the deferral
+	if err := next.Record(Entry{Source: "alpine:3.20", Target: "registry.example.com/alpine:3.20", Digest: "sha256:ccc", MirroredAt: mirroredAt.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := reopened.Get("alpine:3.20", "registry.example.com/alpine:3.20"); entry.Deferred != nil {
+		t.Errorf("alpine entry %+v, want the deferral cleared", entry)
+	}
+	if n := len(reopened.Entries()); n != 3 {
+		t.Errorf("%d entries, want 3", n)
+	}
+}
+
+// TestMergeStale records entries through two handles of the same file, as
+// two processes do, the second opened before the first wrote anything
+func TestMergeStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	first, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nginx := func(digest string, at time.Time) Entry {
+		return Entry{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", Digest: digest, MirroredAt: at}
+	}
+	if err := first.Record(nginx("sha256:new", mirroredAt.Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	// An entry of an earlier mirror, recorded late, loses to the newer one
+	if err := stale.Record(nginx("sha256:old", mirroredAt)); err != nil {
+		t.Fatal(err)
+	}
+	// Entries of other pairs are kept from both
+	if err := stale.Record(Entry{Source: "redis:7", Target: "registry.example.com/redis:7", Digest: "sha256:bbb", MirroredAt: mirroredAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, f := range map[string]*File{"stale": stale, "reopened": mustOpen(t, path)} {
+		if entry, _ := f.Get("nginx:1.25", "registry.example.com/nginx:1.25"); entry.Digest != "sha256:new" {
+			t.Errorf("%s: nginx digest %s, want the newer sha256:new", name, entry.Digest)
+		}
+		if _, ok := f.Get("redis:7", "registry.example.com/redis:7"); !ok {
+			t.Errorf("%s: redis entry missing", name)
+		}
+	}
+
+	// The stale handle reads the newer entry from disk before deferring,
+	// so the deferral keeps its digest
+	if err := first.Record(nginx("sha256:newer", mirroredAt.Add(2*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Defer("nginx:1.25", "registry.example.com/nginx:1.25", Deferral{DeferredAt: mirroredAt.Add(3 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := mustOpen(t, path).Get("nginx:1.25", "registry.example.com/nginx:1.25")
+	if entry.Digest != "sha256:newer" || entry.Deferred == nil {
+		t.Errorf("nginx entry %+v, want the newer digest deferred", entry)
+	}
+}
+
+func TestForget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	f := mustOpen(t, path)
+	for _, entry := range []Entry{
+		{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", MirroredAt: mirroredAt},
+		{Source: "docker.io/library/nginx:1.25", Target: "registry.example.com/nginx:1.25-alpine", MirroredAt: mirroredAt},
+		{Source: "nginx:1.25", Target: "registry.example.com/library/nginx:1.25", MirroredAt: mirroredAt},
+	} {
+		if err := f.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Forget("REGISTRY.example.com/nginx:1.25"); err != nil {
+		t.Fatal(err)
+	}
+	entries := mustOpen(t, path).Entries()
+	if len(entries) != 2 || entries[0].Target == "registry.example.com/nginx:1.25" || entries[1].Target == "registry.example.com/nginx:1.25" {
+		t.Errorf("entries %+v, want those of the other targets", entries)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	if f, err := Open(filepath.Join(dir, "missing.json")); err != nil || len(f.Entries()) != 0 {
+		t.Errorf("Open of a missing file = %v, %v, want an empty state", f, err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(corrupt); err == nil {
+		t.Error("Open of a corrupt file succeeded")
+	}
+
+	// Without a path the state is kept in memory
+	memory := mustOpen(t, "")
+	if err := memory.Record(Entry{Source: "nginx:1.25", Target: "registry.example.com/nginx:1.25", MirroredAt: mirroredAt}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := memory.Get("nginx:1.25", "registry.example.com/nginx:1.25"); !ok {
+		t.Error("entry missing from the state in memory")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files in the directory, want only the corrupt one", len(files))
+	}
+}
+
+func mustOpen(t *testing.T, path string) *File {
+	t.Helper()
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
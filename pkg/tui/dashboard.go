@@ -0,0 +1,309 @@
+// Package tui draws a full-screen dashboard of a run from the events of the
+// docker client: the tasks and their status, the platforms being
+// transferred, recent errors and totals. Keys pause the run after the
+// current task, skip the current task or abort the run.
+package tui
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Fr000g/ImgMigrate/pkg/eta"
+	"github.com/moby/term"
+)
+
+// Errors the contexts of the dashboard are cancelled with
+var (
+	ErrSkipped = errors.New("task skipped from the dashboard")
+	ErrAborted = errors.New("run aborted from the dashboard")
+)
+
+// Limits of what the dashboard keeps
+const (
+	// maxErrors is how many of the latest errors are kept
+	maxErrors = 50
+	// maxLog is how many log lines are kept, and printed when the
+	// dashboard is stopped
+	maxLog = 10000
+	// refresh is how often the screen is redrawn
+	refresh = 250 * time.Millisecond
+)
+
+// Escape sequences switching to the alternate screen and back
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+// Dashboard draws the state of a run on the alternate screen of a terminal
+// and reads the keys controlling it. It receives the events of the docker
+// client as an EventHandler, and log output as an io.Writer.
+type Dashboard struct {
+	in    *os.File
+	out   *os.File
+	runID string
+	state *term.State
+
+	mu      sync.Mutex
+	started time.Time
+	tasks   []taskRow
+	current int
+	active  []*transfer
+	errors  []string
+	log     []string
+	dropped int
+	partial []byte
+	totals  totals
+	paused  bool
+	waiting string
+	resume  chan struct{}
+	skip    context.CancelCauseFunc
+	stopped bool
+
+	// estimator, if set, estimates the time the run has left
+	estimator *eta.Estimator
+
+	abortCtx context.Context
+	abort    context.CancelCauseFunc
+	done     chan struct{}
+}
+
+// Start switches the terminal of out to the dashboard and puts in, which
+// must be a terminal, in raw mode to read keys from it
+func Start(in, out *os.File, runID string) (*Dashboard, error) {
+	state, err := term.MakeRaw(in.Fd())
+	if err != nil {
+		return nil, err
+	}
+	d := &Dashboard{
+		in:      in,
+		out:     out,
+		runID:   runID,
+		state:   state,
+		started: time.Now(),
+		current: -1,
+		done:    make(chan struct{}),
+	}
+	d.abortCtx, d.abort = context.WithCancelCause(context.Background())
+	io.WriteString(out, enterScreen)
+
+	go d.readKeys()
+	go d.redrawLoop()
+	return d, nil
+}
+
+// Stop restores the terminal and prints the log lines kept during the run,
+// so they stay in the scrollback. Later writes go straight to the terminal.
+func (d *Dashboard) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	d.stopped = true
+	close(d.done)
+
+	io.WriteString(d.out, leaveScreen)
+	term.RestoreTerminal(d.in.Fd(), d.state)
+	if d.dropped > 0 {
+		io.WriteString(d.out, "... "+strconv.Itoa(d.dropped)+" earlier log lines not shown\n")
+	}
+	for _, line := range d.log {
+		io.WriteString(d.out, line+"\n")
+	}
+	if len(d.partial) > 0 {
+		d.out.Write(d.partial)
+		d.partial = nil
+	}
+}
+
+// SetEstimator shows the time the current task and the run have left, as
+// estimated by e
+func (d *Dashboard) SetEstimator(e *eta.Estimator) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.estimator = e
+}
+
+// SetWaiting shows what the run waits for, such as a transfer window to
+// open, in the header; an empty reason clears it
+func (d *Dashboard) SetWaiting(reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.waiting = reason
+}
+
+// Write keeps the complete lines of p for the log panel. Incomplete lines
+// are buffered until their newline arrives.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return d.out.Write(p)
+	}
+
+	d.partial = append(d.partial, p...)
+	for {
+		end := bytes.IndexByte(d.partial, '\n')
+		if end < 0 {
+			break
+		}
+		d.addLog(string(d.partial[:end]))
+		d.partial = d.partial[end+1:]
+	}
+	d.partial = append([]byte(nil), d.partial...)
+	return len(p), nil
+}
+
+// addLog keeps a log line, dropping the oldest beyond maxLog
+func (d *Dashboard) addLog(line string) {
+	if len(d.log) == maxLog {
+		d.log = d.log[1:]
+		d.dropped++
+	}
+	d.log = append(d.log, line)
+}
+
+// SetTasks lists the tasks of a run, all pending, by their labels
+func (d *Dashboard) SetTasks(labels []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tasks = make([]taskRow, len(labels))
+	for i, label := range labels {
+		d.tasks[i] = taskRow{label: label, status: statusPending}
+	}
+	d.current = -1
+	d.active = nil
+	d.totals = totals{}
+}
+
+// Attach returns a context of ctx that is cancelled with ErrAborted when
+// the run is aborted from the dashboard
+func (d *Dashboard) Attach(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(d.abortCtx, func() { cancel(ErrAborted) })
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// TaskContext marks task index as the current task and returns a context
+// of ctx that is cancelled with ErrSkipped when it is skipped from the
+// dashboard. Pending tasks before it were skipped by the run. The returned
+// function must be called once the task finished.
+func (d *Dashboard) TaskContext(ctx context.Context, index int) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := max(d.current, 0); i < index && i < len(d.tasks); i++ {
+		if d.tasks[i].status == statusPending {
+			d.tasks[i].status = statusSkipped
+		}
+	}
+	d.current = index
+	if index < len(d.tasks) {
+		d.tasks[index].status = statusRunning
+	}
+	d.skip = cancel
+
+	return ctx, func() {
+		d.mu.Lock()
+		d.skip = nil
+		d.mu.Unlock()
+		cancel(context.Canceled)
+	}
+}
+
+// Skipped reports whether the task of ctx was skipped from the dashboard
+func Skipped(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrSkipped)
+}
+
+// WaitWhilePaused blocks while the run is paused from the dashboard, until
+// it is resumed or ctx ends
+func (d *Dashboard) WaitWhilePaused(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.paused {
+		d.mu.Unlock()
+		return nil
+	}
+	resume := d.resume
+	d.mu.Unlock()
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readKeys handles the keys typed until the dashboard is stopped: p pauses
+// or resumes, s skips the current task, and q or Ctrl-C aborts the run
+func (d *Dashboard) readKeys() {
+	buf := make([]byte, 1)
+	for {
+		if _, err := d.in.Read(buf); err != nil {
+			return
+		}
+		d.mu.Lock()
+		if d.stopped {
+			d.mu.Unlock()
+			return
+		}
+		switch buf[0] {
+		case 'p', 'P':
+			d.togglePause()
+		case 's', 'S':
+			if d.skip != nil {
+				d.skip(ErrSkipped)
+				d.addLog("Skipping the current task")
+			}
+		case 'q', 'Q', 0x03:
+			if d.abortCtx.Err() == nil {
+				d.abort(ErrAborted)
+				d.addLog("Aborting the run")
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// togglePause pauses the run after the current task, or resumes it
+func (d *Dashboard) togglePause() {
+	if d.paused {
+		d.paused = false
+		close(d.resume)
+		d.addLog("Resuming the run")
+		return
+	}
+	d.paused = true
+	d.resume = make(chan struct{})
+	d.addLog("Pausing the run after the current task")
+}
+
+// redrawLoop redraws the screen until the dashboard is stopped
+func (d *Dashboard) redrawLoop() {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		if !d.stopped {
+			d.draw()
+		}
+		d.mu.Unlock()
+	}
+}
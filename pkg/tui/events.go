@@ -0,0 +1,135 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+)
+
+// Task statuses shown besides those of pkg/report
+const (
+	statusPending = "pending"
+	statusRunning = "running"
+	statusSkipped = "skipped"
+)
+
+// taskRow is a task of the run and its status
+type taskRow struct {
+	label  string
+	status string
+}
+
+// transfer is a platform being pulled, saved or pushed
+type transfer struct {
+	key     string
+	stage   string
+	current int64
+	total   int64
+}
+
+// totals sums the platforms the run completed and the bytes it moved
+type totals struct {
+	done   int
+	failed int
+	saved  int64
+	pushed int64
+}
+
+// HandleEvent updates the dashboard from an event of the docker client
+func (d *Dashboard) HandleEvent(e docker.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch e := e.(type) {
+	case docker.PlatformPullProgress:
+		d.transfer(e.Source, e.Platform).set("pull", e.Bytes, e.Total)
+	case docker.PlatformSaveProgress:
+		d.transfer(e.Source, e.Platform).set("save", e.Bytes, e.Total)
+	case docker.PlatformPushProgress:
+		d.transfer(e.Source, e.Platform).set("push", e.Bytes, e.Total)
+	case docker.PlatformSaved:
+		d.remove(e.Source, e.Platform)
+		d.totals.done++
+		d.totals.saved += e.Size
+	case docker.PlatformPushed:
+		if t := d.remove(e.Source, e.Platform); t != nil && t.stage == "push" {
+			d.totals.pushed += t.current
+		}
+		d.totals.done++
+	case docker.PlatformFailed:
+		d.remove(e.Source, e.Platform)
+		d.totals.failed++
+		d.addError(fmt.Sprintf("%s %s: %s failed: %v", e.Source, e.Platform, e.Stage, e.Err))
+	case docker.TaskCompleted:
+		if d.current < 0 || d.current >= len(d.tasks) {
+			return
+		}
+		row := &d.tasks[d.current]
+		if row.status == statusRunning {
+			row.status = report.Status(e.Result, e.Err)
+		}
+		if e.Err != nil {
+			d.addError(fmt.Sprintf("%s: %v", row.label, e.Err))
+		}
+	}
+}
+
+// MarkSkipped shows the current task as skipped, once it ended after it
+// was skipped from the dashboard
+func (d *Dashboard) MarkSkipped() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.current >= 0 && d.current < len(d.tasks) {
+		d.tasks[d.current].status = statusSkipped
+	}
+}
+
+// MarkDeferred shows task index as deferred by the transfer budget before
+// it started
+func (d *Dashboard) MarkDeferred(index int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if index < len(d.tasks) {
+		d.tasks[index].status = report.StatusDeferred
+	}
+}
+
+// transfer returns the transfer of a platform, adding it when it is new
+func (d *Dashboard) transfer(source, platform string) *transfer {
+	key := source + " " + platform
+	for _, t := range d.active {
+		if t.key == key {
+			return t
+		}
+	}
+	t := &transfer{key: key}
+	d.active = append(d.active, t)
+	return t
+}
+
+// remove drops the transfer of a platform and returns it, if there was one
+func (d *Dashboard) remove(source, platform string) *transfer {
+	key := source + " " + platform
+	for i, t := range d.active {
+		if t.key == key {
+			d.active = append(d.active[:i], d.active[i+1:]...)
+			return t
+		}
+	}
+	return nil
+}
+
+// addError keeps an error, dropping the oldest beyond maxErrors
+func (d *Dashboard) addError(msg string) {
+	if len(d.errors) == maxErrors {
+		d.errors = d.errors[1:]
+	}
+	d.errors = append(d.errors, msg)
+}
+
+func (t *transfer) set(stage string, current, total int64) {
+	t.stage = stage
+	t.current = current
+	t.total = total
+}
@@ -0,0 +1,144 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Fr000g/ImgMigrate/pkg/eta"
+	"github.com/Fr000g/ImgMigrate/pkg/progress"
+	"github.com/Fr000g/ImgMigrate/pkg/report"
+	"github.com/moby/term"
+)
+
+// Most lines each panel takes on screen
+const (
+	maxActiveLines = 8
+	maxErrorLines  = 5
+)
+
+// barWidth is the number of cells of the bar of a transfer
+const barWidth = 20
+
+// draw redraws the whole screen in place. The terminal is in raw mode, so
+// lines end with an explicit carriage return.
+func (d *Dashboard) draw() {
+	width, height := 80, 24
+	if size, err := term.GetWinsize(d.out.Fd()); err == nil && size.Width > 0 && size.Height > 0 {
+		width, height = int(size.Width), int(size.Height)
+	}
+
+	header := fmt.Sprintf("imgMigrate run %s, elapsed %s", d.runID, time.Since(d.started).Round(time.Second))
+	if d.estimator != nil {
+		if estimate := d.estimator.Estimate(); estimate.Known {
+			header += fmt.Sprintf(", task %s left, run %s left", eta.Format(estimate.TaskRemaining), eta.Format(estimate.RunRemaining))
+		}
+	}
+	switch {
+	case d.abortCtx.Err() != nil:
+		header += "  ABORTING"
+	case d.paused:
+		header += "  PAUSED after the current task"
+	case d.waiting != "":
+		header += "  WAITING " + d.waiting
+	}
+	lines := []string{header, "p pause/resume   s skip task   q abort", ""}
+
+	lines = append(lines, d.taskSummary())
+	lines = append(lines, d.taskLines(max(3, height/3))...)
+
+	lines = append(lines, "", fmt.Sprintf("Active platforms (%d)", len(d.active)))
+	for i, t := range d.active {
+		if i == maxActiveLines {
+			lines = append(lines, fmt.Sprintf("  ... %d more", len(d.active)-i))
+			break
+		}
+		lines = append(lines, "  "+t.String())
+	}
+
+	if len(d.errors) > 0 {
+		lines = append(lines, "", fmt.Sprintf("Recent errors (%d)", len(d.errors)))
+		for _, msg := range d.errors[max(0, len(d.errors)-maxErrorLines):] {
+			lines = append(lines, "  "+msg)
+		}
+	}
+
+	footer := fmt.Sprintf("Platforms: %d done, %d failed   Saved: %s   Pushed: %s",
+		d.totals.done, d.totals.failed, progress.FormatBytes(d.totals.saved), progress.FormatBytes(d.totals.pushed))
+
+	// The log fills the rest of the screen with its latest lines
+	if room := height - len(lines) - 3; room > 0 && len(d.log) > 0 {
+		lines = append(lines, "", "Log")
+		lines = append(lines, d.log[max(0, len(d.log)-room):]...)
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:min(len(lines), height-1)], footer)
+
+	var buf strings.Builder
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(truncate(line, width-1))
+		buf.WriteString("\x1b[K")
+	}
+	io.WriteString(d.out, buf.String())
+}
+
+// taskSummary counts the tasks by status
+func (d *Dashboard) taskSummary() string {
+	counts := make(map[string]int)
+	for _, row := range d.tasks {
+		counts[row.status]++
+	}
+	finished := counts[report.StatusSuccess] + counts[report.StatusPartial] + counts[report.StatusFailed] + counts[report.StatusDeferred] + counts[statusSkipped]
+	return fmt.Sprintf("Tasks: %d of %d finished, %d failed, %d partial, %d deferred, %d skipped",
+		finished, len(d.tasks), counts[report.StatusFailed], counts[report.StatusPartial], counts[report.StatusDeferred], counts[statusSkipped])
+}
+
+// taskLines lists up to limit tasks around the current one
+func (d *Dashboard) taskLines(limit int) []string {
+	start := 0
+	if len(d.tasks) > limit {
+		start = min(max(0, d.current-limit/2), len(d.tasks)-limit)
+	}
+	var lines []string
+	for i := start; i < len(d.tasks) && i < start+limit; i++ {
+		marker := " "
+		if i == d.current {
+			marker = ">"
+		}
+		lines = append(lines, fmt.Sprintf("%s %-8s %s", marker, d.tasks[i].status, d.tasks[i].label))
+	}
+	return lines
+}
+
+// String renders the transfer as a single line
+func (t *transfer) String() string {
+	filled, percent := 0, 0
+	if t.total > 0 {
+		current := min(t.current, t.total)
+		filled = int(current * barWidth / t.total)
+		percent = int(current * 100 / t.total)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	amount := progress.FormatBytes(t.current)
+	if t.total > 0 {
+		amount += " / " + progress.FormatBytes(t.total)
+	}
+	return fmt.Sprintf("%-4s [%s] %3d%% %s  %s", t.stage, bar, percent, amount, t.key)
+}
+
+// truncate shortens s to at most width characters
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max(0, width)])
+}
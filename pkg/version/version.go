@@ -0,0 +1,50 @@
+// Package version holds the build metadata of the binary. The variables are
+// set at build time with -ldflags, for example:
+//
+//	go build -ldflags "-X github.com/Fr000g/ImgMigrate/pkg/version.Version=1.2.0 \
+//	  -X github.com/Fr000g/ImgMigrate/pkg/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/Fr000g/ImgMigrate/pkg/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, overridden with -ldflags
+var (
+	Version = "dev"
+	Commit  = "dev"
+	Date    = "dev"
+)
+
+// Info is the build metadata of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build metadata. When the commit wasn't injected, the
+// revision recorded by the Go toolchain is used if available.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+
+	if info.Commit == "dev" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				if setting.Key == "vcs.revision" && setting.Value != "" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+// UserAgent returns the User-Agent sent with requests made by the tool
+func UserAgent() string {
+	return "imgMigrate/" + Version
+}
@@ -0,0 +1,107 @@
+// Package window limits transfers to a daily time window, such as the hours
+// a network allows bulk transfers in. A window may span midnight.
+package window
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily span of time, from Start up to End, in a time zone
+type Window struct {
+	// start and end are minutes after midnight
+	start, end int
+	loc        *time.Location
+}
+
+// Parse parses a window such as "01:00-05:00", in local time, or
+// "22:00-04:00 Europe/Berlin" in an IANA time zone
+func Parse(s string) (*Window, error) {
+	span, zone, _ := strings.Cut(strings.TrimSpace(s), " ")
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid window %q, use a span such as 01:00-05:00 with an optional time zone", s)
+	}
+	w := &Window{loc: time.Local}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return nil, fmt.Errorf("invalid start of window %q: %v", s, err)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return nil, fmt.Errorf("invalid end of window %q: %v", s, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("window %q is empty, its start and end are the same", s)
+	}
+	if zone = strings.TrimSpace(zone); zone != "" {
+		if w.loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("invalid time zone of window %q: %v", s, err)
+		}
+	}
+	return w, nil
+}
+
+// parseClock parses a time of day in HH:MM form as minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day in HH:MM form", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Open reports whether the window is open at t
+func (w *Window) Open(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// NextOpen returns when the window opens next after t, or t itself if the
+// window is open then
+func (w *Window) NextOpen(t time.Time) time.Time {
+	if w.Open(t) {
+		return t
+	}
+	local := t.In(w.loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), w.start/60, w.start%60, 0, 0, w.loc)
+	if !next.After(t) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, w.start/60, w.start%60, 0, 0, w.loc)
+	}
+	return next
+}
+
+// Wait blocks until the window is open or ctx ends. It returns when the
+// window opened, which is now if it was already open.
+func (w *Window) Wait(ctx context.Context) (time.Time, error) {
+	for {
+		now := time.Now()
+		next := w.NextOpen(now)
+		if !next.After(now) {
+			return now, nil
+		}
+		// Wake up at least every minute, so a suspended machine or a clock
+		// change doesn't oversleep the opening
+		timer := time.NewTimer(min(next.Sub(now), time.Minute))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return now, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// String formats the window as it is parsed
+func (w *Window) String() string {
+	s := fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+	if w.loc != time.Local {
+		s += " " + w.loc.String()
+	}
+	return s
+}
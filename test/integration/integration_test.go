@@ -0,0 +1,120 @@
+//go:build integration
+
+// The integration tests migrate a fixture pushed to a throwaway registry:2
+// container and check what was pushed through the registry API:
+//
+//	go test -tags integration ./test/integration
+//
+// They need a docker daemon that may run containers, and are skipped
+// without one.
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Fr000g/ImgMigrate/pkg/docker"
+	"github.com/Fr000g/ImgMigrate/pkg/integration"
+)
+
+var (
+	setupOnce sync.Once
+	env       *integration.Env
+	setupErr  error
+)
+
+// setup starts the registry and pushes the fixture for the first test that
+// needs them, skipping the tests when there is no daemon or registry
+func setup(t *testing.T) *integration.Env {
+	t.Helper()
+	setupOnce.Do(func() {
+		client, err := docker.NewClient()
+		if err != nil {
+			setupErr = fmt.Errorf("%w: %v", integration.ErrNoDaemon, err)
+			return
+		}
+		env, setupErr = integration.Setup(context.Background(), client)
+	})
+	if errors.Is(setupErr, integration.ErrNoDaemon) || errors.Is(setupErr, integration.ErrNoRegistry) {
+		t.Skip(setupErr)
+	}
+	if setupErr != nil {
+		t.Fatal(setupErr)
+	}
+	return env
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if env != nil {
+		env.Registry.Close()
+	}
+	os.Exit(code)
+}
+
+// TestPushSpecificArchitectures pushes two platforms of the fixture with a
+// manifest list
+func TestPushSpecificArchitectures(t *testing.T) {
+	env := setup(t)
+	ctx := t.Context()
+	result, err := env.Docker.ProcessImageTask(ctx, docker.TaskSpec{
+		Source:        env.Source(),
+		Target:        env.Registry.Image("specific", "v1"),
+		Architectures: []string{"amd64", "arm64"},
+		Auth:          env.Auth(),
+		Options:       docker.SaveOptions{CreateMultiArch: true},
+	})
+	if err := integration.CheckResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if err := integration.AssertPlatforms(ctx, env.API, "specific", "v1-allarch", []string{"linux/amd64", "linux/arm64"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPushAllArchitectures pushes every platform of the fixture with a
+// manifest list
+func TestPushAllArchitectures(t *testing.T) {
+	env := setup(t)
+	ctx := t.Context()
+	result, err := env.Docker.ProcessImageTask(ctx, docker.TaskSpec{
+		Source:           env.Source(),
+		Target:           env.Registry.Image("all", "v1"),
+		AllArchitectures: true,
+		Auth:             env.Auth(),
+		Options:          docker.SaveOptions{CreateMultiArch: true},
+	})
+	if err := integration.CheckResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if err := integration.AssertPlatforms(ctx, env.API, "all", "v1-allarch", integration.FixturePlatforms); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestVerbatimCopy copies the fixture through the registry API, which
+// keeps the digest of its manifest list
+func TestVerbatimCopy(t *testing.T) {
+	env := setup(t)
+	ctx := t.Context()
+	result, err := env.Docker.ProcessImageTask(ctx, docker.TaskSpec{
+		Source:           env.Source(),
+		Target:           env.Registry.Image("verbatim", "v1"),
+		AllArchitectures: true,
+		Auth:             env.Auth(),
+		Options:          docker.SaveOptions{Verbatim: true},
+	})
+	if err := integration.CheckResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if err := integration.AssertPlatforms(ctx, env.API, "verbatim", "v1", integration.FixturePlatforms); err != nil {
+		t.Error(err)
+	}
+	if err := integration.AssertDigest(ctx, env.API, "verbatim", "v1", env.Fixture.Digest); err != nil {
+		t.Error(err)
+	}
+}